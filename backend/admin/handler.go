@@ -0,0 +1,438 @@
+// Package admin is the back office API: user search, subscriptions and quotas, manual
+// grants and the audit trail. Every route requires an admin role (login.RequireAdmin) and
+// every change is written to the audit trail. Plan CRUD lives in package subscriptions
+// behind the same guard.
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ema-backend/audit"
+	"ema-backend/login"
+	"ema-backend/migrations"
+	"ema-backend/subscriptions"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var quotaFields = []string{"consultations", "questionnaires", "clinical_cases", "files"}
+
+type Handler struct {
+	subs  *subscriptions.Repository
+	users migrations.UserStore
+}
+
+func NewHandler(subs *subscriptions.Repository, users migrations.UserStore) *Handler {
+	return &Handler{subs: subs, users: users}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.Engine) {
+	// UI shell only: it asks for an admin token and sends it on every call
+	r.GET("/admin", func(c *gin.Context) {
+		data, err := os.ReadFile("admin/admin.html")
+		if err != nil {
+			c.String(http.StatusInternalServerError, "admin ui not found")
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
+	})
+
+	g := r.Group("/admin", login.RequireAdmin(h.users), audit.Middleware())
+	g.GET("/me", h.me)
+	g.GET("/users", h.searchUsers)
+	g.GET("/users/:id", h.getUser)
+	g.PUT("/users/:id/role", login.RequireRole(h.users, login.RoleSuperAdmin), h.setUserRole)
+	g.POST("/users/:id/grants", h.grant)
+	g.GET("/subscriptions", h.listSubscriptions)
+	g.PUT("/subscriptions/:id", h.updateSubscription)
+	g.POST("/subscriptions/:id/reset-quotas", h.resetQuotas)
+	g.GET("/audit", h.getAudit)
+}
+
+// page reads ?page= (1-based) and ?page_size= into limit/offset.
+func page(c *gin.Context) (pageNum, limit, offset int) {
+	pageNum, _ = strconv.Atoi(c.Query("page"))
+	if pageNum < 1 {
+		pageNum = 1
+	}
+	limit, _ = strconv.Atoi(c.Query("page_size"))
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+	return pageNum, limit, (pageNum - 1) * limit
+}
+
+// userJSON is the admin view of a user (never the password).
+func userJSON(u *migrations.User) gin.H {
+	return gin.H{"id": u.ID, "first_name": u.FirstName, "last_name": u.LastName, "email": u.Email, "role": u.Role,
+		"city": u.City, "profession": u.Profession, "country_id": u.CountryID, "created_at": u.CreatedAt}
+}
+
+// me handles GET /admin/me, used by the UI to check the token.
+func (h *Handler) me(c *gin.Context) {
+	c.JSON(http.StatusOK, userJSON(login.AdminUser(c)))
+}
+
+// searchUsers handles GET /admin/users?q=&page=&page_size=
+func (h *Handler) searchUsers(c *gin.Context) {
+	p, limit, offset := page(c)
+	users, total, err := migrations.SearchUsers(c.Query("q"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out := make([]gin.H, 0, len(users))
+	for i := range users {
+		u := userJSON(&users[i])
+		if sub, err := h.subs.GetActiveSubscription(users[i].ID); err == nil && sub != nil && sub.Plan != nil {
+			u["plan"] = sub.Plan.Name
+			u["subscription_id"] = sub.ID
+		}
+		out = append(out, u)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out, "page": p, "page_size": limit, "total": total})
+}
+
+// getUser handles GET /admin/users/:id: profile, every subscription (active one flagged),
+// quota anomalies, recent payments and the audit entries about the user.
+func (h *Handler) getUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	u := migrations.GetUserByID(id)
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+		return
+	}
+	subs, err := h.subs.GetSubscriptions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	active, err := h.subs.GetActiveSubscription(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	payments, _, _ := h.subs.GetUserPayments(id, 10, 0)
+	trail, _, _ := audit.List(audit.Filter{TargetType: "users", TargetID: strconv.Itoa(id)}, 20, 0)
+	resp := gin.H{"user": userJSON(u), "subscriptions": subs, "active_subscription": nil, "anomalies": []string{}, "payments": payments, "audit": trail}
+	if active != nil {
+		resp["active_subscription"] = active
+		resp["anomalies"] = quotaAnomalies(active)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// quotaAnomalies flags remaining quotas that don't match the pinned plan limits.
+func quotaAnomalies(sub *subscriptions.Subscription) []string {
+	out := []string{}
+	if sub.Plan == nil {
+		return append(out, "subscription_without_plan")
+	}
+	p := sub.Plan
+	if sub.Consultations == 0 && sub.Questionnaires == 0 && sub.ClinicalCases == 0 &&
+		(p.Consultations > 0 || p.Questionnaires > 0 || p.ClinicalCases > 0) {
+		out = append(out, "active_subscription_zero_remaining_vs_plan_limits")
+	}
+	remaining := map[string]int{"consultations": sub.Consultations, "questionnaires": sub.Questionnaires, "clinical_cases": sub.ClinicalCases, "files": sub.Files}
+	limits := map[string]int{"consultations": p.Consultations, "questionnaires": p.Questionnaires, "clinical_cases": p.ClinicalCases, "files": p.Files}
+	for _, f := range quotaFields {
+		if remaining[f] > limits[f] {
+			out = append(out, f+"_remaining_exceeds_plan_limit")
+		}
+	}
+	return out
+}
+
+// setUserRole handles PUT /admin/users/:id/role { role, reason } (super_admin only).
+func (h *Handler) setUserRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	var body struct {
+		Role   string `json:"role"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(body.Role))
+	if role != login.RoleUser && role != login.RoleAdmin && role != login.RoleSuperAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rol inválido (user | admin | super_admin)"})
+		return
+	}
+	if strings.TrimSpace(body.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason requerido"})
+		return
+	}
+	if self := login.AdminUser(c); self != nil && self.ID == id && role != login.RoleSuperAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no puedes quitarte tu propio rol"})
+		return
+	}
+	u := migrations.GetUserByID(id)
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+		return
+	}
+	if err := migrations.UpdateUserRole(id, role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_ = audit.Record(c, "user.role", "users", strconv.Itoa(id), body.Reason, gin.H{"from": u.Role, "to": role})
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "user_id": id, "role": role})
+}
+
+// grantRequest is the body of POST /admin/users/:id/grants: a plan for some days and/or extra
+// quota on the active subscription. reason is mandatory.
+type grantRequest struct {
+	PlanID int            `json:"plan_id"`
+	Days   int            `json:"days"` // 0 = no end date
+	Quotas map[string]int `json:"quotas"`
+	Reason string         `json:"reason"`
+}
+
+func (g *grantRequest) validate() error {
+	if strings.TrimSpace(g.Reason) == "" {
+		return fmt.Errorf("reason requerido")
+	}
+	if g.PlanID == 0 && len(g.Quotas) == 0 {
+		return fmt.Errorf("plan_id o quotas requerido")
+	}
+	if g.Days < 0 {
+		return fmt.Errorf("days inválido")
+	}
+	for k, v := range g.Quotas {
+		if !isQuotaField(k) {
+			return fmt.Errorf("campo de cuota inválido: %s", k)
+		}
+		if v == 0 {
+			return fmt.Errorf("cantidad inválida para %s", k)
+		}
+	}
+	return nil
+}
+
+func isQuotaField(f string) bool {
+	for _, q := range quotaFields {
+		if q == f {
+			return true
+		}
+	}
+	return false
+}
+
+// grant handles POST /admin/users/:id/grants. A plan grant creates a local subscription
+// (payment_gateway=manual, never charged) that takes precedence over the current one while
+// it lasts; a quota grant adds (or, when negative, removes) units on the active subscription.
+func (h *Handler) grant(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	var body grantRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	if err := body.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if migrations.GetUserByID(id) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+		return
+	}
+	resp := gin.H{"status": "ok", "user_id": id}
+	if body.PlanID != 0 {
+		plan, err := h.subs.GetPlanByID(body.PlanID)
+		if err != nil || plan == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "plan no encontrado"})
+			return
+		}
+		now := time.Now()
+		sub := &subscriptions.Subscription{UserID: id, PlanID: plan.ID, StartDate: now, Gateway: subscriptions.GatewayManual}
+		if u := login.AdminUser(c); u != nil {
+			sub.GatewayReference = "admin:" + strconv.Itoa(u.ID)
+		}
+		if body.Days > 0 {
+			end := now.AddDate(0, 0, body.Days)
+			sub.EndDate = &end
+		}
+		if err := h.subs.CreateSubscription(sub); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp["subscription_id"] = sub.ID
+		resp["end_date"] = sub.EndDate
+	}
+	if len(body.Quotas) > 0 {
+		sub, err := h.subs.GetActiveSubscription(id)
+		if err != nil || sub == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "suscripción no encontrada"})
+			return
+		}
+		for _, f := range quotaFields {
+			if n, ok := body.Quotas[f]; ok {
+				if err := h.subs.AddQuota(sub.ID, f, n); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+		resp["subscription_id"] = sub.ID
+	}
+	_ = audit.Record(c, "user.grant", "users", strconv.Itoa(id), body.Reason, body)
+	c.JSON(http.StatusOK, resp)
+}
+
+// listSubscriptions handles GET /admin/subscriptions?user=
+func (h *Handler) listSubscriptions(c *gin.Context) {
+	uid, _ := strconv.Atoi(c.Query("user"))
+	subs, err := h.subs.GetSubscriptions(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": subs})
+}
+
+// subscriptionUpdate is the body of PUT /admin/subscriptions/:id; nil fields are left alone.
+type subscriptionUpdate struct {
+	Status         *string    `json:"status"`
+	EndDate        *time.Time `json:"end_date"`
+	ClearEndDate   bool       `json:"clear_end_date"`
+	Consultations  *int       `json:"consultations"`
+	Questionnaires *int       `json:"questionnaires"`
+	ClinicalCases  *int       `json:"clinical_cases"`
+	Files          *int       `json:"files"`
+	Reason         string     `json:"reason"`
+}
+
+func (u *subscriptionUpdate) quotas() map[string]*int {
+	return map[string]*int{"consultations": u.Consultations, "questionnaires": u.Questionnaires, "clinical_cases": u.ClinicalCases, "files": u.Files}
+}
+
+// updateSubscription handles PUT /admin/subscriptions/:id: status, end date and remaining
+// quotas. reason is mandatory.
+func (h *Handler) updateSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	var body subscriptionUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	if strings.TrimSpace(body.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason requerido"})
+		return
+	}
+	sub, err := h.subs.GetSubscriptionByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if sub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "suscripción no encontrada"})
+		return
+	}
+	if body.Status != nil || body.EndDate != nil || body.ClearEndDate {
+		status, end := sub.Status, sub.EndDate
+		if body.Status != nil {
+			switch *body.Status {
+			case subscriptions.StatusActive, subscriptions.StatusPastDue, subscriptions.StatusCanceled, subscriptions.StatusExpired:
+				status = *body.Status
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status inválido"})
+				return
+			}
+		}
+		if body.ClearEndDate {
+			end = nil
+		} else if body.EndDate != nil {
+			end = body.EndDate
+		}
+		if err := h.subs.SetSubscriptionAccess(id, status, end); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	for _, f := range quotaFields {
+		if v := body.quotas()[f]; v != nil {
+			if *v < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "valor inválido para " + f})
+				return
+			}
+			if err := h.subs.SetQuotaValue(id, f, *v); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+	fresh, _ := h.subs.GetSubscriptionByID(id)
+	_ = audit.Record(c, "subscription.update", "users", strconv.Itoa(sub.UserID), body.Reason, gin.H{"subscription_id": id, "changes": body})
+	c.JSON(http.StatusOK, fresh)
+}
+
+// resetQuotas handles POST /admin/subscriptions/:id/reset-quotas { reason }: remaining quotas
+// back to the limits of the pinned plan version.
+func (h *Handler) resetQuotas(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason requerido"})
+		return
+	}
+	sub, err := h.subs.GetSubscriptionByID(id)
+	if err != nil || sub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "suscripción no encontrada"})
+		return
+	}
+	if err := h.subs.ResetSubscriptionQuotasToPlan(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fresh, _ := h.subs.GetSubscriptionByID(id)
+	_ = audit.Record(c, "subscription.reset_quotas", "users", strconv.Itoa(sub.UserID), body.Reason,
+		gin.H{"subscription_id": id, "before": gin.H{"consultations": sub.Consultations, "questionnaires": sub.Questionnaires, "clinical_cases": sub.ClinicalCases, "files": sub.Files}})
+	c.JSON(http.StatusOK, fresh)
+}
+
+// getAudit handles GET /admin/audit?admin_id=&action=&target_type=&target_id=&page=&page_size=
+func (h *Handler) getAudit(c *gin.Context) {
+	p, limit, offset := page(c)
+	adminID, _ := strconv.Atoi(c.Query("admin_id"))
+	f := audit.Filter{AdminUserID: adminID, Action: c.Query("action"), TargetType: c.Query("target_type"), TargetID: c.Query("target_id")}
+	entries, total, err := audit.List(f, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": entries, "page": p, "page_size": limit, "total": total})
+}
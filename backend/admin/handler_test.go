@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"ema-backend/migrations"
+	"ema-backend/subscriptions"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQuotaAnomalies(t *testing.T) {
+	plan := &subscriptions.Plan{Consultations: 30, Questionnaires: 50, ClinicalCases: 25, Files: 100}
+	sub := &subscriptions.Subscription{Files: 5, Plan: plan}
+	if got := quotaAnomalies(sub); !reflect.DeepEqual(got, []string{"active_subscription_zero_remaining_vs_plan_limits"}) {
+		t.Errorf("zero remaining: got %v", got)
+	}
+	sub = &subscriptions.Subscription{Consultations: 40, Questionnaires: 10, ClinicalCases: 1, Files: 100, Plan: plan}
+	if got := quotaAnomalies(sub); !reflect.DeepEqual(got, []string{"consultations_remaining_exceeds_plan_limit"}) {
+		t.Errorf("exceeds: got %v", got)
+	}
+	if got := quotaAnomalies(&subscriptions.Subscription{}); len(got) != 1 {
+		t.Errorf("no plan: got %v", got)
+	}
+}
+
+func TestGrantRequestValidate(t *testing.T) {
+	valid := []grantRequest{
+		{PlanID: 2, Days: 30, Reason: "soporte #123"},
+		{Quotas: map[string]int{"consultations": 10, "files": -2}, Reason: "compensación"},
+	}
+	for _, g := range valid {
+		if err := g.validate(); err != nil {
+			t.Errorf("%+v: %v", g, err)
+		}
+	}
+	invalid := []grantRequest{
+		{PlanID: 2},
+		{Reason: "sin nada"},
+		{PlanID: 2, Days: -1, Reason: "x"},
+		{Quotas: map[string]int{"statistics": 1}, Reason: "x"},
+		{Quotas: map[string]int{"files": 0}, Reason: "x"},
+	}
+	for _, g := range invalid {
+		if err := g.validate(); err == nil {
+			t.Errorf("%+v accepted", g)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/admin/users?page=3&page_size=500", nil)
+	if p, limit, offset := page(c); p != 3 || limit != maxPageSize || offset != 200 {
+		t.Errorf("got page=%d limit=%d offset=%d", p, limit, offset)
+	}
+}
+
+func TestRoutesRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewHandler(nil, migrations.NewMemoryStore()).RegisterRoutes(r)
+	for _, path := range []string{"/admin/users", "/admin/audit", "/admin/subscriptions"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without token: got %d", path, w.Code)
+		}
+	}
+}
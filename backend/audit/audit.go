@@ -0,0 +1,175 @@
+// Package audit keeps the trail of admin actions (admin_audit_log): who changed what, when,
+// from where and why.
+package audit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ema-backend/login"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDetailsBytes caps the request body kept as details.
+const maxDetailsBytes = 64 << 10
+
+const recordedKey = "audit_recorded"
+
+var db *sql.DB
+
+// Init sets the DB connection used to write and read the trail.
+func Init(database *sql.DB) { db = database }
+
+// Entry is one admin action.
+type Entry struct {
+	ID          int             `json:"id"`
+	AdminUserID int             `json:"admin_user_id"`
+	AdminEmail  string          `json:"admin_email"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type"`
+	TargetID    string          `json:"target_id"`
+	Reason      string          `json:"reason,omitempty"`
+	Details     json.RawMessage `json:"details,omitempty"`
+	IP          string          `json:"ip,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Record writes an entry for the admin authenticated on c (see login.RequireAdmin). details
+// is stored as JSON. Requests recorded explicitly are skipped by Middleware.
+func Record(c *gin.Context, action, targetType, targetID, reason string, details interface{}) error {
+	e := Entry{Action: action, TargetType: targetType, TargetID: targetID, Reason: strings.TrimSpace(reason), IP: c.ClientIP()}
+	if u := login.AdminUser(c); u != nil {
+		e.AdminUserID, e.AdminEmail = u.ID, u.Email
+	}
+	if details != nil {
+		if b, err := json.Marshal(details); err == nil {
+			e.Details = b
+		}
+	}
+	c.Set(recordedKey, true)
+	return insert(&e)
+}
+
+func insert(e *Entry) error {
+	if db == nil {
+		log.Printf("[AUDIT] db not initialized, dropping %s %s/%s by %s", e.Action, e.TargetType, e.TargetID, e.AdminEmail)
+		return nil
+	}
+	var details interface{}
+	if len(e.Details) > 0 {
+		details = string(e.Details)
+	}
+	_, err := db.Exec(`INSERT INTO admin_audit_log (admin_user_id, admin_email, action, target_type, target_id, reason, details, ip) VALUES (?,?,?,?,?,NULLIF(?,''),?,NULLIF(?,''))`,
+		e.AdminUserID, e.AdminEmail, e.Action, e.TargetType, e.TargetID, e.Reason, details, e.IP)
+	if err != nil {
+		log.Printf("[AUDIT] ❌ %s %s/%s by %s: %v", e.Action, e.TargetType, e.TargetID, e.AdminEmail, err)
+	}
+	return err
+}
+
+// Middleware records every successful mutating request (POST/PUT/PATCH/DELETE) of the route
+// group it is mounted on: action "METHOD /route/:param", the :id param as target, the JSON
+// body as details and its "reason" field (or X-Admin-Reason) as reason.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxDetailsBytes+1))
+			rest, _ := io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), bytes.NewReader(rest)))
+		}
+		c.Next()
+		if c.Writer.Status() >= http.StatusBadRequest || c.GetBool(recordedKey) {
+			return
+		}
+		path := c.FullPath()
+		e := Entry{Action: c.Request.Method + " " + path, TargetType: targetType(path), TargetID: c.Param("id"), IP: c.ClientIP(),
+			Reason: strings.TrimSpace(c.GetHeader("X-Admin-Reason"))}
+		if u := login.AdminUser(c); u != nil {
+			e.AdminUserID, e.AdminEmail = u.ID, u.Email
+		}
+		if len(body) > 0 && len(body) <= maxDetailsBytes && json.Valid(body) {
+			e.Details = body
+			var withReason struct {
+				Reason string `json:"reason"`
+			}
+			if json.Unmarshal(body, &withReason) == nil && strings.TrimSpace(withReason.Reason) != "" {
+				e.Reason = strings.TrimSpace(withReason.Reason)
+			}
+		}
+		_ = insert(&e)
+	}
+}
+
+// targetType is the resource named by a route: "/admin/users/:id/grants" -> "users".
+func targetType(path string) string {
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		if seg != "" && seg != "admin" && !strings.HasPrefix(seg, ":") {
+			return seg
+		}
+	}
+	return ""
+}
+
+// Filter narrows List; zero values match everything.
+type Filter struct {
+	AdminUserID int
+	Action      string // prefix match, e.g. "PUT /plans"
+	TargetType  string
+	TargetID    string
+}
+
+// List returns a page of entries, newest first, and the total number of matches.
+func List(f Filter, limit, offset int) ([]Entry, int, error) {
+	if db == nil {
+		return []Entry{}, 0, nil
+	}
+	where, args := []string{"1=1"}, []interface{}{}
+	if f.AdminUserID > 0 {
+		where, args = append(where, "admin_user_id=?"), append(args, f.AdminUserID)
+	}
+	if f.Action != "" {
+		where, args = append(where, "action LIKE ?"), append(args, f.Action+"%")
+	}
+	if f.TargetType != "" {
+		where, args = append(where, "target_type=?"), append(args, f.TargetType)
+	}
+	if f.TargetID != "" {
+		where, args = append(where, "target_id=?"), append(args, f.TargetID)
+	}
+	cond := strings.Join(where, " AND ")
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM admin_audit_log WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(`SELECT id, admin_user_id, admin_email, action, target_type, target_id, COALESCE(reason,''), COALESCE(details,''), COALESCE(ip,''), created_at
+		FROM admin_audit_log WHERE `+cond+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	out := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var details string
+		if err := rows.Scan(&e.ID, &e.AdminUserID, &e.AdminEmail, &e.Action, &e.TargetType, &e.TargetID, &e.Reason, &details, &e.IP, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		if details != "" {
+			e.Details = json.RawMessage(details)
+		}
+		out = append(out, e)
+	}
+	return out, total, rows.Err()
+}
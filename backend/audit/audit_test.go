@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTargetType(t *testing.T) {
+	cases := map[string]string{
+		"/admin/users/:id/grants":     "users",
+		"/plans/:id/versions/migrate": "plans",
+		"/admin/subscriptions/:id":    "subscriptions",
+		"/promo-codes/:id":            "promo-codes",
+		"/admin":                      "",
+	}
+	for path, want := range cases {
+		if got := targetType(path); got != want {
+			t.Errorf("%s: got %q want %q", path, got, want)
+		}
+	}
+}
+
+func TestMiddlewareKeepsBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var seen string
+	r.PUT("/plans/:id", Middleware(), func(c *gin.Context) {
+		b, _ := io.ReadAll(c.Request.Body)
+		seen = string(b)
+		c.Status(http.StatusOK)
+	})
+	body := `{"name":"Pro","reason":"precio 2026"}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/plans/3", strings.NewReader(body)))
+	if w.Code != http.StatusOK || seen != body {
+		t.Fatalf("handler saw %q (status %d)", seen, w.Code)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"sync"
 	"time"
 
+	"ema-backend/entitlements"
+	"ema-backend/knowledge"
 	"ema-backend/openai"
 	"ema-backend/sse"
 
@@ -123,6 +125,7 @@ func (h *Handler) GenerateAnalytical(c *gin.Context) {
 		c.Header("X-Quota-Remaining", toString(v))
 		c.Header("X-Quota-Field", "clinical_cases")
 	}
+	entitlements.Attach(c)
 	var req generateReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
@@ -181,6 +184,9 @@ func (h *Handler) GenerateAnalytical(c *gin.Context) {
 
 	ch, err := h.aiAnalytical.StreamAssistantJSONCompatible(ctx, threadID, userPrompt, instr, "")
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant error"})
 		return
 	}
@@ -314,6 +320,7 @@ func (h *Handler) ChatAnalytical(c *gin.Context) {
 		c.Header("X-Quota-Remaining", toString(v))
 		c.Header("X-Quota-Field", "clinical_cases")
 	}
+	entitlements.Attach(c)
 	var req chatReq
 	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Mensaje) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
@@ -478,6 +485,9 @@ INSTRUCCIONES CRÍTICAS:
 			}, " ")
 			ch, err := h.aiAnalytical.StreamAssistantMessageCompatible(ctx, threadID, prompt)
 			if err != nil {
+				if openai.RespondUnavailable(c, err) {
+					return
+				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant error"})
 				return
 			}
@@ -528,6 +538,9 @@ INSTRUCCIONES CRÍTICAS:
 		}, " ")
 		ch, err := h.aiAnalytical.StreamAssistantMessageCompatible(ctx, threadID, prompt)
 		if err != nil {
+			if openai.RespondUnavailable(c, err) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant error"})
 			return
 		}
@@ -561,16 +574,28 @@ INSTRUCCIONES CRÍTICAS:
 	if isEvaluation {
 		ch, err := h.aiAnalytical.StreamAssistantMessageCompatible(ctx, threadID, userPrompt+" \n\nInstrucciones: "+instr)
 		if err != nil {
+			if openai.RespondUnavailable(c, err) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant error"})
 			return
 		}
-		var content string
-		select {
-		case content = <-ch:
-		case <-ctx.Done():
-			c.JSON(http.StatusOK, gin.H{"text": "No pude generar la evaluación a tiempo. Intenta nuevamente.", "thread_id": threadID, "note": "timeout"})
-			return
+		// El texto llega en varios deltas: acumular hasta que el stream termine
+		var sb strings.Builder
+	collect:
+		for {
+			select {
+			case tok, ok := <-ch:
+				if !ok {
+					break collect
+				}
+				sb.WriteString(tok)
+			case <-ctx.Done():
+				c.JSON(http.StatusOK, gin.H{"text": "No pude generar la evaluación a tiempo. Intenta nuevamente.", "thread_id": threadID, "note": "timeout"})
+				return
+			}
 		}
+		content := sb.String()
 		// Para evaluaciones, devolver texto directo (MARKDOWN)
 		c.JSON(http.StatusOK, gin.H{"text": strings.TrimSpace(content), "thread_id": threadID})
 		return
@@ -579,6 +604,9 @@ INSTRUCCIONES CRÍTICAS:
 	// Para mensajes normales: JSON
 	ch, err := h.aiAnalytical.StreamAssistantJSONCompatible(ctx, threadID, userPrompt, instr, "")
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant error"})
 		return
 	}
@@ -807,6 +835,7 @@ func (h *Handler) GenerateInteractive(c *gin.Context) {
 		c.Header("X-Quota-Remaining", toString(v))
 		c.Header("X-Quota-Field", "clinical_cases")
 	}
+	entitlements.Attach(c)
 	var req generateReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
@@ -858,6 +887,9 @@ func (h *Handler) GenerateInteractive(c *gin.Context) {
 	}, " ")
 	ch, err := h.aiInteractive.StreamAssistantJSONCompatible(ctx, threadID, userPrompt, instr, "")
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant error"})
 		return
 	}
@@ -943,6 +975,7 @@ func (h *Handler) ChatInteractive(c *gin.Context) {
 		c.Header("X-Quota-Remaining", toString(v))
 		c.Header("X-Quota-Field", "clinical_cases")
 	}
+	entitlements.Attach(c)
 	var req chatReq
 	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Mensaje) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
@@ -967,6 +1000,9 @@ func (h *Handler) ChatInteractive(c *gin.Context) {
 	}, " ")
 	ch, err := h.aiInteractive.StreamAssistantJSONCompatible(ctx, threadID, userPrompt, instr, "")
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant error"})
 		return
 	}
@@ -1228,21 +1264,11 @@ func isRAGEnabled() bool {
 	return val != "false"
 }
 
-// getFixedBooksVectorID retorna el vector store fijo usado para libros del asistente (si está configurado);
-// si no, usa el ID observado en conversations_ia (mantener sincronizado si cambia).
-func getFixedBooksVectorID() string {
-	if v := strings.TrimSpace(os.Getenv("INTERACTIVE_VECTOR_ID")); v != "" { // permitir override por env
-		return v
-	}
-	// Valor por defecto usado en conversations_ia.SmartMessage
-	return "vs_680fc484cef081918b2b9588b701e2f4"
-}
-
 // collectEvidence busca primero en vector de libros y luego en PubMed; devuelve bloque de referencias o cadena vacía.
 func collectEvidence(ctx context.Context, ai Assistant, query string) string {
-	// 1) Libros (vector fijo) con metadatos si están disponibles
+	// 1) Libros (base de conocimiento de casos clínicos) con metadatos si están disponibles
 	refs := make([]string, 0, 3)
-	vectorID := getFixedBooksVectorID()
+	vectorID := knowledge.VectorStoreFor(knowledge.FlowClinicalCase)
 	if strings.HasPrefix(vectorID, "vs_") {
 		if res, err := ai.SearchInVectorStoreWithMetadata(ctx, vectorID, query); err == nil && res != nil && res.HasResult {
 			// Formato APA simplificado: Autor/Fuente (año). Título/Sección.
@@ -1277,22 +1303,24 @@ func collectEvidence(ctx context.Context, ai Assistant, query string) string {
 			refs = append(refs, "Base de conocimiento médico.")
 		}
 	}
-	// 2) PubMed - formato simplificado
-	if pm, err := ai.SearchPubMed(ctx, query); err == nil && strings.TrimSpace(pm) != "" {
-		// Intentar extraer primer autor y año si está en el formato típico de PubMed
-		p := strings.TrimSpace(pm)
-		// Buscar patrón "Autor et al. (año)" o "Autor (año)"
-		pmRef := "PubMed."
-		if idx := strings.Index(p, "("); idx != -1 && idx < 100 {
-			if idx2 := strings.Index(p[idx:], ")"); idx2 != -1 && idx2 < 10 {
-				author := strings.TrimSpace(p[:idx])
-				year := strings.TrimSpace(p[idx+1 : idx+idx2])
-				if len(year) == 4 && author != "" {
-					pmRef = author + " (" + year + ")."
+	// 2) PubMed - formato simplificado (si el plan lo incluye)
+	if entitlements.Allowed(ctx, entitlements.PubMedSearch) {
+		if pm, err := ai.SearchPubMed(ctx, query); err == nil && strings.TrimSpace(pm) != "" {
+			// Intentar extraer primer autor y año si está en el formato típico de PubMed
+			p := strings.TrimSpace(pm)
+			// Buscar patrón "Autor et al. (año)" o "Autor (año)"
+			pmRef := "PubMed."
+			if idx := strings.Index(p, "("); idx != -1 && idx < 100 {
+				if idx2 := strings.Index(p[idx:], ")"); idx2 != -1 && idx2 < 10 {
+					author := strings.TrimSpace(p[:idx])
+					year := strings.TrimSpace(p[idx+1 : idx+idx2])
+					if len(year) == 4 && author != "" {
+						pmRef = author + " (" + year + ")."
+					}
 				}
 			}
+			refs = append(refs, pmRef)
 		}
-		refs = append(refs, pmRef)
 	}
 	if len(refs) == 0 {
 		return ""
@@ -17,6 +17,7 @@ import (
 	"time"
 	"unicode"
 
+	"ema-backend/knowledge"
 	"ema-backend/openai"
 
 	"github.com/gin-gonic/gin"
@@ -42,7 +43,7 @@ type Handler struct {
 	askedQuestions     map[string][]string // thread_id -> list of question texts already asked (to reduce repetition)
 	evalCorrect        map[string]int      // thread_id -> count correct answers
 	evalAnswers        map[string]int      // thread_id -> total evaluated answers
-	vectorID           string              // knowledge vector id for references
+	vectorID           string              // knowledge vector id override; empty = knowledge registry
 	// local evaluation support
 	lastCorrectIndex   map[string]int      // thread_id -> correct index of last question
 	lastOptions        map[string][]string // thread_id -> slice of option texts of last question
@@ -156,10 +157,6 @@ func DefaultHandler() *Handler {
 			maxQ = v
 		}
 	}
-	vector := strings.TrimSpace(os.Getenv("INTERACTIVE_VECTOR_ID"))
-	if vector == "" {
-		vector = "vs_680fc484cef081918b2b9588b701e2f4"
-	}
 	return &Handler{
 		ai:                 cli,
 		maxQuestions:       maxQ,
@@ -168,7 +165,6 @@ func DefaultHandler() *Handler {
 		askedQuestions:     make(map[string][]string),
 		evalCorrect:        make(map[string]int),
 		evalAnswers:        make(map[string]int),
-		vectorID:           vector,
 		lastCorrectIndex:   make(map[string]int),
 		lastOptions:        make(map[string][]string),
 		lastQuestionText:   make(map[string]string),
@@ -415,6 +411,9 @@ func (h *Handler) StartCase(c *gin.Context) {
 	ch, err := h.ai.StreamAssistantJSON(ctx, threadID, userPrompt, instr)
 	if err != nil {
 		log.Printf("[InteractiveCase][Start][ERROR] thread=%s err=%v", threadID, err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate clinical case"})
 		return
 	}
@@ -1964,6 +1963,7 @@ func (h *Handler) recoverCorrectIndexEvidence(ctx context.Context, question stri
 		return -1, false
 	}
 	// Limitar presupuesto de tiempo para no bloquear el turno completo
+	vectorID := h.knowledgeVectorID()
 	tctx, cancel := context.WithTimeout(ctx, 8*time.Second)
 	defer cancel()
 
@@ -1993,11 +1993,11 @@ func (h *Handler) recoverCorrectIndexEvidence(ctx context.Context, question stri
 		}
 		sc := 0
 		// 1) Vector de libros
-		if strings.HasPrefix(strings.TrimSpace(h.vectorID), "vs_") {
-			if res, err := h.ai.SearchInVectorStoreWithMetadata(tctx, h.vectorID, query); err == nil && res != nil {
+		if strings.HasPrefix(strings.TrimSpace(vectorID), "vs_") {
+			if res, err := h.ai.SearchInVectorStoreWithMetadata(tctx, vectorID, query); err == nil && res != nil {
 				if res.HasResult {
 					sc += 2 // resultado con metadatos vale más
-				} else if txt, err2 := h.ai.SearchInVectorStore(tctx, h.vectorID, query); err2 == nil && strings.TrimSpace(txt) != "" {
+				} else if txt, err2 := h.ai.SearchInVectorStore(tctx, vectorID, query); err2 == nil && strings.TrimSpace(txt) != "" {
 					sc += 1
 				}
 			}
@@ -2254,7 +2254,16 @@ func buildInteractiveCaseQuery(caseMap map[string]any) string {
 	return title
 }
 
-// collectInteractiveEvidence consulta primero el vector de libros fijo (handler.vectorID) y luego PubMed
+// knowledgeVectorID devuelve el vector de libros de los casos interactivos: el fijado en el
+// handler (tests) o el que asigna el registro de bases de conocimiento.
+func (h *Handler) knowledgeVectorID() string {
+	if h.vectorID != "" {
+		return h.vectorID
+	}
+	return knowledge.VectorStoreFor(knowledge.FlowInteractiveCase)
+}
+
+// collectInteractiveEvidence consulta primero el vector de libros (knowledgeVectorID) y luego PubMed
 func (h *Handler) collectInteractiveEvidence(ctx context.Context, query string) string {
 	refs := h.collectInteractiveEvidenceRaw(ctx, query)
 	if len(refs) == 0 {
@@ -2279,12 +2288,13 @@ func (h *Handler) collectInteractiveEvidenceRaw(ctx context.Context, query strin
 	if os.Getenv("TESTING") == "1" {
 		return nil
 	}
-	log.Printf("[collectInteractiveEvidence] vectorID=%s query_len=%d", h.vectorID, len(query))
+	vectorID := h.knowledgeVectorID()
+	log.Printf("[collectInteractiveEvidence] vectorID=%s query_len=%d", vectorID, len(query))
 	refs := make([]string, 0, 2)
 	// 1) Libros (vector fijo configurado en handler)
-	if strings.HasPrefix(strings.TrimSpace(h.vectorID), "vs_") {
+	if strings.HasPrefix(strings.TrimSpace(vectorID), "vs_") {
 		log.Printf("[collectInteractiveEvidence] buscando en vector store...")
-		if res, err := h.ai.SearchInVectorStoreWithMetadata(ctx, h.vectorID, query); err == nil && res != nil && res.HasResult {
+		if res, err := h.ai.SearchInVectorStoreWithMetadata(ctx, vectorID, query); err == nil && res != nil && res.HasResult {
 			log.Printf("[collectInteractiveEvidence] encontrado con metadata: source=%s", res.Source)
 			src := strings.TrimSpace(res.Source)
 			sec := strings.TrimSpace(res.Section)
@@ -2301,7 +2311,7 @@ func (h *Handler) collectInteractiveEvidenceRaw(ctx context.Context, query strin
 				line = fmt.Sprintf("%s (referencia médica)", src)
 			}
 			refs = append(refs, line)
-		} else if txt, err2 := h.ai.SearchInVectorStore(ctx, h.vectorID, query); err2 == nil && strings.TrimSpace(txt) != "" {
+		} else if txt, err2 := h.ai.SearchInVectorStore(ctx, vectorID, query); err2 == nil && strings.TrimSpace(txt) != "" {
 			log.Printf("[collectInteractiveEvidence] encontrado sin metadata, len=%d", len(txt))
 			// Sin metadata, solo indicar que viene de la base médica
 			refs = append(refs, "Base médica")
@@ -2315,7 +2325,7 @@ func (h *Handler) collectInteractiveEvidenceRaw(ctx context.Context, query strin
 			log.Printf("[collectInteractiveEvidence] no se encontraron resultados en vector store")
 		}
 	} else {
-		log.Printf("[collectInteractiveEvidence] vectorID inválido o vacío: '%s'", h.vectorID)
+		log.Printf("[collectInteractiveEvidence] vectorID inválido o vacío: '%s'", vectorID)
 	}
 	// 2) PubMed - Habilitado para enriquecer referencias bibliográficas
 	if pm, err := h.ai.SearchPubMed(ctx, query); err == nil && strings.TrimSpace(pm) != "" {
@@ -0,0 +1,67 @@
+package casos_interactivos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// With AI_PROVIDER=mock the default handler runs a whole interactive_v2 case offline.
+func TestMockProvider_FullCaseOffline(t *testing.T) {
+	t.Setenv("AI_PROVIDER", "mock")
+	t.Setenv("OPENAI_API_KEY", "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	DefaultHandler().RegisterRoutes(r)
+
+	post := func(path, body string) map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d body=%s", path, w.Code, w.Body.String())
+		}
+		var out map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", path, err)
+		}
+		return out
+	}
+
+	start := post("/casos-interactivos/iniciar", `{"age":"58","sex":"male","type":"interactive","pregnant":false,"max_interactions":3}`)
+	threadID, _ := start["thread_id"].(string)
+	if !strings.HasPrefix(threadID, "thread_") || start["schema_version"] != interactiveSchemaVersion {
+		t.Fatalf("start = %v", start)
+	}
+	data, _ := start["data"].(map[string]any)
+	next, _ := data["next"].(map[string]any)
+	pregunta, _ := next["pregunta"].(map[string]any)
+	if texto, _ := pregunta["texto"].(string); texto == "" {
+		t.Fatalf("start without question: %v", data)
+	}
+
+	for turn := 1; ; turn++ {
+		if turn > 6 {
+			t.Fatal("case never finished")
+		}
+		resp := post("/casos-interactivos/mensaje", `{"thread_id":"`+threadID+`","mensaje":"Electrocardiograma de 12 derivaciones"}`)
+		d, _ := resp["data"].(map[string]any)
+		if finish, _ := d["finish"].(float64); finish == 1 {
+			if fb, _ := d["feedback"].(string); strings.TrimSpace(fb) == "" {
+				t.Fatalf("closing turn without feedback: %v", d)
+			}
+			break
+		}
+		n, _ := d["next"].(map[string]any)
+		pq, _ := n["pregunta"].(map[string]any)
+		if opts, _ := pq["opciones"].([]any); len(opts) != 4 {
+			t.Fatalf("turn %d: options = %v", turn, pq["opciones"])
+		}
+	}
+}
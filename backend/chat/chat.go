@@ -14,6 +14,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"ema-backend/openai"
 	"ema-backend/sse"
 )
 
@@ -55,6 +56,9 @@ func (h *Handler) Start(c *gin.Context) {
 			log.Printf("[chat][Start] assistant_id=%s thread=%s", h.AI.GetAssistantID(), threadID)
 		} else if h.strictThreads {
 			log.Printf("[chat][Start][error] create_thread err=%v assistant_id=%s", err, h.AI.GetAssistantID())
+			if openai.RespondUnavailable(c, err) {
+				return
+			}
 			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no se pudo crear thread assistant", "detail": err.Error()})
 			return
 		}
@@ -189,6 +193,9 @@ func (h *Handler) Message(c *gin.Context) {
 						}
 						stream, ferr := h.AI.StreamMessage(c, fb)
 						if ferr != nil {
+							if openai.RespondUnavailable(c, ferr) {
+								return
+							}
 							c.JSON(http.StatusInternalServerError, gin.H{"error": ferr.Error()})
 							return
 						}
@@ -209,6 +216,9 @@ func (h *Handler) Message(c *gin.Context) {
 						}
 						stream, ferr := h.AI.StreamMessage(c, fb)
 						if ferr != nil {
+							if openai.RespondUnavailable(c, ferr) {
+								return
+							}
 							c.JSON(http.StatusInternalServerError, gin.H{"error": ferr.Error()})
 							return
 						}
@@ -242,6 +252,9 @@ func (h *Handler) Message(c *gin.Context) {
 						}
 						stream, ferr := h.AI.StreamMessage(c, fb)
 						if ferr != nil {
+							if openai.RespondUnavailable(c, ferr) {
+								return
+							}
 							c.JSON(http.StatusInternalServerError, gin.H{"error": ferr.Error()})
 							return
 						}
@@ -299,6 +312,9 @@ func (h *Handler) Message(c *gin.Context) {
 						fb := base + "\n\nNota: no se pudo usar el documento adjunto."
 						fbs, ferr := h.AI.StreamMessage(c.Request.Context(), fb)
 						if ferr != nil {
+							if openai.RespondUnavailable(c, ferr) {
+								return
+							}
 							c.JSON(http.StatusInternalServerError, gin.H{"error": ferr.Error()})
 							return
 						}
@@ -348,7 +364,7 @@ func (h *Handler) Message(c *gin.Context) {
 				c.Header("X-Source-Used", "doc_only")
 			} else if strings.TrimSpace(prompt) != "" {
 				// Conversación general (sin PDFs): exigir fuentes de biblioteca + PubMed
-				srcRules := "Tono académico (preciso y conciso). Prioriza SIEMPRE la biblioteca interna (vector de libros vs_680fc484cef081918b2b9588b701e2f4). Si hay conflicto con PubMed, prevalece la biblioteca. Al final añade una línea 'Fuente:' especificando los PDF de la biblioteca usados (si aplica). Si usas PubMed, agrega una sección '**Referencias (PubMed):**' con entradas completas (Autores, Título, Revista, Año, DOI/PMID) de 2020 en adelante. No repitas referencias dentro del cuerpo."
+				srcRules := "Tono académico (preciso y conciso). Prioriza SIEMPRE la biblioteca interna (vector de libros). Si hay conflicto con PubMed, prevalece la biblioteca. Al final añade una línea 'Fuente:' especificando los PDF de la biblioteca usados (si aplica). Si usas PubMed, agrega una sección '**Referencias (PubMed):**' con entradas completas (Autores, Título, Revista, Año, DOI/PMID) de 2020 en adelante. No repitas referencias dentro del cuerpo."
 				prompt = prompt + "\n\n" + srcRules
 				c.Header("X-Source-Policy", "books+pubmed")
 				c.Header("X-Source-Used", "hybrid")
@@ -360,6 +376,9 @@ func (h *Handler) Message(c *gin.Context) {
 				log.Printf("ERROR StreamAssistantMessage (multipart): %v", err)
 				fbStream, ferr := h.AI.StreamMessage(c.Request.Context(), prompt)
 				if ferr != nil {
+					if openai.RespondUnavailable(c, ferr) {
+						return
+					}
 					c.JSON(http.StatusInternalServerError, gin.H{"error": ferr.Error()})
 					return
 				}
@@ -380,6 +399,9 @@ func (h *Handler) Message(c *gin.Context) {
 		}
 		stream, err := h.AI.StreamMessage(c.Request.Context(), prompt)
 		if err != nil {
+			if openai.RespondUnavailable(c, err) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -423,7 +445,7 @@ func (h *Handler) Message(c *gin.Context) {
 			c.Header("X-Source-Used", "doc_only")
 		} else if strings.TrimSpace(prompt) != "" {
 			// Conversación general (sin PDFs): exigir fuentes de biblioteca + PubMed
-			srcRules := "Tono académico (preciso y conciso). Prioriza SIEMPRE la biblioteca interna (vector de libros vs_680fc484cef081918b2b9588b701e2f4). Si hay conflicto con PubMed, prevalece la biblioteca. Al final añade una línea 'Fuente:' especificando los PDF de la biblioteca usados (si aplica). Si usas PubMed, agrega una sección '**Referencias (PubMed):**' con entradas completas (Autores, Título, Revista, Año, DOI/PMID) de 2020 en adelante. No repitas referencias dentro del cuerpo."
+			srcRules := "Tono académico (preciso y conciso). Prioriza SIEMPRE la biblioteca interna (vector de libros). Si hay conflicto con PubMed, prevalece la biblioteca. Al final añade una línea 'Fuente:' especificando los PDF de la biblioteca usados (si aplica). Si usas PubMed, agrega una sección '**Referencias (PubMed):**' con entradas completas (Autores, Título, Revista, Año, DOI/PMID) de 2020 en adelante. No repitas referencias dentro del cuerpo."
 			prompt = prompt + "\n\n" + srcRules
 			c.Header("X-Source-Policy", "books+pubmed")
 			c.Header("X-Source-Used", "hybrid")
@@ -434,6 +456,9 @@ func (h *Handler) Message(c *gin.Context) {
 			log.Printf("ERROR StreamAssistantMessage (json): %v", err)
 			fbStream, ferr := h.AI.StreamMessage(c.Request.Context(), req.Prompt)
 			if ferr != nil {
+				if openai.RespondUnavailable(c, ferr) {
+					return
+				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": ferr.Error()})
 				return
 			}
@@ -459,6 +484,9 @@ func (h *Handler) Message(c *gin.Context) {
 	}
 	stream, err := h.AI.StreamMessage(c.Request.Context(), req.Prompt)
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -490,6 +518,9 @@ func (h *Handler) VectorReset(c *gin.Context) {
 	}
 	vsID, err := h.AI.ForceNewVectorStore(c.Request.Context(), req.ThreadID)
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -509,6 +540,9 @@ func (h *Handler) VectorFiles(c *gin.Context) {
 	}
 	files, err := h.AI.ListVectorStoreFiles(c.Request.Context(), threadID)
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
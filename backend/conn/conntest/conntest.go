@@ -0,0 +1,58 @@
+// Package conntest runs database integration tests against every engine of the test matrix:
+// TEST_MYSQL_DSN (go-sql-driver DSN, with parseTime=true) and TEST_POSTGRES_DSN (lib/pq URL).
+// Engines without a DSN are skipped, so plain `go test ./...` needs no database;
+// scripts/test_db_matrix.sh starts both engines and runs the suite.
+//
+// The tests migrate the same databases up and down: run them with -p 1.
+package conntest
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"ema-backend/conn"
+)
+
+// ForEach runs fn as a subtest ("mysql", "postgres") for each configured engine.
+func ForEach(t *testing.T, fn func(t *testing.T, db *sql.DB)) {
+	t.Helper()
+	engines := []struct {
+		name, env string
+		open      func(dsn string) (*sql.DB, error)
+	}{
+		{"mysql", "TEST_MYSQL_DSN", openMySQL},
+		{"postgres", "TEST_POSTGRES_DSN", conn.OpenPostgres},
+	}
+	ran := false
+	for _, e := range engines {
+		dsn := os.Getenv(e.env)
+		if dsn == "" {
+			continue
+		}
+		ran = true
+		t.Run(e.name, func(t *testing.T) {
+			db, err := e.open(dsn)
+			if err != nil {
+				t.Fatalf("%s: %v", e.env, err)
+			}
+			defer db.Close()
+			fn(t, db)
+		})
+	}
+	if !ran {
+		t.Skip("TEST_MYSQL_DSN / TEST_POSTGRES_DSN not set")
+	}
+}
+
+func openMySQL(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,184 @@
+package conn
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL flavour of a connection. Queries are written once with MySQL-style "?"
+// placeholders (the Postgres driver rebinds them, see Rebind); the methods below cover the
+// few constructs that differ between the engines.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+)
+
+// Open abre la conexión del motor elegido con DB_DRIVER: "mysql" (por defecto) o "postgres".
+func Open() (*sql.DB, error) {
+	switch driver := strings.ToLower(strings.TrimSpace(os.Getenv("DB_DRIVER"))); driver {
+	case "", "mysql":
+		return NewMySQL()
+	case "postgres", "postgresql", "pg":
+		return NewPostgres()
+	default:
+		return nil, fmt.Errorf("DB_DRIVER %q no soportado (mysql | postgres)", driver)
+	}
+}
+
+// DialectOf tells which engine db talks to (MySQL for nil).
+func DialectOf(db *sql.DB) Dialect {
+	if db != nil {
+		if _, ok := db.Driver().(pgDriver); ok {
+			return Postgres
+		}
+	}
+	return MySQL
+}
+
+// Execer is implemented by *sql.DB and *sql.Tx.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// InsertID runs an INSERT and returns the id of the new row (Postgres has no LastInsertId:
+// the statement gets "RETURNING id").
+func (d Dialect) InsertID(db Execer, query string, args ...any) (int64, error) {
+	if d == Postgres {
+		var id int64
+		err := db.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// InsertIgnore turns "INSERT INTO ..." into an insert that skips rows hitting a unique key.
+func (d Dialect) InsertIgnore(insert string) string {
+	if d == Postgres {
+		return insert + " ON CONFLICT DO NOTHING"
+	}
+	return strings.Replace(insert, "INSERT INTO", "INSERT IGNORE INTO", 1)
+}
+
+// Upsert starts the update clause of an insert whose conflictCols (a unique key) already
+// exist. In the assignments, read the proposed values with Excluded and qualify the current
+// ones with the table name.
+func (d Dialect) Upsert(conflictCols ...string) string {
+	if d == Postgres {
+		return "ON CONFLICT (" + strings.Join(conflictCols, ", ") + ") DO UPDATE SET"
+	}
+	return "ON DUPLICATE KEY UPDATE"
+}
+
+// Excluded is the value the conflicting insert proposed for col.
+func (d Dialect) Excluded(col string) string {
+	if d == Postgres {
+		return "EXCLUDED." + col
+	}
+	return "VALUES(" + col + ")"
+}
+
+// Concat concatenates string expressions.
+func (d Dialect) Concat(parts ...string) string {
+	if d == Postgres {
+		return "(" + strings.Join(parts, " || ") + ")"
+	}
+	return "CONCAT(" + strings.Join(parts, ", ") + ")"
+}
+
+// CastText converts an expression to a string.
+func (d Dialect) CastText(expr string) string {
+	if d == Postgres {
+		return "CAST(" + expr + " AS TEXT)"
+	}
+	return "CAST(" + expr + " AS CHAR)"
+}
+
+// CastInt converts an expression to an integer; Postgres needs it for placeholders in a
+// SELECT list, whose type it can't infer.
+func (d Dialect) CastInt(expr string) string {
+	if d == Postgres {
+		return "CAST(" + expr + " AS INTEGER)"
+	}
+	return "CAST(" + expr + " AS SIGNED)"
+}
+
+// JSONInt extracts the integer member key of a JSON object stored as text (NULL if missing).
+func (d Dialect) JSONInt(expr, key string) string {
+	if d == Postgres {
+		return "CAST(CAST(" + expr + " AS JSON)->>'" + key + "' AS INTEGER)"
+	}
+	return "JSON_EXTRACT(" + expr + ",'$." + key + "')"
+}
+
+// MonthKey formats a date column as YYYY-MM.
+func (d Dialect) MonthKey(expr string) string {
+	if d == Postgres {
+		return "TO_CHAR(" + expr + ", 'YYYY-MM')"
+	}
+	return "DATE_FORMAT(" + expr + ", '%Y-%m')"
+}
+
+// ILike is the case-insensitive LIKE operator (MySQL's default collation already ignores case).
+func (d Dialect) ILike() string {
+	if d == Postgres {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// Rebind numbers the "?" placeholders of a query as $1, $2, ... skipping string literals,
+// quoted identifiers and comments.
+func Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(query[i+1:], ch)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+1])
+			i += end
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+4])
+			i += end + 3
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
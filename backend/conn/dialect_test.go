@@ -0,0 +1,71 @@
+package conn
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	cases := map[string]string{
+		"SELECT 1":                                      "SELECT 1",
+		"SELECT * FROM t WHERE a=? AND b=?":             "SELECT * FROM t WHERE a=$1 AND b=$2",
+		"SELECT '?', \"a?\" FROM t WHERE c=?":           "SELECT '?', \"a?\" FROM t WHERE c=$1",
+		"SELECT 'it''s ?' WHERE x=?":                    "SELECT 'it''s ?' WHERE x=$1",
+		"-- why?\nSELECT ? /* and ? */, ?":              "-- why?\nSELECT $1 /* and ? */, $2",
+		"INSERT INTO t (a, b) VALUES (?, NULLIF(?,''))": "INSERT INTO t (a, b) VALUES ($1, NULLIF($2,''))",
+		"SELECT 'unterminated ?":                        "SELECT 'unterminated ?",
+	}
+	for in, want := range cases {
+		if got := Rebind(in); got != want {
+			t.Errorf("Rebind(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDialectSQL(t *testing.T) {
+	cases := []struct{ got, want string }{
+		{MySQL.Upsert("a", "b"), "ON DUPLICATE KEY UPDATE"},
+		{Postgres.Upsert("a", "b"), "ON CONFLICT (a, b) DO UPDATE SET"},
+		{MySQL.Excluded("price"), "VALUES(price)"},
+		{Postgres.Excluded("price"), "EXCLUDED.price"},
+		{MySQL.InsertIgnore("INSERT INTO t (a) VALUES (?)"), "INSERT IGNORE INTO t (a) VALUES (?)"},
+		{Postgres.InsertIgnore("INSERT INTO t (a) VALUES (?)"), "INSERT INTO t (a) VALUES (?) ON CONFLICT DO NOTHING"},
+		{MySQL.Concat("a", "'-'", "b"), "CONCAT(a, '-', b)"},
+		{Postgres.Concat("a", "'-'", "b"), "(a || '-' || b)"},
+		{MySQL.JSONInt("e", "statistics"), "JSON_EXTRACT(e,'$.statistics')"},
+		{Postgres.JSONInt("e", "statistics"), "CAST(CAST(e AS JSON)->>'statistics' AS INTEGER)"},
+		{MySQL.MonthKey("created_at"), "DATE_FORMAT(created_at, '%Y-%m')"},
+		{Postgres.MonthKey("created_at"), "TO_CHAR(created_at, 'YYYY-MM')"},
+		{MySQL.ILike(), "LIKE"},
+		{Postgres.ILike(), "ILIKE"},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("got %q, want %q", c.got, c.want)
+		}
+	}
+}
+
+func TestDialectOf(t *testing.T) {
+	if d := DialectOf(nil); d != MySQL {
+		t.Fatalf("DialectOf(nil) = %s", d)
+	}
+	// sql.Open doesn't connect: enough to check driver detection
+	my, _ := sql.Open("mysql", "u:p@tcp(127.0.0.1:1)/x")
+	defer my.Close()
+	if d := DialectOf(my); d != MySQL {
+		t.Fatalf("mysql connection detected as %s", d)
+	}
+	pg := sql.OpenDB(pgConnector{})
+	defer pg.Close()
+	if d := DialectOf(pg); d != Postgres {
+		t.Fatalf("postgres connection detected as %s", d)
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	t.Setenv("DB_DRIVER", "oracle")
+	if _, err := Open(); err == nil {
+		t.Fatal("expected error for an unsupported DB_DRIVER")
+	}
+}
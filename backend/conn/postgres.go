@@ -0,0 +1,120 @@
+package conn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/lib/pq"
+)
+
+// NewPostgres abre una conexión a Postgres con las mismas variables de entorno que NewMySQL
+// (más DB_SSLMODE, por defecto "disable") y crea la base si no existe.
+func NewPostgres() (*sql.DB, error) {
+	name := os.Getenv("DB_NAME")
+	adminDB, err := OpenPostgres(postgresDSN("postgres"))
+	if err != nil {
+		return nil, err
+	}
+	var exists int
+	err = adminDB.QueryRow("SELECT COUNT(1) FROM pg_database WHERE datname = ?", name).Scan(&exists)
+	if err == nil && exists == 0 {
+		_, err = adminDB.Exec("CREATE DATABASE " + pq.QuoteIdentifier(name))
+	}
+	adminDB.Close()
+	if err != nil {
+		return nil, err
+	}
+	return OpenPostgres(postgresDSN(name))
+}
+
+func postgresDSN(database string) string {
+	sslmode := os.Getenv("DB_SSLMODE")
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD")),
+		Host:     os.Getenv("DB_HOST") + ":" + os.Getenv("DB_PORT"),
+		Path:     "/" + database,
+		RawQuery: url.Values{"sslmode": {sslmode}}.Encode(),
+	}
+	return u.String()
+}
+
+// OpenPostgres opens a lib/pq DSN through the rebinding driver and checks the connection.
+func OpenPostgres(dsn string) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres dsn: %w", err)
+	}
+	db := sql.OpenDB(pgConnector{connector})
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// pgDriver wraps lib/pq so queries keep MySQL-style "?" placeholders: every statement goes
+// through Rebind before reaching Postgres. DialectOf recognizes connections by this driver.
+type pgDriver struct{}
+
+func (pgDriver) Open(dsn string) (driver.Conn, error) {
+	c, err := pq.Driver{}.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return pgConn{c}, nil
+}
+
+type pgConnector struct{ driver.Connector }
+
+func (c pgConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pgConn{cn}, nil
+}
+
+func (pgConnector) Driver() driver.Driver { return pgDriver{} }
+
+// pgConn forwards to the lib/pq connection, which implements every optional interface below.
+type pgConn struct{ driver.Conn }
+
+func (c pgConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c pgConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, Rebind(query))
+}
+
+func (c pgConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, Rebind(query), args)
+}
+
+func (c pgConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, Rebind(query), args)
+}
+
+func (c pgConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c pgConn) Ping(ctx context.Context) error { return c.Conn.(driver.Pinger).Ping(ctx) }
+
+func (c pgConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c pgConn) IsValid() bool { return c.Conn.(driver.Validator).IsValid() }
+
+func (c pgConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
@@ -0,0 +1,112 @@
+package conversations_ia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ema-backend/login"
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+type cancelAI struct {
+	AIClient
+	cancelled []string
+}
+
+func (a *cancelAI) CancelRun(ctx context.Context, threadID string) (bool, error) {
+	a.cancelled = append(a.cancelled, threadID)
+	return threadID == "thread_busy", nil
+}
+
+func TestCancelEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ai := &cancelAI{}
+	r := gin.New()
+	r.POST("/conversations/cancel", NewHandler(ai).Cancel)
+
+	for thread, want := range map[string]bool{"thread_busy": true, "thread_idle": false} {
+		w := serveJSON(r, http.MethodPost, "/conversations/cancel", "", map[string]string{"thread_id": thread})
+		var res struct {
+			Cancelled bool `json:"cancelled"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil || res.Cancelled != want {
+			t.Fatalf("cancel %s: %d %s", thread, w.Code, w.Body)
+		}
+	}
+	if w := serveJSON(r, http.MethodPost, "/conversations/cancel", "", map[string]string{}); w.Code != http.StatusBadRequest {
+		t.Fatalf("cancel without thread_id: %d", w.Code)
+	}
+	if len(ai.cancelled) != 2 {
+		t.Fatalf("CancelRun calls = %v", ai.cancelled)
+	}
+}
+
+// Con historial solo el dueño del hilo puede cancelarlo.
+func TestCancelEndpoint_RequiresOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := migrations.NewMemoryStore()
+	users.AddUser(migrations.User{FirstName: "Ana", Email: "ana@example.com", Password: "pw"})
+	users.AddUser(migrations.User{FirstName: "Luis", Email: "luis@example.com", Password: "pw"})
+	ai := &cancelAI{}
+	h := NewHandler(ai)
+	h.SetHistory(NewMemoryConversationStore(), users)
+	r := gin.New()
+	login.NewHandler(users).RegisterRoutes(r)
+	r.POST("/conversations/cancel", h.Cancel)
+	ana, luis := loginToken(t, r, "ana@example.com"), loginToken(t, r, "luis@example.com")
+	h.history.Record(users.GetUserByEmail("ana@example.com").ID, "thread_busy", "Dosis de amoxicilina", SourceChat)
+
+	body := map[string]string{"thread_id": "thread_busy"}
+	if w := serveJSON(r, http.MethodPost, "/conversations/cancel", "", body); w.Code != http.StatusUnauthorized {
+		t.Fatalf("cancel without token: %d", w.Code)
+	}
+	if w := serveJSON(r, http.MethodPost, "/conversations/cancel", luis, body); w.Code != http.StatusNotFound {
+		t.Fatalf("cancel by another user: %d", w.Code)
+	}
+	if len(ai.cancelled) != 0 {
+		t.Fatalf("CancelRun called for a foreign thread: %v", ai.cancelled)
+	}
+	if w := serveJSON(r, http.MethodPost, "/conversations/cancel", ana, body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"cancelled":true`) {
+		t.Fatalf("cancel by owner: %d %s", w.Code, w.Body)
+	}
+}
+
+// Al desconectarse el cliente sseStream deja de escribir, no envía [DONE] y el productor no queda
+// bloqueado (el resto del canal se descarta).
+func TestSSEStreamStopsOnDisconnect(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctx, disconnect := context.WithCancel(context.Background())
+	c.Request = httptest.NewRequest(http.MethodPost, "/conversations/message", nil).WithContext(ctx)
+
+	ch := make(chan string)
+	produced := make(chan struct{})
+	go func() {
+		defer close(produced)
+		ch <- "Primera parte."
+		disconnect()
+		for i := 0; i < 50; i++ {
+			ch <- " más"
+		}
+		close(ch)
+	}()
+
+	if got := sseStream(c, ch); !strings.HasPrefix(got, "Primera parte.") {
+		t.Fatalf("sseStream = %q", got)
+	}
+	select {
+	case <-produced:
+	case <-time.After(2 * time.Second):
+		t.Fatal("producer blocked after disconnect")
+	}
+	if strings.Contains(w.Body.String(), "[DONE]") {
+		t.Fatalf("wrote [DONE] to a disconnected client: %s", w.Body)
+	}
+}
@@ -0,0 +1,158 @@
+package conversations_ia
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"ema-backend/docindex"
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Citas de respuestas sobre documentos subidos (doc_only / focus_doc): documento, página,
+// sección y el fragmento citado, para que el cliente abra el PDF en la página. Viajan en el
+// evento final "__JSON__" del stream (campo "citations") y se guardan en la transcripción.
+// Con el índice local salen de los fragmentos usados; con el vector store de OpenAI, de una
+// búsqueda paralela a la respuesta (la página solo se conoce si el fragmento la trae o si el
+// texto es OCR con marcas de página).
+
+const (
+	maxCitations         = 5
+	citationSnippetChars = 280
+	// citationWait es lo que se espera a la búsqueda de citas al terminar el stream
+	citationWait = 3 * time.Second
+	citationsKey = "doc_citations"
+)
+
+// ocrPageMarker es la marca que writeOCRText deja al inicio de cada página.
+var ocrPageMarker = regexp.MustCompile(`\[Página \d+\]`)
+
+// Citation es una cita estructurada de una respuesta sobre documentos.
+type Citation struct {
+	Document   string `json:"document"`
+	DocumentID string `json:"document_id,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	Section    string `json:"section,omitempty"`
+	Snippet    string `json:"snippet"`
+}
+
+// label es la forma corta de la cita: "guia.pdf, p. 12 — Tratamiento".
+func (ct Citation) label() string {
+	out := ct.Document
+	if ct.Page > 0 {
+		out += fmt.Sprintf(", p. %d", ct.Page)
+	}
+	if ct.Section != "" {
+		out += " — " + ct.Section
+	}
+	return out
+}
+
+// citationsFromResults arma las citas de los resultados de búsqueda, una por documento y página.
+func citationsFromResults(results []*openai.VectorSearchResult, query string) []Citation {
+	out := []Citation{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		if r == nil || len(out) >= maxCitations {
+			continue
+		}
+		content := strings.TrimSpace(ocrPageMarker.ReplaceAllString(r.Content, " "))
+		doc := strings.TrimSpace(r.Source)
+		if content == "" || doc == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s|%d", doc, r.Page)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		section := strings.TrimSpace(r.Section)
+		if section == fmt.Sprintf("Página %d", r.Page) {
+			section = "" // el respaldo de VectorSearchResult.Section, ya está en Page
+		}
+		out = append(out, Citation{
+			Document:   doc,
+			DocumentID: r.DocumentID,
+			Page:       r.Page,
+			Section:    section,
+			Snippet:    docindex.Snippet(content, query, citationSnippetChars),
+		})
+	}
+	return out
+}
+
+// hitResults pasa los fragmentos del índice local a resultados de búsqueda.
+func hitResults(hits []docindex.Hit) []*openai.VectorSearchResult {
+	out := make([]*openai.VectorSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		out = append(out, &openai.VectorSearchResult{
+			Content:    hit.Content,
+			Source:     hit.FileName,
+			HasResult:  true,
+			Section:    hit.Section,
+			Page:       hit.Page,
+			DocumentID: hit.DocumentID,
+		})
+	}
+	return out
+}
+
+// readyCitations envuelve citas ya conocidas.
+func readyCitations(cits []Citation) <-chan []Citation {
+	ch := make(chan []Citation, 1)
+	ch <- cits
+	close(ch)
+	return ch
+}
+
+// searchCitations busca en segundo plano, en el vector store del hilo, los fragmentos que citan
+// la consulta mientras se genera la respuesta.
+func (h *Handler) searchCitations(ctx context.Context, vsID, query string) <-chan []Citation {
+	ch := make(chan []Citation, 1)
+	go func() {
+		defer close(ch)
+		results, err := h.AI.QuickVectorSearchMultiple(ctx, vsID, query, maxCitations)
+		if err != nil {
+			log.Printf("[conv][citations][error] vs=%s err=%v", vsID, err)
+			return
+		}
+		ch <- citationsFromResults(results, query)
+	}()
+	return ch
+}
+
+// setCitations deja las citas de la respuesta para el evento final del stream.
+func setCitations(c *gin.Context, ch <-chan []Citation) {
+	if ch != nil {
+		c.Set(citationsKey, ch)
+	}
+}
+
+// requestCitations devuelve las citas de la respuesta en curso, esperando hasta citationWait a
+// que termine la búsqueda; el resultado queda en el contexto para la transcripción.
+func requestCitations(c *gin.Context) []Citation {
+	v, ok := c.Get(citationsKey)
+	if !ok {
+		return nil
+	}
+	switch v := v.(type) {
+	case []Citation:
+		return v
+	case <-chan []Citation:
+		var cits []Citation
+		select {
+		case cits = <-v:
+		case <-time.After(citationWait):
+			log.Printf("[conv][citations][timeout] waited=%s", citationWait)
+		case <-c.Request.Context().Done():
+		}
+		c.Set(citationsKey, cits)
+		return cits
+	}
+	return nil
+}
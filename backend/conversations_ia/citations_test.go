@@ -0,0 +1,86 @@
+package conversations_ia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ema-backend/docindex"
+	"ema-backend/files"
+	"ema-backend/llm"
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCitationsFromResults(t *testing.T) {
+	results := []*openai.VectorSearchResult{
+		{Source: "guia.pdf", DocumentID: "file_1", Page: 12, Section: "Tratamiento", Content: "Introducción al capítulo. La metformina es el fármaco de primera línea en la diabetes tipo 2."},
+		{Source: "guia.pdf", DocumentID: "file_1", Page: 12, Section: "Tratamiento", Content: "Otro fragmento de la misma página."},
+		{Source: "escaneo.txt", Page: 3, Section: "Página 3", Content: "[Página 3]\nDosis de metformina en insuficiencia renal."},
+		{Source: "", Content: "sin documento"},
+		{Source: "notas.pdf", Content: "  "},
+	}
+	got := citationsFromResults(results, "metformina diabetes")
+	if len(got) != 2 {
+		t.Fatalf("citations = %+v", got)
+	}
+	if c := got[0]; c.Document != "guia.pdf" || c.DocumentID != "file_1" || c.Page != 12 || c.Section != "Tratamiento" || !strings.HasPrefix(c.Snippet, "Introducción") {
+		t.Fatalf("citation 0 = %+v", c)
+	}
+	if c := got[1]; c.Page != 3 || c.Section != "" || strings.Contains(c.Snippet, "[Página") {
+		t.Fatalf("OCR citation = %+v", c)
+	}
+	if got[0].label() != "guia.pdf, p. 12 — Tratamiento" || got[1].label() != "escaneo.txt, p. 3" {
+		t.Fatalf("labels = %q, %q", got[0].label(), got[1].label())
+	}
+}
+
+func TestLocalDocAnswer_CitationsInFinalEvent(t *testing.T) {
+	ctx := context.Background()
+	h := NewHandler(&docIndexAI{})
+	h.SetDocumentIndex(docindex.New(docindex.NewMemoryStore(), llm.NewMock(nil)))
+	page2 := "Tratamiento\nLa hipertensión arterial se trata con inhibidores de la enzima convertidora y diuréticos tiazídicos."
+	if _, err := h.docs.IndexLayout(ctx, "thread_cit", "guia.pdf", []files.PageText{
+		{Page: 1, Text: "Introducción y metodología de la guía de práctica clínica."},
+		{Page: 2, Text: page2, Headings: []files.Heading{{Text: "Tratamiento", Offset: 0}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.SmartMessage(ctx, "thread_cit", "¿Cómo se trata la hipertensión arterial?", "", TopicSnapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(h.AI.(*docIndexAI).instructions, "[1] guia.pdf, p. 2 (Tratamiento):") {
+		t.Fatalf("instructions without section: %s", h.AI.(*docIndexAI).instructions)
+	}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/conversations/message", nil)
+	setCitations(c, resp.Citations)
+	h.sseMaybeCapture(c, resp.Stream, "thread_cit")
+
+	var final struct {
+		Text      string     `json:"text"`
+		Citations []Citation `json:"citations"`
+	}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if rest, ok := strings.CutPrefix(line, "data: __JSON__:"); ok {
+			if err := json.Unmarshal([]byte(rest), &final); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(final.Citations) == 0 {
+		t.Fatalf("final event without citations: %s", w.Body)
+	}
+	if c := final.Citations[0]; c.Document != "guia.pdf" || c.Page != 2 || c.Section != "Tratamiento" ||
+		!strings.Contains(c.Snippet, "inhibidores de la enzima convertidora") || !strings.HasPrefix(c.DocumentID, "doc_") {
+		t.Fatalf("citation = %+v", c)
+	}
+}
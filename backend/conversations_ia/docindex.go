@@ -0,0 +1,201 @@
+package conversations_ia
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ema-backend/docindex"
+	"ema-backend/files"
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Índice local de PDFs (PDF_LOCAL_INDEX=1): el PDF subido se extrae por página, se divide en
+// fragmentos, se vectoriza con el flujo de embeddings de llm y se guarda en document_chunks.
+// Las preguntas doc-only buscan ahí los fragmentos y los envían al modelo en las instrucciones,
+// sin crear un vector store de OpenAI ni esperar su indexación. Si la indexación local falla,
+// el upload sigue por el camino de OpenAI.
+
+// localDocHits es la cantidad de fragmentos que se envían al modelo por pregunta.
+const localDocHits = 6
+
+// SetDocumentIndex activa el índice local de PDFs.
+func (h *Handler) SetDocumentIndex(idx *docindex.Index) {
+	h.docs = idx
+}
+
+// localDocuments devuelve los documentos del índice local del hilo (ninguno si no está activo).
+func (h *Handler) localDocuments(threadID string) []docindex.Document {
+	if h.docs == nil || strings.TrimSpace(threadID) == "" {
+		return nil
+	}
+	docs, err := h.docs.Documents(threadID)
+	if err != nil {
+		log.Printf("[conv][docindex][error] documents thread=%s err=%v", threadID, err)
+		return nil
+	}
+	return docs
+}
+
+// deleteDocuments borra los documentos del índice local al borrar o reiniciar el hilo.
+func (h *Handler) deleteDocuments(threadID string) {
+	if h.docs == nil {
+		return
+	}
+	if err := h.docs.DeleteThread(threadID); err != nil {
+		log.Printf("[conv][docindex][error] delete thread=%s err=%v", threadID, err)
+	}
+}
+
+// handlePDFLocal indexa el PDF en el índice local y responde (confirmación o respuesta doc-only
+// si vino prompt). pages trae las páginas ya extraídas (OCR de un escaneado); si es nil se lee el PDF.
+// Devuelve false si no se pudo indexar, para seguir con el vector store de OpenAI.
+func (h *Handler) handlePDFLocal(c *gin.Context, threadID, prompt string, upFile *multipart.FileHeader, tmp string, pages []files.PageText, start time.Time) bool {
+	fname := filepath.Base(upFile.Filename)
+	idxStart := time.Now()
+	var doc *docindex.Document
+	var err error
+	if pages != nil {
+		doc, err = h.docs.IndexLayout(c.Request.Context(), threadID, fname, pages)
+	} else {
+		doc, err = h.docs.IndexPDF(c.Request.Context(), threadID, tmp, fname)
+	}
+	if err != nil {
+		log.Printf("[conv][PDF][local_index][fallback_openai] thread=%s file=%s err=%v", threadID, fname, err)
+		return false
+	}
+	log.Printf("[conv][PDF][local_index][ok] thread=%s file=%s doc=%s pages=%d chunks=%d elapsed_ms=%d",
+		threadID, fname, doc.ID, doc.Pages, doc.Chunks, time.Since(idxStart).Milliseconds())
+
+	h.AI.AddSessionBytes(threadID, upFile.Size)
+	if !h.consumeFileQuota(c) {
+		return true
+	}
+	if v, ok := c.Get("quota_remaining"); ok {
+		c.Header("X-Quota-Remaining", toString(v))
+	}
+	c.Header("X-RAG", "1")
+	c.Header("X-Grounded", "1")
+	c.Header("X-RAG-File", fname)
+	c.Header("X-RAG-Prompt", "doc-only-local-v1")
+	c.Header("X-Assistant-Start-Ms", time.Since(start).String())
+	c.Header("X-Thread-ID", threadID)
+	c.Header("X-Strict-Threads", "1")
+	c.Header("X-Source-Used", "doc_only")
+	c.Header("X-Doc-Index", "local")
+	c.Header("X-Document-ID", doc.ID)
+	c.Header("X-PDF-Pages", strconv.Itoa(doc.Pages))
+	c.Header("X-PDF-Indexing-Status", "complete")
+
+	base := strings.TrimSpace(prompt)
+	if base == "" {
+		msg := fmt.Sprintf("✅ Documento '%s' (%d páginas) cargado y procesado correctamente.\n\n"+
+			"Puedes hacer preguntas específicas sobre este PDF.\n\n"+
+			"Fuente: %s", fname, doc.Pages, fname)
+		one := make(chan string, 1)
+		one <- msg
+		close(one)
+		stages := []string{"__STAGE__:start", "__STAGE__:doc_only", "__STAGE__:streaming_answer"}
+		h.sseMaybeCapture(c, wrapWithStages(stages, one), threadID)
+		return true
+	}
+
+	resp, err := h.localDocAnswer(c.Request.Context(), threadID, base, doc.ID)
+	if err != nil {
+		log.Printf("[conv][PDF][local_index][error] stream err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return true
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
+		return true
+	}
+	setCitations(c, resp.Citations)
+	log.Printf("[conv][PDF][doc_only.local.stream] thread=%s file=%s elapsed_ms=%d", threadID, fname, time.Since(start).Milliseconds())
+	stages := []string{"__STAGE__:start", "__STAGE__:doc_only", "__STAGE__:streaming_answer"}
+	h.sseMaybeCapture(c, wrapWithStages(stages, resp.Stream), threadID)
+	return true
+}
+
+// localDocAnswer responde una pregunta doc-only con los fragmentos del índice local. focusDocID
+// limita la búsqueda a ese documento cuando es uno del índice.
+func (h *Handler) localDocAnswer(ctx context.Context, threadID, prompt, focusDocID string) (*SmartResponse, error) {
+	docs := h.localDocuments(threadID)
+	filter := ""
+	names := make([]string, 0, len(docs))
+	for _, d := range docs {
+		if d.ID == strings.TrimSpace(focusDocID) {
+			filter = d.ID
+			names = []string{d.FileName}
+			break
+		}
+		names = append(names, d.FileName)
+	}
+	hits, err := h.docs.Search(ctx, threadID, prompt, localDocHits, filter)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[conv][SmartMessage][doc_only.local] thread=%s docs=%d hits=%d filter=%s", threadID, len(docs), len(hits), filter)
+
+	instructions := buildLocalDocPrompt(prompt, names, hits)
+	// Sin vector store: el contexto del documento va en las instrucciones
+	stream, err := h.AI.StreamResponseWithInstructionsCompatible(ctx, threadID, prompt, instructions, "")
+	if err != nil {
+		return nil, err
+	}
+	source := "doc_only"
+	if filter != "" {
+		source = "focus_doc"
+	}
+	return &SmartResponse{
+		Stream:           stream,
+		Source:           source,
+		AllowedSources:   names,
+		Prompt:           prompt,
+		HasVectorContext: len(hits) > 0,
+		Citations:        readyCitations(citationsFromResults(hitResults(hits), prompt)),
+	}, nil
+}
+
+// buildLocalDocPrompt arma las instrucciones doc-only con los fragmentos encontrados, cada uno
+// con su archivo, página y sección para que el modelo cite con precisión.
+func buildLocalDocPrompt(userPrompt string, docNames []string, hits []docindex.Hit) string {
+	var b strings.Builder
+	b.WriteString("MODO DOCUMENTO PDF: responde ÚNICAMENTE con el contenido de los fragmentos de ")
+	if len(docNames) > 0 {
+		b.WriteString("el documento: " + strings.Join(docNames, ", "))
+	} else {
+		b.WriteString("los PDFs adjuntos al hilo")
+	}
+	b.WriteString(". NO uses conocimiento médico general externo.\n\n")
+	if len(hits) == 0 {
+		b.WriteString("No se encontraron fragmentos relevantes. Indica que el documento no contiene información para responder y sugiere términos alternativos.\n\n")
+	} else {
+		b.WriteString("═══ FRAGMENTOS DEL DOCUMENTO ═══\n")
+		for i, hit := range hits {
+			if hit.Section != "" {
+				fmt.Fprintf(&b, "[%d] %s, p. %d (%s):\n%s\n\n", i+1, hit.FileName, hit.Page, hit.Section, hit.Content)
+			} else {
+				fmt.Fprintf(&b, "[%d] %s, p. %d:\n%s\n\n", i+1, hit.FileName, hit.Page, hit.Content)
+			}
+		}
+	}
+	b.WriteString(`═══ REGLAS DE RESPUESTA ═══
+- Responde con lo que dicen los fragmentos; cita textualmente lo relevante.
+- Si la consulta es vaga, resume los temas que cubren los fragmentos y pregunta qué sección interesa.
+- Si los fragmentos no responden la pregunta, dilo claramente: "El documento no contiene información para responder esta pregunta".
+- Usa Markdown (encabezados, listas, negritas); sin bloques de código, XML ni JSON.
+- Termina con "## Fuentes" y una línea por archivo con las páginas usadas: "- [archivo.pdf], p. X-Y".
+
+Consulta del usuario:
+`)
+	b.WriteString(userPrompt)
+	return b.String()
+}
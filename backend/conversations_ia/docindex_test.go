@@ -0,0 +1,100 @@
+package conversations_ia
+
+import (
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ema-backend/docindex"
+	"ema-backend/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// docIndexAI registra las instrucciones doc-only; las llamadas de vector stores de OpenAI no
+// están implementadas, así que el test falla si el índice local las usa.
+type docIndexAI struct {
+	AIClient
+	instructions string
+	vectorStore  string
+	deleted      []string
+}
+
+func (a *docIndexAI) StreamResponseWithInstructionsCompatible(ctx context.Context, threadID, userMessage, instructions, vectorStoreID string) (<-chan string, error) {
+	a.instructions, a.vectorStore = instructions, vectorStoreID
+	return streamOf("Respuesta."), nil
+}
+
+func (a *docIndexAI) DeleteThreadArtifacts(ctx context.Context, threadID string) error {
+	a.deleted = append(a.deleted, threadID)
+	return nil
+}
+
+func TestSmartMessage_LocalDocumentIndex(t *testing.T) {
+	ctx := context.Background()
+	ai := &docIndexAI{}
+	h := NewHandler(ai)
+	h.SetDocumentIndex(docindex.New(docindex.NewMemoryStore(), llm.NewMock(nil)))
+	doc, err := h.docs.IndexPages(ctx, "thread_doc", "guia.pdf", []string{
+		"Introducción y metodología de la guía de práctica clínica.",
+		"La hipertensión arterial se trata con inhibidores de la enzima convertidora y diuréticos tiazídicos.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.docs.IndexPages(ctx, "thread_doc", "notas.pdf", []string{"Apuntes de neumonía: amoxicilina como primera línea en adultos sanos."})
+
+	if !h.threadHasDocuments(ctx, "thread_doc") {
+		t.Fatal("threadHasDocuments ignores the local index")
+	}
+	resp, err := h.SmartMessage(ctx, "thread_doc", "¿Cómo se trata la hipertensión arterial?", "", TopicSnapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Source != "doc_only" || ai.vectorStore != "" || strings.Join(resp.AllowedSources, ",") != "guia.pdf,notas.pdf" {
+		t.Fatalf("resp = %+v, vector store %q", resp, ai.vectorStore)
+	}
+	first := strings.Index(ai.instructions, "[1] ")
+	if first < 0 || !strings.HasPrefix(ai.instructions[first:], "[1] guia.pdf, p. 2:") {
+		t.Fatalf("instructions = %s", ai.instructions)
+	}
+
+	// focus_doc_id con el id del índice limita la búsqueda a ese documento.
+	resp, _ = h.SmartMessage(ctx, "thread_doc", "¿Cómo se trata la hipertensión arterial?", doc.ID, TopicSnapshot{})
+	if resp.Source != "focus_doc" || strings.Contains(ai.instructions, "notas.pdf") {
+		t.Fatalf("focus resp = %+v\n%s", resp, ai.instructions)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/conversations/delete", h.Delete)
+	if w := serveJSON(r, http.MethodPost, "/conversations/delete", "", map[string]string{"thread_id": "thread_doc"}); w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if len(h.localDocuments("thread_doc")) != 0 {
+		t.Fatal("deleting the thread kept its indexed documents")
+	}
+}
+
+func TestHandlePDFLocal_FallsBackOnUnreadablePDF(t *testing.T) {
+	h := NewHandler(&docIndexAI{})
+	h.SetDocumentIndex(docindex.New(docindex.NewMemoryStore(), llm.NewMock(nil)))
+	tmp := filepath.Join(t.TempDir(), "roto.pdf")
+	os.WriteFile(tmp, []byte("no es un pdf"), 0o644)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/conversations/message", nil)
+	if h.handlePDFLocal(c, "thread_doc", "", &multipart.FileHeader{Filename: "roto.pdf", Size: 12}, tmp, nil, time.Now()) {
+		t.Fatal("unreadable PDF handled locally instead of falling back to OpenAI")
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("fallback wrote a response: %s", w.Body)
+	}
+}
@@ -12,6 +12,7 @@ package conversations_ia
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"mime/multipart"
@@ -25,7 +26,13 @@ import (
 	"sync"
 	"time"
 
+	"ema-backend/docindex"
+	"ema-backend/entitlements"
+	"ema-backend/files"
+	"ema-backend/knowledge"
+	"ema-backend/migrations"
 	"ema-backend/openai"
+	"ema-backend/sse"
 
 	"github.com/gin-gonic/gin"
 )
@@ -71,6 +78,8 @@ type AIClient interface {
 	CreateThreadOrConversation(ctx context.Context) (string, error)
 	StreamResponseWithInstructionsCompatible(ctx context.Context, threadID, userMessage, instructions, vectorStoreID string) (<-chan string, error)
 	StreamMessageWithImageCompatible(ctx context.Context, threadID, prompt, imagePath string) (<-chan string, error)
+	// Detener la respuesta en curso del hilo (botón "detener"); false si no había nada en curso
+	CancelRun(ctx context.Context, threadID string) (bool, error)
 }
 
 // SmartResponse encapsula tanto el stream generado como los metadatos necesarios para validar la respuesta antes de exponerla al usuario.
@@ -84,6 +93,8 @@ type SmartResponse struct {
 	HasVectorContext bool
 	HasPubMedContext bool
 	FallbackReason   string
+	// Citations trae las citas de documentos (doc_only/focus_doc); se leen al terminar el stream
+	Citations <-chan []Citation
 }
 
 // AIClientWithMetadata extiende AIClient con capacidades de metadatos
@@ -97,6 +108,14 @@ type Handler struct {
 	quotaValidator func(ctx context.Context, c *gin.Context, flow string) error
 	topicMu        sync.RWMutex
 	threadTopics   map[string]*topicState
+	// Historial por usuario (ver history.go); nil si no está activado
+	history     ConversationStore
+	users       migrations.UserStore
+	transcripts TranscriptStore
+	// Índice local de PDFs (ver docindex.go); nil = vector stores de OpenAI
+	docs *docindex.Index
+	// OCR de PDFs escaneados (ver ocr.go); nil = se rechazan
+	ocr files.OCR
 }
 
 // topicState persiste la información temática por hilo para mantener coherencia entre preguntas y respuestas.
@@ -219,7 +238,7 @@ func (h *Handler) buildContextualizedQuery(ctx context.Context, threadID, curren
 	}
 
 	// Obtener últimos 3 mensajes del historial (para no sobrecargar)
-	messages, err := h.AI.GetThreadMessages(ctx, threadID, 6) // 3 pares user+assistant
+	messages, err := h.threadMessages(ctx, threadID, 6) // 3 pares user+assistant
 	if err != nil || len(messages) == 0 {
 		log.Printf("[conv][contextualize][no_history] thread=%s using_original_prompt", threadID)
 		return currentPrompt
@@ -260,7 +279,7 @@ func (h *Handler) buildContextualizedQuery(ctx context.Context, threadID, curren
 // para que el Assistant mantenga coherencia temática sin contaminar las búsquedas vectoriales
 func (h *Handler) buildConversationContext(ctx context.Context, threadID string, limit int) string {
 	fetchStart := time.Now()
-	messages, err := h.AI.GetThreadMessages(ctx, threadID, limit)
+	messages, err := h.threadMessages(ctx, threadID, limit)
 	log.Printf("[conv][buildContext][fetch] thread=%s limit=%d elapsed_ms=%d err=%v",
 		threadID, limit, time.Since(fetchStart).Milliseconds(), err)
 	if err != nil || len(messages) == 0 {
@@ -321,6 +340,11 @@ func (h *Handler) SmartMessage(ctx context.Context, threadID, prompt, focusDocID
 	}
 	targetVectorID := booksVectorID()
 
+	// PDFs del índice local: los fragmentos se buscan aquí, sin file_search
+	if len(h.localDocuments(threadID)) > 0 {
+		return h.localDocAnswer(ctx, threadID, prompt, focusDocID)
+	}
+
 	if focusDocID != "" {
 		docOnlyPrompt := fmt.Sprintf(`Responde a la consulta usando EXCLUSIVAMENTE la información contenida en el documento con ID: %s
 
@@ -344,6 +368,7 @@ Instrucciones:
 		}
 		resp.Stream = stream
 		resp.Source = "focus_doc"
+		resp.Citations = h.searchCitations(ctx, vsID, prompt)
 		trimmed := strings.TrimSpace(focusDocID)
 		if trimmed != "" {
 			resp.AllowedSources = append(resp.AllowedSources, trimmed)
@@ -383,6 +408,7 @@ Instrucciones:
 		}
 		resp.Stream = stream
 		resp.Source = "doc_only"
+		resp.Citations = h.searchCitations(ctx, vsID, prompt)
 
 		// Añadir nombres de documentos si los tenemos
 		if len(docNames) > 0 {
@@ -421,7 +447,12 @@ Instrucciones:
 	vectorTime := time.Since(searchStart)
 
 	pubmedStart := time.Now()
-	pdocs := h.buscarPubMed(searchCtx, prompt) // Usar prompt ORIGINAL sin enriquecer
+	var pdocs []Documento
+	if entitlements.Allowed(ctx, entitlements.PubMedSearch) {
+		pdocs = h.buscarPubMed(searchCtx, prompt) // Usar prompt ORIGINAL sin enriquecer
+	} else {
+		log.Printf("[conv][SmartMessage][pubmed][skip] thread=%s reason=feature_locked", threadID)
+	}
 	pubmedTime := time.Since(pubmedStart)
 
 	log.Printf("[conv][SmartMessage][search.timing] thread=%s vector_ms=%d pubmed_ms=%d total_ms=%d",
@@ -856,6 +887,9 @@ func (h *Handler) Start(c *gin.Context) {
 		code := classifyErr(err)
 		// Incluimos más detalles para facilitar debug remoto
 		log.Printf("[conv][Start][error] create_thread_or_conversation code=%s err=%v assistant_id=%s", code, err, h.AI.GetAssistantID())
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		status := http.StatusInternalServerError
 		if code == "assistant_not_configured" {
 			status = http.StatusServiceUnavailable
@@ -871,6 +905,7 @@ func (h *Handler) Start(c *gin.Context) {
 	elapsed := time.Since(start)
 	log.Printf("[conv][Start][ok] thread=%s elapsed_ms=%d", tid, elapsed.Milliseconds())
 	c.Header("X-Assistant-Start-Ms", elapsed.String())
+	h.recordConversation(h.tokenUser(c), tid, "", SourceChat)
 	c.JSON(http.StatusOK, gin.H{"thread_id": tid, "strict_threads": true, "text": ""})
 }
 
@@ -883,6 +918,14 @@ func (h *Handler) Message(c *gin.Context) {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "assistant no configurado"})
 		return
 	}
+	// Funciones del plan (PubMed, imágenes, tamaño de PDF, modelo premium). Se resuelven antes
+	// de la cuota: un adjunto bloqueado por el plan no debe consumir una consulta.
+	entitlements.Attach(c)
+	if feature := lockedAttachmentFeature(c); feature != "" {
+		log.Printf("[conv][Message][locked] feature=%s", feature)
+		entitlements.AbortLocked(c, feature)
+		return
+	}
 	if h.quotaValidator != nil {
 		if err := h.quotaValidator(c.Request.Context(), c, "chat_message"); err != nil {
 			field, _ := c.Get("quota_error_field")
@@ -918,6 +961,7 @@ func (h *Handler) Message(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "parámetros inválidos"})
 		return
 	}
+	h.beginTurn(c, req.ThreadID, req.Prompt, nil)
 	start := time.Now()
 	log.Printf("[conv][Message][json][smart.begin] thread=%s prompt_len=%d prompt_preview=\"%s\"", req.ThreadID, len(req.Prompt), sanitizePreview(req.Prompt))
 
@@ -926,6 +970,9 @@ func (h *Handler) Message(c *gin.Context) {
 	if err != nil {
 		code := classifyErr(err)
 		log.Printf("[conv][Message][json][smart.error] thread=%s code=%s err=%v", req.ThreadID, code, err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		status := http.StatusInternalServerError
 		if code == "assistant_not_configured" {
 			status = http.StatusServiceUnavailable
@@ -949,8 +996,9 @@ func (h *Handler) Message(c *gin.Context) {
 	c.Header("X-Strict-Threads", "1")
 	c.Header("X-Source-Used", source) // Indicar qué fuente se usó
 	if source == "rag" {
-		c.Header("X-Books-Vector-ID", "vs_680fc484cef081918b2b9588b701e2f4")
+		c.Header("X-Books-Vector-ID", booksVectorID())
 	}
+	setCitations(c, resp.Citations)
 	if len(resp.AllowedSources) > 0 {
 		c.Header("X-Allowed-Sources", strings.Join(resp.AllowedSources, ","))
 	}
@@ -979,11 +1027,11 @@ func (h *Handler) Message(c *gin.Context) {
 	switch source {
 	case "doc_only":
 		stages = []string{"__STAGE__:start", "__STAGE__:doc_only", "__STAGE__:streaming_answer"}
-		sseMaybeCapture(c, wrapWithStages(stages, stream), req.ThreadID)
+		h.sseMaybeCapture(c, wrapWithStages(stages, stream), req.ThreadID)
 		return
 	case "smalltalk":
 		stages = []string{"__STAGE__:start", "__STAGE__:smalltalk", "__STAGE__:streaming_answer"}
-		sseMaybeCapture(c, wrapWithStages(stages, stream), req.ThreadID)
+		h.sseMaybeCapture(c, wrapWithStages(stages, stream), req.ThreadID)
 		return
 	case "rag":
 		stages = append(stages, "__STAGE__:rag_found", "__STAGE__:streaming_answer")
@@ -992,7 +1040,7 @@ func (h *Handler) Message(c *gin.Context) {
 	default:
 		stages = append(stages, "__STAGE__:rag_empty", "__STAGE__:no_source", "__STAGE__:streaming_answer")
 	}
-	sseMaybeCapture(c, wrapWithStages(stages, stream), req.ThreadID)
+	h.sseMaybeCapture(c, wrapWithStages(stages, stream), req.ThreadID)
 }
 
 // handleMultipart replica lógica esencial de PDF/audio del chat original, sin fallback Chat Completions.
@@ -1030,6 +1078,7 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "error al recibir archivo", "detail": err.Error()})
 		return
 	}
+	h.beginTurn(c, threadID, prompt, upFile)
 	start := time.Now()
 	log.Printf("[conv][Message][multipart][begin] thread=%s has_file=%v prompt_len=%d", threadID, upFile != nil, len(prompt))
 	if upFile == nil { // solo texto
@@ -1039,6 +1088,9 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		if err != nil {
 			code := classifyErr(err)
 			log.Printf("[conv][Message][multipart][smart.error] thread=%s code=%s err=%v", threadID, code, err)
+			if openai.RespondUnavailable(c, err) {
+				return
+			}
 			status := http.StatusInternalServerError
 			if code == "assistant_not_configured" {
 				status = http.StatusServiceUnavailable
@@ -1061,6 +1113,7 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		c.Header("X-Thread-ID", clientThreadID) // CRÍTICO: Retornar ID original para el frontend
 		c.Header("X-Strict-Threads", "1")
 		c.Header("X-Source-Used", source) // Indicar qué fuente se usó
+		setCitations(c, resp.Citations)
 		if len(resp.AllowedSources) > 0 {
 			c.Header("X-Allowed-Sources", strings.Join(resp.AllowedSources, ","))
 		}
@@ -1083,13 +1136,13 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		stages := []string{"__STAGE__:start", "__STAGE__:rag_search"}
 		if source == "doc_only" {
 			stages = []string{"__STAGE__:start", "__STAGE__:doc_only", "__STAGE__:streaming_answer"}
-			sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+			h.sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
 			return
 		}
 		switch source {
 		case "smalltalk":
 			stages = []string{"__STAGE__:start", "__STAGE__:smalltalk", "__STAGE__:streaming_answer"}
-			sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+			h.sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
 			return
 		case "rag":
 			stages = append(stages, "__STAGE__:rag_found", "__STAGE__:streaming_answer")
@@ -1098,7 +1151,7 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		default:
 			stages = append(stages, "__STAGE__:rag_empty", "__STAGE__:no_source", "__STAGE__:streaming_answer")
 		}
-		sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+		h.sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
 		return
 	}
 	ext := strings.ToLower(filepath.Ext(upFile.Filename))
@@ -1138,6 +1191,9 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		if err != nil {
 			code := classifyErr(err)
 			log.Printf("[conv][Message][multipart][audio.error] thread=%s code=%s err=%v", threadID, code, err)
+			if openai.RespondUnavailable(c, err) {
+				return
+			}
 			status := http.StatusInternalServerError
 			if code == "assistant_not_configured" {
 				status = http.StatusServiceUnavailable
@@ -1161,8 +1217,9 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		c.Header("X-Strict-Threads", "1")
 		c.Header("X-Source-Used", source) // Indicar qué fuente se usó
 		if source == "rag" {
-			c.Header("X-Books-Vector-ID", "vs_680fc484cef081918b2b9588b701e2f4")
+			c.Header("X-Books-Vector-ID", booksVectorID())
 		}
+		setCitations(c, resp.Citations)
 		if len(resp.AllowedSources) > 0 {
 			c.Header("X-Allowed-Sources", strings.Join(resp.AllowedSources, ","))
 		}
@@ -1191,7 +1248,7 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 		default:
 			stages = append(stages, "__STAGE__:rag_empty", "__STAGE__:no_source", "__STAGE__:streaming_answer")
 		}
-		sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+		h.sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
 		return
 	}
 
@@ -1211,6 +1268,9 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 	if err != nil {
 		code := classifyErr(err)
 		log.Printf("[conv][Message][multipart][other.error] thread=%s code=%s err=%v", threadID, code, err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		status := http.StatusInternalServerError
 		if code == "assistant_not_configured" {
 			status = http.StatusServiceUnavailable
@@ -1233,6 +1293,7 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 	c.Header("X-Thread-ID", threadID)
 	c.Header("X-Strict-Threads", "1")
 	c.Header("X-Source-Used", source) // Indicar qué fuente se usó
+	setCitations(c, resp.Citations)
 	if len(resp.AllowedSources) > 0 {
 		c.Header("X-Allowed-Sources", strings.Join(resp.AllowedSources, ","))
 	}
@@ -1261,7 +1322,7 @@ func (h *Handler) handleMultipart(c *gin.Context) {
 	default:
 		stages = append(stages, "__STAGE__:rag_empty", "__STAGE__:no_source", "__STAGE__:streaming_answer")
 	}
-	sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+	h.sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
 }
 
 func (h *Handler) handlePDF(c *gin.Context, threadID, prompt string, upFile *multipart.FileHeader, tmp string, start time.Time) {
@@ -1271,63 +1332,48 @@ func (h *Handler) handlePDF(c *gin.Context, threadID, prompt string, upFile *mul
 		return
 	}
 
-	// Verificar tamaño individual del archivo (100MB = 104857600 bytes)
-	maxFileSizeBytes := int64(100 * 1024 * 1024) // 100MB
+	// Verificar tamaño individual del archivo (100MB = 104857600 bytes, o menos según el plan)
+	maxUploadMB := 100
+	if v, ok := entitlements.Value(c.Request.Context(), entitlements.PDFUploadMaxMB); ok {
+		if v <= 0 {
+			log.Printf("[conv][PDF][locked] thread=%s feature=%s", threadID, entitlements.PDFUploadMaxMB)
+			entitlements.AbortLocked(c, entitlements.PDFUploadMaxMB)
+			return
+		}
+		maxUploadMB = min(maxUploadMB, v)
+	}
+	maxFileSizeBytes := int64(maxUploadMB) * 1024 * 1024
 	if upFile.Size > maxFileSizeBytes {
 		sizeMB := float64(upFile.Size) / (1024 * 1024)
-		log.Printf("[conv][PDF][error] file_too_large thread=%s size_mb=%.1f max_mb=100", threadID, sizeMB)
+		log.Printf("[conv][PDF][error] file_too_large thread=%s size_mb=%.1f max_mb=%d", threadID, sizeMB, maxUploadMB)
 		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
 			"error":       "archivo demasiado grande",
 			"code":        "file_too_large",
-			"detail":      fmt.Sprintf("El archivo pesa %.1f MB. El límite máximo es 100 MB.", sizeMB),
-			"max_size_mb": 100,
+			"detail":      fmt.Sprintf("El archivo pesa %.1f MB. El límite máximo es %d MB.", sizeMB, maxUploadMB),
+			"max_size_mb": maxUploadMB,
 		})
 		return
 	}
 
 	// CRÍTICO: Validar si el PDF tiene texto extraíble ANTES de subirlo a OpenAI
 	// Esto evita desperdiciar tiempo/recursos indexando PDFs escaneados (solo imágenes)
+	var scanned *ocrResult
 	if metadata := h.AI.ExtractPDFMetadataFromPath(tmp); metadata != nil {
 		if !metadata.HasExtractableText {
 			fname := filepath.Base(upFile.Filename)
-			log.Printf("[conv][PDF][scanned_rejected] thread=%s file=%s coverage=%.1f%% pages=%d",
-				threadID, fname, metadata.TextCoveragePercent, metadata.PageCount)
-
-			msg := fmt.Sprintf(`Este documento (**%s**) parece estar compuesto principalmente por imágenes o escaneos (%.1f%% de cobertura de texto en %d páginas), por lo que no se puede leer texto directamente.
-
-**Opciones disponibles:**
-- Sube una versión del documento con texto digital (no escaneado)
-- Si el documento es un escaneo, necesitarás aplicar OCR (Reconocimiento Óptico de Caracteres) antes de subirlo
-- Puedes usar herramientas como Adobe Acrobat, Google Drive, o servicios online de OCR
-
-No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
-				fname, metadata.TextCoveragePercent, metadata.PageCount)
-
-			if v, ok := c.Get("quota_remaining"); ok {
-				c.Header("X-Quota-Remaining", toString(v))
+			if scanned = h.ocrScannedPDF(c, threadID, fname, tmp); scanned == nil {
+				h.rejectScannedPDF(c, threadID, fname, metadata, start)
+				return
 			}
-			c.Header("X-PDF-Scanned", "1")
-			c.Header("X-PDF-Text-Coverage", fmt.Sprintf("%.1f", metadata.TextCoveragePercent))
-			c.Header("X-PDF-Pages", strconv.Itoa(metadata.PageCount))
-			c.Header("X-Assistant-Start-Ms", time.Since(start).String())
-			c.Header("X-Thread-ID", threadID) // Retornar thread ID al frontend
-			c.Header("X-Source-Used", "pdf_scanned_error")
-			log.Printf("[conv][PDF][scanned_response] thread=%s file=%s", threadID, fname)
-
-			one := make(chan string, 1)
-			one <- msg
-			close(one)
-			stages := []string{"__STAGE__:start", "__STAGE__:pdf_validation_failed", "__STAGE__:streaming_answer"}
-			sseMaybeCapture(c, wrapWithStages(stages, one), threadID)
-			return
+		} else {
+			log.Printf("[conv][PDF][text_validated] thread=%s file=%s coverage=%.1f%% pages=%d has_text=true",
+				threadID, upFile.Filename, metadata.TextCoveragePercent, metadata.PageCount)
 		}
-		log.Printf("[conv][PDF][text_validated] thread=%s file=%s coverage=%.1f%% pages=%d has_text=true",
-			threadID, upFile.Filename, metadata.TextCoveragePercent, metadata.PageCount)
 	}
 
 	maxFiles, _ := strconv.Atoi(os.Getenv("VS_MAX_FILES"))
 	maxMB, _ := strconv.Atoi(os.Getenv("VS_MAX_MB"))
-	if maxFiles > 0 && h.AI.CountThreadFiles(threadID) >= maxFiles {
+	if maxFiles > 0 && h.AI.CountThreadFiles(threadID)+len(h.localDocuments(threadID)) >= maxFiles {
 		log.Printf("[conv][PDF][error] max_files thread=%s", threadID)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "límite de archivos alcanzado"})
 		return
@@ -1341,6 +1387,21 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 		}
 	}
 
+	if h.docs != nil && h.handlePDFLocal(c, threadID, prompt, upFile, tmp, scanned.layout(), start) {
+		return
+	}
+	if scanned != nil {
+		// El vector store de OpenAI no lee PDFs escaneados: se sube el texto OCR con marcas de página.
+		txt, err := writeOCRText(tmp, scanned.pages)
+		if err != nil {
+			log.Printf("[conv][PDF][ocr][error] write_text thread=%s err=%v", threadID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
+			return
+		}
+		defer os.Remove(txt)
+		tmp = txt
+	}
+
 	// CRÍTICO: Forzar creación de nuevo vector store en cada upload.
 	// Esto garantiza que OpenAI no mezcle contenido de PDFs antiguos debido a:
 	// 1. Cache/propagación de OpenAI donde ClearVectorStoreFiles reporta "vacío" pero archivos siguen indexados
@@ -1350,6 +1411,9 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 	vsID, err := h.AI.ForceNewVectorStore(c.Request.Context(), threadID)
 	if err != nil {
 		log.Printf("[conv][PDF][error] force_new_vector err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
 		return
 	}
@@ -1358,6 +1422,9 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 	fileID, err := h.AI.UploadAssistantFile(c.Request.Context(), threadID, tmp)
 	if err != nil {
 		log.Printf("[conv][PDF][error] upload err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
 		return
 	}
@@ -1377,6 +1444,9 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 	log.Printf("[conv][PDF][processed] thread=%s file_id=%s process_wait_ms=%d", threadID, fileID, time.Since(pStart).Milliseconds())
 	if err := h.AI.AddFileToVectorStore(c.Request.Context(), vsID, fileID); err != nil {
 		log.Printf("[conv][PDF][error] add_to_vs err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
 		return
 	}
@@ -1494,26 +1564,8 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 	log.Printf("[conv][PDF][post_index_wait] thread=%s wait_complete", threadID)
 
 	h.AI.AddSessionBytes(threadID, upFile.Size)
-	// Consumir cuota de archivo como en chat original
-	if h.quotaValidator != nil {
-		if err := h.quotaValidator(c.Request.Context(), c, "file_upload"); err != nil {
-			field, _ := c.Get("quota_error_field")
-			reason, _ := c.Get("quota_error_reason")
-			resp := gin.H{"error": "file quota exceeded"}
-			if f, ok := field.(string); ok && f != "" {
-				resp["field"] = f
-			}
-			if r, ok := reason.(string); ok && r != "" {
-				resp["reason"] = r
-			}
-			log.Printf("[conv][PDF][quota][denied] field=%v reason=%v", field, reason)
-			c.JSON(http.StatusForbidden, resp)
-			return
-		} else {
-			if v, ok := c.Get("quota_remaining"); ok {
-				log.Printf("[conv][PDF][quota] remaining=%v", v)
-			}
-		}
+	if !h.consumeFileQuota(c) {
+		return
 	}
 	base := strings.TrimSpace(prompt)
 	if base == "" {
@@ -1556,7 +1608,7 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 		one <- msg
 		close(one)
 		stages := []string{"__STAGE__:start", "__STAGE__:doc_only", "__STAGE__:streaming_answer"}
-		sseMaybeCapture(c, wrapWithStages(stages, one), threadID)
+		h.sseMaybeCapture(c, wrapWithStages(stages, one), threadID)
 		return
 	}
 
@@ -1569,6 +1621,9 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 	stream, err := h.AI.StreamResponseWithInstructionsCompatible(c.Request.Context(), threadID, base, p, vsID)
 	if err != nil {
 		log.Printf("[conv][PDF][error] stream err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
 		return
 	}
@@ -1583,19 +1638,84 @@ No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
 	c.Header("X-Thread-ID", threadID)
 	c.Header("X-Strict-Threads", "1")
 	c.Header("X-Source-Used", "doc_only")
+	setCitations(c, h.searchCitations(c.Request.Context(), vsID, base))
 	log.Printf("[conv][PDF][doc_only.stream] thread=%s file=%s elapsed_ms=%d", threadID, upFile.Filename, time.Since(start).Milliseconds())
 	stages := []string{"__STAGE__:start", "__STAGE__:doc_only", "__STAGE__:streaming_answer"}
-	sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+	h.sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+}
+
+// consumeFileQuota consume la cuota de archivo como en chat original; responde 403 y devuelve
+// false si no hay cuota.
+func (h *Handler) consumeFileQuota(c *gin.Context) bool {
+	if h.quotaValidator == nil {
+		return true
+	}
+	if err := h.quotaValidator(c.Request.Context(), c, "file_upload"); err != nil {
+		field, _ := c.Get("quota_error_field")
+		reason, _ := c.Get("quota_error_reason")
+		resp := gin.H{"error": "file quota exceeded"}
+		if f, ok := field.(string); ok && f != "" {
+			resp["field"] = f
+		}
+		if r, ok := reason.(string); ok && r != "" {
+			resp["reason"] = r
+		}
+		log.Printf("[conv][PDF][quota][denied] field=%v reason=%v", field, reason)
+		c.JSON(http.StatusForbidden, resp)
+		return false
+	}
+	if v, ok := c.Get("quota_remaining"); ok {
+		log.Printf("[conv][PDF][quota] remaining=%v", v)
+	}
+	return true
 }
 
 // handleImage procesa imágenes médicas con GPT-4o Vision (sin vector stores).
 // Valida tamaño (20MB max OpenAI), sube la imagen, y hace streaming de análisis vision.
+// rejectScannedPDF responde que el PDF no tiene texto legible (escaneado y sin OCR disponible).
+func (h *Handler) rejectScannedPDF(c *gin.Context, threadID, fname string, metadata *openai.PDFMetadata, start time.Time) {
+	log.Printf("[conv][PDF][scanned_rejected] thread=%s file=%s coverage=%.1f%% pages=%d",
+		threadID, fname, metadata.TextCoveragePercent, metadata.PageCount)
+
+	msg := fmt.Sprintf(`Este documento (**%s**) parece estar compuesto principalmente por imágenes o escaneos (%.1f%% de cobertura de texto en %d páginas), por lo que no se puede leer texto directamente.
+
+**Opciones disponibles:**
+- Sube una versión del documento con texto digital (no escaneado)
+- Si el documento es un escaneo, necesitarás aplicar OCR (Reconocimiento Óptico de Caracteres) antes de subirlo
+- Puedes usar herramientas como Adobe Acrobat, Google Drive, o servicios online de OCR
+
+No puedo buscar ni citar contenido de documentos que solo contienen imágenes.`,
+		fname, metadata.TextCoveragePercent, metadata.PageCount)
+
+	if v, ok := c.Get("quota_remaining"); ok {
+		c.Header("X-Quota-Remaining", toString(v))
+	}
+	c.Header("X-PDF-Scanned", "1")
+	c.Header("X-PDF-Text-Coverage", fmt.Sprintf("%.1f", metadata.TextCoveragePercent))
+	c.Header("X-PDF-Pages", strconv.Itoa(metadata.PageCount))
+	c.Header("X-Assistant-Start-Ms", time.Since(start).String())
+	c.Header("X-Thread-ID", threadID) // Retornar thread ID al frontend
+	c.Header("X-Source-Used", "pdf_scanned_error")
+	log.Printf("[conv][PDF][scanned_response] thread=%s file=%s", threadID, fname)
+
+	one := make(chan string, 1)
+	one <- msg
+	close(one)
+	stages := []string{"__STAGE__:start", "__STAGE__:pdf_validation_failed", "__STAGE__:streaming_answer"}
+	h.sseMaybeCapture(c, wrapWithStages(stages, one), threadID)
+}
+
 func (h *Handler) handleImage(c *gin.Context, threadID, prompt string, upFile *multipart.FileHeader, tmp string, start time.Time) {
 	if upFile.Size <= 0 {
 		log.Printf("[conv][IMAGE][error] empty_file thread=%s", threadID)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "archivo vacío"})
 		return
 	}
+	if !entitlements.Allowed(c.Request.Context(), entitlements.ImageAnalysis) {
+		log.Printf("[conv][IMAGE][locked] thread=%s feature=%s", threadID, entitlements.ImageAnalysis)
+		entitlements.AbortLocked(c, entitlements.ImageAnalysis)
+		return
+	}
 
 	// Verificar tamaño (OpenAI Vision: max 20MB)
 	maxFileSizeBytes := int64(20 * 1024 * 1024) // 20MB
@@ -1649,6 +1769,9 @@ func (h *Handler) handleImage(c *gin.Context, threadID, prompt string, upFile *m
 	stream, err := h.AI.StreamMessageWithImageCompatible(c.Request.Context(), threadID, base, tmp)
 	if err != nil {
 		log.Printf("[conv][IMAGE][error] stream err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
 		return
 	}
@@ -1670,7 +1793,7 @@ func (h *Handler) handleImage(c *gin.Context, threadID, prompt string, upFile *m
 
 	// Stages para el frontend
 	stages := []string{"__STAGE__:start", "__STAGE__:image_upload", "__STAGE__:vision_analysis", "__STAGE__:streaming_answer"}
-	sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
+	h.sseMaybeCapture(c, wrapWithStages(stages, stream), threadID)
 }
 
 // Utilidades
@@ -1682,6 +1805,30 @@ func isAudioExt(ext string) bool {
 	return false
 }
 
+// lockedAttachmentFeature devuelve la función del plan que bloquea el adjunto de un mensaje
+// multipart (imagen sin image_analysis, PDF con pdf_upload_max_mb <= 0), o "" si no hay bloqueo.
+func lockedAttachmentFeature(c *gin.Context) string {
+	if !strings.HasPrefix(c.GetHeader("Content-Type"), "multipart/form-data") {
+		return ""
+	}
+	upFile, err := c.FormFile("file")
+	if err != nil || upFile == nil {
+		return ""
+	}
+	ctx := c.Request.Context()
+	switch ext := strings.ToLower(filepath.Ext(upFile.Filename)); {
+	case isImageExt(ext):
+		if !entitlements.Allowed(ctx, entitlements.ImageAnalysis) {
+			return entitlements.ImageAnalysis
+		}
+	case ext == ".pdf":
+		if v, ok := entitlements.Value(ctx, entitlements.PDFUploadMaxMB); ok && v <= 0 {
+			return entitlements.PDFUploadMaxMB
+		}
+	}
+	return ""
+}
+
 func isImageExt(ext string) bool {
 	switch ext {
 	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
@@ -1738,6 +1885,10 @@ func (h *Handler) threadHasDocuments(ctx context.Context, threadID string) bool
 		return false
 	}
 
+	if len(h.localDocuments(threadID)) > 0 {
+		return true
+	}
+
 	// Primero verificar contador local (actualizado inmediatamente al añadir archivo)
 	// Esto evita el delay de propagación de OpenAI API (10-30s)
 	localCount := h.AI.CountThreadFiles(threadID)
@@ -1759,6 +1910,13 @@ func (h *Handler) threadHasDocuments(ctx context.Context, threadID string) bool
 
 // getThreadDocumentNames obtiene los nombres reales de los archivos en el vector store del thread
 func (h *Handler) getThreadDocumentNames(ctx context.Context, threadID string) []string {
+	if docs := h.localDocuments(threadID); len(docs) > 0 {
+		names := make([]string, len(docs))
+		for i, d := range docs {
+			names[i] = d.FileName
+		}
+		return names
+	}
 	vsID := h.AI.GetVectorStoreID(threadID)
 	if vsID == "" {
 		return []string{}
@@ -2082,7 +2240,8 @@ func normalizeMarkdownFull(text string) string {
 
 // sseStream mínima (duplicada para aislar del paquete chat existente) – reusa formato: cada token -> data: token\n\n
 // HÍBRIDO: Envía chunks SSE para progreso visual + JSON final con texto completo bien formateado
-func sseStream(c *gin.Context, ch <-chan string) {
+// Devuelve ese texto final (el que se transcribe).
+func sseStream(c *gin.Context, ch <-chan string) string {
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
@@ -2092,7 +2251,11 @@ func sseStream(c *gin.Context, ch <-chan string) {
 	// Buffer para acumular texto completo
 	var fullText strings.Builder
 
-	for tok := range ch {
+	for {
+		tok, ok := sse.Next(c.Request.Context(), ch)
+		if !ok {
+			break
+		}
 		if tok == "" {
 			continue
 		}
@@ -2174,6 +2337,11 @@ func sseStream(c *gin.Context, ch <-chan string) {
 		}
 	}
 
+	// Cliente desconectado: el run ya se canceló; devolver lo generado para la transcripción
+	if c.Request.Context().Err() != nil {
+		return normalizeMarkdownFull(fullText.String())
+	}
+
 	// CRÍTICO: Enviar evento FINAL con JSON completo bien formateado
 	// El frontend debe buscar este evento especial "__JSON__:" para obtener el texto definitivo
 	finalText := fullText.String()
@@ -2183,11 +2351,16 @@ func sseStream(c *gin.Context, ch <-chan string) {
 	finalNormalized := normalizeMarkdownFull(finalText)
 
 	// Escapar JSON correctamente
-	jsonBytes, err := json.Marshal(map[string]interface{}{
+	final := map[string]interface{}{
 		"text":          finalNormalized,
 		"char_count":    len(finalNormalized),
 		"newline_count": strings.Count(finalNormalized, "\n"),
-	})
+	}
+	// Citas de documentos (documento, página, sección, fragmento) si la respuesta las tiene
+	if cits := requestCitations(c); len(cits) > 0 {
+		final["citations"] = cits
+	}
+	jsonBytes, err := json.Marshal(final)
 
 	if err == nil {
 		_, _ = c.Writer.Write([]byte("data: __JSON__:" + string(jsonBytes) + "\n\n"))
@@ -2198,12 +2371,14 @@ func sseStream(c *gin.Context, ch <-chan string) {
 	// Marcador de fin de stream
 	_, _ = c.Writer.Write([]byte("data: [DONE]\n\n"))
 	c.Writer.Flush()
+	return finalNormalized
 }
 
 // sseMaybeCapture agrega token final __FULL__ en modo test (TEST_CAPTURE_FULL=1) replicando chat original.
-func sseMaybeCapture(c *gin.Context, ch <-chan string, threadID string) {
+// Al terminar guarda el turno en la transcripción local (ver transcript.go).
+func (h *Handler) sseMaybeCapture(c *gin.Context, ch <-chan string, threadID string) {
 	if os.Getenv("TEST_CAPTURE_FULL") != "1" {
-		sseStream(c, ch)
+		h.saveTurn(c, sseStream(c, ch))
 		return
 	}
 	buf := &strings.Builder{}
@@ -2215,8 +2390,9 @@ func sseMaybeCapture(c *gin.Context, ch <-chan string, threadID string) {
 		}
 		close(proxy)
 	}()
-	sseStream(c, proxy)
+	answer := sseStream(c, proxy)
 	c.Writer.Write([]byte("data: __FULL__ " + sanitize(buf.String()) + "\n\n"))
+	h.saveTurn(c, answer)
 }
 
 // wrapWithStages emits a sequence of stage markers before forwarding tokens from the main stream.
@@ -2761,9 +2937,9 @@ func joinDocTitles(docs []Documento) string {
 	return s
 }
 
-// booksVectorID devuelve el ID canónico del vector de libros; sin dependencia de env
+// booksVectorID devuelve el vector de libros del chat según el registro de bases de conocimiento
 func booksVectorID() string {
-	return "vs_680fc484cef081918b2b9588b701e2f4"
+	return knowledge.VectorStoreFor(knowledge.FlowChat)
 }
 
 // classifyErr produce un code simbólico para facilitar observabilidad lado cliente.
@@ -2773,6 +2949,8 @@ func classifyErr(err error) string {
 	}
 	e := strings.ToLower(err.Error())
 	switch {
+	case errors.Is(err, openai.ErrUpstreamUnavailable):
+		return "upstream_unavailable"
 	case strings.Contains(e, "not configured"):
 		return "assistant_not_configured"
 	case strings.Contains(e, "401") || strings.Contains(e, "unauthorized"):
@@ -2800,10 +2978,59 @@ func (h *Handler) Delete(c *gin.Context) {
 	}
 	log.Printf("[conv][Delete][begin] thread=%s", req.ThreadID)
 	_ = h.AI.DeleteThreadArtifacts(c.Request.Context(), req.ThreadID)
+	h.deleteDocuments(req.ThreadID)
+	if u := h.tokenUser(c); u != nil && h.history != nil {
+		owned, err := h.history.Delete(u.ID, req.ThreadID)
+		if err != nil {
+			log.Printf("[conv][Delete][history][error] thread=%s err=%v", req.ThreadID, err)
+		}
+		if owned {
+			h.deleteTranscript(req.ThreadID)
+		}
+	}
 	log.Printf("[conv][Delete][done] thread=%s", req.ThreadID)
 	c.Status(http.StatusNoContent)
 }
 
+// Cancel: POST /conversations/cancel {thread_id} detiene la respuesta en curso del hilo
+// (botón "detener"). El stream SSE abierto termina con lo generado hasta ese momento. Con
+// historial exige token y que el hilo sea del usuario.
+func (h *Handler) Cancel(c *gin.Context) {
+	var req struct {
+		ThreadID string `json:"thread_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.ThreadID) == "" {
+		log.Printf("[conv][Cancel][error] bind thread_id=%s err=%v", req.ThreadID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "thread_id requerido"})
+		return
+	}
+	// Con historial solo el dueño del hilo puede detener su respuesta
+	if h.history != nil {
+		u := h.historyUser(c)
+		if u == nil {
+			return
+		}
+		cv, err := h.history.Get(u.ID, req.ThreadID)
+		if err != nil {
+			log.Printf("[conv][Cancel][error] get thread=%s user=%d err=%v", req.ThreadID, u.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo cancelar la respuesta"})
+			return
+		}
+		if cv == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "conversación no encontrada"})
+			return
+		}
+	}
+	cancelled, err := h.AI.CancelRun(c.Request.Context(), req.ThreadID)
+	if err != nil {
+		log.Printf("[conv][Cancel][error] thread=%s err=%v", req.ThreadID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": errMsg(err)})
+		return
+	}
+	log.Printf("[conv][Cancel][done] thread=%s cancelled=%v", req.ThreadID, cancelled)
+	c.JSON(http.StatusOK, gin.H{"thread_id": req.ThreadID, "cancelled": cancelled})
+}
+
 // VectorReset: fuerza vector store limpio
 func (h *Handler) VectorReset(c *gin.Context) {
 	var req struct {
@@ -2815,9 +3042,13 @@ func (h *Handler) VectorReset(c *gin.Context) {
 		return
 	}
 	log.Printf("[conv][VectorReset][begin] thread=%s", req.ThreadID)
+	h.deleteDocuments(req.ThreadID)
 	vsID, err := h.AI.ForceNewVectorStore(c.Request.Context(), req.ThreadID)
 	if err != nil {
 		log.Printf("[conv][VectorReset][error] force_new err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
 		return
 	}
@@ -2837,6 +3068,9 @@ func (h *Handler) VectorFiles(c *gin.Context) {
 	files, err := h.AI.ListVectorStoreFiles(c.Request.Context(), threadID)
 	if err != nil {
 		log.Printf("[conv][VectorFiles][error] list err=%v", err)
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(err)})
 		return
 	}
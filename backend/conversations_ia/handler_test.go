@@ -1,8 +1,18 @@
 package conversations_ia
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"ema-backend/entitlements"
+
+	"github.com/gin-gonic/gin"
 )
 
 func TestNormalizeMarkdownFull(t *testing.T) {
@@ -113,3 +123,41 @@ Referencias aquí.
 		t.Error("Hay más de 2 saltos de línea consecutivos")
 	}
 }
+
+// Un adjunto bloqueado por el plan se rechaza antes de validar la cuota: no consume consulta.
+func TestMessage_LockedAttachmentKeepsQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&historyAI{})
+	quota := 5
+	h.SetQuotaValidator(func(ctx context.Context, c *gin.Context, flow string) error {
+		if quota <= 0 {
+			return errors.New("sin cuota")
+		}
+		quota--
+		return nil
+	})
+	plan := entitlements.Set{Values: map[string]int{entitlements.ImageAnalysis: 0, entitlements.PDFUploadMaxMB: 0}}
+
+	for name, want := range map[string]string{"radiografia.png": entitlements.ImageAnalysis, "guia.pdf": entitlements.PDFUploadMaxMB} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("thread_id", "thread_locked")
+		fw, _ := mw.CreateFormFile("file", name)
+		_, _ = fw.Write([]byte("contenido"))
+		_ = mw.Close()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		req := httptest.NewRequest(http.MethodPost, "/conversations/message", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		c.Request = req.WithContext(entitlements.WithSet(req.Context(), plan))
+		h.Message(c)
+
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("%s: %d %s", name, w.Code, w.Body)
+		}
+		if quota != 5 {
+			t.Fatalf("%s: quota = %d, want 5", name, quota)
+		}
+	}
+}
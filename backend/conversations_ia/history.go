@@ -0,0 +1,218 @@
+package conversations_ia
+
+import (
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"ema-backend/login"
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Historial de conversaciones en servidor: cada hilo creado o usado con token queda indexado
+// por usuario, con un título tomado del primer mensaje.
+//   - GET    /conversations?limit=&offset=&archived=1
+//   - PATCH  /conversations/:thread_id {title?, archived?}
+//   - DELETE /conversations/:thread_id (borra también los artifacts y la transcripción del hilo)
+
+const (
+	conversationTitleMaxRunes = 60
+	conversationTitleLimit    = 255
+)
+
+// SetHistory activa el historial. Sin él, los hilos no se indexan y /conversations responde 503.
+func (h *Handler) SetHistory(store ConversationStore, users migrations.UserStore) {
+	h.history = store
+	h.users = users
+}
+
+// conversationTitle resume el primer mensaje en una línea corta, cortando en un límite de palabra.
+func conversationTitle(prompt string) string {
+	title := strings.Join(strings.Fields(prompt), " ")
+	if utf8.RuneCountInString(title) <= conversationTitleMaxRunes {
+		return title
+	}
+	cut := string([]rune(title)[:conversationTitleMaxRunes])
+	if i := strings.LastIndex(cut, " "); i > conversationTitleMaxRunes/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:-") + "…"
+}
+
+// conversationSource clasifica el hilo por el adjunto recibido (chat si no hay archivo).
+func conversationSource(f *multipart.FileHeader) string {
+	if f == nil {
+		return SourceChat
+	}
+	ext := strings.ToLower(filepath.Ext(f.Filename))
+	switch {
+	case ext == ".pdf":
+		return SourcePDF
+	case isImageExt(ext):
+		return SourceImage
+	case isAudioExt(ext):
+		return SourceAudio
+	}
+	return SourceChat
+}
+
+// tokenUser resuelve el usuario del token sin responder: las rutas de chat siguen aceptando
+// llamadas anónimas, que simplemente no se indexan.
+func (h *Handler) tokenUser(c *gin.Context) *migrations.User {
+	if h.users == nil {
+		return nil
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+	email, ok := login.GetEmailFromToken(token)
+	if !ok {
+		return nil
+	}
+	return h.users.GetUserByEmail(email)
+}
+
+// historyUser exige token para las rutas del historial; responde el error y devuelve nil si falta.
+func (h *Handler) historyUser(c *gin.Context) *migrations.User {
+	if h.history == nil || h.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "historial no disponible"})
+		return nil
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token requerido"})
+		return nil
+	}
+	email, ok := login.GetEmailFromToken(token)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sesión inválida"})
+		return nil
+	}
+	u := h.users.GetUserByEmail(email)
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+		return nil
+	}
+	return u
+}
+
+// recordConversation indexa el hilo para el usuario (nada si es anónimo); los fallos solo se
+// registran en el log para no cortar el chat.
+func (h *Handler) recordConversation(u *migrations.User, threadID, prompt, sourceType string) {
+	if h.history == nil || u == nil {
+		return
+	}
+	if err := h.history.Record(u.ID, threadID, conversationTitle(prompt), sourceType); err != nil {
+		log.Printf("[conv][history][error] record thread=%s user=%d err=%v", threadID, u.ID, err)
+	}
+}
+
+// ListConversations: GET /conversations?limit=&offset=&archived=1 (más recientes primero).
+func (h *Handler) ListConversations(c *gin.Context) {
+	u := h.historyUser(c)
+	if u == nil {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	archived, _ := strconv.ParseBool(c.DefaultQuery("archived", "0"))
+	list, total, err := h.history.List(u.ID, archived, limit, offset)
+	if err != nil {
+		log.Printf("[conv][history][error] list user=%d err=%v", u.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo obtener el historial"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": list, "total": total, "limit": limit, "offset": offset})
+}
+
+// UpdateConversation: PATCH /conversations/:thread_id renombra ({title}) y/o archiva ({archived}).
+func (h *Handler) UpdateConversation(c *gin.Context) {
+	u := h.historyUser(c)
+	if u == nil {
+		return
+	}
+	threadID := c.Param("thread_id")
+	var req struct {
+		Title    *string `json:"title"`
+		Archived *bool   `json:"archived"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Title == nil && req.Archived == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parámetros inválidos"})
+		return
+	}
+	var title string
+	if req.Title != nil {
+		title = strings.Join(strings.Fields(*req.Title), " ")
+		if title == "" || utf8.RuneCountInString(title) > conversationTitleLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "título inválido"})
+			return
+		}
+	}
+	found := true
+	var err error
+	if req.Title != nil {
+		found, err = h.history.Rename(u.ID, threadID, title)
+	}
+	if err == nil && found && req.Archived != nil {
+		found, err = h.history.SetArchived(u.ID, threadID, *req.Archived)
+	}
+	if err != nil {
+		log.Printf("[conv][history][error] update thread=%s user=%d err=%v", threadID, u.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo actualizar la conversación"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversación no encontrada"})
+		return
+	}
+	cv, err := h.history.Get(u.ID, threadID)
+	if err != nil || cv == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo actualizar la conversación"})
+		return
+	}
+	c.JSON(http.StatusOK, cv)
+}
+
+// DeleteConversation: DELETE /conversations/:thread_id. Solo el dueño puede borrar; se eliminan
+// los artifacts del hilo (vector store, archivos), la entrada del historial y su transcripción.
+func (h *Handler) DeleteConversation(c *gin.Context) {
+	u := h.historyUser(c)
+	if u == nil {
+		return
+	}
+	threadID := c.Param("thread_id")
+	cv, err := h.history.Get(u.ID, threadID)
+	if err != nil {
+		log.Printf("[conv][history][error] get thread=%s user=%d err=%v", threadID, u.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo borrar la conversación"})
+		return
+	}
+	if cv == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversación no encontrada"})
+		return
+	}
+	if err := h.AI.DeleteThreadArtifacts(c.Request.Context(), threadID); err != nil {
+		log.Printf("[conv][history][warn] delete_artifacts thread=%s err=%v", threadID, err)
+	}
+	h.deleteDocuments(threadID)
+	if _, err := h.history.Delete(u.ID, threadID); err != nil {
+		log.Printf("[conv][history][error] delete thread=%s user=%d err=%v", threadID, u.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo borrar la conversación"})
+		return
+	}
+	h.deleteTranscript(threadID)
+	c.Status(http.StatusNoContent)
+}
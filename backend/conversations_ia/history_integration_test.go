@@ -0,0 +1,106 @@
+package conversations_ia
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"ema-backend/conn/conntest"
+	"ema-backend/migrations"
+)
+
+func TestSQLHistoryStoresMatrix(t *testing.T) {
+	conntest.ForEach(t, func(t *testing.T, db *sql.DB) {
+		ctx := context.Background()
+		m, err := migrations.NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Up(ctx, migrations.MigrateOptions{}); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		t.Cleanup(func() {
+			all, _ := m.Status(ctx)
+			m.Down(ctx, migrations.MigrateOptions{Steps: len(all)})
+		})
+		users := migrations.NewSQLStore(db)
+		for _, email := range []string{"ana@example.com", "luis@example.com"} {
+			if err := users.CreateUser("Ana", "Pérez", email, "hash", "user"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ana, luis := users.GetUserByEmail("ana@example.com").ID, users.GetUserByEmail("luis@example.com").ID
+		s := NewSQLConversationStore(db)
+
+		for _, rec := range []struct{ title, source string }{{"", SourceChat}, {"Dosis de amoxicilina", SourcePDF}, {"Otra", SourceImage}} {
+			if err := s.Record(ana, "thread_a", rec.title, rec.source); err != nil {
+				t.Fatalf("Record: %v", err)
+			}
+		}
+		if err := s.Record(luis, "thread_a", "Intruso", SourceAudio); err != nil {
+			t.Fatalf("Record (other user): %v", err)
+		}
+		if err := s.Record(ana, "thread_b", "Segunda", SourceChat); err != nil {
+			t.Fatal(err)
+		}
+		cv, err := s.Get(ana, "thread_a")
+		if err != nil || cv == nil || cv.Title != "Dosis de amoxicilina" || cv.SourceType != SourcePDF || cv.Archived {
+			t.Fatalf("Get = %+v, %v", cv, err)
+		}
+		if cv, err := s.Get(luis, "thread_a"); err != nil || cv != nil {
+			t.Fatalf("Get (other user) = %+v, %v", cv, err)
+		}
+
+		if ok, err := s.Rename(ana, "thread_a", "Antibióticos"); err != nil || !ok {
+			t.Fatalf("Rename = %v, %v", ok, err)
+		}
+		if ok, err := s.SetArchived(ana, "thread_b", true); err != nil || !ok {
+			t.Fatalf("SetArchived = %v, %v", ok, err)
+		}
+		if ok, err := s.SetArchived(ana, "thread_b", true); err != nil || !ok {
+			t.Fatalf("SetArchived (unchanged) = %v, %v", ok, err)
+		}
+		if ok, err := s.Rename(luis, "thread_a", "x"); err != nil || ok {
+			t.Fatalf("Rename (other user) = %v, %v", ok, err)
+		}
+		list, total, err := s.List(ana, false, 10, 0)
+		if err != nil || total != 1 || list[0].Title != "Antibióticos" {
+			t.Fatalf("List = %+v, %d, %v", list, total, err)
+		}
+		if list, total, err := s.List(ana, true, 10, 0); err != nil || total != 1 || list[0].ThreadID != "thread_b" {
+			t.Fatalf("List archived = %+v, %d, %v", list, total, err)
+		}
+
+		if ok, err := s.Delete(luis, "thread_a"); err != nil || ok {
+			t.Fatalf("Delete (other user) = %v, %v", ok, err)
+		}
+		if ok, err := s.Delete(ana, "thread_a"); err != nil || !ok {
+			t.Fatalf("Delete = %v, %v", ok, err)
+		}
+		if _, total, _ := s.List(ana, false, 10, 0); total != 0 {
+			t.Fatalf("total after delete = %d", total)
+		}
+
+		ts := NewSQLTranscriptStore(db)
+		err = ts.Append(
+			TranscriptMessage{ThreadID: "thread_b", UserID: &ana, Role: "user", Content: "¿Dosis?", Attachment: "receta.pdf"},
+			TranscriptMessage{ThreadID: "thread_b", UserID: &ana, Role: "assistant", Content: "500 mg", Source: "rag", Citations: []string{"Harrison"}, LatencyMs: 1200},
+		)
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := ts.Append(TranscriptMessage{ThreadID: "thread_b", Role: "user", Content: "anónimo"}); err != nil {
+			t.Fatalf("Append (anonymous): %v", err)
+		}
+		msgs, err := ts.Messages("thread_b", 2)
+		if err != nil || len(msgs) != 2 || msgs[0].Content != "500 mg" || msgs[0].Citations[0] != "Harrison" || msgs[0].LatencyMs != 1200 || msgs[1].UserID != nil {
+			t.Fatalf("Messages = %+v, %v", msgs, err)
+		}
+		if err := ts.DeleteThread("thread_b"); err != nil {
+			t.Fatalf("DeleteThread: %v", err)
+		}
+		if msgs, _ := ts.Messages("thread_b", 0); len(msgs) != 0 {
+			t.Fatalf("Messages after delete = %+v", msgs)
+		}
+	})
+}
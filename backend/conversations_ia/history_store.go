@@ -0,0 +1,259 @@
+package conversations_ia
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"ema-backend/conn"
+)
+
+// Conversation es la entrada del índice de hilos de un usuario (tabla conversations).
+type Conversation struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	ThreadID   string    `json:"thread_id"`
+	Title      string    `json:"title"`
+	SourceType string    `json:"source_type"`
+	Archived   bool      `json:"archived"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Tipos de origen de una conversación: el primer adjunto que recibió el hilo (o chat si solo texto).
+const (
+	SourceChat  = "chat"
+	SourcePDF   = "pdf"
+	SourceImage = "image"
+	SourceAudio = "audio"
+)
+
+// ConversationStore guarda quién es dueño de cada hilo y de qué trata.
+// Todas las operaciones van acotadas al usuario: un hilo ajeno se comporta como inexistente.
+type ConversationStore interface {
+	// Record registra el hilo (o lo toca si ya existe): fija el título si aún está vacío,
+	// pasa el origen de chat al del primer adjunto y actualiza updated_at.
+	Record(userID int, threadID, title, sourceType string) error
+	// Get devuelve nil si el hilo no existe o es de otro usuario.
+	Get(userID int, threadID string) (*Conversation, error)
+	// List devuelve una página (más recientes primero) y el total de conversaciones.
+	List(userID int, archived bool, limit, offset int) ([]Conversation, int, error)
+	Rename(userID int, threadID, title string) (bool, error)
+	SetArchived(userID int, threadID string, archived bool) (bool, error)
+	Delete(userID int, threadID string) (bool, error)
+}
+
+var (
+	_ ConversationStore = (*SQLConversationStore)(nil)
+	_ ConversationStore = (*MemoryConversationStore)(nil)
+)
+
+// SQLConversationStore implementa ConversationStore sobre MySQL o Postgres.
+type SQLConversationStore struct {
+	db      *sql.DB
+	dialect conn.Dialect
+}
+
+func NewSQLConversationStore(db *sql.DB) *SQLConversationStore {
+	return &SQLConversationStore{db: db, dialect: conn.DialectOf(db)}
+}
+
+const conversationColumns = "id, user_id, thread_id, title, source_type, archived, created_at, updated_at"
+
+func scanConversation(row interface{ Scan(...any) error }) (*Conversation, error) {
+	var cv Conversation
+	if err := row.Scan(&cv.ID, &cv.UserID, &cv.ThreadID, &cv.Title, &cv.SourceType, &cv.Archived, &cv.CreatedAt, &cv.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &cv, nil
+}
+
+func (s *SQLConversationStore) Record(userID int, threadID, title, sourceType string) error {
+	insert := s.dialect.InsertIgnore("INSERT INTO conversations (user_id, thread_id, title, source_type) VALUES (?, ?, ?, ?)")
+	if _, err := s.db.Exec(insert, userID, threadID, title, sourceType); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`UPDATE conversations SET
+		title = CASE WHEN title = '' THEN ? ELSE title END,
+		source_type = CASE WHEN source_type = 'chat' THEN ? ELSE source_type END,
+		updated_at = NOW()
+		WHERE user_id = ? AND thread_id = ?`, title, sourceType, userID, threadID)
+	return err
+}
+
+func (s *SQLConversationStore) Get(userID int, threadID string) (*Conversation, error) {
+	row := s.db.QueryRow("SELECT "+conversationColumns+" FROM conversations WHERE user_id = ? AND thread_id = ?", userID, threadID)
+	cv, err := scanConversation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return cv, err
+}
+
+func (s *SQLConversationStore) List(userID int, archived bool, limit, offset int) ([]Conversation, int, error) {
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM conversations WHERE user_id = ? AND archived = ?", userID, archived).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.Query("SELECT "+conversationColumns+` FROM conversations
+		WHERE user_id = ? AND archived = ?
+		ORDER BY updated_at DESC, id DESC LIMIT ? OFFSET ?`, userID, archived, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []Conversation{}
+	for rows.Next() {
+		cv, err := scanConversation(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, *cv)
+	}
+	return list, total, rows.Err()
+}
+
+func (s *SQLConversationStore) Rename(userID int, threadID, title string) (bool, error) {
+	if ok, err := s.exists(userID, threadID); !ok {
+		return false, err
+	}
+	_, err := s.db.Exec("UPDATE conversations SET title = ?, updated_at = NOW() WHERE user_id = ? AND thread_id = ?", title, userID, threadID)
+	return err == nil, err
+}
+
+// SetArchived no toca updated_at: archivar no reordena la lista.
+func (s *SQLConversationStore) SetArchived(userID int, threadID string, archived bool) (bool, error) {
+	if ok, err := s.exists(userID, threadID); !ok {
+		return false, err
+	}
+	_, err := s.db.Exec("UPDATE conversations SET archived = ? WHERE user_id = ? AND thread_id = ?", archived, userID, threadID)
+	return err == nil, err
+}
+
+func (s *SQLConversationStore) Delete(userID int, threadID string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM conversations WHERE user_id = ? AND thread_id = ?", userID, threadID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// exists se consulta aparte porque en un UPDATE MySQL solo cuenta como afectadas las filas que cambian.
+func (s *SQLConversationStore) exists(userID int, threadID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM conversations WHERE user_id = ? AND thread_id = ?", userID, threadID).Scan(&n)
+	return n > 0, err
+}
+
+// MemoryConversationStore es un ConversationStore en memoria para tests y ejecuciones sin base.
+type MemoryConversationStore struct {
+	mu     sync.Mutex
+	convs  map[string]*Conversation
+	nextID int
+	now    func() time.Time
+}
+
+func NewMemoryConversationStore() *MemoryConversationStore {
+	return &MemoryConversationStore{convs: map[string]*Conversation{}, now: time.Now}
+}
+
+func (s *MemoryConversationStore) Record(userID int, threadID, title, sourceType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	cv, ok := s.convs[threadID]
+	if !ok {
+		s.nextID++
+		s.convs[threadID] = &Conversation{ID: s.nextID, UserID: userID, ThreadID: threadID, Title: title, SourceType: sourceType, CreatedAt: now, UpdatedAt: now}
+		return nil
+	}
+	if cv.UserID != userID {
+		return nil
+	}
+	if cv.Title == "" {
+		cv.Title = title
+	}
+	if cv.SourceType == SourceChat {
+		cv.SourceType = sourceType
+	}
+	cv.UpdatedAt = now
+	return nil
+}
+
+func (s *MemoryConversationStore) Get(userID int, threadID string) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cv := s.owned(userID, threadID); cv != nil {
+		out := *cv
+		return &out, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryConversationStore) List(userID int, archived bool, limit, offset int) ([]Conversation, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := []Conversation{}
+	for _, cv := range s.convs {
+		if cv.UserID == userID && cv.Archived == archived {
+			all = append(all, *cv)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].UpdatedAt.Equal(all[j].UpdatedAt) {
+			return all[i].UpdatedAt.After(all[j].UpdatedAt)
+		}
+		return all[i].ID > all[j].ID
+	})
+	total := len(all)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return all[offset:end], total, nil
+}
+
+func (s *MemoryConversationStore) Rename(userID int, threadID, title string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cv := s.owned(userID, threadID)
+	if cv == nil {
+		return false, nil
+	}
+	cv.Title, cv.UpdatedAt = title, s.now()
+	return true, nil
+}
+
+func (s *MemoryConversationStore) SetArchived(userID int, threadID string, archived bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cv := s.owned(userID, threadID)
+	if cv == nil {
+		return false, nil
+	}
+	cv.Archived = archived
+	return true, nil
+}
+
+func (s *MemoryConversationStore) Delete(userID int, threadID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owned(userID, threadID) == nil {
+		return false, nil
+	}
+	delete(s.convs, threadID)
+	return true, nil
+}
+
+func (s *MemoryConversationStore) owned(userID int, threadID string) *Conversation {
+	if cv, ok := s.convs[threadID]; ok && cv.UserID == userID {
+		return cv
+	}
+	return nil
+}
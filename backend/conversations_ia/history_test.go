@@ -0,0 +1,212 @@
+package conversations_ia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ema-backend/login"
+	"ema-backend/migrations"
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyAI implementa solo lo que usan Start, el borrado y la lectura del historial; el resto del
+// AIClient queda sin implementar.
+type historyAI struct {
+	AIClient
+	next        int
+	deleted     []string
+	remoteReads int
+}
+
+func (a *historyAI) GetAssistantID() string { return "asst_test" }
+
+func (a *historyAI) CreateThreadOrConversation(ctx context.Context) (string, error) {
+	a.next++
+	return "thread_" + string(rune('a'+a.next-1)), nil
+}
+
+func (a *historyAI) DeleteThreadArtifacts(ctx context.Context, threadID string) error {
+	a.deleted = append(a.deleted, threadID)
+	return nil
+}
+
+func (a *historyAI) GetThreadMessages(ctx context.Context, threadID string, limit int) ([]openai.ThreadMessage, error) {
+	a.remoteReads++
+	return []openai.ThreadMessage{{Role: "user", Content: "remoto"}}, nil
+}
+
+func TestConversationTitle(t *testing.T) {
+	cases := map[string]string{
+		"":                                  "",
+		"  ¿Qué es la\n\ttaquicardia?  ":    "¿Qué es la taquicardia?",
+		strings.Repeat("palabra ", 5) + "x": "palabra palabra palabra palabra palabra x",
+		"Explica la fisiopatología de la insuficiencia cardiaca congestiva en adultos mayores": "Explica la fisiopatología de la insuficiencia cardiaca…",
+	}
+	for in, want := range cases {
+		if got := conversationTitle(in); got != want {
+			t.Errorf("conversationTitle(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMemoryConversationStoreRecord(t *testing.T) {
+	s := NewMemoryConversationStore()
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { clock = clock.Add(time.Minute); return clock }
+
+	s.Record(1, "thread_a", "", SourceChat)
+	s.Record(1, "thread_b", "Primera", SourceChat)
+	s.Record(1, "thread_a", "Dosis de amoxicilina", SourcePDF)
+	s.Record(1, "thread_a", "Otra pregunta", SourceImage)
+	s.Record(2, "thread_a", "Intruso", SourceAudio)
+
+	list, total, _ := s.List(1, false, 10, 0)
+	if total != 2 || list[0].ThreadID != "thread_a" {
+		t.Fatalf("List = %+v, total %d", list, total)
+	}
+	if a := list[0]; a.Title != "Dosis de amoxicilina" || a.SourceType != SourcePDF {
+		t.Fatalf("thread_a = %+v; want first title and first attachment kind", a)
+	}
+	if cv, _ := s.Get(2, "thread_a"); cv != nil {
+		t.Fatalf("another user can read thread_a: %+v", cv)
+	}
+	if page, total, _ := s.List(1, false, 1, 1); total != 2 || len(page) != 1 || page[0].ThreadID != "thread_b" {
+		t.Fatalf("second page = %+v, total %d", page, total)
+	}
+}
+
+func newHistoryRouter(t *testing.T) (*gin.Engine, *Handler, *historyAI, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	users := migrations.NewMemoryStore()
+	users.AddUser(migrations.User{FirstName: "Ana", Email: "ana@example.com", Password: "pw"})
+	users.AddUser(migrations.User{FirstName: "Luis", Email: "luis@example.com", Password: "pw"})
+	ai := &historyAI{}
+	h := NewHandler(ai)
+	h.SetHistory(NewMemoryConversationStore(), users)
+	h.SetTranscripts(NewMemoryTranscriptStore())
+
+	r := gin.New()
+	login.NewHandler(users).RegisterRoutes(r)
+	r.POST("/conversations/start", h.Start)
+	r.POST("/conversations/delete", h.Delete)
+	r.GET("/conversations", h.ListConversations)
+	r.GET("/conversations/:thread_id/messages", h.ConversationMessages)
+	r.PATCH("/conversations/:thread_id", h.UpdateConversation)
+	r.DELETE("/conversations/:thread_id", h.DeleteConversation)
+	return r, h, ai, loginToken(t, r, "ana@example.com")
+}
+
+func loginToken(t *testing.T, r *gin.Engine, email string) string {
+	t.Helper()
+	w := serveJSON(r, http.MethodPost, "/login", "", login.Credentials{Email: email, Password: "pw"})
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Token == "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	return res.Token
+}
+
+func serveJSON(r *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+type conversationPage struct {
+	Conversations []Conversation `json:"conversations"`
+	Total         int            `json:"total"`
+}
+
+func listConversations(t *testing.T, r *gin.Engine, token, query string) conversationPage {
+	t.Helper()
+	w := serveJSON(r, http.MethodGet, "/conversations"+query, token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /conversations%s: %d %s", query, w.Code, w.Body)
+	}
+	var page conversationPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestConversationHistoryEndpoints(t *testing.T) {
+	r, h, ai, token := newHistoryRouter(t)
+
+	// Start sin token sigue funcionando pero no indexa el hilo
+	if w := serveJSON(r, http.MethodPost, "/conversations/start", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("anonymous start: %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if w := serveJSON(r, http.MethodPost, "/conversations/start", token, nil); w.Code != http.StatusOK {
+			t.Fatalf("start: %d %s", w.Code, w.Body)
+		}
+	}
+	h.history.Record(1, "thread_b", conversationTitle("¿Cuál es la dosis de paracetamol?"), SourceChat)
+
+	page := listConversations(t, r, token, "")
+	if page.Total != 2 || page.Conversations[0].ThreadID != "thread_b" || page.Conversations[0].Title != "¿Cuál es la dosis de paracetamol?" {
+		t.Fatalf("history = %+v", page)
+	}
+	if w := serveJSON(r, http.MethodGet, "/conversations", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: %d", w.Code)
+	}
+
+	if w := serveJSON(r, http.MethodPatch, "/conversations/thread_c", token, gin.H{"title": "  Farmacología  "}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title":"Farmacología"`) {
+		t.Fatalf("rename: %d %s", w.Code, w.Body)
+	}
+	if w := serveJSON(r, http.MethodPatch, "/conversations/thread_c", token, gin.H{"title": " "}); w.Code != http.StatusBadRequest {
+		t.Fatalf("empty title: %d", w.Code)
+	}
+	if w := serveJSON(r, http.MethodPatch, "/conversations/thread_b", token, gin.H{"archived": true}); w.Code != http.StatusOK {
+		t.Fatalf("archive: %d %s", w.Code, w.Body)
+	}
+	if page := listConversations(t, r, token, "?archived=1"); page.Total != 1 || page.Conversations[0].ThreadID != "thread_b" {
+		t.Fatalf("archived = %+v", page)
+	}
+	if page := listConversations(t, r, token, "?limit=1&offset=0"); page.Total != 1 || page.Conversations[0].ThreadID != "thread_c" {
+		t.Fatalf("active = %+v", page)
+	}
+
+	// Otro usuario no ve, renombra ni borra hilos ajenos
+	other := loginToken(t, r, "luis@example.com")
+	if page := listConversations(t, r, other, ""); page.Total != 0 {
+		t.Fatalf("other user history = %+v", page)
+	}
+	if w := serveJSON(r, http.MethodPatch, "/conversations/thread_c", other, gin.H{"title": "x"}); w.Code != http.StatusNotFound {
+		t.Fatalf("foreign rename: %d", w.Code)
+	}
+	if w := serveJSON(r, http.MethodDelete, "/conversations/thread_c", other, nil); w.Code != http.StatusNotFound || len(ai.deleted) != 0 {
+		t.Fatalf("foreign delete: %d, artifacts deleted %v", w.Code, ai.deleted)
+	}
+
+	if w := serveJSON(r, http.MethodDelete, "/conversations/thread_c", token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if len(ai.deleted) != 1 || ai.deleted[0] != "thread_c" {
+		t.Fatalf("DeleteThreadArtifacts calls = %v", ai.deleted)
+	}
+	if w := serveJSON(r, http.MethodPost, "/conversations/delete", token, gin.H{"thread_id": "thread_b"}); w.Code != http.StatusNoContent {
+		t.Fatalf("legacy delete: %d", w.Code)
+	}
+	if page := listConversations(t, r, token, "?archived=true"); page.Total != 0 {
+		t.Fatalf("history after deletes = %+v", page)
+	}
+}
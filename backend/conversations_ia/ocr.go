@@ -0,0 +1,90 @@
+package conversations_ia
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ema-backend/files"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OCR de PDFs escaneados (OCR_ENABLED=1): las páginas sin capa de texto se rasterizan y se leen
+// con Tesseract (español + inglés). Las páginas con confianza baja se descartan. El texto va al
+// índice local si está activo; si no, se sube al vector store de OpenAI como .txt con marcas de
+// página. Sin texto utilizable, el PDF se rechaza como antes.
+
+// SetOCR activa el OCR para PDFs escaneados.
+func (h *Handler) SetOCR(o files.OCR) {
+	h.ocr = o
+}
+
+// ocrResult es el texto de un PDF escaneado después del OCR.
+type ocrResult struct {
+	pages      []files.PageText
+	ocrPages   int
+	confidence float64 // media de las páginas leídas con OCR
+}
+
+// layout devuelve las páginas con sus encabezados (nil si no hubo OCR).
+func (r *ocrResult) layout() []files.PageText {
+	if r == nil {
+		return nil
+	}
+	return r.pages
+}
+
+// ocrScannedPDF aplica OCR a un PDF sin capa de texto. Devuelve nil si el OCR no está activo o
+// no produjo texto utilizable.
+func (h *Handler) ocrScannedPDF(c *gin.Context, threadID, fname, tmp string) *ocrResult {
+	if h.ocr == nil {
+		return nil
+	}
+	start := time.Now()
+	pages, err := files.ExtractPDFPagesOCR(c.Request.Context(), tmp, h.ocr, files.OCROptionsFromEnv())
+	if err != nil {
+		log.Printf("[conv][PDF][ocr][error] thread=%s file=%s err=%v", threadID, fname, err)
+		return nil
+	}
+	res := &ocrResult{pages: pages}
+	for _, p := range pages {
+		if p.OCR {
+			res.ocrPages++
+			res.confidence += p.Confidence
+		}
+	}
+	if res.ocrPages == 0 {
+		log.Printf("[conv][PDF][ocr][empty] thread=%s file=%s pages=%d elapsed_ms=%d", threadID, fname, len(pages), time.Since(start).Milliseconds())
+		return nil
+	}
+	res.confidence /= float64(res.ocrPages)
+	log.Printf("[conv][PDF][ocr][ok] thread=%s file=%s pages=%d ocr_pages=%d confidence=%.1f elapsed_ms=%d",
+		threadID, fname, len(pages), res.ocrPages, res.confidence, time.Since(start).Milliseconds())
+	c.Header("X-PDF-OCR", "1")
+	c.Header("X-PDF-OCR-Pages", strconv.Itoa(res.ocrPages))
+	c.Header("X-PDF-OCR-Confidence", fmt.Sprintf("%.1f", res.confidence))
+	return res
+}
+
+// writeOCRText escribe el texto de pages junto a tmp (mismo nombre, extensión .txt), con una marca
+// por página para que las respuestas puedan citar la página.
+func writeOCRText(tmp string, pages []files.PageText) (string, error) {
+	var b strings.Builder
+	for _, p := range pages {
+		text := strings.TrimSpace(p.Text)
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "[Página %d]\n%s\n\n", p.Page, text)
+	}
+	path := strings.TrimSuffix(tmp, filepath.Ext(tmp)) + ".txt"
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,145 @@
+package conversations_ia
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ema-backend/docindex"
+	"ema-backend/files"
+	"ema-backend/llm"
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scannedAI reporta cualquier PDF como escaneado (sin capa de texto).
+type scannedAI struct {
+	docIndexAI
+}
+
+func (a *scannedAI) ExtractPDFMetadataFromPath(filePath string) *openai.PDFMetadata {
+	return &openai.PDFMetadata{PageCount: 2, HasExtractableText: false}
+}
+
+func (a *scannedAI) AddSessionBytes(threadID string, delta int64) {}
+
+// pageOCR devuelve el mismo texto para todas las páginas.
+type pageOCR struct {
+	text string
+	conf float64
+}
+
+func (o pageOCR) Recognize(ctx context.Context, pdfPath string, page int) (string, float64, error) {
+	return fmt.Sprintf("%s (página %d)", o.text, page), o.conf, nil
+}
+
+// blankPDF escribe un PDF válido de n páginas sin contenido, como un escaneo sin capa de texto.
+func blankPDF(t *testing.T, n int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, n)
+	for i := range kids {
+		kids[i] = fmt.Sprintf("%d 0 R", i+3)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n))
+	for i := 0; i < n; i++ {
+		obj("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>")
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	path := filepath.Join(t.TempDir(), "escaneo.pdf")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func pdfRequest() (*httptest.ResponseRecorder, *gin.Context) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/conversations/message", nil)
+	return w, c
+}
+
+func TestHandlePDF_ScannedWithOCRIndexesText(t *testing.T) {
+	tmp := blankPDF(t, 2)
+	h := NewHandler(&scannedAI{})
+	h.SetDocumentIndex(docindex.New(docindex.NewMemoryStore(), llm.NewMock(nil)))
+	h.SetOCR(pageOCR{text: "Manejo de la cetoacidosis diabética con insulina intravenosa", conf: 87})
+
+	w, c := pdfRequest()
+	h.handlePDF(c, "thread_scan", "", &multipart.FileHeader{Filename: "escaneo.pdf", Size: 2048}, tmp, time.Now())
+
+	if w.Code != http.StatusOK || w.Header().Get("X-PDF-Scanned") != "" {
+		t.Fatalf("status %d, headers %v: %s", w.Code, w.Header(), w.Body)
+	}
+	if w.Header().Get("X-PDF-OCR") != "1" || w.Header().Get("X-PDF-OCR-Pages") != "2" || w.Header().Get("X-PDF-OCR-Confidence") != "87.0" {
+		t.Fatalf("OCR headers = %v", w.Header())
+	}
+	hits, err := h.docs.Search(context.Background(), "thread_scan", "cetoacidosis diabética", 3, "")
+	if err != nil || len(hits) == 0 || !strings.Contains(hits[0].Content, "insulina intravenosa") {
+		t.Fatalf("OCR text not indexed: %+v %v", hits, err)
+	}
+}
+
+func TestHandlePDF_ScannedRejectedWithoutUsableOCR(t *testing.T) {
+	tmp := blankPDF(t, 1)
+	for name, ocr := range map[string]files.OCR{
+		"disabled":       nil,
+		"low_confidence": pageOCR{text: "ruido", conf: 12},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := NewHandler(&scannedAI{})
+			h.SetDocumentIndex(docindex.New(docindex.NewMemoryStore(), llm.NewMock(nil)))
+			h.SetOCR(ocr)
+			w, c := pdfRequest()
+			h.handlePDF(c, "thread_scan", "", &multipart.FileHeader{Filename: "escaneo.pdf", Size: 2048}, tmp, time.Now())
+			if w.Header().Get("X-PDF-Scanned") != "1" || w.Header().Get("X-PDF-OCR") != "" {
+				t.Fatalf("headers = %v", w.Header())
+			}
+			if len(h.localDocuments("thread_scan")) != 0 {
+				t.Fatal("scanned PDF indexed without OCR text")
+			}
+		})
+	}
+}
+
+func TestWriteOCRText(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "escaneo.pdf")
+	path, err := writeOCRText(tmp, []files.PageText{
+		{Page: 1, Text: "Portada", OCR: true, Confidence: 90},
+		{Page: 2},
+		{Page: 3, Text: " Dosis pediátricas \n", OCR: true, Confidence: 75},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "escaneo.txt" {
+		t.Fatalf("path = %s", path)
+	}
+	data, _ := os.ReadFile(path)
+	if want := "[Página 1]\nPortada\n\n[Página 3]\nDosis pediátricas\n\n"; string(data) != want {
+		t.Fatalf("text = %q, want %q", data, want)
+	}
+}
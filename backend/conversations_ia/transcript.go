@@ -0,0 +1,183 @@
+package conversations_ia
+
+import (
+	"context"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Transcripción local: cada turno (prompt + respuesta final ya normalizada) se guarda al terminar
+// el stream, con la fuente usada, las citas y la latencia. El historial se lee de aquí sin llamar
+// a OpenAI y sobrevive a la limpieza de threads/vector stores.
+//   - GET /conversations/:thread_id/messages?limit=
+
+const transcriptTurnKey = "conv_transcript_turn"
+
+// transcriptTurn es el turno en curso, guardado en el contexto de gin desde que se valida el
+// mensaje hasta que el stream termina.
+type transcriptTurn struct {
+	threadID   string
+	userID     *int
+	prompt     string
+	attachment string
+	start      time.Time
+}
+
+// SetTranscripts activa la transcripción local de los mensajes.
+func (h *Handler) SetTranscripts(store TranscriptStore) {
+	h.transcripts = store
+}
+
+// beginTurn indexa el hilo en el historial del usuario y abre el turno que se transcribe al
+// terminar la respuesta.
+func (h *Handler) beginTurn(c *gin.Context, threadID, prompt string, upFile *multipart.FileHeader) {
+	u := h.tokenUser(c)
+	titleFrom := prompt
+	if strings.TrimSpace(titleFrom) == "" && upFile != nil {
+		titleFrom = upFile.Filename
+	}
+	h.recordConversation(u, threadID, titleFrom, conversationSource(upFile))
+	if h.transcripts == nil {
+		return
+	}
+	turn := &transcriptTurn{threadID: threadID, prompt: prompt, start: time.Now()}
+	if u != nil {
+		turn.userID = &u.ID
+	}
+	if upFile != nil {
+		turn.attachment = upFile.Filename
+	}
+	c.Set(transcriptTurnKey, turn)
+}
+
+// saveTurn guarda el prompt y la respuesta final del turno abierto por beginTurn. La fuente y las
+// citas se toman de las cabeceras X-* que ya se enviaron al cliente.
+func (h *Handler) saveTurn(c *gin.Context, answer string) {
+	v, _ := c.Get(transcriptTurnKey)
+	turn, _ := v.(*transcriptTurn)
+	if turn == nil || h.transcripts == nil {
+		return
+	}
+	c.Set(transcriptTurnKey, nil)
+	msgs := []TranscriptMessage{{ThreadID: turn.threadID, UserID: turn.userID, Role: "user", Content: turn.prompt, Attachment: turn.attachment}}
+	if answer = strings.TrimSpace(answer); answer != "" {
+		hdr := c.Writer.Header()
+		msgs = append(msgs, TranscriptMessage{
+			ThreadID:  turn.threadID,
+			UserID:    turn.userID,
+			Role:      "assistant",
+			Content:   answer,
+			Source:    hdr.Get("X-Source-Used"),
+			Citations: transcriptCitations(hdr, answer, requestCitations(c)),
+			LatencyMs: time.Since(turn.start).Milliseconds(),
+		})
+	}
+	if err := h.transcripts.Append(msgs...); err != nil {
+		log.Printf("[conv][transcript][error] thread=%s err=%v", turn.threadID, err)
+	}
+}
+
+// transcriptCitations junta las citas de documentos (con página), o los libros y referencias
+// PubMed informados en las cabeceras; si no hay, extrae las líneas con forma de referencia de
+// la respuesta.
+func transcriptCitations(hdr http.Header, answer string, docs []Citation) []string {
+	var out []string
+	for _, ct := range docs {
+		out = append(out, ct.label())
+	}
+	if len(out) > 0 {
+		return out
+	}
+	seen := map[string]bool{}
+	for _, key := range []string{"X-Vector-Books-Used", "X-PubMed-References"} {
+		for _, ref := range strings.Split(hdr.Get(key), " | ") {
+			if ref = strings.TrimSpace(ref); ref != "" && !seen[ref] {
+				seen[ref] = true
+				out = append(out, ref)
+			}
+		}
+	}
+	if len(out) == 0 {
+		out = extractReferenceLines(answer)
+	}
+	return out
+}
+
+// threadMessages devuelve el historial del hilo desde la transcripción local y solo recurre a
+// OpenAI para hilos anteriores a ella. Ambas fuentes salen del más antiguo al más reciente:
+// buildContextualizedQuery y buildConversationContext leen los últimos turnos desde el final.
+func (h *Handler) threadMessages(ctx context.Context, threadID string, limit int) ([]openai.ThreadMessage, error) {
+	if h.transcripts != nil {
+		local, err := h.transcripts.Messages(threadID, limit)
+		if err != nil {
+			log.Printf("[conv][transcript][error] read thread=%s err=%v", threadID, err)
+		}
+		if len(local) > 0 {
+			out := make([]openai.ThreadMessage, len(local))
+			for i, m := range local {
+				out[i] = openai.ThreadMessage{Role: m.Role, Content: m.Content, CreatedAt: m.CreatedAt.Unix()}
+			}
+			return out, nil
+		}
+	}
+	remote, err := h.AI.GetThreadMessages(ctx, threadID, limit)
+	if err != nil {
+		return nil, err
+	}
+	// La API lista los mensajes con order=desc; se ordenan por fecha para no depender del cliente
+	sort.SliceStable(remote, func(i, j int) bool { return remote[i].CreatedAt < remote[j].CreatedAt })
+	return remote, nil
+}
+
+// ConversationMessages: GET /conversations/:thread_id/messages?limit= (solo el dueño del hilo).
+func (h *Handler) ConversationMessages(c *gin.Context) {
+	u := h.historyUser(c)
+	if u == nil {
+		return
+	}
+	if h.transcripts == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "historial no disponible"})
+		return
+	}
+	threadID := c.Param("thread_id")
+	cv, err := h.history.Get(u.ID, threadID)
+	if err != nil {
+		log.Printf("[conv][history][error] get thread=%s user=%d err=%v", threadID, u.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo obtener el historial"})
+		return
+	}
+	if cv == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversación no encontrada"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	msgs, err := h.transcripts.Messages(threadID, limit)
+	if err != nil {
+		log.Printf("[conv][transcript][error] read thread=%s err=%v", threadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no se pudo obtener el historial"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversation": cv, "messages": msgs})
+}
+
+// deleteTranscript borra la transcripción cuando el usuario borra el hilo.
+func (h *Handler) deleteTranscript(threadID string) {
+	if h.transcripts == nil {
+		return
+	}
+	if err := h.transcripts.DeleteThread(threadID); err != nil {
+		log.Printf("[conv][transcript][error] delete thread=%s err=%v", threadID, err)
+	}
+}
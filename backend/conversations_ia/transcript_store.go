@@ -0,0 +1,152 @@
+package conversations_ia
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// TranscriptMessage es un mensaje guardado localmente (tabla conversation_messages): el prompt
+// del usuario o la respuesta final del assistant tal como se envió al cliente.
+type TranscriptMessage struct {
+	ID         int64     `json:"id"`
+	ThreadID   string    `json:"thread_id"`
+	UserID     *int      `json:"user_id,omitempty"`
+	Role       string    `json:"role"` // "user" o "assistant"
+	Content    string    `json:"content"`
+	Attachment string    `json:"attachment,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Citations  []string  `json:"citations,omitempty"`
+	LatencyMs  int64     `json:"latency_ms,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TranscriptStore guarda las transcripciones de los hilos, independientes del thread de OpenAI.
+type TranscriptStore interface {
+	// Append guarda los mensajes de un turno en orden.
+	Append(msgs ...TranscriptMessage) error
+	// Messages devuelve los últimos limit mensajes del hilo en orden cronológico (todos si limit <= 0).
+	Messages(threadID string, limit int) ([]TranscriptMessage, error)
+	DeleteThread(threadID string) error
+}
+
+var (
+	_ TranscriptStore = (*SQLTranscriptStore)(nil)
+	_ TranscriptStore = (*MemoryTranscriptStore)(nil)
+)
+
+// SQLTranscriptStore implementa TranscriptStore sobre MySQL o Postgres.
+type SQLTranscriptStore struct {
+	db *sql.DB
+}
+
+func NewSQLTranscriptStore(db *sql.DB) *SQLTranscriptStore {
+	return &SQLTranscriptStore{db: db}
+}
+
+func (s *SQLTranscriptStore) Append(msgs ...TranscriptMessage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range msgs {
+		var citations sql.NullString
+		if len(m.Citations) > 0 {
+			b, _ := json.Marshal(m.Citations)
+			citations = sql.NullString{String: string(b), Valid: true}
+		}
+		if _, err := tx.Exec(`INSERT INTO conversation_messages
+			(thread_id, user_id, role, content, attachment, source, citations, latency_ms)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			m.ThreadID, m.UserID, m.Role, m.Content, m.Attachment, m.Source, citations, m.LatencyMs); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLTranscriptStore) Messages(threadID string, limit int) ([]TranscriptMessage, error) {
+	query := `SELECT id, thread_id, user_id, role, content, attachment, source, citations, latency_ms, created_at
+		FROM conversation_messages WHERE thread_id = ? ORDER BY id DESC`
+	args := []any{threadID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []TranscriptMessage{}
+	for rows.Next() {
+		var m TranscriptMessage
+		var userID sql.NullInt64
+		var citations sql.NullString
+		if err := rows.Scan(&m.ID, &m.ThreadID, &userID, &m.Role, &m.Content, &m.Attachment, &m.Source, &citations, &m.LatencyMs, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			m.UserID = &id
+		}
+		if citations.Valid && citations.String != "" {
+			_ = json.Unmarshal([]byte(citations.String), &m.Citations)
+		}
+		list = append(list, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Se leyó del más nuevo al más viejo para aplicar el límite
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, nil
+}
+
+func (s *SQLTranscriptStore) DeleteThread(threadID string) error {
+	_, err := s.db.Exec("DELETE FROM conversation_messages WHERE thread_id = ?", threadID)
+	return err
+}
+
+// MemoryTranscriptStore es un TranscriptStore en memoria para tests y ejecuciones sin base.
+type MemoryTranscriptStore struct {
+	mu     sync.Mutex
+	msgs   map[string][]TranscriptMessage
+	nextID int64
+}
+
+func NewMemoryTranscriptStore() *MemoryTranscriptStore {
+	return &MemoryTranscriptStore{msgs: map[string][]TranscriptMessage{}}
+}
+
+func (s *MemoryTranscriptStore) Append(msgs ...TranscriptMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		s.nextID++
+		m.ID, m.CreatedAt = s.nextID, time.Now()
+		s.msgs[m.ThreadID] = append(s.msgs[m.ThreadID], m)
+	}
+	return nil
+}
+
+func (s *MemoryTranscriptStore) Messages(threadID string, limit int) ([]TranscriptMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := s.msgs[threadID]
+	if limit > 0 && len(all) > limit {
+		all = all[len(all)-limit:]
+	}
+	return append([]TranscriptMessage{}, all...), nil
+}
+
+func (s *MemoryTranscriptStore) DeleteThread(threadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.msgs, threadID)
+	return nil
+}
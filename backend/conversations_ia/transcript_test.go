@@ -0,0 +1,111 @@
+package conversations_ia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+func streamOf(tokens ...string) <-chan string {
+	ch := make(chan string, len(tokens))
+	for _, t := range tokens {
+		ch <- t
+	}
+	close(ch)
+	return ch
+}
+
+// sendTurn simula un mensaje: abre el turno, fija las cabeceras de fuente y transmite la respuesta.
+func sendTurn(h *Handler, token, threadID, prompt string, headers map[string]string, tokens ...string) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/conversations/message", nil)
+	if token != "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	h.beginTurn(c, threadID, prompt, nil)
+	for k, v := range headers {
+		c.Header(k, v)
+	}
+	h.sseMaybeCapture(c, wrapWithStages([]string{"__STAGE__:start"}, streamOf(tokens...)), threadID)
+}
+
+func TestTranscriptSavedAfterStream(t *testing.T) {
+	r, h, ai, token := newHistoryRouter(t)
+
+	sendTurn(h, token, "thread_a", "¿Dosis de ibuprofeno?", map[string]string{
+		"X-Source-Used":       "rag",
+		"X-Vector-Books-Used": "Goodman & Gilman | Harrison",
+	}, "Respuesta", " final.")
+	sendTurn(h, "", "thread_z", "Hola", nil) // anónimo y sin respuesta
+
+	msgs, _ := h.transcripts.Messages("thread_a", 0)
+	if len(msgs) != 2 || msgs[0].Role != "user" || msgs[0].Content != "¿Dosis de ibuprofeno?" || msgs[0].UserID == nil {
+		t.Fatalf("transcript = %+v", msgs)
+	}
+	if a := msgs[1]; a.Role != "assistant" || a.Content != "Respuesta final." || a.Source != "rag" || len(a.Citations) != 2 || a.Citations[1] != "Harrison" {
+		t.Fatalf("assistant message = %+v", a)
+	}
+	if anon, _ := h.transcripts.Messages("thread_z", 0); len(anon) != 1 || anon[0].UserID != nil {
+		t.Fatalf("anonymous transcript = %+v", anon)
+	}
+
+	// El contexto conversacional sale de la transcripción local, sin llamar a OpenAI
+	if got, _ := h.threadMessages(context.Background(), "thread_a", 6); len(got) != 2 || ai.remoteReads != 0 {
+		t.Fatalf("threadMessages = %+v, remote reads %d", got, ai.remoteReads)
+	}
+	if got, _ := h.threadMessages(context.Background(), "thread_old", 6); len(got) != 1 || ai.remoteReads != 1 {
+		t.Fatalf("threadMessages without transcript = %+v, remote reads %d", got, ai.remoteReads)
+	}
+	if got, _ := h.threadMessages(context.Background(), "thread_a", 6); got[0].Role != "user" || got[1].Role != "assistant" {
+		t.Fatalf("local threadMessages order = %+v", got)
+	}
+
+	w := serveJSON(r, http.MethodGet, "/conversations/thread_a/messages", token, nil)
+	var res struct {
+		Conversation Conversation        `json:"conversation"`
+		Messages     []TranscriptMessage `json:"messages"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil || len(res.Messages) != 2 || res.Conversation.Title != "¿Dosis de ibuprofeno?" {
+		t.Fatalf("GET messages: %d %s", w.Code, w.Body)
+	}
+	if w := serveJSON(r, http.MethodGet, "/conversations/thread_z/messages", token, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("foreign thread messages: %d", w.Code)
+	}
+
+	if w := serveJSON(r, http.MethodDelete, "/conversations/thread_a", token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if msgs, _ := h.transcripts.Messages("thread_a", 0); len(msgs) != 0 {
+		t.Fatalf("transcript after delete = %+v", msgs)
+	}
+}
+
+// remoteOrderAI devuelve el historial remoto del más reciente al más antiguo (order=desc).
+type remoteOrderAI struct {
+	historyAI
+}
+
+func (a *remoteOrderAI) GetThreadMessages(ctx context.Context, threadID string, limit int) ([]openai.ThreadMessage, error) {
+	return []openai.ThreadMessage{
+		{Role: "assistant", Content: "La dosis es 10 mg/kg.", CreatedAt: 300},
+		{Role: "user", Content: "¿Y en niños?", CreatedAt: 200},
+		{Role: "user", Content: "Paracetamol.", CreatedAt: 100},
+	}, nil
+}
+
+// Los hilos sin transcripción local salen en el mismo orden que la transcripción: el más
+// reciente al final, que es donde buscan los turnos recientes los constructores de contexto.
+func TestThreadMessagesRemoteOrder(t *testing.T) {
+	h := NewHandler(&remoteOrderAI{})
+	h.SetTranscripts(NewMemoryTranscriptStore())
+	got, err := h.threadMessages(context.Background(), "thread_old", 6)
+	if err != nil || len(got) != 3 || got[0].Content != "Paracetamol." || got[2].Content != "La dosis es 10 mg/kg." {
+		t.Fatalf("remote threadMessages = %+v, %v", got, err)
+	}
+}
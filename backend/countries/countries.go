@@ -2,33 +2,64 @@ package countries
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Country is the response model matching CountryModel in Flutter
-// { id, name, short_code, phone_code }
+// { id, name, short_code, phone_code, currency }
 
 type Country struct {
 	ID        int    `json:"id"`
 	Name      string `json:"name"`
 	ShortCode string `json:"short_code"`
 	PhoneCode int    `json:"phone_code"`
+	Currency  string `json:"currency"` // ISO 4217 used for local prices
+}
+
+// Minimal seed list; extend as needed or read from DB.
+var list = []Country{
+	{ID: 1, Name: "Colombia", ShortCode: "CO", PhoneCode: 57, Currency: "COP"},
+	{ID: 2, Name: "México", ShortCode: "MX", PhoneCode: 52, Currency: "MXN"},
+	{ID: 3, Name: "Perú", ShortCode: "PE", PhoneCode: 51, Currency: "PEN"},
+	{ID: 4, Name: "Argentina", ShortCode: "AR", PhoneCode: 54, Currency: "ARS"},
+	{ID: 5, Name: "Chile", ShortCode: "CL", PhoneCode: 56, Currency: "CLP"},
+	{ID: 6, Name: "España", ShortCode: "ES", PhoneCode: 34, Currency: "EUR"},
+	{ID: 7, Name: "Estados Unidos", ShortCode: "US", PhoneCode: 1, Currency: "USD"},
+}
+
+// All returns a copy of the supported countries.
+func All() []Country {
+	out := make([]Country, len(list))
+	copy(out, list)
+	return out
+}
+
+// ByID looks up a country by its id (users.country_id).
+func ByID(id int) (Country, bool) {
+	for _, c := range list {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return Country{}, false
+}
+
+// ByCode looks up a country by its ISO 3166-1 alpha-2 code (case-insensitive).
+func ByCode(code string) (Country, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, c := range list {
+		if c.ShortCode == code {
+			return c, true
+		}
+	}
+	return Country{}, false
 }
 
 // RegisterRoutes registers GET /countries with a static minimal list for now.
 func RegisterRoutes(r *gin.Engine) {
 	r.GET("/countries", func(c *gin.Context) {
-		// Minimal seed list; extend as needed or read from DB.
-		data := []Country{
-			{ID: 1, Name: "Colombia", ShortCode: "CO", PhoneCode: 57},
-			{ID: 2, Name: "México", ShortCode: "MX", PhoneCode: 52},
-			{ID: 3, Name: "Perú", ShortCode: "PE", PhoneCode: 51},
-			{ID: 4, Name: "Argentina", ShortCode: "AR", PhoneCode: 54},
-			{ID: 5, Name: "Chile", ShortCode: "CL", PhoneCode: 56},
-			{ID: 6, Name: "España", ShortCode: "ES", PhoneCode: 34},
-			{ID: 7, Name: "Estados Unidos", ShortCode: "US", PhoneCode: 1},
-		}
-		c.JSON(http.StatusOK, data)
+		c.JSON(http.StatusOK, All())
 	})
 }
@@ -0,0 +1,97 @@
+package docindex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ema-backend/files"
+)
+
+const (
+	// chunkChars is the target size of a chunk; small enough for precise matches, large enough
+	// to keep a paragraph together.
+	chunkChars = 1200
+	// chunkOverlap is the text repeated at the start of the next chunk of the same page so a
+	// sentence cut at the boundary is still found.
+	chunkOverlap = 200
+	// minChunkChars drops page fragments too short to be useful (page numbers, headers).
+	minChunkChars = 40
+)
+
+// Chunk is a piece of a document page with its embedding.
+type Chunk struct {
+	ThreadID   string    `json:"thread_id"`
+	DocumentID string    `json:"document_id"`
+	FileName   string    `json:"file_name"`
+	Page       int       `json:"page"`              // 1-based
+	Section    string    `json:"section,omitempty"` // heading in effect where the chunk starts
+	Index      int       `json:"chunk_index"`
+	Content    string    `json:"content"`
+	Vector     []float32 `json:"-"`
+}
+
+// chunkPages splits the text of each page (pages[0] is page 1) into chunks of about size
+// characters with overlap characters carried over. Chunks never span two pages, so every
+// chunk cites exactly one page; a chunk's section is the last heading before its first word,
+// carried over from earlier pages.
+func chunkPages(pages []files.PageText, size, overlap int) []Chunk {
+	var chunks []Chunk
+	section := ""
+	for i, page := range pages {
+		words, offsets := fields(page.Text)
+		heading := 0
+		sectionAt := func(off int) string {
+			for heading < len(page.Headings) && page.Headings[heading].Offset <= off {
+				section = page.Headings[heading].Text
+				heading++
+			}
+			return section
+		}
+		start := 0
+		for start < len(words) {
+			end, n := start, 0
+			for end < len(words) && (n == 0 || n+1+utf8.RuneCountInString(words[end]) <= size) {
+				n += utf8.RuneCountInString(words[end]) + 1
+				end++
+			}
+			content := strings.Join(words[start:end], " ")
+			sec := sectionAt(offsets[start])
+			if utf8.RuneCountInString(content) >= minChunkChars {
+				chunks = append(chunks, Chunk{Page: i + 1, Section: sec, Index: len(chunks), Content: content})
+			}
+			if end >= len(words) {
+				break
+			}
+			// step back over about overlap characters, always moving forward
+			next, back := end, 0
+			for next > start+1 && back < overlap {
+				next--
+				back += utf8.RuneCountInString(words[next]) + 1
+			}
+			start = next
+		}
+		// headings after the last chunk start still open the section of the next page
+		sectionAt(len(page.Text))
+	}
+	return chunks
+}
+
+// fields is strings.Fields also returning the byte offset of each word.
+func fields(s string) (words []string, offsets []int) {
+	start := -1
+	for i, r := range s {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				words, offsets = append(words, s[start:i]), append(offsets, start)
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words, offsets = append(words, s[start:]), append(offsets, start)
+	}
+	return words, offsets
+}
@@ -0,0 +1,210 @@
+// Package docindex is the in-process vector index for the PDFs users upload to a conversation:
+// text is extracted per page, split into page-bound chunks, embedded with the llm embeddings
+// flow and searched by brute-force cosine similarity. It replaces the per-upload OpenAI vector
+// store (create, upload, poll until indexed) when PDF_LOCAL_INDEX=1.
+package docindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"ema-backend/files"
+)
+
+const (
+	// embedBatch is the number of chunks sent per embeddings request.
+	embedBatch = 64
+	// maxChunks bounds the embedding cost of a single document (~3.6M characters).
+	maxChunks = 3000
+)
+
+// ErrNoText is returned for documents without extractable text (scanned PDFs).
+var ErrNoText = errors.New("docindex: document has no extractable text")
+
+// Embedder turns texts into vectors; llm.Provider implements it.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Document summarizes an indexed document.
+type Document struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	Pages    int    `json:"pages"` // page count when indexed; Documents reports the last page with text
+	Chunks   int    `json:"chunks"`
+}
+
+// Hit is a search result.
+type Hit struct {
+	Chunk
+	Score float32 `json:"score"`
+}
+
+// Index indexes and searches the documents of each thread.
+type Index struct {
+	store Store
+	embed Embedder
+	ocr   files.OCR
+}
+
+func New(store Store, embed Embedder) *Index {
+	return &Index{store: store, embed: embed}
+}
+
+// SetOCR makes IndexPDF read pages without a text layer (scanned pages) with o.
+func (x *Index) SetOCR(o files.OCR) { x.ocr = o }
+
+// IndexPDF extracts the text and section headings of the PDF at filePath page by page and
+// indexes it under threadID.
+func (x *Index) IndexPDF(ctx context.Context, threadID, filePath, fileName string) (*Document, error) {
+	var pages []files.PageText
+	var err error
+	if x.ocr != nil {
+		pages, err = files.ExtractPDFPagesOCR(ctx, filePath, x.ocr, files.OCROptionsFromEnv())
+	} else {
+		pages, err = files.ExtractPDFLayout(filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x.IndexLayout(ctx, threadID, fileName, pages)
+}
+
+// IndexPages indexes a document given as the plain text of its pages (pages[0] is page 1);
+// headings are guessed from the text with files.TextHeadings.
+func (x *Index) IndexPages(ctx context.Context, threadID, fileName string, pages []string) (*Document, error) {
+	layout := make([]files.PageText, len(pages))
+	for i, text := range pages {
+		layout[i] = files.PageText{Page: i + 1, Text: text, Headings: files.TextHeadings(text)}
+	}
+	return x.IndexLayout(ctx, threadID, fileName, layout)
+}
+
+// IndexLayout indexes a document given page by page with its section headings.
+// Uploading the same document again to the thread replaces it.
+func (x *Index) IndexLayout(ctx context.Context, threadID, fileName string, pages []files.PageText) (*Document, error) {
+	chunks := chunkPages(pages, chunkChars, chunkOverlap)
+	if len(chunks) == 0 {
+		return nil, ErrNoText
+	}
+	if len(chunks) > maxChunks {
+		log.Printf("[docindex][truncate] thread=%s file=%s chunks=%d max=%d", threadID, fileName, len(chunks), maxChunks)
+		chunks = chunks[:maxChunks]
+	}
+	doc := &Document{ID: documentID(threadID, fileName, pages), FileName: fileName, Pages: len(pages), Chunks: len(chunks)}
+	texts := make([]string, len(chunks))
+	for i := range chunks {
+		chunks[i].ThreadID, chunks[i].DocumentID, chunks[i].FileName = threadID, doc.ID, fileName
+		texts[i] = chunks[i].Content
+	}
+	for start := 0; start < len(texts); start += embedBatch {
+		end := min(start+embedBatch, len(texts))
+		vecs, err := x.embed.Embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("docindex: embeddings: %w", err)
+		}
+		if len(vecs) != end-start {
+			return nil, fmt.Errorf("docindex: embeddings: got %d vectors for %d texts", len(vecs), end-start)
+		}
+		for i, v := range vecs {
+			chunks[start+i].Vector = normalize(v)
+		}
+	}
+	if err := x.store.Replace(threadID, doc.ID, chunks); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Search returns the k chunks of the thread most similar to query, best first. A non-empty
+// documentID restricts the search to that document.
+func (x *Index) Search(ctx context.Context, threadID, query string, k int, documentID string) ([]Hit, error) {
+	chunks, err := x.store.Chunks(threadID)
+	if err != nil || len(chunks) == 0 {
+		return nil, err
+	}
+	vecs, err := x.embed.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("docindex: embeddings: %w", err)
+	}
+	if len(vecs) != 1 {
+		return nil, errors.New("docindex: embeddings: no vector for the query")
+	}
+	q := normalize(vecs[0])
+	hits := make([]Hit, 0, len(chunks))
+	for _, ch := range chunks {
+		if documentID != "" && ch.DocumentID != documentID {
+			continue
+		}
+		if len(ch.Vector) != len(q) {
+			continue // indexed with another embedding model
+		}
+		hits = append(hits, Hit{Chunk: ch, Score: dot(q, ch.Vector)})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if k > 0 && len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits, nil
+}
+
+// Documents lists the documents indexed for the thread, in upload order.
+func (x *Index) Documents(threadID string) ([]Document, error) {
+	return x.store.Documents(threadID)
+}
+
+// HasDocuments reports whether anything is indexed for the thread.
+func (x *Index) HasDocuments(threadID string) bool {
+	if strings.TrimSpace(threadID) == "" {
+		return false
+	}
+	docs, err := x.Documents(threadID)
+	return err == nil && len(docs) > 0
+}
+
+// DeleteThread drops every document of the thread.
+func (x *Index) DeleteThread(threadID string) error {
+	return x.store.DeleteThread(threadID)
+}
+
+// documentID identifies a document by thread, name and content, so re-uploads replace it.
+func documentID(threadID, fileName string, pages []files.PageText) string {
+	h := sha256.New()
+	h.Write([]byte(threadID + "\x00" + fileName + "\x00"))
+	for _, p := range pages {
+		h.Write([]byte(p.Text))
+		h.Write([]byte{0})
+	}
+	return "doc_" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, f := range v {
+		sum += float64(f) * float64(f)
+	}
+	if sum == 0 {
+		return v
+	}
+	n := float32(1 / math.Sqrt(sum))
+	out := make([]float32, len(v))
+	for i, f := range v {
+		out[i] = f * n
+	}
+	return out
+}
+
+func dot(a, b []float32) float32 {
+	var s float32
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
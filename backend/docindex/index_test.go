@@ -0,0 +1,143 @@
+package docindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"ema-backend/files"
+	"ema-backend/llm"
+)
+
+func TestChunkPages(t *testing.T) {
+	var words []string
+	for i := 0; i < 400; i++ {
+		words = append(words, fmt.Sprintf("palabra%03d", i))
+	}
+	long := strings.Join(words, " ") // ~4800 characters
+	pages := []files.PageText{
+		{Text: long},
+		{},
+		{Text: "12"},
+		{Text: "Tratamiento\nLa insuficiencia cardiaca aguda requiere diuréticos de asa y vasodilatadores.",
+			Headings: []files.Heading{{Text: "Tratamiento", Offset: 0}}},
+	}
+	chunks := chunkPages(pages, 1200, 200)
+	if len(chunks) != 6 {
+		t.Fatalf("chunks = %d", len(chunks))
+	}
+	for i, ch := range chunks[:5] {
+		if ch.Page != 1 || ch.Index != i || len(ch.Content) > 1200 {
+			t.Errorf("chunk %d = page %d, %d chars", i, ch.Page, len(ch.Content))
+		}
+	}
+	// Consecutive chunks of a page overlap; empty and tiny pages produce none.
+	first := strings.Fields(chunks[1].Content)[0]
+	if tail := chunks[0].Content[len(chunks[0].Content)-250:]; !strings.Contains(tail, first) {
+		t.Errorf("chunk 1 starts at %s, outside the end of chunk 0", first)
+	}
+	if last := chunks[5]; last.Page != 4 || last.Index != 5 || last.Section != "Tratamiento" {
+		t.Errorf("last chunk = %+v", last)
+	}
+}
+
+func TestChunkPages_Sections(t *testing.T) {
+	var words []string
+	for i := 0; i < 150; i++ {
+		words = append(words, fmt.Sprintf("dato%03d", i))
+	}
+	body := strings.Join(words, " ") // ~1200 characters: one chunk, two with a heading added
+	page1 := "Introducción breve del capítulo sobre diabetes.\n2. Diagnóstico\n" + body
+	page2 := body + "\n3. Tratamiento"
+	page3 := body
+	pages := []files.PageText{
+		{Text: page1, Headings: files.TextHeadings(page1)},
+		{Text: page2, Headings: files.TextHeadings(page2)},
+		{Text: page3},
+	}
+	var got []string
+	for _, ch := range chunkPages(pages, 1200, 200) {
+		got = append(got, fmt.Sprintf("%d:%s", ch.Page, ch.Section))
+	}
+	// The section of a chunk is the one open where it starts, carried across pages.
+	want := "1:,1:2. Diagnóstico,2:2. Diagnóstico,2:2. Diagnóstico,3:3. Tratamiento"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("sections = %s\nwant       %s", strings.Join(got, ","), want)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	ctx := context.Background()
+	x := New(NewMemoryStore(), llm.NewMock(nil))
+	doc, err := x.IndexPages(ctx, "conv_1", "guia.pdf", []string{
+		"Introducción general a la guía de práctica clínica y metodología de revisión.",
+		"La hipertensión arterial se trata con inhibidores de la enzima convertidora y diuréticos tiazídicos.",
+		"La neumonía adquirida en la comunidad se trata con amoxicilina o macrólidos según gravedad.",
+	})
+	if err != nil || doc.Chunks != 3 || doc.Pages != 3 {
+		t.Fatalf("IndexPages = %+v, %v", doc, err)
+	}
+	other, err := x.IndexPages(ctx, "conv_1", "notas.pdf", []string{"Apuntes sobre neumonía: amoxicilina como primera línea en adultos sanos."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits, err := x.Search(ctx, "conv_1", "¿cómo se trata la hipertensión arterial?", 2, "")
+	if err != nil || len(hits) != 2 || hits[0].Page != 2 || hits[0].FileName != "guia.pdf" {
+		t.Fatalf("Search = %+v, %v", hits, err)
+	}
+	hits, _ = x.Search(ctx, "conv_1", "neumonía amoxicilina", 5, other.ID)
+	if len(hits) != 1 || hits[0].DocumentID != other.ID {
+		t.Fatalf("Search restricted to a document = %+v", hits)
+	}
+
+	// Re-uploading the same file replaces it instead of duplicating chunks.
+	x.IndexPages(ctx, "conv_1", "notas.pdf", []string{"Apuntes sobre neumonía: amoxicilina como primera línea en adultos sanos."})
+	docs, _ := x.Documents("conv_1")
+	if len(docs) != 2 || docs[0].FileName != "guia.pdf" || docs[1].Chunks != 1 {
+		t.Fatalf("Documents = %+v", docs)
+	}
+
+	if _, err := x.IndexPages(ctx, "conv_1", "escaneo.pdf", []string{"", " "}); !errors.Is(err, ErrNoText) {
+		t.Fatalf("scanned document: %v", err)
+	}
+	if x.HasDocuments("conv_2") {
+		t.Fatal("documents leaked across threads")
+	}
+	x.DeleteThread("conv_1")
+	if x.HasDocuments("conv_1") {
+		t.Fatal("DeleteThread kept documents")
+	}
+}
+
+func TestVectorEncoding(t *testing.T) {
+	v := []float32{0.5, -1.25, 3e-7}
+	got, err := decodeVector(encodeVector(v))
+	if err != nil || len(got) != 3 || got[0] != v[0] || got[1] != v[1] || got[2] != v[2] {
+		t.Fatalf("round trip = %v, %v", got, err)
+	}
+	if _, err := decodeVector([]byte{1, 2, 3}); err == nil {
+		t.Fatal("truncated vector accepted")
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	content := "La guía revisa la evidencia disponible. La metformina es el fármaco de primera línea en la diabetes tipo 2. " +
+		"Se ajusta la dosis según la función renal. Los controles se repiten cada tres meses hasta alcanzar la meta."
+	got := Snippet(content, "¿Cuál es la primera línea para la diabetes tipo 2?", 120)
+	if got != "La metformina es el fármaco de primera línea en la diabetes tipo 2. Se ajusta la dosis según la función renal." {
+		t.Fatalf("snippet = %q", got)
+	}
+	if got := Snippet(content, "anticoagulación", 60); got != "La guía revisa la evidencia disponible." {
+		t.Fatalf("snippet without matches = %q", got)
+	}
+	if got := Snippet(strings.Repeat("palabra ", 40), "palabra", 50); utf8.RuneCountInString(got) > 51 || !strings.HasSuffix(got, "…") {
+		t.Fatalf("long sentence = %q", got)
+	}
+	if short := "Texto breve."; Snippet(short, "texto", 100) != short {
+		t.Fatal("short content changed")
+	}
+}
@@ -0,0 +1,70 @@
+package docindex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Snippet returns the passage of content that best matches query, at most maxChars long, for
+// quoting in a citation: the sentence sharing the most query terms, followed by the next ones
+// while they fit. Without any shared term it returns the start of content.
+func Snippet(content, query string, maxChars int) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if maxChars <= 0 || utf8.RuneCountInString(content) <= maxChars {
+		return content
+	}
+	terms := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(query), notWordRune) {
+		if utf8.RuneCountInString(w) >= 4 {
+			terms[w] = true
+		}
+	}
+	sentences := splitSentences(content)
+	best, bestScore := 0, 0
+	for i, s := range sentences {
+		score := 0
+		for _, w := range strings.FieldsFunc(strings.ToLower(s), notWordRune) {
+			if terms[w] {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	out := sentences[best]
+	for _, s := range sentences[best+1:] {
+		if utf8.RuneCountInString(out)+1+utf8.RuneCountInString(s) > maxChars {
+			break
+		}
+		out += " " + s
+	}
+	if utf8.RuneCountInString(out) <= maxChars {
+		return out
+	}
+	// a single long sentence: cut at a word boundary
+	r := []rune(out)[:maxChars]
+	if i := strings.LastIndexByte(string(r), ' '); i > maxChars/2 {
+		return string(r)[:i] + "…"
+	}
+	return string(r) + "…"
+}
+
+// splitSentences splits text after ". ", "? ", "! " and "; ", keeping the punctuation.
+func splitSentences(text string) []string {
+	var out []string
+	start := 0
+	for i := 0; i+1 < len(text); i++ {
+		if strings.IndexByte(".?!;", text[i]) >= 0 && text[i+1] == ' ' {
+			out = append(out, text[start:i+1])
+			start = i + 2
+		}
+	}
+	if start < len(text) {
+		out = append(out, text[start:])
+	}
+	return out
+}
+
+func notWordRune(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }
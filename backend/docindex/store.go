@@ -0,0 +1,175 @@
+package docindex
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+)
+
+// Store persists the chunks of the documents uploaded to each thread.
+type Store interface {
+	// Replace stores the chunks of a document, dropping any previous version of it.
+	Replace(threadID, documentID string, chunks []Chunk) error
+	// Chunks returns every chunk of the thread ordered by document and chunk index.
+	Chunks(threadID string) ([]Chunk, error)
+	// Documents summarizes the documents of the thread in upload order, without the vectors.
+	Documents(threadID string) ([]Document, error)
+	DeleteThread(threadID string) error
+}
+
+var (
+	_ Store = (*SQLStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// SQLStore implements Store on the document_chunks table (MySQL or Postgres).
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore { return &SQLStore{db: db} }
+
+func (s *SQLStore) Replace(threadID, documentID string, chunks []Chunk) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM document_chunks WHERE thread_id = ? AND document_id = ?`, threadID, documentID); err != nil {
+		return err
+	}
+	for _, ch := range chunks {
+		if _, err := tx.Exec(`INSERT INTO document_chunks (thread_id, document_id, file_name, page, section, chunk_index, content, embedding)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			threadID, documentID, ch.FileName, ch.Page, ch.Section, ch.Index, ch.Content, encodeVector(ch.Vector)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Chunks(threadID string) ([]Chunk, error) {
+	rows, err := s.db.Query(`SELECT thread_id, document_id, file_name, page, section, chunk_index, content, embedding
+		FROM document_chunks WHERE thread_id = ? ORDER BY id`, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Chunk{}
+	for rows.Next() {
+		var ch Chunk
+		var raw []byte
+		if err := rows.Scan(&ch.ThreadID, &ch.DocumentID, &ch.FileName, &ch.Page, &ch.Section, &ch.Index, &ch.Content, &raw); err != nil {
+			return nil, err
+		}
+		if ch.Vector, err = decodeVector(raw); err != nil {
+			return nil, err
+		}
+		list = append(list, ch)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) Documents(threadID string) ([]Document, error) {
+	rows, err := s.db.Query(`SELECT document_id, file_name, MAX(page), COUNT(*) FROM document_chunks
+		WHERE thread_id = ? GROUP BY document_id, file_name ORDER BY MIN(id)`, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	docs := []Document{}
+	for rows.Next() {
+		var d Document
+		if err := rows.Scan(&d.ID, &d.FileName, &d.Pages, &d.Chunks); err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+func (s *SQLStore) DeleteThread(threadID string) error {
+	_, err := s.db.Exec(`DELETE FROM document_chunks WHERE thread_id = ?`, threadID)
+	return err
+}
+
+// MemoryStore is an in-memory Store for tests and runs without a database.
+type MemoryStore struct {
+	mu     sync.Mutex
+	chunks map[string][]Chunk
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{chunks: map[string][]Chunk{}}
+}
+
+func (s *MemoryStore) Replace(threadID, documentID string, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := []Chunk{}
+	for _, ch := range s.chunks[threadID] {
+		if ch.DocumentID != documentID {
+			kept = append(kept, ch)
+		}
+	}
+	s.chunks[threadID] = append(kept, chunks...)
+	return nil
+}
+
+func (s *MemoryStore) Chunks(threadID string) ([]Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Chunk{}, s.chunks[threadID]...), nil
+}
+
+func (s *MemoryStore) Documents(threadID string) ([]Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return summarize(s.chunks[threadID]), nil
+}
+
+func (s *MemoryStore) DeleteThread(threadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.chunks, threadID)
+	return nil
+}
+
+// summarize groups chunks (in upload order) into documents.
+func summarize(chunks []Chunk) []Document {
+	docs := []Document{}
+	index := map[string]int{}
+	for _, ch := range chunks {
+		i, ok := index[ch.DocumentID]
+		if !ok {
+			i = len(docs)
+			index[ch.DocumentID] = i
+			docs = append(docs, Document{ID: ch.DocumentID, FileName: ch.FileName})
+		}
+		docs[i].Chunks++
+		docs[i].Pages = max(docs[i].Pages, ch.Page)
+	}
+	return docs
+}
+
+// encodeVector packs v as little-endian float32s.
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+func decodeVector(b []byte) ([]float32, error) {
+	if len(b)%4 != 0 {
+		return nil, errors.New("docindex: corrupt embedding")
+	}
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v, nil
+}
@@ -0,0 +1,43 @@
+package docindex
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"ema-backend/conn/conntest"
+	"ema-backend/llm"
+	"ema-backend/migrations"
+)
+
+func TestSQLStoreMatrix(t *testing.T) {
+	conntest.ForEach(t, func(t *testing.T, db *sql.DB) {
+		ctx := context.Background()
+		m, err := migrations.NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Up(ctx, migrations.MigrateOptions{}); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		t.Cleanup(func() {
+			all, _ := m.Status(ctx)
+			m.Down(ctx, migrations.MigrateOptions{Steps: len(all)})
+		})
+		x := New(NewSQLStore(db), llm.NewMock(nil))
+		doc, err := x.IndexPages(ctx, "conv_sql", "guia.pdf", []string{
+			"La hipertensión arterial se trata con inhibidores de la enzima convertidora.",
+			"INFECCIONES RESPIRATORIAS\nLa neumonía adquirida en la comunidad se trata con amoxicilina.",
+		})
+		if err != nil {
+			t.Fatalf("IndexPages: %v", err)
+		}
+		hits, err := x.Search(ctx, "conv_sql", "tratamiento de la neumonía", 1, "")
+		if err != nil || len(hits) != 1 || hits[0].Page != 2 || hits[0].Section != "INFECCIONES RESPIRATORIAS" || hits[0].DocumentID != doc.ID || len(hits[0].Vector) != llm.MockEmbeddingDims {
+			t.Fatalf("Search = %+v, %v", hits, err)
+		}
+		if err := x.DeleteThread("conv_sql"); err != nil || x.HasDocuments("conv_sql") {
+			t.Fatalf("DeleteThread: %v", err)
+		}
+	})
+}
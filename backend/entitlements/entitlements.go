@@ -0,0 +1,259 @@
+// Package entitlements answers "may this user use feature X?" from the plan (version) of
+// their active subscription. Counters (consultations, files, ...) stay in package quota;
+// this covers on/off features and per-plan limits such as the PDF upload size.
+package entitlements
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"ema-backend/login"
+	"ema-backend/migrations"
+	"ema-backend/openai"
+	"ema-backend/subscriptions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Feature keys, see subscriptions.Features for the catalog and defaults.
+const (
+	ImageAnalysis  = subscriptions.FeatureImageAnalysis
+	PubMedSearch   = subscriptions.FeaturePubMedSearch
+	PDFUploadMaxMB = subscriptions.FeaturePDFUploadMaxMB
+	ExportPDF      = subscriptions.FeatureExportPDF
+	PremiumModel   = subscriptions.FeaturePremiumModel
+	Statistics     = subscriptions.FeatureStatistics
+)
+
+// ErrFeatureLocked is returned by Check when the plan doesn't include the feature.
+var ErrFeatureLocked = errors.New("función no incluida en tu plan")
+
+// Set holds the effective entitlements of a user.
+type Set struct {
+	PlanID   int            `json:"plan_id"`
+	PlanName string         `json:"plan_name"`
+	Values   map[string]int `json:"entitlements"`
+}
+
+// Value returns the value of feature and whether the set defines it.
+func (s *Set) Value(feature string) (int, bool) {
+	v, ok := s.Values[feature]
+	return v, ok
+}
+
+// Decision is the outcome of Check.
+type Decision struct {
+	Feature string `json:"feature"`
+	Allowed bool   `json:"allowed"`
+	Value   int    `json:"value"`
+	PlanID  int    `json:"plan_id"`
+}
+
+// Service resolves entitlements from subscriptions; users resolves request tokens.
+type Service struct {
+	subs  *subscriptions.Repository
+	users migrations.UserStore
+}
+
+func NewService(repo *subscriptions.Repository, users migrations.UserStore) *Service {
+	return &Service{subs: repo, users: users}
+}
+
+var defaultService *Service
+
+// Init sets the service used by the package-level helpers (Check, Attach, /me/entitlements).
+func Init(repo *subscriptions.Repository, users migrations.UserStore) {
+	defaultService = NewService(repo, users)
+}
+
+// unrestricted is what users get when entitlements are disabled (ENTITLEMENTS_DISABLE=1):
+// every feature at its paid-plan default.
+func unrestricted() Set {
+	values := make(map[string]int, len(subscriptions.Features))
+	for _, f := range subscriptions.Features {
+		values[f.Key] = f.Paid
+	}
+	return Set{Values: values}
+}
+
+func fromPlan(p *subscriptions.Plan) Set {
+	return Set{PlanID: p.ID, PlanName: p.Name, Values: p.ResolvedEntitlements()}
+}
+
+// Resolve returns the entitlements of user: those of the plan version pinned on the active
+// subscription, the Free plan when there is none (or no user).
+func (s *Service) Resolve(ctx context.Context, user *migrations.User) (Set, error) {
+	if s == nil || s.subs == nil || os.Getenv("ENTITLEMENTS_DISABLE") == "1" {
+		return unrestricted(), nil
+	}
+	if user != nil {
+		sub, err := s.subs.GetActiveSubscription(user.ID)
+		if err != nil {
+			return Set{}, err
+		}
+		if sub != nil && sub.Plan != nil {
+			return fromPlan(sub.Plan), nil
+		}
+	}
+	free, err := s.subs.GetFreePlan()
+	if err != nil {
+		return Set{}, err
+	}
+	if free == nil {
+		return fromPlan(&subscriptions.Plan{}), nil
+	}
+	return fromPlan(free), nil
+}
+
+// Check reports whether user may use feature. A locked feature returns ErrFeatureLocked
+// together with the decision; limits are allowed when > 0 and Decision.Value holds them.
+func (s *Service) Check(ctx context.Context, user *migrations.User, feature string) (Decision, error) {
+	if _, ok := subscriptions.FeatureByKey(feature); !ok {
+		return Decision{}, errors.New("entitlement desconocido: " + feature)
+	}
+	set, err := s.Resolve(ctx, user)
+	if err != nil {
+		return Decision{}, err
+	}
+	v, _ := set.Value(feature)
+	d := Decision{Feature: feature, Allowed: v > 0, Value: v, PlanID: set.PlanID}
+	if !d.Allowed {
+		return d, ErrFeatureLocked
+	}
+	return d, nil
+}
+
+// Check uses the service set by Init; everything is allowed until Init is called.
+func Check(ctx context.Context, user *migrations.User, feature string) (Decision, error) {
+	return defaultService.Check(ctx, user, feature)
+}
+
+type ctxKey struct{}
+
+// WithSet attaches the entitlements of the request's user to ctx.
+func WithSet(ctx context.Context, s Set) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &s)
+}
+
+// FromContext returns the entitlements attached by Attach, if any.
+func FromContext(ctx context.Context) (*Set, bool) {
+	s, ok := ctx.Value(ctxKey{}).(*Set)
+	return s, ok && s != nil
+}
+
+// Allowed reports whether the entitlements in ctx include feature. Contexts without
+// entitlements (internal calls, tests) allow everything.
+func Allowed(ctx context.Context, feature string) bool {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return true
+	}
+	v, _ := s.Value(feature)
+	return v > 0
+}
+
+// Value returns the value of feature in ctx; ok is false when nothing is attached.
+func Value(ctx context.Context, feature string) (int, bool) {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return s.Value(feature)
+}
+
+// userByEmail looks the user up in the injected store; nil without one.
+func (s *Service) userByEmail(email string) *migrations.User {
+	if s == nil || s.users == nil {
+		return nil
+	}
+	return s.users.GetUserByEmail(email)
+}
+
+// userFromRequest resolves the Bearer token user; nil for anonymous or invalid tokens.
+func (s *Service) userFromRequest(c *gin.Context) *migrations.User {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+	email, ok := login.GetEmailFromToken(token)
+	if !ok {
+		return nil
+	}
+	return s.userByEmail(email)
+}
+
+// Attach resolves the entitlements of the request's user and stores them in the request
+// context, so code deeper in the call chain can use Allowed/Value. Users with premium_model
+// get CHAT_PREMIUM_MODEL (when set) for their AI calls, and the assistant's pubmed_search tool
+// is disabled for plans without PubMed. Resolution errors fail open.
+func Attach(c *gin.Context) {
+	if defaultService == nil {
+		return
+	}
+	user := defaultService.userFromRequest(c)
+	ctx := c.Request.Context()
+	if user != nil {
+		ctx = openai.WithUserID(ctx, user.ID)
+	}
+	set, err := defaultService.Resolve(ctx, user)
+	if err != nil {
+		log.Printf("[entitlements][skip] resolve error: %v", err)
+		c.Request = c.Request.WithContext(ctx)
+		return
+	}
+	ctx = WithSet(ctx, set)
+	if v, _ := set.Value(PremiumModel); v > 0 {
+		if m := strings.TrimSpace(os.Getenv("CHAT_PREMIUM_MODEL")); m != "" {
+			ctx = openai.WithModel(ctx, m)
+		}
+	}
+	if v, _ := set.Value(PubMedSearch); v <= 0 {
+		ctx = openai.WithoutTools(ctx, "pubmed_search")
+	}
+	c.Request = c.Request.WithContext(ctx)
+}
+
+// AbortLocked answers 403 for a feature the user's plan doesn't include.
+func AbortLocked(c *gin.Context, feature string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrFeatureLocked.Error(), "code": "feature_locked", "feature": feature})
+}
+
+// RegisterRoutes mounts GET /me/entitlements.
+func RegisterRoutes(r *gin.Engine) {
+	r.GET("/me/entitlements", getMyEntitlements)
+}
+
+// getMyEntitlements handles GET /me/entitlements: the effective value of every feature so
+// the app can hide or badge locked ones.
+func getMyEntitlements(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token requerido"})
+		return
+	}
+	email, ok := login.GetEmailFromToken(token)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sesión inválida"})
+		return
+	}
+	u := defaultService.userByEmail(email)
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+		return
+	}
+	set, err := defaultService.Resolve(c.Request.Context(), u)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	features := make([]gin.H, 0, len(subscriptions.Features))
+	for _, f := range subscriptions.Features {
+		v := set.Values[f.Key]
+		features = append(features, gin.H{"key": f.Key, "kind": f.Kind, "description": f.Description, "value": v, "allowed": v > 0})
+	}
+	c.JSON(http.StatusOK, gin.H{"plan_id": set.PlanID, "plan_name": set.PlanName, "entitlements": set.Values, "features": features})
+}
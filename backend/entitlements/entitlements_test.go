@@ -0,0 +1,37 @@
+package entitlements
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextHelpers(t *testing.T) {
+	ctx := context.Background()
+	if !Allowed(ctx, PubMedSearch) {
+		t.Error("no entitlements attached must allow")
+	}
+	if _, ok := Value(ctx, PDFUploadMaxMB); ok {
+		t.Error("value without entitlements")
+	}
+	ctx = WithSet(ctx, Set{PlanID: 1, Values: map[string]int{PubMedSearch: 0, PDFUploadMaxMB: 10}})
+	if Allowed(ctx, PubMedSearch) || !Allowed(ctx, PDFUploadMaxMB) {
+		t.Error("attached entitlements ignored")
+	}
+	if v, ok := Value(ctx, PDFUploadMaxMB); !ok || v != 10 {
+		t.Errorf("value: got %d ok=%v", v, ok)
+	}
+}
+
+func TestCheckUninitialized(t *testing.T) {
+	d, err := (*Service)(nil).Check(context.Background(), nil, PremiumModel)
+	if err != nil || !d.Allowed {
+		t.Errorf("got %+v err=%v", d, err)
+	}
+	if _, err := (*Service)(nil).Check(context.Background(), nil, "teleconsulta"); err == nil {
+		t.Error("unknown feature accepted")
+	}
+	t.Setenv("ENTITLEMENTS_DISABLE", "1")
+	if d, err := NewService(nil, nil).Check(context.Background(), nil, Statistics); err != nil || d.Value != 1 {
+		t.Errorf("disabled: got %+v err=%v", d, err)
+	}
+}
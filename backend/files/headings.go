@@ -0,0 +1,71 @@
+package files
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxHeadingChars bounds a heading; longer "titles" are body text set in a large font.
+const maxHeadingChars = 120
+
+// Heading is a section title found in the text of a page.
+type Heading struct {
+	Text   string `json:"text"`
+	Offset int    `json:"offset"` // byte offset of the heading in the page text
+}
+
+// numberedHeading matches "2.", "2.1", "III." or "Capítulo 4" followed by a capitalized title.
+var numberedHeading = regexp.MustCompile(`^(?i:(?:cap[ií]tulo|chapter|secci[oó]n|section|tema|parte|part|anexo|appendix)\s+\w+|(?:\d{1,2}(?:\.\d{1,2}){0,3}\.?|[IVXLC]{1,6}\.))\s*[-–—:.]?\s*\p{Lu}`)
+
+// TextHeadings returns the title-like lines of plain text (OCR output, text without font
+// information): numbered sections and short all-caps lines.
+func TextHeadings(text string) []Heading {
+	var out []Heading
+	off := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && looksLikeHeading(trimmed) {
+			out = append(out, Heading{Text: truncateHeading(trimmed), Offset: off + strings.Index(line, trimmed)})
+		}
+		off += len(line)
+	}
+	return out
+}
+
+// looksLikeHeading reports whether a single line reads as a section title regardless of its font.
+func looksLikeHeading(line string) bool {
+	if !isHeadingLength(line) || strings.HasSuffix(line, ".") || strings.HasSuffix(line, ",") || strings.HasSuffix(line, ";") {
+		return false
+	}
+	words := len(strings.Fields(line))
+	if numberedHeading.MatchString(line) {
+		return words <= 12
+	}
+	letters, upper := 0, 0
+	for _, r := range line {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= 6 && upper == letters && words <= 10
+}
+
+// isHeadingLength reports whether line has the length of a title and some letters.
+func isHeadingLength(line string) bool {
+	n := utf8.RuneCountInString(line)
+	return n >= 3 && n <= maxHeadingChars && strings.IndexFunc(line, unicode.IsLetter) >= 0
+}
+
+func truncateHeading(s string) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= maxHeadingChars {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimSpace(string(r[:maxHeadingChars])) + "…"
+}
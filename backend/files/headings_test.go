@@ -0,0 +1,56 @@
+package files
+
+import (
+	"testing"
+
+	pdf "rsc.io/pdf"
+)
+
+func TestTextHeadings(t *testing.T) {
+	text := "CAPÍTULO 3\nHipertensión arterial\nEl tratamiento inicial combina cambios en el estilo de vida.\n3.1 Tratamiento farmacológico\n1. Administrar enalapril 10 mg cada 12 horas y controlar la presión.\nIECA"
+	got := TextHeadings(text)
+	want := []string{"CAPÍTULO 3", "3.1 Tratamiento farmacológico"}
+	if len(got) != len(want) {
+		t.Fatalf("headings = %+v, want %v", got, want)
+	}
+	for i, h := range got {
+		if h.Text != want[i] || text[h.Offset:h.Offset+len(h.Text)] != h.Text {
+			t.Fatalf("heading %d = %+v, want %q at its offset", i, h, want[i])
+		}
+	}
+}
+
+// glyphs escribe s letra por letra como lo entrega rsc.io/pdf, en la línea y con el tamaño dados.
+func glyphs(s string, y, size float64) []pdf.Text {
+	var out []pdf.Text
+	x := 50.0
+	for _, r := range s {
+		if r == ' ' {
+			x += size * 0.5 // espacio por posicionamiento, sin glifo
+			continue
+		}
+		out = append(out, pdf.Text{FontSize: size, X: x, Y: y, W: size * 0.5, S: string(r)})
+		x += size * 0.5
+	}
+	return out
+}
+
+func TestLayoutText(t *testing.T) {
+	var page []pdf.Text
+	page = append(page, glyphs("Manejo de la", 760, 18)...)
+	page = append(page, glyphs("cetoacidosis", 738, 18)...)
+	page = append(page, glyphs("La insulina intravenosa es el pilar", 700, 10)...)
+	page = append(page, glyphs("del tratamiento inicial.", 688, 10)...)
+	page = append(page, glyphs("Hidratación", 660, 13)...)
+	page = append(page, glyphs("Solución salina al 0,9%.", 640, 10)...)
+
+	text, headings := layoutText(page)
+	want := "Manejo de la\ncetoacidosis\nLa insulina intravenosa es el pilar\ndel tratamiento inicial.\nHidratación\nSolución salina al 0,9%."
+	if text != want {
+		t.Fatalf("text = %q, want %q", text, want)
+	}
+	if len(headings) != 2 || headings[0].Text != "Manejo de la cetoacidosis" || headings[0].Offset != 0 ||
+		headings[1].Text != "Hidratación" || text[headings[1].Offset:headings[1].Offset+len("Hidratación")] != "Hidratación" {
+		t.Fatalf("headings = %+v", headings)
+	}
+}
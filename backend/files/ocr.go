@@ -0,0 +1,222 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OCR for scanned PDFs: pages without a text layer are rasterized (poppler's pdftoppm) and read
+// with Tesseract, which also reports a per-word confidence. Configured from the environment:
+//
+//	OCR_ENABLED=1            turn the OCR stage on (needs pdftoppm and tesseract in PATH)
+//	OCR_LANGUAGES            Tesseract languages, default "spa+eng"
+//	OCR_DPI                  rasterization resolution, default 300
+//	OCR_MIN_CONFIDENCE       pages below this mean confidence (0-100) are dropped, default 50
+//	OCR_MAX_PAGES            pages OCR'd per document, default 60
+
+// ErrNoTextLayer is returned by ExtractPDFText for PDFs without text when no OCR is configured.
+var ErrNoTextLayer = errors.New("pdf has no extractable text (scanned?)")
+
+// minPageChars is the text below which a page is considered image-only.
+const minPageChars = 20
+
+// OCR reads the text of one page of a PDF.
+type OCR interface {
+	// Recognize returns the text of page (1-based) and its mean confidence (0-100).
+	Recognize(ctx context.Context, pdfPath string, page int) (text string, confidence float64, err error)
+}
+
+// PageText is the text of a page, its section headings and where it came from.
+type PageText struct {
+	Page       int       `json:"page"` // 1-based
+	Text       string    `json:"text"`
+	Headings   []Heading `json:"headings,omitempty"`
+	OCR        bool      `json:"ocr"`
+	Confidence float64   `json:"confidence,omitempty"` // OCR pages only, 0-100
+}
+
+// OCROptions bounds the OCR stage.
+type OCROptions struct {
+	MinConfidence float64
+	MaxPages      int
+	Workers       int
+}
+
+// OCROptionsFromEnv reads OCR_MIN_CONFIDENCE and OCR_MAX_PAGES.
+func OCROptionsFromEnv() OCROptions {
+	o := OCROptions{MinConfidence: 50, MaxPages: 60, Workers: 2}
+	if v, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("OCR_MIN_CONFIDENCE")), 64); err == nil && v >= 0 {
+		o.MinConfidence = v
+	}
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("OCR_MAX_PAGES"))); err == nil && v > 0 {
+		o.MaxPages = v
+	}
+	return o
+}
+
+var defaultOCR OCR
+
+// SetOCR sets the OCR used by ExtractPDFText for PDFs without a text layer.
+func SetOCR(o OCR) { defaultOCR = o }
+
+// ExtractPDFPagesOCR returns the text of every page, running ocr on the pages whose text layer
+// is (nearly) empty. OCR pages under opts.MinConfidence, or past opts.MaxPages, come back empty
+// so page numbers stay aligned. A nil ocr behaves like ExtractPDFPages.
+func ExtractPDFPagesOCR(ctx context.Context, filePath string, ocr OCR, opts OCROptions) ([]PageText, error) {
+	pages, err := ExtractPDFLayout(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ocrPages(ctx, filePath, pages, ocr, opts), nil
+}
+
+func ocrPages(ctx context.Context, filePath string, pages []PageText, ocr OCR, opts OCROptions) []PageText {
+	out := make([]PageText, len(pages))
+	var todo []int
+	for i, p := range pages {
+		out[i] = p
+		if ocr != nil && len(strings.TrimSpace(p.Text)) < minPageChars {
+			todo = append(todo, i)
+		}
+	}
+	if opts.MaxPages > 0 && len(todo) > opts.MaxPages {
+		todo = todo[:opts.MaxPages]
+	}
+	workers := max(opts.Workers, 1)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				text, conf, err := ocr.Recognize(ctx, filePath, i+1)
+				if err != nil || conf < opts.MinConfidence || strings.TrimSpace(text) == "" {
+					continue
+				}
+				out[i] = PageText{Page: i + 1, Text: text, Headings: TextHeadings(text), OCR: true, Confidence: conf}
+			}
+		}()
+	}
+	for _, i := range todo {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return out
+}
+
+// Tesseract implements OCR with the pdftoppm and tesseract command-line tools.
+type Tesseract struct {
+	Languages string
+	DPI       int
+	// PageTimeout bounds rasterizing and reading a single page.
+	PageTimeout time.Duration
+
+	pdftoppm, tesseract string
+}
+
+// NewTesseractFromEnv returns the OCR configured by OCR_ENABLED, OCR_LANGUAGES and OCR_DPI;
+// nil (and no error) when OCR is disabled, an error when the tools are missing.
+func NewTesseractFromEnv() (*Tesseract, error) {
+	if strings.TrimSpace(os.Getenv("OCR_ENABLED")) != "1" {
+		return nil, nil
+	}
+	t := &Tesseract{Languages: "spa+eng", DPI: 300, PageTimeout: 60 * time.Second}
+	if v := strings.TrimSpace(os.Getenv("OCR_LANGUAGES")); v != "" {
+		t.Languages = v
+	}
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("OCR_DPI"))); err == nil && v > 0 {
+		t.DPI = v
+	}
+	var err error
+	if t.pdftoppm, err = exec.LookPath("pdftoppm"); err != nil {
+		return nil, fmt.Errorf("ocr: pdftoppm (poppler-utils) not found: %w", err)
+	}
+	if t.tesseract, err = exec.LookPath("tesseract"); err != nil {
+		return nil, fmt.Errorf("ocr: tesseract not found: %w", err)
+	}
+	return t, nil
+}
+
+func (t *Tesseract) Recognize(ctx context.Context, pdfPath string, page int) (string, float64, error) {
+	if t.PageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.PageTimeout)
+		defer cancel()
+	}
+	dir, err := os.MkdirTemp("", "ocr-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(dir)
+	prefix := filepath.Join(dir, "page")
+	n := strconv.Itoa(page)
+	if out, err := exec.CommandContext(ctx, t.pdftoppm, "-f", n, "-l", n, "-r", strconv.Itoa(t.DPI), "-gray", "-png", "-singlefile", pdfPath, prefix).CombinedOutput(); err != nil {
+		return "", 0, fmt.Errorf("ocr: pdftoppm page %d: %v: %s", page, err, bytes.TrimSpace(out))
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.tesseract, prefix+".png", "stdout", "-l", t.Languages, "tsv")
+	cmd.Stderr = &stderr
+	tsv, err := cmd.Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("ocr: tesseract page %d: %v: %s", page, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	text, conf := parseTesseractTSV(tsv)
+	return text, conf, nil
+}
+
+// parseTesseractTSV rebuilds the text of Tesseract's TSV output (one row per word, with block,
+// paragraph and line numbers) and returns the mean word confidence.
+func parseTesseractTSV(tsv []byte) (string, float64) {
+	var b strings.Builder
+	var sum float64
+	var words int
+	lastLine, lastPar := "", ""
+	sc := bufio.NewScanner(bytes.NewReader(tsv))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		cols := strings.Split(sc.Text(), "\t")
+		// level page_num block_num par_num line_num word_num left top width height conf text
+		if len(cols) < 12 || cols[0] != "5" {
+			continue
+		}
+		conf, err := strconv.ParseFloat(cols[10], 64)
+		word := strings.TrimSpace(cols[11])
+		if err != nil || conf < 0 || word == "" {
+			continue
+		}
+		par := cols[2] + "." + cols[3]
+		line := par + "." + cols[4]
+		switch {
+		case b.Len() == 0:
+		case par != lastPar:
+			b.WriteString("\n\n")
+		case line != lastLine:
+			b.WriteString("\n")
+		default:
+			b.WriteString(" ")
+		}
+		b.WriteString(word)
+		lastLine, lastPar = line, par
+		sum += conf
+		words++
+	}
+	if words == 0 {
+		return "", 0
+	}
+	return b.String(), sum / float64(words)
+}
@@ -0,0 +1,70 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeOCR devuelve texto fijo por página y registra las páginas leídas.
+type fakeOCR struct {
+	conf  map[int]float64
+	calls []int
+}
+
+func (f *fakeOCR) Recognize(ctx context.Context, pdfPath string, page int) (string, float64, error) {
+	f.calls = append(f.calls, page)
+	return fmt.Sprintf("texto escaneado de la página %d", page), f.conf[page], nil
+}
+
+func TestOCRPages(t *testing.T) {
+	ocr := &fakeOCR{conf: map[int]float64{1: 91, 3: 20, 4: 88}}
+	texts := []string{"", "Esta página ya tiene una capa de texto suficiente.", "  ", "", ""}
+	pages := make([]PageText, len(texts))
+	for i, text := range texts {
+		pages[i] = PageText{Page: i + 1, Text: text}
+	}
+	got := ocrPages(context.Background(), "scan.pdf", pages, ocr, OCROptions{MinConfidence: 50, MaxPages: 3, Workers: 1})
+
+	if len(got) != len(pages) {
+		t.Fatalf("got %d pages, want %d", len(got), len(pages))
+	}
+	if fmt.Sprint(ocr.calls) != "[1 3 4]" {
+		t.Fatalf("OCR'd pages %v, want [1 3 4] (text pages skipped, MaxPages=3)", ocr.calls)
+	}
+	if p := got[0]; !p.OCR || p.Page != 1 || p.Confidence != 91 || !strings.Contains(p.Text, "página 1") {
+		t.Fatalf("page 1 = %+v", p)
+	}
+	if p := got[1]; p.OCR || p.Text != texts[1] {
+		t.Fatalf("text page rewritten: %+v", p)
+	}
+	if p := got[2]; p.OCR || strings.TrimSpace(p.Text) != "" {
+		t.Fatalf("low-confidence page kept: %+v", p)
+	}
+	if p := got[4]; p.OCR || p.Page != 5 {
+		t.Fatalf("page past MaxPages = %+v", p)
+	}
+}
+
+func TestParseTesseractTSV(t *testing.T) {
+	tsv := strings.Join([]string{
+		"level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext",
+		"1\t1\t0\t0\t0\t0\t0\t0\t2480\t3508\t-1\t",
+		"5\t1\t1\t1\t1\t1\t10\t10\t100\t30\t96.5\tGuía",
+		"5\t1\t1\t1\t1\t2\t120\t10\t100\t30\t90.5\tclínica",
+		"5\t1\t1\t1\t2\t1\t10\t50\t100\t30\t80\tHipertensión",
+		"5\t1\t1\t1\t2\t2\t120\t50\t100\t30\t-1\t ",
+		"5\t1\t2\t1\t1\t1\t10\t200\t100\t30\t73\tTratamiento",
+	}, "\n")
+	text, conf := parseTesseractTSV([]byte(tsv))
+	if want := "Guía clínica\nHipertensión\n\nTratamiento"; text != want {
+		t.Fatalf("text = %q, want %q", text, want)
+	}
+	if conf != 85 {
+		t.Fatalf("confidence = %v, want 85", conf)
+	}
+	if text, conf := parseTesseractTSV(nil); text != "" || conf != 0 {
+		t.Fatalf("empty TSV = %q, %v", text, conf)
+	}
+}
@@ -2,15 +2,122 @@ package files
 
 import (
 	"bytes"
-	"errors"
+	"context"
+	"fmt"
 	"io"
-	"os"
+	"math"
+	"strings"
+	"unicode/utf8"
 
 	pdf "rsc.io/pdf"
 )
 
+// ExtractPDFPages opens a PDF at filePath and returns the text layer of every page, in order
+// (pages[0] is page 1). Pages without text come back empty, so callers can keep page numbers.
+func ExtractPDFPages(filePath string) ([]string, error) {
+	layout, err := ExtractPDFLayout(filePath)
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]string, len(layout))
+	for i, p := range layout {
+		pages[i] = p.Text
+	}
+	return pages, nil
+}
+
+// ExtractPDFLayout is ExtractPDFPages keeping line breaks and the section headings of each page:
+// lines set in a font clearly larger than the body text of the page, or title-like lines
+// ("2.1 Tratamiento", "CAPÍTULO 3") that TextHeadings recognizes.
+func ExtractPDFLayout(filePath string) (pages []PageText, err error) {
+	// rsc.io/pdf panics on malformed content streams
+	defer func() {
+		if r := recover(); r != nil {
+			pages, err = nil, fmt.Errorf("pdf: %v", r)
+		}
+	}()
+	r, err := pdf.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	pages = make([]PageText, r.NumPage())
+	for i := range pages {
+		pages[i].Page = i + 1
+		p := r.Page(i + 1)
+		// blank pages may have no content stream at all
+		if p.V.IsNull() || p.V.Key("Contents").IsNull() {
+			continue
+		}
+		pages[i].Text, pages[i].Headings = layoutText(p.Content().Text)
+	}
+	return pages, nil
+}
+
+// textLine is a run of glyphs sharing a baseline.
+type textLine struct {
+	b    strings.Builder
+	size float64
+}
+
+// layoutText joins the glyphs of a page into lines (a space where glyphs are apart, a newline
+// where the baseline moves) and returns the lines that look like headings.
+func layoutText(glyphs []pdf.Text) (string, []Heading) {
+	var lines []*textLine
+	var cur *textLine
+	var lastY, lastEnd float64
+	chars := map[float64]int{} // font size -> characters, to find the body text size
+	for _, t := range glyphs {
+		if t.S == "" {
+			continue
+		}
+		switch {
+		case cur == nil || math.Abs(t.Y-lastY) > math.Max(t.FontSize, 1)*0.5:
+			cur = &textLine{}
+			lines = append(lines, cur)
+		case t.X-lastEnd > t.FontSize*0.2 && !strings.HasSuffix(cur.b.String(), " ") && !strings.HasPrefix(t.S, " "):
+			cur.b.WriteByte(' ')
+		}
+		cur.b.WriteString(t.S)
+		cur.size = math.Max(cur.size, t.FontSize)
+		chars[math.Round(t.FontSize*2)/2] += utf8.RuneCountInString(t.S)
+		lastY, lastEnd = t.Y, t.X+t.W
+	}
+	var body float64
+	for size, n := range chars {
+		if n > chars[body] || (n == chars[body] && size < body) {
+			body = size
+		}
+	}
+
+	var b strings.Builder
+	var headings []Heading
+	prevHeading := false
+	for _, l := range lines {
+		text := strings.Join(strings.Fields(l.b.String()), " ")
+		if text == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		large := body > 0 && l.size >= body*1.15 && isHeadingLength(text)
+		switch {
+		case large && prevHeading && len(headings) > 0:
+			// a title wrapped over two lines
+			last := &headings[len(headings)-1]
+			last.Text = truncateHeading(last.Text + " " + text)
+		case large || looksLikeHeading(text):
+			headings = append(headings, Heading{Text: truncateHeading(text), Offset: b.Len()})
+		}
+		prevHeading = large
+		b.WriteString(text)
+	}
+	return b.String(), headings
+}
+
 // ExtractPDFText opens a PDF at filePath and returns extracted text up to maxChars.
 // It returns an error if the file can't be read. If maxChars <= 0, a sane default is used.
+// Scanned PDFs go through the OCR set with SetOCR, or fail with ErrNoTextLayer.
 func ExtractPDFText(filePath string, maxChars int) (string, error) {
 	if maxChars <= 0 {
 		maxChars = 12000 // ~2-3k tokens, avoids blowing context
@@ -21,12 +128,11 @@ func ExtractPDFText(filePath string, maxChars int) (string, error) {
 		return "", err
 	}
 
-	// Some PDFs have no text layer; return empty string in that case
 	var buf bytes.Buffer
 	total := r.NumPage()
 	for pageIndex := 1; pageIndex <= total; pageIndex++ {
 		p := r.Page(pageIndex)
-		if p.V.IsNull() {
+		if p.V.IsNull() || p.V.Key("Contents").IsNull() {
 			continue
 		}
 		content := p.Content()
@@ -43,19 +149,27 @@ func ExtractPDFText(filePath string, maxChars int) (string, error) {
 		}
 	}
 
-	// Fallback: If no text extracted, try raw content stream extraction
-	if buf.Len() == 0 {
-		data, err := os.ReadFile(filePath)
+	// No text layer (scanned PDF): OCR the pages when configured; dumping the raw bytes
+	// only produced binary garbage.
+	if len(bytes.TrimSpace(buf.Bytes())) == 0 {
+		if defaultOCR == nil {
+			return "", ErrNoTextLayer
+		}
+		pages, err := ExtractPDFPagesOCR(context.Background(), filePath, defaultOCR, OCROptionsFromEnv())
 		if err != nil {
 			return "", err
 		}
-		if len(data) == 0 {
-			return "", errors.New("pdf appears empty")
+		buf.Reset()
+		for _, p := range pages {
+			if strings.TrimSpace(p.Text) == "" {
+				continue
+			}
+			buf.WriteString(p.Text)
+			buf.WriteString("\n\n")
 		}
-		if len(data) > maxChars {
-			data = data[:maxChars]
+		if buf.Len() == 0 {
+			return "", ErrNoTextLayer
 		}
-		return string(bytes.ReplaceAll(data, []byte{'\x00'}, []byte{' '})), nil
 	}
 	// Trim at maxChars
 	if buf.Len() > maxChars {
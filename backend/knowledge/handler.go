@@ -0,0 +1,148 @@
+package knowledge
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"ema-backend/audit"
+	"ema-backend/login"
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+// store is the part of Repository the admin handler uses.
+type store interface {
+	List() ([]KnowledgeBase, error)
+	Get(id int) (*KnowledgeBase, error)
+	Create(kb *KnowledgeBase) error
+	Update(id int, kb *KnowledgeBase) error
+	Delete(id int) error
+}
+
+// Handler serves the admin CRUD of knowledge bases.
+type Handler struct {
+	repo     store
+	registry *Registry
+	users    migrations.UserStore
+}
+
+// NewHandler builds the handler; registry (may be nil) is refreshed after every change and
+// users resolves the admin token.
+func NewHandler(repo *Repository, registry *Registry, users migrations.UserStore) *Handler {
+	return &Handler{repo: repo, registry: registry, users: users}
+}
+
+// RegisterRoutes mounts /admin/knowledge-bases (admins only, audited).
+func (h *Handler) RegisterRoutes(r *gin.Engine) {
+	adm := r.Group("/admin/knowledge-bases", login.RequireAdmin(h.users), audit.Middleware())
+	adm.GET("", h.list)
+	adm.POST("", h.create)
+	adm.PUT("/:id", h.update)
+	adm.DELETE("/:id", h.delete)
+}
+
+func (h *Handler) refresh() {
+	if err := h.registry.Refresh(); err != nil {
+		log.Printf("[knowledge][refresh] error: %v", err)
+	}
+}
+
+func (h *Handler) list(c *gin.Context) {
+	list, err := h.repo.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *Handler) create(c *gin.Context) {
+	// active defaults to true when omitted
+	body := KnowledgeBase{Active: true}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	if err := body.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.vectorStoreTaken(c, 0, body.VectorStoreID) {
+		return
+	}
+	if err := h.repo.Create(&body); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.refresh()
+	c.JSON(http.StatusCreated, body)
+}
+
+func (h *Handler) update(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	old, err := h.repo.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if old == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "base de conocimiento no encontrada"})
+		return
+	}
+	// fields omitted in the body keep their current value
+	body := *old
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	if err := body.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.vectorStoreTaken(c, id, body.VectorStoreID) {
+		return
+	}
+	body.ID = id
+	if err := h.repo.Update(id, &body); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.refresh()
+	c.JSON(http.StatusOK, body)
+}
+
+func (h *Handler) delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	if err := h.repo.Delete(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.refresh()
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// vectorStoreTaken answers 409 when another knowledge base already uses vectorStoreID.
+func (h *Handler) vectorStoreTaken(c *gin.Context, id int, vectorStoreID string) bool {
+	list, err := h.repo.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	for _, kb := range list {
+		if kb.VectorStoreID == vectorStoreID && kb.ID != id {
+			c.JSON(http.StatusConflict, gin.H{"error": "vector store ya registrado en otra base de conocimiento"})
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package knowledge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryStore is an in-memory store for the handler tests.
+type memoryStore struct {
+	bases  []KnowledgeBase
+	nextID int
+}
+
+func (m *memoryStore) List() ([]KnowledgeBase, error) {
+	return append([]KnowledgeBase{}, m.bases...), nil
+}
+
+func (m *memoryStore) Get(id int) (*KnowledgeBase, error) {
+	for _, kb := range m.bases {
+		if kb.ID == id {
+			return &kb, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) Create(kb *KnowledgeBase) error {
+	m.nextID++
+	kb.ID = m.nextID
+	m.bases = append(m.bases, *kb)
+	return nil
+}
+
+func (m *memoryStore) Update(id int, kb *KnowledgeBase) error {
+	for i := range m.bases {
+		if m.bases[i].ID == id {
+			m.bases[i] = *kb
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) Delete(id int) error {
+	for i := range m.bases {
+		if m.bases[i].ID == id {
+			m.bases = append(m.bases[:i], m.bases[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func TestHandlerCRUD(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := &memoryStore{}
+	reg := NewRegistry(repo.List)
+	h := &Handler{repo: repo, registry: reg}
+	r := gin.New()
+	r.GET("/admin/knowledge-bases", h.list)
+	r.POST("/admin/knowledge-bases", h.create)
+	r.PUT("/admin/knowledge-bases/:id", h.update)
+	r.DELETE("/admin/knowledge-bases/:id", h.delete)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	for _, body := range []string{
+		`{"name":"","vector_store_id":"vs_1"}`,
+		`{"name":"Cardio","vector_store_id":"file-123"}`,
+		`{"name":"Cardio","vector_store_id":"vs_1","flows":["radiology"]}`,
+	} {
+		if w := do(http.MethodPost, "/admin/knowledge-bases", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", body, w.Code, w.Body)
+		}
+	}
+
+	w := do(http.MethodPost, "/admin/knowledge-bases", `{"name":" Cardiología ","specialty":"cardiología","vector_store_id":"vs_cardio","flows":["quiz","quiz"],"category_ids":[7]}`)
+	var kb KnowledgeBase
+	json.Unmarshal(w.Body.Bytes(), &kb)
+	if w.Code != http.StatusCreated || kb.ID == 0 || kb.Name != "Cardiología" || kb.Language != "es" || !kb.Active || len(kb.Flows) != 1 {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	if got := reg.VectorStoreFor(FlowQuiz, 7); got != "vs_cardio" {
+		t.Fatalf("registry not refreshed after create: %s", got)
+	}
+	if w := do(http.MethodPost, "/admin/knowledge-bases", `{"name":"Otra","vector_store_id":"vs_cardio"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate vector store = %d", w.Code)
+	}
+
+	// Partial update: omitted fields keep their value.
+	w = do(http.MethodPut, "/admin/knowledge-bases/1", `{"active":false}`)
+	json.Unmarshal(w.Body.Bytes(), &kb)
+	if w.Code != http.StatusOK || kb.Active || kb.VectorStoreID != "vs_cardio" || len(kb.CategoryIDs) != 1 {
+		t.Fatalf("update = %d %s", w.Code, w.Body)
+	}
+	if got := reg.VectorStoreFor(FlowQuiz, 7); got == "vs_cardio" {
+		t.Fatal("deactivated base still selected")
+	}
+	if w := do(http.MethodPut, "/admin/knowledge-bases/99", `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("update missing = %d", w.Code)
+	}
+
+	if w := do(http.MethodDelete, "/admin/knowledge-bases/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if reg.IsRegistered("vs_cardio") {
+		t.Fatal("deleted base still registered")
+	}
+	if w := do(http.MethodGet, "/admin/knowledge-bases", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"data":[]`) {
+		t.Fatalf("list = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,130 @@
+// Package knowledge keeps the registry of knowledge bases: the OpenAI vector stores (books
+// library, specialty libraries) the AI flows search. Admins manage them under
+// /admin/knowledge-bases; the flows ask VectorStoreFor which store to use, and the vector
+// store cleanup in package openai never deletes a registered one.
+package knowledge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Flows that search a knowledge base.
+const (
+	FlowChat            = "chat"
+	FlowQuiz            = "quiz"
+	FlowClinicalCase    = "clinical_case"
+	FlowInteractiveCase = "interactive_case"
+)
+
+// Flows lists the valid flow names.
+var Flows = []string{FlowChat, FlowQuiz, FlowClinicalCase, FlowInteractiveCase}
+
+// DefaultVectorStoreID is the books library used when no knowledge base is registered
+// (migration 0006 seeds it as the first one).
+const DefaultVectorStoreID = "vs_680fc484cef081918b2b9588b701e2f4"
+
+// KnowledgeBase is a row of knowledge_bases. An empty Flows list means every flow; an
+// empty CategoryIDs list makes it a general base for those flows.
+type KnowledgeBase struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Specialty     string    `json:"specialty"`
+	VectorStoreID string    `json:"vector_store_id"`
+	Language      string    `json:"language"`
+	Active        bool      `json:"active"`
+	Flows         []string  `json:"flows"`
+	CategoryIDs   []int     `json:"category_ids"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// servesFlow reports whether kb may be searched by flow.
+func (kb *KnowledgeBase) servesFlow(flow string) bool {
+	if len(kb.Flows) == 0 {
+		return true
+	}
+	for _, f := range kb.Flows {
+		if f == flow {
+			return true
+		}
+	}
+	return false
+}
+
+// coversCategory reports whether kb is narrowed to one of categoryIDs.
+func (kb *KnowledgeBase) coversCategory(categoryIDs []int) bool {
+	for _, have := range kb.CategoryIDs {
+		for _, want := range categoryIDs {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalize trims the fields, defaults the language and validates kb before saving it.
+func (kb *KnowledgeBase) normalize() error {
+	kb.Name = strings.TrimSpace(kb.Name)
+	kb.Specialty = strings.TrimSpace(kb.Specialty)
+	kb.VectorStoreID = strings.TrimSpace(kb.VectorStoreID)
+	kb.Language = strings.ToLower(strings.TrimSpace(kb.Language))
+	if kb.Name == "" {
+		return fmt.Errorf("name requerido")
+	}
+	if !strings.HasPrefix(kb.VectorStoreID, "vs_") {
+		return fmt.Errorf("vector_store_id inválido (debe empezar con vs_)")
+	}
+	if kb.Language == "" {
+		kb.Language = "es"
+	}
+	flows := make([]string, 0, len(kb.Flows))
+	seen := map[string]bool{}
+	for _, f := range kb.Flows {
+		f = strings.TrimSpace(f)
+		if !validFlow(f) {
+			return fmt.Errorf("flow inválido %q (válidos: %s)", f, strings.Join(Flows, ", "))
+		}
+		if !seen[f] {
+			seen[f] = true
+			flows = append(flows, f)
+		}
+	}
+	kb.Flows = flows
+	ids := make([]int, 0, len(kb.CategoryIDs))
+	seenID := map[int]bool{}
+	for _, id := range kb.CategoryIDs {
+		if id <= 0 {
+			return fmt.Errorf("category_id inválido: %d", id)
+		}
+		if !seenID[id] {
+			seenID[id] = true
+			ids = append(ids, id)
+		}
+	}
+	kb.CategoryIDs = ids
+	return nil
+}
+
+func validFlow(f string) bool {
+	for _, v := range Flows {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeFlows(flows []string) string { return strings.Join(flows, ",") }
+
+func decodeFlows(s string) []string {
+	flows := []string{}
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			flows = append(flows, f)
+		}
+	}
+	return flows
+}
@@ -0,0 +1,155 @@
+package knowledge
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshEvery bounds how stale the in-memory snapshot may get when another instance edits
+// the registry (edits made through this instance refresh it right away).
+const refreshEvery = time.Minute
+
+// Registry answers which vector store a flow should search, from an in-memory snapshot of
+// the knowledge bases. A nil Registry (or one that never loaded) falls back to the env
+// variables and DefaultVectorStoreID, like the code before the registry.
+type Registry struct {
+	load func() ([]KnowledgeBase, error)
+
+	mu       sync.Mutex
+	bases    []KnowledgeBase
+	loadedAt time.Time
+}
+
+// NewRegistry builds a registry over load (usually Repository.List).
+func NewRegistry(load func() ([]KnowledgeBase, error)) *Registry {
+	return &Registry{load: load}
+}
+
+// Refresh reloads the snapshot; on error the previous one is kept.
+func (r *Registry) Refresh() error {
+	if r == nil || r.load == nil {
+		return nil
+	}
+	bases, err := r.load()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	r.bases = bases
+	return nil
+}
+
+// snapshot returns the current knowledge bases, reloading them when stale.
+func (r *Registry) snapshot() []KnowledgeBase {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	stale := time.Since(r.loadedAt) > refreshEvery
+	r.mu.Unlock()
+	if stale {
+		if err := r.Refresh(); err != nil {
+			log.Printf("[knowledge][refresh] error: %v", err)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bases
+}
+
+// VectorStoreFor returns the vector store flow should search. Among the active bases
+// serving the flow, one narrowed to any of categoryIDs beats a general one, and one that
+// lists the flow explicitly beats one serving every flow; ties go to the oldest base. With
+// no match it falls back to the flow's env variable, then DefaultVectorStoreID.
+func (r *Registry) VectorStoreFor(flow string, categoryIDs ...int) string {
+	best, bestScore := "", -1
+	for _, kb := range r.snapshot() {
+		if !kb.Active || !kb.servesFlow(flow) {
+			continue
+		}
+		score := 0
+		switch {
+		case kb.coversCategory(categoryIDs):
+			score = 2
+		case len(kb.CategoryIDs) > 0:
+			continue // a specialty base for other categories
+		}
+		if len(kb.Flows) > 0 {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = kb.VectorStoreID, score
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return fallbackVectorStore(flow)
+}
+
+// IsRegistered reports whether vectorStoreID belongs to a knowledge base (active or not) or
+// is one of the fallbacks, so cleanup jobs must leave it alone.
+func (r *Registry) IsRegistered(vectorStoreID string) bool {
+	vectorStoreID = strings.TrimSpace(vectorStoreID)
+	if vectorStoreID == "" {
+		return false
+	}
+	for _, kb := range r.snapshot() {
+		if kb.VectorStoreID == vectorStoreID {
+			return true
+		}
+	}
+	for _, flow := range Flows {
+		if fallbackVectorStore(flow) == vectorStoreID {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackVectorStore is the store used before the registry existed: the per-flow env
+// variable when set, the shared books library otherwise.
+func fallbackVectorStore(flow string) string {
+	var env string
+	switch flow {
+	case FlowQuiz:
+		env = "CUESTIONARIOS_VECTOR_STORE_ID"
+	case FlowClinicalCase, FlowInteractiveCase:
+		env = "INTERACTIVE_VECTOR_ID"
+	}
+	if env != "" {
+		if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+			return v
+		}
+	}
+	return DefaultVectorStoreID
+}
+
+var defaultRegistry *Registry
+
+// Init sets the registry used by the package-level helpers and loads it.
+func Init(repo *Repository) *Registry {
+	defaultRegistry = NewRegistry(repo.List)
+	if err := defaultRegistry.Refresh(); err != nil {
+		log.Printf("[knowledge][init] error: %v", err)
+	}
+	return defaultRegistry
+}
+
+// Default returns the registry set by Init (nil before).
+func Default() *Registry { return defaultRegistry }
+
+// VectorStoreFor uses the registry set by Init; before Init it returns the fallbacks.
+func VectorStoreFor(flow string, categoryIDs ...int) string {
+	return defaultRegistry.VectorStoreFor(flow, categoryIDs...)
+}
+
+// IsRegistered uses the registry set by Init.
+func IsRegistered(vectorStoreID string) bool {
+	return defaultRegistry.IsRegistered(vectorStoreID)
+}
@@ -0,0 +1,72 @@
+package knowledge
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVectorStoreFor(t *testing.T) {
+	t.Setenv("CUESTIONARIOS_VECTOR_STORE_ID", "")
+	t.Setenv("INTERACTIVE_VECTOR_ID", "vs_env_cases")
+	bases := []KnowledgeBase{
+		{ID: 1, VectorStoreID: "vs_books", Active: true},
+		{ID: 2, VectorStoreID: "vs_cardio", Active: true, CategoryIDs: []int{7}},
+		{ID: 3, VectorStoreID: "vs_cardio_quiz", Active: true, Flows: []string{FlowQuiz}, CategoryIDs: []int{7}},
+		{ID: 4, VectorStoreID: "vs_quiz", Active: true, Flows: []string{FlowQuiz}},
+		{ID: 5, VectorStoreID: "vs_neuro_old", Active: false, CategoryIDs: []int{9}},
+	}
+	r := NewRegistry(func() ([]KnowledgeBase, error) { return bases, nil })
+	for _, tc := range []struct {
+		flow string
+		cats []int
+		want string
+	}{
+		{FlowChat, nil, "vs_books"},
+		{FlowChat, []int{7}, "vs_cardio"},
+		{FlowQuiz, nil, "vs_quiz"},
+		{FlowQuiz, []int{3, 7}, "vs_cardio_quiz"},
+		{FlowQuiz, []int{9}, "vs_quiz"},          // inactive specialty base ignored
+		{FlowClinicalCase, []int{9}, "vs_books"}, // general base beats the env fallback
+	} {
+		if got := r.VectorStoreFor(tc.flow, tc.cats...); got != tc.want {
+			t.Errorf("VectorStoreFor(%s, %v) = %s, want %s", tc.flow, tc.cats, got, tc.want)
+		}
+	}
+	if !r.IsRegistered("vs_neuro_old") || !r.IsRegistered("vs_env_cases") || !r.IsRegistered(DefaultVectorStoreID) || r.IsRegistered("vs_thread_upload") {
+		t.Error("IsRegistered must cover every base (active or not) and the fallbacks")
+	}
+}
+
+func TestVectorStoreFor_Fallbacks(t *testing.T) {
+	t.Setenv("CUESTIONARIOS_VECTOR_STORE_ID", "vs_env_quiz")
+	t.Setenv("INTERACTIVE_VECTOR_ID", "")
+	var nilRegistry *Registry
+	if got := nilRegistry.VectorStoreFor(FlowQuiz, 7); got != "vs_env_quiz" {
+		t.Errorf("quiz fallback = %s", got)
+	}
+	if got := nilRegistry.VectorStoreFor(FlowInteractiveCase); got != DefaultVectorStoreID {
+		t.Errorf("interactive fallback = %s", got)
+	}
+
+	// A failed reload keeps the previous snapshot.
+	fail := false
+	r := NewRegistry(func() ([]KnowledgeBase, error) {
+		if fail {
+			return nil, errors.New("db down")
+		}
+		return []KnowledgeBase{{ID: 1, VectorStoreID: "vs_chat", Active: true, Flows: []string{FlowChat}}}, nil
+	})
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	if err := r.Refresh(); err == nil {
+		t.Fatal("Refresh hid the load error")
+	}
+	if got := r.VectorStoreFor(FlowChat); got != "vs_chat" {
+		t.Errorf("after a failed refresh = %s", got)
+	}
+	if got := r.VectorStoreFor(FlowQuiz); got != "vs_env_quiz" {
+		t.Errorf("flow without a base = %s", got)
+	}
+}
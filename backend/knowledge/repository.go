@@ -0,0 +1,164 @@
+package knowledge
+
+import (
+	"database/sql"
+
+	"ema-backend/conn"
+)
+
+// Repository persists knowledge bases (knowledge_bases, knowledge_base_categories).
+type Repository struct {
+	db      *sql.DB
+	dialect conn.Dialect
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db, dialect: conn.DialectOf(db)}
+}
+
+const kbColumns = `id, name, specialty, vector_store_id, language, flows, active, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKnowledgeBase(row rowScanner) (*KnowledgeBase, error) {
+	var kb KnowledgeBase
+	var flows string
+	var created, updated sql.NullTime
+	if err := row.Scan(&kb.ID, &kb.Name, &kb.Specialty, &kb.VectorStoreID, &kb.Language, &flows, &kb.Active, &created, &updated); err != nil {
+		return nil, err
+	}
+	kb.Flows = decodeFlows(flows)
+	kb.CategoryIDs = []int{}
+	kb.CreatedAt, kb.UpdatedAt = created.Time, updated.Time
+	return &kb, nil
+}
+
+// List returns every knowledge base (active or not) with its categories, by id.
+func (r *Repository) List() ([]KnowledgeBase, error) {
+	rows, err := r.db.Query(`SELECT ` + kbColumns + ` FROM knowledge_bases ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []KnowledgeBase{}
+	index := map[int]int{}
+	for rows.Next() {
+		kb, err := scanKnowledgeBase(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[kb.ID] = len(list)
+		list = append(list, *kb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	catRows, err := r.db.Query(`SELECT knowledge_base_id, category_id FROM knowledge_base_categories ORDER BY knowledge_base_id, category_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer catRows.Close()
+	for catRows.Next() {
+		var kbID, catID int
+		if err := catRows.Scan(&kbID, &catID); err != nil {
+			return nil, err
+		}
+		if i, ok := index[kbID]; ok {
+			list[i].CategoryIDs = append(list[i].CategoryIDs, catID)
+		}
+	}
+	return list, catRows.Err()
+}
+
+// Get returns a knowledge base by id, nil when it doesn't exist.
+func (r *Repository) Get(id int) (*KnowledgeBase, error) {
+	kb, err := scanKnowledgeBase(r.db.QueryRow(`SELECT `+kbColumns+` FROM knowledge_bases WHERE id=?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.Query(`SELECT category_id FROM knowledge_base_categories WHERE knowledge_base_id=? ORDER BY category_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var catID int
+		if err := rows.Scan(&catID); err != nil {
+			return nil, err
+		}
+		kb.CategoryIDs = append(kb.CategoryIDs, catID)
+	}
+	return kb, rows.Err()
+}
+
+// Create inserts kb with its categories and sets kb.ID.
+func (r *Repository) Create(kb *KnowledgeBase) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	id, err := r.dialect.InsertID(tx, `INSERT INTO knowledge_bases (name, specialty, vector_store_id, language, flows, active) VALUES (?,?,?,?,?,?)`,
+		kb.Name, kb.Specialty, kb.VectorStoreID, kb.Language, encodeFlows(kb.Flows), kb.Active)
+	if err != nil {
+		return err
+	}
+	if err := setCategories(tx, int(id), kb.CategoryIDs); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	kb.ID = int(id)
+	return nil
+}
+
+// Update overwrites the knowledge base id and replaces its categories.
+func (r *Repository) Update(id int, kb *KnowledgeBase) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE knowledge_bases SET name=?, specialty=?, vector_store_id=?, language=?, flows=?, active=?, updated_at=CURRENT_TIMESTAMP WHERE id=?`,
+		kb.Name, kb.Specialty, kb.VectorStoreID, kb.Language, encodeFlows(kb.Flows), kb.Active, id); err != nil {
+		return err
+	}
+	if err := setCategories(tx, id, kb.CategoryIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes the knowledge base id (its categories go with it).
+func (r *Repository) Delete(id int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM knowledge_base_categories WHERE knowledge_base_id=?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM knowledge_bases WHERE id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setCategories(tx *sql.Tx, kbID int, categoryIDs []int) error {
+	if _, err := tx.Exec(`DELETE FROM knowledge_base_categories WHERE knowledge_base_id=?`, kbID); err != nil {
+		return err
+	}
+	for _, catID := range categoryIDs {
+		if _, err := tx.Exec(`INSERT INTO knowledge_base_categories (knowledge_base_id, category_id) VALUES (?,?)`, kbID, catID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
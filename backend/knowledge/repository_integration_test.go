@@ -0,0 +1,71 @@
+package knowledge
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"ema-backend/conn/conntest"
+	"ema-backend/migrations"
+)
+
+func TestRepositoryMatrix(t *testing.T) {
+	conntest.ForEach(t, func(t *testing.T, db *sql.DB) {
+		ctx := context.Background()
+		m, err := migrations.NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Up(ctx, migrations.MigrateOptions{}); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		t.Cleanup(func() {
+			all, _ := m.Status(ctx)
+			m.Down(ctx, migrations.MigrateOptions{Steps: len(all)})
+		})
+		var catID int
+		if err := db.QueryRow(`SELECT id FROM medical_categories ORDER BY id LIMIT 1`).Scan(&catID); err == sql.ErrNoRows {
+			if _, err := db.Exec(`INSERT INTO medical_categories (name) VALUES ('Cardiología')`); err != nil {
+				t.Fatal(err)
+			}
+			db.QueryRow(`SELECT id FROM medical_categories ORDER BY id LIMIT 1`).Scan(&catID)
+		}
+		r := NewRepository(db)
+
+		// Migration 0006 seeds the books library.
+		list, err := r.List()
+		if err != nil || len(list) != 1 || list[0].VectorStoreID != DefaultVectorStoreID || !list[0].Active || len(list[0].Flows) != 0 {
+			t.Fatalf("seeded list = %+v, %v", list, err)
+		}
+
+		kb := &KnowledgeBase{Name: "Cardiología", Specialty: "cardiología", VectorStoreID: "vs_cardio", Language: "es", Active: true,
+			Flows: []string{FlowQuiz, FlowChat}, CategoryIDs: []int{catID}}
+		if err := r.Create(kb); err != nil || kb.ID == 0 {
+			t.Fatalf("Create: %v", err)
+		}
+		got, err := r.Get(kb.ID)
+		if err != nil || got == nil || len(got.Flows) != 2 || len(got.CategoryIDs) != 1 || got.CategoryIDs[0] != catID {
+			t.Fatalf("Get = %+v, %v", got, err)
+		}
+
+		got.Active, got.Flows, got.CategoryIDs = false, nil, nil
+		if err := r.Update(kb.ID, got); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if got, _ = r.Get(kb.ID); got.Active || len(got.Flows) != 0 || len(got.CategoryIDs) != 0 {
+			t.Fatalf("after Update = %+v", got)
+		}
+
+		reg := NewRegistry(r.List)
+		if !reg.IsRegistered("vs_cardio") || reg.VectorStoreFor(FlowChat, catID) != DefaultVectorStoreID {
+			t.Fatal("registry over the repository")
+		}
+
+		if err := r.Delete(kb.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if got, err := r.Get(kb.ID); got != nil || err != nil {
+			t.Fatalf("after Delete = %+v, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Config describes an endpoint speaking the OpenAI HTTP API.
+type Config struct {
+	BaseURL   string
+	APIKey    string
+	ChatModel string
+	// FastModel serves Request.Fast (short auxiliary calls such as query translation).
+	FastModel      string
+	EmbeddingModel string
+	// TranscriptionModels are tried in order.
+	TranscriptionModels []string
+}
+
+// Compatible implements Provider over the OpenAI HTTP API: api.openai.com itself or any
+// self-hosted server exposing /v1/chat/completions, /v1/embeddings and /v1/audio/transcriptions.
+type Compatible struct {
+	name string
+	cfg  Config
+	api  *openai.Client
+}
+
+var _ Provider = (*Compatible)(nil)
+
+// NewOpenAI returns the OpenAI provider; without an API key every call fails with ErrNotConfigured.
+func NewOpenAI(cfg Config) *Compatible {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://api.openai.com/v1"
+	}
+	if cfg.ChatModel == "" {
+		cfg.ChatModel = "gpt-4-turbo"
+	}
+	if cfg.FastModel == "" {
+		cfg.FastModel = "gpt-4o-mini"
+	}
+	if cfg.EmbeddingModel == "" {
+		cfg.EmbeddingModel = "text-embedding-3-small"
+	}
+	if len(cfg.TranscriptionModels) == 0 {
+		cfg.TranscriptionModels = []string{"gpt-4o-mini-transcribe", "whisper-1"}
+	}
+	return newCompatible("openai", cfg, cfg.APIKey != "")
+}
+
+// NewLocal returns a provider for a self-hosted OpenAI-compatible server (llama.cpp server,
+// Ollama's /v1, vLLM). The API key is optional; embeddings and transcription need their own
+// model names and report ErrUnsupported otherwise.
+func NewLocal(cfg Config) *Compatible {
+	if cfg.APIKey == "" {
+		cfg.APIKey = "local" // most servers ignore it, but some proxies require the header
+	}
+	if cfg.FastModel == "" {
+		cfg.FastModel = cfg.ChatModel
+	}
+	return newCompatible("local", cfg, cfg.BaseURL != "" && cfg.ChatModel != "")
+}
+
+func newCompatible(name string, cfg Config, configured bool) *Compatible {
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	p := &Compatible{name: name, cfg: cfg}
+	if configured {
+		oc := openai.DefaultConfig(cfg.APIKey)
+		oc.BaseURL = cfg.BaseURL
+		p.api = openai.NewClientWithConfig(oc)
+	}
+	return p
+}
+
+func (p *Compatible) Name() string { return p.name }
+
+func (p *Compatible) chatRequest(req Request) openai.ChatCompletionRequest {
+	model := req.Model
+	if model == "" {
+		model = p.cfg.ChatModel
+		if req.Fast {
+			model = p.cfg.FastModel
+		}
+	}
+	msgs := make([]openai.ChatCompletionMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		msgs[i] = openai.ChatCompletionMessage{Role: m.Role, Content: m.Content}
+	}
+	out := openai.ChatCompletionRequest{
+		Model:       model,
+		Messages:    msgs,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+	if req.JSON {
+		out.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	return out
+}
+
+func (p *Compatible) Complete(ctx context.Context, req Request) (string, error) {
+	if p.api == nil {
+		return "", ErrNotConfigured
+	}
+	resp, err := p.api.CreateChatCompletion(ctx, p.chatRequest(req))
+	if err != nil {
+		return "", fmt.Errorf("llm %s: %w", p.name, err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("llm %s: empty completion", p.name)
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// Stream uses server-sent deltas; servers or models that reject streaming get a single
+// non-streamed completion instead.
+func (p *Compatible) Stream(ctx context.Context, req Request) (<-chan string, error) {
+	if p.api == nil {
+		return nil, ErrNotConfigured
+	}
+	cr := p.chatRequest(req)
+	cr.Stream = true
+	stream, err := p.api.CreateChatCompletionStream(ctx, cr)
+	if err != nil {
+		log.Printf("[llm][%s][stream.init.error] %v", p.name, err)
+		text, err2 := p.Complete(ctx, req)
+		if err2 != nil {
+			return nil, err
+		}
+		out := make(chan string, 1)
+		if text != "" {
+			out <- text
+		}
+		close(out)
+		return out, nil
+	}
+	out := make(chan string)
+	go func() {
+		defer stream.Close()
+		defer close(out)
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Printf("[llm][%s][stream.end] err=%v", p.name, err)
+				}
+				return
+			}
+			if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+				continue
+			}
+			select {
+			case out <- resp.Choices[0].Delta.Content:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (p *Compatible) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if p.api == nil {
+		return nil, ErrNotConfigured
+	}
+	if p.cfg.EmbeddingModel == "" {
+		return nil, ErrUnsupported
+	}
+	resp, err := p.api.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Input: texts, Model: openai.EmbeddingModel(p.cfg.EmbeddingModel)})
+	if err != nil {
+		return nil, fmt.Errorf("llm %s: %w", p.name, err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("llm %s: %d embeddings for %d inputs", p.name, len(resp.Data), len(texts))
+	}
+	out := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(out) {
+			return nil, fmt.Errorf("llm %s: embedding index %d out of range", p.name, d.Index)
+		}
+		out[d.Index] = d.Embedding
+	}
+	return out, nil
+}
+
+// Transcribe tries each transcription model in order and returns the first error if all fail.
+func (p *Compatible) Transcribe(ctx context.Context, filePath string) (string, error) {
+	if p.api == nil {
+		return "", ErrNotConfigured
+	}
+	if len(p.cfg.TranscriptionModels) == 0 {
+		return "", ErrUnsupported
+	}
+	var firstErr error
+	for _, model := range p.cfg.TranscriptionModels {
+		resp, err := p.api.CreateTranscription(ctx, openai.AudioRequest{Model: model, FilePath: filePath})
+		if err == nil {
+			return resp.Text, nil
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("llm %s: %w", p.name, err)
+		}
+	}
+	return "", firstErr
+}
@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Flows that pick their provider with LLM_PROVIDER_<FLOW> (e.g. LLM_PROVIDER_TRANSCRIPTION=local),
+// falling back to LLM_PROVIDER and then to OpenAI.
+const (
+	FlowChat             = "chat"              // free chat completions (/asistente without Assistants)
+	FlowStructured       = "structured"        // JSON answers generated without an assistant
+	FlowQueryTranslation = "query_translation" // Spanish question -> English PubMed terms
+	FlowTranscription    = "transcription"     // audio attachments
+	FlowEmbeddings       = "embeddings"        // local document indexes
+)
+
+const (
+	ProviderOpenAI = "openai"
+	ProviderLocal  = "local"
+	ProviderMock   = "mock" // offline, deterministic answers (see NewMock)
+)
+
+// Registry maps flows to providers.
+type Registry struct {
+	providers map[string]Provider
+	def       string
+	flows     map[string]string
+}
+
+// NewRegistry returns a registry whose flows all use def unless overridden with Route.
+func NewRegistry(def Provider, others ...Provider) *Registry {
+	r := &Registry{providers: map[string]Provider{def.Name(): def}, def: def.Name(), flows: map[string]string{}}
+	for _, p := range others {
+		r.providers[p.Name()] = p
+	}
+	return r
+}
+
+// Route makes flow use the provider called name.
+func (r *Registry) Route(flow, name string) error {
+	if _, ok := r.providers[name]; !ok {
+		return fmt.Errorf("proveedor LLM %q no configurado para el flujo %q", name, flow)
+	}
+	r.flows[flow] = name
+	return nil
+}
+
+// For returns the provider of flow (the default one for unknown flows).
+func (r *Registry) For(flow string) Provider {
+	if name, ok := r.flows[flow]; ok {
+		return r.providers[name]
+	}
+	return r.providers[r.def]
+}
+
+// FromEnv builds the registry from the environment:
+//
+//	LLM_PROVIDER                 default provider: openai (default) | local | mock
+//	LLM_PROVIDER_<FLOW>          per-flow override, e.g. LLM_PROVIDER_CHAT=local
+//	OPENAI_API_KEY, OPENAI_BASE_URL, CHAT_MODEL
+//	LLM_LOCAL_BASE_URL           e.g. http://localhost:11434/v1 (Ollama), http://localhost:8080/v1 (llama.cpp)
+//	LLM_LOCAL_API_KEY, LLM_LOCAL_MODEL, LLM_LOCAL_FAST_MODEL,
+//	LLM_LOCAL_EMBEDDING_MODEL, LLM_LOCAL_TRANSCRIPTION_MODEL
+//
+// The local provider is only available when LLM_LOCAL_BASE_URL and LLM_LOCAL_MODEL are set; the
+// mock provider (offline, deterministic) always is.
+func FromEnv() (*Registry, error) {
+	openaiP := NewOpenAI(Config{
+		BaseURL:   env("OPENAI_BASE_URL"),
+		APIKey:    env("OPENAI_API_KEY"),
+		ChatModel: env("CHAT_MODEL"),
+	})
+	providers := []Provider{openaiP, NewMock(nil)}
+	if base, model := env("LLM_LOCAL_BASE_URL"), env("LLM_LOCAL_MODEL"); base != "" && model != "" {
+		cfg := Config{
+			BaseURL:        base,
+			APIKey:         env("LLM_LOCAL_API_KEY"),
+			ChatModel:      model,
+			FastModel:      env("LLM_LOCAL_FAST_MODEL"),
+			EmbeddingModel: env("LLM_LOCAL_EMBEDDING_MODEL"),
+		}
+		if m := env("LLM_LOCAL_TRANSCRIPTION_MODEL"); m != "" {
+			cfg.TranscriptionModels = []string{m}
+		}
+		providers = append(providers, NewLocal(cfg))
+	}
+	r := NewRegistry(openaiP, providers[1:]...)
+	if def := strings.ToLower(env("LLM_PROVIDER")); def != "" {
+		p, ok := r.providers[def]
+		if !ok {
+			return nil, fmt.Errorf("LLM_PROVIDER %q no configurado (openai | mock | local con LLM_LOCAL_BASE_URL y LLM_LOCAL_MODEL)", def)
+		}
+		r.def = p.Name()
+	}
+	for _, flow := range []string{FlowChat, FlowStructured, FlowQueryTranslation, FlowTranscription, FlowEmbeddings} {
+		name := strings.ToLower(env("LLM_PROVIDER_" + strings.ToUpper(flow)))
+		if name == "" {
+			continue
+		}
+		if err := r.Route(flow, name); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// env reads a variable without surrounding spaces or quotes.
+func env(key string) string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		v = v[1 : len(v)-1]
+	}
+	return v
+}
@@ -0,0 +1,93 @@
+// Package llm is the provider-neutral interface to language models: chat completion (whole or
+// streamed), structured JSON, embeddings and audio transcription. The openai package keeps the
+// OpenAI-only features (Assistants threads, Responses conversations, vector stores); the plain
+// model calls go through a Provider chosen per flow (see Registry), so a flow can run on OpenAI or
+// on a self-hosted OpenAI-compatible server (llama.cpp, Ollama, vLLM).
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+var (
+	// ErrNotConfigured is returned by a provider missing its key or endpoint.
+	ErrNotConfigured = errors.New("llm: provider not configured")
+	// ErrUnsupported is returned for a capability the provider has no model for
+	// (e.g. transcription on a local server without LLM_LOCAL_TRANSCRIPTION_MODEL).
+	ErrUnsupported = errors.New("llm: capability not supported by provider")
+)
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is a chat completion. Model is a provider-specific override; leave it empty to use the
+// provider's chat model, or its fast model when Fast is set.
+type Request struct {
+	Model       string
+	Fast        bool
+	Messages    []Message
+	Temperature float32
+	MaxTokens   int
+	// JSON asks for a single JSON object as the answer (response_format json_object).
+	JSON bool
+}
+
+// Provider is implemented by every model backend.
+type Provider interface {
+	// Name identifies the backend ("openai", "local", "mock") in logs and configuration.
+	Name() string
+	Complete(ctx context.Context, req Request) (string, error)
+	// Stream emits the answer in pieces and closes the channel at the end; errors after the
+	// first piece only end the stream early.
+	Stream(ctx context.Context, req Request) (<-chan string, error)
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Transcribe(ctx context.Context, filePath string) (string, error)
+}
+
+// CompleteJSON runs req in JSON mode and decodes the answer into out. Code fences around the
+// object, which some local models add anyway, are stripped.
+func CompleteJSON(ctx context.Context, p Provider, req Request, out any) error {
+	req.JSON = true
+	text, err := p.Complete(ctx, req)
+	if err != nil {
+		return err
+	}
+	text = stripCodeFence(text)
+	if err := json.Unmarshal([]byte(text), out); err != nil {
+		return fmt.Errorf("llm: %s returned invalid JSON: %w", p.Name(), err)
+	}
+	return nil
+}
+
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") {
+		return s
+	}
+	s = strings.TrimPrefix(s, "```")
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:] // language tag ("json")
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "```"))
+}
+
+// Prompt builds the usual system + user request.
+func Prompt(system, user string) Request {
+	var msgs []Message
+	if strings.TrimSpace(system) != "" {
+		msgs = append(msgs, Message{Role: RoleSystem, Content: system})
+	}
+	return Request{Messages: append(msgs, Message{Role: RoleUser, Content: user})}
+}
@@ -0,0 +1,190 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeServer speaks the subset of the OpenAI API that local servers implement.
+func fakeServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var models []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model          string          `json:"model"`
+			Stream         bool            `json:"stream"`
+			Messages       []Message       `json:"messages"`
+			ResponseFormat json.RawMessage `json:"response_format"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+		answer := "eco: " + req.Messages[len(req.Messages)-1].Content
+		if len(req.ResponseFormat) > 0 {
+			answer = "```json\n{\"ok\": true, \"model\": \"" + req.Model + "\"}\n```"
+		}
+		if !req.Stream {
+			fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":%q}}]}`, answer)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range strings.SplitAfter(answer, " ") {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", piece)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	mux.HandleFunc("/v1/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		// Out of order on purpose: results are matched by index
+		fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`)
+	})
+	mux.HandleFunc("/v1/audio/transcriptions", func(w http.ResponseWriter, r *http.Request) {
+		model := r.FormValue("model")
+		models = append(models, model)
+		if model != "whisper-local" {
+			http.Error(w, `{"error":{"message":"unknown model"}}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"text":"hola doctor"}`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &models
+}
+
+func TestLocalProvider(t *testing.T) {
+	srv, models := fakeServer(t)
+	p := NewLocal(Config{
+		BaseURL:             srv.URL + "/v1/",
+		ChatModel:           "llama3",
+		FastModel:           "llama3-mini",
+		EmbeddingModel:      "nomic-embed",
+		TranscriptionModels: []string{"missing", "whisper-local"},
+	})
+	ctx := context.Background()
+
+	if got, err := p.Complete(ctx, Prompt("sé breve", "hola")); err != nil || got != "eco: hola" {
+		t.Fatalf("Complete = %q, %v", got, err)
+	}
+	ch, err := p.Stream(ctx, Request{Fast: true, Messages: []Message{{Role: RoleUser, Content: "uno dos tres"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pieces []string
+	for tok := range ch {
+		pieces = append(pieces, tok)
+	}
+	if len(pieces) != 4 || strings.Join(pieces, "") != "eco: uno dos tres" {
+		t.Fatalf("Stream pieces = %q", pieces)
+	}
+	var out struct {
+		OK    bool   `json:"ok"`
+		Model string `json:"model"`
+	}
+	if err := CompleteJSON(ctx, p, Prompt("", "json"), &out); err != nil || !out.OK || out.Model != "llama3" {
+		t.Fatalf("CompleteJSON = %+v, %v", out, err)
+	}
+	if want := []string{"llama3", "llama3-mini", "llama3"}; strings.Join(*models, ",") != strings.Join(want, ",") {
+		t.Fatalf("models = %v, want %v", *models, want)
+	}
+
+	vecs, err := p.Embed(ctx, []string{"a", "b"})
+	if err != nil || len(vecs) != 2 || vecs[0][0] != 1 || vecs[1][1] != 1 {
+		t.Fatalf("Embed = %v, %v", vecs, err)
+	}
+
+	audio := filepath.Join(t.TempDir(), "nota.m4a")
+	os.WriteFile(audio, []byte("audio"), 0o644)
+	if text, err := p.Transcribe(ctx, audio); err != nil || text != "hola doctor" {
+		t.Fatalf("Transcribe = %q, %v", text, err)
+	}
+}
+
+func TestUnconfiguredProviders(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewOpenAI(Config{}).Complete(ctx, Prompt("", "hola")); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("OpenAI without key: %v", err)
+	}
+	local := NewLocal(Config{BaseURL: "http://127.0.0.1:1/v1", ChatModel: "llama3"})
+	if _, err := local.Embed(ctx, []string{"a"}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Embed without model: %v", err)
+	}
+	if _, err := local.Transcribe(ctx, "x.mp3"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Transcribe without model: %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("LLM_LOCAL_BASE_URL", "http://localhost:11434/v1")
+	t.Setenv("LLM_LOCAL_MODEL", "llama3")
+	t.Setenv("LLM_PROVIDER_TRANSCRIPTION", "Local")
+	r, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.For(FlowChat).Name() != ProviderOpenAI || r.For(FlowTranscription).Name() != ProviderLocal {
+		t.Fatalf("chat=%s transcription=%s", r.For(FlowChat).Name(), r.For(FlowTranscription).Name())
+	}
+
+	t.Setenv("LLM_PROVIDER", "local")
+	if r, err = FromEnv(); err != nil || r.For(FlowEmbeddings).Name() != ProviderLocal {
+		t.Fatalf("default local: %v", err)
+	}
+
+	t.Setenv("LLM_LOCAL_BASE_URL", "")
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("LLM_PROVIDER=local without LLM_LOCAL_BASE_URL should fail")
+	}
+}
+
+func TestMockProvider(t *testing.T) {
+	ctx := context.Background()
+	p := NewMock(nil)
+	a, _ := p.Complete(ctx, Prompt("", "hola"))
+	b, _ := p.Complete(ctx, Prompt("", "hola"))
+	if a != b || !strings.Contains(a, "hola") {
+		t.Fatalf("Complete not deterministic: %q vs %q", a, b)
+	}
+	var out map[string]any
+	if err := CompleteJSON(ctx, p, Prompt("", "json"), &out); err != nil {
+		t.Fatalf("CompleteJSON: %v", err)
+	}
+	ch, err := p.Stream(ctx, Prompt("", "uno dos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pieces []string
+	for tok := range ch {
+		pieces = append(pieces, tok)
+	}
+	if strings.Join(pieces, "") != a[:strings.Index(a, "hola")]+"uno dos" || len(pieces) < 2 {
+		t.Fatalf("Stream pieces = %q", pieces)
+	}
+
+	vecs, err := p.Embed(ctx, []string{"dolor torácico agudo", "dolor torácico", "fractura de cadera"})
+	if err != nil || len(vecs) != 3 || len(vecs[0]) != MockEmbeddingDims {
+		t.Fatalf("Embed = %v, %v", vecs, err)
+	}
+	dot := func(x, y []float32) (s float32) {
+		for i := range x {
+			s += x[i] * y[i]
+		}
+		return s
+	}
+	if dot(vecs[0], vecs[1]) <= dot(vecs[0], vecs[2]) {
+		t.Fatal("texts sharing words should be closer")
+	}
+
+	t.Setenv("LLM_PROVIDER", "mock")
+	if r, err := FromEnv(); err != nil || r.For(FlowTranscription).Name() != ProviderMock {
+		t.Fatalf("LLM_PROVIDER=mock: %v", err)
+	}
+}
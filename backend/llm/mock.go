@@ -0,0 +1,109 @@
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// MockEmbeddingDims is the length of the vectors returned by the mock provider.
+const MockEmbeddingDims = 64
+
+// Mock implements Provider without network access: the same request always gets the same answer,
+// which keeps demos and integration tests reproducible.
+type Mock struct {
+	answer func(Request) string
+}
+
+var _ Provider = (*Mock)(nil)
+
+// NewMock returns the mock provider. answer builds the reply of each request; nil echoes the last
+// user message (JSON requests get an empty object).
+func NewMock(answer func(Request) string) *Mock {
+	if answer == nil {
+		answer = echoAnswer
+	}
+	return &Mock{answer: answer}
+}
+
+func echoAnswer(req Request) string {
+	if req.JSON {
+		return "{}"
+	}
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == RoleUser {
+			return "Respuesta simulada: " + strings.TrimSpace(req.Messages[i].Content)
+		}
+	}
+	return "Respuesta simulada."
+}
+
+func (m *Mock) Name() string { return ProviderMock }
+
+func (m *Mock) Complete(ctx context.Context, req Request) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return m.answer(req), nil
+}
+
+// Stream emits the answer word by word, like a real server.
+func (m *Mock) Stream(ctx context.Context, req Request) (<-chan string, error) {
+	text, err := m.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for _, piece := range strings.SplitAfter(text, " ") {
+			if piece == "" {
+				continue
+			}
+			select {
+			case out <- piece:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Embed hashes the words of each text into a normalized bag-of-words vector, so texts sharing
+// vocabulary score higher under cosine similarity.
+func (m *Mock) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		v := make([]float32, MockEmbeddingDims)
+		for _, w := range strings.Fields(strings.ToLower(t)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(w, ".,;:¿?¡!()\"'")))
+			v[h.Sum32()%MockEmbeddingDims]++
+		}
+		var norm float64
+		for _, x := range v {
+			norm += float64(x * x)
+		}
+		if norm > 0 {
+			inv := float32(1 / math.Sqrt(norm))
+			for j := range v {
+				v[j] *= inv
+			}
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (m *Mock) Transcribe(ctx context.Context, filePath string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return "Transcripción simulada de " + filepath.Base(filePath) + ".", nil
+}
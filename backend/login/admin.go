@@ -0,0 +1,72 @@
+package login
+
+import (
+	"net/http"
+	"strings"
+
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles stored in users.role. Admin endpoints accept admin and super_admin; only
+// super_admin may change roles.
+const (
+	RoleUser       = "user"
+	RoleAdmin      = "admin"
+	RoleSuperAdmin = "super_admin"
+)
+
+const adminUserKey = "admin_user"
+
+// IsAdminRole reports whether role grants access to the admin API.
+func IsAdminRole(role string) bool {
+	role = strings.ToLower(strings.TrimSpace(role))
+	return role == RoleAdmin || role == RoleSuperAdmin
+}
+
+// RequireRole only lets through requests whose Bearer token belongs to a user of users with
+// one of roles. The user is stored in the context (see AdminUser).
+func RequireRole(users migrations.UserStore, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token requerido"})
+			return
+		}
+		email, ok := GetEmailFromToken(token)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "sesión inválida"})
+			return
+		}
+		u := users.GetUserByEmail(email)
+		if u == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "usuario no encontrado"})
+			return
+		}
+		role := strings.ToLower(strings.TrimSpace(u.Role))
+		for _, r := range roles {
+			if role == r {
+				c.Set(adminUserKey, u)
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "acceso restringido a administradores"})
+	}
+}
+
+// RequireAdmin is RequireRole(users, admin, super_admin).
+func RequireAdmin(users migrations.UserStore) gin.HandlerFunc {
+	return RequireRole(users, RoleAdmin, RoleSuperAdmin)
+}
+
+// AdminUser returns the user authenticated by RequireRole.
+func AdminUser(c *gin.Context) *migrations.User {
+	if v, ok := c.Get(adminUserKey); ok {
+		if u, ok := v.(*migrations.User); ok {
+			return u
+		}
+	}
+	return nil
+}
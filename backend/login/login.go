@@ -93,7 +93,25 @@ func GetEmailFromToken(token string) (string, bool) {
 	return tp.Email, true
 }
 
-func Handler(c *gin.Context) {
+// Handler serves the auth endpoints that read or write users.
+type Handler struct {
+	users migrations.UserStore
+}
+
+func NewHandler(users migrations.UserStore) *Handler { return &Handler{users: users} }
+
+// RegisterRoutes registers the auth routes expected by the app.
+func (h *Handler) RegisterRoutes(r *gin.Engine) {
+	r.POST("/login", h.Login)
+	r.GET("/session", h.Session)
+	r.POST("/logout", LogoutHandler)
+	r.POST("/session/refresh", RefreshHandler)
+	r.POST("/register", h.Register)
+	r.POST("/password/forgot", ForgotPasswordHandler)
+	r.POST("/password/change", h.ChangePassword)
+}
+
+func (h *Handler) Login(c *gin.Context) {
 	var creds Credentials
 	if err := c.ShouldBindJSON(&creds); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
@@ -104,7 +122,7 @@ func Handler(c *gin.Context) {
 	creds.Email = strings.TrimSpace(strings.ToLower(creds.Email))
 	creds.Password = strings.TrimSpace(creds.Password)
 
-	user := migrations.GetUserByEmail(creds.Email)
+	user := h.users.GetUserByEmail(creds.Email)
 	if user != nil && user.Password == creds.Password {
 		dur := sessionDurations(creds.Remember)
 		token, exp, _ := signToken(user.Email, dur, creds.Remember)
@@ -128,7 +146,7 @@ func Handler(c *gin.Context) {
 	}
 }
 
-func SessionHandler(c *gin.Context) {
+func (h *Handler) Session(c *gin.Context) {
 	auth := c.GetHeader("Authorization")
 	token := strings.TrimPrefix(auth, "Bearer ")
 	if token == "" { c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido"}); return }
@@ -138,7 +156,7 @@ func SessionHandler(c *gin.Context) {
 		return
 	}
 	email := tp.Email
-	user := migrations.GetUserByEmail(email)
+	user := h.users.GetUserByEmail(email)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
 		return
@@ -176,7 +194,7 @@ type RegisterPayload struct {
 	Password  string `json:"password"`
 }
 
-func RegisterHandler(c *gin.Context) {
+func (h *Handler) Register(c *gin.Context) {
 	var p RegisterPayload
 	if err := c.ShouldBindJSON(&p); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
@@ -186,14 +204,14 @@ func RegisterHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Campos requeridos faltantes"})
 		return
 	}
-	if exists, err := migrations.EmailExists(p.Email); err != nil {
+	if exists, err := h.users.EmailExists(p.Email); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error validando usuario"})
 		return
 	} else if exists {
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "El correo ya está registrado"})
 		return
 	}
-	if err := migrations.CreateUser(p.FirstName, p.LastName, p.Email, p.Password, "user"); err != nil {
+	if err := h.users.CreateUser(p.FirstName, p.LastName, p.Email, p.Password, RoleUser); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear el usuario"})
 		return
 	}
@@ -222,7 +240,7 @@ type ChangePasswordPayload struct {
 	NewPassword string `json:"new_password"`
 }
 
-func ChangePasswordHandler(c *gin.Context) {
+func (h *Handler) ChangePassword(c *gin.Context) {
 	var p ChangePasswordPayload
 	if err := c.ShouldBindJSON(&p); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
@@ -235,12 +253,12 @@ func ChangePasswordHandler(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido"})
 		return
 	}
-	user := migrations.GetUserByEmail(userEmail)
+	user := h.users.GetUserByEmail(userEmail)
 	if user == nil || user.Password != p.OldPassword {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciales inválidas"})
 		return
 	}
-	if err := migrations.UpdateUserPassword(user.ID, p.NewPassword); err != nil {
+	if err := h.users.UpdateUserPassword(user.ID, p.NewPassword); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo actualizar la contraseña"})
 		return
 	}
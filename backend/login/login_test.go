@@ -0,0 +1,99 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+func do(r *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRegisterLoginChangePassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("SMTP_HOST", "") // no emails
+	store := migrations.NewMemoryStore()
+	r := gin.New()
+	NewHandler(store).RegisterRoutes(r)
+
+	reg := RegisterPayload{FirstName: "Ana", LastName: "Pérez", Email: "ana@example.com", Password: "secret"}
+	if w := do(r, http.MethodPost, "/register", "", reg); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	if w := do(r, http.MethodPost, "/register", "", reg); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("duplicate register: %d", w.Code)
+	}
+	if u := store.GetUserByEmail("ana@example.com"); u == nil || u.Role != RoleUser {
+		t.Fatalf("stored user = %+v", u)
+	}
+
+	if w := do(r, http.MethodPost, "/login", "", Credentials{Email: "ana@example.com", Password: "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: %d", w.Code)
+	}
+	w := do(r, http.MethodPost, "/login", "", Credentials{Email: " ANA@example.com ", Password: "secret"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	var res struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	if email, ok := GetEmailFromToken(res.Token); !ok || email != "ana@example.com" {
+		t.Fatalf("token email = %q %v", email, ok)
+	}
+	if w := do(r, http.MethodGet, "/session", res.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("session: %d", w.Code)
+	}
+
+	change := ChangePasswordPayload{OldPassword: "secret", NewPassword: "new-secret"}
+	if w := do(r, http.MethodPost, "/password/change", res.Token, change); w.Code != http.StatusOK {
+		t.Fatalf("change password: %d %s", w.Code, w.Body)
+	}
+	if u := store.GetUserByEmail("ana@example.com"); u.Password != "new-secret" {
+		t.Fatalf("password not updated")
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := migrations.NewMemoryStore()
+	store.AddUser(migrations.User{FirstName: "Ana", Email: "ana@example.com", Password: "pw", Role: RoleAdmin})
+	store.AddUser(migrations.User{FirstName: "Luis", Email: "luis@example.com", Password: "pw", Role: RoleUser})
+	r := gin.New()
+	NewHandler(store).RegisterRoutes(r)
+	r.GET("/admin/ping", RequireAdmin(store), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"email": AdminUser(c).Email})
+	})
+	token := func(email string) string {
+		var res struct {
+			Token string `json:"token"`
+		}
+		_ = json.Unmarshal(do(r, http.MethodPost, "/login", "", Credentials{Email: email, Password: "pw"}).Body.Bytes(), &res)
+		return res.Token
+	}
+
+	if w := do(r, http.MethodGet, "/admin/ping", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: %d", w.Code)
+	}
+	if w := do(r, http.MethodGet, "/admin/ping", token("luis@example.com"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("plain user: %d", w.Code)
+	}
+	if w := do(r, http.MethodGet, "/admin/ping", token("ana@example.com"), nil); w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("ana@example.com")) {
+		t.Fatalf("admin: %d %s", w.Code, w.Body)
+	}
+}
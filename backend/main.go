@@ -2,15 +2,15 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	"ema-backend/admin"
+	"ema-backend/audit"
 	"ema-backend/casos_clinico"
 	"ema-backend/casos_interactivos"
 	"ema-backend/categories"
@@ -18,12 +18,18 @@ import (
 	"ema-backend/conn"
 	"ema-backend/conversations_ia"
 	"ema-backend/countries"
+	"ema-backend/docindex"
+	"ema-backend/entitlements"
+	"ema-backend/files"
+	"ema-backend/knowledge"
+	"ema-backend/llm"
 	"ema-backend/login"
 	"ema-backend/marketing"
 	"ema-backend/migrations"
 	"ema-backend/openai"
 	"ema-backend/profile"
 	"ema-backend/quota"
+	"ema-backend/stats"
 	"ema-backend/subscriptions"
 	"ema-backend/testsapi"
 
@@ -45,8 +51,8 @@ func main() {
 		log.Printf("no .env file found, using environment variables: %v", err)
 	}
 
-	// Connect to MySQL
-	db, err := conn.NewMySQL()
+	// Connect to MySQL or Postgres (DB_DRIVER)
+	db, err := conn.Open()
 	if err != nil {
 		log.Fatalf("database connection failed: %v", err)
 	}
@@ -54,6 +60,14 @@ func main() {
 
 	// Initialize migrations package with DB and run migrations
 	migrations.Init(db)
+	audit.Init(db)
+	// `ema-backend migrate <status|up|down>`: schema migrations only, then exit
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrations.RunCLI(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
 	if err := migrations.Migrate(); err != nil {
 		log.Fatalf("migrations failed: %v", err)
 	}
@@ -86,6 +100,11 @@ func main() {
 		}()
 		c.Next()
 	})
+	// Los runs de OpenAI se cancelan cuando el cliente se desconecta (ver openai.WithClientContext)
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(openai.WithClientContext(c.Request.Context()))
+		c.Next()
+	})
 	// Attach token expiry header middleware globally
 	r.Use(login.TokenExpiryHeader())
 
@@ -123,6 +142,10 @@ func main() {
 
 	// Initialize OpenAI client BEFORE routes that reference it
 	openai.SetPersistDB(db)
+	// Knowledge bases (vector stores per flow/category); loaded before the client so its
+	// vector store cleanup never deletes a registered one
+	kbRepo := knowledge.NewRepository(db)
+	kbRegistry := knowledge.Init(kbRepo)
 	ai := openai.NewClient()
 
 	// Health check extendido (needs ai)
@@ -135,17 +158,16 @@ func main() {
 		})
 	})
 
+	// Users, subscription quotas and test history for the HTTP packages
+	store := migrations.NewSQLStore(db)
+	// Assistant tool (function calling): the user's questionnaire history
+	ai.RegisterTool(stats.QuizHistoryTool(store))
+
 	// Auth routes expected by Flutter
-	r.POST("/login", login.Handler)
-	r.GET("/session", login.SessionHandler)
-	r.POST("/logout", login.LogoutHandler)
-	r.POST("/session/refresh", login.RefreshHandler)
-	r.POST("/register", login.RegisterHandler)
-	r.POST("/password/forgot", login.ForgotPasswordHandler)
-	r.POST("/password/change", login.ChangePasswordHandler)
+	login.NewHandler(store).RegisterRoutes(r)
 
 	// Profile routes and static media
-	profile.RegisterRoutes(r)
+	profile.NewHandler(store, store, store).RegisterRoutes(r)
 	mediaRoot := strings.TrimSpace(os.Getenv("MEDIA_ROOT"))
 	if mediaRoot == "" {
 		mediaRoot = "./media"
@@ -159,17 +181,14 @@ func main() {
 
 	// Subscriptions & quota
 	subRepo := subscriptions.NewRepository(db)
-	subHandler := subscriptions.NewHandler(subRepo)
+	subHandler := subscriptions.NewHandler(subRepo, store)
 	subHandler.RegisterRoutes(r)
-	// Quota validator setup: user resolver via migrations (minimal projection)
-	quota.RegisterUserResolver(func(email string) *quota.UserLite {
-		u := migrations.GetUserByEmail(email)
-		if u == nil {
-			return nil
-		}
-		return &quota.UserLite{ID: u.ID, Email: u.Email}
-	})
-	qValidator := quota.NewValidator(subRepo)
+	// Quota renewal per billing interval (monthly/annual)
+	subscriptions.NewRenewer(subRepo).Start()
+	qValidator := quota.NewValidator(store, store)
+	// Plan feature entitlements (PubMed, images, PDF size, premium model...) and GET /me/entitlements
+	entitlements.Init(subRepo, store)
+	entitlements.RegisterRoutes(r)
 
 	// Countries route (simple list)
 	countries.RegisterRoutes(r)
@@ -178,6 +197,8 @@ func main() {
 	catRepo := categories.NewRepository(db)
 	catHandler := categories.NewHandler(catRepo)
 	catHandler.RegisterRoutes(r)
+	// Admin CRUD of knowledge bases: /admin/knowledge-bases
+	knowledge.NewHandler(kbRepo, kbRegistry, store).RegisterRoutes(r)
 
 	// Chat/OpenAI endpoints (optional if keys provided) - client already initialized above
 	chatHandler := chat.NewHandler(ai)
@@ -193,8 +214,35 @@ func main() {
 	// Nuevo chat migrado (Assistants v2 estricto)
 	convHandler := conversations_ia.NewHandler(ai)
 	convHandler.SetQuotaValidator(qValidator.ValidateAndConsume)
+	convHandler.SetHistory(conversations_ia.NewSQLConversationStore(db), store)
+	convHandler.SetTranscripts(conversations_ia.NewSQLTranscriptStore(db))
+	// Scanned PDFs (no text layer) are OCR'd with pdftoppm + tesseract when OCR_ENABLED=1
+	var ocr files.OCR
+	if t, err := files.NewTesseractFromEnv(); err != nil {
+		log.Printf("[ocr][disabled] %v", err)
+	} else if t != nil {
+		ocr = t
+		files.SetOCR(t)
+		convHandler.SetOCR(t)
+	}
+	// Uploaded PDFs go to the in-process index (document_chunks) instead of a new OpenAI vector
+	// store per upload; embeddings use the llm embeddings flow (LLM_PROVIDER_EMBEDDINGS)
+	if strings.TrimSpace(os.Getenv("PDF_LOCAL_INDEX")) == "1" {
+		idx := docindex.New(docindex.NewSQLStore(db), ai.LLM(llm.FlowEmbeddings))
+		if ocr != nil {
+			idx.SetOCR(ocr)
+		}
+		convHandler.SetDocumentIndex(idx)
+	}
 	r.POST("/conversations/start", convHandler.Start)
 	r.POST("/conversations/message", convHandler.Message)
+	// Botón "detener": cancela el run/response en curso del hilo
+	r.POST("/conversations/cancel", convHandler.Cancel)
+	// Historial por usuario: listar, renombrar/archivar y borrar (token requerido)
+	r.GET("/conversations", convHandler.ListConversations)
+	r.GET("/conversations/:thread_id/messages", convHandler.ConversationMessages)
+	r.PATCH("/conversations/:thread_id", convHandler.UpdateConversation)
+	r.DELETE("/conversations/:thread_id", convHandler.DeleteConversation)
 	// Debug config (non-secret) – can be protected later behind ENV
 	r.GET("/conversations/debug/config", convHandler.DebugConfig)
 	// Paridad: limpieza y vector store
@@ -251,7 +299,16 @@ func main() {
 			c.JSON(404, gin.H{"error": "suscripción no encontrada"})
 			return
 		}
-		c.JSON(200, gin.H{"plan": sub.Plan, "subscription_id": sub.ID})
+		c.JSON(200, gin.H{
+			"plan":               sub.Plan,
+			"subscription_id":    sub.ID,
+			"status":             sub.Status,
+			"start_date":         sub.StartDate,
+			"end_date":           sub.EndDate,
+			"current_period_end": sub.CurrentPeriodEnd,
+			"trial_ends_at":      sub.TrialEndsAt,
+			"scheduled_plan_id":  sub.ScheduledPlanID,
+		})
 	})
 
 	// Plans + active plan id (for UI highlight)
@@ -282,11 +339,13 @@ func main() {
 		if sub != nil {
 			activeID = sub.PlanID
 		}
+		country := subscriptions.RequestCountry(c, u)
 		list := []gin.H{}
 		for _, p := range plans {
-			list = append(list, gin.H{"id": p.ID, "name": p.Name, "price": p.Price, "billing": p.Billing, "consultations": p.Consultations, "questionnaires": p.Questionnaires, "clinical_cases": p.ClinicalCases, "files": p.Files, "currency": p.Currency, "active": p.ID == activeID})
+			lp, _ := p.ResolveLocalizedPrice(subscriptions.FrequencyDefault, country)
+			list = append(list, gin.H{"id": p.ID, "name": p.Name, "price": lp.Price, "billing": p.Billing, "interval": p.Interval, "interval_count": p.IntervalCount, "prices": p.LocalizedPrices(country), "consultations": p.Consultations, "questionnaires": p.Questionnaires, "clinical_cases": p.ClinicalCases, "files": p.Files, "currency": lp.Currency, "active": p.ID == activeID})
 		}
-		c.JSON(200, gin.H{"plans": list, "active_plan_id": activeID})
+		c.JSON(200, gin.H{"plans": list, "active_plan_id": activeID, "country": country})
 	})
 
 	// Tests (quizzes) endpoints for Flutter
@@ -310,85 +369,8 @@ func main() {
 
 	// Removed legacy stats stub endpoints (now served via /user-overview aggregate)
 
-	// Debug endpoint to reset clinical_cases quota for current token (ONLY for local dev)
-	r.POST("/debug/reset-clinical-cases", func(c *gin.Context) {
-		auth := c.GetHeader("Authorization")
-		token := strings.TrimPrefix(auth, "Bearer ")
-		if token == "" {
-			c.JSON(401, gin.H{"error": "token requerido"})
-			return
-		}
-		email, ok := login.GetEmailFromToken(token)
-		if !ok {
-			c.JSON(401, gin.H{"error": "sesión inválida"})
-			return
-		}
-		u := migrations.GetUserByEmail(email)
-		if u == nil {
-			c.JSON(404, gin.H{"error": "usuario no encontrado"})
-			return
-		}
-		var body struct {
-			Value int `json:"value"`
-		}
-		if err := c.ShouldBindJSON(&body); err != nil || body.Value < 0 {
-			c.JSON(400, gin.H{"error": "value inválido"})
-			return
-		}
-		sub, err := subRepo.GetActiveSubscription(u.ID)
-		if err != nil || sub == nil {
-			c.JSON(404, gin.H{"error": "suscripción no encontrada"})
-			return
-		}
-		if err := subRepo.SetQuotaValue(sub.ID, "clinical_cases", body.Value); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(200, gin.H{"status": "ok", "subscription_id": sub.ID, "clinical_cases": body.Value})
-	})
-
-	// Dev helper: forzar creación de suscripción (evita esperar webhook). Requiere APP_ENV=dev
-	r.POST("/dev/force-subscribe", func(c *gin.Context) {
-		if os.Getenv("APP_ENV") != "dev" {
-			c.JSON(403, gin.H{"error": "solo disponible en dev"})
-			return
-		}
-		auth := c.GetHeader("Authorization")
-		token := strings.TrimPrefix(auth, "Bearer ")
-		if token == "" {
-			c.JSON(401, gin.H{"error": "token requerido"})
-			return
-		}
-		email, ok := login.GetEmailFromToken(token)
-		if !ok {
-			c.JSON(401, gin.H{"error": "sesión inválida"})
-			return
-		}
-		u := migrations.GetUserByEmail(email)
-		if u == nil {
-			c.JSON(404, gin.H{"error": "usuario no encontrado"})
-			return
-		}
-		var body struct {
-			PlanID    int `json:"plan_id"`
-			Frequency int `json:"frequency"`
-		}
-		if err := c.ShouldBindJSON(&body); err != nil || body.PlanID == 0 {
-			c.JSON(400, gin.H{"error": "plan_id requerido"})
-			return
-		}
-		plan, err := subRepo.GetPlanByID(body.PlanID)
-		if err != nil || plan == nil {
-			c.JSON(404, gin.H{"error": "plan no encontrado"})
-			return
-		}
-		s := &subscriptions.Subscription{UserID: u.ID, PlanID: plan.ID, StartDate: time.Now(), Frequency: body.Frequency}
-		if err := subRepo.CreateSubscription(s); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(200, gin.H{"status": "ok", "subscription_id": s.ID, "plan_id": plan.ID})
-	})
+	// Back office: users, subscriptions, grants and audit trail (admin role only)
+	admin.NewHandler(subRepo, store).RegisterRoutes(r)
 
 	// Inspect active subscription quotas quickly
 	r.GET("/me/quota", func(c *gin.Context) {
@@ -429,241 +411,6 @@ func main() {
 		})
 	})
 
-	// Dev reset quotas to plan defaults
-	r.POST("/dev/reset-quotas", func(c *gin.Context) {
-		if os.Getenv("APP_ENV") != "dev" {
-			c.JSON(403, gin.H{"error": "solo disponible en dev"})
-			return
-		}
-		auth := c.GetHeader("Authorization")
-		token := strings.TrimPrefix(auth, "Bearer ")
-		if token == "" {
-			c.JSON(401, gin.H{"error": "token requerido"})
-			return
-		}
-		email, ok := login.GetEmailFromToken(token)
-		if !ok {
-			c.JSON(401, gin.H{"error": "sesión inválida"})
-			return
-		}
-		u := migrations.GetUserByEmail(email)
-		if u == nil {
-			c.JSON(404, gin.H{"error": "usuario no encontrado"})
-			return
-		}
-		sub, err := subRepo.GetActiveSubscription(u.ID)
-		if err != nil || sub == nil {
-			c.JSON(404, gin.H{"error": "suscripción no encontrada"})
-			return
-		}
-		if err := subRepo.ResetSubscriptionQuotasToPlan(sub.ID); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		// Reload
-		fresh, _ := subRepo.GetActiveSubscription(u.ID)
-		c.JSON(200, gin.H{"status": "ok", "subscription_id": sub.ID, "plan": fresh.Plan.Name, "consultations": fresh.Consultations, "questionnaires": fresh.Questionnaires, "clinical_cases": fresh.ClinicalCases, "files": fresh.Files})
-	})
-
-	// Dev diagnostic: dump plans + subscriptions for a user and highlight anomalies
-	r.GET("/dev/diagnose-subscriptions", func(c *gin.Context) {
-		if os.Getenv("APP_ENV") != "dev" {
-			c.JSON(403, gin.H{"error": "solo disponible en dev"})
-			return
-		}
-		uidStr := c.Query("user_id")
-		if uidStr == "" {
-			c.JSON(400, gin.H{"error": "user_id requerido"})
-			return
-		}
-		uid, err := strconv.Atoi(uidStr)
-		if err != nil {
-			c.JSON(400, gin.H{"error": "user_id inválido"})
-			return
-		}
-		// Load plans
-		plansRows, err := db.Query(`SELECT id,name,consultations,questionnaires,clinical_cases,files,price FROM subscription_plans`)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		defer plansRows.Close()
-		plans := []gin.H{}
-		for plansRows.Next() {
-			var id, c1, c2, c3, c4 int
-			var name string
-			var price float64
-			if err := plansRows.Scan(&id, &name, &c1, &c2, &c3, &c4, &price); err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return
-			}
-			plans = append(plans, gin.H{"id": id, "name": name, "consultations": c1, "questionnaires": c2, "clinical_cases": c3, "files": c4, "price": price})
-		}
-		// Load all subscriptions for user
-		sRows, err := db.Query(`SELECT id, plan_id, consultations, questionnaires, clinical_cases, files, start_date, end_date, frequency FROM subscriptions WHERE user_id=? ORDER BY id DESC`, uid)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		defer sRows.Close()
-		subs := []gin.H{}
-		for sRows.Next() {
-			var id, planID, cons, ques, clin, files, freq int
-			var start, endStr sql.NullTime
-			var end interface{} = nil
-			if err := sRows.Scan(&id, &planID, &cons, &ques, &clin, &files, &start, &endStr, &freq); err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return
-			}
-			if endStr.Valid {
-				end = endStr.Time
-			}
-			subs = append(subs, gin.H{"id": id, "plan_id": planID, "consultations": cons, "questionnaires": ques, "clinical_cases": clin, "files": files, "start_date": start.Time, "end_date": end, "frequency": freq})
-		}
-		// Determine active (first entry in subs slice) and compare with plan
-		anomalies := []string{}
-		if len(subs) > 0 {
-			active := subs[0]
-			var planMatch gin.H
-			for _, p := range plans {
-				if p["id"] == active["plan_id"] {
-					planMatch = p
-					break
-				}
-			}
-			if planMatch != nil {
-				// Check if active quotas are all zero while plan has >0
-				zeros := (active["consultations"].(int) == 0 && active["questionnaires"].(int) == 0 && active["clinical_cases"].(int) == 0)
-				planHas := (planMatch["consultations"].(int) > 0 || planMatch["questionnaires"].(int) > 0 || planMatch["clinical_cases"].(int) > 0)
-				if zeros && planHas {
-					anomalies = append(anomalies, "active_subscription_zero_remaining_vs_plan_limits")
-				}
-				// Individual mismatch (remaining > plan limit) improbable, check
-				if active["consultations"].(int) > planMatch["consultations"].(int) {
-					anomalies = append(anomalies, "consultations_remaining_exceeds_plan_limit")
-				}
-			}
-		}
-		c.JSON(200, gin.H{"user_id": uid, "plans": plans, "subscriptions": subs, "anomalies": anomalies})
-	})
-
-	// Admin self-repair: if active subscription remaining quotas are all zero but plan limits > 0, reset them.
-	r.POST("/admin/repair-quotas", func(c *gin.Context) {
-		auth := c.GetHeader("Authorization")
-		token := strings.TrimPrefix(auth, "Bearer ")
-		if token == "" {
-			c.JSON(401, gin.H{"error": "token requerido"})
-			return
-		}
-		email, ok := login.GetEmailFromToken(token)
-		if !ok {
-			c.JSON(401, gin.H{"error": "sesión inválida"})
-			return
-		}
-		u := migrations.GetUserByEmail(email)
-		if u == nil {
-			c.JSON(404, gin.H{"error": "usuario no encontrado"})
-			return
-		}
-		sub, err := subRepo.GetActiveSubscription(u.ID)
-		if err != nil || sub == nil {
-			c.JSON(404, gin.H{"error": "suscripción no encontrada"})
-			return
-		}
-		zeros := sub.Consultations == 0 && sub.Questionnaires == 0 && sub.ClinicalCases == 0 && sub.Files > 0 // allow files>0
-		planHas := sub.Plan.Consultations > 0 || sub.Plan.Questionnaires > 0 || sub.Plan.ClinicalCases > 0
-		if !zeros || !planHas {
-			c.JSON(200, gin.H{"status": "no_action", "consultations": sub.Consultations, "clinical_cases": sub.ClinicalCases})
-			return
-		}
-		if err := subRepo.ResetSubscriptionQuotasToPlan(sub.ID); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		fresh, _ := subRepo.GetActiveSubscription(u.ID)
-		c.JSON(200, gin.H{"status": "repaired", "subscription_id": fresh.ID, "plan": fresh.Plan.Name, "consultations": fresh.Consultations, "questionnaires": fresh.Questionnaires, "clinical_cases": fresh.ClinicalCases, "files": fresh.Files})
-	})
-
-	// Debug: listar todas las suscripciones (o del usuario actual) con cuotas y plan
-	r.GET("/debug/subscriptions", func(c *gin.Context) {
-		userParam := c.Query("user")
-		uid := 0
-		if userParam != "" {
-			if v, err := strconv.Atoi(userParam); err == nil {
-				uid = v
-			}
-		}
-		subs, err := subRepo.GetSubscriptions(uid)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		// Determine active per user (highest id per user_id)
-		latest := map[int]int{} // user_id -> max subscription id
-		for _, s := range subs {
-			if s.ID > latest[s.UserID] {
-				latest[s.UserID] = s.ID
-			}
-		}
-		out := []gin.H{}
-		for _, s := range subs {
-			plan := gin.H{}
-			if s.Plan != nil {
-				plan = gin.H{"id": s.Plan.ID, "name": s.Plan.Name, "consultations": s.Plan.Consultations, "questionnaires": s.Plan.Questionnaires, "clinical_cases": s.Plan.ClinicalCases, "files": s.Plan.Files, "price": s.Plan.Price}
-			}
-			out = append(out, gin.H{"id": s.ID, "user_id": s.UserID, "plan_id": s.PlanID, "plan": plan, "consultations": s.Consultations, "questionnaires": s.Questionnaires, "clinical_cases": s.ClinicalCases, "files": s.Files, "start_date": s.StartDate, "end_date": s.EndDate, "active": s.ID == latest[s.UserID]})
-		}
-		c.JSON(200, gin.H{"subscriptions": out})
-	})
-
-	// Debug: resync cuotas de la suscripción activa con los valores del plan
-	r.POST("/debug/resync-quotas", func(c *gin.Context) {
-		auth := c.GetHeader("Authorization")
-		token := strings.TrimPrefix(auth, "Bearer ")
-		if token == "" {
-			c.JSON(401, gin.H{"error": "token requerido"})
-			return
-		}
-		email, ok := login.GetEmailFromToken(token)
-		if !ok {
-			c.JSON(401, gin.H{"error": "sesión inválida"})
-			return
-		}
-		u := migrations.GetUserByEmail(email)
-		if u == nil {
-			c.JSON(404, gin.H{"error": "usuario no encontrado"})
-			return
-		}
-		sub, err := subRepo.GetActiveSubscription(u.ID)
-		if err != nil || sub == nil {
-			c.JSON(404, gin.H{"error": "suscripción no encontrada"})
-			return
-		}
-		plan, err := subRepo.GetPlanByID(sub.PlanID)
-		if err != nil || plan == nil {
-			c.JSON(404, gin.H{"error": "plan no encontrado"})
-			return
-		}
-		// Set each field explicitly
-		if err := subRepo.SetQuotaValue(sub.ID, "consultations", plan.Consultations); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		if err := subRepo.SetQuotaValue(sub.ID, "questionnaires", plan.Questionnaires); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		if err := subRepo.SetQuotaValue(sub.ID, "clinical_cases", plan.ClinicalCases); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		if err := subRepo.SetQuotaValue(sub.ID, "files", plan.Files); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(200, gin.H{"status": "ok", "subscription_id": sub.ID, "plan_id": plan.ID, "consultations": plan.Consultations, "questionnaires": plan.Questionnaires, "clinical_cases": plan.ClinicalCases, "files": plan.Files})
-	})
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+const cliUsage = `usage: ema-backend migrate <command> [flags]
+
+commands:
+  status              list migrations and whether they are applied
+  up   [-steps N] [-to VERSION] [-dry-run]   apply pending migrations
+  down [-steps N] [-to VERSION] [-dry-run]   roll back (default: the last one)
+`
+
+// RunCLI implements the `migrate` subcommand on the DB set by Init.
+func RunCLI(args []string, out io.Writer) error {
+	if db == nil {
+		return fmt.Errorf("db is not initialized")
+	}
+	if len(args) == 0 {
+		fmt.Fprint(out, cliUsage)
+		return fmt.Errorf("missing command")
+	}
+	cmd := args[0]
+	fset := flag.NewFlagSet("migrate "+cmd, flag.ContinueOnError)
+	fset.SetOutput(out)
+	var opts MigrateOptions
+	fset.BoolVar(&opts.DryRun, "dry-run", false, "print the plan and statements without executing them")
+	fset.IntVar(&opts.Steps, "steps", 0, "max migrations to apply or roll back")
+	fset.IntVar(&opts.Target, "to", 0, "up: apply up to this version; down: roll back every version above it")
+	if err := fset.Parse(args[1:]); err != nil {
+		return err
+	}
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch cmd {
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.ChecksumMismatch {
+				state += " (CHECKSUM MISMATCH)"
+			}
+			if !s.Reversible {
+				state += " (irreversible)"
+			}
+			fmt.Fprintf(out, "%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	case "up", "down":
+		run := m.Up
+		if cmd == "down" {
+			run = m.Down
+		}
+		done, err := run(ctx, opts)
+		verb := map[string]string{"up": "applied", "down": "rolled back"}[cmd]
+		if opts.DryRun {
+			verb = "would be " + verb
+		}
+		for _, mig := range done {
+			fmt.Fprintf(out, "%04d_%s %s\n", mig.Version, mig.Name, verb)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(out, "nothing to do")
+		}
+		return err
+	}
+	fmt.Fprint(out, cliUsage)
+	return fmt.Errorf("unknown command %q", cmd)
+}
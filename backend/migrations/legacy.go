@@ -0,0 +1,119 @@
+package migrations
+
+import (
+	"context"
+	"log"
+)
+
+// adoptLegacySchema brings a database created by the old boot-time Migrate (CREATE TABLE IF
+// NOT EXISTS + ALTER on every boot) to the 0001_baseline schema: the baseline creates missing
+// tables but can't add the columns and indexes older deployments lack. It runs once, when
+// schema_migrations is still empty and the users table already exists. MySQL only: Postgres
+// databases never ran the old Migrate.
+func adoptLegacySchema() error {
+	ctx := context.Background()
+	columns := []struct{ table, column, def string }{
+		{"users", "profile_image", "profile_image VARCHAR(255) DEFAULT ''"},
+		{"users", "city", "city VARCHAR(100) DEFAULT ''"},
+		{"users", "profession", "profession VARCHAR(100) DEFAULT ''"},
+		{"users", "gender", "gender VARCHAR(50) DEFAULT ''"},
+		{"users", "age", "age INT DEFAULT NULL"},
+		{"users", "country_id", "country_id INT DEFAULT NULL"},
+		{"users", "stripe_customer_id", "stripe_customer_id VARCHAR(100) NULL"},
+		{"subscription_plans", "stripe_product_id", "stripe_product_id VARCHAR(100) NULL"},
+		{"subscription_plans", "stripe_price_id", "stripe_price_id VARCHAR(100) NULL"},
+		{"subscription_plans", "billing_interval", "billing_interval VARCHAR(10) NOT NULL DEFAULT 'month'"},
+		{"subscription_plans", "billing_interval_count", "billing_interval_count INT NOT NULL DEFAULT 1"},
+		{"subscription_plans", "trial_days", "trial_days INT NOT NULL DEFAULT 0"},
+		{"subscription_plans", "current_version_id", "current_version_id INT NULL"},
+		{"subscription_plans", "version", "version INT NOT NULL DEFAULT 0"},
+		{"subscription_plans", "entitlements", "entitlements TEXT NULL"},
+		{"subscriptions", "billing_interval", "billing_interval VARCHAR(10) NOT NULL DEFAULT 'month'"},
+		{"subscriptions", "interval_count", "interval_count INT NOT NULL DEFAULT 1"},
+		{"subscriptions", "current_period_end", "current_period_end DATETIME NULL"},
+		{"subscriptions", "stripe_subscription_id", "stripe_subscription_id VARCHAR(100) NULL"},
+		{"subscriptions", "scheduled_plan_id", "scheduled_plan_id INT NULL"},
+		{"subscriptions", "scheduled_interval", "scheduled_interval VARCHAR(10) NULL"},
+		{"subscriptions", "promo_code", "promo_code VARCHAR(64) NULL"},
+		{"subscriptions", "trial_ends_at", "trial_ends_at DATETIME NULL"},
+		{"subscriptions", "status", "status VARCHAR(20) NOT NULL DEFAULT 'active'"},
+		{"subscriptions", "payment_gateway", "payment_gateway VARCHAR(20) NULL"},
+		{"subscriptions", "gateway_reference", "gateway_reference VARCHAR(100) NULL"},
+		{"subscriptions", "plan_version_id", "plan_version_id INT NULL"},
+		{"subscription_plan_prices", "country_code", "country_code VARCHAR(2) NOT NULL DEFAULT ''"},
+		{"subscription_plan_prices", "currency", "currency VARCHAR(10) NULL"},
+		{"subscription_plan_versions", "entitlements", "entitlements TEXT NULL"},
+	}
+	for _, c := range columns {
+		if !tableExists(ctx, db, c.table) {
+			continue // created complete by the baseline
+		}
+		if err := ensureColumnExists(c.table, c.column, c.def); err != nil {
+			return err
+		}
+	}
+	if tableExists(ctx, db, "subscription_plan_prices") {
+		if err := ensureIndexExists("subscription_plan_prices", "uniq_plan_country_interval", "UNIQUE KEY uniq_plan_country_interval (plan_id, country_code, billing_interval, interval_count)"); err != nil {
+			return err
+		}
+		if err := dropIndexIfExists("subscription_plan_prices", "uniq_plan_interval"); err != nil {
+			return err
+		}
+	}
+	log.Printf("[MIGRATION] ✅ legacy schema adopted")
+	return nil
+}
+
+// ensureColumnExists checks information_schema and adds the column if missing
+func ensureColumnExists(table, column, columnDef string) error {
+	var cnt int
+	q := `SELECT COUNT(1) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`
+	if err := db.QueryRow(q, table, column).Scan(&cnt); err != nil {
+		return err
+	}
+	if cnt == 0 {
+		log.Printf("[MIGRATION] Adding column %s.%s", table, column)
+		_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + columnDef)
+		if err != nil {
+			log.Printf("[MIGRATION] ERROR adding column %s.%s: %v", table, column, err)
+			return err
+		}
+		log.Printf("[MIGRATION] Successfully added column %s.%s", table, column)
+		return nil
+	}
+	log.Printf("[MIGRATION] Column %s.%s already exists", table, column)
+	return nil
+}
+
+// ensureIndexExists adds the index (e.g. "UNIQUE KEY name (cols)") when no index with that name exists
+func ensureIndexExists(table, index, indexDef string) error {
+	var cnt int
+	q := `SELECT COUNT(1) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`
+	if err := db.QueryRow(q, table, index).Scan(&cnt); err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return nil
+	}
+	log.Printf("[MIGRATION] Adding index %s.%s", table, index)
+	if _, err := db.Exec("ALTER TABLE " + table + " ADD " + indexDef); err != nil {
+		log.Printf("[MIGRATION] ERROR adding index %s.%s: %v", table, index, err)
+		return err
+	}
+	return nil
+}
+
+// dropIndexIfExists removes an index that a newer one replaces
+func dropIndexIfExists(table, index string) error {
+	var cnt int
+	q := `SELECT COUNT(1) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`
+	if err := db.QueryRow(q, table, index).Scan(&cnt); err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return nil
+	}
+	log.Printf("[MIGRATION] Dropping index %s.%s", table, index)
+	_, err := db.Exec("ALTER TABLE " + table + " DROP INDEX " + index)
+	return err
+}
@@ -1,11 +1,16 @@
 package migrations
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"ema-backend/conn"
 )
 
 type User struct {
@@ -33,166 +38,34 @@ func Init(database *sql.DB) {
 	db = database
 }
 
-// Migrate creates required tables if they do not exist
+// Migrate applies the pending versioned migrations (see schema.go). With MIGRATE_ON_BOOT=0
+// it only reports them, for deployments that run `ema-backend migrate up` as a release step.
 func Migrate() error {
-	log.Printf("[MIGRATION] 🔄 Starting database migrations...")
 	if db == nil {
 		return fmt.Errorf("db is not initialized")
 	}
-
-	log.Printf("[MIGRATION] Creating users table if not exists...")
-	createUsers := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		first_name VARCHAR(100) NOT NULL,
-		last_name VARCHAR(100) NOT NULL,
-		email VARCHAR(191) NOT NULL UNIQUE,
-		password VARCHAR(191) NOT NULL,
-		role VARCHAR(50) NOT NULL DEFAULT 'user',
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-	if _, err := db.Exec(createUsers); err != nil {
-		log.Printf("[MIGRATION] ❌ ERROR creating users table: %v", err)
-		return err
-	}
-	log.Printf("[MIGRATION] ✅ users table ready")
-
-	// Ensure optional columns exist (profile_image, city, profession, gender, age, country_id)
-	if err := ensureColumnExists("users", "profile_image", "profile_image VARCHAR(255) DEFAULT ''"); err != nil {
-		return err
-	}
-	if err := ensureColumnExists("users", "city", "city VARCHAR(100) DEFAULT ''"); err != nil {
-		return err
-	}
-	if err := ensureColumnExists("users", "profession", "profession VARCHAR(100) DEFAULT ''"); err != nil {
-		return err
-	}
-	if err := ensureColumnExists("users", "gender", "gender VARCHAR(50) DEFAULT ''"); err != nil {
-		return err
-	}
-	if err := ensureColumnExists("users", "age", "age INT DEFAULT NULL"); err != nil {
-		return err
-	}
-	if err := ensureColumnExists("users", "country_id", "country_id INT DEFAULT NULL"); err != nil {
-		return err
-	}
-
-	// Subscriptions related tables
-	log.Printf("[MIGRATION] Creating subscription_plans table if not exists...")
-	createPlans := `
-	CREATE TABLE IF NOT EXISTS subscription_plans (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		name VARCHAR(100) NOT NULL,
-		currency VARCHAR(10) NOT NULL DEFAULT 'USD',
-		price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
-		billing VARCHAR(50) NOT NULL DEFAULT 'Mensual',
-		consultations INT NOT NULL DEFAULT 0,
-		questionnaires INT NOT NULL DEFAULT 0,
-		clinical_cases INT NOT NULL DEFAULT 0,
-		files INT NOT NULL DEFAULT 0,
-		stripe_product_id VARCHAR(100) NULL,
-		stripe_price_id VARCHAR(100) NULL
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-	if _, err := db.Exec(createPlans); err != nil {
-		log.Printf("[MIGRATION] ❌ ERROR creating subscription_plans table: %v", err)
-		return err
-	}
-	log.Printf("[MIGRATION] ✅ subscription_plans table ready")
-
-	log.Printf("[MIGRATION] Creating subscriptions table if not exists...")
-	createSubs := `
-	CREATE TABLE IF NOT EXISTS subscriptions (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		user_id INT NOT NULL,
-		plan_id INT NOT NULL,
-		start_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		end_date DATETIME NULL,
-		frequency INT NOT NULL DEFAULT 0,
-		consultations INT NOT NULL DEFAULT 0,
-		questionnaires INT NOT NULL DEFAULT 0,
-		clinical_cases INT NOT NULL DEFAULT 0,
-		files INT NOT NULL DEFAULT 0,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-		FOREIGN KEY (plan_id) REFERENCES subscription_plans(id) ON DELETE CASCADE
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-	if _, err := db.Exec(createSubs); err != nil {
-		log.Printf("[MIGRATION] ❌ ERROR creating subscriptions table: %v", err)
-		return err
-	}
-	log.Printf("[MIGRATION] ✅ subscriptions table ready")
-
-	// Ensure new Stripe-related columns exist (idempotent) for backward compatibility
-	if err := ensureColumnExists("subscription_plans", "stripe_product_id", "stripe_product_id VARCHAR(100) NULL"); err != nil {
-		return err
-	}
-	if err := ensureColumnExists("subscription_plans", "stripe_price_id", "stripe_price_id VARCHAR(100) NULL"); err != nil {
-		return err
-	}
-	if err := ensureColumnExists("users", "stripe_customer_id", "stripe_customer_id VARCHAR(100) NULL"); err != nil {
-		return err
-	}
-
-	// Medical categories table for quizzes/tests
-	log.Printf("[MIGRATION] Creating medical_categories table if not exists...")
-	createMedicalCategories := `
-	CREATE TABLE IF NOT EXISTS medical_categories (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		name VARCHAR(191) NOT NULL UNIQUE,
-		description TEXT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-	if _, err := db.Exec(createMedicalCategories); err != nil {
-		log.Printf("[MIGRATION] ❌ ERROR creating medical_categories table: %v", err)
-		return err
-	}
-	log.Printf("[MIGRATION] ✅ medical_categories table ready")
-
-	// Test history table for tracking completed tests/quizzes
-	log.Printf("[MIGRATION] Creating test_history table if not exists...")
-	createTestHistory := `
-	CREATE TABLE IF NOT EXISTS test_history (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		user_id INT NOT NULL,
-		category_id INT NULL,
-		test_name VARCHAR(255) NOT NULL,
-		score_obtained INT NOT NULL DEFAULT 0,
-		max_score INT NOT NULL DEFAULT 0,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-		FOREIGN KEY (category_id) REFERENCES medical_categories(id) ON DELETE SET NULL,
-		INDEX idx_user_created (user_id, created_at),
-		INDEX idx_user_category (user_id, category_id)
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-	if _, err := db.Exec(createTestHistory); err != nil {
-		log.Printf("[MIGRATION] ❌ ERROR creating test_history table: %v", err)
-		return err
-	}
-	log.Printf("[MIGRATION] ✅ test_history table ready")
-
-	log.Printf("[MIGRATION] ✅ All migrations completed successfully")
-	return nil
-}
-
-// ensureColumnExists checks information_schema and adds the column if missing
-func ensureColumnExists(table, column, columnDef string) error {
-	var cnt int
-	q := `SELECT COUNT(1) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`
-	if err := db.QueryRow(q, table, column).Scan(&cnt); err != nil {
+	m, err := NewMigrator(db)
+	if err != nil {
 		return err
 	}
-	if cnt == 0 {
-		log.Printf("[MIGRATION] Adding column %s.%s", table, column)
-		_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + columnDef)
+	ctx := context.Background()
+	if os.Getenv("MIGRATE_ON_BOOT") == "0" {
+		pending, err := m.Up(ctx, MigrateOptions{DryRun: true})
 		if err != nil {
-			log.Printf("[MIGRATION] ERROR adding column %s.%s: %v", table, column, err)
 			return err
 		}
-		log.Printf("[MIGRATION] Successfully added column %s.%s", table, column)
+		if len(pending) > 0 {
+			log.Printf("[MIGRATION] ⚠️ %d pending migrations (MIGRATE_ON_BOOT=0): run `ema-backend migrate up`", len(pending))
+		}
 		return nil
 	}
-	log.Printf("[MIGRATION] Column %s.%s already exists", table, column)
+	log.Printf("[MIGRATION] 🔄 Applying pending migrations...")
+	applied, err := m.Up(ctx, MigrateOptions{})
+	if err != nil {
+		log.Printf("[MIGRATION] ❌ ERROR: %v", err)
+		return err
+	}
+	log.Printf("[MIGRATION] ✅ Schema up to date (%d applied)", len(applied))
 	return nil
 }
 
@@ -211,20 +84,42 @@ func SeedDefaultUser() error {
 		return err
 	}
 	if count == 0 {
-		res, err := db.Exec(
+		uid, err := conn.DialectOf(db).InsertID(db,
 			"INSERT INTO users (first_name, last_name, email, password, role) VALUES (?, ?, ?, ?, ?)",
 			"Leonardo", "Herrera", email, password, "super_admin",
 		)
 		if err != nil {
 			return err
 		}
-		uid, _ := res.LastInsertId()
 		// Asigna subscripción Free automáticamente si existe plan
 		_ = EnsureFreeSubscriptionForUser(int(uid))
 	}
 	return nil
 }
 
+// EnsurePlanVersions snapshots version 1 of every plan that has none yet and pins the
+// subscriptions without a version to their plan's current one (idempotent).
+func EnsurePlanVersions() error {
+	if db == nil {
+		return fmt.Errorf("db is not initialized")
+	}
+	if _, err := db.Exec(`INSERT INTO subscription_plan_versions (plan_id, version, currency, price, billing_interval, interval_count, consultations, questionnaires, clinical_cases, files, statistics, trial_days, entitlements)
+		SELECT p.id, 1, p.currency, p.price, COALESCE(p.billing_interval,'month'), COALESCE(p.billing_interval_count,1), p.consultations, p.questionnaires, p.clinical_cases, p.files,
+			CASE WHEN p.price>0 THEN 1 ELSE 0 END, COALESCE(p.trial_days,0), p.entitlements
+		FROM subscription_plans p WHERE NOT EXISTS (SELECT 1 FROM subscription_plan_versions v WHERE v.plan_id = p.id)`); err != nil {
+		return err
+	}
+	// Correlated subqueries instead of UPDATE ... JOIN, which Postgres doesn't have
+	if _, err := db.Exec(`UPDATE subscription_plans SET version = 1,
+			current_version_id = (SELECT v.id FROM subscription_plan_versions v WHERE v.plan_id = subscription_plans.id AND v.version = 1)
+		WHERE current_version_id IS NULL AND EXISTS (SELECT 1 FROM subscription_plan_versions v WHERE v.plan_id = subscription_plans.id AND v.version = 1)`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE subscriptions SET plan_version_id = (SELECT p.current_version_id FROM subscription_plans p WHERE p.id = subscriptions.plan_id)
+		WHERE plan_version_id IS NULL AND EXISTS (SELECT 1 FROM subscription_plans p WHERE p.id = subscriptions.plan_id AND p.current_version_id IS NOT NULL)`)
+	return err
+}
+
 // SeedDefaultPlans inserts some default plans if none exist
 func SeedDefaultPlans() error {
 	if db == nil {
@@ -247,6 +142,7 @@ func SeedDefaultPlans() error {
 		if _, err := db.Exec(`INSERT INTO subscription_plans (name, currency, price, billing, consultations, questionnaires, clinical_cases, files) VALUES ('Premium','USD',19.99,'Mensual',100,200,100,500)`); err != nil {
 			return err
 		}
+		return EnsurePlanVersions()
 	}
 	return nil
 }
@@ -339,12 +235,7 @@ func GetUserByEmail(email string) *User {
 	if db == nil {
 		return nil
 	}
-	row := db.QueryRow("SELECT id, first_name, last_name, email, password, role, IFNULL(profile_image,''), IFNULL(city,''), IFNULL(profession,''), IFNULL(gender,''), age, country_id, created_at, updated_at FROM users WHERE email = ? LIMIT 1", email)
-	var u User
-	if err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.Role, &u.ProfileImage, &u.City, &u.Profession, &u.Gender, &u.Age, &u.CountryID, &u.CreatedAt, &u.UpdatedAt); err != nil {
-		return nil
-	}
-	return &u
+	return NewSQLStore(db).GetUserByEmail(email)
 }
 
 // GetUserByID retrieves a user by its ID
@@ -352,201 +243,61 @@ func GetUserByID(id int) *User {
 	if db == nil {
 		return nil
 	}
-	row := db.QueryRow("SELECT id, first_name, last_name, email, password, role, IFNULL(profile_image,''), IFNULL(city,''), IFNULL(profession,''), IFNULL(gender,''), age, country_id, created_at, updated_at FROM users WHERE id = ? LIMIT 1", id)
-	var u User
-	if err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.Role, &u.ProfileImage, &u.City, &u.Profession, &u.Gender, &u.Age, &u.CountryID, &u.CreatedAt, &u.UpdatedAt); err != nil {
-		return nil
-	}
-	return &u
-}
-
-// UpdateUserProfileImage updates the profile_image path
-func UpdateUserProfileImage(id int, path string) error {
-	if db == nil {
-		return fmt.Errorf("db is not initialized")
-	}
-	_, err := db.Exec("UPDATE users SET profile_image = ?, updated_at = NOW() WHERE id = ?", path, id)
-	return err
+	return NewSQLStore(db).GetUserByID(id)
 }
 
-// UpdateUserProfile updates first/last name and optional city/profession/gender/age/country
-func UpdateUserProfile(id int, firstName, lastName, city, profession, gender string, age, countryID *int) error {
+// SearchUsers returns a page of users whose id, email or name matches q (all users when q is
+// empty), newest first, together with the total number of matches.
+func SearchUsers(q string, limit, offset int) ([]User, int, error) {
 	if db == nil {
-		return fmt.Errorf("db is not initialized")
-	}
-	cur := GetUserByID(id)
-	if cur == nil {
-		return fmt.Errorf("user not found")
-	}
-	if firstName == "" {
-		firstName = cur.FirstName
-	}
-	if lastName == "" {
-		lastName = cur.LastName
-	}
-	if city == "" {
-		city = cur.City
-	}
-	if profession == "" {
-		profession = cur.Profession
-	}
-	if gender == "" {
-		gender = cur.Gender
-	}
-	if age == nil {
-		age = cur.Age
+		return nil, 0, fmt.Errorf("db is not initialized")
+	}
+	q = strings.TrimSpace(q)
+	where, args := "", []interface{}{}
+	if q != "" {
+		d := conn.DialectOf(db)
+		like := "%" + q + "%"
+		where = " WHERE email " + d.ILike() + " ? OR " + d.Concat("first_name", "' '", "last_name") + " " + d.ILike() + " ?"
+		args = append(args, like, like)
+		if id, err := strconv.Atoi(q); err == nil {
+			where += " OR id = ?"
+			args = append(args, id)
+		}
 	}
-	if countryID == nil {
-		countryID = cur.CountryID
+	var total int
+	if err := db.QueryRow("SELECT COUNT(1) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
 	}
-	_, err := db.Exec("UPDATE users SET first_name = ?, last_name = ?, city = ?, profession = ?, gender = ?, age = ?, country_id = ?, updated_at = NOW() WHERE id = ?", firstName, lastName, city, profession, gender, age, countryID, id)
-	return err
-}
-
-// UpdateUserPassword updates the password for the given user id
-func UpdateUserPassword(id int, password string) error {
-	if db == nil {
-		return fmt.Errorf("db is not initialized")
+	rows, err := db.Query("SELECT id, first_name, last_name, email, role, COALESCE(profile_image,''), COALESCE(city,''), COALESCE(profession,''), COALESCE(gender,''), age, country_id, created_at, updated_at FROM users"+where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Role, &u.ProfileImage, &u.City, &u.Profession, &u.Gender, &u.Age, &u.CountryID, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
 	}
-	_, err := db.Exec("UPDATE users SET password = ?, updated_at = NOW() WHERE id = ?", password, id)
-	return err
+	return users, total, rows.Err()
 }
 
-// CreateUser inserts a new user record
-func CreateUser(firstName, lastName, email, password, role string) error {
+// UpdateUserRole changes the role of a user (user | admin | super_admin)
+func UpdateUserRole(id int, role string) error {
 	if db == nil {
 		return fmt.Errorf("db is not initialized")
 	}
-	_, err := db.Exec(
-		"INSERT INTO users (first_name, last_name, email, password, role) VALUES (?, ?, ?, ?, ?)",
-		firstName, lastName, email, password, role,
-	)
+	_, err := db.Exec("UPDATE users SET role = ?, updated_at = NOW() WHERE id = ?", role, id)
 	return err
 }
 
-// EmailExists checks if a user with the given email exists
-func EmailExists(email string) (bool, error) {
-	if db == nil {
-		return false, fmt.Errorf("db is not initialized")
-	}
-	var count int
-	if err := db.QueryRow("SELECT COUNT(1) FROM users WHERE email = ?", email).Scan(&count); err != nil {
-		return false, err
-	}
-	return count > 0, nil
-}
-
-// EnsureFreeSubscriptionForUser creates a Free subscription for the user if none exists
+// EnsureFreeSubscriptionForUser creates a Free subscription for the user if none is active
+// (new users, or users whose paid subscription expired)
 func EnsureFreeSubscriptionForUser(userID int) error {
 	if db == nil {
 		return fmt.Errorf("db is not initialized")
 	}
-	var count int
-	if err := db.QueryRow("SELECT COUNT(1) FROM subscriptions WHERE user_id = ?", userID).Scan(&count); err != nil {
-		return err
-	}
-	if count > 0 {
-		return nil
-	}
-	// Find Free plan
-	var planID, consultations, questionnaires, clinicalCases, files int
-	row := db.QueryRow("SELECT id, consultations, questionnaires, clinical_cases, files FROM subscription_plans WHERE name = 'Free' LIMIT 1")
-	switch err := row.Scan(&planID, &consultations, &questionnaires, &clinicalCases, &files); err {
-	case nil:
-		// ok
-	case sql.ErrNoRows:
-		// Fallback to any plan (cheapest/first)
-		row2 := db.QueryRow("SELECT id, consultations, questionnaires, clinical_cases, files FROM subscription_plans ORDER BY price ASC, id ASC LIMIT 1")
-		if err2 := row2.Scan(&planID, &consultations, &questionnaires, &clinicalCases, &files); err2 != nil {
-			return err2
-		}
-	default:
-		return err
-	}
-	// Create subscription initialized with plan quotas
-	_, err := db.Exec(`INSERT INTO subscriptions (user_id, plan_id, start_date, frequency, consultations, questionnaires, clinical_cases, files)
-		VALUES (?,?, NOW(), 0, ?, ?, ?, ?)`, userID, planID, consultations, questionnaires, clinicalCases, files)
-	return err
-}
-
-// GetActiveSubscriptionForUser returns the most recent subscription of a user joined with plan
-func GetActiveSubscriptionForUser(userID int) (map[string]interface{}, error) {
-	if db == nil {
-		return nil, fmt.Errorf("db is not initialized")
-	}
-	query := `SELECT s.id, s.user_id, s.plan_id, s.start_date, s.end_date, s.frequency,
-		s.consultations, s.questionnaires, s.clinical_cases, s.files,
-		p.id, p.name, p.currency, p.price, p.billing, p.consultations, p.questionnaires, p.clinical_cases, p.files,
-		CASE WHEN p.price>0 THEN 1 ELSE 0 END AS statistics
-		FROM subscriptions s JOIN subscription_plans p ON s.plan_id = p.id
-		WHERE s.user_id = ? ORDER BY s.id DESC LIMIT 1`
-	row := db.QueryRow(query, userID)
-	var sID, uID, planID, freq, sConsult, sQuest, sClin, sFiles int
-	var start time.Time
-	var end sql.NullTime
-	var pID, pConsult, pQuest, pClin, pFiles int
-	var statistics int
-	var pName, pCurrency, pBilling string
-	var pPrice float64
-	if err := row.Scan(&sID, &uID, &planID, &start, &end, &freq, &sConsult, &sQuest, &sClin, &sFiles,
-		&pID, &pName, &pCurrency, &pPrice, &pBilling, &pConsult, &pQuest, &pClin, &pFiles, &statistics); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
-	}
-	sub := map[string]interface{}{
-		"id":             sID,
-		"user_id":        uID,
-		"plan_id":        planID,
-		"start_date":     start.Format(time.RFC3339),
-		"end_date":       nil,
-		"frequency":      freq,
-		"consultations":  sConsult,
-		"questionnaires": sQuest,
-		"clinical_cases": sClin,
-		"files":          sFiles,
-		"statistics":     statistics,
-		"subscription_plan": map[string]interface{}{
-			"id":             pID,
-			"name":           pName,
-			"currency":       pCurrency,
-			"price":          pPrice,
-			"billing":        pBilling,
-			"consultations":  pConsult,
-			"questionnaires": pQuest,
-			"clinical_cases": pClin,
-			"files":          pFiles,
-			"statistics":     statistics,
-		},
-	}
-	if end.Valid {
-		sub["end_date"] = end.Time.Format(time.RFC3339)
-	}
-	return sub, nil
-}
-
-// ResetActiveSubscriptionQuotas resets the latest subscription quotas to plan defaults.
-func ResetActiveSubscriptionQuotas(userID int) error {
-	if db == nil {
-		return fmt.Errorf("db is not initialized")
-	}
-	row := db.QueryRow("SELECT id, plan_id FROM subscriptions WHERE user_id=? ORDER BY id DESC LIMIT 1", userID)
-	var subID, planID int
-	if err := row.Scan(&subID, &planID); err != nil {
-		if err == sql.ErrNoRows {
-			return nil
-		}
-		return err
-	}
-	prow := db.QueryRow("SELECT consultations, questionnaires, clinical_cases, files FROM subscription_plans WHERE id=? LIMIT 1", planID)
-	var c1, c2, c3, c4 int
-	if err := prow.Scan(&c1, &c2, &c3, &c4); err != nil {
-		return err
-	}
-	if _, err := db.Exec(`UPDATE subscriptions SET consultations=?, questionnaires=?, clinical_cases=?, files=? WHERE id=?`, c1, c2, c3, c4, subID); err != nil {
-		return err
-	}
-	log.Printf("[QUOTA][AUTO-RESET] user_id=%d sub_id=%d plan_id=%d consultations=%d questionnaires=%d clinical_cases=%d files=%d", userID, subID, planID, c1, c2, c3, c4)
-	return nil
+	return NewSQLStore(db).EnsureFreeSubscription(userID)
 }
@@ -0,0 +1,456 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ema-backend/conn"
+)
+
+// Versioned schema migrations: sql/<dialect>/NNNN_name.up.sql (required) and
+// sql/<dialect>/NNNN_name.down.sql (optional, without it the migration can't be rolled back),
+// applied in version order and recorded in schema_migrations with the checksum of the up
+// script. Applied files must not be edited: add a new migration instead, to both sql/mysql
+// and sql/postgres with the same version and name.
+//
+//go:embed sql/mysql/*.sql sql/postgres/*.sql
+var migrationFiles embed.FS
+
+// lockName is the advisory lock (GET_LOCK on MySQL, pg_try_advisory_lock on Postgres) held
+// while migrating, so replicas booting together don't apply the same migration twice.
+const (
+	lockName        = "ema_schema_migrations"
+	lockTimeoutSecs = 60
+)
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	checksum CHAR(64) NOT NULL,
+	execution_ms INT NOT NULL DEFAULT 0,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string // empty = irreversible
+	Checksum string // sha256 of Up
+}
+
+// AppliedMigration is a row of schema_migrations.
+type AppliedMigration struct {
+	Version     int
+	Name        string
+	Checksum    string
+	ExecutionMS int
+	AppliedAt   time.Time
+}
+
+// MigrationStatus pairs a migration file with its schema_migrations row (if applied).
+type MigrationStatus struct {
+	Version          int        `json:"version"`
+	Name             string     `json:"name"`
+	Applied          bool       `json:"applied"`
+	AppliedAt        *time.Time `json:"applied_at,omitempty"`
+	ChecksumMismatch bool       `json:"checksum_mismatch,omitempty"`
+	Reversible       bool       `json:"reversible"`
+}
+
+// MigrateOptions tunes Up/Down. Zero values mean: up applies everything, down rolls back one.
+type MigrateOptions struct {
+	DryRun bool // log the plan and statements without executing anything
+	Steps  int  // max migrations to apply / roll back
+	Target int  // up: apply up to this version; down: roll back every version above it
+}
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// loadMigrations reads and validates the migration files of fsys (sorted by version).
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration file %q: expected NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if strings.TrimSpace(mig.Up) == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", mig.Version, mig.Name)
+		}
+		sum := sha256.Sum256([]byte(mig.Up))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// splitStatements splits a script into statements: a statement ends with a line ending in
+// ";". Lines starting with "--" are comments.
+func splitStatements(script string) []string {
+	var out []string
+	var cur strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmt := strings.TrimSuffix(strings.TrimSpace(cur.String()), ";")
+			out = append(out, stmt)
+			cur.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(cur.String()); rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
+
+// planUp returns the pending migrations to apply. Applied migrations must still exist with
+// the same checksum: an edited or missing file means the database and the code disagree.
+func planUp(all []Migration, applied map[int]AppliedMigration, opts MigrateOptions) ([]Migration, error) {
+	known := map[int]bool{}
+	for _, m := range all {
+		known[m.Version] = true
+		if a, ok := applied[m.Version]; ok && a.Checksum != m.Checksum {
+			return nil, fmt.Errorf("migration %04d_%s changed after being applied (checksum %s, applied %s)", m.Version, m.Name, short(m.Checksum), short(a.Checksum))
+		}
+	}
+	for v, a := range applied {
+		if !known[v] {
+			return nil, fmt.Errorf("migration %04d_%s is applied but its file is missing (newer build?)", v, a.Name)
+		}
+	}
+	var out []Migration
+	for _, m := range all {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if opts.Target > 0 && m.Version > opts.Target {
+			break
+		}
+		out = append(out, m)
+		if opts.Steps > 0 && len(out) == opts.Steps {
+			break
+		}
+	}
+	return out, nil
+}
+
+// planDown returns the applied migrations to roll back, newest first: the last Steps ones
+// (default 1), or every one above Target.
+func planDown(all []Migration, applied map[int]AppliedMigration, opts MigrateOptions) ([]Migration, error) {
+	steps := opts.Steps
+	if steps <= 0 && opts.Target <= 0 {
+		steps = 1
+	}
+	var out []Migration
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if opts.Target > 0 && m.Version <= opts.Target {
+			break
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %04d_%s is irreversible (no down script)", m.Version, m.Name)
+		}
+		out = append(out, m)
+		if steps > 0 && len(out) == steps {
+			break
+		}
+	}
+	return out, nil
+}
+
+func short(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+// Migrator applies the embedded migrations to db.
+type Migrator struct {
+	db         *sql.DB
+	dialect    conn.Dialect
+	migrations []Migration
+}
+
+// NewMigrator loads the embedded migration files of the engine db talks to.
+func NewMigrator(database *sql.DB) (*Migrator, error) {
+	d := conn.DialectOf(database)
+	all, err := loadMigrations(migrationFiles, path.Join("sql", string(d)))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: database, dialect: d, migrations: all}, nil
+}
+
+// applied reads schema_migrations; a missing table means nothing is applied yet.
+func (m *Migrator) applied(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}) (map[int]AppliedMigration, error) {
+	out := map[int]AppliedMigration{}
+	if !tableExists(ctx, m.db, "schema_migrations") {
+		return out, nil
+	}
+	rows, err := q.QueryContext(ctx, `SELECT version, name, checksum, execution_ms, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.ExecutionMS, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		out[a.Version] = a
+	}
+	return out, rows.Err()
+}
+
+func tableExists(ctx context.Context, database *sql.DB, table string) bool {
+	schema := "DATABASE()"
+	if conn.DialectOf(database) == conn.Postgres {
+		schema = "current_schema()"
+	}
+	var cnt int
+	err := database.QueryRowContext(ctx, `SELECT COUNT(1) FROM information_schema.tables WHERE table_schema = `+schema+` AND table_name = ?`, table).Scan(&cnt)
+	return err == nil && cnt > 0
+}
+
+// Status lists every migration file and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Version: mig.Version, Name: mig.Name, Reversible: strings.TrimSpace(mig.Down) != ""}
+		if a, ok := applied[mig.Version]; ok {
+			at := a.AppliedAt
+			s.Applied, s.AppliedAt, s.ChecksumMismatch = true, &at, a.Checksum != mig.Checksum
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// withLock runs fn on a dedicated connection holding the advisory lock (session-scoped on
+// both engines).
+func (m *Migrator) withLock(ctx context.Context, fn func(c *sql.Conn) error) error {
+	c, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	unlock := `SELECT RELEASE_LOCK(?)`
+	if m.dialect == conn.Postgres {
+		err, unlock = lockPostgres(ctx, c), `SELECT pg_advisory_unlock(hashtext(?))`
+	} else {
+		err = lockMySQL(ctx, c)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.ExecContext(context.Background(), unlock, lockName)
+	return fn(c)
+}
+
+func lockMySQL(ctx context.Context, c *sql.Conn) error {
+	var got sql.NullInt64
+	if err := c.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, lockName, lockTimeoutSecs).Scan(&got); err != nil {
+		return err
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return fmt.Errorf("could not acquire migration lock %q within %ds (another instance migrating?)", lockName, lockTimeoutSecs)
+	}
+	return nil
+}
+
+// lockPostgres polls pg_try_advisory_lock: pg_advisory_lock has no timeout of its own.
+func lockPostgres(ctx context.Context, c *sql.Conn) error {
+	deadline := time.Now().Add(lockTimeoutSecs * time.Second)
+	for {
+		var got bool
+		if err := c.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext(?))`, lockName).Scan(&got); err != nil {
+			return err
+		}
+		if got {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("could not acquire migration lock %q within %ds (another instance migrating?)", lockName, lockTimeoutSecs)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Up applies pending migrations in order and returns them. Databases created by the old
+// boot-time Migrate are first brought to the baseline (adoptLegacySchema).
+func (m *Migrator) Up(ctx context.Context, opts MigrateOptions) ([]Migration, error) {
+	if opts.DryRun {
+		applied, err := m.applied(ctx, m.db)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := planUp(m.migrations, applied, opts)
+		if err != nil {
+			return nil, err
+		}
+		if m.dialect == conn.MySQL && len(applied) == 0 && tableExists(ctx, m.db, "users") {
+			log.Printf("[MIGRATION][dry-run] legacy schema detected: missing baseline columns would be added first")
+		}
+		logPlan("up", plan, func(mig Migration) string { return mig.Up })
+		return plan, nil
+	}
+	var done []Migration
+	err := m.withLock(ctx, func(c *sql.Conn) error {
+		if _, err := c.ExecContext(ctx, createSchemaMigrations); err != nil {
+			return err
+		}
+		applied, err := m.applied(ctx, c)
+		if err != nil {
+			return err
+		}
+		plan, err := planUp(m.migrations, applied, opts)
+		if err != nil {
+			return err
+		}
+		if m.dialect == conn.MySQL && len(applied) == 0 && tableExists(ctx, m.db, "users") {
+			log.Printf("[MIGRATION] legacy schema detected, adding missing baseline columns")
+			if err := adoptLegacySchema(); err != nil {
+				return err
+			}
+		}
+		for _, mig := range plan {
+			start := time.Now()
+			if err := execScript(ctx, c, mig, mig.Up); err != nil {
+				return err
+			}
+			ms := time.Since(start).Milliseconds()
+			if _, err := c.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, execution_ms) VALUES (?,?,?,?)`, mig.Version, mig.Name, mig.Checksum, ms); err != nil {
+				return err
+			}
+			log.Printf("[MIGRATION] ✅ %04d_%s applied (%dms)", mig.Version, mig.Name, ms)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back applied migrations, newest first, and returns them.
+func (m *Migrator) Down(ctx context.Context, opts MigrateOptions) ([]Migration, error) {
+	if opts.DryRun {
+		applied, err := m.applied(ctx, m.db)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := planDown(m.migrations, applied, opts)
+		if err != nil {
+			return nil, err
+		}
+		logPlan("down", plan, func(mig Migration) string { return mig.Down })
+		return plan, nil
+	}
+	var done []Migration
+	err := m.withLock(ctx, func(c *sql.Conn) error {
+		applied, err := m.applied(ctx, c)
+		if err != nil {
+			return err
+		}
+		plan, err := planDown(m.migrations, applied, opts)
+		if err != nil {
+			return err
+		}
+		for _, mig := range plan {
+			if err := execScript(ctx, c, mig, mig.Down); err != nil {
+				return err
+			}
+			if _, err := c.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version=?`, mig.Version); err != nil {
+				return err
+			}
+			log.Printf("[MIGRATION] ↩️ %04d_%s rolled back", mig.Version, mig.Name)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// execScript runs the statements of a script one by one. MySQL commits DDL implicitly, so a
+// failure leaves the earlier statements applied and the migration unrecorded: write
+// migrations whose statements can be re-run (IF NOT EXISTS / IF EXISTS).
+func execScript(ctx context.Context, c *sql.Conn, mig Migration, script string) error {
+	for i, stmt := range splitStatements(script) {
+		if _, err := c.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %04d_%s statement %d: %w", mig.Version, mig.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+func logPlan(direction string, plan []Migration, script func(Migration) string) {
+	if len(plan) == 0 {
+		log.Printf("[MIGRATION][dry-run] %s: nothing to do", direction)
+		return
+	}
+	for _, mig := range plan {
+		log.Printf("[MIGRATION][dry-run] %s %04d_%s", direction, mig.Version, mig.Name)
+		for _, stmt := range splitStatements(script(mig)) {
+			log.Printf("[MIGRATION][dry-run]   %s;", stmt)
+		}
+	}
+}
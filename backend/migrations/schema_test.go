@@ -0,0 +1,153 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"ema-backend/conn"
+)
+
+func testMigrations(t *testing.T) []Migration {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"sql/0002_second.up.sql":  {Data: []byte("ALTER TABLE a ADD COLUMN b INT;")},
+		"sql/0001_first.up.sql":   {Data: []byte("CREATE TABLE a (id INT);")},
+		"sql/0001_first.down.sql": {Data: []byte("DROP TABLE a;")},
+		"sql/0003_third.up.sql":   {Data: []byte("CREATE TABLE c (id INT);")},
+		"sql/0003_third.down.sql": {Data: []byte("DROP TABLE c;")},
+	}
+	all, err := loadMigrations(fsys, "sql")
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	return all
+}
+
+func versions(ms []Migration) []int {
+	out := []int{}
+	for _, m := range ms {
+		out = append(out, m.Version)
+	}
+	return out
+}
+
+func TestLoadMigrations(t *testing.T) {
+	all := testMigrations(t)
+	if got := versions(all); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Fatalf("versions = %v", got)
+	}
+	if all[0].Name != "first" || all[0].Down == "" || all[1].Down != "" || len(all[0].Checksum) != 64 {
+		t.Fatalf("unexpected migration %+v / %+v", all[0], all[1])
+	}
+	if _, err := loadMigrations(fstest.MapFS{"sql/first.up.sql": {Data: []byte("x;")}}, "sql"); err == nil {
+		t.Fatal("expected error for a file without version")
+	}
+	if _, err := loadMigrations(fstest.MapFS{"sql/0001_a.down.sql": {Data: []byte("x;")}}, "sql"); err == nil {
+		t.Fatal("expected error for a migration without up script")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	byDialect := map[conn.Dialect][]Migration{}
+	for _, d := range []conn.Dialect{conn.MySQL, conn.Postgres} {
+		all, err := loadMigrations(migrationFiles, "sql/"+string(d))
+		if err != nil {
+			t.Fatalf("embedded %s migrations: %v", d, err)
+		}
+		for i, m := range all {
+			if m.Version != i+1 {
+				t.Fatalf("%s migration versions must be consecutive from 1, got %v", d, versions(all))
+			}
+			if len(splitStatements(m.Up)) == 0 {
+				t.Fatalf("%s %04d_%s has no statements", d, m.Version, m.Name)
+			}
+		}
+		byDialect[d] = all
+	}
+	// Every migration exists for both engines, with the same name and reversibility
+	my, pg := byDialect[conn.MySQL], byDialect[conn.Postgres]
+	if len(my) != len(pg) {
+		t.Fatalf("mysql has %d migrations, postgres %d", len(my), len(pg))
+	}
+	for i := range my {
+		if my[i].Name != pg[i].Name || (my[i].Down == "") != (pg[i].Down == "") {
+			t.Fatalf("migration %04d differs: mysql %s (down %v), postgres %s (down %v)", my[i].Version, my[i].Name, my[i].Down != "", pg[i].Name, pg[i].Down != "")
+		}
+	}
+}
+
+func TestPostgresMigrationsAvoidMySQLSyntax(t *testing.T) {
+	all, err := loadMigrations(migrationFiles, "sql/postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		for _, bad := range []string{"AUTO_INCREMENT", "ENGINE=", "ON UPDATE CURRENT_TIMESTAMP", "DATETIME", "TINYINT", "UNIQUE KEY", "DATE_ADD", "`"} {
+			if strings.Contains(m.Up+m.Down, bad) {
+				t.Errorf("postgres %04d_%s contains %q", m.Version, m.Name, bad)
+			}
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := "-- comment\nCREATE TABLE a (\n  id INT\n);\n\nINSERT INTO a VALUES (1);\nSELECT 1"
+	got := splitStatements(script)
+	if len(got) != 3 || !strings.HasPrefix(got[0], "CREATE TABLE a (") || strings.HasSuffix(got[0], ";") || got[2] != "SELECT 1" {
+		t.Fatalf("splitStatements = %q", got)
+	}
+}
+
+func TestPlanUp(t *testing.T) {
+	all := testMigrations(t)
+	applied := map[int]AppliedMigration{1: {Version: 1, Name: "first", Checksum: all[0].Checksum}}
+	cases := []struct {
+		opts MigrateOptions
+		want []int
+	}{
+		{MigrateOptions{}, []int{2, 3}},
+		{MigrateOptions{Steps: 1}, []int{2}},
+		{MigrateOptions{Target: 2}, []int{2}},
+	}
+	for _, tc := range cases {
+		plan, err := planUp(all, applied, tc.opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.opts, err)
+		}
+		if got := versions(plan); len(got) != len(tc.want) || got[0] != tc.want[0] {
+			t.Fatalf("%+v: plan = %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+	if _, err := planUp(all, map[int]AppliedMigration{1: {Version: 1, Checksum: "edited"}}, MigrateOptions{}); err == nil {
+		t.Fatal("expected checksum mismatch error")
+	}
+	if _, err := planUp(all, map[int]AppliedMigration{9: {Version: 9, Name: "future"}}, MigrateOptions{}); err == nil {
+		t.Fatal("expected error for an applied migration without file")
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	all := testMigrations(t)
+	applied := map[int]AppliedMigration{}
+	for _, m := range all {
+		applied[m.Version] = AppliedMigration{Version: m.Version, Checksum: m.Checksum}
+	}
+	plan, err := planDown(all, applied, MigrateOptions{})
+	if err != nil || len(plan) != 1 || plan[0].Version != 3 {
+		t.Fatalf("default down = %v, %v", versions(plan), err)
+	}
+	// 0002 has no down script
+	if _, err := planDown(all, applied, MigrateOptions{Steps: 2}); err == nil {
+		t.Fatal("expected irreversible error")
+	}
+	delete(applied, 2)
+	plan, err = planDown(all, applied, MigrateOptions{Target: 0, Steps: 5})
+	if err != nil || len(plan) != 2 || plan[0].Version != 3 || plan[1].Version != 1 {
+		t.Fatalf("down steps = %v, %v", versions(plan), err)
+	}
+	plan, err = planDown(all, applied, MigrateOptions{Target: 1})
+	if err != nil || len(plan) != 1 || plan[0].Version != 3 {
+		t.Fatalf("down to 1 = %v, %v", versions(plan), err)
+	}
+}
@@ -0,0 +1,11 @@
+-- Drops the whole baseline schema (and its data). Only meant for throwaway databases.
+DROP TABLE IF EXISTS test_history;
+DROP TABLE IF EXISTS medical_categories;
+DROP TABLE IF EXISTS subscription_plan_versions;
+DROP TABLE IF EXISTS subscription_plan_prices;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS promo_redemptions;
+DROP TABLE IF EXISTS promo_codes;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS subscription_plans;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,199 @@
+-- Schema as created by the boot-time Migrate before versioned migrations existed.
+-- Every statement is idempotent: databases created by the old Migrate adopt it as-is
+-- (see adoptLegacySchema for the columns older databases may still miss).
+
+CREATE TABLE IF NOT EXISTS users (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	first_name VARCHAR(100) NOT NULL,
+	last_name VARCHAR(100) NOT NULL,
+	email VARCHAR(191) NOT NULL UNIQUE,
+	password VARCHAR(191) NOT NULL,
+	role VARCHAR(50) NOT NULL DEFAULT 'user',
+	profile_image VARCHAR(255) DEFAULT '',
+	city VARCHAR(100) DEFAULT '',
+	profession VARCHAR(100) DEFAULT '',
+	gender VARCHAR(50) DEFAULT '',
+	age INT DEFAULT NULL,
+	country_id INT DEFAULT NULL,
+	stripe_customer_id VARCHAR(100) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS subscription_plans (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	currency VARCHAR(10) NOT NULL DEFAULT 'USD',
+	price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	billing VARCHAR(50) NOT NULL DEFAULT 'Mensual',
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	billing_interval_count INT NOT NULL DEFAULT 1,
+	trial_days INT NOT NULL DEFAULT 0,
+	consultations INT NOT NULL DEFAULT 0,
+	questionnaires INT NOT NULL DEFAULT 0,
+	clinical_cases INT NOT NULL DEFAULT 0,
+	files INT NOT NULL DEFAULT 0,
+	stripe_product_id VARCHAR(100) NULL,
+	stripe_price_id VARCHAR(100) NULL,
+	current_version_id INT NULL,
+	version INT NOT NULL DEFAULT 0,
+	entitlements TEXT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	plan_id INT NOT NULL,
+	plan_version_id INT NULL,
+	start_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	end_date DATETIME NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'active',
+	frequency INT NOT NULL DEFAULT 0,
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	interval_count INT NOT NULL DEFAULT 1,
+	current_period_end DATETIME NULL,
+	stripe_subscription_id VARCHAR(100) NULL,
+	payment_gateway VARCHAR(20) NULL,
+	gateway_reference VARCHAR(100) NULL,
+	scheduled_plan_id INT NULL,
+	scheduled_interval VARCHAR(10) NULL,
+	promo_code VARCHAR(64) NULL,
+	trial_ends_at DATETIME NULL,
+	consultations INT NOT NULL DEFAULT 0,
+	questionnaires INT NOT NULL DEFAULT 0,
+	clinical_cases INT NOT NULL DEFAULT 0,
+	files INT NOT NULL DEFAULT 0,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (plan_id) REFERENCES subscription_plans(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS promo_codes (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	code VARCHAR(64) NOT NULL UNIQUE,
+	description VARCHAR(255) NULL,
+	percent_off DECIMAL(5,2) NOT NULL DEFAULT 0.00,
+	amount_off DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	currency VARCHAR(10) NULL,
+	duration VARCHAR(20) NOT NULL DEFAULT 'once',
+	duration_in_months INT NULL,
+	trial_days INT NOT NULL DEFAULT 0,
+	plan_id INT NULL,
+	max_redemptions INT NOT NULL DEFAULT 0,
+	times_redeemed INT NOT NULL DEFAULT 0,
+	expires_at DATETIME NULL,
+	active TINYINT(1) NOT NULL DEFAULT 1,
+	stripe_coupon_id VARCHAR(100) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (plan_id) REFERENCES subscription_plans(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	promo_code_id INT NOT NULL,
+	user_id INT NOT NULL,
+	subscription_id INT NOT NULL,
+	redeemed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE KEY uniq_promo_subscription (promo_code_id, subscription_id),
+	INDEX idx_promo_user (promo_code_id, user_id),
+	FOREIGN KEY (promo_code_id) REFERENCES promo_codes(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS payments (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	subscription_id INT NULL,
+	plan_id INT NULL,
+	gateway VARCHAR(20) NOT NULL,
+	gateway_payment_id VARCHAR(100) NOT NULL,
+	amount DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+	currency VARCHAR(10) NOT NULL DEFAULT 'USD',
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	payment_method VARCHAR(30) NULL,
+	description VARCHAR(255) NULL,
+	invoice_number VARCHAR(50) NULL,
+	invoice_pdf_url VARCHAR(500) NULL,
+	paid_at DATETIME NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	UNIQUE KEY uniq_gateway_payment (gateway, gateway_payment_id),
+	INDEX idx_payments_user (user_id, created_at),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS subscription_plan_prices (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	plan_id INT NOT NULL,
+	country_code VARCHAR(2) NOT NULL DEFAULT '',
+	currency VARCHAR(10) NULL,
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	interval_count INT NOT NULL DEFAULT 1,
+	price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	stripe_price_id VARCHAR(100) NULL,
+	UNIQUE KEY uniq_plan_country_interval (plan_id, country_code, billing_interval, interval_count),
+	FOREIGN KEY (plan_id) REFERENCES subscription_plans(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS subscription_plan_versions (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	plan_id INT NOT NULL,
+	version INT NOT NULL,
+	currency VARCHAR(10) NOT NULL DEFAULT 'USD',
+	price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	interval_count INT NOT NULL DEFAULT 1,
+	consultations INT NOT NULL DEFAULT 0,
+	questionnaires INT NOT NULL DEFAULT 0,
+	clinical_cases INT NOT NULL DEFAULT 0,
+	files INT NOT NULL DEFAULT 0,
+	statistics TINYINT NOT NULL DEFAULT 0,
+	trial_days INT NOT NULL DEFAULT 0,
+	entitlements TEXT NULL,
+	grandfathered_until DATETIME NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE KEY uniq_plan_version (plan_id, version),
+	FOREIGN KEY (plan_id) REFERENCES subscription_plans(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS medical_categories (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(191) NOT NULL UNIQUE,
+	description TEXT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS test_history (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	category_id INT NULL,
+	test_name VARCHAR(255) NOT NULL,
+	score_obtained INT NOT NULL DEFAULT 0,
+	max_score INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (category_id) REFERENCES medical_categories(id) ON DELETE SET NULL,
+	INDEX idx_user_created (user_id, created_at),
+	INDEX idx_user_category (user_id, category_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Data backfills the old Migrate repeated on every boot
+UPDATE subscriptions SET payment_gateway='stripe' WHERE payment_gateway IS NULL AND stripe_subscription_id IS NOT NULL;
+
+UPDATE subscriptions SET current_period_end = CASE billing_interval
+	WHEN 'year' THEN DATE_ADD(start_date, INTERVAL interval_count YEAR)
+	WHEN 'week' THEN DATE_ADD(start_date, INTERVAL interval_count WEEK)
+	WHEN 'day' THEN DATE_ADD(start_date, INTERVAL interval_count DAY)
+	ELSE DATE_ADD(start_date, INTERVAL interval_count MONTH) END
+	WHERE current_period_end IS NULL;
+
+INSERT INTO subscription_plan_versions (plan_id, version, currency, price, billing_interval, interval_count, consultations, questionnaires, clinical_cases, files, statistics, trial_days, entitlements)
+	SELECT p.id, 1, p.currency, p.price, COALESCE(p.billing_interval,'month'), COALESCE(p.billing_interval_count,1), p.consultations, p.questionnaires, p.clinical_cases, p.files,
+		CASE WHEN p.price>0 THEN 1 ELSE 0 END, COALESCE(p.trial_days,0), p.entitlements
+	FROM subscription_plans p WHERE NOT EXISTS (SELECT 1 FROM subscription_plan_versions v WHERE v.plan_id = p.id);
+
+UPDATE subscription_plans p JOIN subscription_plan_versions v ON v.plan_id = p.id AND v.version = 1
+	SET p.current_version_id = v.id, p.version = 1 WHERE p.current_version_id IS NULL;
+
+UPDATE subscriptions s JOIN subscription_plans p ON p.id = s.plan_id
+	SET s.plan_version_id = p.current_version_id WHERE s.plan_version_id IS NULL AND p.current_version_id IS NOT NULL;
@@ -0,0 +1,16 @@
+-- Audit trail of admin actions (no FKs: entries outlive the users they mention)
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	admin_user_id INT NOT NULL,
+	admin_email VARCHAR(255) NOT NULL,
+	action VARCHAR(100) NOT NULL,
+	target_type VARCHAR(50) NOT NULL DEFAULT '',
+	target_id VARCHAR(50) NOT NULL DEFAULT '',
+	reason VARCHAR(500) NULL,
+	details TEXT NULL,
+	ip VARCHAR(64) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_audit_created (created_at),
+	INDEX idx_audit_target (target_type, target_id),
+	INDEX idx_audit_admin (admin_user_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+-- Server-side index of the chat threads handed out by /conversations/start (owner, title, source)
+CREATE TABLE IF NOT EXISTS conversations (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	thread_id VARCHAR(191) NOT NULL UNIQUE,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	source_type VARCHAR(20) NOT NULL DEFAULT 'chat',
+	archived TINYINT(1) NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_conversations_user (user_id, archived, updated_at),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
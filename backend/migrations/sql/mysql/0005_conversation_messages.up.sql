@@ -0,0 +1,17 @@
+-- Local transcript of every chat turn (prompt and final answer), independent of the OpenAI thread.
+-- user_id is NULL for anonymous threads.
+CREATE TABLE IF NOT EXISTS conversation_messages (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	thread_id VARCHAR(191) NOT NULL,
+	user_id INT NULL,
+	role VARCHAR(16) NOT NULL,
+	content MEDIUMTEXT NOT NULL,
+	attachment VARCHAR(255) NOT NULL DEFAULT '',
+	source VARCHAR(40) NOT NULL DEFAULT '',
+	citations TEXT NULL,
+	latency_ms INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_conversation_messages_thread (thread_id, id),
+	INDEX idx_conversation_messages_user (user_id, created_at),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,28 @@
+-- Registry of the OpenAI vector stores searched by the AI flows (books library, specialty
+-- libraries). flows is a comma-separated list of flows (chat, quiz, clinical_case,
+-- interactive_case; empty = every flow); knowledge_base_categories narrows a base to medical
+-- categories (none = general). Registered stores are never deleted by the TTL cleanup.
+CREATE TABLE IF NOT EXISTS knowledge_bases (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(191) NOT NULL,
+	specialty VARCHAR(191) NOT NULL DEFAULT '',
+	vector_store_id VARCHAR(191) NOT NULL UNIQUE,
+	language VARCHAR(10) NOT NULL DEFAULT 'es',
+	flows VARCHAR(255) NOT NULL DEFAULT '',
+	active TINYINT(1) NOT NULL DEFAULT 1,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS knowledge_base_categories (
+	knowledge_base_id INT NOT NULL,
+	category_id INT NOT NULL,
+	PRIMARY KEY (knowledge_base_id, category_id),
+	INDEX idx_knowledge_base_categories_category (category_id),
+	FOREIGN KEY (knowledge_base_id) REFERENCES knowledge_bases(id) ON DELETE CASCADE,
+	FOREIGN KEY (category_id) REFERENCES medical_categories(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- The books library every flow used until now
+INSERT IGNORE INTO knowledge_bases (name, specialty, vector_store_id, language, flows, active)
+VALUES ('Biblioteca médica', 'medicina general', 'vs_680fc484cef081918b2b9588b701e2f4', 'es', '', 1);
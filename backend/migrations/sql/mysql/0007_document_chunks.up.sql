@@ -0,0 +1,15 @@
+-- Local index of the PDFs uploaded to a conversation (PDF_LOCAL_INDEX=1): one row per chunk
+-- with its page and embedding (little-endian float32, normalized), searched by brute force
+-- per thread instead of an OpenAI vector store.
+CREATE TABLE IF NOT EXISTS document_chunks (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	thread_id VARCHAR(191) NOT NULL,
+	document_id VARCHAR(64) NOT NULL,
+	file_name VARCHAR(255) NOT NULL DEFAULT '',
+	page INT NOT NULL DEFAULT 0,
+	chunk_index INT NOT NULL DEFAULT 0,
+	content TEXT NOT NULL,
+	embedding MEDIUMBLOB NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_document_chunks_thread (thread_id, document_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
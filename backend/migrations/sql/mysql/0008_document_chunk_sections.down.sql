@@ -0,0 +1 @@
+ALTER TABLE document_chunks DROP COLUMN section;
@@ -0,0 +1,3 @@
+-- Section heading in effect where each chunk starts, captured at upload, for page-accurate
+-- citations (document, page, section, snippet).
+ALTER TABLE document_chunks ADD COLUMN section VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE subscriptions DROP COLUMN scheduled_interval_count;
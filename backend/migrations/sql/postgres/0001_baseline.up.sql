@@ -0,0 +1,171 @@
+-- Postgres version of the MySQL baseline (same tables, columns and keys). updated_at has no
+-- automatic refresh on Postgres: the UPDATE statements set it themselves.
+
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	first_name VARCHAR(100) NOT NULL,
+	last_name VARCHAR(100) NOT NULL,
+	email VARCHAR(191) NOT NULL UNIQUE,
+	password VARCHAR(191) NOT NULL,
+	role VARCHAR(50) NOT NULL DEFAULT 'user',
+	profile_image VARCHAR(255) DEFAULT '',
+	city VARCHAR(100) DEFAULT '',
+	profession VARCHAR(100) DEFAULT '',
+	gender VARCHAR(50) DEFAULT '',
+	age INT DEFAULT NULL,
+	country_id INT DEFAULT NULL,
+	stripe_customer_id VARCHAR(100) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS subscription_plans (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	currency VARCHAR(10) NOT NULL DEFAULT 'USD',
+	price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	billing VARCHAR(50) NOT NULL DEFAULT 'Mensual',
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	billing_interval_count INT NOT NULL DEFAULT 1,
+	trial_days INT NOT NULL DEFAULT 0,
+	consultations INT NOT NULL DEFAULT 0,
+	questionnaires INT NOT NULL DEFAULT 0,
+	clinical_cases INT NOT NULL DEFAULT 0,
+	files INT NOT NULL DEFAULT 0,
+	stripe_product_id VARCHAR(100) NULL,
+	stripe_price_id VARCHAR(100) NULL,
+	current_version_id INT NULL,
+	version INT NOT NULL DEFAULT 0,
+	entitlements TEXT NULL
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	plan_id INT NOT NULL REFERENCES subscription_plans(id) ON DELETE CASCADE,
+	plan_version_id INT NULL,
+	start_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	end_date TIMESTAMP NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'active',
+	frequency INT NOT NULL DEFAULT 0,
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	interval_count INT NOT NULL DEFAULT 1,
+	current_period_end TIMESTAMP NULL,
+	stripe_subscription_id VARCHAR(100) NULL,
+	payment_gateway VARCHAR(20) NULL,
+	gateway_reference VARCHAR(100) NULL,
+	scheduled_plan_id INT NULL,
+	scheduled_interval VARCHAR(10) NULL,
+	promo_code VARCHAR(64) NULL,
+	trial_ends_at TIMESTAMP NULL,
+	consultations INT NOT NULL DEFAULT 0,
+	questionnaires INT NOT NULL DEFAULT 0,
+	clinical_cases INT NOT NULL DEFAULT 0,
+	files INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS promo_codes (
+	id SERIAL PRIMARY KEY,
+	code VARCHAR(64) NOT NULL UNIQUE,
+	description VARCHAR(255) NULL,
+	percent_off DECIMAL(5,2) NOT NULL DEFAULT 0.00,
+	amount_off DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	currency VARCHAR(10) NULL,
+	duration VARCHAR(20) NOT NULL DEFAULT 'once',
+	duration_in_months INT NULL,
+	trial_days INT NOT NULL DEFAULT 0,
+	plan_id INT NULL REFERENCES subscription_plans(id) ON DELETE SET NULL,
+	max_redemptions INT NOT NULL DEFAULT 0,
+	times_redeemed INT NOT NULL DEFAULT 0,
+	expires_at TIMESTAMP NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	stripe_coupon_id VARCHAR(100) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+	id SERIAL PRIMARY KEY,
+	promo_code_id INT NOT NULL REFERENCES promo_codes(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subscription_id INT NOT NULL,
+	redeemed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT uniq_promo_subscription UNIQUE (promo_code_id, subscription_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_promo_user ON promo_redemptions (promo_code_id, user_id);
+
+CREATE TABLE IF NOT EXISTS payments (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subscription_id INT NULL,
+	plan_id INT NULL,
+	gateway VARCHAR(20) NOT NULL,
+	gateway_payment_id VARCHAR(100) NOT NULL,
+	amount DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+	currency VARCHAR(10) NOT NULL DEFAULT 'USD',
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	payment_method VARCHAR(30) NULL,
+	description VARCHAR(255) NULL,
+	invoice_number VARCHAR(50) NULL,
+	invoice_pdf_url VARCHAR(500) NULL,
+	paid_at TIMESTAMP NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT uniq_gateway_payment UNIQUE (gateway, gateway_payment_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_user ON payments (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS subscription_plan_prices (
+	id SERIAL PRIMARY KEY,
+	plan_id INT NOT NULL REFERENCES subscription_plans(id) ON DELETE CASCADE,
+	country_code VARCHAR(2) NOT NULL DEFAULT '',
+	currency VARCHAR(10) NULL,
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	interval_count INT NOT NULL DEFAULT 1,
+	price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	stripe_price_id VARCHAR(100) NULL,
+	CONSTRAINT uniq_plan_country_interval UNIQUE (plan_id, country_code, billing_interval, interval_count)
+);
+
+CREATE TABLE IF NOT EXISTS subscription_plan_versions (
+	id SERIAL PRIMARY KEY,
+	plan_id INT NOT NULL REFERENCES subscription_plans(id) ON DELETE CASCADE,
+	version INT NOT NULL,
+	currency VARCHAR(10) NOT NULL DEFAULT 'USD',
+	price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+	billing_interval VARCHAR(10) NOT NULL DEFAULT 'month',
+	interval_count INT NOT NULL DEFAULT 1,
+	consultations INT NOT NULL DEFAULT 0,
+	questionnaires INT NOT NULL DEFAULT 0,
+	clinical_cases INT NOT NULL DEFAULT 0,
+	files INT NOT NULL DEFAULT 0,
+	statistics SMALLINT NOT NULL DEFAULT 0,
+	trial_days INT NOT NULL DEFAULT 0,
+	entitlements TEXT NULL,
+	grandfathered_until TIMESTAMP NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT uniq_plan_version UNIQUE (plan_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS medical_categories (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(191) NOT NULL UNIQUE,
+	description TEXT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS test_history (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	category_id INT NULL REFERENCES medical_categories(id) ON DELETE SET NULL,
+	test_name VARCHAR(255) NOT NULL,
+	score_obtained INT NOT NULL DEFAULT 0,
+	max_score INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_created ON test_history (user_id, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_user_category ON test_history (user_id, category_id);
@@ -0,0 +1,19 @@
+-- Audit trail of admin actions (no FKs: entries outlive the users they mention)
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+	id SERIAL PRIMARY KEY,
+	admin_user_id INT NOT NULL,
+	admin_email VARCHAR(255) NOT NULL,
+	action VARCHAR(100) NOT NULL,
+	target_type VARCHAR(50) NOT NULL DEFAULT '',
+	target_id VARCHAR(50) NOT NULL DEFAULT '',
+	reason VARCHAR(500) NULL,
+	details TEXT NULL,
+	ip VARCHAR(64) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_created ON admin_audit_log (created_at);
+
+CREATE INDEX IF NOT EXISTS idx_audit_target ON admin_audit_log (target_type, target_id);
+
+CREATE INDEX IF NOT EXISTS idx_audit_admin ON admin_audit_log (admin_user_id, created_at);
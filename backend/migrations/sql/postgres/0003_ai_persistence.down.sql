@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ai_filecache;
+DROP TABLE IF EXISTS ai_vectorstores;
@@ -0,0 +1,11 @@
+-- Key-value tables of the OpenAI client (thread -> vector store, file cache key -> file id),
+-- previously created lazily by the openai package.
+CREATE TABLE IF NOT EXISTS ai_vectorstores (
+	thread_id VARCHAR(191) PRIMARY KEY,
+	vector_store_id VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS ai_filecache (
+	ckey VARCHAR(191) PRIMARY KEY,
+	file_id VARCHAR(255)
+);
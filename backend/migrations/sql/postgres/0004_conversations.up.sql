@@ -0,0 +1,13 @@
+-- Server-side index of the chat threads handed out by /conversations/start (owner, title, source)
+CREATE TABLE IF NOT EXISTS conversations (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	thread_id VARCHAR(191) NOT NULL UNIQUE,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	source_type VARCHAR(20) NOT NULL DEFAULT 'chat',
+	archived BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversations_user ON conversations (user_id, archived, updated_at);
@@ -0,0 +1,17 @@
+-- Local transcript of every chat turn (prompt and final answer), independent of the OpenAI thread.
+-- user_id is NULL for anonymous threads.
+CREATE TABLE IF NOT EXISTS conversation_messages (
+	id BIGSERIAL PRIMARY KEY,
+	thread_id VARCHAR(191) NOT NULL,
+	user_id INT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role VARCHAR(16) NOT NULL,
+	content TEXT NOT NULL,
+	attachment VARCHAR(255) NOT NULL DEFAULT '',
+	source VARCHAR(40) NOT NULL DEFAULT '',
+	citations TEXT NULL,
+	latency_ms INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_messages_thread ON conversation_messages (thread_id, id);
+CREATE INDEX IF NOT EXISTS idx_conversation_messages_user ON conversation_messages (user_id, created_at);
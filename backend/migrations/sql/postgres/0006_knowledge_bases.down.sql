@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS knowledge_base_categories;
+DROP TABLE IF EXISTS knowledge_bases;
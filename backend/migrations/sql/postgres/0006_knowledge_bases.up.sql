@@ -0,0 +1,28 @@
+-- Registry of the OpenAI vector stores searched by the AI flows (books library, specialty
+-- libraries). flows is a comma-separated list of flows (chat, quiz, clinical_case,
+-- interactive_case; empty = every flow); knowledge_base_categories narrows a base to medical
+-- categories (none = general). Registered stores are never deleted by the TTL cleanup.
+CREATE TABLE IF NOT EXISTS knowledge_bases (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(191) NOT NULL,
+	specialty VARCHAR(191) NOT NULL DEFAULT '',
+	vector_store_id VARCHAR(191) NOT NULL UNIQUE,
+	language VARCHAR(10) NOT NULL DEFAULT 'es',
+	flows VARCHAR(255) NOT NULL DEFAULT '',
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS knowledge_base_categories (
+	knowledge_base_id INT NOT NULL REFERENCES knowledge_bases(id) ON DELETE CASCADE,
+	category_id INT NOT NULL REFERENCES medical_categories(id) ON DELETE CASCADE,
+	PRIMARY KEY (knowledge_base_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_knowledge_base_categories_category ON knowledge_base_categories (category_id);
+
+-- The books library every flow used until now
+INSERT INTO knowledge_bases (name, specialty, vector_store_id, language, flows, active)
+VALUES ('Biblioteca médica', 'medicina general', 'vs_680fc484cef081918b2b9588b701e2f4', 'es', '', TRUE)
+ON CONFLICT (vector_store_id) DO NOTHING;
@@ -0,0 +1,3 @@
+-- Interval count of the price a scheduled plan change (downgrade) moves to, applied with it
+-- at renewal; NULL means 1.
+ALTER TABLE subscriptions ADD COLUMN scheduled_interval_count INT NULL;
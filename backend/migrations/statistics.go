@@ -8,15 +8,11 @@ import (
 )
 
 // RecordTestCompletion registra un test completado en el historial
-func RecordTestCompletion(userID int, categoryID *int, testName string, scoreObtained, maxScore int) error {
-	if db == nil {
-		return fmt.Errorf("db is not initialized")
-	}
-
+func (s *SQLStore) RecordTestCompletion(userID int, categoryID *int, testName string, scoreObtained, maxScore int) error {
 	log.Printf("[STATS] Recording test: userID=%d categoryID=%v testName=%s score=%d/%d",
 		userID, categoryID, testName, scoreObtained, maxScore)
 
-	_, err := db.Exec(
+	_, err := s.db.Exec(
 		"INSERT INTO test_history (user_id, category_id, test_name, score_obtained, max_score) VALUES (?, ?, ?, ?, ?)",
 		userID, categoryID, testName, scoreObtained, maxScore,
 	)
@@ -29,135 +25,90 @@ func RecordTestCompletion(userID int, categoryID *int, testName string, scoreObt
 }
 
 // GetTestProgress obtiene estadísticas generales de progreso del usuario
-func GetTestProgress(userID int, limit int) (map[string]interface{}, error) {
-	if db == nil {
-		return nil, fmt.Errorf("db is not initialized")
-	}
-
+func (s *SQLStore) GetTestProgress(userID int, limit int) (*TestProgress, error) {
 	// Obtener resumen general (promedio de TODOS los tests)
 	summaryQuery := `
-		SELECT 
+		SELECT
 			COUNT(*) as total_tests,
-			IFNULL(SUM(score_obtained), 0) as total_score_obtained,
-			IFNULL(SUM(max_score), 0) as total_max_score
+			COALESCE(SUM(score_obtained), 0) as total_score_obtained,
+			COALESCE(SUM(max_score), 0) as total_max_score
 		FROM test_history
 		WHERE user_id = ?`
 
-	var totalTests, totalScoreObtained, totalMaxScore int
-	err := db.QueryRow(summaryQuery, userID).Scan(&totalTests, &totalScoreObtained, &totalMaxScore)
+	var progress TestProgress
+	sum := &progress.Summary
+	err := s.db.QueryRow(summaryQuery, userID).Scan(&sum.TotalTests, &sum.TotalScore, &sum.TotalMaxScore)
 	if err != nil {
 		return nil, err
 	}
 
 	// Calcular promedio general
-	averagePercentage := 0.0
-	if totalMaxScore > 0 {
-		averagePercentage = (float64(totalScoreObtained) / float64(totalMaxScore)) * 100
-	}
+	sum.AveragePercentage = percentage(sum.TotalScore, sum.TotalMaxScore)
 
 	// Obtener últimos tests para detalle
 	detailQuery := `
 		SELECT th.id, th.test_name, th.score_obtained, th.max_score, th.created_at,
-		       IFNULL(mc.name, '') as category_name
+		       COALESCE(mc.name, '') as category_name
 		FROM test_history th
 		LEFT JOIN medical_categories mc ON th.category_id = mc.id
 		WHERE th.user_id = ?
 		ORDER BY th.created_at DESC
 		LIMIT ?`
 
-	rows, err := db.Query(detailQuery, userID, limit)
+	rows, err := s.db.Query(detailQuery, userID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var recentTests []map[string]interface{}
 	for rows.Next() {
-		var id, scoreObtained, maxScore int
-		var testName, categoryName string
-		var createdAt time.Time
-
-		if err := rows.Scan(&id, &testName, &scoreObtained, &maxScore, &createdAt, &categoryName); err != nil {
+		var t TestResult
+		if err := rows.Scan(&t.TestID, &t.TestName, &t.ScoreObtained, &t.MaxScore, &t.CreatedAt, &t.CategoryName); err != nil {
 			continue
 		}
-
-		percentage := 0.0
-		if maxScore > 0 {
-			percentage = (float64(scoreObtained) / float64(maxScore)) * 100
-		}
-
-		recentTests = append(recentTests, map[string]interface{}{
-			"test_id":        id,
-			"test_name":      testName,
-			"score_obtained": scoreObtained,
-			"max_score":      maxScore,
-			"percentage":     percentage,
-			"category_name":  categoryName,
-			"created_at":     createdAt.Format(time.RFC3339),
-		})
+		t.Percentage = percentage(t.ScoreObtained, t.MaxScore)
+		progress.RecentTests = append(progress.RecentTests, t)
 	}
 
 	// Retornar resumen + últimos tests
-	return map[string]interface{}{
-		"summary": map[string]interface{}{
-			"total_tests":        totalTests,
-			"total_score":        totalScoreObtained,
-			"total_max_score":    totalMaxScore,
-			"average_percentage": averagePercentage,
-		},
-		"recent_tests": recentTests,
-	}, nil
+	return &progress, nil
 }
 
 // GetMonthlyScores obtiene los puntajes agrupados por mes (últimos 6 meses)
-func GetMonthlyScores(userID int) ([]map[string]interface{}, error) {
-	if db == nil {
-		return nil, fmt.Errorf("db is not initialized")
-	}
-
+func (s *SQLStore) GetMonthlyScores(userID int) ([]MonthlyScore, error) {
+	month := s.dialect.MonthKey("created_at")
 	query := `
-		SELECT 
-			DATE_FORMAT(created_at, '%Y-%m') as mes,
+		SELECT
+			` + month + ` as mes,
 			SUM(score_obtained) as puntos,
 			COUNT(*) as tests_count
 		FROM test_history
-		WHERE user_id = ? 
-		  AND created_at >= DATE_SUB(NOW(), INTERVAL 6 MONTH)
-		GROUP BY DATE_FORMAT(created_at, '%Y-%m')
+		WHERE user_id = ?
+		  AND created_at >= ?
+		GROUP BY ` + month + `
 		ORDER BY mes DESC
 		LIMIT 6`
 
-	rows, err := db.Query(query, userID)
+	rows, err := s.db.Query(query, userID, time.Now().AddDate(0, -6, 0))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []map[string]interface{}
+	var results []MonthlyScore
 	for rows.Next() {
-		var mes string
-		var puntos, testsCount int
-
-		if err := rows.Scan(&mes, &puntos, &testsCount); err != nil {
+		var m MonthlyScore
+		if err := rows.Scan(&m.Month, &m.Points, &m.TestsCount); err != nil {
 			continue
 		}
-
-		results = append(results, map[string]interface{}{
-			"mes":         mes,
-			"puntos":      puntos,
-			"tests_count": testsCount,
-		})
+		results = append(results, m)
 	}
 
 	return results, nil
 }
 
 // GetMostStudiedCategory obtiene la categoría más estudiada del usuario
-func GetMostStudiedCategory(userID int) (map[string]interface{}, error) {
-	if db == nil {
-		return nil, fmt.Errorf("db is not initialized")
-	}
-
+func (s *SQLStore) GetMostStudiedCategory(userID int) (*StudiedCategory, error) {
 	query := `
 		SELECT mc.id, mc.name, COUNT(*) as study_count
 		FROM test_history th
@@ -167,22 +118,13 @@ func GetMostStudiedCategory(userID int) (map[string]interface{}, error) {
 		ORDER BY study_count DESC
 		LIMIT 1`
 
-	row := db.QueryRow(query, userID)
-
-	var categoryID, studyCount int
-	var categoryName string
-
-	err := row.Scan(&categoryID, &categoryName, &studyCount)
+	var c StudiedCategory
+	err := s.db.QueryRow(query, userID).Scan(&c.CategoryID, &c.CategoryName, &c.StudyCount)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No hay categoría estudiada
 		}
 		return nil, err
 	}
-
-	return map[string]interface{}{
-		"category_id":   categoryID,
-		"category_name": categoryName,
-		"study_count":   studyCount,
-	}, nil
+	return &c, nil
 }
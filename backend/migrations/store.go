@@ -0,0 +1,168 @@
+package migrations
+
+import "time"
+
+// Stores used by the HTTP packages (login, profile, stats, quota). SQLStore implements them
+// on the database; MemoryStore keeps everything in memory for tests.
+
+// UserStore reads and updates users.
+type UserStore interface {
+	GetUserByEmail(email string) *User
+	GetUserByID(id int) *User
+	EmailExists(email string) (bool, error)
+	CreateUser(firstName, lastName, email, password, role string) error
+	UpdateUserPassword(id int, password string) error
+	// UpdateUserProfile keeps the current value of every empty string / nil argument.
+	UpdateUserProfile(id int, firstName, lastName, city, profession, gender string, age, countryID *int) error
+	UpdateUserProfileImage(id int, path string) error
+}
+
+// SubscriptionStore reads the active subscription of a user and updates its quotas.
+type SubscriptionStore interface {
+	// EnsureFreeSubscription creates a Free subscription when the user has no active one.
+	EnsureFreeSubscription(userID int) error
+	// GetActiveSubscription returns nil, nil when the user has no active subscription.
+	GetActiveSubscription(userID int) (*ActiveSubscription, error)
+	// ResetActiveSubscriptionQuotas sets the quotas back to the limits of the plan version.
+	ResetActiveSubscriptionQuotas(userID int) error
+	SetQuota(subscriptionID int, field string, value int) error
+	// ConsumeQuota decrements field by amount only if enough is left; false means exhausted.
+	ConsumeQuota(subscriptionID int, field string, amount int) (bool, error)
+}
+
+// StatsStore records completed tests and aggregates the user's history (test_history).
+type StatsStore interface {
+	RecordTestCompletion(userID int, categoryID *int, testName string, scoreObtained, maxScore int) error
+	GetTestProgress(userID int, limit int) (*TestProgress, error)
+	// GetMonthlyScores covers the last 6 months, newest first.
+	GetMonthlyScores(userID int) ([]MonthlyScore, error)
+	// GetMostStudiedCategory returns nil, nil when no test has a category.
+	GetMostStudiedCategory(userID int) (*StudiedCategory, error)
+}
+
+// QuotaFields are the subscription columns consumed by the app flows.
+var QuotaFields = []string{"consultations", "questionnaires", "clinical_cases", "files"}
+
+func isQuotaField(field string) bool {
+	for _, f := range QuotaFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Quotas are the four usage counters of a plan (limits) or a subscription (remaining).
+type Quotas struct {
+	Consultations  int `json:"consultations"`
+	Questionnaires int `json:"questionnaires"`
+	ClinicalCases  int `json:"clinical_cases"`
+	Files          int `json:"files"`
+}
+
+// Get returns the value of a quota field (0 for unknown fields).
+func (q Quotas) Get(field string) int {
+	switch field {
+	case "consultations":
+		return q.Consultations
+	case "questionnaires":
+		return q.Questionnaires
+	case "clinical_cases":
+		return q.ClinicalCases
+	case "files":
+		return q.Files
+	}
+	return 0
+}
+
+func (q *Quotas) set(field string, value int) {
+	switch field {
+	case "consultations":
+		q.Consultations = value
+	case "questionnaires":
+		q.Questionnaires = value
+	case "clinical_cases":
+		q.ClinicalCases = value
+	case "files":
+		q.Files = value
+	}
+}
+
+// ActivePlan is the plan of an active subscription, with the limits and price of the plan
+// version the subscription is pinned to.
+type ActivePlan struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	Currency      string  `json:"currency"`
+	Price         float64 `json:"price"`
+	Billing       string  `json:"billing"`
+	Interval      string  `json:"interval"`
+	IntervalCount int     `json:"interval_count"`
+	Quotas
+	Statistics int `json:"statistics"`
+}
+
+// ActiveSubscription is the active subscription of a user joined with its plan; Quotas are
+// the remaining usages. Its JSON is the active_subscription of the profile endpoints.
+type ActiveSubscription struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"user_id"`
+	PlanID           int        `json:"plan_id"`
+	StartDate        time.Time  `json:"start_date"`
+	EndDate          *time.Time `json:"end_date"`
+	Status           string     `json:"status"`
+	Frequency        int        `json:"frequency"`
+	Interval         string     `json:"interval"`
+	IntervalCount    int        `json:"interval_count"`
+	CurrentPeriodEnd *time.Time `json:"current_period_end"`
+	Quotas
+	Statistics int        `json:"statistics"`
+	Plan       ActivePlan `json:"subscription_plan"`
+}
+
+// Used returns how many usages of field the subscription consumed in the period.
+func (s *ActiveSubscription) Used(field string) int {
+	return s.Plan.Get(field) - s.Get(field)
+}
+
+// TestProgress is the overall score of a user plus the latest tests.
+type TestProgress struct {
+	Summary     TestSummary  `json:"summary"`
+	RecentTests []TestResult `json:"recent_tests"`
+}
+
+type TestSummary struct {
+	TotalTests        int     `json:"total_tests"`
+	TotalScore        int     `json:"total_score"`
+	TotalMaxScore     int     `json:"total_max_score"`
+	AveragePercentage float64 `json:"average_percentage"`
+}
+
+type TestResult struct {
+	TestID        int       `json:"test_id"`
+	TestName      string    `json:"test_name"`
+	ScoreObtained int       `json:"score_obtained"`
+	MaxScore      int       `json:"max_score"`
+	Percentage    float64   `json:"percentage"`
+	CategoryName  string    `json:"category_name"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type MonthlyScore struct {
+	Month      string `json:"mes"` // YYYY-MM
+	Points     int    `json:"puntos"`
+	TestsCount int    `json:"tests_count"`
+}
+
+type StudiedCategory struct {
+	CategoryID   int    `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	StudyCount   int    `json:"study_count"`
+}
+
+func percentage(obtained, max int) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return float64(obtained) / float64(max) * 100
+}
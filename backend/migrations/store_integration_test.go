@@ -0,0 +1,111 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"ema-backend/conn/conntest"
+)
+
+// migrateFresh applies every migration to database and rolls them all back when the test ends.
+func migrateFresh(t *testing.T, database *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	m, err := NewMigrator(database)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	if _, err := m.Up(ctx, MigrateOptions{}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := m.Down(ctx, MigrateOptions{Steps: len(m.migrations)}); err != nil {
+			t.Errorf("Down: %v", err)
+		}
+	})
+	if again, err := m.Up(ctx, MigrateOptions{}); err != nil || len(again) != 0 {
+		t.Fatalf("second Up = %d, %v; want nothing to apply", len(again), err)
+	}
+}
+
+func TestSQLStoreMatrix(t *testing.T) {
+	conntest.ForEach(t, func(t *testing.T, database *sql.DB) {
+		migrateFresh(t, database)
+		Init(database)
+		t.Cleanup(func() { Init(nil) })
+		if err := SeedDefaultPlans(); err != nil {
+			t.Fatalf("SeedDefaultPlans: %v", err)
+		}
+		if err := EnsurePlanVersions(); err != nil {
+			t.Fatalf("EnsurePlanVersions: %v", err)
+		}
+		s := NewSQLStore(database)
+
+		if err := s.CreateUser("Ada", "Lovelace", "ada@example.com", "hash", "user"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		u := s.GetUserByEmail("ada@example.com")
+		if u == nil || u.FirstName != "Ada" || u.City != "" {
+			t.Fatalf("GetUserByEmail = %+v", u)
+		}
+		age := 36
+		if err := s.UpdateUserProfile(u.ID, "", "", "London", "", "", &age, nil); err != nil {
+			t.Fatalf("UpdateUserProfile: %v", err)
+		}
+		if got := s.GetUserByID(u.ID); got.City != "London" || got.Age == nil || *got.Age != 36 || got.LastName != "Lovelace" {
+			t.Fatalf("profile after update = %+v", got)
+		}
+		if users, total, err := SearchUsers("LOVE", 10, 0); err != nil || total != 1 || len(users) != 1 {
+			t.Fatalf("SearchUsers = %d users, total %d, %v", len(users), total, err)
+		}
+
+		if err := s.EnsureFreeSubscription(u.ID); err != nil {
+			t.Fatalf("EnsureFreeSubscription: %v", err)
+		}
+		sub, err := s.GetActiveSubscription(u.ID)
+		if err != nil || sub == nil {
+			t.Fatalf("GetActiveSubscription = %v, %v", sub, err)
+		}
+		if sub.Plan.Name != "Free" || sub.Consultations != 3 || sub.Plan.Consultations != 3 || sub.CurrentPeriodEnd == nil {
+			t.Fatalf("free subscription = %+v", sub)
+		}
+		if ok, err := s.ConsumeQuota(sub.ID, "consultations", 2); err != nil || !ok {
+			t.Fatalf("ConsumeQuota = %v, %v", ok, err)
+		}
+		if ok, _ := s.ConsumeQuota(sub.ID, "consultations", 2); ok {
+			t.Fatal("ConsumeQuota went below zero")
+		}
+		if err := s.ResetActiveSubscriptionQuotas(u.ID); err != nil {
+			t.Fatalf("ResetActiveSubscriptionQuotas: %v", err)
+		}
+		if sub, _ = s.GetActiveSubscription(u.ID); sub.Consultations != 3 {
+			t.Fatalf("consultations after reset = %d", sub.Consultations)
+		}
+
+		if _, err := database.Exec("INSERT INTO medical_categories (name) VALUES (?)", "Cardiología"); err != nil {
+			t.Fatalf("insert category: %v", err)
+		}
+		var categoryID int
+		if err := database.QueryRow("SELECT id FROM medical_categories WHERE name = ?", "Cardiología").Scan(&categoryID); err != nil {
+			t.Fatalf("category id: %v", err)
+		}
+		for _, score := range []int{6, 8} {
+			if err := s.RecordTestCompletion(u.ID, &categoryID, "Quiz", score, 10); err != nil {
+				t.Fatalf("RecordTestCompletion: %v", err)
+			}
+		}
+		progress, err := s.GetTestProgress(u.ID, 5)
+		if err != nil || progress.Summary.TotalTests != 2 || progress.Summary.AveragePercentage != 70 || progress.RecentTests[0].CategoryName != "Cardiología" {
+			t.Fatalf("GetTestProgress = %+v, %v", progress, err)
+		}
+		months, err := s.GetMonthlyScores(u.ID)
+		if err != nil || len(months) != 1 || months[0].Month != time.Now().Format("2006-01") || months[0].Points != 14 {
+			t.Fatalf("GetMonthlyScores = %+v, %v", months, err)
+		}
+		if c, err := s.GetMostStudiedCategory(u.ID); err != nil || c == nil || c.CategoryID != categoryID || c.StudyCount != 2 {
+			t.Fatalf("GetMostStudiedCategory = %+v, %v", c, err)
+		}
+	})
+}
@@ -0,0 +1,338 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory UserStore, SubscriptionStore and StatsStore for tests and
+// local runs without a database. Seed it with AddUser / AddSubscription / AddCategory.
+type MemoryStore struct {
+	// FreePlan is the plan given by EnsureFreeSubscription.
+	FreePlan ActivePlan
+
+	mu         sync.Mutex
+	users      map[int]*User
+	subs       []*ActiveSubscription
+	tests      []memoryTest
+	categories map[int]string
+	nextID     int
+	now        func() time.Time
+}
+
+type memoryTest struct {
+	TestResult
+	userID     int
+	categoryID *int
+}
+
+var (
+	_ UserStore         = (*MemoryStore)(nil)
+	_ SubscriptionStore = (*MemoryStore)(nil)
+	_ StatsStore        = (*MemoryStore)(nil)
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		FreePlan:   ActivePlan{Name: "Free", Currency: "USD", Billing: "Mensual", Interval: "month", IntervalCount: 1},
+		users:      map[int]*User{},
+		categories: map[int]string{},
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryStore) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+// AddUser stores a copy of u (assigning an ID when zero) and returns it.
+func (m *MemoryStore) AddUser(u User) *User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u.ID == 0 {
+		u.ID = m.id()
+	} else if u.ID > m.nextID {
+		m.nextID = u.ID
+	}
+	if u.Role == "" {
+		u.Role = "user"
+	}
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = m.now()
+		u.UpdatedAt = u.CreatedAt
+	}
+	m.users[u.ID] = &u
+	cp := u
+	return &cp
+}
+
+// AddSubscription stores a copy of s (assigning an ID when zero; StartDate defaults to now).
+func (m *MemoryStore) AddSubscription(s ActiveSubscription) *ActiveSubscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addSubscription(s)
+}
+
+func (m *MemoryStore) addSubscription(s ActiveSubscription) *ActiveSubscription {
+	if s.ID == 0 {
+		s.ID = m.id()
+	} else if s.ID > m.nextID {
+		m.nextID = s.ID
+	}
+	if s.StartDate.IsZero() {
+		s.StartDate = m.now()
+	}
+	if s.Status == "" {
+		s.Status = "active"
+	}
+	if s.PlanID == 0 {
+		s.PlanID = s.Plan.ID
+	}
+	m.subs = append(m.subs, &s)
+	cp := s
+	return &cp
+}
+
+// AddCategory names a medical category for the stats.
+func (m *MemoryStore) AddCategory(id int, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.categories[id] = name
+}
+
+func (m *MemoryStore) GetUserByEmail(email string) *User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			cp := *u
+			return &cp
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUserByID(id int) *User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; ok {
+		cp := *u
+		return &cp
+	}
+	return nil
+}
+
+func (m *MemoryStore) EmailExists(email string) (bool, error) {
+	return m.GetUserByEmail(email) != nil, nil
+}
+
+func (m *MemoryStore) CreateUser(firstName, lastName, email, password, role string) error {
+	if exists, _ := m.EmailExists(email); exists {
+		return fmt.Errorf("duplicate email: %s", email)
+	}
+	m.AddUser(User{FirstName: firstName, LastName: lastName, Email: email, Password: password, Role: role})
+	return nil
+}
+
+func (m *MemoryStore) updateUser(id int, fn func(u *User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	fn(u)
+	u.UpdatedAt = m.now()
+	return nil
+}
+
+func (m *MemoryStore) UpdateUserPassword(id int, password string) error {
+	return m.updateUser(id, func(u *User) { u.Password = password })
+}
+
+func (m *MemoryStore) UpdateUserProfile(id int, firstName, lastName, city, profession, gender string, age, countryID *int) error {
+	return m.updateUser(id, func(u *User) { mergeProfile(u, firstName, lastName, city, profession, gender, age, countryID) })
+}
+
+func (m *MemoryStore) UpdateUserProfileImage(id int, path string) error {
+	return m.updateUser(id, func(u *User) { u.ProfileImage = path })
+}
+
+// active mirrors activeSubscriptionWhere: the newest started, not ended, not expired one.
+func (m *MemoryStore) active(userID int) *ActiveSubscription {
+	now := m.now()
+	var found *ActiveSubscription
+	for _, s := range m.subs {
+		if s.UserID != userID || s.Status == "expired" || s.StartDate.After(now) || (s.EndDate != nil && !s.EndDate.After(now)) {
+			continue
+		}
+		if found == nil || s.ID > found.ID {
+			found = s
+		}
+	}
+	return found
+}
+
+func (m *MemoryStore) byID(subscriptionID int) *ActiveSubscription {
+	for _, s := range m.subs {
+		if s.ID == subscriptionID {
+			return s
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) EnsureFreeSubscription(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active(userID) != nil {
+		return nil
+	}
+	now := m.now()
+	periodEnd := now.AddDate(0, 1, 0)
+	m.addSubscription(ActiveSubscription{UserID: userID, StartDate: now, Interval: "month", IntervalCount: 1,
+		CurrentPeriodEnd: &periodEnd, Quotas: m.FreePlan.Quotas, Statistics: m.FreePlan.Statistics, Plan: m.FreePlan})
+	return nil
+}
+
+func (m *MemoryStore) GetActiveSubscription(userID int) (*ActiveSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.active(userID); s != nil {
+		cp := *s
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) ResetActiveSubscriptionQuotas(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.active(userID); s != nil {
+		s.Quotas = s.Plan.Quotas
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetQuota(subscriptionID int, field string, value int) error {
+	if !isQuotaField(field) {
+		return fmt.Errorf("invalid quota field: %s", field)
+	}
+	if value < 0 {
+		value = 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.byID(subscriptionID); s != nil {
+		s.set(field, value)
+	}
+	return nil
+}
+
+func (m *MemoryStore) ConsumeQuota(subscriptionID int, field string, amount int) (bool, error) {
+	if amount <= 0 {
+		return true, nil
+	}
+	if !isQuotaField(field) {
+		return false, fmt.Errorf("invalid quota field: %s", field)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.byID(subscriptionID)
+	if s == nil || s.Get(field) < amount {
+		return false, nil
+	}
+	s.set(field, s.Get(field)-amount)
+	return true, nil
+}
+
+func (m *MemoryStore) RecordTestCompletion(userID int, categoryID *int, testName string, scoreObtained, maxScore int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tests = append(m.tests, memoryTest{
+		TestResult: TestResult{TestID: m.id(), TestName: testName, ScoreObtained: scoreObtained, MaxScore: maxScore, CreatedAt: m.now()},
+		userID:     userID, categoryID: categoryID,
+	})
+	return nil
+}
+
+// userTests returns the tests of a user, newest first.
+func (m *MemoryStore) userTests(userID int) []memoryTest {
+	var out []memoryTest
+	for _, t := range m.tests {
+		if t.userID == userID {
+			out = append(out, t)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+func (m *MemoryStore) GetTestProgress(userID int, limit int) (*TestProgress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var p TestProgress
+	for i, t := range m.userTests(userID) {
+		p.Summary.TotalTests++
+		p.Summary.TotalScore += t.ScoreObtained
+		p.Summary.TotalMaxScore += t.MaxScore
+		if i < limit {
+			r := t.TestResult
+			r.Percentage = percentage(r.ScoreObtained, r.MaxScore)
+			if t.categoryID != nil {
+				r.CategoryName = m.categories[*t.categoryID]
+			}
+			p.RecentTests = append(p.RecentTests, r)
+		}
+	}
+	p.Summary.AveragePercentage = percentage(p.Summary.TotalScore, p.Summary.TotalMaxScore)
+	return &p, nil
+}
+
+func (m *MemoryStore) GetMonthlyScores(userID int) ([]MonthlyScore, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	since := m.now().AddDate(0, -6, 0)
+	var out []MonthlyScore
+	for _, t := range m.userTests(userID) {
+		if t.CreatedAt.Before(since) {
+			continue
+		}
+		month := t.CreatedAt.Format("2006-01")
+		if n := len(out); n > 0 && out[n-1].Month == month {
+			out[n-1].Points += t.ScoreObtained
+			out[n-1].TestsCount++
+			continue
+		}
+		if len(out) == 6 {
+			break
+		}
+		out = append(out, MonthlyScore{Month: month, Points: t.ScoreObtained, TestsCount: 1})
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) GetMostStudiedCategory(userID int) (*StudiedCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[int]int{}
+	for _, t := range m.userTests(userID) {
+		if t.categoryID == nil {
+			continue
+		}
+		if _, ok := m.categories[*t.categoryID]; ok {
+			counts[*t.categoryID]++
+		}
+	}
+	var best *StudiedCategory
+	for id, n := range counts {
+		if best == nil || n > best.StudyCount || (n == best.StudyCount && id < best.CategoryID) {
+			best = &StudiedCategory{CategoryID: id, CategoryName: m.categories[id], StudyCount: n}
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,234 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"ema-backend/conn"
+)
+
+// SQLStore implements UserStore, SubscriptionStore and StatsStore on the database (MySQL or
+// Postgres).
+type SQLStore struct {
+	db      *sql.DB
+	dialect conn.Dialect
+}
+
+var (
+	_ UserStore         = (*SQLStore)(nil)
+	_ SubscriptionStore = (*SQLStore)(nil)
+	_ StatsStore        = (*SQLStore)(nil)
+)
+
+func NewSQLStore(database *sql.DB) *SQLStore {
+	return &SQLStore{db: database, dialect: conn.DialectOf(database)}
+}
+
+const userColumns = "id, first_name, last_name, email, password, role, COALESCE(profile_image,''), COALESCE(city,''), COALESCE(profession,''), COALESCE(gender,''), age, country_id, created_at, updated_at"
+
+func scanUser(row *sql.Row) *User {
+	var u User
+	if err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.Role, &u.ProfileImage, &u.City, &u.Profession, &u.Gender, &u.Age, &u.CountryID, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil
+	}
+	return &u
+}
+
+// GetUserByEmail retrieves a user by email (nil if not found)
+func (s *SQLStore) GetUserByEmail(email string) *User {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ? LIMIT 1", email))
+}
+
+// GetUserByID retrieves a user by its ID (nil if not found)
+func (s *SQLStore) GetUserByID(id int) *User {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ? LIMIT 1", id))
+}
+
+// EmailExists checks if a user with the given email exists
+func (s *SQLStore) EmailExists(email string) (bool, error) {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(1) FROM users WHERE email = ?", email).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateUser inserts a new user record
+func (s *SQLStore) CreateUser(firstName, lastName, email, password, role string) error {
+	_, err := s.db.Exec(
+		"INSERT INTO users (first_name, last_name, email, password, role) VALUES (?, ?, ?, ?, ?)",
+		firstName, lastName, email, password, role,
+	)
+	return err
+}
+
+// UpdateUserPassword updates the password for the given user id
+func (s *SQLStore) UpdateUserPassword(id int, password string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ?, updated_at = NOW() WHERE id = ?", password, id)
+	return err
+}
+
+// UpdateUserProfile updates first/last name and optional city/profession/gender/age/country
+func (s *SQLStore) UpdateUserProfile(id int, firstName, lastName, city, profession, gender string, age, countryID *int) error {
+	cur := s.GetUserByID(id)
+	if cur == nil {
+		return fmt.Errorf("user not found")
+	}
+	mergeProfile(cur, firstName, lastName, city, profession, gender, age, countryID)
+	_, err := s.db.Exec("UPDATE users SET first_name = ?, last_name = ?, city = ?, profession = ?, gender = ?, age = ?, country_id = ?, updated_at = NOW() WHERE id = ?",
+		cur.FirstName, cur.LastName, cur.City, cur.Profession, cur.Gender, cur.Age, cur.CountryID, id)
+	return err
+}
+
+// mergeProfile applies the non-empty profile fields to u.
+func mergeProfile(u *User, firstName, lastName, city, profession, gender string, age, countryID *int) {
+	if firstName != "" {
+		u.FirstName = firstName
+	}
+	if lastName != "" {
+		u.LastName = lastName
+	}
+	if city != "" {
+		u.City = city
+	}
+	if profession != "" {
+		u.Profession = profession
+	}
+	if gender != "" {
+		u.Gender = gender
+	}
+	if age != nil {
+		u.Age = age
+	}
+	if countryID != nil {
+		u.CountryID = countryID
+	}
+}
+
+// UpdateUserProfileImage updates the profile_image path
+func (s *SQLStore) UpdateUserProfileImage(id int, path string) error {
+	_, err := s.db.Exec("UPDATE users SET profile_image = ?, updated_at = NOW() WHERE id = ?", path, id)
+	return err
+}
+
+// activeSubscriptionWhere mirrors subscriptions.Repository: started, not past end_date, not expired.
+const activeSubscriptionWhere = "COALESCE(s.status,'active') <> 'expired' AND s.start_date <= NOW() AND (s.end_date IS NULL OR s.end_date > NOW())"
+
+// EnsureFreeSubscription creates a Free subscription for the user if none is active
+// (new users, or users whose paid subscription expired)
+func (s *SQLStore) EnsureFreeSubscription(userID int) error {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(1) FROM subscriptions s WHERE s.user_id = ? AND "+activeSubscriptionWhere, userID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	// Find Free plan
+	var planID int
+	var q Quotas
+	var versionID sql.NullInt64
+	row := s.db.QueryRow("SELECT id, current_version_id, consultations, questionnaires, clinical_cases, files FROM subscription_plans WHERE name = 'Free' LIMIT 1")
+	switch err := row.Scan(&planID, &versionID, &q.Consultations, &q.Questionnaires, &q.ClinicalCases, &q.Files); err {
+	case nil:
+		// ok
+	case sql.ErrNoRows:
+		// Fallback to any plan (cheapest/first)
+		row2 := s.db.QueryRow("SELECT id, current_version_id, consultations, questionnaires, clinical_cases, files FROM subscription_plans ORDER BY price ASC, id ASC LIMIT 1")
+		if err2 := row2.Scan(&planID, &versionID, &q.Consultations, &q.Questionnaires, &q.ClinicalCases, &q.Files); err2 != nil {
+			return err2
+		}
+	default:
+		return err
+	}
+	// Create subscription initialized with plan quotas
+	now := time.Now()
+	_, err := s.db.Exec(`INSERT INTO subscriptions (user_id, plan_id, plan_version_id, start_date, frequency, billing_interval, interval_count, current_period_end, consultations, questionnaires, clinical_cases, files)
+		VALUES (?,?,?,?, 0, 'month', 1, ?, ?, ?, ?, ?)`, userID, planID, versionID, now, now.AddDate(0, 1, 0), q.Consultations, q.Questionnaires, q.ClinicalCases, q.Files)
+	return err
+}
+
+// GetActiveSubscription returns the active subscription of a user joined with plan;
+// limits and price come from the plan version the subscription is pinned to.
+func (s *SQLStore) GetActiveSubscription(userID int) (*ActiveSubscription, error) {
+	query := `SELECT s.id, s.user_id, s.plan_id, s.start_date, s.end_date, COALESCE(s.status,'active'), s.frequency,
+		COALESCE(s.billing_interval,'month'), COALESCE(s.interval_count,1), s.current_period_end,
+		s.consultations, s.questionnaires, s.clinical_cases, s.files,
+		p.id, p.name, COALESCE(v.currency,p.currency), COALESCE(v.price,p.price), p.billing, COALESCE(v.billing_interval,p.billing_interval,'month'), COALESCE(v.interval_count,p.billing_interval_count,1),
+		COALESCE(v.consultations,p.consultations), COALESCE(v.questionnaires,p.questionnaires), COALESCE(v.clinical_cases,p.clinical_cases), COALESCE(v.files,p.files),
+		COALESCE(` + s.dialect.JSONInt("COALESCE(v.entitlements,p.entitlements)", "statistics") + `, v.statistics, CASE WHEN p.price>0 THEN 1 ELSE 0 END) AS statistics
+		FROM subscriptions s JOIN subscription_plans p ON s.plan_id = p.id LEFT JOIN subscription_plan_versions v ON v.id = s.plan_version_id
+		WHERE s.user_id = ? AND ` + activeSubscriptionWhere + ` ORDER BY s.id DESC LIMIT 1`
+	var sub ActiveSubscription
+	var end, periodEnd sql.NullTime
+	p := &sub.Plan
+	if err := s.db.QueryRow(query, userID).Scan(&sub.ID, &sub.UserID, &sub.PlanID, &sub.StartDate, &end, &sub.Status, &sub.Frequency, &sub.Interval, &sub.IntervalCount, &periodEnd,
+		&sub.Consultations, &sub.Questionnaires, &sub.ClinicalCases, &sub.Files,
+		&p.ID, &p.Name, &p.Currency, &p.Price, &p.Billing, &p.Interval, &p.IntervalCount, &p.Consultations, &p.Questionnaires, &p.ClinicalCases, &p.Files, &sub.Statistics); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p.Statistics = sub.Statistics
+	if end.Valid {
+		sub.EndDate = &end.Time
+	}
+	if periodEnd.Valid {
+		sub.CurrentPeriodEnd = &periodEnd.Time
+	}
+	return &sub, nil
+}
+
+// ResetActiveSubscriptionQuotas resets the active subscription quotas to the limits of its plan version.
+func (s *SQLStore) ResetActiveSubscriptionQuotas(userID int) error {
+	row := s.db.QueryRow(`SELECT s.id, s.plan_id, COALESCE(v.consultations,p.consultations), COALESCE(v.questionnaires,p.questionnaires), COALESCE(v.clinical_cases,p.clinical_cases), COALESCE(v.files,p.files)
+		FROM subscriptions s JOIN subscription_plans p ON p.id = s.plan_id LEFT JOIN subscription_plan_versions v ON v.id = s.plan_version_id
+		WHERE s.user_id=? AND `+activeSubscriptionWhere+` ORDER BY s.id DESC LIMIT 1`, userID)
+	var subID, planID, c1, c2, c3, c4 int
+	if err := row.Scan(&subID, &planID, &c1, &c2, &c3, &c4); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if _, err := s.db.Exec(`UPDATE subscriptions SET consultations=?, questionnaires=?, clinical_cases=?, files=? WHERE id=?`, c1, c2, c3, c4, subID); err != nil {
+		return err
+	}
+	log.Printf("[QUOTA][AUTO-RESET] user_id=%d sub_id=%d plan_id=%d consultations=%d questionnaires=%d clinical_cases=%d files=%d", userID, subID, planID, c1, c2, c3, c4)
+	return nil
+}
+
+// SetQuota sets a quota field to an exact value (negative values are stored as 0).
+func (s *SQLStore) SetQuota(subscriptionID int, field string, value int) error {
+	if !isQuotaField(field) {
+		return fmt.Errorf("invalid quota field: %s", field)
+	}
+	if value < 0 {
+		value = 0
+	}
+	_, err := s.db.Exec("UPDATE subscriptions SET "+field+"=? WHERE id=?", value, subscriptionID)
+	return err
+}
+
+// ConsumeQuota atomically decrements a quota field by amount if enough is left.
+func (s *SQLStore) ConsumeQuota(subscriptionID int, field string, amount int) (bool, error) {
+	if amount <= 0 {
+		return true, nil
+	}
+	if !isQuotaField(field) {
+		return false, fmt.Errorf("invalid quota field: %s", field)
+	}
+	// Conditional update ensures atomic check & decrement
+	res, err := s.db.Exec("UPDATE subscriptions SET "+field+"="+field+"-? WHERE id=? AND "+field+">= ?", amount, subscriptionID, amount)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
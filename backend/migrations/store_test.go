@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreSubscriptions(t *testing.T) {
+	m := NewMemoryStore()
+	past := time.Now().Add(-time.Hour)
+	m.AddSubscription(ActiveSubscription{UserID: 1, Plan: ActivePlan{ID: 2}, EndDate: &past})
+	m.AddSubscription(ActiveSubscription{UserID: 1, Plan: ActivePlan{ID: 3}, Status: "expired"})
+	if s, _ := m.GetActiveSubscription(1); s != nil {
+		t.Fatalf("ended/expired subscriptions are not active: %+v", s)
+	}
+
+	m.FreePlan.Quotas = Quotas{Consultations: 2, Files: 1}
+	if err := m.EnsureFreeSubscription(1); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.EnsureFreeSubscription(1)
+	s, _ := m.GetActiveSubscription(1)
+	if s == nil || s.Plan.Name != "Free" || s.Consultations != 2 || len(m.subs) != 3 {
+		t.Fatalf("free subscription = %+v (%d subs)", s, len(m.subs))
+	}
+
+	if ok, _ := m.ConsumeQuota(s.ID, "consultations", 2); !ok {
+		t.Fatal("consume 2 of 2")
+	}
+	if ok, _ := m.ConsumeQuota(s.ID, "consultations", 1); ok {
+		t.Fatal("consume past zero")
+	}
+	if _, err := m.ConsumeQuota(s.ID, "password", 1); err == nil {
+		t.Fatal("unknown field must fail")
+	}
+	if err := m.ResetActiveSubscriptionQuotas(1); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ = m.GetActiveSubscription(1); s.Consultations != 2 || s.Used("consultations") != 0 {
+		t.Fatalf("after reset = %+v", s.Quotas)
+	}
+}
@@ -0,0 +1,223 @@
+package openai
+
+// Calculadoras clínicas expuestas al modelo con la tool clinical_calculator. Se calculan aquí
+// para que las cifras no dependan de la aritmética del modelo.
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CalculatorInput son los argumentos de clinical_calculator; cada calculadora usa solo algunos.
+type CalculatorInput struct {
+	Calculadora string  `json:"calculadora"`
+	PesoKg      float64 `json:"peso_kg"`
+	TallaCm     float64 `json:"talla_cm"`
+	Edad        float64 `json:"edad"`
+	Sexo        string  `json:"sexo"` // "M" o "F"
+	Creatinina  float64 `json:"creatinina_mg_dl"`
+	PAS         float64 `json:"pas_mmhg"`
+	PAD         float64 `json:"pad_mmhg"`
+	Sodio       float64 `json:"sodio_meq_l"`
+	Cloro       float64 `json:"cloro_meq_l"`
+	Bicarbonato float64 `json:"bicarbonato_meq_l"`
+	Calcio      float64 `json:"calcio_mg_dl"`
+	Albumina    float64 `json:"albumina_g_dl"`
+}
+
+// CalculatorResult es lo que recibe el modelo.
+type CalculatorResult struct {
+	Calculadora    string  `json:"calculadora"`
+	Valor          float64 `json:"valor"`
+	Unidad         string  `json:"unidad"`
+	Interpretacion string  `json:"interpretacion,omitempty"`
+	Formula        string  `json:"formula"`
+}
+
+type calculator struct {
+	name     string
+	requires []string // campos JSON obligatorios (> 0)
+	unit     string
+	formula  string
+	compute  func(in CalculatorInput) (float64, string)
+}
+
+var calculators = []calculator{
+	{
+		name: "imc", requires: []string{"peso_kg", "talla_cm"}, unit: "kg/m²",
+		formula: "peso / talla²",
+		compute: func(in CalculatorInput) (float64, string) {
+			m := in.TallaCm / 100
+			v := in.PesoKg / (m * m)
+			switch {
+			case v < 18.5:
+				return v, "bajo peso"
+			case v < 25:
+				return v, "normal"
+			case v < 30:
+				return v, "sobrepeso"
+			case v < 35:
+				return v, "obesidad grado I"
+			case v < 40:
+				return v, "obesidad grado II"
+			}
+			return v, "obesidad grado III"
+		},
+	},
+	{
+		name: "superficie_corporal", requires: []string{"peso_kg", "talla_cm"}, unit: "m²",
+		formula: "Mosteller: √(talla_cm × peso / 3600)",
+		compute: func(in CalculatorInput) (float64, string) {
+			return math.Sqrt(in.TallaCm * in.PesoKg / 3600), ""
+		},
+	},
+	{
+		name: "cockcroft_gault", requires: []string{"edad", "peso_kg", "creatinina_mg_dl", "sexo"}, unit: "mL/min",
+		formula: "(140 − edad) × peso / (72 × creatinina) × 0,85 si mujer",
+		compute: func(in CalculatorInput) (float64, string) {
+			v := (140 - in.Edad) * in.PesoKg / (72 * in.Creatinina)
+			if isFemale(in.Sexo) {
+				v *= 0.85
+			}
+			switch {
+			case v >= 90:
+				return v, "aclaramiento normal o alto"
+			case v >= 60:
+				return v, "disminución leve"
+			case v >= 30:
+				return v, "disminución moderada"
+			case v >= 15:
+				return v, "disminución grave"
+			}
+			return v, "falla renal"
+		},
+	},
+	{
+		name: "presion_arterial_media", requires: []string{"pas_mmhg", "pad_mmhg"}, unit: "mmHg",
+		formula: "(PAS + 2 × PAD) / 3",
+		compute: func(in CalculatorInput) (float64, string) {
+			v := (in.PAS + 2*in.PAD) / 3
+			if v < 65 {
+				return v, "menor a 65 mmHg: riesgo de hipoperfusión"
+			}
+			return v, ""
+		},
+	},
+	{
+		name: "anion_gap", requires: []string{"sodio_meq_l", "cloro_meq_l", "bicarbonato_meq_l"}, unit: "mEq/L",
+		formula: "Na − (Cl + HCO₃)",
+		compute: func(in CalculatorInput) (float64, string) {
+			v := in.Sodio - (in.Cloro + in.Bicarbonato)
+			switch {
+			case v > 12:
+				return v, "elevado (referencia 8-12)"
+			case v < 8:
+				return v, "bajo (referencia 8-12)"
+			}
+			return v, "normal (referencia 8-12)"
+		},
+	},
+	{
+		name: "calcio_corregido", requires: []string{"calcio_mg_dl", "albumina_g_dl"}, unit: "mg/dL",
+		formula: "calcio + 0,8 × (4 − albúmina)",
+		compute: func(in CalculatorInput) (float64, string) {
+			return in.Calcio + 0.8*(4-in.Albumina), ""
+		},
+	},
+}
+
+func isFemale(sexo string) bool {
+	s := strings.ToLower(strings.TrimSpace(sexo))
+	return s == "f" || strings.HasPrefix(s, "fem") || s == "mujer"
+}
+
+// field devuelve si el campo JSON name tiene valor.
+func (in CalculatorInput) field(name string) bool {
+	switch name {
+	case "sexo":
+		return strings.TrimSpace(in.Sexo) != ""
+	case "peso_kg":
+		return in.PesoKg > 0
+	case "talla_cm":
+		return in.TallaCm > 0
+	case "edad":
+		return in.Edad > 0
+	case "creatinina_mg_dl":
+		return in.Creatinina > 0
+	case "pas_mmhg":
+		return in.PAS > 0
+	case "pad_mmhg":
+		return in.PAD > 0
+	case "sodio_meq_l":
+		return in.Sodio > 0
+	case "cloro_meq_l":
+		return in.Cloro > 0
+	case "bicarbonato_meq_l":
+		return in.Bicarbonato > 0
+	case "calcio_mg_dl":
+		return in.Calcio > 0
+	case "albumina_g_dl":
+		return in.Albumina > 0
+	}
+	return false
+}
+
+// Calculate evalúa la calculadora indicada en in.
+func Calculate(in CalculatorInput) (CalculatorResult, error) {
+	name := strings.ToLower(strings.TrimSpace(in.Calculadora))
+	for _, calc := range calculators {
+		if calc.name != name {
+			continue
+		}
+		var missing []string
+		for _, f := range calc.requires {
+			if !in.field(f) {
+				missing = append(missing, f)
+			}
+		}
+		if len(missing) > 0 {
+			return CalculatorResult{}, fmt.Errorf("%s: faltan valores: %s", name, strings.Join(missing, ", "))
+		}
+		v, interp := calc.compute(in)
+		return CalculatorResult{
+			Calculadora:    name,
+			Valor:          math.Round(v*10) / 10,
+			Unidad:         calc.unit,
+			Interpretacion: interp,
+			Formula:        calc.formula,
+		}, nil
+	}
+	return CalculatorResult{}, fmt.Errorf("calculadora desconocida %q (disponibles: %s)", in.Calculadora, strings.Join(calculatorNames(), ", "))
+}
+
+func calculatorNames() []string {
+	names := make([]string, len(calculators))
+	for i, calc := range calculators {
+		names[i] = calc.name
+	}
+	return names
+}
+
+func calculatorSchema() map[string]any {
+	number := func(desc string) map[string]any { return map[string]any{"type": "number", "description": desc} }
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"calculadora"},
+		"properties": map[string]any{
+			"calculadora":       map[string]any{"type": "string", "enum": calculatorNames()},
+			"peso_kg":           number("Peso en kg"),
+			"talla_cm":          number("Talla en cm"),
+			"edad":              number("Edad en años"),
+			"sexo":              map[string]any{"type": "string", "enum": []string{"M", "F"}},
+			"creatinina_mg_dl":  number("Creatinina sérica en mg/dL"),
+			"pas_mmhg":          number("Presión arterial sistólica en mmHg"),
+			"pad_mmhg":          number("Presión arterial diastólica en mmHg"),
+			"sodio_meq_l":       number("Sodio sérico en mEq/L"),
+			"cloro_meq_l":       number("Cloro sérico en mEq/L"),
+			"bicarbonato_meq_l": number("Bicarbonato sérico en mEq/L"),
+			"calcio_mg_dl":      number("Calcio sérico total en mg/dL"),
+			"albumina_g_dl":     number("Albúmina sérica en g/dL"),
+		},
+	}
+}
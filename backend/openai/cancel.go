@@ -0,0 +1,154 @@
+package openai
+
+// Cancelación de runs (Assistants) y responses (Responses API) en curso: cuando el cliente se
+// desconecta o pide detener la respuesta (POST /conversations/cancel) se corta el stream local y
+// se avisa a OpenAI para que deje de generar (y de consumir tokens).
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// runTimeout acota un run/response desacoplado del request (file_search sobre vectores grandes
+// puede tardar varios minutos).
+const runTimeout = 240 * time.Second
+
+// activeRun es un run o response en curso de un thread/conversation.
+type activeRun struct {
+	cancel     context.CancelFunc
+	runID      string // Assistants: run_...
+	responseID string // Responses API: resp_...
+}
+
+type clientCtxKey struct{}
+
+// WithClientContext marca ctx (el del request HTTP) como el que termina cuando el cliente se
+// desconecta. Los handlers suelen derivar contextos con su propio deadline; una vez vencido, la
+// desconexión posterior ya no se ve a través de ellos, así que startRun vigila también éste.
+func WithClientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, clientCtxKey{}, ctx)
+}
+
+// startRun deriva el contexto de un run: no hereda el deadline del handler (el run puede durar
+// más que el presupuesto del request) pero sí se cancela si el cliente se desconecta (el
+// contexto del request termina con context.Canceled) o si se llama CancelRun para el thread.
+// done libera el registro y debe llamarse al terminar.
+func (c *Client) startRun(parent context.Context, threadID string, timeout time.Duration) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), timeout)
+	watch := []context.Context{parent}
+	if client, ok := parent.Value(clientCtxKey{}).(context.Context); ok {
+		watch = append(watch, client)
+	}
+	stops := make([]func() bool, 0, len(watch))
+	for _, w := range watch {
+		stops = append(stops, context.AfterFunc(w, func() {
+			if errors.Is(w.Err(), context.Canceled) {
+				log.Printf("[run][client_gone] thread=%s - cancelando run", threadID)
+				cancel()
+			}
+		}))
+	}
+	run := &activeRun{cancel: cancel}
+	c.runMu.Lock()
+	if c.runs == nil {
+		c.runs = map[string]*activeRun{}
+	}
+	c.runs[threadID] = run
+	c.runMu.Unlock()
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel()
+		c.runMu.Lock()
+		if c.runs[threadID] == run {
+			delete(c.runs, threadID)
+		}
+		c.runMu.Unlock()
+	}
+}
+
+// noteRun guarda el ID remoto del run/response en curso para poder cancelarlo.
+func (c *Client) noteRun(threadID, runID, responseID string) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if run := c.runs[threadID]; run != nil {
+		if runID != "" {
+			run.runID = runID
+		}
+		if responseID != "" {
+			run.responseID = responseID
+		}
+	}
+}
+
+// CancelRun detiene la respuesta en curso del thread/conversation. Devuelve false si no había
+// nada que cancelar.
+func (c *Client) CancelRun(ctx context.Context, threadID string) (bool, error) {
+	if c.mock != nil { // las respuestas simuladas son instantáneas
+		return false, nil
+	}
+	c.runMu.Lock()
+	run := c.runs[threadID]
+	c.runMu.Unlock()
+	if run != nil {
+		// El stream detecta la cancelación y avisa a OpenAI (cancelRemoteRun/cancelRemoteResponse)
+		log.Printf("[run][cancel] thread=%s run=%s response=%s", threadID, run.runID, run.responseID)
+		run.cancel()
+		return true, nil
+	}
+	// Sin run local (otra instancia, o runs con polling): cancelar el run activo del thread
+	if !strings.HasPrefix(threadID, "thread_") {
+		return false, nil
+	}
+	runID, _, err := c.checkActiveRun(ctx, threadID)
+	if err != nil || runID == "" {
+		return false, err
+	}
+	log.Printf("[run][cancel] thread=%s run=%s (remote)", threadID, runID)
+	return true, c.cancelRemoteRun(threadID, runID)
+}
+
+// cancelRemoteRun pide a OpenAI cancelar un run de Assistants. Usa un contexto propio porque el
+// del run normalmente ya está cancelado.
+func (c *Client) cancelRemoteRun(threadID, runID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := c.doJSON(ctx, http.MethodPost, "/threads/"+threadID+"/runs/"+runID+"/cancel", nil)
+	return cancelResult("run "+runID, resp, err)
+}
+
+// cancelRemoteResponse pide a OpenAI cancelar un response de la Responses API.
+func (c *Client) cancelRemoteResponse(responseID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := c.doJSONResponses(ctx, http.MethodPost, "/responses/"+responseID+"/cancel", nil)
+	return cancelResult("response "+responseID, resp, err)
+}
+
+// cancelResult trata 400/404/409 (ya terminado o inexistente) como éxito: no queda nada generando.
+func cancelResult(what string, resp *http.Response, err error) error {
+	if err != nil {
+		log.Printf("[run][cancel][error] %s err=%v", what, err)
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		log.Printf("[run][cancel][ok] %s", what)
+		return nil
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusConflict, resp.StatusCode == http.StatusBadRequest:
+		log.Printf("[run][cancel][skip] %s status=%d (ya terminado)", what, resp.StatusCode)
+		return nil
+	}
+	b, _ := io.ReadAll(resp.Body)
+	err = fmt.Errorf("cancel %s failed: status=%d body=%s", what, resp.StatusCode, sanitizeBody(string(b)))
+	log.Printf("[run][cancel][error] %v", err)
+	return err
+}
@@ -25,12 +25,15 @@ import (
 	"time"
 	"unicode"
 
-	openai "github.com/sashabaranov/go-openai"
+	"ema-backend/knowledge"
+	"ema-backend/llm"
+
 	"rsc.io/pdf"
 )
 
 type Client struct {
-	api         *openai.Client
+	// llm serves the plain model calls (chat completions, transcription, translation) per flow
+	llm         *llm.Registry
 	AssistantID string
 	Model       string
 	key         string
@@ -54,6 +57,13 @@ type Client struct {
 	convMu          sync.RWMutex
 	conversations   map[string]string // thread_id -> conversation_id (usually same)
 	useResponsesAPI bool              // Feature flag: true = use Responses API, false = use Assistants API
+	// mock answers every call locally (AI_PROVIDER=mock, see mock.go)
+	mock *mockState
+	// runs/responses en curso por thread, cancelables (see cancel.go)
+	runMu sync.Mutex
+	runs  map[string]*activeRun
+	// tools que el modelo puede invocar en la Responses API (see tools.go)
+	tools toolRegistry
 	// Ensure *Client implements the chat.AIClient interface (compile-time check) via a blank identifier assignment.
 	// (We inline the minimal subset because importing chat here would create a cycle; so we skip direct assertion.)
 }
@@ -96,12 +106,17 @@ func sanitizeEnv(v string) string {
 }
 
 func NewClient() *Client {
+	if mockEnabled() {
+		log.Printf("[openai][mock] AI_PROVIDER=mock: OpenAI and PubMed calls are simulated offline")
+		return NewMockClient()
+	}
 	key := sanitizeEnv(os.Getenv("OPENAI_API_KEY"))
 	assistant := sanitizeEnv(os.Getenv("CHAT_PRINCIPAL_ASSISTANT"))
 	model := sanitizeEnv(os.Getenv("CHAT_MODEL"))
-	var api *openai.Client
-	if key != "" {
-		api = openai.NewClient(key)
+	providers, err := llm.FromEnv()
+	if err != nil {
+		log.Printf("[openai][llm][config.error] %v; using OpenAI for every flow", err)
+		providers = llm.NewRegistry(llm.NewOpenAI(llm.Config{APIKey: key, ChatModel: model}))
 	}
 	vsMap, _ := loadVectorStoreFile()
 	fcMap, _ := loadFileCache()
@@ -113,12 +128,12 @@ func NewClient() *Client {
 	}
 	// Use Responses API by default (new implementation)
 	useResponsesAPI := true
-	return &Client{
-		api:             api,
+	c := &Client{
+		llm:             providers,
 		AssistantID:     assistant,
 		Model:           model,
 		key:             key,
-		httpClient:      &http.Client{Timeout: 180 * time.Second}, // Aumentado a 180s para runs largos y addMessage lentos
+		httpClient:      &http.Client{Transport: upstreamTransport()}, // reintentos + circuit breaker (transport.go)
 		vectorStore:     vsMap,
 		vsLastAccess:    make(map[string]time.Time),
 		fileCache:       fcMap,
@@ -129,161 +144,103 @@ func NewClient() *Client {
 		conversations:   make(map[string]string),
 		useResponsesAPI: useResponsesAPI,
 	}
+	c.registerBuiltinTools()
+	return c
 }
 
+// StreamMessage answers a single prompt. On OpenAI it prefers a one-off Assistants thread when
+// an assistant is configured and uses chat completions otherwise; other providers of the chat
+// flow (llm.FlowChat) always use chat completions.
 func (c *Client) StreamMessage(ctx context.Context, prompt string) (<-chan string, error) {
-	// Prefer Assistants API if an AssistantID is configured
-	if c.key != "" && c.AssistantID != "" && len(c.AssistantID) >= 5 && c.AssistantID[:5] == "asst_" {
-		// Create a transient thread then run once and emit a single chunk
-		threadID, err := c.CreateThread(ctx)
-		if err == nil && threadID != "" {
-			return c.StreamAssistantMessage(ctx, threadID, prompt)
-		}
-		// If thread creation fails, fall back to chat completions below
-	}
-	// If API or model is not configured, emit a minimal placeholder stream to avoid server 500s
-	if c.api == nil || c.AssistantID == "" {
-		log.Printf("[openai][StreamMessage] missing_config api_nil=%v assistant_id=%s", c.api == nil, c.AssistantID)
-		ch := make(chan string, 1)
-		go func() {
-			defer close(ch)
-			ch <- ""
-		}()
-		return ch, nil
-	}
-	// Resolve model to use: prefer CHAT_MODEL; if AssistantID looks like an assistant, fallback to a sane default
-	model := c.Model
-	if model == "" {
-		if len(c.AssistantID) >= 5 && c.AssistantID[:5] == "asst_" {
-			// Assistant IDs are not models; default to gpt-4-turbo (mejor contexto conversacional)
-			// gpt-4-turbo tiene ventana de contexto de 128k tokens vs 128k de gpt-4o
-			// pero gpt-4-turbo es más estable para conversaciones largas con mejor seguimiento de tema
-			model = "gpt-4-turbo"
-		} else {
-			// If AssistantID is actually a model name, allow using it directly
-			model = c.AssistantID
+	p := c.LLM(llm.FlowChat)
+	req := llm.Prompt("", prompt)
+	fallbackModel := ""
+	if p.Name() == llm.ProviderOpenAI {
+		// Prefer Assistants API if an AssistantID is configured
+		if c.key != "" && c.AssistantID != "" && len(c.AssistantID) >= 5 && c.AssistantID[:5] == "asst_" {
+			// Create a transient thread then run once and emit a single chunk
+			threadID, err := c.CreateThread(ctx)
+			if err == nil && threadID != "" {
+				return c.StreamAssistantMessage(ctx, threadID, prompt)
+			}
+			// If thread creation fails, fall back to chat completions below
+		}
+		// If API or model is not configured, emit a minimal placeholder stream to avoid server 500s
+		if c.key == "" || c.AssistantID == "" {
+			log.Printf("[openai][StreamMessage] missing_config key_empty=%v assistant_id=%s", c.key == "", c.AssistantID)
+			ch := make(chan string, 1)
+			go func() {
+				defer close(ch)
+				ch <- ""
+			}()
+			return ch, nil
+		}
+		// Resolve model to use: prefer CHAT_MODEL; if AssistantID looks like an assistant, fallback to a sane default
+		req.Model = c.Model
+		if req.Model == "" {
+			if len(c.AssistantID) >= 5 && c.AssistantID[:5] == "asst_" {
+				// Assistant IDs are not models; default to gpt-4-turbo (mejor contexto conversacional)
+				// gpt-4-turbo tiene ventana de contexto de 128k tokens vs 128k de gpt-4o
+				// pero gpt-4-turbo es más estable para conversaciones largas con mejor seguimiento de tema
+				req.Model = "gpt-4-turbo"
+			} else {
+				// If AssistantID is actually a model name, allow using it directly
+				req.Model = c.AssistantID
+			}
+		}
+		fallbackModel = c.Model
+		if fallbackModel == "" || (len(c.AssistantID) >= 5 && c.AssistantID[:5] == "asst_") {
+			fallbackModel = "gpt-4-turbo"
 		}
 	}
 
-	stream, err := c.api.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
-		Model: model,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: prompt},
-		},
-	})
+	stream, err := p.Stream(ctx, req)
 	if err != nil {
-		// Some models (e.g., gpt-4.1) may not support ChatCompletionStream. Fallback to non-stream.
-		log.Printf("[openai][stream.init.error] %v", err)
-		fbModel := c.Model
-		if fbModel == "" || (len(c.AssistantID) >= 5 && c.AssistantID[:5] == "asst_") {
-			fbModel = "gpt-4o"
-		}
-		resp, err2 := c.api.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-			Model: fbModel,
-			Messages: []openai.ChatCompletionMessage{
-				{Role: openai.ChatMessageRoleUser, Content: prompt},
-			},
-		})
-		if err2 != nil {
-			log.Printf("[openai][fallback.error] %v", err2)
-			return nil, err
-		}
-		out := make(chan string, 1)
-		go func() {
-			defer close(out)
-			if len(resp.Choices) > 0 {
-				msg := resp.Choices[0].Message.Content
-				if msg != "" {
-					log.Printf("[openai][fallback.msg] len=%d", len(msg))
-					out <- msg
-				}
-			}
-		}()
-		return out, nil
+		log.Printf("[openai][StreamMessage][%s] stream.init.error %v", p.Name(), err)
+		return nil, err
 	}
-
 	ch := make(chan string)
-
 	go func() {
-		defer stream.Close()
 		defer close(ch)
 		anyToken := false
-		for {
-			resp, err := stream.Recv()
-			if err != nil {
-				if err.Error() != "EOF" {
-					log.Printf("[openai][stream.end] err=%v", err)
-				}
-				break
-			}
-			if len(resp.Choices) == 0 {
-				log.Printf("[openai][stream.warn] empty choices in delta")
-				continue
-			}
-			token := resp.Choices[0].Delta.Content
-			if token != "" {
-				anyToken = true
-				log.Printf("[openai][stream.token] %s", token)
-				ch <- token
-			}
+		for tok := range stream {
+			anyToken = true
+			ch <- tok
 		}
-
 		// Fallback: si no llegaron tokens, intentamos una completion no-stream
 		if !anyToken {
-			fbModel := c.Model
-			if fbModel == "" || (len(c.AssistantID) >= 5 && c.AssistantID[:5] == "asst_") {
-				fbModel = "gpt-4-turbo"
-			}
-			resp, err := c.api.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-				Model: fbModel,
-				Messages: []openai.ChatCompletionMessage{
-					{Role: openai.ChatMessageRoleUser, Content: prompt},
-				},
-			})
+			req.Model = fallbackModel
+			msg, err := p.Complete(ctx, req)
 			if err != nil {
 				log.Printf("[openai][fallback.error] %v", err)
 				return
 			}
-			if len(resp.Choices) > 0 {
-				msg := resp.Choices[0].Message.Content
-				if msg != "" {
-					log.Printf("[openai][fallback.msg] len=%d", len(msg))
-					ch <- msg
-				}
+			if msg != "" {
+				log.Printf("[openai][fallback.msg] len=%d", len(msg))
+				ch <- msg
 			}
 		}
 	}()
-
 	return ch, nil
 }
 
-// TranscribeFile uses OpenAI's audio transcription APIs to convert an audio file into text.
-// It tries gpt-4o-mini-transcribe first and falls back to whisper-1 for compatibility.
+// TranscribeFile converts an audio file into text with the provider of llm.FlowTranscription
+// (on OpenAI, gpt-4o-mini-transcribe with whisper-1 as fallback).
 func (c *Client) TranscribeFile(ctx context.Context, filePath string) (string, error) {
-	if c.api == nil {
-		return "", errors.New("openai api not configured")
-	}
-	// Try modern transcribe model first
-	req := openai.AudioRequest{
-		Model:    "gpt-4o-mini-transcribe",
-		FilePath: filePath,
-	}
-	resp, err := c.api.CreateTranscription(ctx, req)
-	if err == nil {
-		return resp.Text, nil
-	}
-	// Fallback to whisper-1
-	reqFallback := openai.AudioRequest{
-		Model:    "whisper-1",
-		FilePath: filePath,
-	}
-	resp2, err2 := c.api.CreateTranscription(ctx, reqFallback)
-	if err2 != nil {
-		return "", err
+	return c.LLM(llm.FlowTranscription).Transcribe(ctx, filePath)
+}
+
+// LLM returns the model provider configured for flow (see llm.FromEnv).
+func (c *Client) LLM(flow string) llm.Provider {
+	if c.llm == nil {
+		return llm.NewOpenAI(llm.Config{APIKey: c.key, ChatModel: c.Model})
 	}
-	return resp2.Text, nil
+	return c.llm.For(flow)
 }
 
+// SetLLM replaces the providers read from the environment.
+func (c *Client) SetLLM(r *llm.Registry) { c.llm = r }
+
 // --- Assistants API helpers (HTTP) --- //
 
 func (c *Client) apiURL(path string) string {
@@ -403,6 +360,9 @@ func (c *Client) ensureVectorStore(ctx context.Context, threadID string) (string
 
 // EnsureVectorStore is an exported wrapper for handlers or tests
 func (c *Client) EnsureVectorStore(ctx context.Context, threadID string) (string, error) {
+	if c.mock != nil {
+		return c.mockEnsureVectorStore(threadID), nil
+	}
 	return c.ensureVectorStore(ctx, threadID)
 }
 
@@ -423,6 +383,9 @@ func (c *Client) addFileToVectorStore(ctx context.Context, vsID, fileID string)
 
 // AddFileToVectorStore exported wrapper
 func (c *Client) AddFileToVectorStore(ctx context.Context, vsID, fileID string) error {
+	if c.mock != nil {
+		return c.mockAddFile(vsID, fileID)
+	}
 	fmt.Printf("DEBUG: Adding file %s to vector store %s\n", fileID, vsID)
 
 	if c.key == "" {
@@ -488,6 +451,9 @@ func (c *Client) removeFileFromVectorStore(ctx context.Context, vsID, fileID str
 // This is critical to prevent mixing PDFs from different uploads.
 // Also invalidates the file cache for the thread to force fresh uploads.
 func (c *Client) ClearVectorStoreFiles(ctx context.Context, vsID string) error {
+	if c.mock != nil {
+		return nil
+	}
 	if vsID == "" {
 		return fmt.Errorf("vsID is empty")
 	}
@@ -604,6 +570,9 @@ func (c *Client) pollFileProcessed(ctx context.Context, fileID string, timeout t
 
 // PollFileProcessed exported wrapper
 func (c *Client) PollFileProcessed(ctx context.Context, fileID string, timeout time.Duration) error {
+	if c.mock != nil {
+		return nil
+	}
 	return c.pollFileProcessed(ctx, fileID, timeout)
 }
 
@@ -687,65 +656,17 @@ func (c *Client) pollVectorStoreFileIndexed(ctx context.Context, vsID, fileID st
 
 // PollVectorStoreFileIndexed exported wrapper
 func (c *Client) PollVectorStoreFileIndexed(ctx context.Context, vsID, fileID string, timeout time.Duration) error {
-	return c.pollVectorStoreFileIndexed(ctx, vsID, fileID, timeout)
-}
-
-// emitMarkdownChunks envía el texto dividido en chunks pequeños (~100 chars)
-// para simular streaming SSE, permitiendo que normalizeMarkdownToken() añada
-// saltos de línea correctamente entre headers y contenido.
-// ESTRATEGIA: Headers se envían JUNTO con su párrafo siguiente para que
-// normalizeMarkdownToken() pueda detectar y añadir \n\n si falta.
-func emitMarkdownChunks(out chan<- string, text string) {
-	if text == "" {
-		return
-	}
-
-	lines := strings.Split(text, "\n")
-	i := 0
-
-	for i < len(lines) {
-		line := lines[i]
-
-		// Si es un header markdown (## Título), acumular con líneas siguientes
-		if strings.HasPrefix(strings.TrimSpace(line), "#") {
-			chunk := line
-			i++
-
-			// Añadir líneas siguientes hasta completar ~200 chars o encontrar otro header
-			for i < len(lines) {
-				nextLine := lines[i]
-				// Si encontramos otro header, detener
-				if strings.HasPrefix(strings.TrimSpace(nextLine), "#") {
-					break
-				}
-				chunk += "\n" + nextLine
-				i++
-				// Limitar chunk a ~200 chars
-				if len(chunk) > 200 {
-					break
-				}
-			}
-
-			out <- chunk
-		} else {
-			// Línea normal: acumular hasta ~150 chars
-			chunk := line
-			i++
-			for i < len(lines) && len(chunk) < 150 {
-				nextLine := lines[i]
-				if strings.HasPrefix(strings.TrimSpace(nextLine), "#") {
-					break
-				}
-				chunk += "\n" + nextLine
-				i++
-			}
-			out <- chunk
-		}
+	if c.mock != nil {
+		return nil
 	}
+	return c.pollVectorStoreFileIndexed(ctx, vsID, fileID, timeout)
 }
 
 // UploadAssistantFile uploads a file with purpose=assistants; caches per-thread by sha256
 func (c *Client) UploadAssistantFile(ctx context.Context, threadID, filePath string) (string, error) {
+	if c.mock != nil {
+		return c.mockUploadFile(threadID, filePath)
+	}
 	if c.key == "" {
 		return "", fmt.Errorf("OpenAI API key not set")
 	}
@@ -1021,6 +942,9 @@ func min(a, b int) int {
 // Max size: 20MB (OpenAI limit for vision)
 // Returns the file_id that can be used in messages with image_file content type
 func (c *Client) UploadImageFile(ctx context.Context, imagePath string) (string, error) {
+	if c.mock != nil {
+		return c.mockUploadFile("", imagePath)
+	}
 	if c.key == "" {
 		return "", fmt.Errorf("OpenAI API key not set")
 	}
@@ -1199,6 +1123,9 @@ func (c *Client) doMultipart(ctx context.Context, method, path string, form map[
 
 // CreateThread creates a new Assistants thread and returns the id.
 func (c *Client) CreateThread(ctx context.Context) (string, error) {
+	if c.mock != nil {
+		return c.mock.newID("thread"), nil
+	}
 	if c.key == "" {
 		log.Printf("[openai][CreateThread][error] missing_api_key")
 		return "", fmt.Errorf("openai api key not configured")
@@ -1515,31 +1442,13 @@ func (c *Client) runAndWait(ctx context.Context, threadID string, instructions s
 	return c.runAndWaitWithRetry(ctx, threadID, instructions, vectorStoreID, 0)
 }
 
-// runAndWaitWithRetry es la implementación interna con contador de reintentos
-func (c *Client) runAndWaitWithRetry(ctx context.Context, threadID string, instructions string, vectorStoreID string, retryCount int) (string, error) {
-	const maxRetries = 2 // 2 reintentos = 3 intentos totales (inicial + 2 retries)
-
-	// Máxima duración interna antes de intentar devolver contenido parcial
-	// Aumentado a 160s porque runs complejos pueden tardar 140-150s bajo carga de OpenAI
-	const maxRunDuration = 160 * time.Second
-	start := time.Now()
-
-	// CRÍTICO: Verificar si ya existe un run activo en este thread
-	// Esto previene crear runs duplicados cuando el usuario reintenta
-	existingRunID, existingStatus, err := c.checkActiveRun(ctx, threadID)
-	if err != nil {
-		// Log error pero continúa creando nuevo run
-		log.Printf("[runAndWait][CHECK_ACTIVE_ERROR] thread=%s err=%v - continuará creando nuevo run", threadID, err)
-	}
-	if err == nil && existingRunID != "" {
-		log.Printf("[runAndWait][ACTIVE_RUN_FOUND] thread=%s existing_run=%s status=%s - reutilizando en lugar de crear nuevo",
-			threadID, existingRunID, existingStatus)
-		// Esperar el run existente en lugar de crear uno nuevo
-		return c.pollAndGetResponse(ctx, threadID, existingRunID, start, maxRunDuration)
-	}
-
-	// create run
+// runPayload arma el cuerpo de POST /threads/{id}/runs: assistant, modelo del plan, instrucciones
+// y file_search restringido al vector store indicado (vacío = el configurado en el assistant).
+func (c *Client) runPayload(ctx context.Context, threadID, instructions, vectorStoreID string) map[string]any {
 	payload := map[string]any{"assistant_id": c.AssistantID}
+	if m := ModelFromContext(ctx); m != "" {
+		payload["model"] = m // override del modelo del assistant (p. ej. modelo premium del plan)
+	}
 
 	// Las instrucciones ahora vienen completas desde el handler, NO agregamos reglas adicionales
 	// para evitar confusión o dilución del mensaje principal
@@ -1570,6 +1479,34 @@ func (c *Client) runAndWaitWithRetry(ctx context.Context, threadID string, instr
 	// DEBUG: Log payload completo para verificar qué estamos enviando
 	payloadJSON, _ := json.Marshal(payload)
 	log.Printf("[runAndWait][DEBUG] thread=%s run_payload=%s", threadID, string(payloadJSON))
+	return payload
+}
+
+// runAndWaitWithRetry es la implementación interna con contador de reintentos
+func (c *Client) runAndWaitWithRetry(ctx context.Context, threadID string, instructions string, vectorStoreID string, retryCount int) (string, error) {
+	const maxRetries = 2 // 2 reintentos = 3 intentos totales (inicial + 2 retries)
+
+	// Máxima duración interna antes de intentar devolver contenido parcial
+	// Aumentado a 160s porque runs complejos pueden tardar 140-150s bajo carga de OpenAI
+	const maxRunDuration = 160 * time.Second
+	start := time.Now()
+
+	// CRÍTICO: Verificar si ya existe un run activo en este thread
+	// Esto previene crear runs duplicados cuando el usuario reintenta
+	existingRunID, existingStatus, err := c.checkActiveRun(ctx, threadID)
+	if err != nil {
+		// Log error pero continúa creando nuevo run
+		log.Printf("[runAndWait][CHECK_ACTIVE_ERROR] thread=%s err=%v - continuará creando nuevo run", threadID, err)
+	}
+	if err == nil && existingRunID != "" {
+		log.Printf("[runAndWait][ACTIVE_RUN_FOUND] thread=%s existing_run=%s status=%s - reutilizando en lugar de crear nuevo",
+			threadID, existingRunID, existingStatus)
+		// Esperar el run existente en lugar de crear uno nuevo
+		return c.pollAndGetResponse(ctx, threadID, existingRunID, start, maxRunDuration)
+	}
+
+	// create run
+	payload := c.runPayload(ctx, threadID, instructions, vectorStoreID)
 
 	// TIMING: Medir cuánto tarda OpenAI en crear el run
 	createRunStart := time.Now()
@@ -1614,6 +1551,10 @@ func (c *Client) runAndWaitWithRetry(ctx context.Context, threadID string, instr
 		case <-ctx.Done():
 			log.Printf("[runAndWait][TIMEOUT] thread=%s run_id=%s elapsed=%v polls=%d last_status=%s",
 				threadID, run.ID, time.Since(pollStart), pollCount, lastStatus)
+			// Cliente desconectado: no dejar el run consumiendo tokens
+			if errors.Is(ctx.Err(), context.Canceled) {
+				c.cancelRemoteRun(threadID, run.ID)
+			}
 			return "", ctx.Err()
 		case <-time.After(400 * time.Millisecond):
 		}
@@ -1703,12 +1644,9 @@ func (c *Client) runAndWaitWithRetry(ctx context.Context, threadID string, instr
 				log.Printf("[runAndWait][SERVER_ERROR_DETECTED] thread=%s run_id=%s retry=%d/%d - OpenAI error temporal, reintentando...",
 					threadID, run.ID, retryCount+1, maxRetries)
 
-				// Backoff exponencial con máximo de 15 segundos: 2s, 4s, 8s, 15s, 15s, 15s...
+				// Backoff exponencial con jitter y máximo de 15 segundos (~2s, 4s, 8s, 15s...)
 				// Reducimos agresividad para no saturar OpenAI durante caídas prolongadas
-				waitTime := time.Duration(2<<uint(retryCount)) * time.Second
-				if waitTime > 15*time.Second {
-					waitTime = 15 * time.Second
-				}
+				waitTime := backoff(2*time.Second, 15*time.Second, retryCount)
 				log.Printf("[runAndWait][RETRY_WAIT] thread=%s esperando %v antes de reintentar", threadID, waitTime)
 
 				select {
@@ -2006,13 +1944,17 @@ func (c *Client) getLatestAssistantMessage(ctx context.Context, threadID string)
 
 // ThreadMessage representa un mensaje del historial del thread
 type ThreadMessage struct {
-	Role    string // "user" o "assistant"
-	Content string
+	Role      string // "user" o "assistant"
+	Content   string
+	CreatedAt int64 // unix; 0 si la fuente no lo informa
 }
 
 // GetThreadMessages obtiene los últimos N mensajes del historial del thread
 // para proporcionar contexto conversacional en búsquedas
 func (c *Client) GetThreadMessages(ctx context.Context, threadID string, limit int) ([]ThreadMessage, error) {
+	if c.mock != nil {
+		return c.mockThreadMessages(threadID, limit), nil
+	}
 	if limit <= 0 {
 		limit = 10
 	}
@@ -2026,8 +1968,9 @@ func (c *Client) GetThreadMessages(ctx context.Context, threadID string, limit i
 
 	var ml struct {
 		Data []struct {
-			Role    string `json:"role"`
-			Content []struct {
+			Role      string `json:"role"`
+			CreatedAt int64  `json:"created_at"`
+			Content   []struct {
 				Type string `json:"type"`
 				Text struct {
 					Value string `json:"value"`
@@ -2050,8 +1993,9 @@ func (c *Client) GetThreadMessages(ctx context.Context, threadID string, limit i
 		}
 		if text := cleanAssistantAnnotations(content.String()); text != "" { // Limpiar anotaciones de OpenAI
 			messages = append(messages, ThreadMessage{
-				Role:    m.Role,
-				Content: text,
+				Role:      m.Role,
+				Content:   text,
+				CreatedAt: m.CreatedAt,
 			})
 		}
 	}
@@ -2064,8 +2008,11 @@ func (c *Client) GetThreadMessages(ctx context.Context, threadID string, limit i
 	return messages, nil
 }
 
-// StreamAssistantMessage uses Assistants API but emits the final text as a single chunk for simplicity.
+// StreamAssistantMessage uses Assistants API and streams the run's text deltas as they arrive.
 func (c *Client) StreamAssistantMessage(ctx context.Context, threadID, prompt string) (<-chan string, error) {
+	if c.mock != nil {
+		return c.mockTurn(ctx, threadID, prompt, "")
+	}
 	if c.key == "" || c.AssistantID == "" {
 		return nil, errors.New("assistants not configured")
 	}
@@ -2089,7 +2036,7 @@ func (c *Client) StreamAssistantMessage(ctx context.Context, threadID, prompt st
 		}
 	}
 	log.Printf("[assist][StreamAssistantMessage][start] thread=%s vs=%s prompt_hash=%s", threadID, vsID, hashPrompt)
-	out := make(chan string, 1)
+	out := make(chan string, 100)
 	go func() {
 		defer close(out)
 		// CRÍTICO: Instrucciones para modo documento (cuando usuario sube PDFs)
@@ -2144,7 +2091,7 @@ NO uses razonamiento interno visible.
 			log.Printf("[assist][StreamAssistantMessage] thread=%s bias_last_file=%s age=%s", threadID, lf.Name, time.Since(lf.At))
 		}
 		c.lastMu.RUnlock()
-		text, err := c.runAndWait(ctx, threadID, strict, vsID)
+		text, err := c.streamRunText(ctx, out, threadID, strict, vsID)
 		if err == nil && text != "" {
 			outHash := ""
 			if len(text) > 120 {
@@ -2158,9 +2105,7 @@ NO uses razonamiento interno visible.
 				tmpFile := "/tmp/assistant_full_" + threadID + ".txt"
 				os.WriteFile(tmpFile, []byte(text), 0644)
 			}
-			// DEBUG: Enviar texto completo sin chunking para verificar formato original de OpenAI
 			log.Printf("[DEBUG] Text preview (first 300 chars): %q", truncateString(text, 300))
-			out <- text
 		}
 		if err != nil {
 			log.Printf("[assist][StreamAssistantMessage][error] thread=%s err=%v", threadID, err)
@@ -2169,8 +2114,11 @@ NO uses razonamiento interno visible.
 	return out, nil
 }
 
-// StreamAssistantMessageWithFile uploads a file and attaches it to the user message, then runs and emits final text.
+// StreamAssistantMessageWithFile uploads a file and attaches it to the user message, then runs and streams the text deltas.
 func (c *Client) StreamAssistantMessageWithFile(ctx context.Context, threadID, prompt, filePath string) (<-chan string, error) {
+	if c.mock != nil {
+		return c.mockTurn(ctx, threadID, prompt, "")
+	}
 	if c.key == "" || c.AssistantID == "" {
 		return nil, errors.New("assistants not configured")
 	}
@@ -2201,7 +2149,7 @@ func (c *Client) StreamAssistantMessageWithFile(ctx context.Context, threadID, p
 		return nil, err
 	}
 	log.Printf("[assist][StreamAssistantMessageWithFile][start] thread=%s vs=%s file=%s prompt_len=%d", threadID, vsID, filepath.Base(filePath), len(prompt))
-	out := make(chan string, 1)
+	out := make(chan string, 100)
 	go func() {
 		defer close(out)
 		// Constrain the run to only use the vector store
@@ -2211,7 +2159,7 @@ func (c *Client) StreamAssistantMessageWithFile(ctx context.Context, threadID, p
 		if base := filepath.Base(filePath); base != "" {
 			strict = strict + " Responde sobre el archivo recientemente subido '" + base + "' sin pedir confirmación, salvo que el usuario indique otro documento."
 		}
-		text, err := c.runAndWait(ctx, threadID, strict, vsID)
+		text, err := c.streamRunText(ctx, out, threadID, strict, vsID)
 		if err == nil && text != "" {
 			outHash := ""
 			if len(text) > 120 {
@@ -2225,7 +2173,6 @@ func (c *Client) StreamAssistantMessageWithFile(ctx context.Context, threadID, p
 				tmpFile := "/tmp/assistant_full_" + threadID + ".txt"
 				os.WriteFile(tmpFile, []byte(text), 0644)
 			}
-			out <- text
 		}
 		if err != nil {
 			log.Printf("[assist][StreamAssistantMessageWithFile][error] thread=%s file=%s err=%v", threadID, filepath.Base(filePath), err)
@@ -2238,6 +2185,9 @@ func (c *Client) StreamAssistantMessageWithFile(ctx context.Context, threadID, p
 // then runs the assistant (GPT-4o/GPT-4o-mini with vision) and streams the response.
 // The prompt should ask about the image content.
 func (c *Client) StreamAssistantMessageWithImage(ctx context.Context, threadID, prompt, imagePath string) (<-chan string, error) {
+	if c.mock != nil {
+		return c.mockTurn(ctx, threadID, prompt, "")
+	}
 	if c.key == "" || c.AssistantID == "" {
 		return nil, errors.New("assistants not configured")
 	}
@@ -2302,42 +2252,41 @@ Mantén un tono profesional pero accesible.`
 // StreamAssistantJSON runs the assistant using file_search but WITHOUT overriding tool_resources (vector_store_ids),
 // so it uses the assistant's pre-configured RAG. It enforces custom JSON-style instructions via the run.
 func (c *Client) StreamAssistantJSON(ctx context.Context, threadID, userPrompt, jsonInstructions string) (<-chan string, error) {
-	// Fallback path: if we have no AssistantID but do have an API key + model, emulate via Chat Completions.
-	if c.key == "" { // sin API key no podemos llamar a OpenAI
-		return nil, errors.New("openai api key not configured")
+	if c.mock != nil {
+		return c.mockTurn(ctx, threadID, userPrompt, jsonInstructions)
 	}
-	if c.AssistantID == "" { // usar chat completions como fallback
-		model := c.Model
-		if strings.TrimSpace(model) == "" {
-			model = "gpt-4-turbo" // default con mejor contexto conversacional
+	// Fallback path: if we have no AssistantID, emulate via chat completions on the provider of
+	// llm.FlowStructured (which may be a local server and then needs no OpenAI key).
+	if c.AssistantID == "" {
+		p := c.LLM(llm.FlowStructured)
+		req := llm.Prompt(jsonInstructions+"\nResponde UNICAMENTE JSON válido.", userPrompt)
+		if p.Name() == llm.ProviderOpenAI {
+			if c.key == "" { // sin API key no podemos llamar a OpenAI
+				return nil, errors.New("openai api key not configured")
+			}
+			req.Model = c.Model
+			if strings.TrimSpace(req.Model) == "" {
+				req.Model = "gpt-4-turbo" // default con mejor contexto conversacional
+			}
 		}
 		out := make(chan string, 1)
 		go func() {
 			defer close(out)
-			log.Printf("[openai][StreamAssistantJSON] usando fallback chat completions model=%s", model)
-			// Construimos un prompt estructurado: system con instrucciones JSON y user con el prompt del usuario.
-			// Reutilizamos cliente subyacente (c.api) directamente.
-			if c.api == nil {
-				return
-			}
-			req := openai.ChatCompletionRequest{
-				Model: model,
-				Messages: []openai.ChatCompletionMessage{
-					{Role: openai.ChatMessageRoleSystem, Content: jsonInstructions + "\nResponde UNICAMENTE JSON válido."},
-					{Role: openai.ChatMessageRoleUser, Content: userPrompt},
-				},
-			}
-			resp, err := c.api.CreateChatCompletion(ctx, req)
-			if err != nil || len(resp.Choices) == 0 {
+			log.Printf("[openai][StreamAssistantJSON] usando fallback chat completions provider=%s model=%s", p.Name(), req.Model)
+			txt, err := p.Complete(ctx, req)
+			if err != nil {
+				log.Printf("[openai][StreamAssistantJSON][fallback.error] %v", err)
 				return
 			}
-			txt := resp.Choices[0].Message.Content
 			if strings.TrimSpace(txt) != "" {
 				out <- txt
 			}
 		}()
 		return out, nil
 	}
+	if c.key == "" { // sin API key no podemos llamar a OpenAI
+		return nil, errors.New("openai api key not configured")
+	}
 	// Ruta normal Assistants v2
 	// Usar contexto independiente para addMessage para evitar timeout si el request HTTP ya consumió tiempo
 	// Aumentado a 90s + retry automático para manejar latencias extremas de OpenAI API
@@ -2363,6 +2312,10 @@ func (c *Client) StreamAssistantJSON(ctx context.Context, threadID, userPrompt,
 
 // DeleteThread deletes an Assistants thread (best effort, 404 ignored).
 func (c *Client) DeleteThread(ctx context.Context, threadID string) error {
+	if c.mock != nil {
+		c.mockDeleteThread(threadID)
+		return nil
+	}
 	if c.key == "" || strings.TrimSpace(threadID) == "" {
 		return nil
 	}
@@ -2402,6 +2355,10 @@ func (c *Client) deleteFile(ctx context.Context, fileID string) error {
 
 // DeleteThreadArtifacts removes vector store, uploaded files, and thread; and clears local mappings.
 func (c *Client) DeleteThreadArtifacts(ctx context.Context, threadID string) error {
+	if c.mock != nil {
+		c.mockDeleteThread(threadID)
+		return nil
+	}
 	// Gather vector store id
 	var vsID string
 	c.vsMu.RLock()
@@ -2424,14 +2381,13 @@ func (c *Client) DeleteThreadArtifacts(ctx context.Context, threadID string) err
 	for _, fid := range toDelete {
 		_ = c.deleteFile(ctx, fid)
 	}
-	// Delete vector store SOLO si NO es el vector store compartido de libros
-	// El vector store de libros es permanente y usado por todos los threads del chat general
-	const sharedBooksVectorID = "vs_680fc484cef081918b2b9588b701e2f4"
-	if vsID != "" && vsID != sharedBooksVectorID {
+	// Delete vector store SOLO si NO es una base de conocimiento registrada (libros, especialidades)
+	// Esas son permanentes y compartidas por todos los threads
+	if vsID != "" && !knowledge.IsRegistered(vsID) {
 		log.Printf("[delete_artifacts] deleting vector store thread=%s vs=%s", threadID, vsID)
 		_ = c.deleteVectorStore(ctx, vsID)
-	} else if vsID == sharedBooksVectorID {
-		log.Printf("[delete_artifacts] preserving shared books vector store thread=%s vs=%s", threadID, vsID)
+	} else if vsID != "" {
+		log.Printf("[delete_artifacts] preserving knowledge base vector store thread=%s vs=%s", threadID, vsID)
 	}
 	// Delete thread
 	_ = c.DeleteThread(ctx, threadID)
@@ -2487,6 +2443,15 @@ func (c *Client) ForceNewVectorStore(ctx context.Context, threadID string) (stri
 	if threadID == "" {
 		return "", errors.New("threadID vacío")
 	}
+	if c.mock != nil {
+		c.vsMu.Lock()
+		delete(c.vectorStore, threadID)
+		c.vsMu.Unlock()
+		c.sessMu.Lock()
+		c.sessFiles[threadID] = 0
+		c.sessMu.Unlock()
+		return c.mockEnsureVectorStore(threadID), nil
+	}
 	c.vsMu.Lock()
 	old := c.vectorStore[threadID]
 	delete(c.vectorStore, threadID)
@@ -2498,7 +2463,7 @@ func (c *Client) ForceNewVectorStore(ctx context.Context, threadID string) (stri
 	c.sessFiles[threadID] = 0
 	c.sessMu.Unlock()
 
-	if old != "" {
+	if old != "" && !knowledge.IsRegistered(old) {
 		log.Printf("[vector_store][force_new] thread=%s deleting_old_vs=%s", threadID, old)
 		_ = c.deleteVectorStore(ctx, old)
 	}
@@ -2507,6 +2472,9 @@ func (c *Client) ForceNewVectorStore(ctx context.Context, threadID string) (stri
 
 // ListVectorStoreFiles lists file ids currently attached to the vector store for a thread.
 func (c *Client) ListVectorStoreFiles(ctx context.Context, threadID string) ([]string, error) {
+	if c.mock != nil {
+		return c.mockListFiles(threadID), nil
+	}
 	if threadID == "" {
 		return nil, errors.New("threadID vacío")
 	}
@@ -2584,11 +2552,10 @@ func (c *Client) maybeCleanupVectorStores() {
 	c.vsMu.Lock()
 	now := time.Now()
 	expired := make([]string, 0)
-	// CRÍTICO: Proteger el vector store compartido de libros médicos (permanente)
-	const sharedBooksVectorID = "vs_680fc484cef081918b2b9588b701e2f4"
+	// CRÍTICO: Proteger las bases de conocimiento registradas (libros médicos, especialidades)
 	for t, id := range c.vectorStore {
-		// NUNCA limpiar el vector store de libros - es compartido y permanente
-		if id == sharedBooksVectorID {
+		// NUNCA limpiar una base de conocimiento - es compartida y permanente
+		if knowledge.IsRegistered(id) {
 			continue
 		}
 		last := c.vsLastAccess[t]
@@ -2619,17 +2586,90 @@ func (c *Client) maybeCleanupVectorStores() {
 
 // VectorSearchResult contiene tanto el contenido encontrado como metadatos de la fuente
 type VectorSearchResult struct {
-	Content   string       `json:"content"`
-	Source    string       `json:"source"`             // Título del documento o nombre del archivo
-	VectorID  string       `json:"vector_id"`          // ID del vector store
-	HasResult bool         `json:"has_result"`         // Indica si se encontró información relevante
-	Section   string       `json:"section,omitempty"`  // Sección/capítulo si es posible
-	Metadata  *PDFMetadata `json:"metadata,omitempty"` // Metadatos del PDF si está disponible
+	Content    string       `json:"content"`
+	Source     string       `json:"source"`                // Título del documento o nombre del archivo
+	VectorID   string       `json:"vector_id"`             // ID del vector store
+	HasResult  bool         `json:"has_result"`            // Indica si se encontró información relevante
+	Section    string       `json:"section,omitempty"`     // Sección/capítulo si es posible
+	Page       int          `json:"page,omitempty"`        // Página del fragmento (1-based) si se conoce
+	DocumentID string       `json:"document_id,omitempty"` // file_id de OpenAI o id del índice local
+	Metadata   *PDFMetadata `json:"metadata,omitempty"`    // Metadatos del PDF si está disponible
+}
+
+// chunkMetadata une metadata (formato anterior) y attributes (vector stores actuales) de un fragmento.
+func chunkMetadata(metadata, attributes map[string]any) map[string]any {
+	out := make(map[string]any, len(metadata)+len(attributes))
+	for k, v := range attributes {
+		out[k] = v
+	}
+	for k, v := range metadata {
+		out[k] = v
+	}
+	return out
+}
+
+// pageMarker es la marca de página que lleva el texto OCR subido al vector store ("[Página 3]").
+var pageMarker = regexp.MustCompile(`\[Página (\d+)\]`)
+
+// markedPage devuelve la página con más texto de un fragmento con marcas "[Página N]"; el texto
+// anterior a la primera marca pertenece a la página previa. 0 si no hay marcas.
+func markedPage(text string) int {
+	locs := pageMarker.FindAllStringSubmatchIndex(text, -1)
+	if len(locs) == 0 {
+		return 0
+	}
+	best, bestLen := 0, 0
+	first, _ := strconv.Atoi(text[locs[0][2]:locs[0][3]])
+	if n := len(strings.TrimSpace(text[:locs[0][0]])); n > 0 && first > 1 {
+		best, bestLen = first-1, n
+	}
+	for i, loc := range locs {
+		end := len(text)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		page, _ := strconv.Atoi(text[loc[2]:loc[3]])
+		if n := len(strings.TrimSpace(text[loc[1]:end])); n > bestLen {
+			best, bestLen = page, n
+		}
+	}
+	return best
+}
+
+// applyChunkMetadata completa sección y página de un resultado con los metadatos/atributos del
+// fragmento; si no traen página, la deduce de las marcas del texto OCR (ver markedPage).
+func applyChunkMetadata(result *VectorSearchResult, meta map[string]any) {
+	if section, ok := meta["section"].(string); ok {
+		result.Section = strings.TrimSpace(section)
+	}
+	switch page := meta["page"].(type) {
+	case float64:
+		result.Page = int(page)
+	case string:
+		result.Page, _ = strconv.Atoi(strings.TrimSpace(page))
+	}
+	if result.Page == 0 {
+		result.Page = markedPage(result.Content)
+	}
+	if result.Section == "" {
+		if page, ok := meta["page_label"].(string); ok {
+			result.Section = strings.TrimSpace(page)
+		}
+	}
+	if result.Section == "" && result.Page > 0 {
+		result.Section = fmt.Sprintf("Página %d", result.Page)
+	}
 }
 
 // QuickVectorSearch intenta recuperar fragmentos usando el endpoint directo de vector stores (más liviano que crear runs).
 // Retorna el contenido Y el nombre REAL del archivo (no adivinado), evitando desalineación de fuentes.
 func (c *Client) QuickVectorSearch(ctx context.Context, vectorStoreID, query string) (*VectorSearchResult, error) {
+	if c.mock != nil {
+		if r := c.mockVectorResults(vectorStoreID, query, 1); len(r) > 0 {
+			return r[0], nil
+		}
+		return nil, errors.New("vector store search not configured")
+	}
 	if c.key == "" || strings.TrimSpace(vectorStoreID) == "" {
 		return nil, errors.New("vector store search not configured")
 	}
@@ -2646,9 +2686,10 @@ func (c *Client) QuickVectorSearch(ctx context.Context, vectorStoreID, query str
 	}
 	var data struct {
 		Data []struct {
-			FileID   string          `json:"file_id"`
-			Metadata map[string]any  `json:"metadata"`
-			Content  json.RawMessage `json:"content"`
+			FileID     string          `json:"file_id"`
+			Metadata   map[string]any  `json:"metadata"`
+			Attributes map[string]any  `json:"attributes"`
+			Content    json.RawMessage `json:"content"`
 		} `json:"data"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
@@ -2664,30 +2705,19 @@ func (c *Client) QuickVectorSearch(ctx context.Context, vectorStoreID, query str
 	if isLikelyNoDataResponse(snippet) {
 		snippet = ""
 	}
-	result := &VectorSearchResult{VectorID: vectorStoreID, Content: snippet}
+	result := &VectorSearchResult{VectorID: vectorStoreID, Content: snippet, DocumentID: entry.FileID}
 	if entry.FileID != "" {
 		if name, err := c.getFileName(ctx, entry.FileID); err == nil {
 			result.Source = friendlyDocName(name)
 		}
 	}
-	if result.Source == "" && len(entry.Metadata) > 0 {
-		if raw, ok := entry.Metadata["source"].(string); ok {
+	meta := chunkMetadata(entry.Metadata, entry.Attributes)
+	if result.Source == "" {
+		if raw, ok := meta["source"].(string); ok {
 			result.Source = friendlyDocName(raw)
 		}
-		if section, ok := entry.Metadata["section"].(string); ok {
-			result.Section = strings.TrimSpace(section)
-		}
-		if result.Section == "" {
-			if page, ok := entry.Metadata["page_label"].(string); ok {
-				result.Section = strings.TrimSpace(page)
-			}
-		}
-		if result.Section == "" {
-			if pageNum, ok := entry.Metadata["page"].(float64); ok {
-				result.Section = fmt.Sprintf("Página %d", int(pageNum))
-			}
-		}
 	}
+	applyChunkMetadata(result, meta)
 	if result.Source != "" || result.Content != "" || strings.TrimSpace(result.Section) != "" {
 		result.HasResult = true
 	}
@@ -2697,6 +2727,12 @@ func (c *Client) QuickVectorSearch(ctx context.Context, vectorStoreID, query str
 // QuickVectorSearchMultiple devuelve MÚLTIPLES resultados del vector store (hasta maxResults)
 // Esto permite citar varios libros cuando la información aparece en más de uno
 func (c *Client) QuickVectorSearchMultiple(ctx context.Context, vectorStoreID, query string, maxResults int) ([]*VectorSearchResult, error) {
+	if c.mock != nil {
+		if r := c.mockVectorResults(vectorStoreID, query, maxResults); len(r) > 0 {
+			return r, nil
+		}
+		return nil, errors.New("vector store search not configured")
+	}
 	if c.key == "" || strings.TrimSpace(vectorStoreID) == "" {
 		return nil, errors.New("vector store search not configured")
 	}
@@ -2717,9 +2753,10 @@ func (c *Client) QuickVectorSearchMultiple(ctx context.Context, vectorStoreID, q
 	}
 	var data struct {
 		Data []struct {
-			FileID   string          `json:"file_id"`
-			Metadata map[string]any  `json:"metadata"`
-			Content  json.RawMessage `json:"content"`
+			FileID     string          `json:"file_id"`
+			Metadata   map[string]any  `json:"metadata"`
+			Attributes map[string]any  `json:"attributes"`
+			Content    json.RawMessage `json:"content"`
 		} `json:"data"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
@@ -2748,7 +2785,7 @@ func (c *Client) QuickVectorSearchMultiple(ctx context.Context, vectorStoreID, q
 			continue
 		}
 
-		result := &VectorSearchResult{VectorID: vectorStoreID, Content: snippet}
+		result := &VectorSearchResult{VectorID: vectorStoreID, Content: snippet, DocumentID: entry.FileID}
 
 		// Obtener nombre del archivo
 		if entry.FileID != "" {
@@ -2758,28 +2795,15 @@ func (c *Client) QuickVectorSearchMultiple(ctx context.Context, vectorStoreID, q
 		}
 
 		// Fallback a metadata si no obtuvimos el nombre del FileID
-		if result.Source == "" && len(entry.Metadata) > 0 {
-			if raw, ok := entry.Metadata["source"].(string); ok {
+		meta := chunkMetadata(entry.Metadata, entry.Attributes)
+		if result.Source == "" {
+			if raw, ok := meta["source"].(string); ok {
 				result.Source = friendlyDocName(raw)
 			}
 		}
 
-		// Metadatos adicionales
-		if len(entry.Metadata) > 0 {
-			if section, ok := entry.Metadata["section"].(string); ok {
-				result.Section = strings.TrimSpace(section)
-			}
-			if result.Section == "" {
-				if page, ok := entry.Metadata["page_label"].(string); ok {
-					result.Section = strings.TrimSpace(page)
-				}
-			}
-			if result.Section == "" {
-				if pageNum, ok := entry.Metadata["page"].(float64); ok {
-					result.Section = fmt.Sprintf("Página %d", int(pageNum))
-				}
-			}
-		}
+		// Metadatos adicionales: sección y página
+		applyChunkMetadata(result, meta)
 
 		// CRÍTICO: Deduplicar por nombre de archivo para evitar repetir el mismo libro
 		sourceKey := strings.ToLower(strings.TrimSpace(result.Source))
@@ -2934,6 +2958,12 @@ func (c *Client) SearchInVectorStore(ctx context.Context, vectorStoreID, query s
 
 // SearchInVectorStoreWithMetadata busca información y devuelve metadatos completos
 func (c *Client) SearchInVectorStoreWithMetadata(ctx context.Context, vectorStoreID, query string) (*VectorSearchResult, error) {
+	if c.mock != nil {
+		if r := c.mockVectorResults(vectorStoreID, query, 1); len(r) > 0 {
+			return r[0], nil
+		}
+		return &VectorSearchResult{VectorID: vectorStoreID}, nil
+	}
 	if c.key == "" || c.AssistantID == "" {
 		return nil, errors.New("assistants not configured")
 	}
@@ -3124,6 +3154,9 @@ func (c *Client) getFileName(ctx context.Context, fileID string) (string, error)
 
 // SearchPubMed busca información en PubMed usando E-utilities API de NCBI
 func (c *Client) SearchPubMed(ctx context.Context, query string) (string, error) {
+	if c.mock != nil {
+		return mockPubMed(query), nil
+	}
 	log.Printf("[openai][SearchPubMed][start] query_len=%d query_preview=%s", len(query), sanitizePreview(query))
 	start := time.Now()
 
@@ -3160,7 +3193,8 @@ func (c *Client) SearchPubMed(ctx context.Context, query string) (string, error)
 		return "", fmt.Errorf("failed to create search request: %v", err)
 	}
 
-	client := &http.Client{Timeout: 20 * time.Second}
+	// Mismo transporte que OpenAI: reintenta 429/5xx respetando Retry-After (breaker propio por host)
+	client := &http.Client{Timeout: 20 * time.Second, Transport: upstreamTransport()}
 	searchResp, err := client.Do(searchReq)
 	if err != nil {
 		log.Printf("[openai][SearchPubMed][error] esearch_failed err=%v", err)
@@ -3168,19 +3202,6 @@ func (c *Client) SearchPubMed(ctx context.Context, query string) (string, error)
 	}
 	defer searchResp.Body.Close()
 
-	if searchResp.StatusCode == http.StatusTooManyRequests {
-		log.Printf("[openai][SearchPubMed][rate_limit] waiting 1s before retry")
-		time.Sleep(1 * time.Second)
-		// Reintentar una vez después del rate limit
-		searchResp2, err2 := client.Do(searchReq)
-		if err2 != nil {
-			log.Printf("[openai][SearchPubMed][error] esearch_retry_failed err=%v", err2)
-			return "", nil
-		}
-		searchResp.Body.Close()
-		searchResp = searchResp2
-	}
-
 	if searchResp.StatusCode != http.StatusOK {
 		log.Printf("[openai][SearchPubMed][error] esearch_status=%d", searchResp.StatusCode)
 		return "", nil
@@ -3240,18 +3261,6 @@ func (c *Client) SearchPubMed(ctx context.Context, query string) (string, error)
 	}
 	defer fetchResp.Body.Close()
 
-	if fetchResp.StatusCode == http.StatusTooManyRequests {
-		log.Printf("[openai][SearchPubMed][rate_limit] efetch waiting 1s before retry")
-		time.Sleep(1 * time.Second)
-		fetchResp2, err2 := client.Do(fetchReq)
-		if err2 != nil {
-			log.Printf("[openai][SearchPubMed][error] efetch_retry_failed err=%v", err2)
-			return "", nil
-		}
-		fetchResp.Body.Close()
-		fetchResp = fetchResp2
-	}
-
 	if fetchResp.StatusCode != http.StatusOK {
 		log.Printf("[openai][SearchPubMed][error] efetch_status=%d", fetchResp.StatusCode)
 		return "", nil
@@ -3764,6 +3773,9 @@ func generateSummary(articles []map[string]interface{}, query string) string {
 
 // StreamAssistantWithSpecificVectorStore ejecuta el assistant con un vector store específico
 func (c *Client) StreamAssistantWithSpecificVectorStore(ctx context.Context, threadID, prompt, vectorStoreID string) (<-chan string, error) {
+	if c.mock != nil {
+		return c.mockTurn(ctx, threadID, prompt, "")
+	}
 	if c.key == "" || c.AssistantID == "" {
 		return nil, errors.New("assistants not configured")
 	}
@@ -3779,14 +3791,12 @@ func (c *Client) StreamAssistantWithSpecificVectorStore(ctx context.Context, thr
 	}
 
 	log.Printf("[assist][StreamWithSpecificVector][start] thread=%s vs=%s", threadID, vectorStoreID)
-	out := make(chan string, 1)
+	out := make(chan string, 100)
 	go func() {
 		defer close(out)
-		text, err := c.runAndWaitWithVectorStore(ctx, threadID, prompt, vectorStoreID)
+		text, err := c.streamRunText(ctx, out, threadID, prompt, vectorStoreID)
 		if err == nil && text != "" {
 			log.Printf("[assist][StreamWithSpecificVector][done] thread=%s vs=%s chars=%d", threadID, vectorStoreID, len(text))
-			// DEBUG: Enviar texto completo sin chunking
-			out <- text
 		}
 		if err != nil {
 			log.Printf("[assist][StreamWithSpecificVector][error] thread=%s vs=%s err=%v", threadID, vectorStoreID, err)
@@ -3799,6 +3809,9 @@ func (c *Client) StreamAssistantWithSpecificVectorStore(ctx context.Context, thr
 // de las instrucciones del sistema (que solo se usan en el run).
 // Esto evita contaminar el historial del thread con prompts gigantes del sistema.
 func (c *Client) StreamAssistantWithInstructions(ctx context.Context, threadID, userMessage, instructions, vectorStoreID string) (<-chan string, error) {
+	if c.mock != nil {
+		return c.mockTurn(ctx, threadID, userMessage, instructions)
+	}
 	if c.key == "" || c.AssistantID == "" {
 		return nil, errors.New("assistants not configured")
 	}
@@ -3815,22 +3828,15 @@ func (c *Client) StreamAssistantWithInstructions(ctx context.Context, threadID,
 	log.Printf("[assist][StreamWithInstructions][start] thread=%s vs=%s user_msg_len=%d instructions_len=%d",
 		threadID, vectorStoreID, len(userMessage), len(instructions))
 
-	out := make(chan string, 100) // Buffer más grande para chunks
+	out := make(chan string, 100) // Buffer para los deltas del stream
 	go func() {
 		defer close(out)
-		// CRÍTICO: Usar contexto independiente para el run (desacoplado del HTTP request timeout)
-		// OpenAI puede tardar 180+ segundos en runs complejos con file_search en vectores grandes
-		// Aumentado a 240s (4 min) porque OpenAI API está experimentando latencias extremas
-		runCtx, runCancel := context.WithTimeout(context.Background(), 240*time.Second)
-		defer runCancel()
-		log.Printf("[assist][StreamWithInstructions][run_context] thread=%s timeout=240s", threadID)
-
+		// streamRunText desacopla el run del timeout del HTTP request (OpenAI puede tardar 180+
+		// segundos con file_search en vectores grandes) pero lo cancela si el cliente se desconecta
 		// Usar las instrucciones completas solo para el run, no se guardan en el thread
-		text, err := c.runAndWaitWithVectorStore(runCtx, threadID, instructions, vectorStoreID)
+		text, err := c.streamRunText(ctx, out, threadID, instructions, vectorStoreID)
 		if err == nil && text != "" {
 			log.Printf("[assist][StreamWithInstructions][done] thread=%s vs=%s chars=%d", threadID, vectorStoreID, len(text))
-			// DEBUG: Enviar texto completo sin chunking
-			out <- text
 		}
 		if err != nil {
 			log.Printf("[assist][StreamWithInstructions][error] thread=%s vs=%s err=%v", threadID, vectorStoreID, err)
@@ -3862,28 +3868,31 @@ func sanitizePreview(s string) string {
 	return strings.TrimSpace(s)
 }
 
-// cleanAssistantAnnotations elimina anotaciones internas de OpenAI Assistants API
-// que aparecen como 【0†source】 o fileciteturn0fileN en las respuestas.
-// Estas son markers de citación que OpenAI inserta pero no queremos mostrar al usuario.
-func cleanAssistantAnnotations(text string) string {
+// Markers de citación que OpenAI inserta en el texto y no queremos mostrar al usuario, en el
+// orden en que se eliminan. annotationStripper (stream.go) aplica los mismos patrones sobre deltas.
+var (
 	// Patrón 1: 【número†source】 (brackets Unicode + número + dagger + source)
-	re1 := regexp.MustCompile(`【\d+†[^】]*】`)
-	text = re1.ReplaceAllString(text, "")
-
 	// Patrón 2: fileciteturnNfileM (donde N y M son números)
-	re2 := regexp.MustCompile(`fileciteturn\d+file\d+`)
-	text = re2.ReplaceAllString(text, "")
-
 	// Patrón 3: Otros markers comunes de OpenAI
-	re3 := regexp.MustCompile(`\[citation:\d+\]`)
-	text = re3.ReplaceAllString(text, "")
-
+	annotationMarkers = []*regexp.Regexp{
+		regexp.MustCompile(`【\d+†[^】]*】`),
+		regexp.MustCompile(`fileciteturn\d+file\d+`),
+		regexp.MustCompile(`\[citation:\d+\]`),
+	}
 	// CRÍTICO: Limpiar espacios extras PERO PRESERVAR saltos de línea para Markdown
 	// NO usar \s (incluye \n) sino solo espacios/tabs horizontales
 	// `[ \t]{2,}` = dos o más espacios/tabs → un espacio
-	re4 := regexp.MustCompile(`[ \t]{2,}`)
-	text = re4.ReplaceAllString(text, " ")
+	annotationSpaces = regexp.MustCompile(`[ \t]{2,}`)
+)
 
+// cleanAssistantAnnotations elimina anotaciones internas de OpenAI Assistants API
+// que aparecen como 【0†source】 o fileciteturn0fileN en las respuestas.
+// Estas son markers de citación que OpenAI inserta pero no queremos mostrar al usuario.
+func cleanAssistantAnnotations(text string) string {
+	for _, re := range annotationMarkers {
+		text = re.ReplaceAllString(text, "")
+	}
+	text = annotationSpaces.ReplaceAllString(text, " ")
 	return strings.TrimSpace(text)
 }
 
@@ -3915,52 +3924,34 @@ func (c *Client) translateMedicalQuery(ctx context.Context, query string) string
 
 	log.Printf("[openai][translateQuery][detected] Spanish detected, will translate query_preview=%s", truncateText(query, 80))
 
-	// Usar OpenAI para traducción médica precisa
-	if c.api == nil {
-		log.Printf("[openai][translateQuery][fallback] No API key available, using simple cleanup")
-		// Fallback: limpieza simple si no hay API disponible
-		result := strings.ReplaceAll(query, "¿", "")
-		result = strings.ReplaceAll(result, "¡", "")
-		return result
-	}
-
+	// Traducción médica con el proveedor del flujo llm.FlowQueryTranslation (modelo rápido)
 	translationCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	messages := []openai.ChatCompletionMessage{
-		{
-			Role: "system",
-			Content: "You are a medical search query translator. Convert the Spanish medical question into English search terms for PubMed. " +
-				"Extract only the key medical concepts (disease, treatment, anatomy, etc.). " +
-				"Remove question words (qué, cuál, cómo). Return ONLY the English search terms, no punctuation.",
-		},
-		{
-			Role:    "user",
-			Content: query,
-		},
-	}
-
-	resp, err := c.api.CreateChatCompletion(translationCtx, openai.ChatCompletionRequest{
-		Model:       "gpt-4o-mini", // Modelo rápido y económico para traducciones
-		Messages:    messages,
-		Temperature: 0.1, // Baja temperatura para traducción consistente
-		MaxTokens:   200,
-	})
-
+	req := llm.Prompt("You are a medical search query translator. Convert the Spanish medical question into English search terms for PubMed. "+
+		"Extract only the key medical concepts (disease, treatment, anatomy, etc.). "+
+		"Remove question words (qué, cuál, cómo). Return ONLY the English search terms, no punctuation.", query)
+	req.Fast = true       // Modelo rápido y económico para traducciones
+	req.Temperature = 0.1 // Baja temperatura para traducción consistente
+	req.MaxTokens = 200
+	translated, err := c.LLM(llm.FlowQueryTranslation).Complete(translationCtx, req)
 	if err != nil {
-		log.Printf("[openai][translateQuery][error] err=%v, using original query", err)
+		if errors.Is(err, llm.ErrNotConfigured) {
+			log.Printf("[openai][translateQuery][fallback] No provider available, using simple cleanup")
+		} else {
+			log.Printf("[openai][translateQuery][error] err=%v, using original query", err)
+		}
 		// Fallback: limpiar caracteres especiales
 		result := strings.ReplaceAll(query, "¿", "")
 		result = strings.ReplaceAll(result, "¡", "")
 		return result
 	}
 
-	if len(resp.Choices) == 0 {
-		log.Printf("[openai][translateQuery][error] No choices in response, using original query")
+	translated = strings.TrimSpace(translated)
+	if translated == "" {
+		log.Printf("[openai][translateQuery][error] Empty translation, using original query")
 		return query
 	}
-
-	translated := strings.TrimSpace(resp.Choices[0].Message.Content)
 	log.Printf("[openai][translateQuery][success] original=%s translated=%s", truncateText(query, 50), truncateText(translated, 50))
 	return translated
 }
@@ -4014,6 +4005,9 @@ func isLikelyNoDataResponse(s string) bool {
 // CreateConversation crea una nueva conversación usando Responses API
 // Mantiene compatibilidad: retorna un ID que puede usarse como thread_id
 func (c *Client) CreateConversation(ctx context.Context) (string, error) {
+	if c.mock != nil {
+		return c.mock.newID("conv"), nil
+	}
 	if c.key == "" {
 		return "", errors.New("openai api key not configured")
 	}
@@ -4056,13 +4050,27 @@ func (c *Client) CreateConversation(ctx context.Context) (string, error) {
 
 // StreamResponseWithInstructions usa Responses API para generar respuesta con streaming
 // Separa userMessage (se guarda en conversation) de instructions (solo para el response)
+// Los deltas se emiten a medida que llegan, ya sin markers de citación.
 func (c *Client) StreamResponseWithInstructions(ctx context.Context, conversationID, userMessage, instructions, vectorStoreID string) (<-chan string, error) {
+	return c.responseWithInstructions(ctx, conversationID, userMessage, instructions, vectorStoreID, false)
+}
+
+// responseWithInstructions implementa StreamResponseWithInstructions. Con whole=true (respuestas
+// JSON, cuyos consumidores leen solo el primer chunk) se acumula el stream y se emite el texto
+// completo en un único chunk.
+func (c *Client) responseWithInstructions(ctx context.Context, conversationID, userMessage, instructions, vectorStoreID string, whole bool) (<-chan string, error) {
+	if c.mock != nil {
+		return c.mockTurn(ctx, conversationID, userMessage, instructions)
+	}
 	if c.key == "" {
 		return nil, errors.New("openai api key not configured")
 	}
+	if err := c.upstreamDown(); err != nil {
+		return nil, err
+	}
 
-	log.Printf("[responses][StreamWithInstructions][start] conversation=%s vs=%s user_msg_len=%d instructions_len=%d",
-		conversationID, vectorStoreID, len(userMessage), len(instructions))
+	log.Printf("[responses][StreamWithInstructions][start] conversation=%s vs=%s user_msg_len=%d instructions_len=%d whole=%v",
+		conversationID, vectorStoreID, len(userMessage), len(instructions), whole)
 
 	out := make(chan string, 100)
 	go func() {
@@ -4070,7 +4078,7 @@ func (c *Client) StreamResponseWithInstructions(ctx context.Context, conversatio
 
 		// Construir request de Responses API
 		payload := map[string]any{
-			"model":        resolveModelForResponses(c.Model),
+			"model":        resolveModelForResponses(firstNonEmptyModel(ModelFromContext(ctx), c.Model)),
 			"conversation": conversationID,
 			"input":        userMessage, // Solo el mensaje del usuario
 			"store":        true,        // Guardar para contexto futuro
@@ -4081,66 +4089,72 @@ func (c *Client) StreamResponseWithInstructions(ctx context.Context, conversatio
 			payload["instructions"] = strings.TrimSpace(instructions)
 		}
 
-		// Añadir tools con file_search si hay vector store
+		// Añadir tools con file_search si hay vector store y, en las respuestas de texto, las tools
+		// del backend (function calling, see tools.go); los flujos JSON (whole) no las usan
+		var tools []map[string]any
 		if vectorStoreID != "" {
-			payload["tools"] = []map[string]any{
-				{
-					"type":             "file_search",
-					"vector_store_ids": []string{vectorStoreID},
-				},
-			}
+			tools = append(tools, map[string]any{
+				"type":             "file_search",
+				"vector_store_ids": []string{vectorStoreID},
+			})
 		}
-
-		// Crear response con timeout generoso
-		respCtx, cancel := context.WithTimeout(context.Background(), 240*time.Second)
-		defer cancel()
-
-		resp, err := c.doJSONResponses(respCtx, http.MethodPost, "/responses", payload)
-		if err != nil {
-			log.Printf("[responses][StreamWithInstructions][error] conversation=%s err=%v", conversationID, err)
-			return
+		if !whole {
+			tools = append(tools, functionTools(c.toolsFor(ctx))...)
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			b, _ := io.ReadAll(resp.Body)
-			log.Printf("[responses][StreamWithInstructions][status_error] conversation=%s code=%d body=%s",
-				conversationID, resp.StatusCode, sanitizeBody(string(b)))
-			return
+		if len(tools) > 0 {
+			payload["tools"] = tools
 		}
 
-		// Parsear respuesta
-		var response struct {
-			ID     string `json:"id"`
-			Output []struct {
-				Type    string `json:"type"`
-				Content []struct {
-					Type string `json:"type"`
-					Text string `json:"text"`
-				} `json:"content"`
-			} `json:"output"`
-		}
+		// Crear response con timeout generoso, desacoplado del deadline del handler pero
+		// cancelado si el cliente se desconecta o se pide POST /conversations/cancel
+		respCtx, done := c.startRun(ctx, conversationID, runTimeout)
+		defer done()
 
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			log.Printf("[responses][StreamWithInstructions][parse_error] conversation=%s err=%v", conversationID, err)
+		start := time.Now()
+		sink := &deltaSink{ctx: respCtx, out: out}
+		onDelta := func(delta string) error {
+			if sink.text.Len() == 0 {
+				log.Printf("[responses][StreamWithInstructions][first_delta] conversation=%s ttft_ms=%d", conversationID, time.Since(start).Milliseconds())
+			}
+			return sink.write(delta)
+		}
+		if whole {
+			onDelta = func(string) error { return nil }
+		}
+		// Mientras el modelo pida tools: ejecutarlas y devolverle los resultados en un nuevo
+		// response de la misma conversation (que ya guarda el mensaje y los function_call)
+		var responseID, text string
+		var err error
+		for round := 1; ; round++ {
+			var calls []functionCall
+			responseID, text, calls, err = c.streamResponse(respCtx, conversationID, payload, onDelta)
+			if err != nil || len(calls) == 0 {
+				break
+			}
+			log.Printf("[responses][tools][round] conversation=%s response_id=%s round=%d calls=%d", conversationID, responseID, round, len(calls))
+			payload["input"] = c.runTools(respCtx, conversationID, calls)
+			if round >= maxToolRounds {
+				payload["tool_choice"] = "none" // forzar la respuesta final
+			}
+		}
+		if err == nil && !whole {
+			err = sink.close()
+		}
+		if err != nil {
+			log.Printf("[responses][StreamWithInstructions][error] conversation=%s emitted_chars=%d err=%v", conversationID, sink.text.Len(), err)
 			return
 		}
-
-		// Extraer texto de la respuesta
-		for _, item := range response.Output {
-			if item.Type == "message" {
-				for _, content := range item.Content {
-					if content.Type == "output_text" && content.Text != "" {
-						out <- content.Text
-						log.Printf("[responses][StreamWithInstructions][success] conversation=%s response_id=%s chars=%d",
-							conversationID, response.ID, len(content.Text))
-						return
-					}
-				}
+		if whole {
+			if strings.TrimSpace(text) == "" {
+				log.Printf("[responses][StreamWithInstructions][warn] conversation=%s no_text_found", conversationID)
+				return
 			}
+			out <- text
+		} else {
+			text = sink.text.String()
 		}
-
-		log.Printf("[responses][StreamWithInstructions][warn] conversation=%s no_text_found", conversationID)
+		log.Printf("[responses][StreamWithInstructions][success] conversation=%s response_id=%s chars=%d elapsed_ms=%d",
+			conversationID, responseID, len(text), time.Since(start).Milliseconds())
 	}()
 
 	return out, nil
@@ -4149,6 +4163,9 @@ func (c *Client) StreamResponseWithInstructions(ctx context.Context, conversatio
 // StreamResponseWithImage procesa análisis de imágenes usando Chat Completions API
 // (Responses API aún no soporta imágenes nativamente, así que usamos Chat Completions)
 func (c *Client) StreamResponseWithImage(ctx context.Context, conversationID, prompt, imagePath string) (<-chan string, error) {
+	if c.mock != nil {
+		return c.mockTurn(ctx, conversationID, prompt, "")
+	}
 	if c.key == "" {
 		return nil, errors.New("openai api key not configured")
 	}
@@ -4320,8 +4337,8 @@ func (c *Client) StreamAssistantJSONCompatible(ctx context.Context, threadID, us
 	if c.useResponsesAPI {
 		log.Printf("[hybrid][routing] conversation=%s using_responses_api=true (JSON mode, vectorStoreID=%s)", threadID, vectorStoreID)
 		// Para Responses API: userPrompt es el input, jsonInstructions van en instructions
-		// Usamos vectorStoreID para RAG si está disponible
-		return c.StreamResponseWithInstructions(ctx, threadID, userPrompt, jsonInstructions, vectorStoreID)
+		// Usamos vectorStoreID para RAG si está disponible; el JSON se emite completo en un solo chunk
+		return c.responseWithInstructions(ctx, threadID, userPrompt, jsonInstructions, vectorStoreID, true)
 	}
 	log.Printf("[hybrid][routing] thread=%s using_assistants_api=true (JSON mode)", threadID)
 	return c.StreamAssistantJSON(ctx, threadID, userPrompt, jsonInstructions)
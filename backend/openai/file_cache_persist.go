@@ -47,7 +47,6 @@ func saveFileCache(m map[string]string) error {
 
 func loadFileCacheDB(db *sql.DB) (map[string]string, error) {
 	m := map[string]string{}
-	_, _ = db.Exec(`CREATE TABLE IF NOT EXISTS ai_filecache (ckey VARCHAR(191) PRIMARY KEY, file_id VARCHAR(255))`)
 	rows, err := db.Query(`SELECT ckey, file_id FROM ai_filecache`)
 	if err != nil {
 		return m, nil
@@ -63,7 +62,6 @@ func loadFileCacheDB(db *sql.DB) (map[string]string, error) {
 }
 
 func saveFileCacheDB(db *sql.DB, m map[string]string) error {
-	_, _ = db.Exec(`CREATE TABLE IF NOT EXISTS ai_filecache (ckey VARCHAR(191) PRIMARY KEY, file_id VARCHAR(255))`)
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -0,0 +1,477 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"ema-backend/llm"
+)
+
+// Modo sin conexión (AI_PROVIDER=mock): el cliente no llama a OpenAI ni a PubMed. Hilos, vector
+// stores y archivos se simulan en memoria y cada flujo recibe un payload determinista que cumple
+// su contrato JSON (chat, casos analíticos, casos interactivos interactive_v2 y cuestionarios),
+// para correr la app Flutter y las pruebas de integración sin red ni API key.
+
+// MockAssistantID is the assistant reported by a mock client (so /health shows it as configured).
+const MockAssistantID = "asst_mock"
+
+// mockEnabled reports AI_PROVIDER=mock.
+func mockEnabled() bool {
+	return strings.EqualFold(sanitizeEnv(os.Getenv("AI_PROVIDER")), "mock")
+}
+
+// mockState keeps the simulated threads of a mock client.
+type mockState struct {
+	mu      sync.Mutex
+	seq     int
+	threads map[string][]ThreadMessage
+}
+
+// NewMockClient returns a client that answers every call locally (what NewClient returns with
+// AI_PROVIDER=mock). Nothing is read from or persisted to the vector store and file caches.
+func NewMockClient() *Client {
+	c := &Client{
+		AssistantID:     MockAssistantID,
+		Model:           "mock",
+		httpClient:      &http.Client{Timeout: 180 * time.Second},
+		vectorStore:     make(map[string]string),
+		vsLastAccess:    make(map[string]time.Time),
+		fileCache:       make(map[string]string),
+		sessBytes:       make(map[string]int64),
+		sessFiles:       make(map[string]int),
+		lastFile:        make(map[string]LastFileInfo),
+		conversations:   make(map[string]string),
+		useResponsesAPI: true,
+		mock:            &mockState{threads: make(map[string][]ThreadMessage)},
+	}
+	c.llm = llm.NewRegistry(llm.NewMock(func(req llm.Request) string {
+		var instructions, prompt string
+		for _, m := range req.Messages {
+			if m.Role == llm.RoleSystem {
+				instructions = m.Content
+			} else if m.Role == llm.RoleUser {
+				prompt = m.Content
+			}
+		}
+		return mockAnswer(prompt, instructions, 0)
+	}))
+	return c
+}
+
+func (m *mockState) newID(prefix string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	return fmt.Sprintf("%s_mock_%06d", prefix, m.seq)
+}
+
+// mockTurn answers prompt in threadID and records both messages, like a run on a real thread.
+// The answer is emitted as a single chunk, as the Assistants flows do.
+func (c *Client) mockTurn(ctx context.Context, threadID, prompt, instructions string) (<-chan string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m := c.mock
+	m.mu.Lock()
+	turn := 0
+	for _, msg := range m.threads[threadID] {
+		if msg.Role == "assistant" {
+			turn++
+		}
+	}
+	answer := mockAnswer(prompt, instructions, turn)
+	if threadID != "" {
+		m.threads[threadID] = append(m.threads[threadID],
+			ThreadMessage{Role: "user", Content: prompt},
+			ThreadMessage{Role: "assistant", Content: answer})
+	}
+	m.mu.Unlock()
+	ch := make(chan string, 1)
+	ch <- answer
+	close(ch)
+	return ch, nil
+}
+
+func (c *Client) mockThreadMessages(threadID string, limit int) []ThreadMessage {
+	if limit <= 0 {
+		limit = 10
+	}
+	c.mock.mu.Lock()
+	defer c.mock.mu.Unlock()
+	msgs := c.mock.threads[threadID]
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return append([]ThreadMessage(nil), msgs...)
+}
+
+func (c *Client) mockDeleteThread(threadID string) {
+	c.mock.mu.Lock()
+	delete(c.mock.threads, threadID)
+	c.mock.mu.Unlock()
+	c.vsMu.Lock()
+	delete(c.vectorStore, threadID)
+	delete(c.vsLastAccess, threadID)
+	c.vsMu.Unlock()
+	c.fileMu.Lock()
+	for k := range c.fileCache {
+		if strings.HasPrefix(k, threadID+"|") {
+			delete(c.fileCache, k)
+		}
+	}
+	c.fileMu.Unlock()
+	c.sessMu.Lock()
+	delete(c.sessBytes, threadID)
+	delete(c.sessFiles, threadID)
+	c.sessMu.Unlock()
+}
+
+func (c *Client) mockEnsureVectorStore(threadID string) string {
+	c.vsMu.Lock()
+	defer c.vsMu.Unlock()
+	if id, ok := c.vectorStore[threadID]; ok {
+		return id
+	}
+	id := c.mock.newID("vs")
+	c.vectorStore[threadID] = id
+	c.vsLastAccess[threadID] = time.Now()
+	return id
+}
+
+func (c *Client) mockUploadFile(threadID, filePath string) (string, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", fmt.Errorf("file not found: %s", filePath)
+	}
+	id := c.mock.newID("file")
+	c.fileMu.Lock()
+	c.fileCache[threadID+"|"+id] = id
+	c.fileMu.Unlock()
+	if threadID != "" {
+		c.lastMu.Lock()
+		c.lastFile[threadID] = LastFileInfo{ID: id, Name: filepath.Base(filePath), At: time.Now(), Metadata: extractPDFMetadata(filePath)}
+		c.lastMu.Unlock()
+	}
+	return id, nil
+}
+
+// mockAddFile counts the file for the thread owning vsID, as AddFileToVectorStore does.
+func (c *Client) mockAddFile(vsID, fileID string) error {
+	if vsID == "" || fileID == "" {
+		return errors.New("vector store ID or file ID is empty")
+	}
+	c.vsMu.RLock()
+	defer c.vsMu.RUnlock()
+	for thread, id := range c.vectorStore {
+		if id == vsID {
+			c.sessMu.Lock()
+			c.sessFiles[thread]++
+			c.sessMu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (c *Client) mockListFiles(threadID string) []string {
+	c.fileMu.RLock()
+	defer c.fileMu.RUnlock()
+	out := []string{}
+	for k, id := range c.fileCache {
+		if strings.HasPrefix(k, threadID+"|") {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// mockBooks is the canned knowledge base used for vector searches in mock mode.
+var mockBooks = []struct{ title, section, text string }{
+	{"Harrison. Principios de Medicina Interna, 21ª edición", "Capítulo 1. La práctica de la medicina clínica",
+		"La evaluación clínica parte de una anamnesis dirigida y un examen físico sistemático; los estudios complementarios se solicitan para confirmar o descartar las hipótesis del diagnóstico diferencial."},
+	{"Guyton y Hall. Tratado de Fisiología Médica, 14ª edición", "Capítulo 4. Regulación de la homeostasis",
+		"Los mecanismos de retroalimentación negativa mantienen constantes las variables fisiológicas; su fallo explica buena parte de los signos de descompensación aguda."},
+	{"Goodman & Gilman. Las Bases Farmacológicas de la Terapéutica, 13ª edición", "Capítulo 2. Farmacocinética",
+		"La dosis se ajusta según la función renal y hepática del paciente y las interacciones con otros fármacos; el margen terapéutico condiciona la monitorización."},
+}
+
+// mockVectorResults returns up to n canned fragments. Searches on a thread's own vector store cite
+// the last file uploaded to that thread.
+func (c *Client) mockVectorResults(vectorStoreID, query string, n int) []*VectorSearchResult {
+	if strings.TrimSpace(vectorStoreID) == "" {
+		return nil
+	}
+	topic := mockTopic(query)
+	c.vsMu.RLock()
+	thread := ""
+	for t, id := range c.vectorStore {
+		if id == vectorStoreID {
+			thread = t
+		}
+	}
+	c.vsMu.RUnlock()
+	if thread != "" {
+		c.lastMu.RLock()
+		info, ok := c.lastFile[thread]
+		c.lastMu.RUnlock()
+		if ok {
+			return []*VectorSearchResult{{
+				Content:    fmt.Sprintf("Fragmento simulado de %s relacionado con: %s.", info.Name, topic),
+				Source:     info.Name,
+				VectorID:   vectorStoreID,
+				HasResult:  true,
+				Section:    "Página 1",
+				Page:       1,
+				DocumentID: info.ID,
+				Metadata:   info.Metadata,
+			}}
+		}
+	}
+	if n <= 0 || n > len(mockBooks) {
+		n = len(mockBooks)
+	}
+	out := make([]*VectorSearchResult, 0, n)
+	for _, b := range mockBooks[:n] {
+		out = append(out, &VectorSearchResult{
+			Content:   b.text + " Aplicado a: " + topic + ".",
+			Source:    b.title,
+			VectorID:  vectorStoreID,
+			HasResult: true,
+			Section:   b.section,
+		})
+	}
+	return out
+}
+
+// mockPubMed returns the same JSON shape as SearchPubMed ({summary, studies}).
+func mockPubMed(query string) string {
+	topic := mockTopic(query)
+	studies := []map[string]any{
+		{
+			"title":      "Clinical approach to " + topic + ": a systematic review",
+			"pmid":       "00000001",
+			"authors":    []string{"García A", "Smith J"},
+			"year":       2023,
+			"journal":    "Offline Journal of Medicine",
+			"key_points": []string{"Simulated evidence: structured clinical assessment improves diagnostic accuracy."},
+			"doi":        "doi:10.0000/mock.0001",
+		},
+		{
+			"title":      "Management guidelines related to " + topic,
+			"pmid":       "00000002",
+			"authors":    []string{"López M", "Chen L"},
+			"year":       2022,
+			"journal":    "Mock Clinical Guidelines",
+			"key_points": []string{"Simulated evidence: guideline-directed therapy reduced complications."},
+		},
+	}
+	b, _ := json.Marshal(map[string]any{
+		"summary": "Se encontraron 2 estudios simulados sobre " + topic + " (modo sin conexión).",
+		"studies": studies,
+	})
+	return string(b)
+}
+
+// mockTopic shortens a prompt to its first line for echoing it back.
+func mockTopic(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > 80 {
+		s = string([]rune(s)[:80]) + "…"
+	}
+	if s == "" {
+		s = "la consulta"
+	}
+	return s
+}
+
+var (
+	mockCountRe   = regexp.MustCompile(`(?:tamaño|exactamente)\s+(\d+)`)
+	mockAnswersRe = regexp.MustCompile(`Respuestas del usuario:\s*(\[.*\])`)
+)
+
+// mockAnswer picks the contract from the instructions (and the prompt, for repair requests) and
+// returns a valid payload for it; turn is the number of assistant answers already in the thread.
+func mockAnswer(prompt, instructions string, turn int) string {
+	text := instructions + "\n" + prompt
+	switch {
+	case strings.Contains(text, "next{"):
+		return mockInteractiveTurn(strings.Contains(text, "finish:1"), turn)
+	case strings.Contains(text, "correct_index"):
+		return `{"correct_index":0}`
+	case strings.Contains(text, "fit_global"):
+		return mockQuizEvaluation(prompt)
+	case strings.Contains(text, "'case' y 'data'"):
+		return mockJSON(map[string]any{"case": mockCase("interactive"), "data": map[string]any{"questions": mockOpenQuestion(turn)}})
+	case strings.Contains(text, "'questions'"):
+		n := 5
+		if m := mockCountRe.FindStringSubmatch(text); m != nil {
+			if v, err := strconv.Atoi(m[1]); err == nil && v > 0 && v <= 50 {
+				n = v
+			}
+		}
+		return mockQuiz(n)
+	case strings.Contains(text, "'case'"):
+		return mockJSON(map[string]any{"case": mockCase("static")})
+	case strings.Contains(text, "'data'"):
+		return mockJSON(map[string]any{"data": map[string]any{
+			"feedback": "Buen razonamiento: la respuesta considera los hallazgos principales del caso (simulado).",
+			"question": mockOpenQuestion(turn + 1),
+		}})
+	case strings.Contains(text, "'respuesta'"):
+		return mockJSON(map[string]any{"respuesta": map[string]any{"text": mockChat(prompt)}})
+	}
+	return mockChat(prompt)
+}
+
+func mockJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func mockChat(prompt string) string {
+	return "**Respuesta de demostración (modo sin conexión).**\n\n" +
+		"Consulta recibida: " + mockTopic(prompt) + "\n\n" +
+		"El abordaje inicial combina una anamnesis dirigida, el examen físico y los estudios complementarios " +
+		"que confirmen o descarten las hipótesis principales. Este texto es fijo y no proviene de un modelo.\n\n" +
+		"¿Qué hallazgo clínico te gustaría profundizar?"
+}
+
+func mockCase(kind string) map[string]any {
+	return map[string]any{
+		"id":                   1,
+		"title":                "Dolor torácico opresivo en adulto (caso simulado)",
+		"type":                 kind,
+		"age":                  "58",
+		"sex":                  "masculino",
+		"gestante":             0,
+		"is_real":              0,
+		"anamnesis":            "Varón de 58 años, hipertenso y fumador, con dolor torácico opresivo de 40 minutos irradiado al brazo izquierdo, acompañado de diaforesis.",
+		"physical_examination": "PA 150/95 mmHg, FC 102 lpm, SatO2 95%. Piel pálida y sudorosa; ruidos cardiacos rítmicos sin soplos.",
+		"diagnostic_tests":     "ECG con elevación del ST en V1-V4; troponina I elevada.",
+		"final_diagnosis":      "Infarto agudo de miocardio con elevación del ST de cara anterior.",
+		"management":           "Antiagregación dual, anticoagulación y angioplastia primaria en las primeras 12 horas.",
+	}
+}
+
+var mockCaseQuestions = []struct {
+	text    string
+	options []string
+}{
+	{"¿Cuál es el estudio inicial prioritario?", []string{"Electrocardiograma de 12 derivaciones", "Radiografía de tórax", "Ecografía abdominal", "Gasometría venosa"}},
+	{"¿Qué biomarcador confirma la necrosis miocárdica?", []string{"Troponina cardiaca", "Dímero D", "Procalcitonina", "Lactato"}},
+	{"¿Cuál es la estrategia de reperfusión de elección?", []string{"Angioplastia primaria", "Observación", "Trombólisis diferida a 48 horas", "Cirugía electiva"}},
+	{"¿Qué fármaco forma parte del tratamiento inicial?", []string{"Ácido acetilsalicílico", "Amoxicilina", "Prednisona", "Furosemida en bolo"}},
+	{"¿Qué complicación temprana debe vigilarse?", []string{"Arritmias ventriculares", "Hipotiroidismo", "Pancreatitis", "Anemia ferropénica"}},
+}
+
+func mockOpenQuestion(turn int) map[string]any {
+	q := mockCaseQuestions[turn%len(mockCaseQuestions)]
+	return map[string]any{"texto": q.text, "tipo": "single_choice", "opciones": q.options}
+}
+
+// mockInteractiveTurn follows the interactive_v2 turn contract: feedback, next{hallazgos, pregunta}, finish.
+func mockInteractiveTurn(closing bool, turn int) string {
+	if closing {
+		return mockJSON(map[string]any{
+			"feedback": "Resumen: varón de 58 años con dolor torácico típico y elevación del ST anterior. Diagnóstico final: infarto agudo de miocardio con elevación del ST, confirmado por ECG y troponina; requiere reperfusión urgente.",
+			"next":     map[string]any{},
+			"finish":   1,
+		})
+	}
+	q := mockCaseQuestions[turn%len(mockCaseQuestions)]
+	feedback := "Varón de 58 años, hipertenso y fumador, consulta por dolor torácico opresivo de 40 minutos irradiado al brazo izquierdo con diaforesis. Se encuentra taquicárdico e hipertenso."
+	if turn > 0 {
+		feedback = "La respuesta se analiza según la fisiopatología del síndrome coronario agudo: la oclusión coronaria produce isquemia y necrosis progresiva, por lo que cada decisión debe priorizar el diagnóstico y la reperfusión tempranos."
+	}
+	return mockJSON(map[string]any{
+		"feedback": feedback,
+		"next": map[string]any{
+			"hallazgos": map[string]any{"signos_vitales": "PA 150/95 mmHg, FC 102 lpm, SatO2 95%"},
+			"pregunta": map[string]any{
+				"tipo":          "single-choice",
+				"texto":         q.text,
+				"opciones":      q.options,
+				"correct_index": 0,
+			},
+		},
+		"finish": 0,
+	})
+}
+
+var mockQuizBank = []map[string]any{
+	{"type": "single_choice", "question": "¿Cuál es el fármaco de primera línea en la hipertensión arterial no complicada?", "answer": "Tiazida o IECA", "options": []string{"Tiazida o IECA", "Betabloqueador en todos los casos", "Nitroglicerina", "Digoxina"}},
+	{"type": "true_false", "question": "La troponina cardiaca es el biomarcador de elección para el diagnóstico de infarto.", "answer": "true"},
+	{"type": "open_ended", "question": "Describe los criterios diagnósticos de la diabetes mellitus tipo 2.", "answer": "Glucosa en ayunas ≥126 mg/dL, HbA1c ≥6.5%, glucosa ≥200 mg/dL a las 2 h de la curva o al azar con síntomas."},
+	{"type": "single_choice", "question": "¿Cuál es la causa más frecuente de neumonía adquirida en la comunidad?", "answer": "Streptococcus pneumoniae", "options": []string{"Streptococcus pneumoniae", "Pseudomonas aeruginosa", "Klebsiella pneumoniae", "Legionella pneumophila"}},
+	{"type": "true_false", "question": "La metformina está contraindicada con filtrado glomerular menor de 30 mL/min.", "answer": "true"},
+	{"type": "single_choice", "question": "¿Qué electrolito se vigila al iniciar un IECA?", "answer": "Potasio", "options": []string{"Potasio", "Calcio", "Magnesio", "Fósforo"}},
+}
+
+func mockQuiz(n int) string {
+	questions := make([]map[string]any, 0, n)
+	for i := 0; i < n; i++ {
+		q := map[string]any{"id": i + 1}
+		for k, v := range mockQuizBank[i%len(mockQuizBank)] {
+			q[k] = v
+		}
+		if round := i / len(mockQuizBank); round > 0 {
+			q["question"] = fmt.Sprintf("%s (variante %d)", q["question"], round+1)
+		}
+		questions = append(questions, q)
+	}
+	return mockJSON(map[string]any{"questions": questions})
+}
+
+// mockQuizEvaluation marks every answered question as correct.
+func mockQuizEvaluation(prompt string) string {
+	var items []struct {
+		QuestionID int    `json:"question_id"`
+		Answer     string `json:"answer"`
+	}
+	if m := mockAnswersRe.FindStringSubmatch(prompt); m != nil {
+		if err := json.Unmarshal([]byte(m[1]), &items); err != nil {
+			log.Printf("[openai][mock] evaluation answers not parsed: %v", err)
+		}
+	}
+	eval := make([]map[string]any, 0, len(items))
+	correct := 0
+	for _, it := range items {
+		ok := 0
+		if strings.TrimSpace(it.Answer) != "" {
+			ok = 1
+			correct++
+		}
+		eval = append(eval, map[string]any{"question_id": it.QuestionID, "is_correct": ok, "fit": "Respuesta evaluada en modo sin conexión."})
+	}
+	pct := 0.0
+	if len(items) > 0 {
+		pct = float64(correct) * 100 / float64(len(items))
+	}
+	level := "desempeño bajo"
+	switch {
+	case pct >= 85:
+		level = "desempeño alto"
+	case pct >= 70:
+		level = "desempeño adecuado"
+	case pct >= 50:
+		level = "desempeño moderado"
+	}
+	global := fmt.Sprintf("Puntaje y Calificación:\nTotal de respuestas correctas: %d de %d.\nPuntaje: %.2f%%.\nClasificación: %s\n\n", correct, len(items), pct, level) +
+		"Retroalimentación:\nEvaluación generada en modo sin conexión; las respuestas no vacías se consideran correctas.\n\n" +
+		"Referencias:\n" + mockBooks[0].title + "; PMID: 00000001 - Simulated clinical review"
+	return mockJSON(map[string]any{"evaluation": eval, "correct_answers": correct, "fit_global": global})
+}
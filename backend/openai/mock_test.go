@@ -0,0 +1,158 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mockRead(t *testing.T, ch <-chan string, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	for s := range ch {
+		sb.WriteString(s)
+	}
+	return sb.String()
+}
+
+func TestMockClientContracts(t *testing.T) {
+	t.Setenv("AI_PROVIDER", "mock")
+	c := NewClient()
+	if c.mock == nil || c.GetAssistantID() != MockAssistantID {
+		t.Fatal("AI_PROVIDER=mock should return the mock client")
+	}
+	ctx := context.Background()
+	thread, err := c.CreateThreadOrConversation(ctx)
+	if err != nil || !strings.HasPrefix(thread, "conv_mock_") {
+		t.Fatalf("CreateThreadOrConversation = %q, %v", thread, err)
+	}
+
+	// Quiz generation: exactly the requested number of questions.
+	ch, err := c.StreamAssistantJSONCompatible(ctx, thread, `Genera un JSON con {"questions": [...] } de tamaño 8.`,
+		"Devuelve un objeto con la clave 'questions' como array de tamaño solicitado.", "vs_books")
+	var quiz struct {
+		Questions []struct {
+			ID       int      `json:"id"`
+			Type     string   `json:"type"`
+			Question string   `json:"question"`
+			Answer   string   `json:"answer"`
+			Options  []string `json:"options"`
+		} `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(mockRead(t, ch, err)), &quiz); err != nil || len(quiz.Questions) != 8 {
+		t.Fatalf("quiz = %+v, %v", quiz, err)
+	}
+	seen := map[string]bool{}
+	for _, q := range quiz.Questions {
+		if seen[q.Question] || (q.Type == "single_choice" && q.Options[0] != q.Answer) {
+			t.Fatalf("invalid question %+v", q)
+		}
+		seen[q.Question] = true
+	}
+
+	// Quiz evaluation: one entry per answer.
+	ch, err = c.StreamAssistantJSONCompatible(ctx, thread,
+		`Respuestas del usuario: [{"answer":"true","question_id":1,"type":"true_false"},{"answer":"","question_id":2,"type":"open_ended"}]`,
+		"evaluation: array de {question_id:int, is_correct:0|1, fit:string} correct_answers:int, fit_global:string", "")
+	var eval struct {
+		Evaluation     []map[string]any `json:"evaluation"`
+		CorrectAnswers int              `json:"correct_answers"`
+		FitGlobal      string           `json:"fit_global"`
+	}
+	if err := json.Unmarshal([]byte(mockRead(t, ch, err)), &eval); err != nil || len(eval.Evaluation) != 2 || eval.CorrectAnswers != 1 ||
+		!strings.Contains(eval.FitGlobal, "Total de respuestas correctas: 1 de 2.") {
+		t.Fatalf("evaluation = %+v, %v", eval, err)
+	}
+
+	// Interactive cases (interactive_v2): a question per turn, then the closing turn.
+	var turn struct {
+		Feedback string `json:"feedback"`
+		Next     struct {
+			Pregunta struct {
+				Texto        string   `json:"texto"`
+				Opciones     []string `json:"opciones"`
+				CorrectIndex *int     `json:"correct_index"`
+			} `json:"pregunta"`
+		} `json:"next"`
+		Finish int `json:"finish"`
+	}
+	first := ""
+	for i := 0; i < 2; i++ {
+		ch, err = c.StreamAssistantJSON(ctx, thread, "Respuesta", "JSON: feedback, next{hallazgos{}, pregunta{tipo:'single-choice', opciones[4], correct_index:0-3}}, finish:0.")
+		if err := json.Unmarshal([]byte(mockRead(t, ch, err)), &turn); err != nil || turn.Finish != 0 ||
+			len(turn.Next.Pregunta.Opciones) != 4 || turn.Next.Pregunta.CorrectIndex == nil || turn.Next.Pregunta.Texto == first {
+			t.Fatalf("turn %d = %+v, %v", i, turn, err)
+		}
+		first = turn.Next.Pregunta.Texto
+	}
+	ch, err = c.StreamAssistantJSON(ctx, thread, "Respuesta final", "JSON: feedback, next{}, finish:1.")
+	if err := json.Unmarshal([]byte(mockRead(t, ch, err)), &turn); err != nil || turn.Finish != 1 || turn.Feedback == "" {
+		t.Fatalf("closing turn = %+v, %v", turn, err)
+	}
+
+	// Clinical cases: static case, interactive case + first question, chat answers.
+	for instr, key := range map[string]string{
+		"Responde estrictamente en JSON válido con la clave 'case'.":                  "case",
+		"Responde en JSON válido con las claves: 'case' y 'data'.":                    "data",
+		"Responde estrictamente en JSON válido con la clave 'data' que contenga:":     "data",
+		"JSON: { 'respuesta': { 'text': <string> } }. Texto: 2–3 párrafos (150–220).": "respuesta",
+	} {
+		ch, err = c.StreamAssistantJSONCompatible(ctx, thread, "Paciente de 58 años", instr, "")
+		var out map[string]any
+		if err := json.Unmarshal([]byte(mockRead(t, ch, err)), &out); err != nil || out[key] == nil {
+			t.Fatalf("%q = %v, %v", instr, out, err)
+		}
+	}
+
+	// Every exchange is kept in the thread.
+	if msgs, err := c.GetThreadMessages(ctx, thread, 4); err != nil || len(msgs) != 4 || msgs[3].Role != "assistant" {
+		t.Fatalf("GetThreadMessages = %+v, %v", msgs, err)
+	}
+}
+
+func TestMockClientSearchAndFiles(t *testing.T) {
+	c := NewMockClient()
+	ctx := context.Background()
+
+	books, err := c.QuickVectorSearchMultiple(ctx, "vs_books", "hipertensión arterial", 2)
+	if err != nil || len(books) != 2 || !books[0].HasResult || books[0].Source == books[1].Source {
+		t.Fatalf("QuickVectorSearchMultiple = %+v, %v", books, err)
+	}
+	var pm struct {
+		Studies []struct {
+			PMID string `json:"pmid"`
+		} `json:"studies"`
+	}
+	if text, err := c.SearchPubMed(ctx, "hipertensión"); err != nil || json.Unmarshal([]byte(text), &pm) != nil || len(pm.Studies) == 0 || pm.Studies[0].PMID == "" {
+		t.Fatalf("SearchPubMed = %q, %v", text, err)
+	}
+
+	thread, _ := c.CreateThread(ctx)
+	pdf := filepath.Join(t.TempDir(), "guia.pdf")
+	os.WriteFile(pdf, []byte("%PDF-1.4"), 0o644)
+	vs, _ := c.EnsureVectorStore(ctx, thread)
+	fileID, err := c.UploadAssistantFile(ctx, thread, pdf)
+	if err != nil || c.AddFileToVectorStore(ctx, vs, fileID) != nil || c.CountThreadFiles(thread) != 1 {
+		t.Fatalf("upload = %q, %v, files=%d", fileID, err, c.CountThreadFiles(thread))
+	}
+	if res, err := c.QuickVectorSearch(ctx, vs, "dosis"); err != nil || res.Source != "guia.pdf" {
+		t.Fatalf("QuickVectorSearch on thread store = %+v, %v", res, err)
+	}
+	if text, err := c.TranscribeFile(ctx, "nota.m4a"); err != nil || !strings.Contains(text, "nota.m4a") {
+		t.Fatalf("TranscribeFile = %q, %v", text, err)
+	}
+	ch, err := c.StreamMessage(ctx, "¿Qué es la HTA?")
+	if text := mockRead(t, ch, err); !strings.Contains(text, "¿Qué es la HTA?") {
+		t.Fatalf("StreamMessage = %q", text)
+	}
+
+	if err := c.DeleteThreadArtifacts(ctx, thread); err != nil || c.GetVectorStoreID(thread) != "" || c.CountThreadFiles(thread) != 0 {
+		t.Fatalf("DeleteThreadArtifacts left state behind: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package openai
+
+import (
+	"context"
+	"strings"
+)
+
+type modelKey struct{}
+
+// WithModel overrides the chat model for the runs/responses created with ctx
+// (e.g. a premium model for plans entitled to it).
+func WithModel(ctx context.Context, model string) context.Context {
+	if strings.TrimSpace(model) == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, modelKey{}, strings.TrimSpace(model))
+}
+
+// ModelFromContext returns the model set with WithModel, or "".
+func ModelFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	m, _ := ctx.Value(modelKey{}).(string)
+	return m
+}
+
+func firstNonEmptyModel(models ...string) string {
+	for _, m := range models {
+		if m != "" {
+			return m
+		}
+	}
+	return ""
+}
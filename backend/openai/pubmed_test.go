@@ -219,7 +219,7 @@ func TestSearchPubMedSpanish(t *testing.T) {
 		}
 
 		if result == "" {
-			if client.api == nil {
+			if client.key == "" {
 				t.Log("⚠ No results - OPENAI_API_KEY not set, translation limited")
 			} else {
 				t.Error("Expected results for translated query, got empty")
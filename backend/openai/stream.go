@@ -0,0 +1,452 @@
+package openai
+
+// Streaming (stream: true) de Responses API y Assistants Runs: los deltas se reenvían al canal
+// a medida que llegan, limpiando los markers de citación sobre la marcha, en vez de esperar la
+// respuesta completa.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxHeldAnnotation limita cuánto texto se retiene esperando el cierre de un marker; más allá
+// se asume que no era una cita y se emite tal cual.
+const maxHeldAnnotation = 512
+
+// annotationTails detectan, al final del texto recibido, el inicio de un marker de
+// annotationMarkers que aún puede completarse (o alargarse) con el siguiente delta.
+var annotationTails = []*regexp.Regexp{
+	tailPattern("【", `(?:\d+(?:†[^】]*)?)?`),
+	tailPattern("fileciteturn", `(?:\d+(?:f|fi|fil|file\d*)?)?`),
+	tailPattern("[citation:", `\d*`),
+}
+
+// tailPattern acepta cualquier prefijo de lit, o lit seguido de rest, anclado al final.
+func tailPattern(lit, rest string) *regexp.Regexp {
+	runes := []rune(lit)
+	alts := make([]string, 0, len(runes))
+	for i := 1; i < len(runes); i++ {
+		alts = append(alts, regexp.QuoteMeta(string(runes[:i])))
+	}
+	alts = append(alts, regexp.QuoteMeta(lit)+rest)
+	return regexp.MustCompile("(?:" + strings.Join(alts, "|") + ")$")
+}
+
+// annotationStripper aplica cleanAssistantAnnotations de forma incremental: la concatenación
+// de Push(d1), Push(d2), ..., Flush() es igual a cleanAssistantAnnotations(d1+d2+...).
+// Retiene los posibles markers incompletos y el espacio en blanco final hasta saber qué sigue.
+type annotationStripper struct {
+	pending [3]string // texto retenido antes de cada patrón de annotationMarkers
+	space   string    // espacio en blanco final aún no emitido
+	started bool      // ya se emitió texto (el espacio inicial se descarta)
+}
+
+// Push procesa un delta y devuelve el texto limpio que ya es seguro emitir (puede ser "").
+func (a *annotationStripper) Push(delta string) string {
+	text := delta
+	for i, re := range annotationMarkers {
+		buf := a.pending[i] + text
+		cut := len(buf)
+		if loc := annotationTails[i].FindStringIndex(buf); loc != nil && len(buf)-loc[0] <= maxHeldAnnotation {
+			cut = loc[0]
+		}
+		a.pending[i] = buf[cut:]
+		text = re.ReplaceAllString(buf[:cut], "")
+	}
+	return a.spaces(text, false)
+}
+
+// Flush emite lo que quedaba retenido; se llama una vez al terminar la respuesta.
+func (a *annotationStripper) Flush() string {
+	text := ""
+	for i, re := range annotationMarkers {
+		text = re.ReplaceAllString(a.pending[i]+text, "")
+		a.pending[i] = ""
+	}
+	return a.spaces(text, true)
+}
+
+// spaces colapsa [ \t]{2,} y recorta el inicio/fin de la respuesta completa. Como lo emitido
+// siempre termina en un carácter no blanco, ninguna racha de espacios queda partida.
+func (a *annotationStripper) spaces(text string, final bool) string {
+	buf := a.space + text
+	if !a.started {
+		buf = strings.TrimLeftFunc(buf, unicode.IsSpace)
+	}
+	body := strings.TrimRightFunc(buf, unicode.IsSpace)
+	a.space = ""
+	if !final {
+		a.space = buf[len(body):]
+	}
+	if body == "" {
+		return ""
+	}
+	a.started = true
+	return annotationSpaces.ReplaceAllString(body, " ")
+}
+
+// deltaSink reenvía al canal de salida los deltas ya limpios y acumula el texto emitido.
+// Si quien consume deja de leer y su contexto termina, write devuelve error para cortar el stream.
+type deltaSink struct {
+	ctx   context.Context
+	out   chan<- string
+	strip annotationStripper
+	text  strings.Builder
+}
+
+func (s *deltaSink) write(delta string) error {
+	return s.send(s.strip.Push(delta))
+}
+
+// close emite el texto retenido por el stripper.
+func (s *deltaSink) close() error {
+	return s.send(s.strip.Flush())
+}
+
+func (s *deltaSink) send(chunk string) error {
+	if chunk == "" {
+		return nil
+	}
+	s.text.WriteString(chunk)
+	select {
+	case s.out <- chunk:
+		return nil
+	default:
+	}
+	select {
+	case s.out <- chunk:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// readSSE recorre un cuerpo text/event-stream y llama fn por cada evento con su nombre
+// (línea "event:") y sus datos (líneas "data:" unidas con \n). Un error de fn detiene la lectura.
+func readSSE(r io.Reader, fn func(event string, data []byte) error) error {
+	sc := bufio.NewScanner(r)
+	// response.completed incluye la respuesta entera en una sola línea
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var event string
+	var data bytes.Buffer
+	dispatch := func() error {
+		defer func() { event = ""; data.Reset() }()
+		if data.Len() == 0 {
+			return nil
+		}
+		return fn(event, data.Bytes())
+	}
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, ":"): // comentario / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return dispatch()
+}
+
+// doSSE hace un POST JSON pidiendo text/event-stream. assistants añade el header de Assistants v2.
+func (c *Client) doSSE(ctx context.Context, path string, payload any, assistants bool) (*http.Response, error) {
+	if c.key == "" {
+		return nil, errors.New("openai api key not configured")
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL(path), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if assistants {
+		req.Header.Set("OpenAI-Beta", "assistants=v2")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s failed: status=%d body=%s", path, resp.StatusCode, sanitizeBody(string(body)))
+	}
+	return resp, nil
+}
+
+// apiError es la forma de los errores que llegan dentro del stream.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e apiError) String() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (code: %s)", e.Message, e.Code)
+}
+
+// streamResponse crea un response con stream: true y llama onDelta con cada fragmento de
+// output_text. Devuelve el ID del response, el texto completo (sin limpiar) y las llamadas a
+// tools (function_call) que pidió el modelo. Si ctx se cancela a mitad del stream se pide a
+// OpenAI cancelar el response.
+func (c *Client) streamResponse(ctx context.Context, conversationID string, payload map[string]any, onDelta func(string) error) (string, string, []functionCall, error) {
+	payload["stream"] = true
+	resp, err := c.doSSE(ctx, "/responses", payload, false)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer resp.Body.Close()
+
+	var id string
+	var full strings.Builder
+	var calls []functionCall
+	done := false
+	err = readSSE(resp.Body, func(event string, data []byte) error {
+		var ev struct {
+			Type     string `json:"type"`
+			Delta    string `json:"delta"`
+			Code     string `json:"code"`
+			Message  string `json:"message"`
+			Response struct {
+				ID     string    `json:"id"`
+				Error  *apiError `json:"error"`
+				Output []struct {
+					Type string `json:"type"`
+					functionCall
+					Content []struct {
+						Type string `json:"type"`
+						Text string `json:"text"`
+					} `json:"content"`
+				} `json:"output"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return fmt.Errorf("invalid responses event %q: %w", event, err)
+		}
+		if ev.Type == "" {
+			ev.Type = event
+		}
+		if ev.Response.ID != "" && id == "" {
+			id = ev.Response.ID
+			c.noteRun(conversationID, "", id)
+		}
+		switch ev.Type {
+		case "response.output_text.delta":
+			full.WriteString(ev.Delta)
+			return onDelta(ev.Delta)
+		case "response.completed", "response.incomplete":
+			done = true
+			for _, item := range ev.Response.Output {
+				if item.Type == "function_call" {
+					calls = append(calls, item.functionCall)
+				}
+			}
+			if full.Len() > 0 {
+				return nil
+			}
+			// Sin deltas (p. ej. proxies que no reenvían eventos intermedios): usar el output final
+			for _, item := range ev.Response.Output {
+				if item.Type != "message" {
+					continue
+				}
+				for _, content := range item.Content {
+					if content.Type == "output_text" && content.Text != "" {
+						full.WriteString(content.Text)
+						if err := onDelta(content.Text); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		case "response.failed":
+			if ev.Response.Error != nil {
+				return fmt.Errorf("response failed: %s", ev.Response.Error)
+			}
+			return errors.New("response failed")
+		case "error":
+			return fmt.Errorf("response stream error: %s", apiError{Code: ev.Code, Message: ev.Message})
+		}
+		return nil
+	})
+	if err == nil && !done {
+		err = errors.New("response stream ended before completion")
+	}
+	if err != nil && ctx.Err() != nil && id != "" {
+		c.cancelRemoteResponse(id)
+	}
+	return id, full.String(), calls, err
+}
+
+// streamRun crea un run con stream: true y llama onDelta con cada fragmento de texto del mensaje
+// del assistant. Los server_error se reintentan como en runAndWaitWithRetry mientras no se haya
+// emitido nada; si el thread ya tiene un run activo se espera ese run (polling) y su texto llega
+// en un único delta.
+func (c *Client) streamRun(ctx context.Context, threadID, instructions, vectorStoreID string, onDelta func(string) error) error {
+	const maxRetries = 2
+	if runID, status, err := c.checkActiveRun(ctx, threadID); err == nil && runID != "" {
+		log.Printf("[streamRun][ACTIVE_RUN_FOUND] thread=%s existing_run=%s status=%s - esperando en lugar de crear nuevo", threadID, runID, status)
+		text, err := c.pollAndGetResponse(ctx, threadID, runID, time.Now(), 160*time.Second)
+		if err != nil {
+			return err
+		}
+		return onDelta(text)
+	}
+
+	payload := c.runPayload(ctx, threadID, instructions, vectorStoreID)
+	payload["stream"] = true
+	for retry := 0; ; retry++ {
+		start := time.Now()
+		emitted := false
+		runID, lastErr, err := c.streamRunOnce(ctx, threadID, payload, func(delta string) error {
+			if !emitted {
+				emitted = true
+				log.Printf("[streamRun][FIRST_DELTA] thread=%s ttft_ms=%d", threadID, time.Since(start).Milliseconds())
+			}
+			return onDelta(delta)
+		})
+		if err == nil {
+			log.Printf("[streamRun][COMPLETED] thread=%s run_id=%s elapsed=%v", threadID, runID, time.Since(start))
+			if !emitted {
+				// Run completado sin deltas de texto: leer el mensaje final del thread
+				text, merr := c.getLatestAssistantMessage(ctx, threadID)
+				if merr != nil {
+					return merr
+				}
+				return onDelta(text)
+			}
+			return nil
+		}
+		if ctx.Err() != nil && runID != "" {
+			c.cancelRemoteRun(threadID, runID)
+		}
+		if lastErr == nil || lastErr.Code != "server_error" || emitted || retry >= maxRetries {
+			log.Printf("[streamRun][ERROR] thread=%s run_id=%s err=%v", threadID, runID, err)
+			return err
+		}
+		waitTime := backoff(2*time.Second, 15*time.Second, retry)
+		log.Printf("[streamRun][SERVER_ERROR_DETECTED] thread=%s run_id=%s retry=%d/%d wait=%v", threadID, runID, retry+1, maxRetries, waitTime)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitTime):
+		}
+	}
+}
+
+// streamRunOnce hace un intento de streamRun. lastErr es el last_error del run si terminó en failed.
+func (c *Client) streamRunOnce(ctx context.Context, threadID string, payload map[string]any, onDelta func(string) error) (runID string, lastErr *apiError, err error) {
+	resp, err := c.doSSE(ctx, "/threads/"+threadID+"/runs", payload, true)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	done := false
+	err = readSSE(resp.Body, func(event string, data []byte) error {
+		if event == "done" || string(data) == "[DONE]" {
+			return nil
+		}
+		switch {
+		case event == "thread.message.delta":
+			var ev struct {
+				Delta struct {
+					Content []struct {
+						Type string `json:"type"`
+						Text struct {
+							Value string `json:"value"`
+						} `json:"text"`
+					} `json:"content"`
+				} `json:"delta"`
+			}
+			if err := json.Unmarshal(data, &ev); err != nil {
+				return fmt.Errorf("invalid run event %q: %w", event, err)
+			}
+			for _, part := range ev.Delta.Content {
+				if part.Type == "text" && part.Text.Value != "" {
+					if err := onDelta(part.Text.Value); err != nil {
+						return err
+					}
+				}
+			}
+		case strings.HasPrefix(event, "thread.run."):
+			var run struct {
+				ID        string    `json:"id"`
+				Status    string    `json:"status"`
+				LastError *apiError `json:"last_error"`
+			}
+			if err := json.Unmarshal(data, &run); err != nil {
+				return fmt.Errorf("invalid run event %q: %w", event, err)
+			}
+			if run.ID != "" && runID == "" && !strings.HasPrefix(event, "thread.run.step.") {
+				runID = run.ID
+				c.noteRun(threadID, runID, "")
+			}
+			switch event {
+			case "thread.run.completed", "thread.run.incomplete":
+				done = true
+			case "thread.run.failed", "thread.run.cancelled", "thread.run.expired":
+				lastErr = run.LastError
+				if lastErr != nil {
+					return fmt.Errorf("run status: %s: %s", run.Status, lastErr)
+				}
+				return fmt.Errorf("run status: %s", run.Status)
+			case "thread.run.requires_action":
+				return errors.New("run requires_action is not supported while streaming")
+			}
+		case event == "error":
+			var ev struct {
+				Error apiError `json:"error"`
+			}
+			_ = json.Unmarshal(data, &ev)
+			return fmt.Errorf("run stream error: %s", ev.Error)
+		}
+		return nil
+	})
+	if err == nil && !done {
+		err = errors.New("run stream ended before completion")
+	}
+	return runID, lastErr, err
+}
+
+// streamRunText ejecuta streamRun reenviando los deltas limpios a out y devuelve el texto
+// emitido (igual a cleanAssistantAnnotations de la respuesta completa). El run vive en un
+// contexto de startRun: sobrevive al deadline del handler pero no a la desconexión del cliente.
+func (c *Client) streamRunText(ctx context.Context, out chan<- string, threadID, instructions, vectorStoreID string) (string, error) {
+	ctx, done := c.startRun(ctx, threadID, runTimeout)
+	defer done()
+	sink := &deltaSink{ctx: ctx, out: out}
+	err := c.streamRun(ctx, threadID, instructions, vectorStoreID, sink.write)
+	if err == nil {
+		err = sink.close()
+	}
+	return sink.text.String(), err
+}
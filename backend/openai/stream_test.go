@@ -0,0 +1,271 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotationStripperMatchesClean(t *testing.T) {
+	texts := []string{
+		"  ## Hipertensión【4†guia.pdf】\n\nLa dosis   inicial es 5 mg.fileciteturn0file3  Ver [citation:2] tabla.  \n",
+		"Sin markers, con\tdos  espacios y saltos\n\n\nfinales \t \n",
+		"【no es cita】 y 【12 tampoco ni fileciteturn sin número ni [citation:x]",
+		"fileciteturn1file22 al inicio y【1†a】【2†b】juntos [citation:10]",
+		"file【1†x】citeturn1file2 se limpia igual que en el texto completo",
+		"   \n\n  ",
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, text := range texts {
+		want := cleanAssistantAnnotations(text)
+		runes := []rune(text)
+		for trial := 0; trial < 200; trial++ {
+			var a annotationStripper
+			var got strings.Builder
+			for i := 0; i < len(runes); {
+				n := 1 + rng.Intn(6)
+				if i+n > len(runes) {
+					n = len(runes) - i
+				}
+				got.WriteString(a.Push(string(runes[i : i+n])))
+				i += n
+			}
+			got.WriteString(a.Flush())
+			if got.String() != want {
+				t.Fatalf("stripped %q = %q, want %q", text, got.String(), want)
+			}
+		}
+	}
+}
+
+func TestReadSSE(t *testing.T) {
+	body := ": keep-alive\n\nevent: a\ndata: {\"x\":1}\n\ndata: line1\ndata: line2\n\nevent: done\ndata: [DONE]"
+	var got []string
+	err := readSSE(strings.NewReader(body), func(event string, data []byte) error {
+		got = append(got, event+"="+string(data))
+		return nil
+	})
+	want := []string{`a={"x":1}`, "=line1\nline2", "done=[DONE]"}
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("readSSE = %q, %v; want %q", got, err, want)
+	}
+}
+
+// sseServer starts an OpenAI stand-in and returns a client whose requests all go to it.
+func sseServer(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	return &Client{
+		AssistantID: "asst_test",
+		key:         "sk-test",
+		httpClient:  &http.Client{Transport: rewriteHost{target}},
+		vectorStore: map[string]string{},
+		lastFile:    map[string]LastFileInfo{},
+	}
+}
+
+type rewriteHost struct{ target *url.URL }
+
+func (r rewriteHost) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func writeEvent(w http.ResponseWriter, event string, data any) {
+	b, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	w.(http.Flusher).Flush()
+}
+
+func TestStreamResponseWithInstructions_ForwardsDeltas(t *testing.T) {
+	release := make(chan struct{})
+	c := sseServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		if r.URL.Path != "/v1/responses" || payload["stream"] != true || r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("unexpected request %s stream=%v", r.URL.Path, payload["stream"])
+		}
+		writeEvent(w, "response.created", map[string]any{"type": "response.created", "response": map[string]any{"id": "resp_1"}})
+		writeEvent(w, "response.output_text.delta", map[string]any{"type": "response.output_text.delta", "delta": "La dosis es "})
+		<-release // the first delta must reach the caller before the response finishes
+		for _, d := range []string{"5 mg【4†gu", "ia.pdf】.", "  "} {
+			writeEvent(w, "response.output_text.delta", map[string]any{"type": "response.output_text.delta", "delta": d})
+		}
+		writeEvent(w, "response.completed", map[string]any{"type": "response.completed", "response": map[string]any{"id": "resp_1"}})
+	})
+
+	ch, err := c.StreamResponseWithInstructions(context.Background(), "conv_1", "¿Dosis?", "", "vs_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case first := <-ch:
+		if first != "La dosis es" {
+			t.Fatalf("first chunk = %q", first)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first delta was not forwarded before completion")
+	}
+	close(release)
+	rest := mockRead(t, ch, nil)
+	if rest != " 5 mg." {
+		t.Fatalf("rest = %q", rest)
+	}
+}
+
+func TestStreamAssistantJSONCompatible_ResponsesSingleChunk(t *testing.T) {
+	c := sseServer(t, func(w http.ResponseWriter, r *http.Request) {
+		for _, d := range []string{`{"respuesta":`, `{"text":"ok"}`, `}`} {
+			writeEvent(w, "response.output_text.delta", map[string]any{"type": "response.output_text.delta", "delta": d})
+		}
+		writeEvent(w, "response.completed", map[string]any{"type": "response.completed", "response": map[string]any{"id": "resp_2"}})
+	})
+	c.useResponsesAPI = true
+	ch, err := c.StreamAssistantJSONCompatible(context.Background(), "conv_1", "Paciente", "JSON", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := <-ch; first != `{"respuesta":{"text":"ok"}}` {
+		t.Fatalf("JSON consumers read one chunk, got %q", first)
+	}
+}
+
+func TestStreamAssistantWithInstructions_RunDeltas(t *testing.T) {
+	attempts := 0
+	c := sseServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/threads/thread_1/messages":
+			w.Write([]byte(`{"id":"msg_1"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/threads/thread_1/runs":
+			w.Write([]byte(`{"data":[]}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/threads/thread_1/runs":
+			var payload map[string]any
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload["stream"] != true || r.Header.Get("OpenAI-Beta") != "assistants=v2" {
+				t.Errorf("run payload = %v", payload)
+			}
+			attempts++
+			writeEvent(w, "thread.run.created", map[string]any{"id": fmt.Sprintf("run_%d", attempts), "status": "queued"})
+			if attempts == 1 { // server_error before any text is retried
+				writeEvent(w, "thread.run.failed", map[string]any{"id": "run_1", "status": "failed",
+					"last_error": map[string]any{"code": "server_error", "message": "boom"}})
+				return
+			}
+			for _, d := range []string{"Según la guía", "fileciteturn0file1 la meta es ", "<130/80."} {
+				writeEvent(w, "thread.message.delta", map[string]any{"delta": map[string]any{
+					"content": []map[string]any{{"index": 0, "type": "text", "text": map[string]any{"value": d}}}}})
+			}
+			writeEvent(w, "thread.run.completed", map[string]any{"id": "run_2", "status": "completed"})
+			fmt.Fprint(w, "event: done\ndata: [DONE]\n\n")
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+
+	ch, err := c.StreamAssistantWithInstructions(context.Background(), "thread_1", "¿Meta de PA?", "Responde con la guía.", "vs_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []string
+	for s := range ch {
+		chunks = append(chunks, s)
+	}
+	if got := strings.Join(chunks, ""); got != "Según la guía la meta es <130/80." || len(chunks) < 2 || attempts != 2 {
+		t.Fatalf("chunks = %q, attempts = %d", chunks, attempts)
+	}
+}
+
+// blockingRunServer streams one delta and then waits until the client goes away; cancels records
+// the OpenAI cancel calls it receives.
+func blockingRunServer(t *testing.T, cancels chan<- string) *Client {
+	return sseServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/cancel"):
+			cancels <- r.URL.Path
+			w.Write([]byte(`{"status":"cancelling"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/threads/thread_1/messages":
+			w.Write([]byte(`{"id":"msg_1"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/threads/thread_1/runs":
+			w.Write([]byte(`{"data":[]}`))
+		case r.URL.Path == "/v1/threads/thread_1/runs":
+			writeEvent(w, "thread.run.created", map[string]any{"id": "run_1", "status": "queued"})
+			writeEvent(w, "thread.message.delta", map[string]any{"delta": map[string]any{
+				"content": []map[string]any{{"type": "text", "text": map[string]any{"value": "Primera parte. "}}}}})
+			<-r.Context().Done()
+		case r.URL.Path == "/v1/responses":
+			writeEvent(w, "response.created", map[string]any{"type": "response.created", "response": map[string]any{"id": "resp_1"}})
+			writeEvent(w, "response.output_text.delta", map[string]any{"type": "response.output_text.delta", "delta": "Primera parte. "})
+			<-r.Context().Done()
+		}
+	})
+}
+
+func expectCancelled(t *testing.T, ch <-chan string, cancels <-chan string, path string) {
+	t.Helper()
+	for range ch {
+	}
+	select {
+	case got := <-cancels:
+		if got != path {
+			t.Fatalf("cancel call = %s, want %s", got, path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no cancel call for %s", path)
+	}
+}
+
+func TestCancelRun_StopsRunAndCancelsUpstream(t *testing.T) {
+	cancels := make(chan string, 4)
+	c := blockingRunServer(t, cancels)
+
+	ch, err := c.StreamAssistantWithInstructions(context.Background(), "thread_1", "¿Meta?", "Responde.", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := <-ch; first != "Primera parte." {
+		t.Fatalf("first chunk = %q", first)
+	}
+	if ok, err := c.CancelRun(context.Background(), "thread_1"); !ok || err != nil {
+		t.Fatalf("CancelRun = %v, %v", ok, err)
+	}
+	expectCancelled(t, ch, cancels, "/v1/threads/thread_1/runs/run_1/cancel")
+	if ok, _ := c.CancelRun(context.Background(), "conv_idle"); ok {
+		t.Fatal("CancelRun without a run in progress should report false")
+	}
+}
+
+func TestClientDisconnect_CancelsResponse(t *testing.T) {
+	cancels := make(chan string, 4)
+	c := blockingRunServer(t, cancels)
+
+	// A handler deadline does not stop the response; only the client going away does.
+	reqCtx, disconnect := context.WithCancel(context.Background())
+	reqCtx = WithClientContext(reqCtx)
+	handlerCtx, handlerCancel := context.WithTimeout(reqCtx, time.Millisecond)
+	defer handlerCancel()
+	ch, err := c.StreamResponseWithInstructions(handlerCtx, "conv_1", "¿Meta?", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := <-ch; first != "Primera parte." {
+		t.Fatalf("first chunk = %q", first)
+	}
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-cancels:
+		t.Fatal("handler deadline cancelled the response")
+	default:
+	}
+	disconnect()
+	expectCancelled(t, ch, cancels, "/v1/responses/resp_1/cancel")
+}
@@ -0,0 +1,282 @@
+package openai
+
+// Function calling en la Responses API: el modelo puede invocar tools del backend (PubMed,
+// biblioteca de libros, historial de cuestionarios del usuario, calculadoras clínicas). Los
+// argumentos llegan como JSON, la tool se ejecuta aquí con timeout y su resultado se envía de
+// vuelta como function_call_output hasta que el modelo produce la respuesta final.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ema-backend/knowledge"
+)
+
+const (
+	// toolTimeout acota cada tool que no define Timeout.
+	toolTimeout = 20 * time.Second
+	// maxToolRounds limita las idas y vueltas modelo → tools; después se pide la respuesta final.
+	maxToolRounds = 4
+	// maxToolOutput recorta resultados grandes antes de devolverlos al modelo.
+	maxToolOutput = 12000
+)
+
+// Tool es una función del backend que el modelo puede invocar.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON Schema de los argumentos
+	Timeout     time.Duration  // 0 = toolTimeout
+	// Run recibe los argumentos tal como los generó el modelo; el resultado se serializa a JSON.
+	Run func(ctx context.Context, args json.RawMessage) (any, error)
+}
+
+// functionCall es un item function_call del output de un response.
+type functionCall struct {
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// toolRegistry guarda las tools registradas en un Client.
+type toolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+// RegisterTool añade (o reemplaza) una tool disponible para StreamResponseWithInstructions.
+func (c *Client) RegisterTool(t Tool) {
+	c.tools.mu.Lock()
+	defer c.tools.mu.Unlock()
+	if c.tools.tools == nil {
+		c.tools.tools = map[string]Tool{}
+	}
+	c.tools.tools[t.Name] = t
+}
+
+// toolsFor devuelve las tools habilitadas en ctx (ver WithoutTools), ordenadas por nombre.
+func (c *Client) toolsFor(ctx context.Context) []Tool {
+	c.tools.mu.RLock()
+	defer c.tools.mu.RUnlock()
+	disabled := disabledTools(ctx)
+	tools := make([]Tool, 0, len(c.tools.tools))
+	for name, t := range c.tools.tools {
+		if !disabled[name] {
+			tools = append(tools, t)
+		}
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+func (c *Client) tool(name string) (Tool, bool) {
+	c.tools.mu.RLock()
+	defer c.tools.mu.RUnlock()
+	t, ok := c.tools.tools[name]
+	return t, ok
+}
+
+// functionTools traduce tools al formato "function" de la Responses API.
+func functionTools(tools []Tool) []map[string]any {
+	defs := make([]map[string]any, 0, len(tools))
+	for _, t := range tools {
+		params := t.Parameters
+		if params == nil {
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		defs = append(defs, map[string]any{
+			"type":        "function",
+			"name":        t.Name,
+			"description": t.Description,
+			"parameters":  params,
+		})
+	}
+	return defs
+}
+
+// runTools ejecuta en paralelo las llamadas de un response y devuelve los function_call_output
+// en el mismo orden. Los errores se devuelven al modelo como {"error": ...} para que pueda
+// seguir sin esa información.
+func (c *Client) runTools(ctx context.Context, conversationID string, calls []functionCall) []map[string]any {
+	outputs := make([]map[string]any, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			result, err := c.runTool(ctx, call)
+			out := toolOutput(result, err)
+			log.Printf("[responses][tools][call] conversation=%s name=%s ms=%d output_len=%d err=%v",
+				conversationID, call.Name, time.Since(start).Milliseconds(), len(out), err)
+			outputs[i] = map[string]any{"type": "function_call_output", "call_id": call.CallID, "output": out}
+		}()
+	}
+	wg.Wait()
+	return outputs
+}
+
+func (c *Client) runTool(ctx context.Context, call functionCall) (result any, err error) {
+	t, ok := c.tool(call.Name)
+	if !ok || disabledTools(ctx)[call.Name] {
+		return nil, fmt.Errorf("tool no disponible: %s", call.Name)
+	}
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = toolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("tool %s: panic: %v", call.Name, r)
+		}
+	}()
+	args := json.RawMessage(call.Arguments)
+	if strings.TrimSpace(call.Arguments) == "" {
+		args = json.RawMessage("{}")
+	}
+	result, err = t.Run(ctx, args)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("tool %s: tiempo agotado (%v)", call.Name, timeout)
+	}
+	return result, err
+}
+
+func toolOutput(result any, err error) string {
+	if err != nil {
+		result = map[string]string{"error": err.Error()}
+	}
+	var out string
+	if s, ok := result.(string); ok {
+		out = s
+	} else if b, merr := json.Marshal(result); merr != nil {
+		out = fmt.Sprintf(`{"error":%q}`, merr.Error())
+	} else {
+		out = string(b)
+	}
+	if len(out) > maxToolOutput {
+		out = truncateUTF8(out, maxToolOutput) + "…[recortado]"
+	}
+	return out
+}
+
+// truncateUTF8 recorta s a como mucho n bytes sin partir un carácter.
+func truncateUTF8(s string, n int) string {
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// registerBuiltinTools registra las tools que solo dependen del Client.
+func (c *Client) registerBuiltinTools() {
+	queryArgs := map[string]any{
+		"type":     "object",
+		"required": []string{"query"},
+		"properties": map[string]any{
+			"query": map[string]any{"type": "string", "description": "Consulta clínica concreta"},
+		},
+	}
+	c.RegisterTool(Tool{
+		Name: "pubmed_search",
+		Description: "Busca artículos recientes (2020+) en PubMed: revisiones sistemáticas, meta-análisis y guías. " +
+			"Devuelve título, autores, revista, año, PMID/DOI y resumen.",
+		Parameters: queryArgs,
+		Timeout:    30 * time.Second,
+		Run: func(ctx context.Context, args json.RawMessage) (any, error) {
+			var a struct {
+				Query string `json:"query"`
+			}
+			if err := json.Unmarshal(args, &a); err != nil || strings.TrimSpace(a.Query) == "" {
+				return nil, errors.New("query requerido")
+			}
+			text, err := c.SearchPubMed(ctx, a.Query)
+			if err != nil {
+				return nil, err
+			}
+			if strings.TrimSpace(text) == "" {
+				return map[string]string{"resultado": "sin resultados en PubMed"}, nil
+			}
+			return text, nil
+		},
+	})
+	c.RegisterTool(Tool{
+		Name:        "book_search",
+		Description: "Busca en la biblioteca interna de libros de medicina y devuelve el fragmento más relevante con su fuente.",
+		Parameters:  queryArgs,
+		Run: func(ctx context.Context, args json.RawMessage) (any, error) {
+			var a struct {
+				Query string `json:"query"`
+			}
+			if err := json.Unmarshal(args, &a); err != nil || strings.TrimSpace(a.Query) == "" {
+				return nil, errors.New("query requerido")
+			}
+			res, err := c.SearchInVectorStoreWithMetadata(ctx, knowledge.VectorStoreFor(knowledge.FlowChat), a.Query)
+			if err != nil {
+				return nil, err
+			}
+			if res == nil || !res.HasResult {
+				return map[string]string{"resultado": "sin resultados en la biblioteca"}, nil
+			}
+			return map[string]string{"fuente": res.Source, "seccion": res.Section, "contenido": res.Content}, nil
+		},
+	})
+	c.RegisterTool(Tool{
+		Name:        "clinical_calculator",
+		Description: "Calculadoras clínicas: " + strings.Join(calculatorNames(), ", ") + ". Indica la calculadora y los valores que pide.",
+		Parameters:  calculatorSchema(),
+		Timeout:     time.Second,
+		Run: func(ctx context.Context, args json.RawMessage) (any, error) {
+			var in CalculatorInput
+			if err := json.Unmarshal(args, &in); err != nil {
+				return nil, fmt.Errorf("argumentos inválidos: %v", err)
+			}
+			return Calculate(in)
+		},
+	})
+}
+
+type userIDKey struct{}
+
+// WithUserID marca ctx con el usuario autenticado del request, para las tools que consultan
+// sus datos (p. ej. el historial de cuestionarios).
+func WithUserID(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext devuelve el usuario marcado con WithUserID.
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(userIDKey{}).(int)
+	return id, ok && id > 0
+}
+
+type disabledToolsKey struct{}
+
+// WithoutTools deshabilita tools para los responses creados con ctx (p. ej. pubmed_search
+// cuando el plan del usuario no incluye PubMed).
+func WithoutTools(ctx context.Context, names ...string) context.Context {
+	disabled := map[string]bool{}
+	for name := range disabledTools(ctx) {
+		disabled[name] = true
+	}
+	for _, name := range names {
+		disabled[name] = true
+	}
+	return context.WithValue(ctx, disabledToolsKey{}, disabled)
+}
+
+func disabledTools(ctx context.Context) map[string]bool {
+	m, _ := ctx.Value(disabledToolsKey{}).(map[string]bool)
+	return m
+}
@@ -0,0 +1,119 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestResponseToolLoop(t *testing.T) {
+	var payloads []map[string]any
+	c := sseServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+		if len(payloads) == 1 {
+			writeEvent(w, "response.completed", map[string]any{"type": "response.completed", "response": map[string]any{
+				"id": "resp_1",
+				"output": []map[string]any{
+					{"type": "function_call", "call_id": "call_calc", "name": "clinical_calculator",
+						"arguments": `{"calculadora":"imc","peso_kg":70,"talla_cm":175}`},
+					{"type": "function_call", "call_id": "call_gone", "name": "pubmed_search", "arguments": `{"query":"x"}`},
+				},
+			}})
+			return
+		}
+		writeEvent(w, "response.output_text.delta", map[string]any{"type": "response.output_text.delta", "delta": "El IMC es 22,9."})
+		writeEvent(w, "response.completed", map[string]any{"type": "response.completed", "response": map[string]any{"id": "resp_2"}})
+	})
+	c.registerBuiltinTools()
+
+	ctx := WithoutTools(context.Background(), "pubmed_search")
+	ch, err := c.StreamResponseWithInstructions(ctx, "conv_1", "Peso 70 kg, talla 175 cm: ¿IMC?", "", "vs_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mockRead(t, ch, nil); got != "El IMC es 22,9." {
+		t.Fatalf("answer = %q", got)
+	}
+	if len(payloads) != 2 {
+		t.Fatalf("responses created = %d", len(payloads))
+	}
+
+	var names []string
+	for _, tool := range payloads[0]["tools"].([]any) {
+		tool := tool.(map[string]any)
+		name, _ := tool["name"].(string)
+		names = append(names, tool["type"].(string)+":"+name)
+	}
+	if got := strings.Join(names, ","); got != "file_search:,function:book_search,function:clinical_calculator" {
+		t.Fatalf("tools = %s", got)
+	}
+
+	second := payloads[1]
+	if second["conversation"] != "conv_1" {
+		t.Fatalf("follow-up response outside the conversation: %v", second)
+	}
+	outputs := second["input"].([]any)
+	calc := outputs[0].(map[string]any)
+	if calc["type"] != "function_call_output" || calc["call_id"] != "call_calc" || !strings.Contains(calc["output"].(string), `"valor":22.9`) {
+		t.Fatalf("calculator output = %v", calc)
+	}
+	// Disabled tools are not run even if the model asks for them.
+	if gone := outputs[1].(map[string]any); !strings.Contains(gone["output"].(string), "tool no disponible") {
+		t.Fatalf("disabled tool output = %v", gone)
+	}
+}
+
+func TestResponseToolLoop_ForcesFinalAnswer(t *testing.T) {
+	rounds := 0
+	c := sseServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		rounds++
+		if payload["tool_choice"] == "none" {
+			writeEvent(w, "response.output_text.delta", map[string]any{"type": "response.output_text.delta", "delta": "Listo."})
+			writeEvent(w, "response.completed", map[string]any{"type": "response.completed", "response": map[string]any{"id": "resp_final"}})
+			return
+		}
+		writeEvent(w, "response.completed", map[string]any{"type": "response.completed", "response": map[string]any{
+			"id":     "resp_loop",
+			"output": []map[string]any{{"type": "function_call", "call_id": "call_1", "name": "echo", "arguments": `{}`}},
+		}})
+	})
+	c.RegisterTool(Tool{Name: "echo", Run: func(ctx context.Context, args json.RawMessage) (any, error) { return "ok", nil }})
+
+	ch, err := c.StreamResponseWithInstructions(context.Background(), "conv_1", "hola", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mockRead(t, ch, nil); got != "Listo." || rounds != maxToolRounds+1 {
+		t.Fatalf("answer = %q after %d responses", got, rounds)
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	for _, tc := range []struct {
+		in   CalculatorInput
+		want float64
+	}{
+		{CalculatorInput{Calculadora: "imc", PesoKg: 70, TallaCm: 175}, 22.9},
+		{CalculatorInput{Calculadora: "cockcroft_gault", Edad: 60, PesoKg: 72, Creatinina: 1, Sexo: "F"}, 68},
+		{CalculatorInput{Calculadora: "presion_arterial_media", PAS: 120, PAD: 80}, 93.3},
+		{CalculatorInput{Calculadora: "anion_gap", Sodio: 140, Cloro: 104, Bicarbonato: 24}, 12},
+		{CalculatorInput{Calculadora: "calcio_corregido", Calcio: 8, Albumina: 2}, 9.6},
+	} {
+		got, err := Calculate(tc.in)
+		if err != nil || got.Valor != tc.want {
+			t.Errorf("%s = %v, %v; want %v", tc.in.Calculadora, got.Valor, err, tc.want)
+		}
+	}
+	if _, err := Calculate(CalculatorInput{Calculadora: "imc", PesoKg: 70}); err == nil || !strings.Contains(err.Error(), "talla_cm") {
+		t.Fatalf("missing talla: %v", err)
+	}
+	if _, err := Calculate(CalculatorInput{Calculadora: "apgar"}); err == nil {
+		t.Fatal("unknown calculator accepted")
+	}
+}
@@ -0,0 +1,335 @@
+package openai
+
+// Transporte HTTP compartido para las llamadas a OpenAI (doJSON, doJSONResponses, doMultipart,
+// doSSE) y PubMed: reintenta 429/5xx y errores de red con backoff exponencial con jitter
+// respetando Retry-After, y abre un circuit breaker por host tras fallos sostenidos. Agotados los
+// reintentos (o con el breaker abierto) devuelve un *UpstreamError, que cumple
+// errors.Is(err, ErrUpstreamUnavailable); los handlers lo traducen a 503 con RespondUnavailable.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrUpstreamUnavailable indica que OpenAI (o PubMed) no está disponible: reintentos agotados o
+// circuit breaker abierto.
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+// UpstreamError detalla el último fallo contra el upstream.
+type UpstreamError struct {
+	Host       string
+	Status     int           // último status HTTP (0 si fue error de red o breaker abierto)
+	RetryAfter time.Duration // cuándo tiene sentido reintentar (0 si el upstream no lo indicó)
+	Body       string        // fragmento saneado del body de error
+	Err        error         // error de red, si lo hubo
+}
+
+func (e *UpstreamError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%s unavailable: %v", e.Host, e.Err)
+	case e.Status != 0:
+		return fmt.Sprintf("%s unavailable: status=%d body=%s", e.Host, e.Status, e.Body)
+	}
+	return fmt.Sprintf("%s unavailable: circuit open", e.Host)
+}
+
+func (e *UpstreamError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrUpstreamUnavailable}
+	}
+	return []error{ErrUpstreamUnavailable, e.Err}
+}
+
+// RespondUnavailable escribe el 503 común (con header Retry-After) si err proviene de un upstream
+// no disponible y devuelve true; en otro caso no escribe nada.
+func RespondUnavailable(c *gin.Context, err error) bool {
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		return false
+	}
+	secs := 10
+	var ue *UpstreamError
+	if errors.As(err, &ue) && ue.RetryAfter > 0 {
+		secs = int((ue.RetryAfter + time.Second - 1) / time.Second)
+	}
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error":       "servicio de IA no disponible temporalmente, intenta de nuevo en unos segundos",
+		"code":        "upstream_unavailable",
+		"retry_after": secs,
+	})
+	return true
+}
+
+// retryConfig agrupa los parámetros del transporte (OPENAI_HTTP_MAX_RETRIES,
+// OPENAI_BREAKER_FAILURES, OPENAI_BREAKER_COOLDOWN_SEC).
+type retryConfig struct {
+	maxRetries      int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	maxRetryAfter   time.Duration // un Retry-After mayor no se espera: se devuelve al cliente
+	breakerFailures int           // requests fallidos consecutivos que abren el breaker
+	breakerCooldown time.Duration
+}
+
+func retryConfigFromEnv() retryConfig {
+	cfg := retryConfig{
+		maxRetries:      3,
+		baseDelay:       500 * time.Millisecond,
+		maxDelay:        8 * time.Second,
+		maxRetryAfter:   30 * time.Second,
+		breakerFailures: 5,
+		breakerCooldown: 30 * time.Second,
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("OPENAI_HTTP_MAX_RETRIES"))); err == nil && n >= 0 {
+		cfg.maxRetries = n
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("OPENAI_BREAKER_FAILURES"))); err == nil && n > 0 {
+		cfg.breakerFailures = n
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("OPENAI_BREAKER_COOLDOWN_SEC"))); err == nil && n > 0 {
+		cfg.breakerCooldown = time.Duration(n) * time.Second
+	}
+	return cfg
+}
+
+var (
+	sharedTransportOnce sync.Once
+	sharedTransport     *retryTransport
+)
+
+// upstreamTransport devuelve el transporte compartido por todos los clientes del proceso, de modo
+// que el estado del breaker es común a todos los handlers.
+func upstreamTransport() *retryTransport {
+	sharedTransportOnce.Do(func() {
+		base := http.DefaultTransport.(*http.Transport).Clone()
+		// Sin Client.Timeout (cortaría los streams SSE): se acota la espera de headers y el resto
+		// lo controla el contexto de cada request.
+		base.ResponseHeaderTimeout = 180 * time.Second
+		sharedTransport = newRetryTransport(base, retryConfigFromEnv())
+	})
+	return sharedTransport
+}
+
+type retryTransport struct {
+	base http.RoundTripper
+	cfg  retryConfig
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func newRetryTransport(base http.RoundTripper, cfg retryConfig) *retryTransport {
+	return &retryTransport{base: base, cfg: cfg, breakers: map[string]*breaker{}}
+}
+
+func (t *retryTransport) breaker(host string) *breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.breakers[host]
+	if b == nil {
+		b = &breaker{}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+// unavailable devuelve un *UpstreamError si el breaker de host está abierto, sin consumir el
+// request de prueba del estado half-open.
+func (t *retryTransport) unavailable(host string) error {
+	if wait := t.breaker(host).openFor(time.Now(), t.cfg); wait > 0 {
+		return &UpstreamError{Host: host, RetryAfter: wait}
+	}
+	return nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	b := t.breaker(host)
+	ok, probe, wait := b.acquire(time.Now(), t.cfg)
+	if !ok {
+		log.Printf("[http][breaker][open] host=%s %s %s retry_after=%v", host, req.Method, req.URL.Path, wait)
+		return nil, &UpstreamError{Host: host, RetryAfter: wait}
+	}
+	// Los bodies en streaming (multipart vía io.Pipe) no se pueden repetir: un solo intento.
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				b.record(outcomeNeutral, probe, t.cfg)
+				return nil, err
+			}
+			r = req.Clone(ctx)
+			r.Body = body
+		}
+		resp, err := t.base.RoundTrip(r)
+		var last *UpstreamError
+		switch {
+		case err != nil && ctx.Err() != nil:
+			b.record(outcomeNeutral, probe, t.cfg)
+			return nil, err
+		case err != nil:
+			last = &UpstreamError{Host: host, Err: err}
+		case !retryableStatus(resp.StatusCode):
+			b.record(outcomeSuccess, probe, t.cfg)
+			return resp, nil
+		default:
+			last = upstreamErrorFrom(host, resp)
+		}
+
+		wait := last.RetryAfter
+		if wait == 0 {
+			wait = backoff(t.cfg.baseDelay, t.cfg.maxDelay, attempt)
+		}
+		quota := last.Status == http.StatusTooManyRequests && strings.Contains(last.Body, "insufficient_quota")
+		if attempt >= t.cfg.maxRetries || !replayable || quota || wait > t.cfg.maxRetryAfter || b.openFor(time.Now(), t.cfg) > 0 {
+			b.record(outcomeFailure, probe, t.cfg)
+			log.Printf("[http][upstream][unavailable] %s %s attempts=%d err=%v", req.Method, req.URL.Path, attempt+1, last)
+			return nil, last
+		}
+		log.Printf("[http][retry] %s %s attempt=%d/%d wait=%v err=%v", req.Method, req.URL.Path, attempt+1, t.cfg.maxRetries, wait, last)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			b.record(outcomeNeutral, probe, t.cfg)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout ||
+		status >= 500 && status != http.StatusNotImplemented
+}
+
+// upstreamErrorFrom consume y cierra el body de una respuesta fallida.
+func upstreamErrorFrom(host string, resp *http.Response) *UpstreamError {
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &UpstreamError{
+		Host:       host,
+		Status:     resp.StatusCode,
+		RetryAfter: retryAfter(resp.Header, time.Now()),
+		Body:       sanitizeBody(string(b)),
+	}
+}
+
+// retryAfter interpreta retry-after-ms (OpenAI) y Retry-After (segundos o fecha HTTP).
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(h.Get("Retry-After-Ms")), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+		return 0
+	}
+	if at, err := http.ParseTime(v); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// backoff devuelve la espera del intento attempt (0-based): base·2^attempt acotado a limit, con
+// jitter en [d/2, d] para no sincronizar los reintentos de varias instancias.
+func backoff(base, limit time.Duration, attempt int) time.Duration {
+	d := limit
+	if attempt < 30 && base<<uint(attempt) < limit {
+		d = base << uint(attempt)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeNeutral // cancelado por el cliente: no dice nada del upstream
+)
+
+// breaker: cerrado mientras failures < breakerFailures; abierto durante breakerCooldown; después
+// half-open, deja pasar un único request de prueba que lo cierra o lo vuelve a abrir.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// acquire decide si el request puede salir; probe indica que es el request de prueba half-open.
+func (b *breaker) acquire(now time.Time, cfg retryConfig) (ok, probe bool, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < cfg.breakerFailures {
+		return true, false, 0
+	}
+	if now.Before(b.openUntil) {
+		return false, false, b.openUntil.Sub(now)
+	}
+	if b.probing {
+		return false, false, time.Second
+	}
+	b.probing = true
+	return true, true, 0
+}
+
+// openFor devuelve cuánto falta para que el breaker deje pasar requests (0 si no está abierto).
+func (b *breaker) openFor(now time.Time, cfg retryConfig) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures >= cfg.breakerFailures && now.Before(b.openUntil) {
+		return b.openUntil.Sub(now)
+	}
+	return 0
+}
+
+func (b *breaker) record(o outcome, probe bool, cfg retryConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch o {
+	case outcomeSuccess:
+		b.failures = 0
+	case outcomeFailure:
+		b.failures++
+		if b.failures >= cfg.breakerFailures {
+			if b.failures == cfg.breakerFailures || probe {
+				log.Printf("[http][breaker][open] failures=%d cooldown=%v", b.failures, cfg.breakerCooldown)
+			}
+			b.openUntil = time.Now().Add(cfg.breakerCooldown)
+		}
+	}
+	if probe {
+		b.probing = false
+	}
+}
+
+// upstreamDown devuelve el *UpstreamError del breaker de OpenAI si está abierto; lo usan los
+// flujos que hacen la primera llamada en una goroutine para fallar antes de abrir el stream.
+func (c *Client) upstreamDown() error {
+	if t, ok := c.httpClient.Transport.(*retryTransport); ok {
+		return t.unavailable("api.openai.com")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retryServer returns a client whose requests go through a retryTransport to h.
+func retryServer(t *testing.T, cfg retryConfig, h http.HandlerFunc) (*Client, *retryTransport) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	tr := newRetryTransport(rewriteHost{target}, cfg)
+	c := &Client{key: "sk-test", httpClient: &http.Client{Transport: tr}}
+	return c, tr
+}
+
+func testRetryConfig() retryConfig {
+	return retryConfig{
+		maxRetries:      3,
+		baseDelay:       time.Millisecond,
+		maxDelay:        5 * time.Millisecond,
+		maxRetryAfter:   time.Second,
+		breakerFailures: 2,
+		breakerCooldown: time.Hour,
+	}
+}
+
+func TestRetryTransport_HonorsRetryAfterAndReplaysBody(t *testing.T) {
+	var calls int32
+	var gaps []time.Duration
+	last := time.Now()
+	c, _ := retryServer(t, testRetryConfig(), func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload["q"] != "x" {
+			t.Errorf("attempt %d body = %v, %v", calls, payload, err)
+		}
+		gaps = append(gaps, time.Since(last))
+		last = time.Now()
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After-Ms", "60")
+			http.Error(w, `{"error":{"message":"rate limited"}}`, http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	})
+
+	resp, err := c.doJSON(context.Background(), http.MethodPost, "/threads", map[string]string{"q": "x"})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("doJSON = %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	if calls != 3 || gaps[1] < 60*time.Millisecond || gaps[2] < 60*time.Millisecond {
+		t.Fatalf("calls = %d, gaps = %v", calls, gaps)
+	}
+}
+
+func TestRetryTransport_ExhaustedRetriesAndBreaker(t *testing.T) {
+	var calls int32
+	c, tr := retryServer(t, testRetryConfig(), func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "overloaded", http.StatusBadGateway)
+	})
+
+	_, err := c.doJSONResponses(context.Background(), http.MethodPost, "/responses", map[string]string{})
+	var ue *UpstreamError
+	if !errors.Is(err, ErrUpstreamUnavailable) || !errors.As(err, &ue) || ue.Status != http.StatusBadGateway || calls != 4 {
+		t.Fatalf("err = %v, calls = %d", err, calls)
+	}
+
+	// A second failed request opens the breaker: later calls fail without reaching the server.
+	c.doJSONResponses(context.Background(), http.MethodPost, "/responses", map[string]string{})
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.doJSON(context.Background(), http.MethodGet, "/threads/thread_1", nil); !errors.As(err, &ue) || ue.RetryAfter <= 0 || atomic.LoadInt32(&calls) != 0 {
+		t.Fatalf("breaker open: err = %v, calls = %d", err, calls)
+	}
+	if _, err := c.StreamResponseWithInstructions(context.Background(), "conv_1", "hola", "", ""); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("streaming with the breaker open = %v", err)
+	}
+	if tr.unavailable("api.openai.com") == nil {
+		t.Fatal("unavailable reported the breaker closed")
+	}
+}
+
+func TestBreaker_HalfOpenProbe(t *testing.T) {
+	cfg := testRetryConfig()
+	cfg.breakerCooldown = time.Minute
+	var b breaker
+	now := time.Now()
+	for i := 0; i < cfg.breakerFailures; i++ {
+		_, probe, _ := b.acquire(now, cfg)
+		b.record(outcomeFailure, probe, cfg)
+	}
+	if ok, _, wait := b.acquire(now, cfg); ok || wait <= 0 {
+		t.Fatalf("open breaker let a request through (wait %v)", wait)
+	}
+
+	later := time.Now().Add(2 * time.Minute)
+	ok, probe, _ := b.acquire(later, cfg)
+	if !ok || !probe {
+		t.Fatal("half-open breaker should allow one probe")
+	}
+	if ok, _, _ := b.acquire(later, cfg); ok {
+		t.Fatal("half-open breaker allowed a second request while probing")
+	}
+	b.record(outcomeNeutral, probe, cfg) // a cancelled probe frees the slot
+	ok, probe, _ = b.acquire(later, cfg)
+	if !ok || !probe {
+		t.Fatal("probe slot not released after a cancelled probe")
+	}
+	b.record(outcomeSuccess, probe, cfg)
+	if ok, probe, _ := b.acquire(later, cfg); !ok || probe {
+		t.Fatal("successful probe should close the breaker")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		header, value string
+		want          time.Duration
+	}{
+		{"Retry-After", "7", 7 * time.Second},
+		{"Retry-After", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"Retry-After-Ms", "250", 250 * time.Millisecond},
+		{"Retry-After", "soon", 0},
+	} {
+		h := http.Header{}
+		h.Set(tc.header, tc.value)
+		if got := retryAfter(h, now); got != tc.want {
+			t.Errorf("%s: %s = %v, want %v", tc.header, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestRespondUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	err := &url.Error{Op: "Post", URL: "https://api.openai.com/v1/responses",
+		Err: &UpstreamError{Host: "api.openai.com", Status: 503, RetryAfter: 1500 * time.Millisecond}}
+	if !RespondUnavailable(c, err) {
+		t.Fatal("UpstreamError not recognised")
+	}
+	var body struct {
+		Code       string `json:"code"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" || body.Code != "upstream_unavailable" || body.RetryAfter != 2 {
+		t.Fatalf("503 = %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	if RespondUnavailable(c, errors.New("status=400")) {
+		t.Fatal("other errors must be left to the caller")
+	}
+}
@@ -53,7 +53,6 @@ func saveVectorStoreFile(m map[string]string) error {
 // DB adapters (simple key-value table)
 func loadVectorStoreDB(db *sql.DB) (map[string]string, error) {
 	m := map[string]string{}
-	_, _ = db.Exec(`CREATE TABLE IF NOT EXISTS ai_vectorstores (thread_id VARCHAR(191) PRIMARY KEY, vector_store_id VARCHAR(255))`)
 	rows, err := db.Query(`SELECT thread_id, vector_store_id FROM ai_vectorstores`)
 	if err != nil {
 		return m, nil
@@ -69,7 +68,6 @@ func loadVectorStoreDB(db *sql.DB) (map[string]string, error) {
 }
 
 func saveVectorStoreDB(db *sql.DB, m map[string]string) error {
-	_, _ = db.Exec(`CREATE TABLE IF NOT EXISTS ai_vectorstores (thread_id VARCHAR(191) PRIMARY KEY, vector_store_id VARCHAR(255))`)
 	tx, err := db.Begin()
 	if err != nil {
 		return err
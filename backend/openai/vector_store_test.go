@@ -63,3 +63,29 @@ func TestVectorStore(t *testing.T) {
 	}
 	t.Logf("Successfully added file to vector store")
 }
+
+func TestApplyChunkMetadata(t *testing.T) {
+	cases := []struct {
+		name        string
+		content     string
+		meta        map[string]any
+		wantPage    int
+		wantSection string
+	}{
+		{"metadata", "texto", map[string]any{"page": float64(12), "section": "Tratamiento"}, 12, "Tratamiento"},
+		{"page_only", "texto", map[string]any{"page": "7"}, 7, "Página 7"},
+		{"ocr_marker", "[Página 4]\nDosis pediátricas de amoxicilina.", nil, 4, "Página 4"},
+		{"ocr_marker_mostly_previous", "final largo de la página anterior con la mayor parte del texto.\n\n[Página 5]\nFin.", nil, 4, "Página 4"},
+		{"nothing", "texto sin marcas", map[string]any{}, 0, ""},
+	}
+	for _, tc := range cases {
+		r := &VectorSearchResult{Content: tc.content}
+		applyChunkMetadata(r, tc.meta)
+		if r.Page != tc.wantPage || r.Section != tc.wantSection {
+			t.Errorf("%s: page %d section %q, want %d %q", tc.name, r.Page, r.Section, tc.wantPage, tc.wantSection)
+		}
+	}
+	if m := chunkMetadata(map[string]any{"page": 1.0}, map[string]any{"page": 2.0, "section": "A"}); m["page"] != 1.0 || m["section"] != "A" {
+		t.Fatalf("chunkMetadata = %v", m)
+	}
+}
@@ -22,27 +22,32 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// getUserByID returns user by id
-func getUserByID(id int) *migrations.User {
-	row := migrations.GetUserByID(id)
-	return row
+// Handler serves the profile, overview and test history endpoints.
+type Handler struct {
+	users migrations.UserStore
+	subs  migrations.SubscriptionStore
+	stats migrations.StatsStore
+}
+
+func NewHandler(users migrations.UserStore, subs migrations.SubscriptionStore, stats migrations.StatsStore) *Handler {
+	return &Handler{users: users, subs: subs, stats: stats}
 }
 
 // RegisterRoutes registers profile endpoints
-func RegisterRoutes(r *gin.Engine) {
-	r.GET("/user-detail/:id", getProfile)
-	r.POST("/user-detail/:id", updateProfile)
+func (h *Handler) RegisterRoutes(r *gin.Engine) {
+	r.GET("/user-detail/:id", h.getProfile)
+	r.POST("/user-detail/:id", h.updateProfile)
 	// Aggregated overview endpoint to reduce multiple sequential fetches on app start.
-	r.GET("/user-overview/:id", getOverview)
+	r.GET("/user-overview/:id", h.getOverview)
 	// Test completion endpoint for statistics tracking
 	r.POST("/record-test", func(c *gin.Context) {
 		log.Printf("🔥🔥🔥 [MIDDLEWARE] POST /record-test received - Method=%s Path=%s RemoteAddr=%s",
 			c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
-		recordTest(c)
+		h.recordTest(c)
 	})
 }
 
-func getProfile(c *gin.Context) {
+func (h *Handler) getProfile(c *gin.Context) {
 	log.Printf("[PROFILE][GET] incoming request: path=%s headers=%v", c.Request.URL.Path, c.Request.Header)
 	auth := c.GetHeader("Authorization")
 	token := strings.TrimPrefix(auth, "Bearer ")
@@ -55,18 +60,18 @@ func getProfile(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido o expirado"})
 		return
 	}
-	user := migrations.GetUserByEmail(email)
+	user := h.users.GetUserByEmail(email)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
 		return
 	}
 
 	// Ensure user has at least a Free subscription so app quotas work
-	if err := migrations.EnsureFreeSubscriptionForUser(user.ID); err != nil {
+	if err := h.subs.EnsureFreeSubscription(user.ID); err != nil {
 		log.Printf("[PROFILE][GET] ensure free subscription failed for userID=%d: %v", user.ID, err)
 	}
 	// Attach user's latest subscription joined with plan as active_subscription
-	activeSub, err := migrations.GetActiveSubscriptionForUser(user.ID)
+	activeSub, err := h.subs.GetActiveSubscription(user.ID)
 	if err != nil {
 		log.Printf("[PROFILE][GET] fetch active subscription failed for userID=%d: %v", user.ID, err)
 	}
@@ -74,11 +79,8 @@ func getProfile(c *gin.Context) {
 	if activeSub != nil {
 		resp["active_subscription"] = activeSub
 		// Structured log for quota visibility
-		if sp, ok := activeSub["subscription_plan"].(map[string]interface{}); ok {
-			planName, _ := sp["name"].(string)
-			log.Printf("[PROFILE][QUOTA] user=%d plan=%s consultations=%v questionnaires=%v clinical_cases=%v files=%v", user.ID, planName,
-				activeSub["consultations"], activeSub["questionnaires"], activeSub["clinical_cases"], activeSub["files"])
-		}
+		log.Printf("[PROFILE][QUOTA] user=%d plan=%s consultations=%v questionnaires=%v clinical_cases=%v files=%v", user.ID, activeSub.Plan.Name,
+			activeSub.Consultations, activeSub.Questionnaires, activeSub.ClinicalCases, activeSub.Files)
 	}
 	log.Printf("[PROFILE][GET] success id=%d email=%s hasActiveSub=%t", user.ID, user.Email, activeSub != nil)
 	c.JSON(http.StatusOK, gin.H{"data": resp})
@@ -89,7 +91,7 @@ func getProfile(c *gin.Context) {
 // Response shape:
 // { data: { profile: {..user fields.., active_subscription: {...}}, stats: { clinical_cases_count, total_tests, test_progress, most_studied_category, chats } } }
 // (Maintains backward compatibility by not altering existing /user-detail response.)
-func getOverview(c *gin.Context) {
+func (h *Handler) getOverview(c *gin.Context) {
 	start := time.Now()
 	log.Printf("[OVERVIEW][GET] incoming request: path=%s", c.Request.URL.Path)
 	auth := c.GetHeader("Authorization")
@@ -103,53 +105,48 @@ func getOverview(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido o expirado"})
 		return
 	}
-	user := migrations.GetUserByEmail(email)
+	user := h.users.GetUserByEmail(email)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
 		return
 	}
 
 	// Ensure at least a free subscription
-	if err := migrations.EnsureFreeSubscriptionForUser(user.ID); err != nil {
+	if err := h.subs.EnsureFreeSubscription(user.ID); err != nil {
 		log.Printf("[OVERVIEW][GET] ensure free subscription failed for userID=%d: %v", user.ID, err)
 	}
-	activeSub, err := migrations.GetActiveSubscriptionForUser(user.ID)
+	activeSub, err := h.subs.GetActiveSubscription(user.ID)
 	if err != nil {
 		log.Printf("[OVERVIEW][GET] fetch active subscription failed userID=%d: %v", user.ID, err)
 	}
 	prof := userToMap(user)
 	if activeSub != nil {
 		// Detect anomaly: remaining zero but plan limits > 0
-		if sp, ok := activeSub["subscription_plan"].(map[string]interface{}); ok {
-			consZero := activeSub["consultations"].(int) == 0
-			clinZero := activeSub["clinical_cases"].(int) == 0
-			planCons, _ := sp["consultations"].(int)
-			planClin, _ := sp["clinical_cases"].(int)
-			planHasAny := (planCons > 0 || planClin > 0)
-			// Caso 1: ambos en cero pero plan tiene >0 en alguno -> reset completo
-			if consZero && clinZero && planHasAny {
-				log.Printf("[OVERVIEW][AUTO-RESET][DETECT] user_id=%d consultations=0 clinical_cases=0 plan_consultations=%v plan_clinical_cases=%v", user.ID, planCons, planClin)
-				if err := migrations.ResetActiveSubscriptionQuotas(user.ID); err != nil {
-					log.Printf("[OVERVIEW][AUTO-RESET][ERROR] user_id=%d err=%v", user.ID, err)
-				} else if refreshed, err2 := migrations.GetActiveSubscriptionForUser(user.ID); err2 == nil && refreshed != nil {
-					activeSub = refreshed
-				}
-			} else if clinZero && planClin > 0 { // Caso 2: solo clinical_cases en cero pero plan lo ofrece
-				log.Printf("[OVERVIEW][AUTO-REPAIR][CLINICAL_CASES_ONLY] user_id=%d plan_clinical_cases=%d", user.ID, planClin)
-				// Reparar solo clinical_cases usando actualización directa
-				if err := migrations.ResetActiveSubscriptionQuotas(user.ID); err != nil {
-					log.Printf("[OVERVIEW][AUTO-REPAIR][ERROR] user_id=%d err=%v", user.ID, err)
-				} else if refreshed, err2 := migrations.GetActiveSubscriptionForUser(user.ID); err2 == nil && refreshed != nil {
-					activeSub = refreshed
-				}
+		consZero := activeSub.Consultations == 0
+		clinZero := activeSub.ClinicalCases == 0
+		planCons := activeSub.Plan.Consultations
+		planClin := activeSub.Plan.ClinicalCases
+		planHasAny := (planCons > 0 || planClin > 0)
+		// Caso 1: ambos en cero pero plan tiene >0 en alguno -> reset completo
+		if consZero && clinZero && planHasAny {
+			log.Printf("[OVERVIEW][AUTO-RESET][DETECT] user_id=%d consultations=0 clinical_cases=0 plan_consultations=%v plan_clinical_cases=%v", user.ID, planCons, planClin)
+			if err := h.subs.ResetActiveSubscriptionQuotas(user.ID); err != nil {
+				log.Printf("[OVERVIEW][AUTO-RESET][ERROR] user_id=%d err=%v", user.ID, err)
+			} else if refreshed, err2 := h.subs.GetActiveSubscription(user.ID); err2 == nil && refreshed != nil {
+				activeSub = refreshed
+			}
+		} else if clinZero && planClin > 0 { // Caso 2: solo clinical_cases en cero pero plan lo ofrece
+			log.Printf("[OVERVIEW][AUTO-REPAIR][CLINICAL_CASES_ONLY] user_id=%d plan_clinical_cases=%d", user.ID, planClin)
+			// Reparar solo clinical_cases usando actualización directa
+			if err := h.subs.ResetActiveSubscriptionQuotas(user.ID); err != nil {
+				log.Printf("[OVERVIEW][AUTO-REPAIR][ERROR] user_id=%d err=%v", user.ID, err)
+			} else if refreshed, err2 := h.subs.GetActiveSubscription(user.ID); err2 == nil && refreshed != nil {
+				activeSub = refreshed
 			}
 		}
 		prof["active_subscription"] = activeSub
-		if sp, ok := activeSub["subscription_plan"].(map[string]interface{}); ok {
-			planName, _ := sp["name"].(string)
-			log.Printf("[OVERVIEW][QUOTA] user=%d plan=%s consultations=%v questionnaires=%v clinical_cases=%v files=%v", user.ID, planName,
-				activeSub["consultations"], activeSub["questionnaires"], activeSub["clinical_cases"], activeSub["files"])
-		}
+		log.Printf("[OVERVIEW][QUOTA] user=%d plan=%s consultations=%v questionnaires=%v clinical_cases=%v files=%v", user.ID, activeSub.Plan.Name,
+			activeSub.Consultations, activeSub.Questionnaires, activeSub.ClinicalCases, activeSub.Files)
 	}
 
 	// Calcular estadísticas reales basadas en los quotas de la suscripción
@@ -163,24 +160,16 @@ func getOverview(c *gin.Context) {
 	}
 
 	if activeSub != nil {
-		plan, _ := activeSub["subscription_plan"].(map[string]interface{})
-
 		// Clinical Cases
-		planClinical := plan["clinical_cases"].(int)
-		remainingClinical := activeSub["clinical_cases"].(int)
-		usedClinical := planClinical - remainingClinical
+		usedClinical := activeSub.Used("clinical_cases")
 		stats["clinical_cases_count"] = usedClinical
 
 		// Total Tests/Questionnaires
-		planTests := plan["questionnaires"].(int)
-		remainingTests := activeSub["questionnaires"].(int)
-		usedTests := planTests - remainingTests
+		usedTests := activeSub.Used("questionnaires")
 		stats["total_tests"] = usedTests
 
 		// Chats/Consultations (devuelto como array para compatibilidad)
-		planChats := plan["consultations"].(int)
-		remainingChats := activeSub["consultations"].(int)
-		usedChats := planChats - remainingChats
+		usedChats := activeSub.Used("consultations")
 		// Crear array de usedChats elementos para compatibilidad con frontend
 		chatsArray := make([]int, usedChats)
 		stats["chats"] = chatsArray
@@ -190,17 +179,17 @@ func getOverview(c *gin.Context) {
 	}
 
 	// Obtener estadísticas detalladas de test_history
-	if testProgress, err := migrations.GetTestProgress(user.ID, 10); err == nil && testProgress != nil {
+	if testProgress, err := h.stats.GetTestProgress(user.ID, 10); err == nil && testProgress != nil {
 		stats["test_progress"] = testProgress
-		log.Printf("[OVERVIEW][STATS] userID=%d test_progress_count=%d", user.ID, len(testProgress))
+		log.Printf("[OVERVIEW][STATS] userID=%d test_progress_count=%d", user.ID, len(testProgress.RecentTests))
 	}
 
-	if monthlyScores, err := migrations.GetMonthlyScores(user.ID); err == nil && monthlyScores != nil {
+	if monthlyScores, err := h.stats.GetMonthlyScores(user.ID); err == nil && monthlyScores != nil {
 		stats["monthly_scores"] = monthlyScores
 		log.Printf("[OVERVIEW][STATS] userID=%d monthly_scores_count=%d", user.ID, len(monthlyScores))
 	}
 
-	if mostStudied, err := migrations.GetMostStudiedCategory(user.ID); err == nil && mostStudied != nil {
+	if mostStudied, err := h.stats.GetMostStudiedCategory(user.ID); err == nil && mostStudied != nil {
 		stats["most_studied_category"] = mostStudied
 		log.Printf("[OVERVIEW][STATS] userID=%d most_studied=%v", user.ID, mostStudied)
 	}
@@ -210,7 +199,7 @@ func getOverview(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": gin.H{"profile": prof, "stats": stats}})
 }
 
-func updateProfile(c *gin.Context) {
+func (h *Handler) updateProfile(c *gin.Context) {
 	log.Printf("[PROFILE][POST] incoming request: path=%s headers=%v", c.Request.URL.Path, c.Request.Header)
 	idStr := c.Param("id")
 	idParam, _ := strconv.Atoi(idStr)
@@ -224,7 +213,7 @@ func updateProfile(c *gin.Context) {
 		return
 	}
 	// Load user by email
-	user := migrations.GetUserByEmail(email)
+	user := h.users.GetUserByEmail(email)
 	if user == nil {
 		log.Printf("[PROFILE][POST] session email found but user not in DB: %s", email)
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "Usuario no encontrado"})
@@ -294,7 +283,7 @@ func updateProfile(c *gin.Context) {
 				relPath = filepath.Base(dst)
 			}
 			rel := "/media/" + filepath.ToSlash(relPath)
-			if err := migrations.UpdateUserProfileImage(user.ID, rel); err != nil {
+			if err := h.users.UpdateUserProfileImage(user.ID, rel); err != nil {
 				log.Printf("[PROFILE][POST] failed updating DB with image path '%s' for userID=%d: %v", rel, user.ID, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"message": "No se pudo actualizar el usuario"})
 				return
@@ -309,14 +298,14 @@ func updateProfile(c *gin.Context) {
 			}
 
 			// Store Cloudinary URL in DB
-			if err := migrations.UpdateUserProfileImage(user.ID, imageURL); err != nil {
+			if err := h.users.UpdateUserProfileImage(user.ID, imageURL); err != nil {
 				log.Printf("[PROFILE][POST] failed updating DB with Cloudinary URL '%s' for userID=%d: %v", imageURL, user.ID, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"message": "No se pudo actualizar el usuario"})
 				return
 			}
 		}
 
-		updated := migrations.GetUserByID(user.ID)
+		updated := h.users.GetUserByID(user.ID)
 		log.Printf("[PROFILE][POST] image updated successfully for userID=%d", user.ID)
 		c.JSON(http.StatusOK, gin.H{"data": userToMap(updated)})
 		return
@@ -360,12 +349,12 @@ func updateProfile(c *gin.Context) {
 
 	log.Printf("[PROFILE][POST] update fields userID=%d first_name='%s' last_name='%s' city='%s' profession='%s' gender='%s' age=%v country_id=%v", user.ID, firstName, lastName, city, profession, gender, age, countryID)
 
-	if err := migrations.UpdateUserProfile(user.ID, firstName, lastName, city, profession, gender, age, countryID); err != nil {
+	if err := h.users.UpdateUserProfile(user.ID, firstName, lastName, city, profession, gender, age, countryID); err != nil {
 		log.Printf("[PROFILE][POST] DB update failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "No se pudo actualizar"})
 		return
 	}
-	updated := migrations.GetUserByID(user.ID)
+	updated := h.users.GetUserByID(user.ID)
 	ageVal := "nil"
 	if updated.Age != nil {
 		ageVal = fmt.Sprintf("%d", *updated.Age)
@@ -498,7 +487,7 @@ func uploadToCloudinary(file *multipart.FileHeader, userID int) (string, error)
 }
 
 // recordTest handles POST /record-test for recording test completions
-func recordTest(c *gin.Context) {
+func (h *Handler) recordTest(c *gin.Context) {
 	log.Printf("[RECORD_TEST] 🚀 INICIO - Received POST request to /record-test")
 	log.Printf("[RECORD_TEST] Headers: %v", c.Request.Header)
 
@@ -520,7 +509,7 @@ func recordTest(c *gin.Context) {
 	}
 
 	log.Printf("[RECORD_TEST] Token valid for email: %s", email)
-	user := migrations.GetUserByEmail(email)
+	user := h.users.GetUserByEmail(email)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
 		return
@@ -556,7 +545,7 @@ func recordTest(c *gin.Context) {
 		user.ID, payload.TestName, payload.ScoreObtained, payload.MaxScore, payload.CategoryID)
 
 	// Registrar en test_history
-	if err := migrations.RecordTestCompletion(user.ID, payload.CategoryID, payload.TestName, payload.ScoreObtained, payload.MaxScore); err != nil {
+	if err := h.stats.RecordTestCompletion(user.ID, payload.CategoryID, payload.TestName, payload.ScoreObtained, payload.MaxScore); err != nil {
 		log.Printf("[RECORD_TEST] ❌ Error recording test for userID=%d: %v", user.ID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo registrar el test", "details": err.Error()})
 		return
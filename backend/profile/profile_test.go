@@ -0,0 +1,112 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ema-backend/login"
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(t *testing.T) (*gin.Engine, *migrations.MemoryStore, *migrations.User, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store := migrations.NewMemoryStore()
+	store.FreePlan.ID = 1
+	store.FreePlan.Quotas = migrations.Quotas{Consultations: 10, Questionnaires: 5, ClinicalCases: 3, Files: 2}
+	store.AddCategory(4, "Cardiología")
+	u := store.AddUser(migrations.User{FirstName: "Ana", LastName: "Pérez", Email: "ana@example.com", Password: "pw"})
+	r := gin.New()
+	login.NewHandler(store).RegisterRoutes(r)
+	NewHandler(store, store, store).RegisterRoutes(r)
+	w := serve(r, http.MethodPost, "/login", "", login.Credentials{Email: u.Email, Password: "pw"})
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Token == "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	return r, store, u, res.Token
+}
+
+func serve(r *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestOverviewCreatesFreeSubscriptionAndStats(t *testing.T) {
+	r, store, u, token := newTestRouter(t)
+	cat := 4
+	for _, score := range []int{8, 6} {
+		body := gin.H{"category_id": cat, "test_name": "Quiz", "score_obtained": score, "max_score": 10}
+		if w := serve(r, http.MethodPost, "/record-test", token, body); w.Code != http.StatusOK {
+			t.Fatalf("record-test: %d %s", w.Code, w.Body)
+		}
+	}
+	sub, _ := store.GetActiveSubscription(u.ID)
+	if sub != nil {
+		t.Fatal("record-test must not create subscriptions")
+	}
+
+	w := serve(r, http.MethodGet, "/user-overview/1", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("overview: %d %s", w.Code, w.Body)
+	}
+	var res struct {
+		Data struct {
+			Profile struct {
+				Email              string                        `json:"email"`
+				ActiveSubscription migrations.ActiveSubscription `json:"active_subscription"`
+			} `json:"profile"`
+			Stats struct {
+				TestProgress        migrations.TestProgress     `json:"test_progress"`
+				MostStudiedCategory *migrations.StudiedCategory `json:"most_studied_category"`
+				MonthlyScores       []migrations.MonthlyScore   `json:"monthly_scores"`
+			} `json:"stats"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	p := res.Data.Profile
+	if p.Email != u.Email || p.ActiveSubscription.Plan.Consultations != 10 || p.ActiveSubscription.Consultations != 10 {
+		t.Errorf("profile = %+v", p)
+	}
+	st := res.Data.Stats
+	if st.TestProgress.Summary.TotalTests != 2 || st.TestProgress.Summary.AveragePercentage != 70 || len(st.TestProgress.RecentTests) != 2 {
+		t.Errorf("test_progress = %+v", st.TestProgress)
+	}
+	if st.MostStudiedCategory == nil || st.MostStudiedCategory.CategoryName != "Cardiología" || st.MostStudiedCategory.StudyCount != 2 {
+		t.Errorf("most_studied_category = %+v", st.MostStudiedCategory)
+	}
+	if len(st.MonthlyScores) != 1 || st.MonthlyScores[0].Points != 14 {
+		t.Errorf("monthly_scores = %+v", st.MonthlyScores)
+	}
+}
+
+func TestUpdateProfileKeepsEmptyFields(t *testing.T) {
+	r, store, u, token := newTestRouter(t)
+	w := serve(r, http.MethodPost, "/user-detail/1", token, gin.H{"city": "Lima", "age": 31})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	got := store.GetUserByID(u.ID)
+	if got.City != "Lima" || got.Age == nil || *got.Age != 31 || got.FirstName != "Ana" {
+		t.Errorf("user = %+v", got)
+	}
+	if w := serve(r, http.MethodGet, "/user-detail/1", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: %d", w.Code)
+	}
+}
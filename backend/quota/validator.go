@@ -9,7 +9,7 @@ import (
     "strconv"
 
     "ema-backend/login"
-    "ema-backend/subscriptions"
+    "ema-backend/migrations"
     "github.com/gin-gonic/gin"
 )
 
@@ -32,10 +32,13 @@ var flowField = map[string]string{
 
 // Validator provides quota validation wired into handlers.
 type Validator struct {
-    subs *subscriptions.Repository
+    subs  migrations.SubscriptionStore
+    users migrations.UserStore
 }
 
-func NewValidator(repo *subscriptions.Repository) *Validator { return &Validator{subs: repo} }
+func NewValidator(subs migrations.SubscriptionStore, users migrations.UserStore) *Validator {
+    return &Validator{subs: subs, users: users}
+}
 
 // ValidateAndConsume identifies user from Authorization token, fetches active subscription and decrements the mapped field by 1.
 func (v *Validator) ValidateAndConsume(ctx context.Context, c *gin.Context, flow string) error {
@@ -63,7 +66,7 @@ func (v *Validator) ValidateAndConsume(ctx context.Context, c *gin.Context, flow
         return errors.New("invalid session")
     }
     // Resolve user
-    u := userResolver(email)
+    u := v.users.GetUserByEmail(email)
     if u == nil {
     log.Printf("[quota][deny] flow=%s field=%s email=%s reason=user_not_found", flow, field, email)
         return errors.New("user not found")
@@ -79,7 +82,6 @@ func (v *Validator) ValidateAndConsume(ctx context.Context, c *gin.Context, flow
     }
     // Unlimited semantics: si el plan define un valor enorme (>=99999) para el campo, tratamos ese campo como ilimitado
     planUnlimited := func(f string) bool {
-        if sub.Plan == nil { return false }
         switch f {
         case "consultations": return sub.Plan.Consultations >= 99999
         case "questionnaires": return sub.Plan.Questionnaires >= 99999
@@ -96,20 +98,15 @@ func (v *Validator) ValidateAndConsume(ctx context.Context, c *gin.Context, flow
         return nil
     }
     // Campo individual en cero pero plan >0: opción de recarga puntual (DEV_REFILL_MISSING_FIELDS=1)
-    if os.Getenv("DEV_REFILL_MISSING_FIELDS") == "1" && sub.Plan != nil {
-        switch field {
-        case "consultations": if sub.Consultations == 0 && sub.Plan.Consultations > 0 { _ = v.subs.SetQuotaValue(sub.ID, field, sub.Plan.Consultations) }
-        case "questionnaires": if sub.Questionnaires == 0 && sub.Plan.Questionnaires > 0 { _ = v.subs.SetQuotaValue(sub.ID, field, sub.Plan.Questionnaires) }
-        case "clinical_cases": if sub.ClinicalCases == 0 && sub.Plan.ClinicalCases > 0 { _ = v.subs.SetQuotaValue(sub.ID, field, sub.Plan.ClinicalCases) }
-        case "files": if sub.Files == 0 && sub.Plan.Files > 0 { _ = v.subs.SetQuotaValue(sub.ID, field, sub.Plan.Files) }
-        }
+    if os.Getenv("DEV_REFILL_MISSING_FIELDS") == "1" {
+        if sub.Get(field) == 0 && sub.Plan.Get(field) > 0 { _ = v.subs.SetQuota(sub.ID, field, sub.Plan.Get(field)) }
         if ref, rerr := v.subs.GetActiveSubscription(u.ID); rerr == nil && ref != nil { sub = ref }
     }
     // Optional auto-reset for dev environments where legacy subscriptions have all zeros but plan has defaults.
     if os.Getenv("DEV_RESET_ZERO_QUOTAS") == "1" &&
         sub.Consultations == 0 && sub.Questionnaires == 0 && sub.ClinicalCases == 0 && sub.Files == 0 &&
-        sub.Plan != nil && (sub.Plan.Consultations > 0 || sub.Plan.Questionnaires > 0 || sub.Plan.ClinicalCases > 0 || sub.Plan.Files > 0) {
-        if err := v.subs.ResetSubscriptionQuotasToPlan(sub.ID); err == nil {
+        (sub.Plan.Consultations > 0 || sub.Plan.Questionnaires > 0 || sub.Plan.ClinicalCases > 0 || sub.Plan.Files > 0) {
+        if err := v.subs.ResetActiveSubscriptionQuotas(u.ID); err == nil {
             if ref, rerr := v.subs.GetActiveSubscription(u.ID); rerr == nil && ref != nil { sub = ref }
             log.Printf("[quota][auto_reset] user_id=%d sub_id=%d applied plan defaults", u.ID, sub.ID)
         } else {
@@ -117,12 +114,12 @@ func (v *Validator) ValidateAndConsume(ctx context.Context, c *gin.Context, flow
         }
     }
     // Optional single-field refill for consultations in dev: if consultations <=0 and plan has value.
-    if refill := os.Getenv("DEV_REFILL_CONSULTATIONS"); refill != "" && sub.Plan != nil && sub.Plan.Consultations > 0 {
+    if refill := os.Getenv("DEV_REFILL_CONSULTATIONS"); refill != "" && sub.Plan.Consultations > 0 {
         if sub.Consultations <= 0 {
             target := sub.Plan.Consultations
             // Allow override numeric env to cap the refill amount
             if n, err := strconv.Atoi(refill); err == nil && n > 0 && n < target { target = n }
-            if err := v.subs.SetQuotaValue(sub.ID, "consultations", target); err == nil {
+            if err := v.subs.SetQuota(sub.ID, "consultations", target); err == nil {
                 if ref, rerr := v.subs.GetActiveSubscription(u.ID); rerr == nil && ref != nil { sub = ref }
                 log.Printf("[quota][refill] user_id=%d sub_id=%d consultations_refilled=%d", u.ID, sub.ID, target)
             } else {
@@ -131,17 +128,7 @@ func (v *Validator) ValidateAndConsume(ctx context.Context, c *gin.Context, flow
         }
     }
     // Fast path check (after potential reset)
-    var remaining int
-    switch field {
-    case "clinical_cases":
-        remaining = sub.ClinicalCases
-    case "consultations":
-        remaining = sub.Consultations
-    case "questionnaires":
-        remaining = sub.Questionnaires
-    case "files":
-        remaining = sub.Files
-    }
+    remaining := sub.Get(field)
     if remaining <= 0 {
         // attach structured info so handler can format JSON
         c.Set("quota_error_field", field)
@@ -174,17 +161,6 @@ func tokenSummary(t string) string {
     return t[:4] + "..." + t[len(t)-4:]
 }
 
-// --- User resolver adapter ---
-// We keep this indirection to avoid tight coupling with migrations/internal user structures.
-
-var userResolver = func(email string) *UserLite { return nil }
-
-// RegisterUserResolver allows main to provide a resolver.
-func RegisterUserResolver(fn func(email string) *UserLite) { userResolver = fn }
-
-// UserLite minimal projection
-type UserLite struct { ID int; Email string }
-
 // Middleware helper (not used yet)
 func (v *Validator) Middleware(flow string) gin.HandlerFunc {
     return func(c *gin.Context) {
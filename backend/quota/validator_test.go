@@ -0,0 +1,74 @@
+package quota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ema-backend/login"
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenFor logs in through the login handler and returns the session token.
+func tokenFor(t *testing.T, store *migrations.MemoryStore, email, password string) string {
+	t.Helper()
+	r := gin.New()
+	login.NewHandler(store).RegisterRoutes(r)
+	b, _ := json.Marshal(login.Credentials{Email: email, Password: password})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(b)))
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Token == "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	return res.Token
+}
+
+func TestValidateAndConsume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := migrations.NewMemoryStore()
+	u := store.AddUser(migrations.User{Email: "doc@example.com", Password: "pw"})
+	plan := migrations.ActivePlan{ID: 1, Name: "Free", Quotas: migrations.Quotas{Consultations: 2, Files: 1}}
+	sub := store.AddSubscription(migrations.ActiveSubscription{UserID: u.ID, Plan: plan, Quotas: migrations.Quotas{Consultations: 1}})
+	token := tokenFor(t, store, u.Email, "pw")
+	v := NewValidator(store, store)
+
+	newCtx := func(tok string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		if tok != "" {
+			c.Request.Header.Set("Authorization", "Bearer "+tok)
+		}
+		return c
+	}
+
+	c := newCtx(token)
+	if err := v.ValidateAndConsume(context.Background(), c, "chat_message"); err != nil {
+		t.Fatalf("first consume: %v", err)
+	}
+	if got := c.GetInt("quota_remaining"); got != 0 {
+		t.Errorf("quota_remaining = %d, want 0", got)
+	}
+	got, _ := store.GetActiveSubscription(u.ID)
+	if got.ID != sub.ID || got.Consultations != 0 {
+		t.Fatalf("consultations after consume = %d", got.Consultations)
+	}
+
+	c = newCtx(token)
+	if err := v.ValidateAndConsume(context.Background(), c, "chat_message"); err == nil || c.GetString("quota_error_field") != "consultations" {
+		t.Fatalf("exhausted quota: err=%v field=%q", err, c.GetString("quota_error_field"))
+	}
+	if err := v.ValidateAndConsume(context.Background(), newCtx(""), "chat_message"); err == nil {
+		t.Fatal("missing token must be rejected")
+	}
+	if err := v.ValidateAndConsume(context.Background(), newCtx(""), "interactive_chat"); err != nil {
+		t.Fatalf("flows without quota are free: %v", err)
+	}
+}
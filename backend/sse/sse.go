@@ -1,6 +1,8 @@
 package sse
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"strings"
 
@@ -28,7 +30,11 @@ func Stream(c *gin.Context, ch <-chan string) {
 		return
 	}
 
-	for msg := range ch {
+	for {
+		msg, ok := Next(c.Request.Context(), ch)
+		if !ok {
+			break
+		}
 		// Soportar mensajes multi-línea: cada línea debe ir precedida por 'data: '
 		// para que el cliente SSE no pierda contenido entre saltos de línea.
 		// Además preservamos los '\n' originales añadiéndolos dentro del token excepto en la última línea.
@@ -44,7 +50,27 @@ func Stream(c *gin.Context, ch <-chan string) {
 		_, _ = c.Writer.Write([]byte("\n"))
 		flusher.Flush()
 	}
+	if c.Request.Context().Err() != nil {
+		return // cliente desconectado: no hay a quién escribir
+	}
 	// Write done marker
 	_, _ = c.Writer.Write([]byte("data: [DONE]\n\n"))
 	flusher.Flush()
 }
+
+// Next returns the next token of ch. ok is false once ch is closed or the client disconnects
+// (ctx is the request context). On disconnect the rest of ch is drained in the background so the
+// producers never block; they see the same cancellation and stop the upstream run.
+func Next(ctx context.Context, ch <-chan string) (msg string, ok bool) {
+	select {
+	case msg, ok = <-ch:
+		return msg, ok
+	case <-ctx.Done():
+	}
+	log.Printf("[sse] client disconnected: %v", ctx.Err())
+	go func() {
+		for range ch {
+		}
+	}()
+	return "", false
+}
@@ -8,53 +8,37 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// RegisterStubRoutes registers statistics endpoints
-func RegisterStubRoutes(r *gin.Engine) {
-	r.GET("/users/:id/clinical-cases-count", getClinicalCasesStats)
-	r.GET("/user/:id/total-tests", getTotalTestsStats)
-	r.GET("/user/:id/test-progress", getTestProgress)
-	r.GET("/user/:id/most-studied-category", getMostStudiedCategory)
-	r.GET("/chats/:id", getChatsStats)
+// Handler serves the usage statistics derived from the active subscription quotas.
+type Handler struct {
+	subs migrations.SubscriptionStore
 }
 
-func getClinicalCasesStats(c *gin.Context) {
-	userID, _ := strconv.Atoi(c.Param("id"))
-
-	sub, err := migrations.GetActiveSubscriptionForUser(userID)
-	if err != nil || sub == nil {
-		c.JSON(200, gin.H{"total": 0, "used": 0, "remaining": 0})
-		return
-	}
-
-	plan := sub["subscription_plan"].(map[string]interface{})
-	planLimit := plan["clinical_cases"].(int)
-	remaining := sub["clinical_cases"].(int)
-	used := planLimit - remaining
-
-	log.Printf("[STATS] Clinical Cases - UserID=%d Plan=%d Remaining=%d Used=%d", userID, planLimit, remaining, used)
+func NewHandler(subs migrations.SubscriptionStore) *Handler { return &Handler{subs: subs} }
 
-	c.JSON(200, gin.H{
-		"total":     planLimit,
-		"used":      used,
-		"remaining": remaining,
-	})
+// RegisterRoutes registers statistics endpoints
+func (h *Handler) RegisterRoutes(r *gin.Engine) {
+	r.GET("/users/:id/clinical-cases-count", h.getClinicalCasesStats)
+	r.GET("/user/:id/total-tests", h.getTotalTestsStats)
+	r.GET("/user/:id/test-progress", getTestProgress)
+	r.GET("/user/:id/most-studied-category", getMostStudiedCategory)
+	r.GET("/chats/:id", h.getChatsStats)
 }
 
-func getTotalTestsStats(c *gin.Context) {
+// usage responds total/used/remaining of a quota field of the user's active subscription.
+func (h *Handler) usage(c *gin.Context, field, label string) {
 	userID, _ := strconv.Atoi(c.Param("id"))
 
-	sub, err := migrations.GetActiveSubscriptionForUser(userID)
+	sub, err := h.subs.GetActiveSubscription(userID)
 	if err != nil || sub == nil {
 		c.JSON(200, gin.H{"total": 0, "used": 0, "remaining": 0})
 		return
 	}
 
-	plan := sub["subscription_plan"].(map[string]interface{})
-	planLimit := plan["questionnaires"].(int)
-	remaining := sub["questionnaires"].(int)
-	used := planLimit - remaining
+	planLimit := sub.Plan.Get(field)
+	remaining := sub.Get(field)
+	used := sub.Used(field)
 
-	log.Printf("[STATS] Tests/Questionnaires - UserID=%d Plan=%d Remaining=%d Used=%d", userID, planLimit, remaining, used)
+	log.Printf("[STATS] %s - UserID=%d Plan=%d Remaining=%d Used=%d", label, userID, planLimit, remaining, used)
 
 	c.JSON(200, gin.H{
 		"total":     planLimit,
@@ -63,27 +47,16 @@ func getTotalTestsStats(c *gin.Context) {
 	})
 }
 
-func getChatsStats(c *gin.Context) {
-	userID, _ := strconv.Atoi(c.Param("id"))
-
-	sub, err := migrations.GetActiveSubscriptionForUser(userID)
-	if err != nil || sub == nil {
-		c.JSON(200, gin.H{"total": 0, "used": 0, "remaining": 0})
-		return
-	}
-
-	plan := sub["subscription_plan"].(map[string]interface{})
-	planLimit := plan["consultations"].(int)
-	remaining := sub["consultations"].(int)
-	used := planLimit - remaining
+func (h *Handler) getClinicalCasesStats(c *gin.Context) {
+	h.usage(c, "clinical_cases", "Clinical Cases")
+}
 
-	log.Printf("[STATS] Chats/Consultations - UserID=%d Plan=%d Remaining=%d Used=%d", userID, planLimit, remaining, used)
+func (h *Handler) getTotalTestsStats(c *gin.Context) {
+	h.usage(c, "questionnaires", "Tests/Questionnaires")
+}
 
-	c.JSON(200, gin.H{
-		"total":     planLimit,
-		"used":      used,
-		"remaining": remaining,
-	})
+func (h *Handler) getChatsStats(c *gin.Context) {
+	h.usage(c, "consultations", "Chats/Consultations")
 }
 
 func getTestProgress(c *gin.Context) {
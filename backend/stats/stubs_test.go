@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ema-backend/migrations"
+	"ema-backend/openai"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUsageStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := migrations.NewMemoryStore()
+	plan := migrations.ActivePlan{ID: 1, Quotas: migrations.Quotas{Consultations: 30, Questionnaires: 10, ClinicalCases: 5}}
+	store.AddSubscription(migrations.ActiveSubscription{UserID: 7, Plan: plan, Quotas: migrations.Quotas{Consultations: 28, Questionnaires: 10, ClinicalCases: 2}})
+	r := gin.New()
+	NewHandler(store).RegisterRoutes(r)
+
+	cases := map[string]string{
+		"/users/7/clinical-cases-count": `{"remaining":2,"total":5,"used":3}`,
+		"/user/7/total-tests":           `{"remaining":10,"total":10,"used":0}`,
+		"/chats/7":                      `{"remaining":28,"total":30,"used":2}`,
+		"/chats/8":                      `{"remaining":0,"total":0,"used":0}`,
+	}
+	for path, want := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: %d %s, want %s", path, w.Code, w.Body, want)
+		}
+	}
+}
+
+func TestQuizHistoryTool(t *testing.T) {
+	store := migrations.NewMemoryStore()
+	store.RecordTestCompletion(7, nil, "Cardiología básica", 8, 10)
+	tool := QuizHistoryTool(store)
+
+	if _, err := tool.Run(context.Background(), json.RawMessage(`{}`)); err == nil {
+		t.Fatal("history without a user should fail")
+	}
+	out, err := tool.Run(openai.WithUserID(context.Background(), 7), json.RawMessage(`{"limit":5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(out)
+	if !strings.Contains(string(b), `"test_name":"Cardiología básica"`) || !strings.Contains(string(b), `"average_percentage":80`) {
+		t.Fatalf("history = %s", b)
+	}
+}
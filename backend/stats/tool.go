@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"ema-backend/migrations"
+	"ema-backend/openai"
+)
+
+// QuizHistoryTool lets the assistant read the user's questionnaire history (test_history) to
+// point out weak areas. The user comes from openai.WithUserID on the request context.
+func QuizHistoryTool(store migrations.StatsStore) openai.Tool {
+	return openai.Tool{
+		Name: "quiz_history",
+		Description: "Historial de cuestionarios del usuario: promedio general, últimos tests con puntaje y " +
+			"categoría, puntajes mensuales y categoría más estudiada.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"limit": map[string]any{"type": "integer", "description": "Cantidad de tests recientes (máx. 20)"},
+			},
+		},
+		Run: func(ctx context.Context, args json.RawMessage) (any, error) {
+			userID, ok := openai.UserIDFromContext(ctx)
+			if !ok {
+				return nil, errors.New("usuario no identificado: el historial requiere sesión")
+			}
+			var a struct {
+				Limit int `json:"limit"`
+			}
+			_ = json.Unmarshal(args, &a)
+			if a.Limit <= 0 || a.Limit > 20 {
+				a.Limit = 10
+			}
+			progress, err := store.GetTestProgress(userID, a.Limit)
+			if err != nil {
+				return nil, err
+			}
+			monthly, err := store.GetMonthlyScores(userID)
+			if err != nil {
+				return nil, err
+			}
+			top, err := store.GetMostStudiedCategory(userID)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"progreso":                progress,
+				"puntajes_mensuales":      monthly,
+				"categoria_mas_estudiada": top,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package subscriptions
+
+import (
+	"strings"
+	"time"
+)
+
+// Billing intervals accepted by Stripe recurring prices.
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// Checkout frequency codes sent by the Flutter app (see subscription_container.dart).
+// 0 keeps the plan default interval.
+const (
+	FrequencyDefault = 0
+	FrequencyMonthly = 1
+	FrequencyAnnual  = 2
+)
+
+// PlanPrice is an additional price point of a plan for a given billing interval
+// (e.g. an annual price with discount next to the default monthly one), optionally
+// restricted to one country and its currency (see pricing.go).
+type PlanPrice struct {
+	ID            int     `json:"id"`
+	PlanID        int     `json:"plan_id"`
+	CountryCode   string  `json:"country_code,omitempty"` // "" = default price for every country
+	Currency      string  `json:"currency,omitempty"`     // "" = plan currency
+	Interval      string  `json:"interval"`
+	IntervalCount int     `json:"interval_count"`
+	Price         float64 `json:"price"`
+	StripePriceID string  `json:"stripe_price_id,omitempty"`
+}
+
+// NormalizeInterval maps free-form labels ("Mensual", "Anual", "monthly"...) to a Stripe interval.
+// Unknown or empty values fall back to monthly.
+func NormalizeInterval(v string) string {
+	s := strings.ToLower(strings.TrimSpace(v))
+	switch s {
+	case IntervalDay, "daily", "diario", "dia", "día":
+		return IntervalDay
+	case IntervalWeek, "weekly", "semanal", "semana":
+		return IntervalWeek
+	case IntervalYear, "yearly", "annual", "anual", "año", "ano":
+		return IntervalYear
+	case IntervalMonth, "monthly", "mensual", "mes", "m":
+		return IntervalMonth
+	}
+	if strings.HasPrefix(s, "anual") || strings.HasPrefix(s, "annual") {
+		return IntervalYear
+	}
+	return IntervalMonth
+}
+
+// IntervalForFrequency translates a checkout frequency code into an interval.
+// ok=false means the caller should use the plan default.
+func IntervalForFrequency(freq int) (interval string, ok bool) {
+	switch freq {
+	case FrequencyMonthly:
+		return IntervalMonth, true
+	case FrequencyAnnual:
+		return IntervalYear, true
+	}
+	return "", false
+}
+
+// FrequencyForInterval is the inverse of IntervalForFrequency, used to keep the
+// legacy `frequency` column meaningful for the app.
+func FrequencyForInterval(interval string) int {
+	switch NormalizeInterval(interval) {
+	case IntervalYear:
+		return FrequencyAnnual
+	case IntervalMonth:
+		return FrequencyMonthly
+	}
+	return FrequencyDefault
+}
+
+// AddInterval advances t by count billing intervals (negative count moves back).
+func AddInterval(t time.Time, interval string, count int) time.Time {
+	if count == 0 {
+		count = 1
+	}
+	switch NormalizeInterval(interval) {
+	case IntervalDay:
+		return t.AddDate(0, 0, count)
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7*count)
+	case IntervalYear:
+		return t.AddDate(count, 0, 0)
+	}
+	return t.AddDate(0, count, 0)
+}
+
+// normalizePlanBilling fills Interval/IntervalCount from the display label when missing.
+func normalizePlanBilling(p *Plan) {
+	if p.Interval == "" {
+		p.Interval = NormalizeInterval(p.Billing)
+	} else {
+		p.Interval = NormalizeInterval(p.Interval)
+	}
+	if p.IntervalCount <= 0 {
+		p.IntervalCount = 1
+	}
+}
+
+// ResolvePrice selects the default (country-independent) price point for a checkout
+// frequency. The plan's own price is returned (ID=0) when the frequency matches its
+// default interval or no specific price exists; ok=false when an explicit frequency
+// has no price. The result always carries its currency.
+func (p *Plan) ResolvePrice(freq int) (PlanPrice, bool) {
+	base := PlanPrice{PlanID: p.ID, Currency: p.Currency, Interval: NormalizeInterval(p.Interval), IntervalCount: p.IntervalCount, Price: p.Price, StripePriceID: p.StripePriceID}
+	if base.IntervalCount <= 0 {
+		base.IntervalCount = 1
+	}
+	want, ok := IntervalForFrequency(freq)
+	if !ok || want == base.Interval {
+		return base, true
+	}
+	for _, pp := range p.Prices {
+		if pp.CountryCode == "" && NormalizeInterval(pp.Interval) == want {
+			return p.withCurrency(pp), true
+		}
+	}
+	return PlanPrice{}, false
+}
+
+// withCurrency fills the plan currency on price points that don't set their own.
+func (p *Plan) withCurrency(pp PlanPrice) PlanPrice {
+	if pp.Currency == "" {
+		pp.Currency = p.Currency
+	}
+	return pp
+}
@@ -0,0 +1,58 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeInterval(t *testing.T) {
+	cases := map[string]string{
+		"Mensual": IntervalMonth,
+		"":        IntervalMonth,
+		"Anual":   IntervalYear,
+		"annual":  IntervalYear,
+		"Semanal": IntervalWeek,
+		"day":     IntervalDay,
+		"raro":    IntervalMonth,
+	}
+	for in, want := range cases {
+		if got := NormalizeInterval(in); got != want {
+			t.Errorf("NormalizeInterval(%q)=%q want %q", in, got, want)
+		}
+	}
+}
+
+func TestAddInterval(t *testing.T) {
+	start := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	if got := AddInterval(start, IntervalYear, 1); !got.Equal(time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("year: got %v", got)
+	}
+	if got := AddInterval(start, IntervalMonth, 3); !got.Equal(time.Date(2025, 4, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("3 months: got %v", got)
+	}
+	if got := AddInterval(start, IntervalWeek, 0); !got.Equal(start.AddDate(0, 0, 7)) {
+		t.Fatalf("week with count 0 should default to 1: got %v", got)
+	}
+}
+
+func TestPlanResolvePrice(t *testing.T) {
+	p := &Plan{ID: 2, Price: 9.99, Interval: IntervalMonth, IntervalCount: 1, StripePriceID: "price_m",
+		Prices: []PlanPrice{{ID: 7, PlanID: 2, Interval: IntervalYear, IntervalCount: 1, Price: 99, StripePriceID: "price_y"}}}
+
+	base, ok := p.ResolvePrice(FrequencyDefault)
+	if !ok || base.ID != 0 || base.StripePriceID != "price_m" {
+		t.Fatalf("default frequency should resolve plan price, got %+v ok=%v", base, ok)
+	}
+	monthly, ok := p.ResolvePrice(FrequencyMonthly)
+	if !ok || monthly.Price != 9.99 {
+		t.Fatalf("monthly should resolve plan price, got %+v", monthly)
+	}
+	annual, ok := p.ResolvePrice(FrequencyAnnual)
+	if !ok || annual.ID != 7 || annual.Price != 99 {
+		t.Fatalf("annual should resolve alternative price, got %+v", annual)
+	}
+	p.Prices = nil
+	if _, ok := p.ResolvePrice(FrequencyAnnual); ok {
+		t.Fatalf("annual without price must not resolve")
+	}
+}
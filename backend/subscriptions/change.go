@@ -0,0 +1,254 @@
+package subscriptions
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Quotas is the set of usage counters carried by a subscription.
+type Quotas struct {
+	Consultations  int `json:"consultations"`
+	Questionnaires int `json:"questionnaires"`
+	ClinicalCases  int `json:"clinical_cases"`
+	Files          int `json:"files"`
+}
+
+// Quota rules applied when a plan changes mid-cycle (PLAN_CHANGE_QUOTA_RULE).
+const (
+	// QuotaRuleReset grants the full limits of the new plan.
+	QuotaRuleReset = "reset"
+	// QuotaRuleCarryOver keeps usage of the current period: remaining + (new limit - old limit).
+	QuotaRuleCarryOver = "carryover"
+	// QuotaRuleProrate keeps the remaining quota and adds the limit difference scaled by the
+	// fraction of the period left, mirroring Stripe's money proration. Default.
+	QuotaRuleProrate = "prorate"
+)
+
+// Plan change outcomes returned by POST /me/subscription/change.
+const (
+	PlanChangeApplied          = "changed"
+	PlanChangeScheduled        = "scheduled"
+	PlanChangeCheckoutRequired = "checkout_required"
+	PlanChangeUnchanged        = "unchanged"
+)
+
+var ErrNoActiveSubscription = errors.New("no_active_subscription")
+
+// PlanChangeResult describes what happened to the subscription.
+type PlanChangeResult struct {
+	Status         string     `json:"status"`
+	SubscriptionID int        `json:"subscription_id"`
+	PlanID         int        `json:"plan_id"`
+	Interval       string     `json:"interval,omitempty"`
+	EffectiveAt    *time.Time `json:"effective_at,omitempty"`
+	Quotas         *Quotas    `json:"quotas,omitempty"`
+	CheckoutURL    string     `json:"checkout_url,omitempty"`
+	SessionID      string     `json:"session_id,omitempty"`
+}
+
+func quotaRuleFromEnv() string {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("PLAN_CHANGE_QUOTA_RULE"))); v {
+	case QuotaRuleReset, QuotaRuleCarryOver, QuotaRuleProrate:
+		return v
+	}
+	return QuotaRuleProrate
+}
+
+// unlimitedQuota mirrors the quota validator semantics (>=99999, files >=9999).
+func unlimitedQuota(field string, v int) bool {
+	if field == "files" {
+		return v >= 9999
+	}
+	return v >= 99999
+}
+
+// ApplyQuotaRule computes the quotas of a subscription moving from oldPlan to newPlan.
+// fractionLeft is the share (0..1) of the current billing period still to be used.
+func ApplyQuotaRule(rule string, oldPlan, newPlan *Plan, remaining Quotas, fractionLeft float64) Quotas {
+	if fractionLeft < 0 {
+		fractionLeft = 0
+	}
+	if fractionLeft > 1 {
+		fractionLeft = 1
+	}
+	one := func(field string, oldLimit, newLimit, left int) int {
+		if rule == QuotaRuleReset || unlimitedQuota(field, newLimit) || unlimitedQuota(field, oldLimit) {
+			return newLimit
+		}
+		diff := float64(newLimit - oldLimit)
+		if rule == QuotaRuleProrate {
+			diff = math.Round(diff * fractionLeft)
+		}
+		v := left + int(diff)
+		if v < 0 {
+			return 0
+		}
+		return v
+	}
+	return Quotas{
+		Consultations:  one("consultations", oldPlan.Consultations, newPlan.Consultations, remaining.Consultations),
+		Questionnaires: one("questionnaires", oldPlan.Questionnaires, newPlan.Questionnaires, remaining.Questionnaires),
+		ClinicalCases:  one("clinical_cases", oldPlan.ClinicalCases, newPlan.ClinicalCases, remaining.ClinicalCases),
+		Files:          one("files", oldPlan.Files, newPlan.Files, remaining.Files),
+	}
+}
+
+// monthlyEquivalent normalizes a price point to a per-month amount so plans billed on
+// different intervals can be compared to tell upgrades from downgrades.
+func monthlyEquivalent(pp PlanPrice) float64 {
+	count := float64(pp.IntervalCount)
+	if count <= 0 {
+		count = 1
+	}
+	switch NormalizeInterval(pp.Interval) {
+	case IntervalYear:
+		return pp.Price / (12 * count)
+	case IntervalWeek:
+		return pp.Price * 52 / 12 / count
+	case IntervalDay:
+		return pp.Price * 365 / 12 / count
+	}
+	return pp.Price / count
+}
+
+// periodFractionLeft returns the share of the current billing period not yet elapsed.
+func periodFractionLeft(sub *Subscription, now time.Time) float64 {
+	if sub.CurrentPeriodEnd == nil {
+		return 1
+	}
+	end := *sub.CurrentPeriodEnd
+	start := AddInterval(end, sub.Interval, -max(sub.IntervalCount, 1))
+	total := end.Sub(start)
+	if total <= 0 || !now.Before(end) {
+		return 0
+	}
+	if now.Before(start) {
+		return 1
+	}
+	return float64(end.Sub(now)) / float64(total)
+}
+
+// checkoutGateway picks the gateway that charges a plan change of sub: its own gateway when
+// configured here, else the default one. nil when no gateway can charge.
+func (h *Handler) checkoutGateway(sub *Subscription) PaymentGateway {
+	if gw := h.gateway(sub.Gateway); gw != nil {
+		return gw
+	}
+	return h.gateway("")
+}
+
+// ChangePlan moves the user's active subscription to planID at the interval selected by
+// frequency. Upgrades apply immediately (Stripe prorates the charge, quotas follow
+// PLAN_CHANGE_QUOTA_RULE); downgrades are scheduled for the end of the current period.
+// Paid plans without a Stripe subscription yet (e.g. coming from Free) need a checkout.
+// Prices are resolved for the buyer country so both plans compare in the same currency.
+func (h *Handler) ChangePlan(ctx context.Context, userID, planID, frequency int, country string) (*PlanChangeResult, error) {
+	sub, err := h.repo.GetActiveSubscription(userID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil || sub.Plan == nil {
+		return nil, ErrNoActiveSubscription
+	}
+	newPlan, err := h.repo.GetPlanByID(planID)
+	if err != nil {
+		return nil, err
+	}
+	if newPlan == nil {
+		return nil, errors.New("plan inválido")
+	}
+	newPrice, ok := newPlan.ResolveLocalizedPrice(frequency, country)
+	if !ok {
+		return nil, ErrIntervalNotAvailable
+	}
+	curPlan, err := h.repo.GetPlanByID(sub.PlanID)
+	if err != nil || curPlan == nil {
+		curPlan = sub.Plan
+	} else {
+		// quota math starts from the limits of the version the subscription is pinned to
+		pinned := *curPlan
+		pinned.Consultations, pinned.Questionnaires, pinned.ClinicalCases, pinned.Files = sub.Plan.Consultations, sub.Plan.Questionnaires, sub.Plan.ClinicalCases, sub.Plan.Files
+		curPlan = &pinned
+	}
+	curPrice, ok := curPlan.ResolveLocalizedPrice(FrequencyForInterval(sub.Interval), country)
+	if !ok {
+		curPrice, _ = curPlan.ResolveLocalizedPrice(FrequencyDefault, country)
+	}
+	res := &PlanChangeResult{SubscriptionID: sub.ID, PlanID: newPlan.ID, Interval: newPrice.Interval}
+
+	// Same plan and interval: only meaningful to cancel a pending downgrade.
+	if newPlan.ID == sub.PlanID && newPrice.Interval == NormalizeInterval(sub.Interval) {
+		if sub.ScheduledPlanID != nil {
+			if h.stripe != nil && sub.StripeSubscriptionID != "" {
+				if err := h.stripe.ChangeSubscriptionPrice(ctx, sub.StripeSubscriptionID, newPlan, &newPrice, false); err != nil {
+					return nil, err
+				}
+			}
+			if err := h.repo.ClearScheduledPlanChange(sub.ID); err != nil {
+				return nil, err
+			}
+		}
+		res.Status = PlanChangeUnchanged
+		return res, nil
+	}
+
+	// Paid target without an existing Stripe subscription: go through checkout (same gateway
+	// as the current subscription, the default one for free, manual or unconfigured ones).
+	// Never applied without a payment.
+	if newPrice.Price > 0 && sub.StripeSubscriptionID == "" {
+		gw := h.checkoutGateway(sub)
+		if gw == nil {
+			return nil, ErrGatewayNotAvailable
+		}
+		url, sessionID, err := gw.CreateCheckout(ctx, userID, newPlan.ID, frequency, CheckoutOptions{Country: country})
+		if err != nil {
+			return nil, err
+		}
+		res.Status, res.CheckoutURL, res.SessionID = PlanChangeCheckoutRequired, url, sessionID
+		return res, nil
+	}
+
+	now := time.Now()
+	if monthlyEquivalent(newPrice) < monthlyEquivalent(curPrice) {
+		// Downgrade: keep current entitlements until the period ends
+		if h.stripe != nil && sub.StripeSubscriptionID != "" {
+			if newPrice.Price == 0 {
+				err = h.stripe.CancelAtPeriodEnd(ctx, sub.StripeSubscriptionID)
+			} else {
+				err = h.stripe.ChangeSubscriptionPrice(ctx, sub.StripeSubscriptionID, newPlan, &newPrice, false)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := h.repo.SchedulePlanChange(sub.ID, newPlan.ID, newPrice.Interval, newPrice.IntervalCount); err != nil {
+			return nil, err
+		}
+		res.Status = PlanChangeScheduled
+		res.EffectiveAt = sub.CurrentPeriodEnd
+		log.Printf("[SUBSCRIPTIONS][CHANGE] scheduled user=%d sub=%d from_plan=%d to_plan=%d at=%v", userID, sub.ID, sub.PlanID, newPlan.ID, sub.CurrentPeriodEnd)
+		return res, nil
+	}
+
+	// Upgrade (or lateral move): apply now with proration
+	if h.stripe != nil && sub.StripeSubscriptionID != "" {
+		if err := h.stripe.ChangeSubscriptionPrice(ctx, sub.StripeSubscriptionID, newPlan, &newPrice, true); err != nil {
+			return nil, err
+		}
+	}
+	remaining := Quotas{Consultations: sub.Consultations, Questionnaires: sub.Questionnaires, ClinicalCases: sub.ClinicalCases, Files: sub.Files}
+	q := ApplyQuotaRule(quotaRuleFromEnv(), curPlan, newPlan, remaining, periodFractionLeft(sub, now))
+	if err := h.repo.ApplyPlanChange(sub.ID, newPlan.ID, newPrice.Interval, newPrice.IntervalCount, q); err != nil {
+		return nil, err
+	}
+	res.Status = PlanChangeApplied
+	res.EffectiveAt = &now
+	res.Quotas = &q
+	log.Printf("[SUBSCRIPTIONS][CHANGE] applied user=%d sub=%d from_plan=%d to_plan=%d rule=%s quotas=%+v", userID, sub.ID, sub.PlanID, newPlan.ID, quotaRuleFromEnv(), q)
+	return res, nil
+}
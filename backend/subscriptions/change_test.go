@@ -0,0 +1,69 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyQuotaRule(t *testing.T) {
+	oldPlan := &Plan{Consultations: 30, Questionnaires: 50, ClinicalCases: 25, Files: 100}
+	newPlan := &Plan{Consultations: 100, Questionnaires: 200, ClinicalCases: 100, Files: 500}
+	remaining := Quotas{Consultations: 10, Questionnaires: 50, ClinicalCases: 0, Files: 90}
+
+	if got := ApplyQuotaRule(QuotaRuleReset, oldPlan, newPlan, remaining, 0.5); got != (Quotas{100, 200, 100, 500}) {
+		t.Fatalf("reset: got %+v", got)
+	}
+	if got := ApplyQuotaRule(QuotaRuleCarryOver, oldPlan, newPlan, remaining, 0.5); got != (Quotas{80, 200, 75, 490}) {
+		t.Fatalf("carryover: got %+v", got)
+	}
+	if got := ApplyQuotaRule(QuotaRuleProrate, oldPlan, newPlan, remaining, 0.5); got != (Quotas{45, 125, 38, 290}) {
+		t.Fatalf("prorate: got %+v", got)
+	}
+	// Downgrade carry-over never goes negative
+	if got := ApplyQuotaRule(QuotaRuleCarryOver, newPlan, oldPlan, Quotas{Consultations: 5}, 1); got.Consultations != 0 {
+		t.Fatalf("carryover downgrade should clamp at 0, got %+v", got)
+	}
+	// Unlimited target always grants the unlimited value
+	unl := &Plan{Consultations: 99999}
+	if got := ApplyQuotaRule(QuotaRuleProrate, oldPlan, unl, remaining, 0.1); got.Consultations != 99999 {
+		t.Fatalf("unlimited: got %+v", got)
+	}
+}
+
+func TestPeriodFractionLeft(t *testing.T) {
+	end := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	sub := &Subscription{Interval: IntervalMonth, IntervalCount: 1, CurrentPeriodEnd: &end}
+	mid := time.Date(2025, 1, 16, 12, 0, 0, 0, time.UTC)
+	if f := periodFractionLeft(sub, mid); f < 0.49 || f > 0.51 {
+		t.Fatalf("expected ~0.5, got %v", f)
+	}
+	if f := periodFractionLeft(sub, end.Add(time.Hour)); f != 0 {
+		t.Fatalf("after period end expected 0, got %v", f)
+	}
+	if f := periodFractionLeft(&Subscription{}, mid); f != 1 {
+		t.Fatalf("without period end expected 1, got %v", f)
+	}
+}
+
+func TestMonthlyEquivalent(t *testing.T) {
+	annual := PlanPrice{Interval: IntervalYear, IntervalCount: 1, Price: 120}
+	monthly := PlanPrice{Interval: IntervalMonth, IntervalCount: 1, Price: 12}
+	if monthlyEquivalent(annual) != 10 || monthlyEquivalent(monthly) != 12 {
+		t.Fatalf("unexpected equivalents: %v %v", monthlyEquivalent(annual), monthlyEquivalent(monthly))
+	}
+}
+
+func TestCheckoutGateway(t *testing.T) {
+	fake := NewFakeGateway(nil, "")
+	h := &Handler{gateways: map[string]PaymentGateway{GatewayFake: fake}, defaultGateway: GatewayFake}
+	// Admin grants and gateways not configured here still pay through the default gateway
+	for _, gw := range []string{"", GatewayManual, GatewayMercadoPago, GatewayFake} {
+		if got := h.checkoutGateway(&Subscription{Gateway: gw}); got != fake {
+			t.Fatalf("checkoutGateway(%q) = %v", gw, got)
+		}
+	}
+	none := &Handler{gateways: map[string]PaymentGateway{}}
+	if got := none.checkoutGateway(&Subscription{Gateway: GatewayManual}); got != nil {
+		t.Fatalf("checkoutGateway without gateways = %v", got)
+	}
+}
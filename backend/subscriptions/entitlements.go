@@ -0,0 +1,126 @@
+package subscriptions
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Feature entitlements attached to plans (subscription_plans.entitlements, snapshotted on
+// each plan version). Boolean features use 1/0; limits hold the value itself.
+const (
+	FeatureImageAnalysis  = "image_analysis"
+	FeaturePubMedSearch   = "pubmed_search"
+	FeaturePDFUploadMaxMB = "pdf_upload_max_mb"
+	FeatureExportPDF      = "export_pdf"
+	FeaturePremiumModel   = "premium_model"
+	FeatureStatistics     = "statistics"
+)
+
+// FeatureSpec describes an entitlement and the value plans get when they don't set it:
+// Free for free plans, Paid for paid ones (what every plan had before entitlements existed).
+type FeatureSpec struct {
+	Key         string `json:"key"`
+	Kind        string `json:"kind"` // bool | limit
+	Description string `json:"description"`
+	Free        int    `json:"free_default"`
+	Paid        int    `json:"paid_default"`
+}
+
+// Features is the entitlement catalog, in display order.
+var Features = []FeatureSpec{
+	{Key: FeatureImageAnalysis, Kind: "bool", Description: "Análisis de imágenes en el chat", Free: 1, Paid: 1},
+	{Key: FeaturePubMedSearch, Kind: "bool", Description: "Búsqueda de evidencia en PubMed", Free: 1, Paid: 1},
+	{Key: FeaturePDFUploadMaxMB, Kind: "limit", Description: "Tamaño máximo de PDF subido (MB)", Free: 100, Paid: 100},
+	{Key: FeatureExportPDF, Kind: "bool", Description: "Exportar a PDF", Free: 0, Paid: 1},
+	{Key: FeaturePremiumModel, Kind: "bool", Description: "Modelo de IA premium", Free: 0, Paid: 1},
+	{Key: FeatureStatistics, Kind: "bool", Description: "Estadísticas premium", Free: 0, Paid: 1},
+}
+
+// FeatureByKey looks up a catalog entry.
+func FeatureByKey(key string) (FeatureSpec, bool) {
+	for _, f := range Features {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return FeatureSpec{}, false
+}
+
+// Entitlement returns the value of a feature for the plan: its explicit setting, else the
+// catalog default for free/paid plans (0 for unknown features).
+func (p *Plan) Entitlement(feature string) int {
+	if v, ok := p.Entitlements[feature]; ok {
+		return v
+	}
+	f, ok := FeatureByKey(feature)
+	if !ok {
+		return 0
+	}
+	if p.Price > 0 {
+		return f.Paid
+	}
+	return f.Free
+}
+
+// ResolvedEntitlements returns every catalog feature with its effective value.
+func (p *Plan) ResolvedEntitlements() map[string]int {
+	out := make(map[string]int, len(Features))
+	for _, f := range Features {
+		out[f.Key] = p.Entitlement(f.Key)
+	}
+	return out
+}
+
+// normalizeEntitlements validates admin input: known features only, bools as 0/1, limits >= 0.
+func normalizeEntitlements(in map[string]int) (map[string]int, error) {
+	if in == nil {
+		return nil, nil
+	}
+	out := make(map[string]int, len(in))
+	for k, v := range in {
+		key := strings.ToLower(strings.TrimSpace(k))
+		f, ok := FeatureByKey(key)
+		if !ok {
+			return nil, fmt.Errorf("entitlement desconocido: %s", k)
+		}
+		if v < 0 || (f.Kind == "bool" && v > 1) {
+			return nil, fmt.Errorf("valor inválido para %s", key)
+		}
+		out[key] = v
+	}
+	return out, nil
+}
+
+// encodeEntitlements serializes explicit settings for the entitlements column (nil = defaults).
+func encodeEntitlements(m map[string]int) interface{} {
+	if m == nil {
+		return nil
+	}
+	b, _ := json.Marshal(m) // encoding/json sorts map keys
+	return string(b)
+}
+
+// setEntitlementsFromColumn parses the entitlements column after a scan and keeps the
+// legacy Statistics flag in sync with the statistics entitlement.
+func (p *Plan) setEntitlementsFromColumn(raw string) {
+	p.Entitlements = nil
+	if strings.TrimSpace(raw) != "" {
+		var m map[string]int
+		if err := json.Unmarshal([]byte(raw), &m); err == nil {
+			p.Entitlements = m
+		}
+	}
+	p.Statistics = p.Entitlement(FeatureStatistics)
+}
+
+// sameEntitlements compares the effective feature values of two plans.
+func sameEntitlements(a, b *Plan) bool {
+	ra, rb := a.ResolvedEntitlements(), b.ResolvedEntitlements()
+	for k, v := range ra {
+		if rb[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package subscriptions
+
+import "testing"
+
+func TestPlanEntitlementDefaults(t *testing.T) {
+	free := &Plan{Price: 0}
+	paid := &Plan{Price: 9.99, Entitlements: map[string]int{FeaturePDFUploadMaxMB: 20, FeatureExportPDF: 0}}
+	cases := []struct {
+		plan    *Plan
+		feature string
+		want    int
+	}{
+		{free, FeaturePubMedSearch, 1},
+		{free, FeaturePremiumModel, 0},
+		{free, FeatureStatistics, 0},
+		{paid, FeatureStatistics, 1},
+		{paid, FeaturePDFUploadMaxMB, 20}, // explicit setting wins
+		{paid, FeatureExportPDF, 0},
+		{paid, "unknown", 0},
+	}
+	for _, tc := range cases {
+		if got := tc.plan.Entitlement(tc.feature); got != tc.want {
+			t.Errorf("price=%v %s: got %d want %d", tc.plan.Price, tc.feature, got, tc.want)
+		}
+	}
+	if got := paid.ResolvedEntitlements(); len(got) != len(Features) || got[FeatureImageAnalysis] != 1 {
+		t.Errorf("resolved: got %v", got)
+	}
+}
+
+func TestNormalizeEntitlements(t *testing.T) {
+	got, err := normalizeEntitlements(map[string]int{" PubMed_Search ": 0, "pdf_upload_max_mb": 25})
+	if err != nil || got[FeaturePubMedSearch] != 0 || got[FeaturePDFUploadMaxMB] != 25 || len(got) != 2 {
+		t.Fatalf("got %v err=%v", got, err)
+	}
+	for _, in := range []map[string]int{{"teleconsulta": 1}, {FeatureExportPDF: 2}, {FeaturePDFUploadMaxMB: -1}} {
+		if _, err := normalizeEntitlements(in); err == nil {
+			t.Errorf("%v accepted", in)
+		}
+	}
+}
+
+func TestEntitlementsColumn(t *testing.T) {
+	p := &Plan{Price: 9.99}
+	p.setEntitlementsFromColumn(`{"statistics":0,"premium_model":1}`)
+	if p.Statistics != 0 || p.Entitlement(FeaturePremiumModel) != 1 {
+		t.Errorf("explicit: got %+v", p)
+	}
+	p.setEntitlementsFromColumn("")
+	if p.Entitlements != nil || p.Statistics != 1 {
+		t.Errorf("defaults: got %+v", p)
+	}
+	if got := encodeEntitlements(map[string]int{"b": 1, "a": 0}); got != `{"a":0,"b":1}` {
+		t.Errorf("encode: got %v", got)
+	}
+}
+
+func TestPlanVersionChangedEntitlements(t *testing.T) {
+	old := &Plan{Price: 9.99}
+	same := &Plan{Price: 9.99, Entitlements: map[string]int{FeatureExportPDF: 1}} // equals the paid default
+	if planVersionChanged(old, same) {
+		t.Error("explicit default must not create a version")
+	}
+	locked := &Plan{Price: 9.99, Entitlements: map[string]int{FeatureImageAnalysis: 0}}
+	if !planVersionChanged(old, locked) {
+		t.Error("entitlement change not detected")
+	}
+}
@@ -0,0 +1,175 @@
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FakeGateway is an in-memory gateway for local development: checkouts are paid from a
+// small HTML page (or a webhook call) and behave like a recurring card subscription.
+// State is lost on restart. Enable with PAYMENT_FAKE_GATEWAY=1.
+type FakeGateway struct {
+	repo    *Repository
+	baseURL string // public URL of this backend, used to build the checkout page link
+
+	mu       sync.Mutex
+	seq      int
+	sessions map[string]*fakeSession
+}
+
+type fakeSession struct {
+	metadata map[string]string
+	amount   float64
+	currency string
+	paid     bool
+	subID    int
+}
+
+// NewFakeGateway builds the fake gateway; baseURL defaults to http://localhost:8080.
+func NewFakeGateway(repo *Repository, baseURL string) *FakeGateway {
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return &FakeGateway{repo: repo, baseURL: strings.TrimRight(baseURL, "/"), sessions: map[string]*fakeSession{}}
+}
+
+func (g *FakeGateway) Name() string { return GatewayFake }
+
+func (g *FakeGateway) Methods() []string { return []string{"card", "pse", "oxxo", "nequi"} }
+
+func (g *FakeGateway) Recurring() bool { return true }
+
+func (g *FakeGateway) CreateCheckout(ctx context.Context, userID, planID, frequency int, opts CheckoutOptions) (string, string, error) {
+	plan, err := g.repo.GetPlanByID(planID)
+	if err != nil || plan == nil {
+		return "", "", fmt.Errorf("plan inválido")
+	}
+	price, ok := plan.ResolveLocalizedPrice(frequency, opts.Country)
+	if !ok {
+		return "", "", ErrIntervalNotAvailable
+	}
+	amount := price.Price
+	if opts.Promo != nil {
+		amount = opts.Promo.ApplyTo(amount, price.Currency)
+	}
+	id := g.newSession(checkoutMetadata(userID, planID, frequency, price, opts), amount, price.Currency)
+	return g.baseURL + "/payments/fake/checkout/" + id, id, nil
+}
+
+func (g *FakeGateway) newSession(md map[string]string, amount float64, currency string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.seq++
+	id := fmt.Sprintf("fake_%d_%s", g.seq, randomHex(4))
+	g.sessions[id] = &fakeSession{metadata: md, amount: amount, currency: currency}
+	return id
+}
+
+// Pay marks the session as paid and creates the subscription (idempotent).
+func (g *FakeGateway) Pay(sessionID string) (bool, int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sess, ok := g.sessions[sessionID]
+	if !ok {
+		return false, 0, errors.New("sesión no encontrada")
+	}
+	if sess.paid {
+		return false, sess.subID, nil
+	}
+	created, subID, err := activateCheckout(g.repo, sess.metadata, GatewayFake, sessionID, false)
+	if err != nil {
+		return false, 0, err
+	}
+	sess.paid, sess.subID = true, subID
+	pm := &Payment{Gateway: GatewayFake, GatewayPaymentID: sessionID, Amount: sess.amount, Currency: sess.currency, Status: PaymentPaid, PaymentMethod: "card"}
+	paymentFromMetadata(pm, sess.metadata, subID)
+	recordPayment(g.repo, pm)
+	return created, subID, nil
+}
+
+// ConfirmSession reports the subscription of a paid session; unpaid sessions return created=false.
+func (g *FakeGateway) ConfirmSession(sessionID string) (bool, int, error) {
+	g.mu.Lock()
+	sess, ok := g.sessions[sessionID]
+	g.mu.Unlock()
+	if !ok {
+		return false, 0, errors.New("sesión no encontrada")
+	}
+	if !sess.paid {
+		return false, 0, nil
+	}
+	return false, sess.subID, nil
+}
+
+// HandleWebhook simulates a gateway notification: {"session_id":"fake_1_ab12","status":"paid"}.
+func (g *FakeGateway) HandleWebhook(w http.ResponseWriter, r *http.Request) error {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var event struct {
+		SessionID string `json:"session_id"`
+		Status    string `json:"status"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return err
+	}
+	if event.Status != "paid" {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ignored"))
+		return nil
+	}
+	if _, _, err := g.Pay(event.SessionID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+	return nil
+}
+
+func (g *FakeGateway) Cancel(ctx context.Context, sub *Subscription, atPeriodEnd bool) error {
+	return nil
+}
+
+var fakeCheckoutPage = template.Must(template.New("fake").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Pago de prueba</title></head>
+<body style="font-family:sans-serif;max-width:420px;margin:40px auto">
+<h2>Pasarela de prueba</h2>
+<p>Plan {{.PlanID}} &middot; {{printf "%.2f" .Amount}} {{.Currency}}</p>
+<form method="post"><button name="result" value="paid">Pagar</button> <button name="result" value="cancel">Cancelar</button></form>
+</body></html>`))
+
+// RegisterRoutes exposes the hosted checkout page of the fake gateway.
+func (g *FakeGateway) RegisterRoutes(r *gin.Engine) {
+	r.GET("/payments/fake/checkout/:id", func(c *gin.Context) {
+		g.mu.Lock()
+		sess, ok := g.sessions[c.Param("id")]
+		g.mu.Unlock()
+		if !ok {
+			c.String(http.StatusNotFound, "sesión no encontrada")
+			return
+		}
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		_ = fakeCheckoutPage.Execute(c.Writer, gin.H{"PlanID": sess.metadata["plan_id"], "Amount": sess.amount, "Currency": sess.currency})
+	})
+	r.POST("/payments/fake/checkout/:id", func(c *gin.Context) {
+		if c.PostForm("result") != "paid" {
+			c.Redirect(http.StatusSeeOther, checkoutCancelURL())
+			return
+		}
+		if _, _, err := g.Pay(c.Param("id")); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, checkoutSuccessURL()+"?session_id="+c.Param("id"))
+	})
+}
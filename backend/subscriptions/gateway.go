@@ -0,0 +1,201 @@
+package subscriptions
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payment gateways able to sell plans. The name is stored in subscriptions.payment_gateway.
+const (
+	GatewayStripe      = "stripe"
+	GatewayMercadoPago = "mercadopago"
+	GatewayFake        = "fake"
+)
+
+// GatewayManual marks subscriptions granted by an admin; no gateway charges or renews them.
+const GatewayManual = "manual"
+
+var ErrGatewayNotAvailable = errors.New("gateway_not_available")
+
+// PaymentGateway is the contract every payment processor implements: start a hosted
+// checkout, confirm it when the webhook is late, consume its webhooks and stop renewals.
+type PaymentGateway interface {
+	Name() string
+	// Methods lists the payment methods offered to the app (card, pse, oxxo...).
+	Methods() []string
+	// Recurring reports whether the gateway charges every period automatically (and can
+	// start with a trial). Non-recurring gateways sell one prepaid period per checkout.
+	Recurring() bool
+	// CreateCheckout returns the URL the app opens in a WebView and a session id
+	// usable with ConfirmSession.
+	CreateCheckout(ctx context.Context, userID, planID, frequency int, opts CheckoutOptions) (url, sessionID string, err error)
+	// ConfirmSession creates the subscription of a paid session (idempotent).
+	ConfirmSession(sessionID string) (created bool, subID int, err error)
+	HandleWebhook(w http.ResponseWriter, r *http.Request) error
+	// Cancel stops future charges; atPeriodEnd keeps the current paid period.
+	Cancel(ctx context.Context, sub *Subscription, atPeriodEnd bool) error
+}
+
+// gatewaysFromEnv builds the available gateways. Stripe and Mercado Pago are enabled by
+// their credentials; the in-memory fake with PAYMENT_FAKE_GATEWAY=1 (local development).
+func gatewaysFromEnv(repo *Repository, stripeSvc *StripeService) map[string]PaymentGateway {
+	gws := map[string]PaymentGateway{}
+	if stripeSvc != nil {
+		gws[GatewayStripe] = stripeSvc
+	}
+	if mp := NewMercadoPagoFromEnv(repo); mp != nil {
+		gws[GatewayMercadoPago] = mp
+	}
+	if os.Getenv("PAYMENT_FAKE_GATEWAY") == "1" {
+		gws[GatewayFake] = NewFakeGateway(repo, os.Getenv("PAYMENT_FAKE_BASE_URL"))
+	}
+	return gws
+}
+
+// defaultGatewayName picks PAYMENT_GATEWAY when available, else Stripe, else any configured one.
+func defaultGatewayName(gws map[string]PaymentGateway) string {
+	if name := strings.ToLower(strings.TrimSpace(os.Getenv("PAYMENT_GATEWAY"))); name != "" {
+		if _, ok := gws[name]; ok {
+			return name
+		}
+		log.Printf("[PAYMENTS] PAYMENT_GATEWAY=%s no está configurado", name)
+	}
+	if _, ok := gws[GatewayStripe]; ok {
+		return GatewayStripe
+	}
+	for _, name := range []string{GatewayMercadoPago, GatewayFake} {
+		if _, ok := gws[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// checkoutSuccessURL / checkoutCancelURL are the WebView markers shared by all gateways.
+func checkoutSuccessURL() string {
+	for _, k := range []string{"PAYMENT_SUCCESS_URL", "STRIPE_SUCCESS_URL"} {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return "https://example.com/checkout/success"
+}
+
+func checkoutCancelURL() string {
+	for _, k := range []string{"PAYMENT_CANCEL_URL", "STRIPE_CANCEL_URL"} {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return "https://example.com/checkout/cancel"
+}
+
+// checkoutMetadata is attached to every checkout so the subscription can be created later
+// from the webhook or the confirm call, whatever the gateway.
+func checkoutMetadata(userID, planID, frequency int, price PlanPrice, opts CheckoutOptions) map[string]string {
+	md := map[string]string{
+		"user_id":        strconv.Itoa(userID),
+		"plan_id":        strconv.Itoa(planID),
+		"frequency":      strconv.Itoa(frequency),
+		"interval":       price.Interval,
+		"interval_count": strconv.Itoa(price.IntervalCount),
+		"currency":       price.Currency,
+	}
+	if opts.Country != "" {
+		md["country"] = opts.Country
+	}
+	if opts.Promo != nil {
+		md["promo_code"] = opts.Promo.Code
+	}
+	if opts.TrialDays > 0 {
+		md["trial_days"] = strconv.Itoa(opts.TrialDays)
+	}
+	return md
+}
+
+// subscriptionFromMetadata builds the subscription paid through a checkout. ref is the
+// gateway's id for it (Stripe subscription id, Mercado Pago payment id...). Prepaid
+// purchases (one-off payments without automatic renewal) end with the paid period.
+func subscriptionFromMetadata(md map[string]string, gateway, ref string, prepaid bool, now time.Time) (*Subscription, error) {
+	uid, _ := strconv.Atoi(md["user_id"])
+	pid, _ := strconv.Atoi(md["plan_id"])
+	freq, _ := strconv.Atoi(md["frequency"])
+	if uid == 0 || pid == 0 {
+		return nil, errors.New("metadata incompleta")
+	}
+	sub := &Subscription{UserID: uid, PlanID: pid, StartDate: now, Frequency: freq, Gateway: gateway}
+	if gateway == GatewayStripe {
+		sub.StripeSubscriptionID = ref
+	} else {
+		sub.GatewayReference = ref
+	}
+	applyIntervalMetadata(sub, md)
+	applyPromoMetadata(sub, md, now)
+	if prepaid {
+		sub.TrialEndsAt = nil
+		end := AddInterval(now, NormalizeInterval(sub.Interval), sub.IntervalCount)
+		sub.CurrentPeriodEnd = &end
+		sub.EndDate = &end
+	}
+	return sub, nil
+}
+
+// activateCheckout creates the subscription described by checkout metadata. Idempotent:
+// when the user already has an active subscription to the same plan it is returned as is,
+// except for a new prepaid payment, which extends it by one period. A payment already
+// recorded against a subscription (a redelivered webhook or a late confirm) never extends it again.
+func activateCheckout(repo *Repository, md map[string]string, gateway, ref string, prepaid bool) (bool, int, error) {
+	sub, err := subscriptionFromMetadata(md, gateway, ref, prepaid, time.Now())
+	if err != nil {
+		return false, 0, err
+	}
+	if cur, _ := repo.GetActiveSubscription(sub.UserID); cur != nil && cur.PlanID == sub.PlanID {
+		if prepaid && cur.Gateway == gateway && cur.GatewayReference != ref && cur.EndDate != nil {
+			applied, err := repo.IsPaymentApplied(gateway, ref)
+			if err != nil {
+				return false, 0, err
+			}
+			if applied {
+				log.Printf("[PAYMENTS][%s] prepaid payment already applied user=%d sub=%d ref=%s", gateway, cur.UserID, cur.ID, ref)
+				return false, cur.ID, nil
+			}
+			// New payment for the prepaid plan already running: one more period after the current one
+			end := AddInterval(*cur.EndDate, cur.Interval, cur.IntervalCount)
+			if err := repo.ExtendPrepaidSubscription(cur.ID, ref, end); err != nil {
+				return false, 0, err
+			}
+			log.Printf("[PAYMENTS][%s] prepaid subscription extended user=%d sub=%d until=%s ref=%s", gateway, cur.UserID, cur.ID, end.Format(time.RFC3339), ref)
+			return false, cur.ID, nil
+		}
+		if gateway == GatewayStripe && cur.StripeSubscriptionID == "" && ref != "" {
+			_ = repo.SetStripeSubscriptionID(cur.ID, ref)
+		}
+		return false, cur.ID, nil
+	}
+	if err := repo.CreateSubscription(sub); err != nil {
+		return false, 0, err
+	}
+	recordPromoRedemption(repo, sub)
+	log.Printf("[PAYMENTS][%s] subscription created user=%d plan=%d sub=%d ref=%s", gateway, sub.UserID, sub.PlanID, sub.ID, ref)
+	return true, sub.ID, nil
+}
+
+// recordPromoRedemption tracks the code used by a freshly created subscription (best effort).
+func recordPromoRedemption(repo *Repository, sub *Subscription) {
+	if sub.PromoCode == "" {
+		return
+	}
+	pc, err := repo.GetPromoCodeByCode(sub.PromoCode)
+	if err != nil || pc == nil {
+		return
+	}
+	if err := repo.RecordPromoRedemption(pc.ID, sub.UserID, sub.ID); err != nil {
+		log.Printf("[PAYMENTS][promo] record redemption code=%s sub=%d failed: %v", pc.Code, sub.ID, err)
+	}
+}
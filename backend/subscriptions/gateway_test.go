@@ -0,0 +1,98 @@
+package subscriptions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionFromMetadata(t *testing.T) {
+	now := time.Date(2025, 1, 31, 10, 0, 0, 0, time.UTC)
+	md := checkoutMetadata(7, 3, FrequencyAnnual, PlanPrice{Interval: IntervalYear, IntervalCount: 1}, CheckoutOptions{Promo: &PromoCode{Code: "BIENVENIDA"}, TrialDays: 14})
+
+	sub, err := subscriptionFromMetadata(md, GatewayStripe, "sub_123", false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.UserID != 7 || sub.PlanID != 3 || sub.Interval != IntervalYear || sub.StripeSubscriptionID != "sub_123" || sub.GatewayReference != "" {
+		t.Fatalf("stripe sub = %+v", sub)
+	}
+	if sub.PromoCode != "BIENVENIDA" || sub.TrialEndsAt == nil || !sub.TrialEndsAt.Equal(now.AddDate(0, 0, 14)) {
+		t.Fatalf("promo/trial not applied: %+v", sub)
+	}
+	if sub.EndDate != nil {
+		t.Fatalf("recurring subscription must not end: %v", sub.EndDate)
+	}
+
+	sub, err = subscriptionFromMetadata(md, GatewayMercadoPago, "987", true, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := now.AddDate(1, 0, 0)
+	if sub.StripeSubscriptionID != "" || sub.GatewayReference != "987" || sub.Gateway != GatewayMercadoPago {
+		t.Fatalf("prepaid refs = %+v", sub)
+	}
+	if sub.TrialEndsAt != nil || sub.EndDate == nil || !sub.EndDate.Equal(want) || !sub.CurrentPeriodEnd.Equal(want) {
+		t.Fatalf("prepaid period = end %v period_end %v trial %v", sub.EndDate, sub.CurrentPeriodEnd, sub.TrialEndsAt)
+	}
+
+	if _, err := subscriptionFromMetadata(map[string]string{"plan_id": "3"}, GatewayFake, "x", false, now); err == nil {
+		t.Fatal("expected error for missing user_id")
+	}
+}
+
+func TestDefaultGatewayName(t *testing.T) {
+	mp := &MercadoPagoGateway{}
+	fake := &FakeGateway{}
+	t.Setenv("PAYMENT_GATEWAY", "")
+	if got := defaultGatewayName(map[string]PaymentGateway{GatewayMercadoPago: mp, GatewayFake: fake}); got != GatewayMercadoPago {
+		t.Errorf("got %q want mercadopago", got)
+	}
+	t.Setenv("PAYMENT_GATEWAY", "fake")
+	if got := defaultGatewayName(map[string]PaymentGateway{GatewayMercadoPago: mp, GatewayFake: fake}); got != GatewayFake {
+		t.Errorf("got %q want fake", got)
+	}
+	t.Setenv("PAYMENT_GATEWAY", "stripe") // not configured
+	if got := defaultGatewayName(map[string]PaymentGateway{}); got != "" {
+		t.Errorf("got %q want none", got)
+	}
+}
+
+func TestVerifyMercadoPagoSignature(t *testing.T) {
+	secret := "s3cr3t"
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("id:123456;request-id:req-1;ts:1700000000;"))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	if !verifyMercadoPagoSignature(secret, "ts=1700000000,v1="+sig, "req-1", "123456") {
+		t.Error("valid signature rejected")
+	}
+	if verifyMercadoPagoSignature(secret, "ts=1700000000,v1="+sig, "req-2", "123456") {
+		t.Error("signature for another request accepted")
+	}
+	if verifyMercadoPagoSignature(secret, "v1="+sig, "req-1", "123456") {
+		t.Error("signature without ts accepted")
+	}
+}
+
+func TestMercadoPagoPreference(t *testing.T) {
+	g := &MercadoPagoGateway{successURL: "https://ok", cancelURL: "https://ko"}
+	plan := &Plan{ID: 2, Name: "Premium", Currency: "cop"}
+	price := PlanPrice{Interval: IntervalMonth, IntervalCount: 1, Price: 40000}
+	promo := &PromoCode{Code: "MITAD", PercentOff: 50}
+	pref := g.buildPreference("ema_1", plan, price, map[string]string{"user_id": "1"}, CheckoutOptions{Promo: promo, PaymentMethod: "PSE"})
+	if len(pref.Items) != 1 || pref.Items[0].UnitPrice != 20000 || pref.Items[0].CurrencyID != "COP" || pref.Items[0].Title != "Premium (mensual)" {
+		t.Fatalf("items = %+v", pref.Items)
+	}
+	if pref.PaymentMethods == nil || pref.PaymentMethods.DefaultPaymentMethodID != "pse" {
+		t.Fatalf("payment methods = %+v", pref.PaymentMethods)
+	}
+	if pref.ExternalReference != "ema_1" || pref.BackURLs["failure"] != "https://ko" {
+		t.Fatalf("pref = %+v", pref)
+	}
+	if pref := g.buildPreference("ema_2", plan, price, nil, CheckoutOptions{PaymentMethod: "card"}); pref.PaymentMethods != nil {
+		t.Fatalf("card checkout must not restrict methods: %+v", pref.PaymentMethods)
+	}
+}
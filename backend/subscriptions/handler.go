@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"ema-backend/audit"
 	"ema-backend/login"
 	"ema-backend/migrations"
 
@@ -17,20 +18,54 @@ import (
 
 type Handler struct {
 	repo   *Repository
-	stripe *StripeService
+	users  migrations.UserStore // resolves bearer tokens
+	stripe *StripeService // Stripe-only operations (mid-cycle price swaps)
+	gateways       map[string]PaymentGateway
+	defaultGateway string
 }
 
-func NewHandler(repo *Repository) *Handler {
+func NewHandler(repo *Repository, users migrations.UserStore) *Handler {
 	s := NewStripeFromEnv(repo)
-	return &Handler{repo: repo, stripe: s}
+	gws := gatewaysFromEnv(repo, s)
+	def := defaultGatewayName(gws)
+	log.Printf("[PAYMENTS] gateways=%d default=%q", len(gws), def)
+	return &Handler{repo: repo, users: users, stripe: s, gateways: gws, defaultGateway: def}
+}
+
+// gateway returns the named payment gateway, or the default one when name is empty.
+// nil means the gateway isn't configured.
+func (h *Handler) gateway(name string) PaymentGateway {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = h.defaultGateway
+	}
+	return h.gateways[name]
+}
+
+// gatewayOf returns the gateway charging sub (nil for free or locally granted subscriptions).
+func (h *Handler) gatewayOf(sub *Subscription) PaymentGateway {
+	name := sub.Gateway
+	if name == "" && sub.StripeSubscriptionID != "" {
+		name = GatewayStripe
+	}
+	return h.gateways[name]
 }
 
 func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	r.GET("/plans", h.getPlans)
-	r.POST("/plans", h.createPlan)
-	r.PUT("/plans/:id", h.updatePlan)
-	r.DELETE("/plans/:id", h.deletePlan)
 
+	// Plan, subscription and promo code administration: admin role only, audited
+	adm := r.Group("", login.RequireAdmin(h.users), audit.Middleware())
+	adm.POST("/plans", h.createPlan)
+	adm.PUT("/plans/:id", h.updatePlan)
+	adm.DELETE("/plans/:id", h.deletePlan)
+	adm.PUT("/plans/:id/prices", h.upsertPlanPrice)
+	adm.DELETE("/plans/:id/prices/:price_id", h.deletePlanPrice)
+	adm.GET("/plans/:id/versions", h.getPlanVersions)
+	adm.PUT("/plans/:id/versions/:version/grandfathering", h.setVersionGrandfathering)
+	adm.POST("/plans/:id/versions/migrate", h.migrateVersionCohort)
+
+	// UI shell only: every call it makes carries the admin's token
 	r.GET("/admin/plans", func(c *gin.Context) {
 		c.Header("Content-Type", "text/html; charset=utf-8")
 		data, err := os.ReadFile("subscriptions/admin.html")
@@ -38,15 +73,30 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 		c.Writer.Write(data)
 	})
 
-	r.GET("/subscriptions", h.getSubscriptions)
-	r.POST("/subscriptions", h.createSubscription)
-	r.PUT("/subscriptions/:id", h.updateSubscription)
-	r.DELETE("/subscriptions/:id", h.deleteSubscription)
+	adm.GET("/subscriptions", h.getSubscriptions)
+	adm.POST("/subscriptions", h.createSubscription)
+	adm.PUT("/subscriptions/:id", h.updateSubscription)
+	adm.DELETE("/subscriptions/:id", h.deleteSubscription)
 
 	r.POST("/cancel-subscription", h.cancelSubscription)
+	r.POST("/me/subscription/change", h.changeSubscription)
+	r.POST("/me/subscription/trial", h.startTrial)
+	r.GET("/me/payments", h.getMyPayments)
+	r.GET("/me/payments/:id/receipt", h.getPaymentReceipt)
+
+	adm.GET("/promo-codes", h.getPromoCodes)
+	adm.POST("/promo-codes", h.createPromoCode)
+	adm.DELETE("/promo-codes/:id", h.deactivatePromoCode)
+	r.POST("/promo-codes/validate", h.validatePromoCode)
 	r.POST("/checkout", h.checkout)
+	r.GET("/payment-gateways", h.getPaymentGateways)
 	r.POST("/stripe/webhook", h.handleStripeWebhook)
-	r.POST("/stripe/confirm", h.confirmSession) // confirmación manual (idempotente) basada en session_id
+	r.POST("/stripe/confirm", func(c *gin.Context) { h.confirmSession(c, GatewayStripe) }) // confirmación manual (idempotente) basada en session_id
+	r.POST("/payments/confirm", func(c *gin.Context) { h.confirmSession(c, "") })
+	r.POST("/payments/:gateway/webhook", h.handleGatewayWebhook)
+	if fake, ok := h.gateways[GatewayFake].(*FakeGateway); ok {
+		fake.RegisterRoutes(r)
+	}
 	r.GET("/suscription-plans", h.getPlans)
 }
 
@@ -57,28 +107,37 @@ func (h *Handler) getPlans(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	auth := c.GetHeader("Authorization")
-	token := strings.TrimPrefix(auth, "Bearer ")
 	var activePlanID int
-	if token != "" {
-		if email, ok := login.GetEmailFromToken(token); ok {
-			if u := migrations.GetUserByEmail(email); u != nil {
-				if sub, err2 := h.repo.GetActiveSubscription(u.ID); err2 == nil && sub != nil {
-					activePlanID = sub.PlanID
-				}
-			}
+	u := h.optionalUser(c)
+	if u != nil {
+		if sub, err2 := h.repo.GetActiveSubscription(u.ID); err2 == nil && sub != nil {
+			activePlanID = sub.PlanID
 		}
 	}
+	// ?base=1 (admin UI) returns stored prices as is; otherwise prices are localized for the buyer country
+	base := c.Query("base") == "1"
+	country := ""
+	if !base {
+		country = RequestCountry(c, u)
+	}
 	out := []gin.H{}
 	for _, p := range plans {
+		price, currency, prices := p.Price, p.Currency, p.Prices
+		if !base {
+			lp, _ := p.ResolveLocalizedPrice(FrequencyDefault, country)
+			price, currency, prices = lp.Price, lp.Currency, p.LocalizedPrices(country)
+		}
 		out = append(out, gin.H{
-			"id": p.ID, "name": p.Name, "currency": p.Currency, "price": p.Price, "billing": p.Billing,
+			"id": p.ID, "name": p.Name, "currency": currency, "price": price, "billing": p.Billing,
+			"interval": p.Interval, "interval_count": p.IntervalCount, "prices": prices,
+			"base_currency": p.Currency, "base_price": p.Price,
 			"consultations": p.Consultations, "questionnaires": p.Questionnaires, "clinical_cases": p.ClinicalCases, "files": p.Files,
-			"stripe_product_id": p.StripeProductID, "stripe_price_id": p.StripePriceID, "statistics": p.Statistics,
+			"stripe_product_id": p.StripeProductID, "stripe_price_id": p.StripePriceID, "statistics": p.Statistics, "trial_days": p.TrialDays, "version": p.Version,
+			"entitlements": p.ResolvedEntitlements(),
 			"active": p.ID == activePlanID,
 		})
 	}
-	resp := gin.H{"data": out}
+	resp := gin.H{"data": out, "country": country}
 	if activePlanID != 0 { resp["active_plan_id"] = activePlanID }
 	c.JSON(http.StatusOK, resp)
 }
@@ -89,10 +148,20 @@ func (h *Handler) createPlan(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
 		return
 	}
+	ent, err := normalizeEntitlements(p.Entitlements)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.Entitlements = ent
 	if err := h.repo.CreatePlan(&p); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := h.savePlanPrices(p.ID, p.Prices); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusCreated, p)
 }
 
@@ -102,15 +171,119 @@ func (h *Handler) updatePlan(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
 		return
 	}
-	var p Plan
-	if err := c.ShouldBindJSON(&p); err != nil {
+	// Plan fields plus the grandfathering rule for subscribers of the previous versions
+	var body struct {
+		Plan
+		Grandfathering     string     `json:"grandfathering"`
+		GrandfatheredUntil *time.Time `json:"grandfathered_until"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
 		return
 	}
+	p := body.Plan
+	until, err := grandfatherUntil(body.Grandfathering, body.GrandfatheredUntil, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	old, err := h.repo.GetPlanByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if old == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plan no encontrado"})
+		return
+	}
+	// entitlements omitted in the body: keep the current ones
+	if p.Entitlements == nil {
+		p.Entitlements = old.Entitlements
+	} else if p.Entitlements, err = normalizeEntitlements(p.Entitlements); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err := h.repo.UpdatePlan(id, &p); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// Entitlement changes go to a new version; current subscribers stay on theirs
+	version := old.Version
+	if old.VersionID == 0 || planVersionChanged(old, &p) {
+		v, err := h.repo.CreatePlanVersion(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		version = v.Version
+		if until != nil {
+			if err := h.repo.EndGrandfathering(id, v.ID, *until); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		log.Printf("[SUBSCRIPTIONS][VERSIONS] plan=%d new version=%d grandfathered_until=%v", id, v.Version, until)
+	}
+	if err := h.savePlanPrices(id, p.Prices); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": version})
+}
+
+// savePlanPrices upserts the optional alternative interval prices sent with a plan.
+func (h *Handler) savePlanPrices(planID int, prices []PlanPrice) error {
+	for i := range prices {
+		prices[i].PlanID = planID
+		if err := normalizePlanPrice(&prices[i]); err != nil {
+			return err
+		}
+		if err := h.repo.UpsertPlanPrice(&prices[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertPlanPrice handles PUT /plans/:id/prices with body { interval, interval_count, price }
+func (h *Handler) upsertPlanPrice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	var pp PlanPrice
+	if err := c.ShouldBindJSON(&pp); err != nil || pp.Interval == "" || pp.Price < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	pp.PlanID = id
+	if err := normalizePlanPrice(&pp); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.repo.UpsertPlanPrice(&pp); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pp)
+}
+
+func (h *Handler) deletePlanPrice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	priceID, err := strconv.Atoi(c.Param("price_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price_id inválido"})
+		return
+	}
+	if err := h.repo.DeletePlanPrice(id, priceID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
@@ -159,20 +332,60 @@ func (h *Handler) createSubscription(c *gin.Context) {
 	c.JSON(http.StatusCreated, s)
 }
 
-// cancelSubscription handles POST /cancel-subscription with body { subscription_id }
+// cancelSubscription handles POST /cancel-subscription with body { subscription_id, immediate? }.
+// Only the owner may cancel (admins use /admin/subscriptions). The subscription keeps access
+// until the end of the paid period (or now when immediate), then the lifecycle job expires it
+// and falls back to the Free plan.
 func (h *Handler) cancelSubscription(c *gin.Context) {
+	u := h.currentUser(c)
+	if u == nil {
+		return
+	}
 	var body struct {
-		SubscriptionID int `json:"subscription_id"`
+		SubscriptionID int  `json:"subscription_id"`
+		Immediate      bool `json:"immediate"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil || body.SubscriptionID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "subscription_id requerido"})
 		return
 	}
-	if err := h.repo.DeleteSubscription(body.SubscriptionID); err != nil {
+	sub, err := h.repo.GetSubscriptionByID(body.SubscriptionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if sub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "suscripción no encontrada"})
+		return
+	}
+	if sub.UserID != u.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "la suscripción no pertenece al usuario"})
+		return
+	}
+	endAt := time.Now()
+	if !body.Immediate && sub.Plan != nil && sub.Plan.Price > 0 {
+		if sub.EndDate != nil && sub.EndDate.After(endAt) { // prepaid: paid until end_date
+			endAt = *sub.EndDate
+		} else if sub.CurrentPeriodEnd != nil && sub.CurrentPeriodEnd.After(endAt) {
+			endAt = *sub.CurrentPeriodEnd
+		}
+	}
+	if gw := h.gatewayOf(sub); gw != nil {
+		if serr := gw.Cancel(c.Request.Context(), sub, endAt.After(time.Now())); serr != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": serr.Error()})
+			return
+		}
+	}
+	if err := h.repo.CancelSubscription(sub.ID, endAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	if !endAt.After(time.Now()) {
+		if err := migrations.EnsureFreeSubscriptionForUser(sub.UserID); err != nil {
+			log.Printf("[subscriptions][cancel] free fallback user=%d failed: %v", sub.UserID, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "subscription_status": StatusCanceled, "end_date": endAt})
 }
 
 func (h *Handler) updateSubscription(c *gin.Context) {
@@ -246,21 +459,56 @@ func (h *Handler) deleteSubscription(c *gin.Context) {
 }
 
 // checkout provides a stub checkout URL for clients integrating a webview flow.
-// Expected body: { "user_id": number, "plan_id": number, "frequency": number }
-// Response: { "checkout_url": string }
+// Expected body: { "plan_id": number, "frequency": number, "promo_code"?: string,
+// "gateway"?: "stripe"|"mercadopago"|"fake", "payment_method"?: "card"|"pse"|"oxxo"|... }
+// The buyer is the bearer token's user; a body "user_id" must match it.
+// frequency selects the billing interval: 0 = plan default, 1 = mensual, 2 = anual.
+// Response: { "checkout_url": string, "session_id": string, "gateway": string }
 func (h *Handler) checkout(c *gin.Context) {
-	var body struct {
-		UserID    int `json:"user_id"`
-		PlanID    int `json:"plan_id"`
-		Frequency int `json:"frequency"`
+	u := h.currentUser(c)
+	if u == nil {
+		return
 	}
-	if err := c.ShouldBindJSON(&body); err != nil || body.UserID == 0 || body.PlanID == 0 {
+	var body struct {
+		UserID        int    `json:"user_id"`
+		PlanID        int    `json:"plan_id"`
+		Frequency     int    `json:"frequency"`
+		PromoCode     string `json:"promo_code"`
+		Gateway       string `json:"gateway"`
+		PaymentMethod string `json:"payment_method"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.PlanID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
 		return
 	}
+	if body.UserID != 0 && body.UserID != u.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user_id no corresponde al token"})
+		return
+	}
+	gw := h.gateway(body.Gateway)
+	if body.Gateway != "" && gw == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pasarela de pago no disponible", "code": ErrGatewayNotAvailable.Error()})
+		return
+	}
 	plan, _ := h.repo.GetPlanByID(body.PlanID)
-	if h.stripe != nil && plan != nil && plan.Price > 0 {
-		url, sessionID, err := h.stripe.CreateCheckoutSessionWithID(c.Request.Context(), body.UserID, body.PlanID, body.Frequency)
+	country := RequestCountry(c, u)
+	var price PlanPrice
+	if plan != nil {
+		var ok bool
+		if price, ok = plan.ResolveLocalizedPrice(body.Frequency, country); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "intervalo no disponible para este plan", "code": ErrIntervalNotAvailable.Error()})
+			return
+		}
+	}
+	promo, trialDays, err := h.resolveCheckoutPromo(u.ID, plan, body.PromoCode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "código promocional no válido", "code": err.Error()})
+		return
+	}
+	// Prepaid gateways can't convert a trial into a charge: trials go through the local path
+	if gw != nil && plan != nil && price.Price > 0 && !promo.IsFullDiscountForever() && (gw.Recurring() || trialDays == 0) {
+		opts := CheckoutOptions{Promo: promo, TrialDays: trialDays, PaymentMethod: body.PaymentMethod, Country: country}
+		url, sessionID, err := gw.CreateCheckout(c.Request.Context(), u.ID, body.PlanID, body.Frequency, opts)
 		if err != nil {
 			if errors.Is(err, ErrStripeInvalidAPIKey) {
 				c.JSON(http.StatusBadGateway, gin.H{"error": "stripe_api_key_invalida"})
@@ -270,46 +518,403 @@ func (h *Handler) checkout(c *gin.Context) {
 			return
 		}
 		if os.Getenv("STRIPE_AUTO_SUBSCRIBE") == "1" { // dev shortcut
-			sub := &Subscription{UserID: body.UserID, PlanID: plan.ID, StartDate: time.Now(), Frequency: body.Frequency}
+			sub := &Subscription{UserID: u.ID, PlanID: plan.ID, StartDate: time.Now(), Frequency: body.Frequency, Interval: price.Interval, IntervalCount: price.IntervalCount}
 			if err := h.repo.CreateSubscription(sub); err != nil {
 				log.Printf("[checkout][auto_subscribe] create failed: %v", err)
 			} else {
-				log.Printf("[checkout][auto_subscribe] user=%d plan=%d subscription_id=%d", body.UserID, plan.ID, sub.ID)
+				log.Printf("[checkout][auto_subscribe] user=%d plan=%d subscription_id=%d", u.ID, plan.ID, sub.ID)
 				c.JSON(http.StatusOK, gin.H{"checkout_url": url, "session_id": sessionID, "auto_subscribed": true, "subscription_id": sub.ID})
 				return
 			}
 		}
-		c.JSON(http.StatusOK, gin.H{"checkout_url": url, "session_id": sessionID, "auto_subscribed": false})
+		c.JSON(http.StatusOK, gin.H{"checkout_url": url, "session_id": sessionID, "gateway": gw.Name(), "auto_subscribed": false, "trial_days": trialDays})
 		return
 	}
-	// Direct (free plan or stripe disabled)
-	s := &Subscription{UserID: body.UserID, PlanID: body.PlanID, StartDate: time.Now(), Frequency: body.Frequency}
-	if err := h.repo.CreateSubscription(s); err != nil {
+	// Direct (free plan, 100% forever code, card-less trial or no gateway configured)
+	if plan == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "plan inválido"})
+		return
+	}
+	s, err := h.subscribeLocally(u.ID, plan, price, body.Frequency, promo, trialDays)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"checkout_url": "https://example.com/checkout/success", "auto_subscribed": true, "subscription_id": s.ID})
+	c.JSON(http.StatusOK, gin.H{"checkout_url": "https://example.com/checkout/success", "auto_subscribed": true, "subscription_id": s.ID, "trial_ends_at": s.TrialEndsAt})
+}
+
+// resolveCheckoutPromo validates an optional promo code for the user/plan and returns the
+// trial length to apply (promo trial overrides plan trial; one trial per user).
+func (h *Handler) resolveCheckoutPromo(userID int, plan *Plan, code string) (*PromoCode, int, error) {
+	var promo *PromoCode
+	if strings.TrimSpace(code) != "" {
+		pc, err := h.repo.GetPromoCodeByCode(code)
+		if err != nil {
+			return nil, 0, err
+		}
+		planID := 0
+		if plan != nil {
+			planID = plan.ID
+		}
+		if err := pc.Validate(planID, time.Now()); err != nil {
+			return nil, 0, err
+		}
+		used, err := h.repo.HasRedeemedPromo(pc.ID, userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if used {
+			return nil, 0, ErrPromoAlreadyUsed
+		}
+		promo = pc
+	}
+	days := trialDaysFor(plan, promo)
+	if days > 0 {
+		usedTrial, err := h.repo.HasUsedTrial(userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if usedTrial {
+			// A trial-only code is pointless for a user that already had a trial
+			if promo != nil && promo.TrialDays > 0 && promo.PercentOff == 0 && promo.AmountOff == 0 {
+				return nil, 0, ErrTrialAlreadyUsed
+			}
+			days = 0
+		}
+	}
+	return promo, days, nil
+}
+
+// subscribeLocally creates a subscription without payment gateway (free plans, 100% codes,
+// card-less trials or Stripe disabled) and records the promo redemption. A trial on a paid
+// plan falls back to the Free plan when it ends since there is no payment method on file.
+func (h *Handler) subscribeLocally(userID int, plan *Plan, price PlanPrice, frequency int, promo *PromoCode, trialDays int) (*Subscription, error) {
+	now := time.Now()
+	sub := &Subscription{UserID: userID, PlanID: plan.ID, StartDate: now, Frequency: frequency, Interval: price.Interval, IntervalCount: price.IntervalCount}
+	if promo != nil {
+		sub.PromoCode = promo.Code
+	}
+	if trialDays > 0 && price.Price > 0 {
+		end := now.AddDate(0, 0, trialDays)
+		sub.TrialEndsAt = &end
+		if free, err := h.repo.GetFreePlan(); err == nil && free != nil && free.ID != plan.ID {
+			sub.ScheduledPlanID = &free.ID
+			sub.ScheduledInterval = IntervalMonth
+		}
+	}
+	if err := h.repo.CreateSubscription(sub); err != nil {
+		return nil, err
+	}
+	if promo != nil {
+		if err := h.repo.RecordPromoRedemption(promo.ID, userID, sub.ID); err != nil {
+			log.Printf("[checkout][promo] record redemption code=%s sub=%d failed: %v", promo.Code, sub.ID, err)
+		}
+	}
+	return sub, nil
+}
+
+// startTrial handles POST /me/subscription/trial with body { plan_id, promo_code? }: a card-less
+// trial of a paid plan that downgrades to Free when it ends (upgrade via /checkout to keep it).
+func (h *Handler) startTrial(c *gin.Context) {
+	u := h.currentUser(c)
+	if u == nil {
+		return
+	}
+	var body struct {
+		PlanID    int    `json:"plan_id"`
+		PromoCode string `json:"promo_code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.PlanID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "plan_id requerido"})
+		return
+	}
+	plan, err := h.repo.GetPlanByID(body.PlanID)
+	if err != nil || plan == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plan no encontrado"})
+		return
+	}
+	promo, days, err := h.resolveCheckoutPromo(u.ID, plan, body.PromoCode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "código promocional no válido", "code": err.Error()})
+		return
+	}
+	if days == 0 || plan.Price == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prueba no disponible", "code": ErrTrialAlreadyUsed.Error()})
+		return
+	}
+	price, _ := plan.ResolveLocalizedPrice(FrequencyDefault, RequestCountry(c, u))
+	sub, err := h.subscribeLocally(u.ID, plan, price, FrequencyDefault, promo, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "subscription_id": sub.ID, "plan_id": plan.ID, "trial_ends_at": sub.TrialEndsAt})
+}
+
+// optionalUser is currentUser for public endpoints: nil without writing a response.
+func (h *Handler) optionalUser(c *gin.Context) *migrations.User {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+	email, ok := login.GetEmailFromToken(token)
+	if !ok {
+		return nil
+	}
+	return h.users.GetUserByEmail(email)
+}
+
+// currentUser resolves the user from the bearer token, writing the error response when missing.
+func (h *Handler) currentUser(c *gin.Context) *migrations.User {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token requerido"})
+		return nil
+	}
+	email, ok := login.GetEmailFromToken(token)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sesión inválida"})
+		return nil
+	}
+	u := h.users.GetUserByEmail(email)
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+		return nil
+	}
+	return u
+}
+
+func (h *Handler) getPromoCodes(c *gin.Context) {
+	list, err := h.repo.GetPromoCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *Handler) createPromoCode(c *gin.Context) {
+	var pc PromoCode
+	if err := c.ShouldBindJSON(&pc); err != nil || NormalizePromoCode(pc.Code) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	if pc.PercentOff < 0 || pc.PercentOff > 100 || pc.AmountOff < 0 || pc.TrialDays < 0 || (pc.PercentOff > 0 && pc.AmountOff > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "descuento inválido"})
+		return
+	}
+	switch pc.Duration {
+	case "", "once", "repeating", "forever":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration inválida"})
+		return
+	}
+	pc.Active = true
+	if err := h.repo.CreatePromoCode(&pc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, pc)
+}
+
+func (h *Handler) deactivatePromoCode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	if err := h.repo.DeactivatePromoCode(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// validatePromoCode handles POST /promo-codes/validate with body { code, plan_id, frequency }
+// so the app can preview the discounted price (localized like /plans) before opening checkout.
+func (h *Handler) validatePromoCode(c *gin.Context) {
+	var body struct {
+		Code      string `json:"code"`
+		PlanID    int    `json:"plan_id"`
+		Frequency int    `json:"frequency"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Code == "" || body.PlanID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	plan, err := h.repo.GetPlanByID(body.PlanID)
+	if err != nil || plan == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plan no encontrado"})
+		return
+	}
+	price, ok := plan.ResolveLocalizedPrice(body.Frequency, RequestCountry(c, h.optionalUser(c)))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "intervalo no disponible para este plan", "code": ErrIntervalNotAvailable.Error()})
+		return
+	}
+	pc, err := h.repo.GetPromoCodeByCode(body.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := pc.Validate(plan.ID, time.Now()); err != nil {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "code": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"valid": true, "promo_code": pc.Code, "currency": price.Currency,
+		"price": price.Price, "discounted_price": pc.ApplyTo(price.Price, price.Currency),
+		"duration": pc.Duration, "duration_in_months": pc.DurationInMonths, "trial_days": trialDaysFor(plan, pc),
+	})
+}
+
+// changeSubscription handles POST /me/subscription/change with body { plan_id, frequency }.
+// Response: PlanChangeResult (status changed | scheduled | checkout_required | unchanged).
+func (h *Handler) changeSubscription(c *gin.Context) {
+	u := h.currentUser(c)
+	if u == nil {
+		return
+	}
+	var body struct {
+		PlanID    int `json:"plan_id"`
+		Frequency int `json:"frequency"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.PlanID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "plan_id requerido"})
+		return
+	}
+	res, err := h.ChangePlan(c.Request.Context(), u.ID, body.PlanID, body.Frequency, RequestCountry(c, u))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoActiveSubscription):
+			c.JSON(http.StatusNotFound, gin.H{"error": "suscripción no encontrada"})
+		case errors.Is(err, ErrIntervalNotAvailable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "intervalo no disponible para este plan", "code": err.Error()})
+		case errors.Is(err, ErrGatewayNotAvailable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pasarela de pago no disponible", "code": err.Error()})
+		case errors.Is(err, ErrStripeInvalidAPIKey):
+			c.JSON(http.StatusBadGateway, gin.H{"error": "stripe_api_key_invalida"})
+		default:
+			log.Printf("[subscriptions][change] user=%d plan=%d err=%v", u.ID, body.PlanID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, res)
 }
 
 // confirmSession: fallback idempotente por si el webhook se demora o se perdió.
-// Body: {"session_id":"cs_test_..."}
-func (h *Handler) confirmSession(c *gin.Context) {
-	if h.stripe == nil { c.JSON(400, gin.H{"error":"stripe no configurado"}); return }
-	var body struct { SessionID string `json:"session_id"` }
+// Body: {"session_id":"cs_test_...", "gateway"?: "mercadopago"}; name fixes the gateway (/stripe/confirm).
+func (h *Handler) confirmSession(c *gin.Context, name string) {
+	var body struct {
+		SessionID string `json:"session_id"`
+		Gateway   string `json:"gateway"`
+	}
 	if err := c.ShouldBindJSON(&body); err != nil || body.SessionID == "" { c.JSON(400, gin.H{"error":"session_id requerido"}); return }
-	created, subID, err := h.stripe.ConfirmSession(body.SessionID)
+	if name == "" { name = body.Gateway }
+	gw := h.gateway(name)
+	if gw == nil { c.JSON(400, gin.H{"error":"pasarela de pago no configurada"}); return }
+	created, subID, err := gw.ConfirmSession(body.SessionID)
 	if err != nil { c.JSON(500, gin.H{"error":err.Error()}); return }
-	c.JSON(200, gin.H{"status":"ok","created":created,"subscription_id":subID})
+	c.JSON(200, gin.H{"status":"ok","created":created,"subscription_id":subID,"gateway":gw.Name()})
+}
+
+// getPaymentGateways lists the configured gateways and their payment methods for the checkout screen.
+func (h *Handler) getPaymentGateways(c *gin.Context) {
+	out := []gin.H{}
+	for _, name := range []string{GatewayStripe, GatewayMercadoPago, GatewayFake} {
+		gw, ok := h.gateways[name]
+		if !ok { continue }
+		out = append(out, gin.H{"name": name, "methods": gw.Methods(), "recurring": gw.Recurring(), "default": name == h.defaultGateway})
+	}
+	c.JSON(http.StatusOK, out)
 }
 
 // handleStripeWebhook processes Stripe webhook events to finalize subscriptions on successful payments
 func (h *Handler) handleStripeWebhook(c *gin.Context) {
-	if h.stripe == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "stripe no configurado"})
+	h.serveWebhook(c, GatewayStripe)
+}
+
+// handleGatewayWebhook handles POST /payments/:gateway/webhook for any configured gateway.
+func (h *Handler) handleGatewayWebhook(c *gin.Context) {
+	h.serveWebhook(c, c.Param("gateway"))
+}
+
+func (h *Handler) serveWebhook(c *gin.Context, name string) {
+	gw, ok := h.gateways[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pasarela de pago no configurada"})
 		return
 	}
-	if err := h.stripe.HandleWebhook(c.Writer, c.Request); err != nil {
+	if err := gw.HandleWebhook(c.Writer, c.Request); err != nil {
+		log.Printf("[PAYMENTS][%s][webhook] error: %v", name, err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 }
+
+// getMyPayments handles GET /me/payments?limit=&offset= (newest first).
+// Each item carries receipt_url: the gateway invoice PDF or our local receipt.
+func (h *Handler) getMyPayments(c *gin.Context) {
+	u := h.currentUser(c)
+	if u == nil {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	list, total, err := h.repo.GetUserPayments(u.ID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range list {
+		p := &list[i]
+		if p.HasGatewayInvoice() {
+			p.ReceiptURL = p.InvoicePDFURL
+		} else if p.InvoiceNumber != "" {
+			p.ReceiptURL = "/me/payments/" + strconv.Itoa(p.ID) + "/receipt"
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"payments": list, "total": total, "limit": limit, "offset": offset})
+}
+
+// getPaymentReceipt handles GET /me/payments/:id/receipt: redirects to the gateway invoice when
+// there is one, otherwise renders a PDF receipt with our company tax details.
+func (h *Handler) getPaymentReceipt(c *gin.Context) {
+	u := h.currentUser(c)
+	if u == nil {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	p, err := h.repo.GetPaymentByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if p == nil || p.UserID != u.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pago no encontrado"})
+		return
+	}
+	if p.HasGatewayInvoice() {
+		c.Redirect(http.StatusFound, p.InvoicePDFURL)
+		return
+	}
+	if p.InvoiceNumber == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "el pago no tiene recibo", "status": p.Status})
+		return
+	}
+	cust := ReceiptCustomer{Name: strings.TrimSpace(u.FirstName + " " + u.LastName), Email: u.Email}
+	pdf := RenderReceiptPDF(companyInfoFromEnv(), cust, p)
+	c.Header("Content-Disposition", `inline; filename="recibo-`+p.InvoiceNumber+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
@@ -0,0 +1,368 @@
+package subscriptions
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ema-backend/migrations"
+)
+
+// MercadoPagoGateway sells plans through Mercado Pago Checkout Pro, which covers the
+// methods our LatAm users actually have: PSE (Colombia), OXXO (México) and local cards.
+// Those methods can't be charged automatically, so each checkout buys one prepaid period
+// (end_date = period end) and the user renews by paying again.
+type MercadoPagoGateway struct {
+	repo          *Repository
+	accessToken   string
+	webhookSecret string
+	apiURL        string
+	notifyURL     string
+	successURL    string
+	cancelURL     string
+	methods       []string
+	sandbox       bool
+	httpClient    *http.Client
+}
+
+// NewMercadoPagoFromEnv returns a configured gateway or nil when MERCADOPAGO_ACCESS_TOKEN is missing.
+func NewMercadoPagoFromEnv(repo *Repository) *MercadoPagoGateway {
+	token := os.Getenv("MERCADOPAGO_ACCESS_TOKEN")
+	if token == "" {
+		return nil
+	}
+	apiURL := strings.TrimRight(os.Getenv("MERCADOPAGO_API_URL"), "/")
+	if apiURL == "" {
+		apiURL = "https://api.mercadopago.com"
+	}
+	methods := []string{}
+	for _, m := range strings.Split(os.Getenv("MERCADOPAGO_PAYMENT_METHODS"), ",") {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	if len(methods) == 0 {
+		methods = []string{"card", "pse", "oxxo"}
+	}
+	return &MercadoPagoGateway{
+		repo:          repo,
+		accessToken:   token,
+		webhookSecret: os.Getenv("MERCADOPAGO_WEBHOOK_SECRET"),
+		apiURL:        apiURL,
+		notifyURL:     os.Getenv("MERCADOPAGO_NOTIFICATION_URL"),
+		successURL:    checkoutSuccessURL(),
+		cancelURL:     checkoutCancelURL(),
+		methods:       methods,
+		sandbox:       os.Getenv("MERCADOPAGO_SANDBOX") == "1",
+		httpClient:    &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+func (g *MercadoPagoGateway) Name() string { return GatewayMercadoPago }
+
+func (g *MercadoPagoGateway) Methods() []string { return g.methods }
+
+func (g *MercadoPagoGateway) Recurring() bool { return false }
+
+// mpPreference is the subset of the Checkout Pro preference we send.
+type mpPreference struct {
+	Items             []mpItem          `json:"items"`
+	ExternalReference string            `json:"external_reference"`
+	Metadata          map[string]string `json:"metadata"`
+	BackURLs          map[string]string `json:"back_urls"`
+	AutoReturn        string            `json:"auto_return,omitempty"`
+	NotificationURL   string            `json:"notification_url,omitempty"`
+	PaymentMethods    *mpPaymentMethods `json:"payment_methods,omitempty"`
+}
+
+type mpItem struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Quantity   int     `json:"quantity"`
+	UnitPrice  float64 `json:"unit_price"`
+	CurrencyID string  `json:"currency_id"`
+}
+
+type mpPaymentMethods struct {
+	DefaultPaymentMethodID string `json:"default_payment_method_id,omitempty"`
+}
+
+// mpPayment is the subset of /v1/payments/{id} we read.
+type mpPayment struct {
+	ID                int64                  `json:"id"`
+	Status            string                 `json:"status"`
+	ExternalReference string                 `json:"external_reference"`
+	Metadata          map[string]interface{} `json:"metadata"`
+	TransactionAmount float64                `json:"transaction_amount"`
+	CurrencyID        string                 `json:"currency_id"`
+	PaymentMethodID   string                 `json:"payment_method_id"`
+}
+
+// buildPreference prices one period of the plan in the localized currency (promo discount applied;
+// the Mercado Pago account only accepts its country's currency) and restricts the
+// checkout to the requested method when it isn't a card.
+func (g *MercadoPagoGateway) buildPreference(ref string, plan *Plan, price PlanPrice, md map[string]string, opts CheckoutOptions) mpPreference {
+	currency := firstNonEmpty(price.Currency, plan.Currency)
+	amount := price.Price
+	if opts.Promo != nil {
+		amount = opts.Promo.ApplyTo(amount, currency)
+	}
+	title := plan.Name
+	if price.Interval == IntervalYear {
+		title += " (anual)"
+	} else if price.Interval == IntervalMonth {
+		title += " (mensual)"
+	}
+	pref := mpPreference{
+		Items:             []mpItem{{ID: "plan-" + strconv.Itoa(plan.ID), Title: title, Quantity: 1, UnitPrice: amount, CurrencyID: strings.ToUpper(currency)}},
+		ExternalReference: ref,
+		Metadata:          md,
+		BackURLs:          map[string]string{"success": g.successURL, "pending": g.successURL, "failure": g.cancelURL},
+		AutoReturn:        "approved",
+		NotificationURL:   g.notifyURL,
+	}
+	if m := strings.ToLower(opts.PaymentMethod); m != "" && m != "card" {
+		pref.PaymentMethods = &mpPaymentMethods{DefaultPaymentMethodID: m}
+	}
+	return pref
+}
+
+// CreateCheckout creates a Checkout Pro preference. The returned session id is our
+// external_reference, which ConfirmSession uses to find the payment.
+func (g *MercadoPagoGateway) CreateCheckout(ctx context.Context, userID, planID, frequency int, opts CheckoutOptions) (string, string, error) {
+	plan, err := g.repo.GetPlanByID(planID)
+	if err != nil || plan == nil {
+		return "", "", fmt.Errorf("plan inválido")
+	}
+	price, ok := plan.ResolveLocalizedPrice(frequency, opts.Country)
+	if !ok {
+		return "", "", ErrIntervalNotAvailable
+	}
+	opts.TrialDays = 0 // prepaid: no trial through the gateway
+	ref := "ema_" + randomHex(12)
+	pref := g.buildPreference(ref, plan, price, checkoutMetadata(userID, planID, frequency, price, opts), opts)
+	var out struct {
+		ID               string `json:"id"`
+		InitPoint        string `json:"init_point"`
+		SandboxInitPoint string `json:"sandbox_init_point"`
+	}
+	if err := g.call(ctx, http.MethodPost, "/checkout/preferences", pref, &out); err != nil {
+		log.Printf("[MERCADOPAGO][checkout] error: %v", err)
+		return "", "", err
+	}
+	if g.sandbox && out.SandboxInitPoint != "" {
+		return out.SandboxInitPoint, ref, nil
+	}
+	return out.InitPoint, ref, nil
+}
+
+// ConfirmSession looks up the payments of a checkout and activates the first approved one.
+func (g *MercadoPagoGateway) ConfirmSession(sessionID string) (bool, int, error) {
+	if sessionID == "" {
+		return false, 0, errors.New("session_id vacío")
+	}
+	var out struct {
+		Results []mpPayment `json:"results"`
+	}
+	q := url.Values{"external_reference": {sessionID}, "sort": {"date_created"}, "criteria": {"desc"}}
+	if err := g.call(context.Background(), http.MethodGet, "/v1/payments/search?"+q.Encode(), nil, &out); err != nil {
+		return false, 0, err
+	}
+	for _, p := range out.Results {
+		if p.Status == "approved" {
+			created, subID, err := activateCheckout(g.repo, mpMetadata(p.Metadata), GatewayMercadoPago, strconv.FormatInt(p.ID, 10), true)
+			if err == nil {
+				recordPayment(g.repo, mpPaymentRecord(p, subID))
+			}
+			return created, subID, err
+		}
+	}
+	return false, 0, nil
+}
+
+// HandleWebhook consumes payment notifications (JSON body or legacy IPN query params).
+// Approved payments create the subscription; refunds and chargebacks expire it.
+func (g *MercadoPagoGateway) HandleWebhook(w http.ResponseWriter, r *http.Request) error {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(payload, &event)
+	q := r.URL.Query()
+	if event.Type == "" {
+		event.Type = firstNonEmpty(q.Get("type"), q.Get("topic"))
+	}
+	if event.Data.ID == "" {
+		event.Data.ID = firstNonEmpty(q.Get("data.id"), q.Get("id"))
+	}
+	if g.webhookSecret != "" && !verifyMercadoPagoSignature(g.webhookSecret, r.Header.Get("x-signature"), r.Header.Get("x-request-id"), event.Data.ID) {
+		return errors.New("firma inválida")
+	}
+	if event.Type != "payment" || event.Data.ID == "" {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ignored"))
+		return nil
+	}
+	var p mpPayment
+	if err := g.call(r.Context(), http.MethodGet, "/v1/payments/"+url.PathEscape(event.Data.ID), nil, &p); err != nil {
+		return err
+	}
+	ref := strconv.FormatInt(p.ID, 10)
+	subID := 0
+	switch p.Status {
+	case "approved":
+		if _, subID, err = activateCheckout(g.repo, mpMetadata(p.Metadata), GatewayMercadoPago, ref, true); err != nil {
+			return err
+		}
+	case "refunded", "charged_back":
+		now := time.Now()
+		id, err := g.repo.SetStatusByGatewayReference(GatewayMercadoPago, ref, StatusExpired, &now)
+		if err != nil {
+			return err
+		}
+		if id != 0 {
+			log.Printf("[MERCADOPAGO][webhook] payment=%s %s -> sub=%d expired", ref, p.Status, id)
+			if uid, _ := strconv.Atoi(mpMetadata(p.Metadata)["user_id"]); uid != 0 {
+				if err := migrations.EnsureFreeSubscriptionForUser(uid); err != nil {
+					log.Printf("[MERCADOPAGO][webhook] free fallback user=%d failed: %v", uid, err)
+				}
+			}
+		}
+	default: // pending / in_process (OXXO voucher or PSE not yet paid), rejected...
+		log.Printf("[MERCADOPAGO][webhook] payment=%s status=%s method=%s", ref, p.Status, p.PaymentMethodID)
+	}
+	recordPayment(g.repo, mpPaymentRecord(p, subID))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+	return nil
+}
+
+// Cancel is a no-op: prepaid periods have nothing to stop at Mercado Pago.
+func (g *MercadoPagoGateway) Cancel(ctx context.Context, sub *Subscription, atPeriodEnd bool) error {
+	return nil
+}
+
+func (g *MercadoPagoGateway) call(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.apiURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("mercadopago %s %s: %d %s", method, path, resp.StatusCode, string(b))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// verifyMercadoPagoSignature checks the x-signature header ("ts=...,v1=...") against the
+// manifest "id:<data.id>;request-id:<x-request-id>;ts:<ts>;" signed with the webhook secret.
+func verifyMercadoPagoSignature(secret, header, requestID, dataID string) bool {
+	var ts, v1 string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "ts":
+			ts = kv[1]
+		case "v1":
+			v1 = kv[1]
+		}
+	}
+	if ts == "" || v1 == "" {
+		return false
+	}
+	manifest := "id:" + strings.ToLower(dataID) + ";"
+	if requestID != "" {
+		manifest += "request-id:" + requestID + ";"
+	}
+	manifest += "ts:" + ts + ";"
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(manifest))
+	return hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.ToLower(v1)))
+}
+
+// mpPaymentRecord maps a Mercado Pago payment to the payments history. Mercado Pago
+// issues no invoice PDF, so paid rows get a local receipt.
+func mpPaymentRecord(p mpPayment, subID int) *Payment {
+	rec := &Payment{Gateway: GatewayMercadoPago, GatewayPaymentID: strconv.FormatInt(p.ID, 10), Amount: p.TransactionAmount, Currency: p.CurrencyID, PaymentMethod: p.PaymentMethodID}
+	switch p.Status {
+	case "approved":
+		rec.Status = PaymentPaid
+	case "refunded", "charged_back":
+		rec.Status = PaymentRefunded
+	case "rejected", "cancelled":
+		rec.Status = PaymentFailed
+	default: // pending, in_process, authorized
+		rec.Status = PaymentPending
+	}
+	paymentFromMetadata(rec, mpMetadata(p.Metadata), subID)
+	return rec
+}
+
+// mpMetadata flattens the payment metadata echoed by Mercado Pago back to strings.
+func mpMetadata(md map[string]interface{}) map[string]string {
+	out := make(map[string]string, len(md))
+	for k, v := range md {
+		if v != nil {
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -2,12 +2,23 @@ package subscriptions
 
 import "time"
 
+// Subscription lifecycle statuses. active/past_due/canceled (until end_date) grant access;
+// expired never does.
+const (
+    StatusActive   = "active"
+    StatusPastDue  = "past_due"
+    StatusCanceled = "canceled"
+    StatusExpired  = "expired"
+)
+
 type Plan struct {
     ID            int     `json:"id"`
     Name          string  `json:"name"`
     Currency      string  `json:"currency"`
     Price         float64 `json:"price"`
     Billing       string  `json:"billing"`
+    Interval      string  `json:"interval"`       // day | week | month | year (default derived from Billing)
+    IntervalCount int     `json:"interval_count"` // e.g. 3 + month = trimestral
     Consultations int     `json:"consultations"`
     Questionnaires int    `json:"questionnaires"`
     ClinicalCases int     `json:"clinical_cases"`
@@ -15,15 +26,33 @@ type Plan struct {
     StripeProductID string `json:"stripe_product_id,omitempty"`
     StripePriceID   string `json:"stripe_price_id,omitempty"`
     Statistics    int     `json:"statistics"` // 1 = incluye estadísticas premium
+    TrialDays     int     `json:"trial_days"` // días de prueba gratis al suscribirse (0 = sin prueba)
+    Prices        []PlanPrice `json:"prices,omitempty"` // precios alternativos por intervalo (ej. anual con descuento)
+    VersionID     int     `json:"version_id,omitempty"` // versión inmutable vigente (o la fijada en la suscripción)
+    Version       int     `json:"version,omitempty"`
+    Entitlements  map[string]int `json:"entitlements,omitempty"` // features explícitas (ver Features); ausentes = valor por defecto
+    entitlementsRaw string
 }
 
 type Subscription struct {
     ID            int        `json:"id"`
     UserID        int        `json:"user_id"`
     PlanID        int        `json:"plan_id"`
+    PlanVersionID *int       `json:"plan_version_id,omitempty"` // versión del plan comprada: fija cuotas y límites
     StartDate     time.Time  `json:"start_date"`
-    EndDate       *time.Time `json:"end_date"`
+    EndDate       *time.Time `json:"end_date"` // fin del acceso (cancelación/expiración); nil = sin fin
+    Status        string     `json:"status"`
     Frequency     int        `json:"frequency"`
+    Interval      string     `json:"interval"`
+    IntervalCount int        `json:"interval_count"`
+    CurrentPeriodEnd *time.Time `json:"current_period_end"` // próxima renovación de cuotas
+    StripeSubscriptionID string `json:"stripe_subscription_id,omitempty"`
+    Gateway       string     `json:"payment_gateway,omitempty"`   // pasarela que cobró la suscripción (stripe, mercadopago, fake)
+    GatewayReference string  `json:"gateway_reference,omitempty"` // id del pago/suscripción en pasarelas distintas de Stripe
+    ScheduledPlanID *int     `json:"scheduled_plan_id,omitempty"` // downgrade pendiente, aplicado al fin del periodo
+    ScheduledInterval string `json:"scheduled_interval,omitempty"`
+    PromoCode     string     `json:"promo_code,omitempty"` // código promocional usado al suscribirse
+    TrialEndsAt   *time.Time `json:"trial_ends_at,omitempty"`
     Consultations int        `json:"consultations"`
     Questionnaires int       `json:"questionnaires"`
     ClinicalCases int        `json:"clinical_cases"`
@@ -31,4 +60,3 @@ type Subscription struct {
     Plan          *Plan      `json:"subscription_plan,omitempty"`
     Statistics    int        `json:"statistics"` // copia denormalizada para acceso rápido
 }
-
@@ -0,0 +1,76 @@
+package subscriptions
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payment statuses stored in payments.status.
+const (
+	PaymentPaid     = "paid"
+	PaymentPending  = "pending"
+	PaymentFailed   = "failed"
+	PaymentRefunded = "refunded"
+)
+
+// Payment is one charge reported by a gateway. Gateways that issue their own invoice
+// (Stripe) fill InvoiceNumber/InvoicePDFURL; the others get a local receipt number.
+type Payment struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"user_id"`
+	SubscriptionID   *int       `json:"subscription_id,omitempty"`
+	PlanID           *int       `json:"plan_id,omitempty"`
+	PlanName         string     `json:"plan_name,omitempty"`
+	Gateway          string     `json:"gateway"`
+	GatewayPaymentID string     `json:"gateway_payment_id"`
+	Amount           float64    `json:"amount"`
+	Currency         string     `json:"currency"`
+	Status           string     `json:"status"`
+	PaymentMethod    string     `json:"payment_method,omitempty"`
+	Description      string     `json:"description,omitempty"`
+	InvoiceNumber    string     `json:"invoice_number,omitempty"`
+	InvoicePDFURL    string     `json:"invoice_pdf_url,omitempty"`
+	ReceiptURL       string     `json:"receipt_url,omitempty"` // set by the API: gateway invoice or local receipt
+	PaidAt           *time.Time `json:"paid_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// HasGatewayInvoice reports whether the gateway issued its own invoice PDF for the payment.
+func (p *Payment) HasGatewayInvoice() bool {
+	return p.InvoicePDFURL != ""
+}
+
+// receiptNumberPrefix prefixes local receipt numbers (RECEIPT_NUMBER_PREFIX, default "EMA-R").
+func receiptNumberPrefix() string {
+	if v := strings.TrimSpace(os.Getenv("RECEIPT_NUMBER_PREFIX")); v != "" {
+		return v
+	}
+	return "EMA-R"
+}
+
+// paymentFromMetadata fills user/plan/subscription of a payment from checkout metadata.
+func paymentFromMetadata(p *Payment, md map[string]string, subID int) {
+	if uid, _ := strconv.Atoi(md["user_id"]); uid != 0 {
+		p.UserID = uid
+	}
+	if pid, _ := strconv.Atoi(md["plan_id"]); pid != 0 {
+		p.PlanID = &pid
+	}
+	if subID != 0 {
+		p.SubscriptionID = &subID
+	}
+}
+
+// recordPayment stores a gateway payment, logging instead of failing the webhook: a missing
+// history row must never block the subscription itself.
+func recordPayment(repo *Repository, p *Payment) {
+	if p.UserID == 0 || p.GatewayPaymentID == "" {
+		return
+	}
+	if err := repo.RecordPayment(p); err != nil {
+		log.Printf("[PAYMENTS][%s] record payment %s user=%d failed: %v", p.Gateway, p.GatewayPaymentID, p.UserID, err)
+	}
+}
@@ -0,0 +1,93 @@
+package subscriptions
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStripeInvoicePayment(t *testing.T) {
+	obj := stripeEventObject{ID: "in_1", Currency: "usd", AmountPaid: 1299, AmountDue: 1299, Number: "ABC-0001", InvoicePDF: "https://pay.stripe.com/invoice/pdf"}
+	obj.SubscriptionDetails.Metadata = map[string]string{"user_id": "5", "plan_id": "2"}
+	obj.StatusTransitions.PaidAt = 1735689600
+
+	p, ok := stripeInvoicePayment("invoice.paid", obj)
+	if !ok {
+		t.Fatal("invoice.paid not mapped")
+	}
+	if p.Status != PaymentPaid || p.Amount != 12.99 || p.Currency != "USD" || p.UserID != 5 || p.PlanID == nil || *p.PlanID != 2 {
+		t.Fatalf("payment = %+v", p)
+	}
+	if !p.HasGatewayInvoice() || p.PaidAt == nil || p.PaidAt.Unix() != 1735689600 {
+		t.Fatalf("invoice data = %+v", p)
+	}
+	if p, ok := stripeInvoicePayment("invoice.payment_failed", obj); !ok || p.Status != PaymentFailed {
+		t.Fatalf("failed invoice = %+v %v", p, ok)
+	}
+	obj.AmountPaid = 0 // trial invoice
+	if _, ok := stripeInvoicePayment("invoice.paid", obj); ok {
+		t.Fatal("zero-amount invoice recorded")
+	}
+	if _, ok := stripeInvoicePayment("customer.subscription.updated", obj); ok {
+		t.Fatal("non-invoice event recorded")
+	}
+}
+
+func TestMercadoPagoPaymentRecord(t *testing.T) {
+	md := map[string]interface{}{"user_id": "9", "plan_id": float64(4)}
+	cases := map[string]string{"approved": PaymentPaid, "pending": PaymentPending, "in_process": PaymentPending, "rejected": PaymentFailed, "refunded": PaymentRefunded}
+	for status, want := range cases {
+		rec := mpPaymentRecord(mpPayment{ID: 123, Status: status, TransactionAmount: 40000, CurrencyID: "COP", PaymentMethodID: "pse", Metadata: md}, 17)
+		if rec.Status != want {
+			t.Errorf("%s: got %s want %s", status, rec.Status, want)
+		}
+		if rec.GatewayPaymentID != "123" || rec.UserID != 9 || *rec.PlanID != 4 || *rec.SubscriptionID != 17 || rec.HasGatewayInvoice() {
+			t.Fatalf("%s: record = %+v", status, rec)
+		}
+	}
+}
+
+func TestTaxBreakdown(t *testing.T) {
+	base, tax := taxBreakdown(119, 19)
+	if base != 100 || tax != 19 {
+		t.Fatalf("got base=%v tax=%v", base, tax)
+	}
+	if base, tax := taxBreakdown(50, 0); base != 50 || tax != 0 {
+		t.Fatalf("no tax: got base=%v tax=%v", base, tax)
+	}
+}
+
+func TestRenderReceiptPDF(t *testing.T) {
+	paid := time.Date(2025, 2, 3, 12, 0, 0, 0, time.UTC)
+	p := &Payment{ID: 12, Gateway: GatewayMercadoPago, GatewayPaymentID: "123", PlanName: "Premium (anual)", Amount: 119000, Currency: "COP", Status: PaymentPaid, InvoiceNumber: "EMA-R-000012", PaidAt: &paid}
+	co := CompanyInfo{Name: "EMA Salud S.A.S.", TaxID: "900123456-7", TaxName: "IVA", TaxRate: 19, Note: "nota"}
+	pdf := RenderReceiptPDF(co, ReceiptCustomer{Name: "Ana Muñoz", Email: "ana@example.com"}, p)
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("not a PDF document")
+	}
+	for _, want := range []string{"EMA-R-000012", "NIT/RFC: 900123456-7", "03/02/2025", "COP 100000.00", "COP 19000.00", "Suscripci\xf3n Premium \\(anual\\)", "Ana Mu\xf1oz"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("receipt missing %q", want)
+		}
+	}
+	// every xref entry must point at its object header
+	m := regexp.MustCompile(`(?s)xref\n0 (\d+)\n0000000000 65535 f \n(.*)trailer`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("xref table not found")
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(m[2], -1)
+	if len(offsets) != n-1 {
+		t.Fatalf("xref has %d entries, want %d", len(offsets), n-1)
+	}
+	for i, off := range offsets {
+		o, _ := strconv.Atoi(string(off[1]))
+		if !bytes.HasPrefix(pdf[o:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Errorf("xref entry %d points to %q", i+1, pdf[o:o+10])
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package subscriptions
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"ema-backend/countries"
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countryHeaders are geolocation headers set by CDNs / load balancers, in priority order.
+var countryHeaders = []string{"CF-IPCountry", "CloudFront-Viewer-Country", "X-AppEngine-Country", "X-Country-Code"}
+
+// NormalizeCountryCode returns an upper-case ISO alpha-2 code, or "" for anything else
+// (including Cloudflare's XX = unknown and T1 = Tor).
+func NormalizeCountryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 2 || code == "XX" || code == "T1" || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return ""
+	}
+	return code
+}
+
+// CountryForUser returns the ISO code of the user's profile country ("" when unset).
+func CountryForUser(u *migrations.User) string {
+	if u == nil || u.CountryID == nil {
+		return ""
+	}
+	if ct, ok := countries.ByID(*u.CountryID); ok {
+		return ct.ShortCode
+	}
+	return ""
+}
+
+// RequestCountry resolves the buyer country for pricing: explicit ?country=, then the
+// user's profile country_id, then the geolocation headers. "" means default prices.
+func RequestCountry(c *gin.Context, u *migrations.User) string {
+	if code := NormalizeCountryCode(c.Query("country")); code != "" {
+		return code
+	}
+	if code := CountryForUser(u); code != "" {
+		return code
+	}
+	for _, h := range countryHeaders {
+		if code := NormalizeCountryCode(c.GetHeader(h)); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+// ResolveLocalizedPrice is ResolvePrice for a buyer country: the country's price point for
+// the requested interval wins, otherwise the default price (plan currency) applies.
+func (p *Plan) ResolveLocalizedPrice(freq int, country string) (PlanPrice, bool) {
+	country = NormalizeCountryCode(country)
+	if country != "" {
+		want, ok := IntervalForFrequency(freq)
+		if !ok {
+			want = NormalizeInterval(p.Interval)
+		}
+		for _, pp := range p.Prices {
+			if pp.CountryCode == country && NormalizeInterval(pp.Interval) == want {
+				return p.localCurrency(pp), true
+			}
+		}
+	}
+	return p.ResolvePrice(freq)
+}
+
+// LocalizedPrices lists the alternative interval prices a country sees (the plan default
+// interval excluded, as it's returned as the plan price): country rows replace the default
+// row of the same interval.
+func (p *Plan) LocalizedPrices(country string) []PlanPrice {
+	country = NormalizeCountryCode(country)
+	base := NormalizeInterval(p.Interval)
+	out := []PlanPrice{}
+	index := map[string]int{}
+	for _, pp := range p.Prices {
+		if pp.CountryCode != "" && pp.CountryCode != country {
+			continue
+		}
+		interval := NormalizeInterval(pp.Interval)
+		if interval == base {
+			continue
+		}
+		if pp.CountryCode == "" {
+			pp = p.withCurrency(pp)
+		} else {
+			pp = p.localCurrency(pp)
+		}
+		key := interval + "/" + strconv.Itoa(pp.IntervalCount)
+		if i, ok := index[key]; ok {
+			if pp.CountryCode != "" {
+				out[i] = pp
+			}
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, pp)
+	}
+	return out
+}
+
+var errInvalidCountry = errors.New("country_code inválido")
+
+// normalizePlanPrice validates the country of a price point and defaults its currency to
+// the country's one (admin input).
+func normalizePlanPrice(pp *PlanPrice) error {
+	if strings.TrimSpace(pp.CountryCode) == "" {
+		pp.CountryCode = ""
+		return nil
+	}
+	code := NormalizeCountryCode(pp.CountryCode)
+	if code == "" {
+		return errInvalidCountry
+	}
+	pp.CountryCode = code
+	if strings.TrimSpace(pp.Currency) == "" {
+		if ct, ok := countries.ByCode(code); ok {
+			pp.Currency = ct.Currency
+		}
+	}
+	return nil
+}
+
+// localCurrency fills the currency of a country price point from the countries list.
+func (p *Plan) localCurrency(pp PlanPrice) PlanPrice {
+	if pp.Currency == "" {
+		if ct, ok := countries.ByCode(pp.CountryCode); ok {
+			pp.Currency = ct.Currency
+		} else {
+			pp.Currency = p.Currency
+		}
+	}
+	return pp
+}
+
+// zeroDecimalCurrencies are charged by Stripe in whole units (no cents).
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true, "KRW": true, "MGA": true,
+	"PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// toStripeAmount converts a price to Stripe's smallest currency unit.
+func toStripeAmount(amount float64, currency string) int64 {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return int64(math.Round(amount))
+	}
+	return int64(math.Round(amount * 100))
+}
+
+// fromStripeAmount is the inverse of toStripeAmount.
+func fromStripeAmount(amount int64, currency string) float64 {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return float64(amount)
+	}
+	return float64(amount) / 100
+}
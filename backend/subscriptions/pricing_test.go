@@ -0,0 +1,104 @@
+package subscriptions
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"ema-backend/migrations"
+
+	"github.com/gin-gonic/gin"
+)
+
+func localizedPlan() *Plan {
+	return &Plan{ID: 2, Currency: "USD", Price: 10, Interval: IntervalMonth, IntervalCount: 1, Prices: []PlanPrice{
+		{ID: 1, Interval: IntervalYear, IntervalCount: 1, Price: 100},
+		{ID: 2, CountryCode: "CO", Interval: IntervalMonth, IntervalCount: 1, Price: 40000},
+		{ID: 3, CountryCode: "CO", Interval: IntervalYear, IntervalCount: 1, Price: 400000, Currency: "COP"},
+		{ID: 4, CountryCode: "MX", Interval: IntervalMonth, IntervalCount: 1, Price: 199},
+	}}
+}
+
+func TestResolveLocalizedPrice(t *testing.T) {
+	p := localizedPlan()
+	cases := []struct {
+		country  string
+		freq     int
+		wantID   int
+		price    float64
+		currency string
+	}{
+		{"", FrequencyDefault, 0, 10, "USD"},
+		{"", FrequencyAnnual, 1, 100, "USD"},
+		{"co", FrequencyDefault, 2, 40000, "COP"}, // currency from the countries list
+		{"CO", FrequencyAnnual, 3, 400000, "COP"},
+		{"MX", FrequencyMonthly, 4, 199, "MXN"},
+		{"MX", FrequencyAnnual, 1, 100, "USD"}, // no MX annual price: default one
+		{"ES", FrequencyDefault, 0, 10, "USD"},
+	}
+	for _, tc := range cases {
+		got, ok := p.ResolveLocalizedPrice(tc.freq, tc.country)
+		if !ok || got.ID != tc.wantID || got.Price != tc.price || got.Currency != tc.currency {
+			t.Errorf("%q/%d: got %+v ok=%v", tc.country, tc.freq, got, ok)
+		}
+	}
+}
+
+func TestLocalizedPrices(t *testing.T) {
+	p := localizedPlan()
+	if got := p.LocalizedPrices("CO"); len(got) != 1 || got[0].ID != 3 {
+		t.Errorf("CO: got %+v", got)
+	}
+	if got := p.LocalizedPrices("MX"); len(got) != 1 || got[0].ID != 1 || got[0].Currency != "USD" {
+		t.Errorf("MX: got %+v", got)
+	}
+	if got := p.LocalizedPrices(""); len(got) != 1 || got[0].ID != 1 {
+		t.Errorf("default: got %+v", got)
+	}
+}
+
+func TestRequestCountry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	colombia := 1
+	newCtx := func(url string, headers map[string]string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", url, nil)
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		return c
+	}
+	if got := RequestCountry(newCtx("/plans?country=pe", map[string]string{"CF-IPCountry": "MX"}), &migrations.User{CountryID: &colombia}); got != "PE" {
+		t.Errorf("query override: got %q", got)
+	}
+	if got := RequestCountry(newCtx("/plans", map[string]string{"CF-IPCountry": "MX"}), &migrations.User{CountryID: &colombia}); got != "CO" {
+		t.Errorf("profile country: got %q", got)
+	}
+	if got := RequestCountry(newCtx("/plans", map[string]string{"CF-IPCountry": "XX", "X-Country-Code": "cl"}), nil); got != "CL" {
+		t.Errorf("geolocation header: got %q", got)
+	}
+	if got := RequestCountry(newCtx("/plans", nil), nil); got != "" {
+		t.Errorf("unknown: got %q", got)
+	}
+}
+
+func TestNormalizePlanPrice(t *testing.T) {
+	pp := PlanPrice{CountryCode: " ar "}
+	if err := normalizePlanPrice(&pp); err != nil || pp.CountryCode != "AR" || pp.Currency != "ARS" {
+		t.Fatalf("got %+v err=%v", pp, err)
+	}
+	if err := normalizePlanPrice(&PlanPrice{CountryCode: "Colombia"}); err == nil {
+		t.Fatal("expected invalid country error")
+	}
+}
+
+func TestStripeAmounts(t *testing.T) {
+	if got := toStripeAmount(12.99, "usd"); got != 1299 {
+		t.Errorf("usd: got %d", got)
+	}
+	if got := toStripeAmount(9990, "CLP"); got != 9990 {
+		t.Errorf("clp: got %d", got)
+	}
+	if got := fromStripeAmount(9990, "clp"); got != 9990 {
+		t.Errorf("clp back: got %v", got)
+	}
+}
@@ -0,0 +1,95 @@
+package subscriptions
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// PromoCode is a marketing discount and/or trial grant redeemable at checkout.
+// PercentOff and AmountOff are mutually exclusive; TrialDays>0 overrides the plan trial.
+type PromoCode struct {
+	ID               int        `json:"id"`
+	Code             string     `json:"code"`
+	Description      string     `json:"description"`
+	PercentOff       float64    `json:"percent_off"`
+	AmountOff        float64    `json:"amount_off"`
+	Currency         string     `json:"currency,omitempty"`
+	Duration         string     `json:"duration"` // once | repeating | forever (Stripe coupon semantics)
+	DurationInMonths int        `json:"duration_in_months,omitempty"`
+	TrialDays        int        `json:"trial_days"`
+	PlanID           *int       `json:"plan_id,omitempty"` // nil = any plan
+	MaxRedemptions   int        `json:"max_redemptions"`   // 0 = unlimited
+	TimesRedeemed    int        `json:"times_redeemed"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	Active           bool       `json:"active"`
+	StripeCouponID   string     `json:"stripe_coupon_id,omitempty"`
+}
+
+var (
+	ErrPromoInvalid       = errors.New("promo_code_invalid")
+	ErrPromoExpired       = errors.New("promo_code_expired")
+	ErrPromoExhausted     = errors.New("promo_code_exhausted")
+	ErrPromoNotApplicable = errors.New("promo_code_not_applicable")
+	ErrPromoAlreadyUsed   = errors.New("promo_code_already_used")
+	ErrTrialAlreadyUsed   = errors.New("trial_already_used")
+)
+
+// NormalizePromoCode upper-cases and trims a user-entered code.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks that the code can be redeemed for planID at now. Per-user reuse is
+// checked separately by the repository (HasRedeemedPromo).
+func (pc *PromoCode) Validate(planID int, now time.Time) error {
+	if pc == nil || !pc.Active {
+		return ErrPromoInvalid
+	}
+	if pc.ExpiresAt != nil && !now.Before(*pc.ExpiresAt) {
+		return ErrPromoExpired
+	}
+	if pc.MaxRedemptions > 0 && pc.TimesRedeemed >= pc.MaxRedemptions {
+		return ErrPromoExhausted
+	}
+	if pc.PlanID != nil && *pc.PlanID != planID {
+		return ErrPromoNotApplicable
+	}
+	return nil
+}
+
+// ApplyTo returns the discounted amount of price (never below zero). Fixed amounts only
+// apply when the currency matches.
+func (pc *PromoCode) ApplyTo(price float64, currency string) float64 {
+	if pc == nil {
+		return price
+	}
+	out := price
+	if pc.PercentOff > 0 {
+		out = price * (1 - pc.PercentOff/100)
+	} else if pc.AmountOff > 0 && (pc.Currency == "" || strings.EqualFold(pc.Currency, currency)) {
+		out = price - pc.AmountOff
+	}
+	if out < 0 {
+		out = 0
+	}
+	return math.Round(out*100) / 100
+}
+
+// IsFullDiscountForever reports whether the code makes a plan free for its whole life,
+// in which case no payment gateway is needed.
+func (pc *PromoCode) IsFullDiscountForever() bool {
+	return pc != nil && pc.PercentOff >= 100 && pc.Duration == "forever"
+}
+
+// trialDaysFor resolves the trial length for a checkout: promo trial overrides plan trial.
+func trialDaysFor(plan *Plan, pc *PromoCode) int {
+	if pc != nil && pc.TrialDays > 0 {
+		return pc.TrialDays
+	}
+	if plan != nil {
+		return plan.TrialDays
+	}
+	return 0
+}
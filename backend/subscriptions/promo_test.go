@@ -0,0 +1,57 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPromoCodeValidate(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	plan := 3
+	cases := []struct {
+		name string
+		pc   *PromoCode
+		want error
+	}{
+		{"nil", nil, ErrPromoInvalid},
+		{"inactive", &PromoCode{Active: false}, ErrPromoInvalid},
+		{"expired", &PromoCode{Active: true, ExpiresAt: &past}, ErrPromoExpired},
+		{"exhausted", &PromoCode{Active: true, MaxRedemptions: 10, TimesRedeemed: 10}, ErrPromoExhausted},
+		{"other plan", &PromoCode{Active: true, PlanID: &plan}, ErrPromoNotApplicable},
+		{"ok", &PromoCode{Active: true, MaxRedemptions: 10, TimesRedeemed: 9}, nil},
+	}
+	for _, tc := range cases {
+		if got := tc.pc.Validate(2, now); got != tc.want {
+			t.Errorf("%s: got %v want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPromoCodeApplyTo(t *testing.T) {
+	if got := (&PromoCode{PercentOff: 20}).ApplyTo(19.99, "USD"); got != 15.99 {
+		t.Fatalf("percent: got %v", got)
+	}
+	if got := (&PromoCode{AmountOff: 5, Currency: "usd"}).ApplyTo(9.99, "USD"); got != 4.99 {
+		t.Fatalf("amount: got %v", got)
+	}
+	if got := (&PromoCode{AmountOff: 5, Currency: "EUR"}).ApplyTo(9.99, "USD"); got != 9.99 {
+		t.Fatalf("amount in other currency must not apply: got %v", got)
+	}
+	if got := (&PromoCode{AmountOff: 50}).ApplyTo(9.99, "USD"); got != 0 {
+		t.Fatalf("never below zero: got %v", got)
+	}
+}
+
+func TestTrialDaysFor(t *testing.T) {
+	plan := &Plan{TrialDays: 7}
+	if trialDaysFor(plan, nil) != 7 {
+		t.Fatalf("plan trial expected")
+	}
+	if trialDaysFor(plan, &PromoCode{TrialDays: 14}) != 14 {
+		t.Fatalf("promo trial should override plan trial")
+	}
+	if trialDaysFor(plan, &PromoCode{PercentOff: 10}) != 7 {
+		t.Fatalf("discount-only promo keeps plan trial")
+	}
+}
@@ -0,0 +1,210 @@
+package subscriptions
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CompanyInfo is the issuer printed on local receipts (RECEIPT_COMPANY_* env vars).
+type CompanyInfo struct {
+	Name    string
+	TaxID   string // NIT / RFC / RUC...
+	Address string
+	City    string
+	Email   string
+	TaxName string  // e.g. IVA
+	TaxRate float64 // percent already included in prices; 0 = not itemised
+	Note    string  // legal footer
+}
+
+// ReceiptCustomer identifies who paid.
+type ReceiptCustomer struct {
+	Name  string
+	Email string
+}
+
+func companyInfoFromEnv() CompanyInfo {
+	co := CompanyInfo{
+		Name:    os.Getenv("RECEIPT_COMPANY_NAME"),
+		TaxID:   os.Getenv("RECEIPT_COMPANY_TAX_ID"),
+		Address: os.Getenv("RECEIPT_COMPANY_ADDRESS"),
+		City:    os.Getenv("RECEIPT_COMPANY_CITY"),
+		Email:   os.Getenv("RECEIPT_COMPANY_EMAIL"),
+		TaxName: os.Getenv("RECEIPT_TAX_NAME"),
+		Note:    os.Getenv("RECEIPT_FOOTER_NOTE"),
+	}
+	co.TaxRate, _ = strconv.ParseFloat(os.Getenv("RECEIPT_TAX_RATE"), 64)
+	if co.Name == "" {
+		co.Name = "EMA"
+	}
+	if co.TaxName == "" {
+		co.TaxName = "IVA"
+	}
+	if co.Note == "" {
+		co.Note = "Recibo de pago generado electrónicamente. No reemplaza la factura electrónica cuando esta sea exigible."
+	}
+	return co
+}
+
+var paymentStatusLabels = map[string]string{
+	PaymentPaid:     "Pagado",
+	PaymentPending:  "Pendiente",
+	PaymentFailed:   "Fallido",
+	PaymentRefunded: "Reembolsado",
+}
+
+// taxBreakdown splits a tax-inclusive total into base and tax (rounded to cents).
+func taxBreakdown(total, rate float64) (base, tax float64) {
+	if rate <= 0 {
+		return total, 0
+	}
+	base = math.Round(total/(1+rate/100)*100) / 100
+	return base, math.Round((total-base)*100) / 100
+}
+
+func formatMoney(amount float64, currency string) string {
+	return fmt.Sprintf("%s %.2f", strings.ToUpper(currency), amount)
+}
+
+// RenderReceiptPDF builds a one-page PDF receipt for a payment without gateway invoice.
+func RenderReceiptPDF(co CompanyInfo, cust ReceiptCustomer, p *Payment) []byte {
+	doc := &pdfPage{}
+	y := 790.0
+	doc.text(50, y, 18, true, "Recibo de pago")
+	doc.text(400, y, 10, false, "N.º "+p.InvoiceNumber)
+	y -= 30
+	doc.text(50, y, 11, true, co.Name)
+	for _, l := range []string{prefixed("NIT/RFC: ", co.TaxID), co.Address, co.City, co.Email} {
+		if l != "" {
+			y -= 14
+			doc.text(50, y, 9, false, l)
+		}
+	}
+	y -= 28
+	date := p.CreatedAt
+	if p.PaidAt != nil {
+		date = *p.PaidAt
+	}
+	doc.text(50, y, 10, false, "Fecha: "+date.Format("02/01/2006"))
+	y -= 14
+	doc.text(50, y, 10, false, "Cliente: "+cust.Name)
+	y -= 14
+	doc.text(50, y, 10, false, "Correo: "+cust.Email)
+
+	y -= 30
+	doc.rule(50, y+12, 545)
+	doc.text(50, y, 10, true, "Descripción")
+	doc.text(420, y, 10, true, "Importe")
+	y -= 6
+	doc.rule(50, y, 545)
+	y -= 16
+	desc := p.Description
+	if desc == "" {
+		desc = "Suscripción " + p.PlanName
+	}
+	doc.text(50, y, 10, false, desc)
+	doc.text(420, y, 10, false, formatMoney(p.Amount, p.Currency))
+	y -= 24
+	if base, tax := taxBreakdown(p.Amount, co.TaxRate); tax > 0 {
+		doc.text(300, y, 10, false, "Base")
+		doc.text(420, y, 10, false, formatMoney(base, p.Currency))
+		y -= 14
+		doc.text(300, y, 10, false, fmt.Sprintf("%s (%g%%)", co.TaxName, co.TaxRate))
+		doc.text(420, y, 10, false, formatMoney(tax, p.Currency))
+		y -= 14
+	}
+	doc.text(300, y, 11, true, "Total")
+	doc.text(420, y, 11, true, formatMoney(p.Amount, p.Currency))
+
+	y -= 40
+	method := p.Gateway
+	if p.PaymentMethod != "" {
+		method += " / " + p.PaymentMethod
+	}
+	doc.text(50, y, 9, false, "Medio de pago: "+method)
+	y -= 13
+	doc.text(50, y, 9, false, "Referencia: "+p.GatewayPaymentID)
+	y -= 13
+	status := paymentStatusLabels[p.Status]
+	if status == "" {
+		status = p.Status
+	}
+	doc.text(50, y, 9, false, "Estado: "+status)
+	doc.text(50, 60, 8, false, co.Note)
+	return doc.bytes()
+}
+
+func prefixed(prefix, v string) string {
+	if v == "" {
+		return ""
+	}
+	return prefix + v
+}
+
+// pdfPage is a minimal single-page A4 PDF writer (Helvetica, WinAnsi) for receipts;
+// it avoids pulling a PDF library for a handful of text lines.
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (d *pdfPage) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&d.content, "BT /%s %g Tf %g %g Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+func (d *pdfPage) rule(x1, y, x2 float64) {
+	fmt.Fprintf(&d.content, "0.5 w %g %g m %g %g l S\n", x1, y, x2, y)
+}
+
+func (d *pdfPage) bytes() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", d.content.Len(), d.content.String()),
+	}
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape encodes s as a WinAnsi literal string body (Latin-1 accents, € kept; others as '?').
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '€':
+			b.WriteByte(0x80)
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r < 0x20 || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package subscriptions
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"ema-backend/migrations"
+)
+
+// Renewer is the periodic subscription lifecycle job: it expires subscriptions past their
+// end_date (falling back to the Free plan) and refills quotas of subscriptions whose
+// billing period ended, so monthly plans renew monthly and annual plans renew yearly.
+type Renewer struct {
+	repo     *Repository
+	interval time.Duration
+}
+
+// NewRenewer builds a renewer; SUBSCRIPTION_RENEWAL_INTERVAL_MIN overrides the default 60 min tick.
+func NewRenewer(repo *Repository) *Renewer {
+	every := 60 * time.Minute
+	if v := os.Getenv("SUBSCRIPTION_RENEWAL_INTERVAL_MIN"); v != "" {
+		if d, err := time.ParseDuration(v + "m"); err == nil && d > 0 {
+			every = d
+		}
+	}
+	return &Renewer{repo: repo, interval: every}
+}
+
+// Start runs one pass immediately and then on every tick.
+func (r *Renewer) Start() {
+	ticker := time.NewTicker(r.interval)
+	go func() {
+		r.runOnce()
+		for range ticker.C {
+			r.runOnce()
+		}
+	}()
+}
+
+func (r *Renewer) runOnce() {
+	now := time.Now()
+	users, err := r.repo.ExpireLapsedSubscriptions(now)
+	if err != nil {
+		log.Printf("[SUBSCRIPTIONS][EXPIRE] error: %v", err)
+	}
+	for _, uid := range users {
+		if err := migrations.EnsureFreeSubscriptionForUser(uid); err != nil {
+			log.Printf("[SUBSCRIPTIONS][EXPIRE] free fallback user=%d failed: %v", uid, err)
+		}
+	}
+	if len(users) > 0 {
+		log.Printf("[SUBSCRIPTIONS][EXPIRE] users_downgraded=%d", len(users))
+	}
+	n, err := r.repo.RenewDueSubscriptions(now)
+	if err != nil {
+		log.Printf("[SUBSCRIPTIONS][RENEWAL] error: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[SUBSCRIPTIONS][RENEWAL] renewed=%d", n)
+	}
+}
@@ -3,19 +3,76 @@ package subscriptions
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
+
+	"ema-backend/conn"
 )
 
 type Repository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect conn.Dialect
 }
 
 func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: db, dialect: conn.DialectOf(db)}
+}
+
+// planColumns / subscriptionColumns keep SELECT lists and Scan targets in sync.
+// COALESCE to avoid scanning NULL into string fields; statistics: heuristic (price>0 => 1)
+const planColumns = `p.id, p.name, p.currency, p.price, p.billing, COALESCE(p.billing_interval,'month'), COALESCE(p.billing_interval_count,1), p.consultations, p.questionnaires, p.clinical_cases, p.files, COALESCE(p.stripe_product_id,''), COALESCE(p.stripe_price_id,''), CASE WHEN p.price>0 THEN 1 ELSE 0 END AS statistics, COALESCE(p.trial_days,0), COALESCE(p.current_version_id,0), COALESCE(p.version,0), COALESCE(p.entitlements,'')`
+
+// pinnedPlanColumns is planColumns for subscription rows: limits, price and interval come
+// from the plan version the subscription is pinned to (see subscriptionPlanJoin).
+const pinnedPlanColumns = `p.id, p.name, COALESCE(v.currency,p.currency), COALESCE(v.price,p.price), p.billing, COALESCE(v.billing_interval,p.billing_interval,'month'), COALESCE(v.interval_count,p.billing_interval_count,1), COALESCE(v.consultations,p.consultations), COALESCE(v.questionnaires,p.questionnaires), COALESCE(v.clinical_cases,p.clinical_cases), COALESCE(v.files,p.files), COALESCE(p.stripe_product_id,''), COALESCE(p.stripe_price_id,''), COALESCE(v.statistics, CASE WHEN p.price>0 THEN 1 ELSE 0 END) AS statistics, COALESCE(v.trial_days,p.trial_days,0), COALESCE(v.id,p.current_version_id,0), COALESCE(v.version,p.version,0), COALESCE(v.entitlements,p.entitlements,'')`
+
+const subscriptionPlanJoin = `JOIN subscription_plans p ON s.plan_id = p.id LEFT JOIN subscription_plan_versions v ON v.id = s.plan_version_id`
+
+const subscriptionColumns = `s.id, s.user_id, s.plan_id, s.start_date, s.end_date, COALESCE(s.status,'active'), s.frequency, COALESCE(s.billing_interval,'month'), COALESCE(s.interval_count,1), s.current_period_end, COALESCE(s.stripe_subscription_id,''), COALESCE(s.payment_gateway,''), COALESCE(s.gateway_reference,''), s.scheduled_plan_id, COALESCE(s.scheduled_interval,''), COALESCE(s.promo_code,''), s.trial_ends_at, s.consultations, s.questionnaires, s.clinical_cases, s.files, s.plan_version_id`
+
+// activeSubscriptionWhere selects subscriptions that currently grant access: started, not
+// past their end_date and not expired. Takes the reference time twice.
+const activeSubscriptionWhere = `COALESCE(s.status,'active') <> 'expired' AND s.start_date <= ? AND (s.end_date IS NULL OR s.end_date > ?)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func planScanTargets(p *Plan) []interface{} {
+	return []interface{}{&p.ID, &p.Name, &p.Currency, &p.Price, &p.Billing, &p.Interval, &p.IntervalCount, &p.Consultations, &p.Questionnaires, &p.ClinicalCases, &p.Files, &p.StripeProductID, &p.StripePriceID, &p.Statistics, &p.TrialDays, &p.VersionID, &p.Version, &p.entitlementsRaw}
+}
+
+// scanSubscriptionWithPlan reads a row produced by "SELECT subscriptionColumns, pinnedPlanColumns".
+func scanSubscriptionWithPlan(row rowScanner) (*Subscription, error) {
+	var s Subscription
+	var plan Plan
+	var periodEnd sql.NullTime
+	var scheduledPlan, planVersion sql.NullInt64
+	dest := []interface{}{&s.ID, &s.UserID, &s.PlanID, &s.StartDate, &s.EndDate, &s.Status, &s.Frequency, &s.Interval, &s.IntervalCount, &periodEnd, &s.StripeSubscriptionID, &s.Gateway, &s.GatewayReference, &scheduledPlan, &s.ScheduledInterval, &s.PromoCode, &s.TrialEndsAt, &s.Consultations, &s.Questionnaires, &s.ClinicalCases, &s.Files, &planVersion}
+	dest = append(dest, planScanTargets(&plan)...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if periodEnd.Valid {
+		t := periodEnd.Time
+		s.CurrentPeriodEnd = &t
+	}
+	if scheduledPlan.Valid {
+		id := int(scheduledPlan.Int64)
+		s.ScheduledPlanID = &id
+	}
+	if planVersion.Valid {
+		id := int(planVersion.Int64)
+		s.PlanVersionID = &id
+	}
+	plan.setEntitlementsFromColumn(plan.entitlementsRaw)
+	s.Statistics = plan.Statistics
+	s.Plan = &plan
+	return &s, nil
 }
 
 func (r *Repository) GetPlans() ([]Plan, error) {
-	// COALESCE to avoid scanning NULL into string fields; statistics: heuristic (price>0 => 1)
-	rows, err := r.db.Query(`SELECT id, name, currency, price, billing, consultations, questionnaires, clinical_cases, files, COALESCE(stripe_product_id,''), COALESCE(stripe_price_id,''), CASE WHEN price>0 THEN 1 ELSE 0 END AS statistics FROM subscription_plans`)
+	rows, err := r.db.Query(`SELECT ` + planColumns + ` FROM subscription_plans p`)
 	if err != nil {
 		return nil, err
 	}
@@ -23,44 +80,66 @@ func (r *Repository) GetPlans() ([]Plan, error) {
 	plans := []Plan{}
 	for rows.Next() {
 		var p Plan
-	if err := rows.Scan(&p.ID, &p.Name, &p.Currency, &p.Price, &p.Billing, &p.Consultations, &p.Questionnaires, &p.ClinicalCases, &p.Files, &p.StripeProductID, &p.StripePriceID, &p.Statistics); err != nil {
+		if err := rows.Scan(planScanTargets(&p)...); err != nil {
 			return nil, err
 		}
+		p.setEntitlementsFromColumn(p.entitlementsRaw)
 		plans = append(plans, p)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	prices, err := r.getAllPlanPrices()
+	if err != nil {
+		return nil, err
+	}
+	for i := range plans {
+		plans[i].Prices = prices[plans[i].ID]
+	}
 	return plans, nil
 }
 
 // GetPlanByID returns a plan by its ID
 func (r *Repository) GetPlanByID(id int) (*Plan, error) {
-	row := r.db.QueryRow(`SELECT id, name, currency, price, billing, consultations, questionnaires, clinical_cases, files, COALESCE(stripe_product_id,''), COALESCE(stripe_price_id,''), CASE WHEN price>0 THEN 1 ELSE 0 END AS statistics FROM subscription_plans WHERE id=? LIMIT 1`, id)
+	row := r.db.QueryRow(`SELECT `+planColumns+` FROM subscription_plans p WHERE p.id=? LIMIT 1`, id)
 	var p Plan
-	if err := row.Scan(&p.ID, &p.Name, &p.Currency, &p.Price, &p.Billing, &p.Consultations, &p.Questionnaires, &p.ClinicalCases, &p.Files, &p.StripeProductID, &p.StripePriceID, &p.Statistics); err != nil {
+	if err := row.Scan(planScanTargets(&p)...); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
+	p.setEntitlementsFromColumn(p.entitlementsRaw)
+	prices, err := r.GetPlanPrices(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	p.Prices = prices
 	return &p, nil
 }
 
 func (r *Repository) CreatePlan(p *Plan) error {
-	res, err := r.db.Exec(`INSERT INTO subscription_plans (name, currency, price, billing, consultations, questionnaires, clinical_cases, files, stripe_product_id, stripe_price_id) VALUES (?,?,?,?,?,?,?,?,?,?)`,
-		p.Name, p.Currency, p.Price, p.Billing, p.Consultations, p.Questionnaires, p.ClinicalCases, p.Files, p.StripeProductID, p.StripePriceID)
+	normalizePlanBilling(p)
+	id, err := r.dialect.InsertID(r.db, `INSERT INTO subscription_plans (name, currency, price, billing, billing_interval, billing_interval_count, consultations, questionnaires, clinical_cases, files, stripe_product_id, stripe_price_id, trial_days, entitlements) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		p.Name, p.Currency, p.Price, p.Billing, p.Interval, p.IntervalCount, p.Consultations, p.Questionnaires, p.ClinicalCases, p.Files, p.StripeProductID, p.StripePriceID, p.TrialDays, encodeEntitlements(p.Entitlements))
 	if err != nil {
 		return err
 	}
-	id, err := res.LastInsertId()
+	p.ID = int(id)
+	v, err := r.CreatePlanVersion(p.ID)
 	if err != nil {
 		return err
 	}
-	p.ID = int(id)
+	p.VersionID, p.Version = v.ID, v.Version
 	return nil
 }
 
+// UpdatePlan edits the plan row in place; callers snapshot a new version when the
+// entitlements change (see planVersionChanged) so existing subscribers keep theirs.
 func (r *Repository) UpdatePlan(id int, p *Plan) error {
-	_, err := r.db.Exec(`UPDATE subscription_plans SET name=?, currency=?, price=?, billing=?, consultations=?, questionnaires=?, clinical_cases=?, files=?, stripe_product_id=?, stripe_price_id=? WHERE id=?`,
-		p.Name, p.Currency, p.Price, p.Billing, p.Consultations, p.Questionnaires, p.ClinicalCases, p.Files, p.StripeProductID, p.StripePriceID, id)
+	normalizePlanBilling(p)
+	_, err := r.db.Exec(`UPDATE subscription_plans SET name=?, currency=?, price=?, billing=?, billing_interval=?, billing_interval_count=?, consultations=?, questionnaires=?, clinical_cases=?, files=?, stripe_product_id=?, stripe_price_id=?, trial_days=?, entitlements=? WHERE id=?`,
+		p.Name, p.Currency, p.Price, p.Billing, p.Interval, p.IntervalCount, p.Consultations, p.Questionnaires, p.ClinicalCases, p.Files, p.StripeProductID, p.StripePriceID, p.TrialDays, encodeEntitlements(p.Entitlements), id)
 	return err
 }
 
@@ -69,47 +148,142 @@ func (r *Repository) DeletePlan(id int) error {
 	return err
 }
 
+// GetPlanPrices returns the alternative interval prices of a plan.
+func (r *Repository) GetPlanPrices(planID int) ([]PlanPrice, error) {
+	rows, err := r.db.Query(`SELECT id, plan_id, country_code, COALESCE(currency,''), billing_interval, interval_count, price, COALESCE(stripe_price_id,'') FROM subscription_plan_prices WHERE plan_id=? ORDER BY country_code ASC, id ASC`, planID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []PlanPrice{}
+	for rows.Next() {
+		var pp PlanPrice
+		if err := rows.Scan(&pp.ID, &pp.PlanID, &pp.CountryCode, &pp.Currency, &pp.Interval, &pp.IntervalCount, &pp.Price, &pp.StripePriceID); err != nil {
+			return nil, err
+		}
+		out = append(out, pp)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repository) getAllPlanPrices() (map[int][]PlanPrice, error) {
+	rows, err := r.db.Query(`SELECT id, plan_id, country_code, COALESCE(currency,''), billing_interval, interval_count, price, COALESCE(stripe_price_id,'') FROM subscription_plan_prices ORDER BY country_code ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int][]PlanPrice{}
+	for rows.Next() {
+		var pp PlanPrice
+		if err := rows.Scan(&pp.ID, &pp.PlanID, &pp.CountryCode, &pp.Currency, &pp.Interval, &pp.IntervalCount, &pp.Price, &pp.StripePriceID); err != nil {
+			return nil, err
+		}
+		out[pp.PlanID] = append(out[pp.PlanID], pp)
+	}
+	return out, rows.Err()
+}
+
+// UpsertPlanPrice creates or updates the price of a plan for one interval and country (unique per
+// plan+country+interval+count). Changing the amount or currency clears the Stripe price id so a new
+// Stripe price gets created on next checkout.
+func (r *Repository) UpsertPlanPrice(pp *PlanPrice) error {
+	pp.Interval = NormalizeInterval(pp.Interval)
+	if pp.IntervalCount <= 0 {
+		pp.IntervalCount = 1
+	}
+	pp.CountryCode = strings.ToUpper(strings.TrimSpace(pp.CountryCode))
+	pp.Currency = strings.ToUpper(strings.TrimSpace(pp.Currency))
+	d := r.dialect
+	_, err := r.db.Exec(`INSERT INTO subscription_plan_prices (plan_id, country_code, currency, billing_interval, interval_count, price, stripe_price_id) VALUES (?,?,NULLIF(?,''),?,?,?,?)
+		`+d.Upsert("plan_id", "country_code", "billing_interval", "interval_count")+` stripe_price_id = CASE WHEN subscription_plan_prices.price = `+d.Excluded("price")+` AND COALESCE(subscription_plan_prices.currency,'') = COALESCE(`+d.Excluded("currency")+`,'')
+			THEN COALESCE(NULLIF(`+d.Excluded("stripe_price_id")+`,''), subscription_plan_prices.stripe_price_id) ELSE `+d.Excluded("stripe_price_id")+` END,
+			price = `+d.Excluded("price")+`, currency = `+d.Excluded("currency"),
+		pp.PlanID, pp.CountryCode, pp.Currency, pp.Interval, pp.IntervalCount, pp.Price, pp.StripePriceID)
+	if err != nil {
+		return err
+	}
+	row := r.db.QueryRow(`SELECT id, COALESCE(stripe_price_id,'') FROM subscription_plan_prices WHERE plan_id=? AND country_code=? AND billing_interval=? AND interval_count=? LIMIT 1`, pp.PlanID, pp.CountryCode, pp.Interval, pp.IntervalCount)
+	return row.Scan(&pp.ID, &pp.StripePriceID)
+}
+
+// SetPlanPriceStripeID stores the Stripe price created for an alternative price point.
+func (r *Repository) SetPlanPriceStripeID(id int, stripePriceID string) error {
+	_, err := r.db.Exec(`UPDATE subscription_plan_prices SET stripe_price_id=? WHERE id=?`, stripePriceID, id)
+	return err
+}
+
+func (r *Repository) DeletePlanPrice(planID, priceID int) error {
+	_, err := r.db.Exec(`DELETE FROM subscription_plan_prices WHERE id=? AND plan_id=?`, priceID, planID)
+	return err
+}
+
 func (r *Repository) GetSubscriptions(userID int) ([]Subscription, error) {
-	rows, err := r.db.Query(`SELECT s.id, s.user_id, s.plan_id, s.start_date, s.end_date, s.frequency, s.consultations, s.questionnaires, s.clinical_cases, s.files, p.id, p.name, p.currency, p.price, p.billing, p.consultations, p.questionnaires, p.clinical_cases, p.files, CASE WHEN p.price>0 THEN 1 ELSE 0 END AS statistics FROM subscriptions s JOIN subscription_plans p ON s.plan_id = p.id WHERE (?=0 OR s.user_id=?)`, userID, userID)
+	rows, err := r.db.Query(`SELECT `+subscriptionColumns+`, `+pinnedPlanColumns+` FROM subscriptions s `+subscriptionPlanJoin+` WHERE (?=0 OR s.user_id=?)`, userID, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	subs := []Subscription{}
 	for rows.Next() {
-		var s Subscription
-		var plan Plan
-		err := rows.Scan(&s.ID, &s.UserID, &s.PlanID, &s.StartDate, &s.EndDate, &s.Frequency, &s.Consultations, &s.Questionnaires, &s.ClinicalCases, &s.Files,
-			&plan.ID, &plan.Name, &plan.Currency, &plan.Price, &plan.Billing, &plan.Consultations, &plan.Questionnaires, &plan.ClinicalCases, &plan.Files, &s.Statistics)
+		s, err := scanSubscriptionWithPlan(rows)
 		if err != nil {
 			return nil, err
 		}
-		s.Plan = &plan
-		subs = append(subs, s)
+		subs = append(subs, *s)
 	}
 	return subs, nil
 }
 
 func (r *Repository) CreateSubscription(s *Subscription) error {
 	// If quotas are zero/unset, initialize them from the selected plan
-	if s.Consultations == 0 && s.Questionnaires == 0 && s.ClinicalCases == 0 && s.Files == 0 {
+	needQuotas := s.Consultations == 0 && s.Questionnaires == 0 && s.ClinicalCases == 0 && s.Files == 0
+	if needQuotas || s.Interval == "" || s.PlanVersionID == nil {
 		plan, err := r.GetPlanByID(s.PlanID)
 		if err != nil {
 			return err
 		}
 		if plan != nil {
-			s.Consultations = plan.Consultations
-			s.Questionnaires = plan.Questionnaires
-			s.ClinicalCases = plan.ClinicalCases
-			s.Files = plan.Files
+			// pin the subscription to the version being sold
+			if s.PlanVersionID == nil && plan.VersionID != 0 {
+				v := plan.VersionID
+				s.PlanVersionID = &v
+			}
+			if needQuotas {
+				s.Consultations = plan.Consultations
+				s.Questionnaires = plan.Questionnaires
+				s.ClinicalCases = plan.ClinicalCases
+				s.Files = plan.Files
+			}
+			if s.Interval == "" {
+				pp, ok := plan.ResolvePrice(s.Frequency)
+				if !ok {
+					pp, _ = plan.ResolvePrice(FrequencyDefault)
+				}
+				s.Interval, s.IntervalCount = pp.Interval, pp.IntervalCount
+			}
 		}
 	}
-	res, err := r.db.Exec(`INSERT INTO subscriptions (user_id, plan_id, start_date, end_date, frequency, consultations, questionnaires, clinical_cases, files) VALUES (?,?,?,?,?,?,?,?,?)`,
-		s.UserID, s.PlanID, s.StartDate, s.EndDate, s.Frequency, s.Consultations, s.Questionnaires, s.ClinicalCases, s.Files)
-	if err != nil {
-		return err
+	if s.Interval == "" {
+		s.Interval = IntervalMonth
+	}
+	if s.IntervalCount <= 0 {
+		s.IntervalCount = 1
+	}
+	if s.StartDate.IsZero() {
+		s.StartDate = time.Now()
 	}
-	id, err := res.LastInsertId()
+	if s.Status == "" {
+		s.Status = StatusActive
+	}
+	if s.CurrentPeriodEnd == nil {
+		end := AddInterval(s.StartDate, s.Interval, s.IntervalCount)
+		if s.TrialEndsAt != nil {
+			end = *s.TrialEndsAt
+		}
+		s.CurrentPeriodEnd = &end
+	}
+	id, err := r.dialect.InsertID(r.db, `INSERT INTO subscriptions (user_id, plan_id, plan_version_id, start_date, end_date, status, frequency, billing_interval, interval_count, current_period_end, stripe_subscription_id, payment_gateway, gateway_reference, scheduled_plan_id, scheduled_interval, promo_code, trial_ends_at, consultations, questionnaires, clinical_cases, files) VALUES (?,?,?,?,?,?,?,?,?,?,NULLIF(?,''),NULLIF(?,''),NULLIF(?,''),?,NULLIF(?,''),NULLIF(?,''),?,?,?,?,?)`,
+		s.UserID, s.PlanID, s.PlanVersionID, s.StartDate, s.EndDate, s.Status, s.Frequency, s.Interval, s.IntervalCount, s.CurrentPeriodEnd, s.StripeSubscriptionID, s.Gateway, s.GatewayReference, s.ScheduledPlanID, s.ScheduledInterval, s.PromoCode, s.TrialEndsAt, s.Consultations, s.Questionnaires, s.ClinicalCases, s.Files)
 	if err != nil {
 		return err
 	}
@@ -168,24 +342,21 @@ func (r *Repository) DeleteSubscription(id int) error {
 	return err
 }
 
-// GetActiveSubscription returns the most recent (or first) subscription for a user.
-// Heuristic: no specific status field exists, so we pick the latest by id (DESC).
+// GetActiveSubscription returns the subscription currently granting access to the user:
+// started, not past end_date and not expired. When several overlap (e.g. a canceled plan
+// still running plus a new one) the most recent wins.
 func (r *Repository) GetActiveSubscription(userID int) (*Subscription, error) {
-	row := r.db.QueryRow(`SELECT s.id, s.user_id, s.plan_id, s.start_date, s.end_date, s.frequency, s.consultations, s.questionnaires, s.clinical_cases, s.files,
-			p.id, p.name, p.currency, p.price, p.billing, p.consultations, p.questionnaires, p.clinical_cases, p.files, CASE WHEN p.price>0 THEN 1 ELSE 0 END AS statistics
-			FROM subscriptions s JOIN subscription_plans p ON s.plan_id = p.id WHERE s.user_id=? ORDER BY s.id DESC LIMIT 1`, userID)
-	var s Subscription
-	var plan Plan
-	err := row.Scan(&s.ID, &s.UserID, &s.PlanID, &s.StartDate, &s.EndDate, &s.Frequency, &s.Consultations, &s.Questionnaires, &s.ClinicalCases, &s.Files,
-		&plan.ID, &plan.Name, &plan.Currency, &plan.Price, &plan.Billing, &plan.Consultations, &plan.Questionnaires, &plan.ClinicalCases, &plan.Files, &s.Statistics)
+	now := time.Now()
+	row := r.db.QueryRow(`SELECT `+subscriptionColumns+`, `+pinnedPlanColumns+`
+			FROM subscriptions s `+subscriptionPlanJoin+` WHERE s.user_id=? AND `+activeSubscriptionWhere+` ORDER BY s.id DESC LIMIT 1`, userID, now, now)
+	s, err := scanSubscriptionWithPlan(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	s.Plan = &plan
-	return &s, nil
+	return s, nil
 }
 
 // ConsumeQuota atomically decrements a single quota field by amount if it is > 0.
@@ -219,16 +390,581 @@ func (r *Repository) SetQuotaValue(subscriptionID int, field string, value int)
 	return err
 }
 
-// ResetSubscriptionQuotasToPlan sets the subscription quotas back to the plan defaults.
+// AddQuota atomically adds amount to a quota field (manual grants).
+func (r *Repository) AddQuota(subscriptionID int, field string, amount int) error {
+	allowed := map[string]bool{"consultations": true, "questionnaires": true, "clinical_cases": true, "files": true}
+	if !allowed[field] { return fmt.Errorf("invalid quota field: %s", field) }
+	_, err := r.db.Exec("UPDATE subscriptions SET "+field+"=GREATEST("+field+"+?,0) WHERE id=?", amount, subscriptionID)
+	return err
+}
+
+// SetSubscriptionAccess sets status and end_date of a subscription (admin edits).
+func (r *Repository) SetSubscriptionAccess(subID int, status string, endDate *time.Time) error {
+	_, err := r.db.Exec(`UPDATE subscriptions SET status=?, end_date=? WHERE id=?`, status, endDate, subID)
+	return err
+}
+
+// ResetSubscriptionQuotasToPlan sets the subscription quotas back to the limits of the
+// plan version it is pinned to.
 func (r *Repository) ResetSubscriptionQuotasToPlan(subID int) error {
-	// Get plan id
-	row := r.db.QueryRow(`SELECT plan_id FROM subscriptions WHERE id=? LIMIT 1`, subID)
-	var planID int
-	if err := row.Scan(&planID); err != nil { return err }
-	plan, err := r.GetPlanByID(planID)
+	sub, err := r.GetSubscriptionByID(subID)
 	if err != nil { return err }
-	if plan == nil { return fmt.Errorf("plan not found for subscription %d", subID) }
+	if sub == nil || sub.Plan == nil { return fmt.Errorf("plan not found for subscription %d", subID) }
+	plan := sub.Plan
 	_, err = r.db.Exec(`UPDATE subscriptions SET consultations=?, questionnaires=?, clinical_cases=?, files=? WHERE id=?`, plan.Consultations, plan.Questionnaires, plan.ClinicalCases, plan.Files, subID)
 	return err
 }
 
+
+// RenewDueSubscriptions resets quotas to the plan version limits for every subscription whose
+// current billing period ended at or before now, and advances current_period_end by the
+// subscription interval (skipping missed periods). A scheduled plan change (downgrade)
+// is applied first, with its interval and interval count, so the new period starts on the
+// new plan's current version and cadence; subscribers
+// of a version whose grandfathering ended move to the plan's current version. Returns the
+// number of renewed rows.
+func (r *Repository) RenewDueSubscriptions(now time.Time) (int, error) {
+	rows, err := r.db.Query(`SELECT s.id, tp.id, CASE WHEN s.scheduled_plan_id IS NOT NULL OR v.id IS NULL OR (v.grandfathered_until IS NOT NULL AND v.grandfathered_until <= ?) THEN tp.current_version_id ELSE v.id END,
+			COALESCE(NULLIF(s.scheduled_interval,''), s.billing_interval, 'month'), CASE WHEN s.scheduled_plan_id IS NOT NULL THEN COALESCE(s.scheduled_interval_count,1) ELSE COALESCE(s.interval_count,1) END, s.current_period_end
+		FROM subscriptions s JOIN subscription_plans tp ON tp.id = COALESCE(s.scheduled_plan_id, s.plan_id) LEFT JOIN subscription_plan_versions v ON v.id = s.plan_version_id
+		WHERE s.current_period_end IS NOT NULL AND s.current_period_end <= ? AND COALESCE(s.status,'active') = 'active' AND (s.end_date IS NULL OR s.end_date > ?)`, now, now, now)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		id, planID, count int
+		versionID         sql.NullInt64
+		interval          string
+		periodEnd         time.Time
+	}
+	list := []due{}
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.planID, &d.versionID, &d.interval, &d.count, &d.periodEnd); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	renewed := 0
+	for _, d := range list {
+		next := d.periodEnd
+		for !next.After(now) {
+			next = AddInterval(next, d.interval, d.count)
+		}
+		// Limits come from the target version (plan row only for unversioned plans).
+		var versionID sql.NullInt64
+		var q Quotas
+		err := r.db.QueryRow(`SELECT v.id, COALESCE(v.consultations,p.consultations), COALESCE(v.questionnaires,p.questionnaires), COALESCE(v.clinical_cases,p.clinical_cases), COALESCE(v.files,p.files)
+			FROM subscription_plans p LEFT JOIN subscription_plan_versions v ON v.id = ? WHERE p.id = ?`, d.versionID, d.planID).Scan(&versionID, &q.Consultations, &q.Questionnaires, &q.ClinicalCases, &q.Files)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return renewed, err
+		}
+		// Guard on the old period end so two replicas don't renew the same row twice.
+		res, err := r.db.Exec(`UPDATE subscriptions SET plan_id=?, plan_version_id=?, billing_interval=?, interval_count=?, frequency=?, scheduled_plan_id=NULL, scheduled_interval=NULL, scheduled_interval_count=NULL,
+				consultations=?, questionnaires=?, clinical_cases=?, files=?, current_period_end=?
+			WHERE id=? AND current_period_end=?`, d.planID, versionID, d.interval, d.count, FrequencyForInterval(d.interval), q.Consultations, q.Questionnaires, q.ClinicalCases, q.Files, next, d.id, d.periodEnd)
+		if err != nil {
+			return renewed, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			renewed++
+		}
+	}
+	return renewed, nil
+}
+
+// SetStripeSubscriptionID links a local subscription with its Stripe subscription.
+func (r *Repository) SetStripeSubscriptionID(subID int, stripeSubID string) error {
+	_, err := r.db.Exec(`UPDATE subscriptions SET stripe_subscription_id=? WHERE id=?`, stripeSubID, subID)
+	return err
+}
+
+// ApplyPlanChange switches an existing subscription row to another plan/interval in place,
+// pinned to the plan's current version, with quotas already computed by the caller (see ApplyQuotaRule).
+func (r *Repository) ApplyPlanChange(subID, planID int, interval string, count int, q Quotas) error {
+	if count <= 0 {
+		count = 1
+	}
+	_, err := r.db.Exec(`UPDATE subscriptions SET plan_id=?, plan_version_id=(SELECT p.current_version_id FROM subscription_plans p WHERE p.id = ?), billing_interval=?, interval_count=?, frequency=?, scheduled_plan_id=NULL, scheduled_interval=NULL, scheduled_interval_count=NULL,
+		consultations=?, questionnaires=?, clinical_cases=?, files=? WHERE id=? AND EXISTS (SELECT 1 FROM subscription_plans p WHERE p.id = ?)`,
+		planID, planID, NormalizeInterval(interval), count, FrequencyForInterval(interval), q.Consultations, q.Questionnaires, q.ClinicalCases, q.Files, subID, planID)
+	return err
+}
+
+// SchedulePlanChange records a plan change (target plan, interval and interval count) to apply
+// when the current period ends.
+func (r *Repository) SchedulePlanChange(subID, planID int, interval string, count int) error {
+	if count <= 0 {
+		count = 1
+	}
+	_, err := r.db.Exec(`UPDATE subscriptions SET scheduled_plan_id=?, scheduled_interval=?, scheduled_interval_count=? WHERE id=?`, planID, NormalizeInterval(interval), count, subID)
+	return err
+}
+
+// ClearScheduledPlanChange drops a pending downgrade.
+func (r *Repository) ClearScheduledPlanChange(subID int) error {
+	_, err := r.db.Exec(`UPDATE subscriptions SET scheduled_plan_id=NULL, scheduled_interval=NULL, scheduled_interval_count=NULL WHERE id=?`, subID)
+	return err
+}
+
+// GetFreePlan returns the plan used as downgrade target (name 'Free', else cheapest price 0).
+func (r *Repository) GetFreePlan() (*Plan, error) {
+	var id int
+	err := r.db.QueryRow(`SELECT id FROM subscription_plans WHERE price = 0 ORDER BY (name = 'Free') DESC, id ASC LIMIT 1`).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetPlanByID(id)
+}
+
+// HasUsedTrial reports whether the user already started a trial on any plan.
+func (r *Repository) HasUsedTrial(userID int) (bool, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(1) FROM subscriptions WHERE user_id=? AND trial_ends_at IS NOT NULL`, userID).Scan(&n)
+	return n > 0, err
+}
+
+const promoColumns = `id, code, COALESCE(description,''), percent_off, amount_off, COALESCE(currency,''), duration, COALESCE(duration_in_months,0), trial_days, plan_id, max_redemptions, times_redeemed, expires_at, active, COALESCE(stripe_coupon_id,'')`
+
+func scanPromo(row rowScanner) (*PromoCode, error) {
+	var pc PromoCode
+	var planID sql.NullInt64
+	var expires sql.NullTime
+	if err := row.Scan(&pc.ID, &pc.Code, &pc.Description, &pc.PercentOff, &pc.AmountOff, &pc.Currency, &pc.Duration, &pc.DurationInMonths, &pc.TrialDays, &planID, &pc.MaxRedemptions, &pc.TimesRedeemed, &expires, &pc.Active, &pc.StripeCouponID); err != nil {
+		return nil, err
+	}
+	if planID.Valid {
+		id := int(planID.Int64)
+		pc.PlanID = &id
+	}
+	if expires.Valid {
+		t := expires.Time
+		pc.ExpiresAt = &t
+	}
+	return &pc, nil
+}
+
+func (r *Repository) GetPromoCodes() ([]PromoCode, error) {
+	rows, err := r.db.Query(`SELECT ` + promoColumns + ` FROM promo_codes ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []PromoCode{}
+	for rows.Next() {
+		pc, err := scanPromo(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *pc)
+	}
+	return out, rows.Err()
+}
+
+// GetPromoCodeByCode looks a code up case-insensitively; nil when not found.
+func (r *Repository) GetPromoCodeByCode(code string) (*PromoCode, error) {
+	pc, err := scanPromo(r.db.QueryRow(`SELECT `+promoColumns+` FROM promo_codes WHERE code=? LIMIT 1`, NormalizePromoCode(code)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return pc, err
+}
+
+func (r *Repository) CreatePromoCode(pc *PromoCode) error {
+	pc.Code = NormalizePromoCode(pc.Code)
+	if pc.Duration == "" {
+		pc.Duration = "once"
+	}
+	id, err := r.dialect.InsertID(r.db, `INSERT INTO promo_codes (code, description, percent_off, amount_off, currency, duration, duration_in_months, trial_days, plan_id, max_redemptions, expires_at, active) VALUES (?,?,?,?,NULLIF(?,''),?,?,?,?,?,?,?)`,
+		pc.Code, pc.Description, pc.PercentOff, pc.AmountOff, pc.Currency, pc.Duration, pc.DurationInMonths, pc.TrialDays, pc.PlanID, pc.MaxRedemptions, pc.ExpiresAt, pc.Active)
+	if err != nil {
+		return err
+	}
+	pc.ID = int(id)
+	return nil
+}
+
+// DeactivatePromoCode disables a code; rows are kept so subscriptions keep their reference.
+func (r *Repository) DeactivatePromoCode(id int) error {
+	_, err := r.db.Exec(`UPDATE promo_codes SET active=? WHERE id=?`, false, id)
+	return err
+}
+
+func (r *Repository) SetPromoStripeCouponID(id int, couponID string) error {
+	_, err := r.db.Exec(`UPDATE promo_codes SET stripe_coupon_id=? WHERE id=?`, couponID, id)
+	return err
+}
+
+// HasRedeemedPromo reports whether the user already used the code.
+func (r *Repository) HasRedeemedPromo(promoID, userID int) (bool, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(1) FROM promo_redemptions WHERE promo_code_id=? AND user_id=?`, promoID, userID).Scan(&n)
+	return n > 0, err
+}
+
+// RecordPromoRedemption links a code to the subscription it was used on and bumps the
+// redemption counter. Idempotent per (code, subscription).
+func (r *Repository) RecordPromoRedemption(promoID, userID, subscriptionID int) error {
+	res, err := r.db.Exec(r.dialect.InsertIgnore(`INSERT INTO promo_redemptions (promo_code_id, user_id, subscription_id) VALUES (?,?,?)`), promoID, userID, subscriptionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	_, err = r.db.Exec(`UPDATE promo_codes SET times_redeemed = times_redeemed + 1 WHERE id=?`, promoID)
+	return err
+}
+
+// CancelSubscription ends a subscription at endAt (period end keeps paid access; now cuts it).
+// Pending plan changes are dropped since the subscription won't renew.
+func (r *Repository) CancelSubscription(subID int, endAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE subscriptions SET status=?, end_date=?, scheduled_plan_id=NULL, scheduled_interval=NULL, scheduled_interval_count=NULL WHERE id=?`, StatusCanceled, endAt, subID)
+	return err
+}
+
+// GetSubscriptionByID returns a subscription joined with its plan; nil when missing.
+func (r *Repository) GetSubscriptionByID(id int) (*Subscription, error) {
+	row := r.db.QueryRow(`SELECT `+subscriptionColumns+`, `+pinnedPlanColumns+` FROM subscriptions s `+subscriptionPlanJoin+` WHERE s.id=? LIMIT 1`, id)
+	s, err := scanSubscriptionWithPlan(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// SetStatusByStripeID updates the status (and optionally end_date) of the subscription linked
+// to a Stripe subscription; clearEndDate sets end_date back to NULL (reactivation).
+// Returns the local subscription id, 0 when none is linked.
+func (r *Repository) SetStatusByStripeID(stripeSubID, status string, endDate *time.Time, clearEndDate bool) (int, error) {
+	var id int
+	if err := r.db.QueryRow(`SELECT id FROM subscriptions WHERE stripe_subscription_id=? ORDER BY id DESC LIMIT 1`, stripeSubID).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var err error
+	switch {
+	case endDate != nil:
+		_, err = r.db.Exec(`UPDATE subscriptions SET status=?, end_date=? WHERE id=?`, status, *endDate, id)
+	case clearEndDate:
+		_, err = r.db.Exec(`UPDATE subscriptions SET status=?, end_date=NULL WHERE id=?`, status, id)
+	default:
+		_, err = r.db.Exec(`UPDATE subscriptions SET status=? WHERE id=?`, status, id)
+	}
+	return id, err
+}
+
+// ExtendPrepaidSubscription moves end_date of a prepaid subscription after a new payment.
+// current_period_end is left alone so the renewal job still resets quotas at the old boundary.
+func (r *Repository) ExtendPrepaidSubscription(subID int, ref string, endDate time.Time) error {
+	_, err := r.db.Exec(`UPDATE subscriptions SET gateway_reference=?, end_date=?, status='active' WHERE id=?`, ref, endDate, subID)
+	return err
+}
+
+// IsPaymentApplied reports whether a gateway payment is already recorded against a
+// subscription, i.e. it created or extended one and must not be applied again.
+func (r *Repository) IsPaymentApplied(gateway, gatewayPaymentID string) (bool, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM payments WHERE gateway=? AND gateway_payment_id=? AND subscription_id IS NOT NULL`, gateway, gatewayPaymentID).Scan(&n)
+	return n > 0, err
+}
+
+// SetStatusByGatewayReference is SetStatusByStripeID for the other gateways. ref is a payment
+// id: the subscription is found through the recorded payment, so earlier payments of an
+// extended prepaid plan still resolve, falling back to gateway_reference (latest payment).
+// Returns the local subscription id, 0 when none is linked.
+func (r *Repository) SetStatusByGatewayReference(gateway, ref, status string, endDate *time.Time) (int, error) {
+	var id int
+	err := r.db.QueryRow(`SELECT subscription_id FROM payments WHERE gateway=? AND gateway_payment_id=? AND subscription_id IS NOT NULL`, gateway, ref).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = r.db.QueryRow(`SELECT id FROM subscriptions WHERE payment_gateway=? AND gateway_reference=? ORDER BY id DESC LIMIT 1`, gateway, ref).Scan(&id)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if endDate != nil {
+		_, err = r.db.Exec(`UPDATE subscriptions SET status=?, end_date=? WHERE id=?`, status, *endDate, id)
+	} else {
+		_, err = r.db.Exec(`UPDATE subscriptions SET status=? WHERE id=?`, status, id)
+	}
+	return id, err
+}
+
+// ExpireLapsedSubscriptions marks as expired every subscription whose end_date passed and
+// returns the affected user ids (deduplicated) so callers can grant the Free fallback.
+func (r *Repository) ExpireLapsedSubscriptions(now time.Time) ([]int, error) {
+	rows, err := r.db.Query(`SELECT id, user_id FROM subscriptions WHERE COALESCE(status,'active') <> 'expired' AND end_date IS NOT NULL AND end_date <= ?`, now)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	users := []int{}
+	seen := map[int]bool{}
+	for rows.Next() {
+		var id, uid int
+		if err := rows.Scan(&id, &uid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		if !seen[uid] {
+			seen[uid] = true
+			users = append(users, uid)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if _, err := r.db.Exec(`UPDATE subscriptions SET status=? WHERE id=? AND COALESCE(status,'active') <> 'expired'`, StatusExpired, id); err != nil {
+			return users, err
+		}
+	}
+	return users, nil
+}
+
+const paymentColumns = `pm.id, pm.user_id, pm.subscription_id, pm.plan_id, COALESCE(p.name,''), pm.gateway, pm.gateway_payment_id, pm.amount, pm.currency, pm.status, COALESCE(pm.payment_method,''), COALESCE(pm.description,''), COALESCE(pm.invoice_number,''), COALESCE(pm.invoice_pdf_url,''), pm.paid_at, pm.created_at`
+
+func scanPayment(row rowScanner) (*Payment, error) {
+	var pm Payment
+	var subID, planID sql.NullInt64
+	var paidAt sql.NullTime
+	if err := row.Scan(&pm.ID, &pm.UserID, &subID, &planID, &pm.PlanName, &pm.Gateway, &pm.GatewayPaymentID, &pm.Amount, &pm.Currency, &pm.Status, &pm.PaymentMethod, &pm.Description, &pm.InvoiceNumber, &pm.InvoicePDFURL, &paidAt, &pm.CreatedAt); err != nil {
+		return nil, err
+	}
+	if subID.Valid {
+		id := int(subID.Int64)
+		pm.SubscriptionID = &id
+	}
+	if planID.Valid {
+		id := int(planID.Int64)
+		pm.PlanID = &id
+	}
+	if paidAt.Valid {
+		t := paidAt.Time
+		pm.PaidAt = &t
+	}
+	return &pm, nil
+}
+
+// RecordPayment inserts or updates (by gateway + gateway_payment_id) a payment reported by a
+// gateway, so repeated webhooks only move its status forward. Paid payments without a gateway
+// invoice get a local receipt number derived from the row id.
+func (r *Repository) RecordPayment(p *Payment) error {
+	if p.Status == "" {
+		p.Status = PaymentPending
+	}
+	if p.Status == PaymentPaid && p.PaidAt == nil {
+		now := time.Now()
+		p.PaidAt = &now
+	}
+	d := r.dialect
+	// New value when the webhook has one, current value otherwise
+	keep := func(col string) string { return col + "=COALESCE(" + d.Excluded(col) + ", payments." + col + ")" }
+	_, err := r.db.Exec(`INSERT INTO payments (user_id, subscription_id, plan_id, gateway, gateway_payment_id, amount, currency, status, payment_method, description, invoice_number, invoice_pdf_url, paid_at)
+		VALUES (?,?,?,?,?,?,?,?,NULLIF(?,''),NULLIF(?,''),NULLIF(?,''),NULLIF(?,''),?)
+		`+d.Upsert("gateway", "gateway_payment_id")+` status=`+d.Excluded("status")+`, amount=`+d.Excluded("amount")+`, currency=`+d.Excluded("currency")+`, updated_at=NOW(),
+			`+keep("subscription_id")+`, `+keep("plan_id")+`, `+keep("payment_method")+`, `+keep("invoice_number")+`, `+keep("invoice_pdf_url")+`, `+keep("paid_at"),
+		p.UserID, p.SubscriptionID, p.PlanID, p.Gateway, p.GatewayPaymentID, p.Amount, strings.ToUpper(p.Currency), p.Status, p.PaymentMethod, p.Description, p.InvoiceNumber, p.InvoicePDFURL, p.PaidAt)
+	if err != nil {
+		return err
+	}
+	if p.Status == PaymentPaid && p.InvoiceNumber == "" {
+		number := d.Concat(d.CastText("?"), "'-'", "LPAD("+d.CastText("id")+", 6, '0')")
+		_, err = r.db.Exec(`UPDATE payments SET invoice_number=`+number+` WHERE gateway=? AND gateway_payment_id=? AND invoice_number IS NULL`, receiptNumberPrefix(), p.Gateway, p.GatewayPaymentID)
+	}
+	return err
+}
+
+// SetPaymentStatus updates the status of a known gateway payment (e.g. refunds).
+// Returns false when the payment isn't recorded.
+func (r *Repository) SetPaymentStatus(gateway, gatewayPaymentID, status string) (bool, error) {
+	res, err := r.db.Exec(`UPDATE payments SET status=?, updated_at=NOW() WHERE gateway=? AND gateway_payment_id=?`, status, gateway, gatewayPaymentID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetUserPayments returns the payment history of a user, newest first, plus the total count.
+func (r *Repository) GetUserPayments(userID, limit, offset int) ([]Payment, int, error) {
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM payments WHERE user_id=?`, userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.Query(`SELECT `+paymentColumns+` FROM payments pm LEFT JOIN subscription_plans p ON p.id = pm.plan_id
+		WHERE pm.user_id=? ORDER BY pm.created_at DESC, pm.id DESC LIMIT ? OFFSET ?`, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []Payment{}
+	for rows.Next() {
+		pm, err := scanPayment(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, *pm)
+	}
+	return list, total, rows.Err()
+}
+
+// GetPaymentByID returns a payment or nil when it doesn't exist.
+func (r *Repository) GetPaymentByID(id int) (*Payment, error) {
+	row := r.db.QueryRow(`SELECT `+paymentColumns+` FROM payments pm LEFT JOIN subscription_plans p ON p.id = pm.plan_id WHERE pm.id=?`, id)
+	pm, err := scanPayment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return pm, err
+}
+
+// GetSubscriptionByStripeID returns the local subscription linked to a Stripe subscription, or nil.
+func (r *Repository) GetSubscriptionByStripeID(stripeSubID string) (*Subscription, error) {
+	row := r.db.QueryRow(`SELECT `+subscriptionColumns+`, `+pinnedPlanColumns+` FROM subscriptions s `+subscriptionPlanJoin+` WHERE s.stripe_subscription_id=? ORDER BY s.id DESC LIMIT 1`, stripeSubID)
+	sub, err := scanSubscriptionWithPlan(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return sub, err
+}
+
+const planVersionColumns = `v.id, v.plan_id, v.version, v.currency, v.price, v.billing_interval, v.interval_count, v.consultations, v.questionnaires, v.clinical_cases, v.files, v.statistics, v.trial_days, COALESCE(v.entitlements,''), v.grandfathered_until, v.created_at, COALESCE(p.current_version_id,0) = v.id`
+
+func scanPlanVersion(row rowScanner, extra ...interface{}) (*PlanVersion, error) {
+	var v PlanVersion
+	var raw string
+	dest := []interface{}{&v.ID, &v.PlanID, &v.Version, &v.Currency, &v.Price, &v.Interval, &v.IntervalCount, &v.Consultations, &v.Questionnaires, &v.ClinicalCases, &v.Files, &v.Statistics, &v.TrialDays, &raw, &v.GrandfatheredUntil, &v.CreatedAt, &v.Current}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	p := Plan{Price: v.Price}
+	p.setEntitlementsFromColumn(raw)
+	v.Entitlements, v.Statistics = p.ResolvedEntitlements(), p.Statistics
+	return &v, nil
+}
+
+// CreatePlanVersion snapshots the current plan row as its next version and makes it the
+// version sold to new subscribers.
+func (r *Repository) CreatePlanVersion(planID int) (*PlanVersion, error) {
+	var next int
+	if err := r.db.QueryRow(`SELECT COALESCE(MAX(version),0)+1 FROM subscription_plan_versions WHERE plan_id=?`, planID).Scan(&next); err != nil {
+		return nil, err
+	}
+	id, err := r.dialect.InsertID(r.db, `INSERT INTO subscription_plan_versions (plan_id, version, currency, price, billing_interval, interval_count, consultations, questionnaires, clinical_cases, files, statistics, trial_days, entitlements)
+		SELECT p.id, `+r.dialect.CastInt("?")+`, p.currency, p.price, COALESCE(p.billing_interval,'month'), COALESCE(p.billing_interval_count,1), p.consultations, p.questionnaires, p.clinical_cases, p.files,
+			CASE WHEN p.price>0 THEN 1 ELSE 0 END, COALESCE(p.trial_days,0), p.entitlements
+		FROM subscription_plans p WHERE p.id=?`, next, planID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.db.Exec(`UPDATE subscription_plans SET current_version_id=?, version=? WHERE id=?`, id, next, planID); err != nil {
+		return nil, err
+	}
+	return r.GetPlanVersion(planID, next)
+}
+
+// GetPlanVersions lists the versions of a plan (newest first) with their active subscribers.
+func (r *Repository) GetPlanVersions(planID int) ([]PlanVersion, error) {
+	now := time.Now()
+	rows, err := r.db.Query(`SELECT `+planVersionColumns+`,
+			(SELECT COUNT(1) FROM subscriptions s WHERE s.plan_version_id = v.id AND `+activeSubscriptionWhere+`)
+		FROM subscription_plan_versions v JOIN subscription_plans p ON p.id = v.plan_id WHERE v.plan_id=? ORDER BY v.version DESC`, now, now, planID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []PlanVersion{}
+	for rows.Next() {
+		var subscribers int
+		v, err := scanPlanVersion(rows, &subscribers)
+		if err != nil {
+			return nil, err
+		}
+		v.Subscribers = subscribers
+		out = append(out, *v)
+	}
+	return out, rows.Err()
+}
+
+// GetPlanVersion returns version number `version` of a plan (nil when missing).
+func (r *Repository) GetPlanVersion(planID, version int) (*PlanVersion, error) {
+	row := r.db.QueryRow(`SELECT `+planVersionColumns+` FROM subscription_plan_versions v JOIN subscription_plans p ON p.id = v.plan_id WHERE v.plan_id=? AND v.version=?`, planID, version)
+	v, err := scanPlanVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return v, err
+}
+
+// GetCurrentPlanVersion returns the version sold to new subscribers (nil when unversioned).
+func (r *Repository) GetCurrentPlanVersion(planID int) (*PlanVersion, error) {
+	row := r.db.QueryRow(`SELECT `+planVersionColumns+` FROM subscription_plan_versions v JOIN subscription_plans p ON p.current_version_id = v.id WHERE p.id=?`, planID)
+	v, err := scanPlanVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return v, err
+}
+
+// SetGrandfatheredUntil sets when renewals stop honoring a version (nil = never).
+func (r *Repository) SetGrandfatheredUntil(versionID int, until *time.Time) error {
+	_, err := r.db.Exec(`UPDATE subscription_plan_versions SET grandfathered_until=? WHERE id=?`, until, versionID)
+	return err
+}
+
+// EndGrandfathering applies a grandfathering deadline to every version of the plan older than
+// currentVersionID, keeping earlier deadlines already set.
+func (r *Repository) EndGrandfathering(planID, currentVersionID int, until time.Time) error {
+	_, err := r.db.Exec(`UPDATE subscription_plan_versions SET grandfathered_until=? WHERE plan_id=? AND id<>? AND (grandfathered_until IS NULL OR grandfathered_until > ?)`, until, planID, currentVersionID, until)
+	return err
+}
+
+// GetVersionSubscribers returns the active subscriptions pinned to a plan version.
+func (r *Repository) GetVersionSubscribers(versionID int) ([]Subscription, error) {
+	now := time.Now()
+	rows, err := r.db.Query(`SELECT `+subscriptionColumns+`, `+pinnedPlanColumns+` FROM subscriptions s `+subscriptionPlanJoin+` WHERE s.plan_version_id=? AND `+activeSubscriptionWhere, versionID, now, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subs := []Subscription{}
+	for rows.Next() {
+		s, err := scanSubscriptionWithPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, *s)
+	}
+	return subs, rows.Err()
+}
+
+// MoveSubscriptionToVersion re-pins a subscription from one version to another with the given
+// quotas. Returns false when the subscription was no longer on fromVersionID.
+func (r *Repository) MoveSubscriptionToVersion(subID, fromVersionID, toVersionID int, q Quotas) (bool, error) {
+	res, err := r.db.Exec(`UPDATE subscriptions SET plan_version_id=?, consultations=?, questionnaires=?, clinical_cases=?, files=? WHERE id=? AND plan_version_id=?`,
+		toVersionID, q.Consultations, q.Questionnaires, q.ClinicalCases, q.Files, subID, fromVersionID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,200 @@
+package subscriptions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"ema-backend/conn/conntest"
+	"ema-backend/migrations"
+)
+
+func TestRepositoryMatrix(t *testing.T) {
+	conntest.ForEach(t, func(t *testing.T, db *sql.DB) {
+		ctx := context.Background()
+		m, err := migrations.NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Up(ctx, migrations.MigrateOptions{}); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		t.Cleanup(func() {
+			all, _ := m.Status(ctx)
+			m.Down(ctx, migrations.MigrateOptions{Steps: len(all)})
+		})
+		store := migrations.NewSQLStore(db)
+		if err := store.CreateUser("Grace", "Hopper", "grace@example.com", "hash", "user"); err != nil {
+			t.Fatal(err)
+		}
+		userID := store.GetUserByEmail("grace@example.com").ID
+		r := NewRepository(db)
+
+		free := &Plan{Name: "Free", Currency: "USD", Billing: "Mensual", Consultations: 3}
+		pro := &Plan{Name: "Pro", Currency: "USD", Price: 9.99, Billing: "Mensual", Consultations: 30, Entitlements: map[string]int{FeatureStatistics: 1}}
+		for _, p := range []*Plan{free, pro} {
+			if err := r.CreatePlan(p); err != nil {
+				t.Fatalf("CreatePlan: %v", err)
+			}
+			if p.ID == 0 || p.VersionID == 0 || p.Version != 1 {
+				t.Fatalf("plan after create = %+v", p)
+			}
+		}
+		if v, err := r.CreatePlanVersion(pro.ID); err != nil || v.Version != 2 {
+			t.Fatalf("CreatePlanVersion = %+v, %v", v, err)
+		}
+
+		// Upsert: same amount keeps the Stripe price, a new amount clears it
+		pp := &PlanPrice{PlanID: pro.ID, CountryCode: "mx", Currency: "mxn", Interval: "month", Price: 99, StripePriceID: "price_1"}
+		if err := r.UpsertPlanPrice(pp); err != nil {
+			t.Fatalf("UpsertPlanPrice: %v", err)
+		}
+		again := &PlanPrice{PlanID: pro.ID, CountryCode: "MX", Currency: "MXN", Interval: "month", Price: 99}
+		if err := r.UpsertPlanPrice(again); err != nil || again.ID != pp.ID || again.StripePriceID != "price_1" {
+			t.Fatalf("upsert same price = %+v, %v", again, err)
+		}
+		changed := &PlanPrice{PlanID: pro.ID, CountryCode: "MX", Currency: "MXN", Interval: "month", Price: 129}
+		if err := r.UpsertPlanPrice(changed); err != nil || changed.ID != pp.ID || changed.StripePriceID != "" {
+			t.Fatalf("upsert new price = %+v, %v", changed, err)
+		}
+
+		start := time.Now().Add(-40 * 24 * time.Hour)
+		periodEnd := start.AddDate(0, 1, 0)
+		sub := &Subscription{UserID: userID, PlanID: free.ID, PlanVersionID: &free.VersionID, StartDate: start, Interval: "month", IntervalCount: 1, CurrentPeriodEnd: &periodEnd, Consultations: 1}
+		if err := r.CreateSubscription(sub); err != nil || sub.ID == 0 {
+			t.Fatalf("CreateSubscription = %d, %v", sub.ID, err)
+		}
+		if ok, err := r.ConsumeQuota(sub.ID, "consultations", 1); err != nil || !ok {
+			t.Fatalf("ConsumeQuota = %v, %v", ok, err)
+		}
+		if n, err := r.RenewDueSubscriptions(time.Now()); err != nil || n != 1 {
+			t.Fatalf("RenewDueSubscriptions = %d, %v", n, err)
+		}
+		if got, _ := r.GetSubscriptionByID(sub.ID); got.Consultations != 3 || !got.CurrentPeriodEnd.After(time.Now()) {
+			t.Fatalf("renewed subscription = %+v", got)
+		}
+		if err := r.ApplyPlanChange(sub.ID, pro.ID, "month", 1, Quotas{Consultations: 30}); err != nil {
+			t.Fatalf("ApplyPlanChange: %v", err)
+		}
+		got, _ := r.GetSubscriptionByID(sub.ID)
+		if got.PlanID != pro.ID || got.PlanVersionID == nil || got.Plan == nil || got.Plan.Version != 2 || got.Consultations != 30 {
+			t.Fatalf("subscription after plan change = %+v", got)
+		}
+
+		// A downgrade scheduled onto a quarterly price renews on the new cadence
+		due := time.Now().Add(-time.Hour)
+		quarterly := &Subscription{UserID: userID, PlanID: pro.ID, StartDate: start, Interval: "month", IntervalCount: 1, CurrentPeriodEnd: &due}
+		if err := r.CreateSubscription(quarterly); err != nil {
+			t.Fatalf("CreateSubscription: %v", err)
+		}
+		if err := r.SchedulePlanChange(quarterly.ID, free.ID, "month", 3); err != nil {
+			t.Fatalf("SchedulePlanChange: %v", err)
+		}
+		if _, err := r.RenewDueSubscriptions(time.Now()); err != nil {
+			t.Fatalf("RenewDueSubscriptions (scheduled): %v", err)
+		}
+		got, _ = r.GetSubscriptionByID(quarterly.ID)
+		if got.PlanID != free.ID || got.IntervalCount != 3 || got.ScheduledPlanID != nil || !got.CurrentPeriodEnd.After(due.AddDate(0, 2, 0)) {
+			t.Fatalf("subscription after scheduled change = %+v", got)
+		}
+
+		pc := &PromoCode{Code: "welcome", PercentOff: 10, Active: true}
+		if err := r.CreatePromoCode(pc); err != nil || pc.ID == 0 {
+			t.Fatalf("CreatePromoCode = %d, %v", pc.ID, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := r.RecordPromoRedemption(pc.ID, userID, sub.ID); err != nil {
+				t.Fatalf("RecordPromoRedemption: %v", err)
+			}
+		}
+		if err := r.DeactivatePromoCode(pc.ID); err != nil {
+			t.Fatalf("DeactivatePromoCode: %v", err)
+		}
+		if got, _ := r.GetPromoCodeByCode("WELCOME"); got == nil || got.Active || got.TimesRedeemed != 1 {
+			t.Fatalf("promo = %+v", got)
+		}
+
+		pay := &Payment{UserID: userID, Gateway: "fake", GatewayPaymentID: "pay_1", Amount: 9.99, Currency: "usd"}
+		if err := r.RecordPayment(pay); err != nil {
+			t.Fatalf("RecordPayment: %v", err)
+		}
+		pay.Status, pay.SubscriptionID = PaymentPaid, &sub.ID
+		if err := r.RecordPayment(pay); err != nil {
+			t.Fatalf("RecordPayment (paid): %v", err)
+		}
+		list, total, err := r.GetUserPayments(userID, 10, 0)
+		if err != nil || total != 1 || list[0].Status != PaymentPaid || list[0].SubscriptionID == nil {
+			t.Fatalf("GetUserPayments = %+v, %d, %v", list, total, err)
+		}
+		if want := fmt.Sprintf("%s-%06d", receiptNumberPrefix(), list[0].ID); list[0].InvoiceNumber != want {
+			t.Fatalf("invoice number = %q, want %q", list[0].InvoiceNumber, want)
+		}
+	})
+}
+
+func TestPrepaidPaymentReplay(t *testing.T) {
+	conntest.ForEach(t, func(t *testing.T, db *sql.DB) {
+		ctx := context.Background()
+		m, err := migrations.NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Up(ctx, migrations.MigrateOptions{}); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		t.Cleanup(func() {
+			all, _ := m.Status(ctx)
+			m.Down(ctx, migrations.MigrateOptions{Steps: len(all)})
+		})
+		store := migrations.NewSQLStore(db)
+		if err := store.CreateUser("Ada", "Lovelace", "ada@example.com", "hash", "user"); err != nil {
+			t.Fatal(err)
+		}
+		userID := store.GetUserByEmail("ada@example.com").ID
+		r := NewRepository(db)
+		pro := &Plan{Name: "Pro", Currency: "MXN", Price: 99, Billing: "Mensual", Consultations: 30}
+		if err := r.CreatePlan(pro); err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		md := map[string]string{"user_id": fmt.Sprint(userID), "plan_id": fmt.Sprint(pro.ID), "interval": "month", "interval_count": "1", "currency": "MXN"}
+		pay := func(ref string) int {
+			t.Helper()
+			_, subID, err := activateCheckout(r, md, GatewayMercadoPago, ref, true)
+			if err != nil {
+				t.Fatalf("activateCheckout(%s): %v", ref, err)
+			}
+			recordPayment(r, &Payment{UserID: userID, SubscriptionID: &subID, Gateway: GatewayMercadoPago, GatewayPaymentID: ref, Amount: 99, Currency: "MXN", Status: PaymentPaid})
+			return subID
+		}
+		endDate := func(subID int) time.Time {
+			t.Helper()
+			got, err := r.GetSubscriptionByID(subID)
+			if err != nil || got == nil || got.EndDate == nil {
+				t.Fatalf("GetSubscriptionByID = %+v, %v", got, err)
+			}
+			return *got.EndDate
+		}
+
+		subID := pay("P1")
+		first := endDate(subID)
+		if again := pay("P2"); again != subID {
+			t.Fatalf("P2 sub = %d, want %d", again, subID)
+		}
+		extended := endDate(subID)
+		if !extended.After(first) {
+			t.Fatalf("P2 end_date = %s, want after %s", extended, first)
+		}
+		// Redelivered webhook (or late confirm) of the first payment: no free period
+		pay("P1")
+		if got := endDate(subID); !got.Equal(extended) {
+			t.Fatalf("P1 replay end_date = %s, want %s", got, extended)
+		}
+		// A refund of the earlier payment still finds the subscription
+		now := time.Now()
+		if id, err := r.SetStatusByGatewayReference(GatewayMercadoPago, "P1", StatusExpired, &now); err != nil || id != subID {
+			t.Fatalf("SetStatusByGatewayReference(P1) = %d, %v", id, err)
+		}
+	})
+}
@@ -13,6 +13,8 @@ import (
 	"log"
 	"strings"
 
+	"ema-backend/migrations"
+
 	stripe "github.com/stripe/stripe-go/v78"
 	"github.com/stripe/stripe-go/v78/client"
 	"github.com/stripe/stripe-go/v78/webhook"
@@ -33,6 +35,9 @@ type StripeService struct {
 
 var ErrStripeInvalidAPIKey = errors.New("stripe_invalid_api_key")
 
+// ErrIntervalNotAvailable is returned when checkout asks for a frequency the plan has no price for.
+var ErrIntervalNotAvailable = errors.New("interval_not_available")
+
 func maskKey(k string) string {
 	if len(k) < 12 { return "****" }
 	return k[:7] + "..." + k[len(k)-4:]
@@ -44,15 +49,9 @@ func NewStripeFromEnv(repo *Repository) *StripeService {
 	if key == "" {
 		return nil
 	}
-	success := os.Getenv("STRIPE_SUCCESS_URL")
-	if success == "" {
-		// Default to example success marker consumed by Flutter webview
-		success = "https://example.com/checkout/success"
-	}
-	cancel := os.Getenv("STRIPE_CANCEL_URL")
-	if cancel == "" {
-		cancel = "https://example.com/checkout/cancel"
-	}
+	// Success/cancel markers consumed by the Flutter webview
+	success := checkoutSuccessURL()
+	cancel := checkoutCancelURL()
 	sc := &client.API{}
 	sc.Init(key, nil)
 	return &StripeService{
@@ -65,10 +64,30 @@ func NewStripeFromEnv(repo *Repository) *StripeService {
 	}
 }
 
-// CreateCheckoutSession simulates creating a checkout session and returns a URL.
-// Replace the body with real Stripe SDK/API calls if desired.
-func (s *StripeService) ensureStripeProductAndPrice(ctx context.Context, p *Plan) error {
-	if p.Price == 0 { // Free plan: no Stripe objects needed
+func (s *StripeService) Name() string { return GatewayStripe }
+
+func (s *StripeService) Methods() []string { return []string{"card"} }
+
+func (s *StripeService) Recurring() bool { return true }
+
+// CreateCheckout implements PaymentGateway.
+func (s *StripeService) CreateCheckout(ctx context.Context, userID, planID, frequency int, opts CheckoutOptions) (string, string, error) {
+	return s.CreateCheckoutSessionWithOptions(ctx, userID, planID, frequency, opts)
+}
+
+// Cancel implements PaymentGateway using the Stripe subscription linked at checkout.
+func (s *StripeService) Cancel(ctx context.Context, sub *Subscription, atPeriodEnd bool) error {
+	if sub.StripeSubscriptionID == "" { return nil }
+	if atPeriodEnd { return s.CancelAtPeriodEnd(ctx, sub.StripeSubscriptionID) }
+	return s.CancelNow(ctx, sub.StripeSubscriptionID)
+}
+
+// ensureStripeProductAndPrice makes sure the plan has a Stripe product and that pp has a
+// recurring Stripe price matching its amount, currency and interval. pp.ID == 0 is the
+// plan's own (default interval) price; other ids are rows of subscription_plan_prices,
+// including per-country prices, each with a Stripe price in its own currency.
+func (s *StripeService) ensureStripeProductAndPrice(ctx context.Context, p *Plan, pp *PlanPrice) error {
+	if pp.Price == 0 { // Free plan: no Stripe objects needed
 		return nil
 	}
 	// Create product if missing
@@ -77,41 +96,54 @@ func (s *StripeService) ensureStripeProductAndPrice(ctx context.Context, p *Plan
 		if err != nil { return err }
 		p.StripeProductID = prod.ID
 	}
-	// Ensure price: fetch existing to compare amount (if stored)
-	if p.StripePriceID != "" {
-		if pr, err := s.sc.Prices.Get(p.StripePriceID, nil); err == nil {
-			current := pr.UnitAmount
-			desired := int64(p.Price * 100)
-			if current != desired { // create new price; keep old for historic invoices
-				priceParams := &stripe.PriceParams{
-					Product:    stripe.String(p.StripeProductID),
-					Currency:   stripe.String(p.Currency),
-					UnitAmount: stripe.Int64(desired),
-					Recurring: &stripe.PriceRecurringParams{Interval: stripe.String("month")},
-				}
-				price, err := s.sc.Prices.New(priceParams)
-				if err != nil { return err }
-				p.StripePriceID = price.ID
+	interval := NormalizeInterval(pp.Interval)
+	count := int64(pp.IntervalCount)
+	if count <= 0 { count = 1 }
+	currency := strings.ToLower(pp.Currency)
+	if currency == "" { currency = strings.ToLower(p.Currency) }
+	desired := toStripeAmount(pp.Price, currency)
+	// Ensure price: fetch existing to compare amount/interval (if stored)
+	if pp.StripePriceID != "" {
+		if pr, err := s.sc.Prices.Get(pp.StripePriceID, nil); err == nil {
+			sameInterval := pr.Recurring != nil && string(pr.Recurring.Interval) == interval && pr.Recurring.IntervalCount == count
+			if pr.UnitAmount != desired || !sameInterval || !strings.EqualFold(string(pr.Currency), currency) {
+				// create new price; keep old for historic invoices
+				pp.StripePriceID = ""
 			}
 		} else { // price id invalid -> recreate
-			p.StripePriceID = ""
+			pp.StripePriceID = ""
 		}
 	}
-	if p.StripePriceID == "" { // create if missing
-		unitAmount := int64(p.Price * 100)
+	if pp.StripePriceID == "" { // create if missing
 		priceParams := &stripe.PriceParams{
 			Product:    stripe.String(p.StripeProductID),
-			Currency:   stripe.String(p.Currency),
-			UnitAmount: stripe.Int64(unitAmount),
-			Recurring: &stripe.PriceRecurringParams{Interval: stripe.String("month")},
+			Currency:   stripe.String(currency),
+			UnitAmount: stripe.Int64(desired),
+			Recurring:  &stripe.PriceRecurringParams{Interval: stripe.String(interval), IntervalCount: stripe.Int64(count)},
 		}
+		priceParams.Context = ctx
 		price, err := s.sc.Prices.New(priceParams)
 		if err != nil { return err }
-		p.StripePriceID = price.ID
+		pp.StripePriceID = price.ID
+	}
+	if pp.ID == 0 {
+		p.StripePriceID = pp.StripePriceID
 	}
 	return nil
 }
 
+// persistStripeIDs stores product/price ids resolved by ensureStripeProductAndPrice.
+func (s *StripeService) persistStripeIDs(p *Plan, pp *PlanPrice) {
+	if err := s.repo.UpdatePlan(p.ID, p); err != nil {
+		log.Printf("[STRIPE][ensure] persist plan %d failed: %v", p.ID, err)
+	}
+	if pp.ID != 0 {
+		if err := s.repo.SetPlanPriceStripeID(pp.ID, pp.StripePriceID); err != nil {
+			log.Printf("[STRIPE][ensure] persist plan price %d failed: %v", pp.ID, err)
+		}
+	}
+}
+
 // CreateCheckoutSession creates a real Stripe Checkout Session (one-off) for a plan.
 // Backward compatible (deprecated) wrapper
 func (s *StripeService) CreateCheckoutSession(ctx context.Context, userID, planID, frequency int) (string, error) {
@@ -121,15 +153,30 @@ func (s *StripeService) CreateCheckoutSession(ctx context.Context, userID, planI
 
 // New: returns URL + sessionID
 func (s *StripeService) CreateCheckoutSessionWithID(ctx context.Context, userID, planID, frequency int) (string, string, error) {
+	return s.CreateCheckoutSessionWithOptions(ctx, userID, planID, frequency, CheckoutOptions{})
+}
+
+// CheckoutOptions carries the optional marketing parameters of a checkout.
+type CheckoutOptions struct {
+	Promo         *PromoCode // already validated for the user/plan
+	TrialDays     int        // >0 starts the Stripe subscription in trial; Stripe converts it automatically
+	PaymentMethod string     // preferred method for gateways offering several (pse, oxxo...)
+	Country       string     // buyer country (ISO alpha-2) selecting the localized price
+}
+
+// CreateCheckoutSessionWithOptions creates the Checkout Session applying promo coupon and trial.
+func (s *StripeService) CreateCheckoutSessionWithOptions(ctx context.Context, userID, planID, frequency int, opts CheckoutOptions) (string, string, error) {
 	if s == nil { return "", "", errors.New("stripe no configurado") }
 	plan, err := s.repo.GetPlanByID(planID)
 	if err != nil || plan == nil { return "", "", fmt.Errorf("plan inválido") }
-	if plan.Price == 0 {
-		sub := &Subscription{UserID: userID, PlanID: plan.ID, StartDate: time.Now(), Frequency: frequency}
+	price, ok := plan.ResolveLocalizedPrice(frequency, opts.Country)
+	if !ok { return "", "", ErrIntervalNotAvailable }
+	if price.Price == 0 {
+		sub := &Subscription{UserID: userID, PlanID: plan.ID, StartDate: time.Now(), Frequency: frequency, Interval: price.Interval, IntervalCount: price.IntervalCount}
 		if err := s.repo.CreateSubscription(sub); err != nil { return "", "", err }
 		return s.successURL, "", nil
 	}
-	if err := s.ensureStripeProductAndPrice(ctx, plan); err != nil {
+	if err := s.ensureStripeProductAndPrice(ctx, plan, &price); err != nil {
 		var se *stripe.Error
 		if errors.As(err, &se) && (se.HTTPStatusCode == 401 || strings.Contains(strings.ToLower(se.Msg), "invalid api key")) {
 			log.Printf("[STRIPE][ensure] invalid api key (%s): %v", maskKey(s.secretKey), se)
@@ -138,20 +185,31 @@ func (s *StripeService) CreateCheckoutSessionWithID(ctx context.Context, userID,
 		}
 		return "", "", err
 	}
-	_ = s.repo.UpdatePlan(plan.ID, plan)
+	s.persistStripeIDs(plan, &price)
 	params := &stripe.CheckoutSessionParams{
 		SuccessURL: stripe.String(s.successURL),
 		CancelURL:  stripe.String(s.cancelURL),
 		Mode:       stripe.String(string(stripe.CheckoutSessionModeSubscription)),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{{
-			Price:    stripe.String(plan.StripePriceID),
+			Price:    stripe.String(price.StripePriceID),
 			Quantity: stripe.Int64(1),
 		}},
-		Metadata: map[string]string{
-			"user_id": strconv.Itoa(userID),
-			"plan_id": strconv.Itoa(planID),
-			"frequency": strconv.Itoa(frequency),
-		},
+		Metadata: checkoutMetadata(userID, planID, frequency, price, opts),
+	}
+	if opts.Promo != nil {
+		if opts.Promo.PercentOff > 0 || opts.Promo.AmountOff > 0 { // trial-only codes carry no coupon
+			couponID, err := s.ensureStripeCoupon(ctx, opts.Promo)
+			if err != nil { return "", "", err }
+			params.Discounts = []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(couponID)}}
+		}
+	} else if os.Getenv("STRIPE_ALLOW_PROMOTION_CODES") == "1" {
+		// Codes created directly in the Stripe dashboard
+		params.AllowPromotionCodes = stripe.Bool(true)
+	}
+	// Copied to the subscription so its invoices identify the user/plan (payment history)
+	params.SubscriptionData = &stripe.CheckoutSessionSubscriptionDataParams{Metadata: params.Metadata}
+	if opts.TrialDays > 0 {
+		params.SubscriptionData.TrialPeriodDays = stripe.Int64(int64(opts.TrialDays))
 	}
 	if s.invalidKey { return "", "", ErrStripeInvalidAPIKey }
 	sess, err := s.sc.CheckoutSessions.New(params)
@@ -168,6 +226,31 @@ func (s *StripeService) CreateCheckoutSessionWithID(ctx context.Context, userID,
 	return sess.URL, sess.ID, nil
 }
 
+// stripeEventObject is the subset of the webhook data.object fields we read (checkout
+// sessions, subscriptions, invoices and charges share it).
+type stripeEventObject struct {
+	ID                string            `json:"id"`
+	Metadata          map[string]string `json:"metadata"`
+	Subscription      string            `json:"subscription"`
+	Status            string            `json:"status"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	// invoices
+	AmountPaid          int64  `json:"amount_paid"`
+	AmountDue           int64  `json:"amount_due"`
+	Currency            string `json:"currency"`
+	Number              string `json:"number"`
+	InvoicePDF          string `json:"invoice_pdf"`
+	SubscriptionDetails struct {
+		Metadata map[string]string `json:"metadata"`
+	} `json:"subscription_details"`
+	StatusTransitions struct {
+		PaidAt int64 `json:"paid_at"`
+	} `json:"status_transitions"`
+	// charges
+	Invoice string `json:"invoice"`
+}
+
 // HandleWebhook consumes webhook payloads. For a successful checkout event,
 // it creates a subscription record for the user/plan encoded in metadata.
 func (s *StripeService) HandleWebhook(w http.ResponseWriter, r *http.Request) error {
@@ -192,31 +275,31 @@ func (s *StripeService) HandleWebhook(w http.ResponseWriter, r *http.Request) er
 	var event struct {
 		Type string `json:"type"`
 		Data struct {
-			Object struct {
-				Metadata map[string]string `json:"metadata"`
-			} `json:"object"`
+			Object stripeEventObject `json:"object"`
 		} `json:"data"`
 	}
 	if err := json.Unmarshal(payload, &event); err != nil {
 		return err
 	}
 	if event.Type != "checkout.session.completed" {
-		// Ignore other events
+		obj := event.Data.Object
+		handled, err := s.applyLifecycleEvent(event.Type, obj.ID, obj.Subscription, obj.Status, obj.CancelAtPeriodEnd, obj.CurrentPeriodEnd)
+		if err != nil {
+			return err
+		}
+		if s.recordPaymentEvent(event.Type, obj) {
+			handled = true
+		}
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ignored"))
+		if handled {
+			_, _ = w.Write([]byte("ok"))
+		} else { // Ignore other events
+			_, _ = w.Write([]byte("ignored"))
+		}
 		return nil
 	}
-	// Parse metadata
-	uid, _ := strconv.Atoi(event.Data.Object.Metadata["user_id"])
-	pid, _ := strconv.Atoi(event.Data.Object.Metadata["plan_id"])
-	freq, _ := strconv.Atoi(event.Data.Object.Metadata["frequency"])
-	if uid == 0 || pid == 0 {
-		return fmt.Errorf("metadata incompleta")
-	}
 	// Create subscription record initialized with plan quotas
-	now := time.Now()
-	sub := &Subscription{UserID: uid, PlanID: pid, StartDate: now, Frequency: freq}
-	if err := s.repo.CreateSubscription(sub); err != nil {
+	if _, _, err := activateCheckout(s.repo, event.Data.Object.Metadata, GatewayStripe, event.Data.Object.Subscription, false); err != nil {
 		return err
 	}
 	w.WriteHeader(http.StatusOK)
@@ -231,15 +314,216 @@ func (s *StripeService) ConfirmSession(sessionID string) (bool, int, error) {
 	sess, err := s.sc.CheckoutSessions.Get(sessionID, nil)
 	if err != nil { return false, 0, err }
 	if sess.Status != stripe.CheckoutSessionStatusComplete { return false, 0, nil }
-	uid, _ := strconv.Atoi(sess.Metadata["user_id"])
-	pid, _ := strconv.Atoi(sess.Metadata["plan_id"])
-	freq, _ := strconv.Atoi(sess.Metadata["frequency"])
-	if uid == 0 || pid == 0 { return false, 0, errors.New("metadata incompleta") }
 	// If already active with same plan, no new creation
-	sub, _ := s.repo.GetActiveSubscription(uid)
-	if sub != nil && sub.PlanID == pid { return false, sub.ID, nil }
-	now := time.Now()
-	newSub := &Subscription{UserID: uid, PlanID: pid, StartDate: now, Frequency: freq}
-	if err := s.repo.CreateSubscription(newSub); err != nil { return false, 0, err }
-	return true, newSub.ID, nil
+	stripeSubID := ""
+	if sess.Subscription != nil { stripeSubID = sess.Subscription.ID }
+	return activateCheckout(s.repo, sess.Metadata, GatewayStripe, stripeSubID, false)
+}
+
+
+// applyIntervalMetadata copies the billing interval chosen at checkout (session metadata)
+// onto the subscription so quota renewal follows the paid interval.
+func applyIntervalMetadata(sub *Subscription, md map[string]string) {
+	if v := md["interval"]; v != "" {
+		sub.Interval = NormalizeInterval(v)
+		sub.IntervalCount, _ = strconv.Atoi(md["interval_count"])
+	}
+}
+
+// ChangeSubscriptionPrice swaps the price of the single item of a Stripe subscription.
+// prorate=true charges/credits the difference right away (upgrades); prorate=false keeps
+// the current period untouched so the new price applies from the next invoice (downgrades).
+func (s *StripeService) ChangeSubscriptionPrice(ctx context.Context, stripeSubID string, plan *Plan, price *PlanPrice, prorate bool) error {
+	if s == nil { return errors.New("stripe no configurado") }
+	if err := s.ensureStripeProductAndPrice(ctx, plan, price); err != nil { return err }
+	s.persistStripeIDs(plan, price)
+	ss, err := s.sc.Subscriptions.Get(stripeSubID, nil)
+	if err != nil { return err }
+	if ss.Items == nil || len(ss.Items.Data) == 0 { return fmt.Errorf("stripe subscription %s sin items", stripeSubID) }
+	behavior := "none"
+	if prorate { behavior = "always_invoice" }
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{{
+			ID:    stripe.String(ss.Items.Data[0].ID),
+			Price: stripe.String(price.StripePriceID),
+		}},
+		ProrationBehavior: stripe.String(behavior),
+		CancelAtPeriodEnd: stripe.Bool(false),
+		Metadata: map[string]string{
+			"plan_id": strconv.Itoa(plan.ID),
+			"interval": price.Interval,
+		},
+	}
+	params.Context = ctx
+	if _, err := s.sc.Subscriptions.Update(stripeSubID, params); err != nil {
+		log.Printf("[STRIPE][change] update %s failed: %v", stripeSubID, err)
+		return err
+	}
+	return nil
+}
+
+// CancelAtPeriodEnd stops renewal of a Stripe subscription while keeping the paid period.
+func (s *StripeService) CancelAtPeriodEnd(ctx context.Context, stripeSubID string) error {
+	if s == nil { return errors.New("stripe no configurado") }
+	params := &stripe.SubscriptionParams{CancelAtPeriodEnd: stripe.Bool(true)}
+	params.Context = ctx
+	_, err := s.sc.Subscriptions.Update(stripeSubID, params)
+	return err
+}
+
+// applyPromoMetadata copies the promo code and trial length chosen at checkout.
+func applyPromoMetadata(sub *Subscription, md map[string]string, now time.Time) {
+	sub.PromoCode = md["promo_code"]
+	if days, _ := strconv.Atoi(md["trial_days"]); days > 0 {
+		end := now.AddDate(0, 0, days)
+		sub.TrialEndsAt = &end
+	}
+}
+
+// ensureStripeCoupon mirrors a local promo code as a Stripe coupon (created once, id stored).
+func (s *StripeService) ensureStripeCoupon(ctx context.Context, pc *PromoCode) (string, error) {
+	if pc.StripeCouponID != "" { return pc.StripeCouponID, nil }
+	params := &stripe.CouponParams{
+		Name:     stripe.String(pc.Code),
+		Duration: stripe.String(pc.Duration),
+		Metadata: map[string]string{"promo_code_id": strconv.Itoa(pc.ID)},
+	}
+	if pc.Duration == "repeating" && pc.DurationInMonths > 0 {
+		params.DurationInMonths = stripe.Int64(int64(pc.DurationInMonths))
+	}
+	if pc.PercentOff > 0 {
+		params.PercentOff = stripe.Float64(pc.PercentOff)
+	} else if pc.AmountOff > 0 {
+		params.AmountOff = stripe.Int64(toStripeAmount(pc.AmountOff, pc.Currency))
+		params.Currency = stripe.String(strings.ToLower(pc.Currency))
+	} else {
+		return "", fmt.Errorf("promo %s sin descuento", pc.Code)
+	}
+	params.Context = ctx
+	cp, err := s.sc.Coupons.New(params)
+	if err != nil { return "", err }
+	pc.StripeCouponID = cp.ID
+	if err := s.repo.SetPromoStripeCouponID(pc.ID, cp.ID); err != nil {
+		log.Printf("[STRIPE][promo] persist coupon id code=%s failed: %v", pc.Code, err)
+	}
+	return cp.ID, nil
+}
+
+// lifecycleTransition maps a Stripe billing event to the local status change:
+// failed invoices put the subscription past_due, paid invoices reactivate it, a
+// cancellation scheduled at period end sets end_date and a deleted subscription expires.
+// clearEndDate is set when an active subscription is no longer scheduled to cancel (the
+// user undid "cancel at period end"), so the old end_date must not expire it.
+// ok=false means the event doesn't affect the subscription status.
+func lifecycleTransition(eventType, objectID, invoiceSubID, status string, cancelAtPeriodEnd bool, periodEnd int64, now time.Time) (stripeSubID, newStatus string, endDate *time.Time, clearEndDate, ok bool) {
+	switch eventType {
+	case "invoice.payment_failed":
+		stripeSubID, newStatus = invoiceSubID, StatusPastDue
+	case "invoice.paid", "invoice.payment_succeeded":
+		stripeSubID, newStatus = invoiceSubID, StatusActive
+	case "customer.subscription.deleted":
+		stripeSubID, newStatus, endDate = objectID, StatusExpired, &now
+	case "customer.subscription.updated":
+		stripeSubID = objectID
+		switch {
+		case cancelAtPeriodEnd && periodEnd > 0:
+			end := time.Unix(periodEnd, 0)
+			newStatus, endDate = StatusCanceled, &end
+		case status == "past_due" || status == "unpaid":
+			newStatus = StatusPastDue
+		case status == "active" || status == "trialing":
+			newStatus, clearEndDate = StatusActive, !cancelAtPeriodEnd
+		default:
+			return "", "", nil, false, false
+		}
+	default:
+		return "", "", nil, false, false
+	}
+	return stripeSubID, newStatus, endDate, clearEndDate, stripeSubID != ""
+}
+
+// applyLifecycleEvent keeps the local subscription status in sync with Stripe billing events.
+func (s *StripeService) applyLifecycleEvent(eventType, objectID, invoiceSubID, status string, cancelAtPeriodEnd bool, periodEnd int64) (bool, error) {
+	stripeSubID, newStatus, endDate, clearEndDate, ok := lifecycleTransition(eventType, objectID, invoiceSubID, status, cancelAtPeriodEnd, periodEnd, time.Now())
+	if !ok {
+		return false, nil
+	}
+	id, err := s.repo.SetStatusByStripeID(stripeSubID, newStatus, endDate, clearEndDate)
+	if err != nil {
+		return false, err
+	}
+	if id != 0 {
+		log.Printf("[STRIPE][webhook] %s stripe_sub=%s sub=%d status=%s", eventType, stripeSubID, id, newStatus)
+		if newStatus == StatusExpired {
+			if uid := s.userOfSubscription(id); uid != 0 {
+				if err := migrations.EnsureFreeSubscriptionForUser(uid); err != nil {
+					log.Printf("[STRIPE][webhook] free fallback user=%d failed: %v", uid, err)
+				}
+			}
+		}
+	}
+	return id != 0, nil
+}
+
+// stripeInvoicePayment maps an invoice event to a payment row. ok=false for other events
+// and for zero-amount invoices (trials, 100% coupons).
+func stripeInvoicePayment(eventType string, obj stripeEventObject) (*Payment, bool) {
+	p := &Payment{Gateway: GatewayStripe, GatewayPaymentID: obj.ID, Currency: strings.ToUpper(obj.Currency), InvoiceNumber: obj.Number, InvoicePDFURL: obj.InvoicePDF, PaymentMethod: "card"}
+	switch eventType {
+	case "invoice.paid", "invoice.payment_succeeded":
+		p.Status, p.Amount = PaymentPaid, fromStripeAmount(obj.AmountPaid, obj.Currency)
+		if obj.StatusTransitions.PaidAt > 0 {
+			t := time.Unix(obj.StatusTransitions.PaidAt, 0)
+			p.PaidAt = &t
+		}
+	case "invoice.payment_failed":
+		p.Status, p.Amount = PaymentFailed, fromStripeAmount(obj.AmountDue, obj.Currency)
+	default:
+		return nil, false
+	}
+	if p.Amount == 0 || obj.ID == "" {
+		return nil, false
+	}
+	paymentFromMetadata(p, obj.SubscriptionDetails.Metadata, 0)
+	return p, true
+}
+
+// recordPaymentEvent keeps the payments history in sync with invoice and refund events.
+func (s *StripeService) recordPaymentEvent(eventType string, obj stripeEventObject) bool {
+	if eventType == "charge.refunded" && obj.Invoice != "" {
+		ok, err := s.repo.SetPaymentStatus(GatewayStripe, obj.Invoice, PaymentRefunded)
+		if err != nil {
+			log.Printf("[STRIPE][webhook] refund invoice=%s failed: %v", obj.Invoice, err)
+		}
+		return ok
+	}
+	p, ok := stripeInvoicePayment(eventType, obj)
+	if !ok {
+		return false
+	}
+	if obj.Subscription != "" {
+		if sub, _ := s.repo.GetSubscriptionByStripeID(obj.Subscription); sub != nil {
+			p.UserID = sub.UserID
+			p.SubscriptionID, p.PlanID = &sub.ID, &sub.PlanID
+		}
+	}
+	recordPayment(s.repo, p)
+	return true
+}
+
+func (s *StripeService) userOfSubscription(id int) int {
+	sub, err := s.repo.GetSubscriptionByID(id)
+	if err != nil || sub == nil {
+		return 0
+	}
+	return sub.UserID
+}
+
+// CancelNow cancels a Stripe subscription immediately (no further invoices).
+func (s *StripeService) CancelNow(ctx context.Context, stripeSubID string) error {
+	if s == nil { return errors.New("stripe no configurado") }
+	params := &stripe.SubscriptionCancelParams{}
+	params.Context = ctx
+	_, err := s.sc.Subscriptions.Cancel(stripeSubID, params)
+	return err
 }
@@ -0,0 +1,57 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLifecycleTransition(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		event, objectID, invoiceSub, status string
+		cancelAtPeriodEnd                   bool
+		periodEnd                           int64
+		wantSub, wantStatus                 string
+		wantEnd, wantOK                     bool
+	}{
+		{"invoice.payment_failed", "in_1", "sub_1", "", false, 0, "sub_1", StatusPastDue, false, true},
+		{"invoice.paid", "in_1", "sub_1", "", false, 0, "sub_1", StatusActive, false, true},
+		{"customer.subscription.deleted", "sub_2", "", "canceled", false, 0, "sub_2", StatusExpired, true, true},
+		{"customer.subscription.updated", "sub_3", "", "active", true, now.Add(48 * time.Hour).Unix(), "sub_3", StatusCanceled, true, true},
+		{"customer.subscription.updated", "sub_3", "", "unpaid", false, 0, "sub_3", StatusPastDue, false, true},
+		{"customer.subscription.updated", "sub_3", "", "incomplete", false, 0, "", "", false, false},
+		{"invoice.payment_failed", "in_2", "", "", false, 0, "", StatusPastDue, false, false}, // one-off invoice
+		{"charge.refunded", "ch_1", "", "", false, 0, "", "", false, false},
+	}
+	for _, tc := range cases {
+		sub, status, end, _, ok := lifecycleTransition(tc.event, tc.objectID, tc.invoiceSub, tc.status, tc.cancelAtPeriodEnd, tc.periodEnd, now)
+		if ok != tc.wantOK || sub != tc.wantSub || (ok && status != tc.wantStatus) || (end != nil) != tc.wantEnd {
+			t.Errorf("%s/%s: got sub=%q status=%q end=%v ok=%v", tc.event, tc.status, sub, status, end, ok)
+		}
+	}
+}
+
+func TestLifecycleTransition_CancelThenReactivate(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	periodEnd := now.Add(20 * 24 * time.Hour).Unix()
+
+	_, status, end, clear, ok := lifecycleTransition("customer.subscription.updated", "sub_1", "", "active", true, periodEnd, now)
+	if !ok || status != StatusCanceled || end == nil || end.Unix() != periodEnd || clear {
+		t.Fatalf("cancel at period end: status=%q end=%v clear=%v ok=%v", status, end, clear, ok)
+	}
+	// Undoing the cancellation (or ChangeSubscriptionPrice with CancelAtPeriodEnd=false)
+	// must drop the end_date, or ExpireLapsedSubscriptions expires a paying subscription.
+	for _, st := range []string{"active", "trialing"} {
+		_, status, end, clear, ok = lifecycleTransition("customer.subscription.updated", "sub_1", "", st, false, periodEnd, now)
+		if !ok || status != StatusActive || end != nil || !clear {
+			t.Fatalf("reactivate (%s): status=%q end=%v clear=%v ok=%v", st, status, end, clear, ok)
+		}
+	}
+	// Paid invoices and past_due updates leave end_date alone.
+	if _, _, _, clear, _ := lifecycleTransition("invoice.paid", "in_1", "sub_1", "", false, 0, now); clear {
+		t.Fatal("invoice.paid clears end_date")
+	}
+	if _, _, _, clear, _ := lifecycleTransition("customer.subscription.updated", "sub_1", "", "past_due", false, 0, now); clear {
+		t.Fatal("past_due clears end_date")
+	}
+}
@@ -0,0 +1,241 @@
+package subscriptions
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlanVersion is an immutable snapshot of what a plan grants. Subscriptions are pinned to
+// the version they bought (subscriptions.plan_version_id); editing the entitlements of a
+// plan creates a new version for new buyers instead of changing existing subscribers.
+type PlanVersion struct {
+	ID                 int            `json:"id"`
+	PlanID             int            `json:"plan_id"`
+	Version            int            `json:"version"`
+	Currency           string         `json:"currency"`
+	Price              float64        `json:"price"`
+	Interval           string         `json:"interval"`
+	IntervalCount      int            `json:"interval_count"`
+	Consultations      int            `json:"consultations"`
+	Questionnaires     int            `json:"questionnaires"`
+	ClinicalCases      int            `json:"clinical_cases"`
+	Files              int            `json:"files"`
+	Statistics         int            `json:"statistics"`
+	TrialDays          int            `json:"trial_days"`
+	Entitlements       map[string]int `json:"entitlements"`        // effective feature values of the version
+	GrandfatheredUntil *time.Time     `json:"grandfathered_until"` // nil = subscribers keep this version until they change plan
+	CreatedAt          time.Time      `json:"created_at"`
+	Current            bool           `json:"current"`
+	Subscribers        int            `json:"subscribers"` // active subscriptions pinned to this version
+}
+
+// limits returns the version entitlements as a Plan, for ApplyQuotaRule.
+func (v *PlanVersion) limits() *Plan {
+	return &Plan{ID: v.PlanID, Consultations: v.Consultations, Questionnaires: v.Questionnaires, ClinicalCases: v.ClinicalCases, Files: v.Files}
+}
+
+// Grandfathering rules for the subscribers of superseded versions (PLAN_GRANDFATHERING).
+const (
+	// GrandfatherForever keeps subscribers on their version until they change plan. Default.
+	GrandfatherForever = "forever"
+	// GrandfatherRenewal moves subscribers to the current version at their next renewal.
+	GrandfatherRenewal = "renewal"
+)
+
+var errInvalidGrandfathering = errors.New("grandfathering inválido (forever | renewal)")
+
+func grandfatheringFromEnv() string {
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("PLAN_GRANDFATHERING"))); v == GrandfatherRenewal {
+		return v
+	}
+	return GrandfatherForever
+}
+
+// grandfatherUntil resolves the date after which renewals move subscribers of older versions
+// to the current one: an explicit date wins, "renewal" means from now, "forever" never (nil).
+func grandfatherUntil(rule string, until *time.Time, now time.Time) (*time.Time, error) {
+	if until != nil {
+		return until, nil
+	}
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	if rule == "" {
+		rule = grandfatheringFromEnv()
+	}
+	switch rule {
+	case GrandfatherForever:
+		return nil, nil
+	case GrandfatherRenewal:
+		return &now, nil
+	}
+	return nil, errInvalidGrandfathering
+}
+
+// planVersionChanged reports whether an edit changes what subscribers get (limits, base price,
+// interval, trial or feature entitlements) and therefore needs a new version. Name/label
+// edits don't.
+func planVersionChanged(old, updated *Plan) bool {
+	return !strings.EqualFold(old.Currency, updated.Currency) || old.Price != updated.Price ||
+		NormalizeInterval(old.Interval) != NormalizeInterval(updated.Interval) || max(old.IntervalCount, 1) != max(updated.IntervalCount, 1) ||
+		old.Consultations != updated.Consultations || old.Questionnaires != updated.Questionnaires ||
+		old.ClinicalCases != updated.ClinicalCases || old.Files != updated.Files || old.TrialDays != updated.TrialDays ||
+		!sameEntitlements(old, updated)
+}
+
+// CohortMigration is the outcome of moving the subscribers of one version to another.
+type CohortMigration struct {
+	PlanID      int    `json:"plan_id"`
+	FromVersion int    `json:"from_version"`
+	ToVersion   int    `json:"to_version"`
+	QuotaRule   string `json:"quota_rule"`
+	DryRun      bool   `json:"dry_run"`
+	Matched     int    `json:"matched"`
+	Migrated    int    `json:"migrated"`
+}
+
+// MigrateVersionCohort moves the active subscribers of version from to version to of the
+// same plan right away. Remaining quotas follow rule (carryover by default: usage of the
+// current period is kept). Only entitlements move: what the payment gateway charges is not
+// touched (a Stripe subscription keeps billing its price until the user changes plan).
+func (h *Handler) MigrateVersionCohort(planID, from, to int, rule string, dryRun bool) (*CohortMigration, error) {
+	switch rule {
+	case "":
+		rule = QuotaRuleCarryOver
+	case QuotaRuleReset, QuotaRuleCarryOver, QuotaRuleProrate:
+	default:
+		return nil, errors.New("quota_rule inválido")
+	}
+	src, err := h.repo.GetPlanVersion(planID, from)
+	if err != nil {
+		return nil, err
+	}
+	var dst *PlanVersion
+	if to == 0 {
+		dst, err = h.repo.GetCurrentPlanVersion(planID)
+	} else {
+		dst, err = h.repo.GetPlanVersion(planID, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src == nil || dst == nil {
+		return nil, errors.New("versión no encontrada")
+	}
+	res := &CohortMigration{PlanID: planID, FromVersion: src.Version, ToVersion: dst.Version, QuotaRule: rule, DryRun: dryRun}
+	if src.ID == dst.ID {
+		return res, nil
+	}
+	subs, err := h.repo.GetVersionSubscribers(src.ID)
+	if err != nil {
+		return nil, err
+	}
+	res.Matched = len(subs)
+	if dryRun {
+		return res, nil
+	}
+	now := time.Now()
+	for i := range subs {
+		sub := &subs[i]
+		remaining := Quotas{Consultations: sub.Consultations, Questionnaires: sub.Questionnaires, ClinicalCases: sub.ClinicalCases, Files: sub.Files}
+		q := ApplyQuotaRule(rule, src.limits(), dst.limits(), remaining, periodFractionLeft(sub, now))
+		moved, err := h.repo.MoveSubscriptionToVersion(sub.ID, src.ID, dst.ID, q)
+		if err != nil {
+			return res, err
+		}
+		if moved {
+			res.Migrated++
+		}
+	}
+	log.Printf("[SUBSCRIPTIONS][VERSIONS] cohort plan=%d v%d -> v%d rule=%s migrated=%d/%d", planID, src.Version, dst.Version, rule, res.Migrated, res.Matched)
+	return res, nil
+}
+
+// getPlanVersions handles GET /plans/:id/versions
+func (h *Handler) getPlanVersions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	versions, err := h.repo.GetPlanVersions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": versions})
+}
+
+// setVersionGrandfathering handles PUT /plans/:id/versions/:version/grandfathering with body
+// { grandfathering: forever|renewal, grandfathered_until? }
+func (h *Handler) setVersionGrandfathering(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "versión inválida"})
+		return
+	}
+	var body struct {
+		Grandfathering     string     `json:"grandfathering"`
+		GrandfatheredUntil *time.Time `json:"grandfathered_until"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "datos inválidos"})
+		return
+	}
+	until, err := grandfatherUntil(body.Grandfathering, body.GrandfatheredUntil, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	v, err := h.repo.GetPlanVersion(id, number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if v == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "versión no encontrada"})
+		return
+	}
+	if err := h.repo.SetGrandfatheredUntil(v.ID, until); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	v.GrandfatheredUntil = until
+	c.JSON(http.StatusOK, v)
+}
+
+// migrateVersionCohort handles POST /plans/:id/versions/migrate with body
+// { from_version, to_version? (default current), quota_rule?, dry_run? }
+func (h *Handler) migrateVersionCohort(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+	var body struct {
+		FromVersion int    `json:"from_version"`
+		ToVersion   int    `json:"to_version"`
+		QuotaRule   string `json:"quota_rule"`
+		DryRun      bool   `json:"dry_run"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.FromVersion <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_version requerido"})
+		return
+	}
+	res, err := h.MigrateVersionCohort(id, body.FromVersion, body.ToVersion, body.QuotaRule, body.DryRun)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
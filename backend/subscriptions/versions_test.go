@@ -0,0 +1,59 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlanVersionChanged(t *testing.T) {
+	old := &Plan{Name: "Pro", Currency: "USD", Price: 9.99, Billing: "Mensual", Interval: IntervalMonth, IntervalCount: 1, Consultations: 30, Questionnaires: 50, ClinicalCases: 25, Files: 100}
+	same := *old
+	same.Name, same.Billing, same.Currency, same.IntervalCount = "Pro+", "Mensual (IVA incl.)", "usd", 0
+	if planVersionChanged(old, &same) {
+		t.Error("label edits must not create a version")
+	}
+	for name, edit := range map[string]func(p *Plan){
+		"limits":   func(p *Plan) { p.Consultations = 20 },
+		"price":    func(p *Plan) { p.Price = 12.99 },
+		"interval": func(p *Plan) { p.Interval = IntervalYear },
+		"trial":    func(p *Plan) { p.TrialDays = 7 },
+	} {
+		updated := *old
+		edit(&updated)
+		if !planVersionChanged(old, &updated) {
+			t.Errorf("%s change not detected", name)
+		}
+	}
+}
+
+func TestGrandfatherUntil(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	t.Setenv("PLAN_GRANDFATHERING", "")
+	if got, err := grandfatherUntil("", nil, now); err != nil || got != nil {
+		t.Errorf("default: got %v err=%v", got, err)
+	}
+	if got, err := grandfatherUntil("renewal", nil, now); err != nil || got == nil || !got.Equal(now) {
+		t.Errorf("renewal: got %v err=%v", got, err)
+	}
+	until := now.AddDate(0, 6, 0)
+	if got, _ := grandfatherUntil("forever", &until, now); got == nil || !got.Equal(until) {
+		t.Errorf("explicit date: got %v", got)
+	}
+	if _, err := grandfatherUntil("never", nil, now); err == nil {
+		t.Error("invalid rule accepted")
+	}
+	t.Setenv("PLAN_GRANDFATHERING", "renewal")
+	if got, _ := grandfatherUntil("", nil, now); got == nil {
+		t.Error("env default ignored")
+	}
+}
+
+func TestCohortQuotasCarryOver(t *testing.T) {
+	from := &PlanVersion{Consultations: 30, Questionnaires: 50, ClinicalCases: 25, Files: 100}
+	to := &PlanVersion{Consultations: 20, Questionnaires: 80, ClinicalCases: 25, Files: 99999}
+	q := ApplyQuotaRule(QuotaRuleCarryOver, from.limits(), to.limits(), Quotas{Consultations: 5, Questionnaires: 10, ClinicalCases: 3, Files: 40}, 0.5)
+	want := Quotas{Consultations: 0, Questionnaires: 40, ClinicalCases: 3, Files: 99999}
+	if q != want {
+		t.Fatalf("got %+v want %+v", q, want)
+	}
+}
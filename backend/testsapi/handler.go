@@ -13,6 +13,8 @@ import (
 	"strings"
 	"time"
 
+	"ema-backend/entitlements"
+	"ema-backend/knowledge"
 	"ema-backend/openai"
 
 	"github.com/gin-gonic/gin"
@@ -120,6 +122,7 @@ func (h *Handler) generate(c *gin.Context) {
 			c.Header("X-Quota-Remaining", toString(v))
 		}
 	}
+	entitlements.Attach(c)
 	var req generateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
@@ -138,10 +141,8 @@ func (h *Handler) generate(c *gin.Context) {
 	}
 
 	// PASO 1: Realizar búsquedas en libros y PubMed para basar las preguntas en fuentes confiables
-	vectorID := os.Getenv("CUESTIONARIOS_VECTOR_STORE_ID")
-	if vectorID == "" {
-		vectorID = "vs_680fc484cef081918b2b9588b701e2f4" // vector por defecto de cuestionarios médicos
-	}
+	// Base de conocimiento de cuestionarios para las categorías pedidas (p. ej. cardiología)
+	vectorID := knowledge.VectorStoreFor(knowledge.FlowQuiz, req.IdCategoria...)
 
 	// Construir query de búsqueda basada en categorías o medicina interna
 	searchQuery := "medicina interna"
@@ -172,7 +173,7 @@ func (h *Handler) generate(c *gin.Context) {
 	pubmedContext := ""
 	if extClient, ok := h.ai.(interface {
 		SearchPubMed(ctx context.Context, query string) (string, error)
-	}); ok {
+	}); ok && entitlements.Allowed(ctx, entitlements.PubMedSearch) {
 		if result, err := extClient.SearchPubMed(ctx, searchQuery); err == nil {
 			pubmedContext = strings.TrimSpace(result)
 			log.Printf("[testsapi.generate] pubmed search OK: content_len=%d", len(pubmedContext))
@@ -234,6 +235,9 @@ func (h *Handler) generate(c *gin.Context) {
 
 	threadID, err := h.ai.CreateThreadOrConversation(ctx)
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant thread error"})
 		return
 	}
@@ -264,6 +268,9 @@ func (h *Handler) generate(c *gin.Context) {
 	jsonInstr := strings.Join(instr, " ")
 	ch, err := h.ai.StreamAssistantJSONCompatible(ctx, threadID, sb.String(), jsonInstr, vectorID)
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant run error"})
 		return
 	}
@@ -327,6 +334,7 @@ func (h *Handler) generate(c *gin.Context) {
 }
 
 func (h *Handler) evaluate(c *gin.Context) {
+	entitlements.Attach(c)
 	var req evaluateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
@@ -337,10 +345,7 @@ func (h *Handler) evaluate(c *gin.Context) {
 	defer cancel()
 
 	// PASO 1: Buscar en fuentes médicas para fundamentar la evaluación
-	vectorID := os.Getenv("CUESTIONARIOS_VECTOR_STORE_ID")
-	if vectorID == "" {
-		vectorID = "vs_680fc484cef081918b2b9588b701e2f4"
-	}
+	vectorID := knowledge.VectorStoreFor(knowledge.FlowQuiz)
 
 	// Construir query basada en las respuestas del usuario para buscar contexto relevante
 	searchQuery := "evaluación médica"
@@ -372,7 +377,7 @@ func (h *Handler) evaluate(c *gin.Context) {
 	pubmedContext := ""
 	if extClient, ok := h.ai.(interface {
 		SearchPubMed(ctx context.Context, query string) (string, error)
-	}); ok {
+	}); ok && entitlements.Allowed(ctx, entitlements.PubMedSearch) {
 		if result, err := extClient.SearchPubMed(ctx, searchQuery); err == nil {
 			pubmedContext = strings.TrimSpace(result)
 			log.Printf("[testsapi.evaluate] pubmed search OK: content_len=%d", len(pubmedContext))
@@ -429,6 +434,9 @@ func (h *Handler) evaluate(c *gin.Context) {
 		var err error
 		threadID, err = h.ai.CreateThreadOrConversation(ctx)
 		if err != nil {
+			if openai.RespondUnavailable(c, err) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant thread error"})
 			return
 		}
@@ -468,6 +476,9 @@ func (h *Handler) evaluate(c *gin.Context) {
 	}, " ")
 	ch, err := h.ai.StreamAssistantJSONCompatible(ctx, threadID, sb.String(), evalInstr, vectorID)
 	if err != nil {
+		if openai.RespondUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "assistant run error"})
 		return
 	}
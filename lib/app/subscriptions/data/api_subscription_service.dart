@@ -188,25 +188,6 @@ class ApiSubscriptionService extends SubscriptionService {
       autoSubscribed: data['auto_subscribed'] == true,
     );
   }
-
-  // Dev helper: force subscription without waiting for webhook (APP_ENV=dev in backend)
-  Future<bool> forceSubscribe({
-    required int planId,
-    required int frequency,
-    required String authToken,
-  }) async {
-    final url = Uri.parse('$apiUrl/dev/force-subscribe');
-    final resp = await http.post(
-      url,
-      headers: {
-        'Content-Type': 'application/json',
-        'Authorization': 'Bearer $authToken',
-      },
-      body: jsonEncode({'plan_id': planId, 'frequency': frequency}),
-    );
-    if (resp.statusCode == 200) return true;
-    return false;
-  }
 }
 
 class _CheckoutResult {